@@ -0,0 +1,169 @@
+// Package apierror writes the JSON error envelope returned by every bridge API
+// endpoint:
+//
+//	{"success": false, "error": {"code": "invalid_time", "message": "...", "request_id": "..."}}
+//
+// Clients should branch on the code; messages are for humans and may change.
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Error codes. New codes may be added; existing ones keep their meaning.
+const (
+	// CodeInvalidRequest means the request body or parameters failed validation
+	CodeInvalidRequest = "invalid_request"
+	// CodeInvalidTime means a timestamp is malformed or out of range
+	CodeInvalidTime = "invalid_time"
+	// CodeUnauthorized means the API key is missing or wrong
+	CodeUnauthorized = "unauthorized"
+	// CodeForbidden means the client address is not in the IP allowlist
+	CodeForbidden = "forbidden"
+	// CodeInsufficientScope means the API key is valid but lacks the scope the route needs
+	CodeInsufficientScope = "insufficient_scope"
+	// CodeNotFound means the route or the addressed resource doesn't exist
+	CodeNotFound = "not_found"
+	// CodeUnsupportedVersion means the requested API version doesn't exist
+	CodeUnsupportedVersion = "unsupported_version"
+	// CodeMethodNotAllowed means the route exists but not for this method
+	CodeMethodNotAllowed = "method_not_allowed"
+	// CodeConflict means the resource is in a state that doesn't allow the change
+	CodeConflict = "conflict"
+	// CodeTooLarge means the request body is over the endpoint's size limit
+	CodeTooLarge = "too_large"
+	// CodeRateLimited means the client sent too many requests; see Retry-After
+	CodeRateLimited = "rate_limited"
+	// CodeWhatsAppUnavailable means the bridge is not connected to WhatsApp
+	CodeWhatsAppUnavailable = "whatsapp_unavailable"
+	// CodeWhatsAppError means WhatsApp rejected or failed the request
+	CodeWhatsAppError = "whatsapp_error"
+	// CodeInternal means the bridge itself failed, for example a database error
+	CodeInternal = "internal_error"
+)
+
+// RequestIDHeader carries the ID of each request. Write copies it from the
+// response headers into the error body, so a failure can be found in the logs.
+const RequestIDHeader = "X-Request-ID"
+
+// Body is the error object inside the envelope
+type Body struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+	// Fields lists every failing request field of an invalid_request or
+	// invalid_time error, when the bridge could tell them apart
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError is one request field that failed validation
+type FieldError struct {
+	// Field is the JSON name of the field, dotted for nested ones
+	Field string `json:"field"`
+	// Code is a short reason like "required" or "invalid_format"
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Envelope is the full error response
+type Envelope struct {
+	Success bool `json:"success"`
+	Error   Body `json:"error"`
+}
+
+// Write sends an error response with the given status, code and message
+func Write(w http.ResponseWriter, status int, code, message string) {
+	h := w.Header()
+	h.Set("Content-Type", "application/json")
+	h.Del("Content-Length")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Envelope{Error: Body{Code: code, Message: message, RequestID: h.Get(RequestIDHeader)}})
+}
+
+// Invalid sends a 400 listing the failing fields. The code is invalid_time when
+// only times are wrong, as a single bad time has always been reported, and
+// invalid_request otherwise.
+func Invalid(w http.ResponseWriter, fields []FieldError) {
+	code := CodeInvalidTime
+	messages := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if f.Code != CodeInvalidTime {
+			code = CodeInvalidRequest
+		}
+		messages = append(messages, f.Field+": "+f.Message)
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "application/json")
+	h.Del("Content-Length")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(Envelope{Error: Body{
+		Code:      code,
+		Message:   "Invalid request: " + strings.Join(messages, "; "),
+		RequestID: h.Get(RequestIDHeader),
+		Fields:    fields,
+	}})
+}
+
+// BadRequest sends a 400 invalid_request error
+func BadRequest(w http.ResponseWriter, message string) {
+	Write(w, http.StatusBadRequest, CodeInvalidRequest, message)
+}
+
+// NotFound sends a 404 not_found error
+func NotFound(w http.ResponseWriter, message string) {
+	Write(w, http.StatusNotFound, CodeNotFound, message)
+}
+
+// Conflict sends a 409 conflict error
+func Conflict(w http.ResponseWriter, message string) {
+	Write(w, http.StatusConflict, CodeConflict, message)
+}
+
+// Internal sends a 500 internal_error. Log the cause before calling it; the
+// message is shown to clients, so it shouldn't leak internal details.
+func Internal(w http.ResponseWriter, message string) {
+	Write(w, http.StatusInternalServerError, CodeInternal, message)
+}
+
+// Wrap converts the plain-text 404 and 405 responses that http.ServeMux writes
+// for unknown routes and methods into the JSON envelope
+func Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&muxErrorWriter{ResponseWriter: w}, r)
+	})
+}
+
+// muxErrorWriter rewrites plain-text 404/405 responses and drops their body
+type muxErrorWriter struct {
+	http.ResponseWriter
+	rewritten bool
+}
+
+func (w *muxErrorWriter) WriteHeader(status int) {
+	plainText := strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain")
+	switch {
+	case status == http.StatusNotFound && plainText:
+		w.rewritten = true
+		Write(w.ResponseWriter, status, CodeNotFound, "No such endpoint")
+	case status == http.StatusMethodNotAllowed && plainText:
+		w.rewritten = true
+		Write(w.ResponseWriter, status, CodeMethodNotAllowed, "Method not allowed")
+	default:
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *muxErrorWriter) Write(b []byte) (int, error) {
+	if w.rewritten {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *muxErrorWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
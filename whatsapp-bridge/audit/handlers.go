@@ -0,0 +1,96 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/pagination"
+)
+
+// SetupHandlers returns the HTTP handler serving the audit log under /v1/audit
+func SetupHandlers(store *Store) http.Handler {
+	mux := http.NewServeMux()
+
+	// GET /v1/audit - Recorded calls, latest first, a page at a time
+	mux.HandleFunc("GET /v1/audit", func(w http.ResponseWriter, r *http.Request) {
+		page, err := pagination.FromRequest(r)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+		filter, err := filterFromQuery(r)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+		var cursor Cursor
+		if ok, err := page.Decode(&cursor); err != nil {
+			apierror.BadRequest(w, "Invalid cursor")
+			return
+		} else if ok {
+			filter.After = &cursor
+		}
+		filter.Limit = page.Limit + 1
+
+		entries, err := store.List(r.Context(), filter)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error listing audit log", "error", err)
+			apierror.Internal(w, "Failed to list audit log")
+			return
+		}
+		entries, next := pagination.Page(entries, page, func(e *Entry) interface{} {
+			return Cursor{Time: e.Time, ID: e.ID}
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":     true,
+			"entries":     entries,
+			"next_cursor": next,
+		})
+	})
+
+	return apierror.Wrap(mux)
+}
+
+// filterFromQuery reads the actor, method, endpoint, resource_id, result,
+// since and until filters
+func filterFromQuery(r *http.Request) (Filter, error) {
+	query := r.URL.Query()
+	filter := Filter{
+		Actor:          query.Get("actor"),
+		Method:         strings.ToUpper(query.Get("method")),
+		EndpointPrefix: query.Get("endpoint"),
+		ResourceID:     query.Get("resource_id"),
+	}
+	// Legacy /api/ paths are recorded under /v1/
+	if rest, ok := strings.CutPrefix(filter.EndpointPrefix, "/api/"); ok {
+		filter.EndpointPrefix = "/v1/" + rest
+	}
+
+	switch result := query.Get("result"); result {
+	case "":
+	case "success", "failure":
+		failed := result == "failure"
+		filter.Failed = &failed
+	default:
+		return filter, errors.New("result must be success or failure")
+	}
+
+	for name, target := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := query.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be an RFC 3339 time, e.g. 2025-10-06T15:00:00Z", name)
+			}
+			*target = t
+		}
+	}
+	return filter, nil
+}
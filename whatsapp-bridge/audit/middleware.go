@@ -0,0 +1,238 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/logging"
+)
+
+const (
+	// maxSummaryBody is how much of a request body is read for its summary.
+	// Larger bodies are summarized by type and size only.
+	maxSummaryBody = 64 << 10
+	// maxSummaryString is the length strings are cut to in a summary
+	maxSummaryString = 80
+	// maxSummaryItems is how many array items a summary keeps
+	maxSummaryItems = 10
+	// maxSummaryDepth is how deep a summary follows nested objects and arrays
+	maxSummaryDepth = 3
+	// maxErrorBody is how much of an error response is kept to read its code
+	maxErrorBody = 4 << 10
+)
+
+// redactedValue replaces secrets in payload summaries
+const redactedValue = "[redacted]"
+
+// mutatingMethods are the methods recorded
+var mutatingMethods = map[string]bool{
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+// Middleware records every POST, PUT, PATCH and DELETE to a /v1/ route in
+// store. It goes inside authentication, so actor can name the caller from what
+// authentication attached to the request. A call that can't be recorded is
+// logged and still served.
+func Middleware(store *Store, actor func(r *http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !mutatingMethods[r.Method] || !strings.HasPrefix(r.URL.Path, "/v1/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Read the start of the body for the summary and put it back for next
+		var body []byte
+		if r.Body != nil && r.Body != http.NoBody {
+			body, _ = io.ReadAll(io.LimitReader(r.Body, maxSummaryBody+1))
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		}
+
+		notes := &annotations{}
+		rec := &recorder{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), annotationsKey{}, notes)))
+
+		entry := &Entry{
+			ID:         uuid.New().String(),
+			Time:       start,
+			Actor:      actor(r),
+			RemoteIP:   remoteIP(r),
+			Method:     r.Method,
+			Endpoint:   r.URL.Path,
+			ResourceID: notes.resource,
+			Payload:    Summarize(r.Header.Get("Content-Type"), body, r.ContentLength),
+			Status:     rec.statusCode(),
+			ErrorCode:  rec.errorCode(),
+			RequestID:  logging.RequestID(r.Context()),
+			Duration:   time.Since(start).Milliseconds(),
+		}
+		// Record the call even if the client has gone away in the meantime
+		if err := store.Record(context.WithoutCancel(r.Context()), entry); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to record API call in the audit log", "endpoint", entry.Endpoint, "error", err)
+		}
+	})
+}
+
+// annotationsKey is the request context key holding the call's *annotations
+type annotationsKey struct{}
+
+// annotations are what handlers add to the call's entry
+type annotations struct {
+	resource string
+}
+
+// SetResource records the ID of the message or delivery a call created or
+// changed in its audit entry. It does nothing outside a recorded call.
+func SetResource(ctx context.Context, id string) {
+	if notes, ok := ctx.Value(annotationsKey{}).(*annotations); ok {
+		notes.resource = id
+	}
+}
+
+// recorder remembers the status of the response and the start of an error body
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   []byte
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 && status >= http.StatusOK {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if rec.status >= http.StatusBadRequest && len(rec.body) < maxErrorBody {
+		rec.body = append(rec.body, p[:min(len(p), maxErrorBody-len(rec.body))]...)
+	}
+	return rec.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rec *recorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+func (rec *recorder) statusCode() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
+
+// errorCode reads the code from an apierror envelope, if the call failed
+func (rec *recorder) errorCode() string {
+	if len(rec.body) == 0 {
+		return ""
+	}
+	var envelope apierror.Envelope
+	if err := json.Unmarshal(rec.body, &envelope); err != nil {
+		return ""
+	}
+	return envelope.Error.Code
+}
+
+// remoteIP returns the client address without the port
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Summarize describes a request body for an entry's payload. JSON objects are
+// kept with long strings cut, large arrays shortened and secrets masked;
+// anything else, and bodies too large to read, only by content type and size
+// (length, or the body's size when negative).
+func Summarize(contentType string, body []byte, length int64) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if len(body) <= maxSummaryBody && (mediaType == "application/json" || mediaType == "") {
+		var value interface{}
+		if json.Unmarshal(body, &value) == nil {
+			if data, err := json.Marshal(summarizeValue(value, "", 0)); err == nil {
+				return data
+			}
+		}
+	}
+
+	size := length
+	if size < 0 {
+		size = int64(len(body))
+	}
+	data, _ := json.Marshal(map[string]interface{}{"content_type": mediaType, "bytes": size})
+	return data
+}
+
+// summarizeValue shortens one JSON value; key is its field name, if any
+func summarizeValue(value interface{}, key string, depth int) interface{} {
+	if isSecret(key) {
+		return redactedValue
+	}
+	switch v := value.(type) {
+	case string:
+		if r := []rune(v); len(r) > maxSummaryString {
+			return string(r[:maxSummaryString]) + "…"
+		}
+		return v
+	case map[string]interface{}:
+		if depth >= maxSummaryDepth {
+			return "{…}"
+		}
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k] = summarizeValue(item, k, depth+1)
+		}
+		return out
+	case []interface{}:
+		if depth >= maxSummaryDepth {
+			return "[…]"
+		}
+		n := min(len(v), maxSummaryItems)
+		out := make([]interface{}, 0, n+1)
+		for _, item := range v[:n] {
+			out = append(out, summarizeValue(item, "", depth+1))
+		}
+		if len(v) > n {
+			out = append(out, "…")
+		}
+		return out
+	}
+	return value
+}
+
+// isSecret reports whether a field name looks like it holds a credential
+func isSecret(key string) bool {
+	key = strings.ToLower(key)
+	for _, word := range []string{"password", "secret", "token", "api_key", "apikey"} {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
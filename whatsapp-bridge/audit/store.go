@@ -0,0 +1,206 @@
+// Package audit records the API calls that change something (POST, PATCH,
+// DELETE) with who made them, so shared deployments can answer questions like
+// "who scheduled that message?".
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Entry is one recorded call
+type Entry struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+	// Actor is the name of the API key used, or the self-reported X-Created-By
+	// caller when authentication is off
+	Actor    string `json:"actor,omitempty"`
+	RemoteIP string `json:"remote_ip"`
+	Method   string `json:"method"`
+	Endpoint string `json:"endpoint"`
+	// ResourceID is the message or delivery the call created or changed, when known
+	ResourceID string `json:"resource_id,omitempty"`
+	// Payload summarizes the request body, with long strings cut and secrets masked
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Status    int             `json:"status"`
+	ErrorCode string          `json:"error_code,omitempty"`
+	RequestID string          `json:"request_id,omitempty"`
+	Duration  int64           `json:"duration_ms"`
+}
+
+// Filter selects entries in List. Zero fields match everything.
+type Filter struct {
+	Actor  string
+	Method string
+	// EndpointPrefix matches endpoints starting with it, e.g. /v1/scheduled
+	EndpointPrefix string
+	ResourceID     string
+	// Failed selects failed calls (status 400 and up) when true, successful
+	// ones when false
+	Failed *bool
+	Since  time.Time
+	Until  time.Time
+	After  *Cursor
+	Limit  int
+}
+
+// Cursor is the sort key of an entry in List order
+type Cursor struct {
+	Time time.Time `json:"t"`
+	ID   string    `json:"id"`
+}
+
+// Store keeps the audit log in a SQLite database
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore creates the audit table in db if needed
+func NewStore(db *sql.DB) (*Store, error) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id TEXT PRIMARY KEY,
+			time TIMESTAMP NOT NULL,
+			actor TEXT NOT NULL DEFAULT '',
+			remote_ip TEXT NOT NULL DEFAULT '',
+			method TEXT NOT NULL,
+			endpoint TEXT NOT NULL,
+			resource_id TEXT NOT NULL DEFAULT '',
+			payload TEXT,
+			status INTEGER NOT NULL,
+			error_code TEXT NOT NULL DEFAULT '',
+			request_id TEXT NOT NULL DEFAULT '',
+			duration_ms INTEGER NOT NULL DEFAULT 0
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_audit_log_time ON audit_log(time, id);
+		CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log(actor, time);
+		CREATE INDEX IF NOT EXISTS idx_audit_log_resource ON audit_log(resource_id);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create audit log table: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Record saves an entry
+func (s *Store) Record(ctx context.Context, e *Entry) error {
+	var payload interface{}
+	if len(e.Payload) > 0 {
+		payload = string(e.Payload)
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO audit_log (id, time, actor, remote_ip, method, endpoint, resource_id, payload, status, error_code, request_id, duration_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, e.ID, e.Time.UTC(), e.Actor, e.RemoteIP, e.Method, e.Endpoint, e.ResourceID, payload, e.Status, e.ErrorCode, e.RequestID, e.Duration)
+	if err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	return nil
+}
+
+// List returns the entries matching filter, latest first
+func (s *Store) List(ctx context.Context, filter Filter) ([]*Entry, error) {
+	query := `
+		SELECT id, time, actor, remote_ip, method, endpoint, resource_id, payload, status, error_code, request_id, duration_ms
+		FROM audit_log WHERE 1=1`
+	var args []interface{}
+
+	if filter.Actor != "" {
+		query += " AND actor = ?"
+		args = append(args, filter.Actor)
+	}
+	if filter.Method != "" {
+		query += " AND method = ?"
+		args = append(args, filter.Method)
+	}
+	if filter.EndpointPrefix != "" {
+		query += " AND substr(endpoint, 1, ?) = ?"
+		args = append(args, len(filter.EndpointPrefix), filter.EndpointPrefix)
+	}
+	if filter.ResourceID != "" {
+		query += " AND resource_id = ?"
+		args = append(args, filter.ResourceID)
+	}
+	if filter.Failed != nil {
+		if *filter.Failed {
+			query += " AND status >= 400"
+		} else {
+			query += " AND status < 400"
+		}
+	}
+	if !filter.Since.IsZero() {
+		query += " AND time >= ?"
+		args = append(args, filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		query += " AND time < ?"
+		args = append(args, filter.Until.UTC())
+	}
+	if filter.After != nil {
+		query += " AND (time < ? OR (time = ? AND id < ?))"
+		args = append(args, filter.After.Time.UTC(), filter.After.Time.UTC(), filter.After.ID)
+	}
+	query += " ORDER BY time DESC, id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*Entry{}
+	for rows.Next() {
+		e := &Entry{}
+		var payload sql.NullString
+		if err := rows.Scan(&e.ID, &e.Time, &e.Actor, &e.RemoteIP, &e.Method, &e.Endpoint, &e.ResourceID, &payload, &e.Status, &e.ErrorCode, &e.RequestID, &e.Duration); err != nil {
+			return nil, err
+		}
+		if payload.Valid {
+			e.Payload = json.RawMessage(payload.String)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Prune deletes the entries older than before and returns how many there were
+func (s *Store) Prune(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM audit_log WHERE time < ?", before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// pruneInterval is how often KeepFor deletes old entries
+const pruneInterval = time.Hour
+
+// KeepFor deletes entries older than retention every hour until ctx is done
+func KeepFor(ctx context.Context, store *Store, retention time.Duration) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := store.Prune(ctx, time.Now().Add(-retention))
+		if err != nil {
+			slog.Warn("Failed to prune the audit log", "error", err)
+		} else if n > 0 {
+			slog.Info("Pruned the audit log", "deleted", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waHistorySync"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/jobs"
+	"whatsapp-client/scheduler"
+	"whatsapp-client/validate"
+)
+
+const (
+	// defaultBackfillCount and maxBackfillCount are how many older messages a
+	// backfill asks for per chat by default and at most
+	defaultBackfillCount = 50
+	maxBackfillCount     = 1000
+	// backfillBatch is how many messages one request to the phone asks for
+	backfillBatch = 50
+	// backfillTimeout is how long the phone has to answer a request
+	backfillTimeout = 2 * time.Minute
+)
+
+// BackfillRequest is the body of POST /v1/history/backfill
+type BackfillRequest struct {
+	// Chat is the chat to fetch older messages of, every stored chat when
+	// empty
+	Chat string `json:"chat,omitempty"`
+	// Count is how many older messages to ask for per chat
+	Count int `json:"count,omitempty"`
+}
+
+// BackfillChat is how a backfill of one chat went
+type BackfillChat struct {
+	ChatJID string `json:"chat_jid"`
+	// Received is how many older messages the phone sent
+	Received int `json:"received"`
+	// Complete is whether the phone has no messages older than those stored
+	Complete bool   `json:"complete"`
+	Error    string `json:"error,omitempty"`
+}
+
+// oldestMessage is the anchor of an on-demand history request: the phone
+// sends the messages before it
+type oldestMessage struct {
+	id        string
+	isFromMe  bool
+	timestamp time.Time
+}
+
+// GetOldestMessage returns the oldest stored message of a chat, sql.ErrNoRows
+// when it has none
+func (store *MessageStore) GetOldestMessage(chatJID string) (oldestMessage, error) {
+	var oldest oldestMessage
+	err := store.db.QueryRow(
+		"SELECT id, is_from_me, timestamp FROM messages WHERE chat_jid = ? ORDER BY timestamp, id LIMIT 1",
+		chatJID,
+	).Scan(&oldest.id, &oldest.isFromMe, &oldest.timestamp)
+	return oldest, err
+}
+
+// GetBackfillChats returns the stored chats whose history the phone can send,
+// those with people and groups that have a message to start from, latest
+// active first
+func (store *MessageStore) GetBackfillChats() ([]string, error) {
+	rows, err := store.db.Query(
+		`SELECT jid FROM chats c WHERE type IN (?, ?) AND EXISTS (SELECT 1 FROM messages m WHERE m.chat_jid = c.jid)
+		ORDER BY last_message_time DESC`,
+		chatIndividual, chatGroup,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chats []string
+	for rows.Next() {
+		var jid string
+		if err := rows.Scan(&jid); err != nil {
+			return nil, err
+		}
+		chats = append(chats, jid)
+	}
+	return chats, rows.Err()
+}
+
+// historyBackfiller asks the phone for chats' older messages. The phone
+// answers with an on-demand history sync, which the bridge stores like any
+// other, so the backfiller only waits for it to arrive.
+type historyBackfiller struct {
+	client *whatsmeow.Client
+	store  *MessageStore
+
+	// running lets one backfill ask at a time, so each answer goes to the
+	// request it is for
+	running sync.Mutex
+
+	mu sync.Mutex
+	// waiting is where the number of messages received for a chat goes
+	waiting map[string]chan int
+}
+
+// handleEvent passes the answers to on-demand history requests to whoever
+// waits for them. It is added after the handler storing history syncs, so the
+// messages are stored by then.
+func (b *historyBackfiller) handleEvent(evt interface{}) {
+	history, ok := evt.(*events.HistorySync)
+	if !ok || history.Data.GetSyncType() != waHistorySync.HistorySync_ON_DEMAND {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, conversation := range history.Data.GetConversations() {
+		if received, ok := b.waiting[conversation.GetID()]; ok {
+			select {
+			case received <- len(conversation.GetMessages()):
+			default:
+			}
+		}
+	}
+}
+
+// request asks the phone for up to count messages of chat older than the
+// oldest stored, and returns how many it sent
+func (b *historyBackfiller) request(ctx context.Context, chat types.JID, count int) (int, error) {
+	oldest, err := b.store.GetOldestMessage(chat.String())
+	if err != nil {
+		return 0, err
+	}
+
+	received := make(chan int, 1)
+	b.mu.Lock()
+	b.waiting[chat.String()] = received
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.waiting, chat.String())
+		b.mu.Unlock()
+	}()
+
+	msg := b.client.BuildHistorySyncRequest(&types.MessageInfo{
+		MessageSource: types.MessageSource{Chat: chat, IsFromMe: oldest.isFromMe},
+		ID:            oldest.id,
+		Timestamp:     oldest.timestamp,
+	}, count)
+	// The request goes to the phone, the account's primary device
+	if _, err := b.client.SendMessage(ctx, b.client.Store.ID.ToNonAD(), msg, whatsmeow.SendRequestExtra{Peer: true}); err != nil {
+		return 0, fmt.Errorf("failed to ask the phone for history: %w", err)
+	}
+
+	timeout := time.NewTimer(backfillTimeout)
+	defer timeout.Stop()
+	select {
+	case n := <-received:
+		return n, nil
+	case <-timeout.C:
+		return 0, errBackfillTimeout
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// errBackfillTimeout is returned by request when the phone doesn't answer
+var errBackfillTimeout = fmt.Errorf("the phone didn't send history within %s; it has to be online", backfillTimeout)
+
+// backfillChat asks the phone for count older messages of a chat, a batch at
+// a time, until it has them or the phone has no more. The error is the one
+// that stopped it early, also in the result.
+func (b *historyBackfiller) backfillChat(ctx context.Context, chat types.JID, count int) (BackfillChat, error) {
+	result := BackfillChat{ChatJID: chat.String()}
+	for result.Received < count {
+		batch := min(backfillBatch, count-result.Received)
+		n, err := b.request(ctx, chat, batch)
+		if err != nil {
+			result.Error = err.Error()
+			return result, err
+		}
+		result.Received += n
+		if n == 0 {
+			result.Complete = true
+			break
+		}
+	}
+	return result, nil
+}
+
+// run backfills chats one after another, reporting each one done. It stops at
+// the first chat the phone doesn't answer for, as it is likely offline.
+func (b *historyBackfiller) run(ctx context.Context, chats []types.JID, count int, report func(done, total int)) map[string]interface{} {
+	b.running.Lock()
+	defer b.running.Unlock()
+
+	results := []BackfillChat{}
+	received := 0
+	report(0, len(chats))
+	for i, chat := range chats {
+		if ctx.Err() != nil {
+			break
+		}
+		result, err := b.backfillChat(ctx, chat, count)
+		slog.InfoContext(ctx, "Backfilled chat history", "chat_jid", result.ChatJID, "received", result.Received, "complete", result.Complete, "error", result.Error)
+		results = append(results, result)
+		received += result.Received
+		report(i+1, len(chats))
+		if errors.Is(err, errBackfillTimeout) {
+			break
+		}
+	}
+	return map[string]interface{}{
+		"success":    true,
+		"received":   received,
+		"chats":      results,
+		"chats_left": len(chats) - len(results),
+	}
+}
+
+// registerBackfillHandlers adds the endpoint for fetching older history from
+// the phone, and the event handler it waits on. It has to run after the
+// handler storing history syncs is added.
+func registerBackfillHandlers(mux *http.ServeMux, client *whatsmeow.Client, store *MessageStore, jobManager *jobs.Manager) {
+	backfill := &historyBackfiller{client: client, store: store, waiting: make(map[string]chan int)}
+	client.AddEventHandler(backfill.handleEvent)
+
+	// POST /v1/history/backfill - Ask the phone for messages older than the
+	// oldest stored, of one chat or of every chat, and store them. The phone
+	// takes a while to answer, so this always runs as a job.
+	mux.HandleFunc("POST /v1/history/backfill", func(w http.ResponseWriter, r *http.Request) {
+		var req BackfillRequest
+		var v validate.Validator
+		// The body is optional
+		if r.ContentLength != 0 && v.Decode(r, &req) {
+			if req.Chat != "" {
+				req.Chat = v.Recipient("chat", req.Chat)
+			}
+			v.NotNegative("count", req.Count)
+			if req.Count > maxBackfillCount {
+				v.Add("count", validate.CodeInvalidValue, fmt.Sprintf("must be at most %d", maxBackfillCount))
+			}
+		}
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		if req.Count == 0 {
+			req.Count = defaultBackfillCount
+		}
+
+		var chats []types.JID
+		if req.Chat != "" {
+			chat, err := parseRecipient(req.Chat)
+			if err != nil {
+				apierror.BadRequest(w, err.Error())
+				return
+			}
+			if _, err := store.GetOldestMessage(chat.String()); errors.Is(err, sql.ErrNoRows) {
+				apierror.NotFound(w, "No messages stored in "+chat.String()+" to fetch older ones from")
+				return
+			} else if err != nil {
+				slog.ErrorContext(r.Context(), "Failed to get oldest message", "chat_jid", chat, "error", err)
+				apierror.Internal(w, "Failed to get oldest message")
+				return
+			}
+			chats = append(chats, chat)
+		} else {
+			jids, err := store.GetBackfillChats()
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Failed to get chats to backfill", "error", err)
+				apierror.Internal(w, "Failed to get chats to backfill")
+				return
+			}
+			for _, jid := range jids {
+				if chat, err := types.ParseJID(jid); err == nil {
+					chats = append(chats, chat)
+				}
+			}
+		}
+
+		if !client.IsConnected() || client.Store.ID == nil {
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not connected to WhatsApp")
+			return
+		}
+
+		job, err := jobManager.Start(r.Context(), "backfill", scheduler.RequestCreator(r), func(ctx context.Context, report func(int, int)) (interface{}, error) {
+			return backfill.run(ctx, chats, req.Count, report), nil
+		})
+		if err != nil {
+			jobs.StartError(w, err)
+			return
+		}
+		slog.InfoContext(r.Context(), "Started history backfill", "job_id", job.ID, "chats", len(chats), "count", req.Count)
+		jobs.Accepted(w, r, job)
+	})
+}
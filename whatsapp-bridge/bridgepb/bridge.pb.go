@@ -0,0 +1,925 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        v5.29.3
+// source: bridgepb/bridge.proto
+
+package bridgepb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// ScheduledMessage is a message waiting to be sent, or the record of one
+type ScheduledMessage struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	Id               string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Recipient        string                 `protobuf:"bytes,2,opt,name=recipient,proto3" json:"recipient,omitempty"`
+	Message          string                 `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"`
+	ScheduledTime    *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=scheduled_time,json=scheduledTime,proto3" json:"scheduled_time,omitempty"`
+	CreatedAt        *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	CheckForResponse bool                   `protobuf:"varint,6,opt,name=check_for_response,json=checkForResponse,proto3" json:"check_for_response,omitempty"`
+	// Status is pending, sending, sent, paused, cancelled or failed
+	Status            string                 `protobuf:"bytes,7,opt,name=status,proto3" json:"status,omitempty"`
+	SentAt            *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=sent_at,json=sentAt,proto3" json:"sent_at,omitempty"`
+	ErrorMessage      string                 `protobuf:"bytes,9,opt,name=error_message,json=errorMessage,proto3" json:"error_message,omitempty"`
+	WhatsappMessageId string                 `protobuf:"bytes,10,opt,name=whatsapp_message_id,json=whatsappMessageId,proto3" json:"whatsapp_message_id,omitempty"`
+	ChatJid           string                 `protobuf:"bytes,11,opt,name=chat_jid,json=chatJid,proto3" json:"chat_jid,omitempty"`
+	DeliveredAt       *timestamppb.Timestamp `protobuf:"bytes,12,opt,name=delivered_at,json=deliveredAt,proto3" json:"delivered_at,omitempty"`
+	ReadAt            *timestamppb.Timestamp `protobuf:"bytes,13,opt,name=read_at,json=readAt,proto3" json:"read_at,omitempty"`
+	// DeliveryMode is scheduled or online
+	DeliveryMode        string `protobuf:"bytes,14,opt,name=delivery_mode,json=deliveryMode,proto3" json:"delivery_mode,omitempty"`
+	OnlineWindowMinutes int32  `protobuf:"varint,15,opt,name=online_window_minutes,json=onlineWindowMinutes,proto3" json:"online_window_minutes,omitempty"`
+	// Precision is normal or precise
+	Precision string `protobuf:"bytes,16,opt,name=precision,proto3" json:"precision,omitempty"`
+	// MetadataJson is the caller's metadata as JSON, empty if there is none
+	MetadataJson  string `protobuf:"bytes,17,opt,name=metadata_json,json=metadataJson,proto3" json:"metadata_json,omitempty"`
+	CreatedBy     string `protobuf:"bytes,18,opt,name=created_by,json=createdBy,proto3" json:"created_by,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScheduledMessage) Reset() {
+	*x = ScheduledMessage{}
+	mi := &file_bridgepb_bridge_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScheduledMessage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScheduledMessage) ProtoMessage() {}
+
+func (x *ScheduledMessage) ProtoReflect() protoreflect.Message {
+	mi := &file_bridgepb_bridge_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScheduledMessage.ProtoReflect.Descriptor instead.
+func (*ScheduledMessage) Descriptor() ([]byte, []int) {
+	return file_bridgepb_bridge_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ScheduledMessage) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *ScheduledMessage) GetRecipient() string {
+	if x != nil {
+		return x.Recipient
+	}
+	return ""
+}
+
+func (x *ScheduledMessage) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *ScheduledMessage) GetScheduledTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ScheduledTime
+	}
+	return nil
+}
+
+func (x *ScheduledMessage) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *ScheduledMessage) GetCheckForResponse() bool {
+	if x != nil {
+		return x.CheckForResponse
+	}
+	return false
+}
+
+func (x *ScheduledMessage) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *ScheduledMessage) GetSentAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.SentAt
+	}
+	return nil
+}
+
+func (x *ScheduledMessage) GetErrorMessage() string {
+	if x != nil {
+		return x.ErrorMessage
+	}
+	return ""
+}
+
+func (x *ScheduledMessage) GetWhatsappMessageId() string {
+	if x != nil {
+		return x.WhatsappMessageId
+	}
+	return ""
+}
+
+func (x *ScheduledMessage) GetChatJid() string {
+	if x != nil {
+		return x.ChatJid
+	}
+	return ""
+}
+
+func (x *ScheduledMessage) GetDeliveredAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.DeliveredAt
+	}
+	return nil
+}
+
+func (x *ScheduledMessage) GetReadAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ReadAt
+	}
+	return nil
+}
+
+func (x *ScheduledMessage) GetDeliveryMode() string {
+	if x != nil {
+		return x.DeliveryMode
+	}
+	return ""
+}
+
+func (x *ScheduledMessage) GetOnlineWindowMinutes() int32 {
+	if x != nil {
+		return x.OnlineWindowMinutes
+	}
+	return 0
+}
+
+func (x *ScheduledMessage) GetPrecision() string {
+	if x != nil {
+		return x.Precision
+	}
+	return ""
+}
+
+func (x *ScheduledMessage) GetMetadataJson() string {
+	if x != nil {
+		return x.MetadataJson
+	}
+	return ""
+}
+
+func (x *ScheduledMessage) GetCreatedBy() string {
+	if x != nil {
+		return x.CreatedBy
+	}
+	return ""
+}
+
+type ScheduleMessageRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Recipient is a phone number with country code or a full JID
+	Recipient        string                 `protobuf:"bytes,1,opt,name=recipient,proto3" json:"recipient,omitempty"`
+	Message          string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	ScheduledTime    *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=scheduled_time,json=scheduledTime,proto3" json:"scheduled_time,omitempty"`
+	CheckForResponse bool                   `protobuf:"varint,4,opt,name=check_for_response,json=checkForResponse,proto3" json:"check_for_response,omitempty"`
+	// DeliveryMode is scheduled (the default) or online
+	DeliveryMode        string `protobuf:"bytes,5,opt,name=delivery_mode,json=deliveryMode,proto3" json:"delivery_mode,omitempty"`
+	OnlineWindowMinutes int32  `protobuf:"varint,6,opt,name=online_window_minutes,json=onlineWindowMinutes,proto3" json:"online_window_minutes,omitempty"`
+	// Precision is normal (the default) or precise
+	Precision string `protobuf:"bytes,7,opt,name=precision,proto3" json:"precision,omitempty"`
+	// MetadataJson is stored with the message and returned untouched; it must be valid JSON
+	MetadataJson  string `protobuf:"bytes,8,opt,name=metadata_json,json=metadataJson,proto3" json:"metadata_json,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScheduleMessageRequest) Reset() {
+	*x = ScheduleMessageRequest{}
+	mi := &file_bridgepb_bridge_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScheduleMessageRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScheduleMessageRequest) ProtoMessage() {}
+
+func (x *ScheduleMessageRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_bridgepb_bridge_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScheduleMessageRequest.ProtoReflect.Descriptor instead.
+func (*ScheduleMessageRequest) Descriptor() ([]byte, []int) {
+	return file_bridgepb_bridge_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ScheduleMessageRequest) GetRecipient() string {
+	if x != nil {
+		return x.Recipient
+	}
+	return ""
+}
+
+func (x *ScheduleMessageRequest) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *ScheduleMessageRequest) GetScheduledTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ScheduledTime
+	}
+	return nil
+}
+
+func (x *ScheduleMessageRequest) GetCheckForResponse() bool {
+	if x != nil {
+		return x.CheckForResponse
+	}
+	return false
+}
+
+func (x *ScheduleMessageRequest) GetDeliveryMode() string {
+	if x != nil {
+		return x.DeliveryMode
+	}
+	return ""
+}
+
+func (x *ScheduleMessageRequest) GetOnlineWindowMinutes() int32 {
+	if x != nil {
+		return x.OnlineWindowMinutes
+	}
+	return 0
+}
+
+func (x *ScheduleMessageRequest) GetPrecision() string {
+	if x != nil {
+		return x.Precision
+	}
+	return ""
+}
+
+func (x *ScheduleMessageRequest) GetMetadataJson() string {
+	if x != nil {
+		return x.MetadataJson
+	}
+	return ""
+}
+
+type GetScheduledMessageRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetScheduledMessageRequest) Reset() {
+	*x = GetScheduledMessageRequest{}
+	mi := &file_bridgepb_bridge_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetScheduledMessageRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetScheduledMessageRequest) ProtoMessage() {}
+
+func (x *GetScheduledMessageRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_bridgepb_bridge_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetScheduledMessageRequest.ProtoReflect.Descriptor instead.
+func (*GetScheduledMessageRequest) Descriptor() ([]byte, []int) {
+	return file_bridgepb_bridge_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GetScheduledMessageRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type ListScheduledMessagesRequest struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Status    string                 `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
+	Recipient string                 `protobuf:"bytes,2,opt,name=recipient,proto3" json:"recipient,omitempty"`
+	CreatedBy string                 `protobuf:"bytes,3,opt,name=created_by,json=createdBy,proto3" json:"created_by,omitempty"`
+	// Limit is the page size, 100 when unset and at most 1000
+	Limit int32 `protobuf:"varint,4,opt,name=limit,proto3" json:"limit,omitempty"`
+	// Cursor is the next_cursor of the previous page
+	Cursor        string `protobuf:"bytes,5,opt,name=cursor,proto3" json:"cursor,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListScheduledMessagesRequest) Reset() {
+	*x = ListScheduledMessagesRequest{}
+	mi := &file_bridgepb_bridge_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListScheduledMessagesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListScheduledMessagesRequest) ProtoMessage() {}
+
+func (x *ListScheduledMessagesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_bridgepb_bridge_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListScheduledMessagesRequest.ProtoReflect.Descriptor instead.
+func (*ListScheduledMessagesRequest) Descriptor() ([]byte, []int) {
+	return file_bridgepb_bridge_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListScheduledMessagesRequest) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *ListScheduledMessagesRequest) GetRecipient() string {
+	if x != nil {
+		return x.Recipient
+	}
+	return ""
+}
+
+func (x *ListScheduledMessagesRequest) GetCreatedBy() string {
+	if x != nil {
+		return x.CreatedBy
+	}
+	return ""
+}
+
+func (x *ListScheduledMessagesRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *ListScheduledMessagesRequest) GetCursor() string {
+	if x != nil {
+		return x.Cursor
+	}
+	return ""
+}
+
+type ListScheduledMessagesResponse struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Messages []*ScheduledMessage    `protobuf:"bytes,1,rep,name=messages,proto3" json:"messages,omitempty"`
+	// NextCursor fetches the next page; it is empty on the last one
+	NextCursor    string `protobuf:"bytes,2,opt,name=next_cursor,json=nextCursor,proto3" json:"next_cursor,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListScheduledMessagesResponse) Reset() {
+	*x = ListScheduledMessagesResponse{}
+	mi := &file_bridgepb_bridge_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListScheduledMessagesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListScheduledMessagesResponse) ProtoMessage() {}
+
+func (x *ListScheduledMessagesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_bridgepb_bridge_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListScheduledMessagesResponse.ProtoReflect.Descriptor instead.
+func (*ListScheduledMessagesResponse) Descriptor() ([]byte, []int) {
+	return file_bridgepb_bridge_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ListScheduledMessagesResponse) GetMessages() []*ScheduledMessage {
+	if x != nil {
+		return x.Messages
+	}
+	return nil
+}
+
+func (x *ListScheduledMessagesResponse) GetNextCursor() string {
+	if x != nil {
+		return x.NextCursor
+	}
+	return ""
+}
+
+type CancelScheduledMessageRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CancelScheduledMessageRequest) Reset() {
+	*x = CancelScheduledMessageRequest{}
+	mi := &file_bridgepb_bridge_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CancelScheduledMessageRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CancelScheduledMessageRequest) ProtoMessage() {}
+
+func (x *CancelScheduledMessageRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_bridgepb_bridge_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CancelScheduledMessageRequest.ProtoReflect.Descriptor instead.
+func (*CancelScheduledMessageRequest) Descriptor() ([]byte, []int) {
+	return file_bridgepb_bridge_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *CancelScheduledMessageRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type SendMessageRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Recipient is a phone number with country code or a full JID
+	Recipient string `protobuf:"bytes,1,opt,name=recipient,proto3" json:"recipient,omitempty"`
+	Message   string `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	// MediaPath is a file on the bridge host to send as media, with message as its caption
+	MediaPath     string `protobuf:"bytes,3,opt,name=media_path,json=mediaPath,proto3" json:"media_path,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SendMessageRequest) Reset() {
+	*x = SendMessageRequest{}
+	mi := &file_bridgepb_bridge_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SendMessageRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SendMessageRequest) ProtoMessage() {}
+
+func (x *SendMessageRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_bridgepb_bridge_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SendMessageRequest.ProtoReflect.Descriptor instead.
+func (*SendMessageRequest) Descriptor() ([]byte, []int) {
+	return file_bridgepb_bridge_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *SendMessageRequest) GetRecipient() string {
+	if x != nil {
+		return x.Recipient
+	}
+	return ""
+}
+
+func (x *SendMessageRequest) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *SendMessageRequest) GetMediaPath() string {
+	if x != nil {
+		return x.MediaPath
+	}
+	return ""
+}
+
+type SendMessageResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	MessageId     string                 `protobuf:"bytes,1,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
+	ChatJid       string                 `protobuf:"bytes,2,opt,name=chat_jid,json=chatJid,proto3" json:"chat_jid,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SendMessageResponse) Reset() {
+	*x = SendMessageResponse{}
+	mi := &file_bridgepb_bridge_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SendMessageResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SendMessageResponse) ProtoMessage() {}
+
+func (x *SendMessageResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_bridgepb_bridge_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SendMessageResponse.ProtoReflect.Descriptor instead.
+func (*SendMessageResponse) Descriptor() ([]byte, []int) {
+	return file_bridgepb_bridge_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *SendMessageResponse) GetMessageId() string {
+	if x != nil {
+		return x.MessageId
+	}
+	return ""
+}
+
+func (x *SendMessageResponse) GetChatJid() string {
+	if x != nil {
+		return x.ChatJid
+	}
+	return ""
+}
+
+type StreamEventsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types limits the stream to these event types; empty means all
+	Types []string `protobuf:"bytes,1,rep,name=types,proto3" json:"types,omitempty"`
+	// AfterId first replays the kept events after this ID
+	AfterId       uint64 `protobuf:"varint,2,opt,name=after_id,json=afterId,proto3" json:"after_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamEventsRequest) Reset() {
+	*x = StreamEventsRequest{}
+	mi := &file_bridgepb_bridge_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamEventsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamEventsRequest) ProtoMessage() {}
+
+func (x *StreamEventsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_bridgepb_bridge_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamEventsRequest.ProtoReflect.Descriptor instead.
+func (*StreamEventsRequest) Descriptor() ([]byte, []int) {
+	return file_bridgepb_bridge_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *StreamEventsRequest) GetTypes() []string {
+	if x != nil {
+		return x.Types
+	}
+	return nil
+}
+
+func (x *StreamEventsRequest) GetAfterId() uint64 {
+	if x != nil {
+		return x.AfterId
+	}
+	return 0
+}
+
+// Event is one bridge event, with the same data as the SSE stream
+type Event struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            uint64                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Type          string                 `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=time,proto3" json:"time,omitempty"`
+	Data          *structpb.Struct       `protobuf:"bytes,4,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	mi := &file_bridgepb_bridge_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_bridgepb_bridge_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_bridgepb_bridge_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Event) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Event) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Event) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *Event) GetData() *structpb.Struct {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+var File_bridgepb_bridge_proto protoreflect.FileDescriptor
+
+const file_bridgepb_bridge_proto_rawDesc = "" +
+	"\n" +
+	"\x15bridgepb/bridge.proto\x12\x12whatsapp.bridge.v1\x1a\x1cgoogle/protobuf/struct.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\xf2\x05\n" +
+	"\x10ScheduledMessage\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1c\n" +
+	"\trecipient\x18\x02 \x01(\tR\trecipient\x12\x18\n" +
+	"\amessage\x18\x03 \x01(\tR\amessage\x12A\n" +
+	"\x0escheduled_time\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\rscheduledTime\x129\n" +
+	"\n" +
+	"created_at\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x12,\n" +
+	"\x12check_for_response\x18\x06 \x01(\bR\x10checkForResponse\x12\x16\n" +
+	"\x06status\x18\a \x01(\tR\x06status\x123\n" +
+	"\asent_at\x18\b \x01(\v2\x1a.google.protobuf.TimestampR\x06sentAt\x12#\n" +
+	"\rerror_message\x18\t \x01(\tR\ferrorMessage\x12.\n" +
+	"\x13whatsapp_message_id\x18\n" +
+	" \x01(\tR\x11whatsappMessageId\x12\x19\n" +
+	"\bchat_jid\x18\v \x01(\tR\achatJid\x12=\n" +
+	"\fdelivered_at\x18\f \x01(\v2\x1a.google.protobuf.TimestampR\vdeliveredAt\x123\n" +
+	"\aread_at\x18\r \x01(\v2\x1a.google.protobuf.TimestampR\x06readAt\x12#\n" +
+	"\rdelivery_mode\x18\x0e \x01(\tR\fdeliveryMode\x122\n" +
+	"\x15online_window_minutes\x18\x0f \x01(\x05R\x13onlineWindowMinutes\x12\x1c\n" +
+	"\tprecision\x18\x10 \x01(\tR\tprecision\x12#\n" +
+	"\rmetadata_json\x18\x11 \x01(\tR\fmetadataJson\x12\x1d\n" +
+	"\n" +
+	"created_by\x18\x12 \x01(\tR\tcreatedBy\"\xdd\x02\n" +
+	"\x16ScheduleMessageRequest\x12\x1c\n" +
+	"\trecipient\x18\x01 \x01(\tR\trecipient\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\x12A\n" +
+	"\x0escheduled_time\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\rscheduledTime\x12,\n" +
+	"\x12check_for_response\x18\x04 \x01(\bR\x10checkForResponse\x12#\n" +
+	"\rdelivery_mode\x18\x05 \x01(\tR\fdeliveryMode\x122\n" +
+	"\x15online_window_minutes\x18\x06 \x01(\x05R\x13onlineWindowMinutes\x12\x1c\n" +
+	"\tprecision\x18\a \x01(\tR\tprecision\x12#\n" +
+	"\rmetadata_json\x18\b \x01(\tR\fmetadataJson\",\n" +
+	"\x1aGetScheduledMessageRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"\xa1\x01\n" +
+	"\x1cListScheduledMessagesRequest\x12\x16\n" +
+	"\x06status\x18\x01 \x01(\tR\x06status\x12\x1c\n" +
+	"\trecipient\x18\x02 \x01(\tR\trecipient\x12\x1d\n" +
+	"\n" +
+	"created_by\x18\x03 \x01(\tR\tcreatedBy\x12\x14\n" +
+	"\x05limit\x18\x04 \x01(\x05R\x05limit\x12\x16\n" +
+	"\x06cursor\x18\x05 \x01(\tR\x06cursor\"\x82\x01\n" +
+	"\x1dListScheduledMessagesResponse\x12@\n" +
+	"\bmessages\x18\x01 \x03(\v2$.whatsapp.bridge.v1.ScheduledMessageR\bmessages\x12\x1f\n" +
+	"\vnext_cursor\x18\x02 \x01(\tR\n" +
+	"nextCursor\"/\n" +
+	"\x1dCancelScheduledMessageRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"k\n" +
+	"\x12SendMessageRequest\x12\x1c\n" +
+	"\trecipient\x18\x01 \x01(\tR\trecipient\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\x12\x1d\n" +
+	"\n" +
+	"media_path\x18\x03 \x01(\tR\tmediaPath\"O\n" +
+	"\x13SendMessageResponse\x12\x1d\n" +
+	"\n" +
+	"message_id\x18\x01 \x01(\tR\tmessageId\x12\x19\n" +
+	"\bchat_jid\x18\x02 \x01(\tR\achatJid\"F\n" +
+	"\x13StreamEventsRequest\x12\x14\n" +
+	"\x05types\x18\x01 \x03(\tR\x05types\x12\x19\n" +
+	"\bafter_id\x18\x02 \x01(\x04R\aafterId\"\x88\x01\n" +
+	"\x05Event\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x12.\n" +
+	"\x04time\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12+\n" +
+	"\x04data\x18\x04 \x01(\v2\x17.google.protobuf.StructR\x04data2\x81\x05\n" +
+	"\x06Bridge\x12c\n" +
+	"\x0fScheduleMessage\x12*.whatsapp.bridge.v1.ScheduleMessageRequest\x1a$.whatsapp.bridge.v1.ScheduledMessage\x12k\n" +
+	"\x13GetScheduledMessage\x12..whatsapp.bridge.v1.GetScheduledMessageRequest\x1a$.whatsapp.bridge.v1.ScheduledMessage\x12|\n" +
+	"\x15ListScheduledMessages\x120.whatsapp.bridge.v1.ListScheduledMessagesRequest\x1a1.whatsapp.bridge.v1.ListScheduledMessagesResponse\x12q\n" +
+	"\x16CancelScheduledMessage\x121.whatsapp.bridge.v1.CancelScheduledMessageRequest\x1a$.whatsapp.bridge.v1.ScheduledMessage\x12^\n" +
+	"\vSendMessage\x12&.whatsapp.bridge.v1.SendMessageRequest\x1a'.whatsapp.bridge.v1.SendMessageResponse\x12T\n" +
+	"\fStreamEvents\x12'.whatsapp.bridge.v1.StreamEventsRequest\x1a\x19.whatsapp.bridge.v1.Event0\x01B\x1aZ\x18whatsapp-client/bridgepbb\x06proto3"
+
+var (
+	file_bridgepb_bridge_proto_rawDescOnce sync.Once
+	file_bridgepb_bridge_proto_rawDescData []byte
+)
+
+func file_bridgepb_bridge_proto_rawDescGZIP() []byte {
+	file_bridgepb_bridge_proto_rawDescOnce.Do(func() {
+		file_bridgepb_bridge_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_bridgepb_bridge_proto_rawDesc), len(file_bridgepb_bridge_proto_rawDesc)))
+	})
+	return file_bridgepb_bridge_proto_rawDescData
+}
+
+var file_bridgepb_bridge_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_bridgepb_bridge_proto_goTypes = []any{
+	(*ScheduledMessage)(nil),              // 0: whatsapp.bridge.v1.ScheduledMessage
+	(*ScheduleMessageRequest)(nil),        // 1: whatsapp.bridge.v1.ScheduleMessageRequest
+	(*GetScheduledMessageRequest)(nil),    // 2: whatsapp.bridge.v1.GetScheduledMessageRequest
+	(*ListScheduledMessagesRequest)(nil),  // 3: whatsapp.bridge.v1.ListScheduledMessagesRequest
+	(*ListScheduledMessagesResponse)(nil), // 4: whatsapp.bridge.v1.ListScheduledMessagesResponse
+	(*CancelScheduledMessageRequest)(nil), // 5: whatsapp.bridge.v1.CancelScheduledMessageRequest
+	(*SendMessageRequest)(nil),            // 6: whatsapp.bridge.v1.SendMessageRequest
+	(*SendMessageResponse)(nil),           // 7: whatsapp.bridge.v1.SendMessageResponse
+	(*StreamEventsRequest)(nil),           // 8: whatsapp.bridge.v1.StreamEventsRequest
+	(*Event)(nil),                         // 9: whatsapp.bridge.v1.Event
+	(*timestamppb.Timestamp)(nil),         // 10: google.protobuf.Timestamp
+	(*structpb.Struct)(nil),               // 11: google.protobuf.Struct
+}
+var file_bridgepb_bridge_proto_depIdxs = []int32{
+	10, // 0: whatsapp.bridge.v1.ScheduledMessage.scheduled_time:type_name -> google.protobuf.Timestamp
+	10, // 1: whatsapp.bridge.v1.ScheduledMessage.created_at:type_name -> google.protobuf.Timestamp
+	10, // 2: whatsapp.bridge.v1.ScheduledMessage.sent_at:type_name -> google.protobuf.Timestamp
+	10, // 3: whatsapp.bridge.v1.ScheduledMessage.delivered_at:type_name -> google.protobuf.Timestamp
+	10, // 4: whatsapp.bridge.v1.ScheduledMessage.read_at:type_name -> google.protobuf.Timestamp
+	10, // 5: whatsapp.bridge.v1.ScheduleMessageRequest.scheduled_time:type_name -> google.protobuf.Timestamp
+	0,  // 6: whatsapp.bridge.v1.ListScheduledMessagesResponse.messages:type_name -> whatsapp.bridge.v1.ScheduledMessage
+	10, // 7: whatsapp.bridge.v1.Event.time:type_name -> google.protobuf.Timestamp
+	11, // 8: whatsapp.bridge.v1.Event.data:type_name -> google.protobuf.Struct
+	1,  // 9: whatsapp.bridge.v1.Bridge.ScheduleMessage:input_type -> whatsapp.bridge.v1.ScheduleMessageRequest
+	2,  // 10: whatsapp.bridge.v1.Bridge.GetScheduledMessage:input_type -> whatsapp.bridge.v1.GetScheduledMessageRequest
+	3,  // 11: whatsapp.bridge.v1.Bridge.ListScheduledMessages:input_type -> whatsapp.bridge.v1.ListScheduledMessagesRequest
+	5,  // 12: whatsapp.bridge.v1.Bridge.CancelScheduledMessage:input_type -> whatsapp.bridge.v1.CancelScheduledMessageRequest
+	6,  // 13: whatsapp.bridge.v1.Bridge.SendMessage:input_type -> whatsapp.bridge.v1.SendMessageRequest
+	8,  // 14: whatsapp.bridge.v1.Bridge.StreamEvents:input_type -> whatsapp.bridge.v1.StreamEventsRequest
+	0,  // 15: whatsapp.bridge.v1.Bridge.ScheduleMessage:output_type -> whatsapp.bridge.v1.ScheduledMessage
+	0,  // 16: whatsapp.bridge.v1.Bridge.GetScheduledMessage:output_type -> whatsapp.bridge.v1.ScheduledMessage
+	4,  // 17: whatsapp.bridge.v1.Bridge.ListScheduledMessages:output_type -> whatsapp.bridge.v1.ListScheduledMessagesResponse
+	0,  // 18: whatsapp.bridge.v1.Bridge.CancelScheduledMessage:output_type -> whatsapp.bridge.v1.ScheduledMessage
+	7,  // 19: whatsapp.bridge.v1.Bridge.SendMessage:output_type -> whatsapp.bridge.v1.SendMessageResponse
+	9,  // 20: whatsapp.bridge.v1.Bridge.StreamEvents:output_type -> whatsapp.bridge.v1.Event
+	15, // [15:21] is the sub-list for method output_type
+	9,  // [9:15] is the sub-list for method input_type
+	9,  // [9:9] is the sub-list for extension type_name
+	9,  // [9:9] is the sub-list for extension extendee
+	0,  // [0:9] is the sub-list for field type_name
+}
+
+func init() { file_bridgepb_bridge_proto_init() }
+func file_bridgepb_bridge_proto_init() {
+	if File_bridgepb_bridge_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_bridgepb_bridge_proto_rawDesc), len(file_bridgepb_bridge_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   10,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_bridgepb_bridge_proto_goTypes,
+		DependencyIndexes: file_bridgepb_bridge_proto_depIdxs,
+		MessageInfos:      file_bridgepb_bridge_proto_msgTypes,
+	}.Build()
+	File_bridgepb_bridge_proto = out.File
+	file_bridgepb_bridge_proto_goTypes = nil
+	file_bridgepb_bridge_proto_depIdxs = nil
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/audit"
+	"whatsapp-client/scheduler"
+	"whatsapp-client/validate"
+)
+
+// maxBroadcastRecipients is the most recipients WhatsApp lets a broadcast
+// list have
+const maxBroadcastRecipients = 256
+
+// BroadcastList is a broadcast list the bridge knows, and who is on it
+type BroadcastList struct {
+	JID        string     `json:"jid"`
+	Name       string     `json:"name"`
+	Recipients []string   `json:"recipients"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+}
+
+// SetBroadcastRequest is the body of PUT /v1/broadcasts/{jid}
+type SetBroadcastRequest struct {
+	// Recipients are the phone numbers or JIDs of the people on the list
+	Recipients []string `json:"recipients"`
+}
+
+// BroadcastDelivery is one recipient's copy of a message sent to a broadcast
+// list, with its receipt times once they arrive
+type BroadcastDelivery struct {
+	Recipient   string     `json:"recipient"`
+	MessageID   string     `json:"message_id,omitempty"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	SentAt      time.Time  `json:"sent_at"`
+	DeliveredAt *time.Time `json:"delivered_at"`
+	ReadAt      *time.Time `json:"read_at"`
+}
+
+// StoreBroadcastList sets the recipients of a broadcast list. Recipients older
+// than the ones stored are ignored.
+func (store *MessageStore) StoreBroadcastList(listJID string, recipients []string, updatedAt time.Time) error {
+	encoded, err := json.Marshal(recipients)
+	if err != nil {
+		return err
+	}
+	_, err = store.db.Exec(
+		`INSERT INTO broadcast_lists (jid, recipients, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (jid) DO UPDATE SET recipients = excluded.recipients, updated_at = excluded.updated_at
+		WHERE excluded.updated_at >= broadcast_lists.updated_at`,
+		listJID, string(encoded), updatedAt,
+	)
+	return err
+}
+
+// GetBroadcastLists returns the broadcast lists the bridge knows: those it has
+// recipients for, and those it only has messages in, which have none
+func (store *MessageStore) GetBroadcastLists() ([]BroadcastList, error) {
+	rows, err := store.db.Query(
+		`SELECT b.jid, COALESCE(c.name, ''), b.recipients, b.updated_at
+		FROM broadcast_lists b LEFT JOIN chats c ON c.jid = b.jid
+		UNION ALL
+		SELECT c.jid, COALESCE(c.name, ''), '[]', NULL
+		FROM chats c
+		WHERE c.jid LIKE '%@broadcast' AND c.jid != ?
+		AND NOT EXISTS (SELECT 1 FROM broadcast_lists b WHERE b.jid = c.jid)
+		ORDER BY 1`,
+		types.StatusBroadcastJID.String(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lists := []BroadcastList{}
+	for rows.Next() {
+		var list BroadcastList
+		var recipients string
+		var updatedAt sql.NullTime
+		if err := rows.Scan(&list.JID, &list.Name, &recipients, &updatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(recipients), &list.Recipients); err != nil {
+			return nil, fmt.Errorf("invalid recipients of %s: %w", list.JID, err)
+		}
+		list.UpdatedAt = nullTimePtr(updatedAt)
+		lists = append(lists, list)
+	}
+	return lists, rows.Err()
+}
+
+// GetBroadcastRecipients returns the recipients of a broadcast list, none if
+// the bridge doesn't know them
+func (store *MessageStore) GetBroadcastRecipients(listJID string) ([]string, error) {
+	var encoded string
+	err := store.db.QueryRow("SELECT recipients FROM broadcast_lists WHERE jid = ?", listJID).Scan(&encoded)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var recipients []string
+	err = json.Unmarshal([]byte(encoded), &recipients)
+	return recipients, err
+}
+
+// StoreBroadcastDelivery records what happened to one recipient's copy of a
+// message sent to a broadcast list. A failed copy has no message ID.
+func (store *MessageStore) StoreBroadcastDelivery(broadcastID, listJID, recipient string, result scheduler.SendResult, sentAt time.Time) error {
+	var messageID, sendError sql.NullString
+	if result.Success {
+		messageID = sql.NullString{String: result.MessageID, Valid: true}
+	} else {
+		sendError = sql.NullString{String: result.Message, Valid: true}
+	}
+	_, err := store.db.Exec(
+		`INSERT INTO broadcast_deliveries (broadcast_id, list_jid, recipient, message_id, error, sent_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		broadcastID, listJID, recipient, messageID, sendError, sentAt,
+	)
+	return err
+}
+
+// GetBroadcastDeliveries returns each recipient's copy of a message sent to a
+// broadcast list, with the receipts of the copies sent. It returns no
+// deliveries for a message that wasn't sent to the list.
+func (store *MessageStore) GetBroadcastDeliveries(broadcastID, listJID string) ([]BroadcastDelivery, error) {
+	rows, err := store.db.Query(
+		`SELECT d.recipient, d.message_id, d.error, d.sent_at, r.delivered_at, r.read_at
+		FROM broadcast_deliveries d
+		LEFT JOIN message_receipts r ON r.message_id = d.message_id AND r.chat_jid = d.recipient AND r.participant = d.recipient
+		WHERE d.broadcast_id = ? AND d.list_jid = ?
+		ORDER BY d.recipient`,
+		broadcastID, listJID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []BroadcastDelivery{}
+	for rows.Next() {
+		var delivery BroadcastDelivery
+		var messageID, sendError sql.NullString
+		var deliveredAt, readAt sql.NullTime
+		if err := rows.Scan(&delivery.Recipient, &messageID, &sendError, &delivery.SentAt, &deliveredAt, &readAt); err != nil {
+			return nil, err
+		}
+		delivery.MessageID = messageID.String
+		delivery.Error = sendError.String
+		delivery.Status = "sent"
+		if sendError.Valid {
+			delivery.Status = "failed"
+		}
+		delivery.DeliveredAt = nullTimePtr(deliveredAt)
+		delivery.ReadAt = nullTimePtr(readAt)
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// handleBroadcastRecipients records who is on a broadcast list, from a message
+// the account sent to it from the phone. Linked devices can't ask for a list's
+// recipients, so this is how the bridge learns them.
+func handleBroadcastRecipients(store *MessageStore, msg *events.Message) {
+	recipients := make([]string, 0, len(msg.Info.BroadcastRecipients))
+	for _, recipient := range msg.Info.BroadcastRecipients {
+		jid := recipient.PN
+		if jid.IsEmpty() {
+			jid = recipient.LID
+		}
+		recipients = append(recipients, jid.ToNonAD().String())
+	}
+	if err := store.StoreBroadcastList(msg.Info.Chat.String(), recipients, msg.Info.Timestamp); err != nil {
+		slog.Warn("Failed to store broadcast list", "chat_jid", msg.Info.Chat.String(), "error", err)
+	}
+}
+
+// sendToBroadcast sends a message to everyone on a broadcast list, each in
+// their own chat as phones do, and records each recipient's copy. send sends
+// it to one recipient, with the context that chat's disappearing timer needs.
+// The result has a broadcast ID standing for all the copies, and fails only
+// when no copy was sent.
+func sendToBroadcast(ctx context.Context, client *whatsmeow.Client, store *MessageStore, listJID string, send func(ctx context.Context, recipient string, contextInfo *waProto.ContextInfo) scheduler.SendResult) scheduler.SendResult {
+	recipients, err := store.GetBroadcastRecipients(listJID)
+	if err != nil {
+		return scheduler.SendResult{Message: fmt.Sprintf("Error reading broadcast list: %v", err)}
+	}
+	if len(recipients) == 0 {
+		return scheduler.SendResult{Message: fmt.Sprintf("The recipients of broadcast list %s aren't known", listJID)}
+	}
+
+	broadcastID := client.GenerateMessageID()
+	var sent int
+	var lastError string
+	for _, recipient := range recipients {
+		var result scheduler.SendResult
+		if info, err := sendContext(store, client, recipient, scheduler.SendOptions{}); err != nil {
+			result = scheduler.SendResult{Message: fmt.Sprintf("Error building message context: %v", err)}
+		} else {
+			result = send(ctx, recipient, info)
+		}
+		if result.Success {
+			sent++
+		} else {
+			lastError = result.Message
+			slog.WarnContext(ctx, "Failed to send broadcast copy", "broadcast_id", broadcastID, "chat_jid", listJID, "recipient", recipient, "error", result.Message)
+		}
+		if err := store.StoreBroadcastDelivery(broadcastID, listJID, recipient, result, time.Now()); err != nil {
+			slog.WarnContext(ctx, "Failed to store broadcast delivery", "broadcast_id", broadcastID, "recipient", recipient, "error", err)
+		}
+	}
+
+	if sent == 0 {
+		return scheduler.SendResult{Message: fmt.Sprintf("Failed to send to any of the %d recipients of %s: %s", len(recipients), listJID, lastError)}
+	}
+	return scheduler.SendResult{
+		Success:   true,
+		Message:   fmt.Sprintf("Message sent to %d of %d recipients of %s", sent, len(recipients), listJID),
+		MessageID: broadcastID,
+		ChatJID:   listJID,
+		Timestamp: time.Now(),
+	}
+}
+
+// registerBroadcastHandlers adds the endpoints for broadcast lists. Messages
+// are sent to a list through POST /v1/send and POST /v1/schedule with the
+// list's JID as the recipient.
+func registerBroadcastHandlers(mux *http.ServeMux, store *MessageStore) {
+	// GET /v1/broadcasts - The broadcast lists the bridge knows and their
+	// recipients
+	mux.HandleFunc("GET /v1/broadcasts", func(w http.ResponseWriter, r *http.Request) {
+		lists, err := store.GetBroadcastLists()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to read broadcast lists", "error", err)
+			apierror.Internal(w, "Failed to read broadcast lists")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    true,
+			"broadcasts": lists,
+		})
+	})
+
+	// PUT /v1/broadcasts/{jid} - Set who is on a broadcast list, for a list the
+	// bridge hasn't seen the phone send to, or a new one only the bridge uses
+	mux.HandleFunc("PUT /v1/broadcasts/{jid}", func(w http.ResponseWriter, r *http.Request) {
+		var req SetBroadcastRequest
+		var v validate.Validator
+		listJID := r.PathValue("jid")
+		v.JID("jid", listJID)
+		if !v.Failed("jid") && !scheduler.IsBroadcastList(listJID) {
+			v.Add("jid", validate.CodeInvalidValue, "must be a broadcast list JID, ending in @broadcast")
+		}
+		if v.Decode(r, &req) {
+			switch {
+			case len(req.Recipients) == 0:
+				v.Add("recipients", validate.CodeRequired, "is required")
+			case len(req.Recipients) > maxBroadcastRecipients:
+				v.Add("recipients", validate.CodeTooLong, fmt.Sprintf("must have at most %d recipients", maxBroadcastRecipients))
+			}
+			for i, recipient := range req.Recipients {
+				field := fmt.Sprintf("recipients.%d", i)
+				recipient = v.Recipient(field, recipient)
+				if v.Failed(field) {
+					continue
+				}
+				jid, _ := parseRecipient(recipient)
+				if jid.Server != types.DefaultUserServer && jid.Server != types.HiddenUserServer {
+					v.Add(field, validate.CodeInvalidValue, "must be a person, not a group or channel")
+					continue
+				}
+				req.Recipients[i] = jid.String()
+			}
+		}
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+
+		updatedAt := time.Now()
+		if err := store.StoreBroadcastList(listJID, req.Recipients, updatedAt); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to store broadcast list", "error", err)
+			apierror.Internal(w, "Failed to store broadcast list")
+			return
+		}
+		slog.InfoContext(r.Context(), "Set broadcast list", "chat_jid", listJID, "recipients", len(req.Recipients))
+		audit.SetResource(r.Context(), listJID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    true,
+			"jid":        listJID,
+			"recipients": req.Recipients,
+			"updated_at": updatedAt,
+		})
+	})
+
+	// GET /v1/broadcasts/{jid}/messages/{id} - Each recipient's copy of a
+	// message sent to a broadcast list, by the message ID the send returned,
+	// and whether it was delivered and read
+	mux.HandleFunc("GET /v1/broadcasts/{jid}/messages/{id}", func(w http.ResponseWriter, r *http.Request) {
+		listJID := r.PathValue("jid")
+		broadcastID := r.PathValue("id")
+
+		deliveries, err := store.GetBroadcastDeliveries(broadcastID, listJID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to read broadcast deliveries", "error", err)
+			apierror.Internal(w, "Failed to read broadcast deliveries")
+			return
+		}
+		if len(deliveries) == 0 {
+			apierror.NotFound(w, "Broadcast message not found")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    true,
+			"message_id": broadcastID,
+			"chat_jid":   listJID,
+			"deliveries": deliveries,
+		})
+	})
+}
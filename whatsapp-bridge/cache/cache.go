@@ -0,0 +1,75 @@
+// Package cache keeps the results of expensive list queries for a short time,
+// so clients polling the API don't each run the query against the database.
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// maxEntries bounds the number of cached results. Each filter combination and
+// page is its own entry; when the cache is full, expired entries are dropped,
+// and everything if none has expired.
+const maxEntries = 256
+
+// Cache holds query results by key for a TTL, or until the data they were read
+// from changes. Changes are detected with a version the caller passes in, for
+// example a counter its store bumps after every write. Cached values are shared
+// between callers, who must not modify them.
+type Cache[V any] struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]entry[V]
+}
+
+type entry[V any] struct {
+	value   V
+	version uint64
+	expires time.Time
+}
+
+// New returns a cache keeping results for ttl. A ttl of 0 or less turns caching
+// off, so Get always runs the query.
+func New[V any](ttl time.Duration) *Cache[V] {
+	return &Cache[V]{ttl: ttl, entries: make(map[string]entry[V])}
+}
+
+// Get returns the result cached for key if it was read at version and hasn't
+// expired, and otherwise runs fill and caches its result. The version must be
+// read before the data fill reads, so a write racing with the query leaves the
+// result under the old version, where it isn't found again. Errors aren't
+// cached.
+func (c *Cache[V]) Get(key string, version uint64, fill func() (V, error)) (V, error) {
+	if c == nil || c.ttl <= 0 {
+		return fill()
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && e.version == version && now.Before(e.expires) {
+		return e.value, nil
+	}
+
+	value, err := fill()
+	if err != nil {
+		return value, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxEntries {
+		for k, old := range c.entries {
+			if !now.Before(old.expires) || old.version != version {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxEntries {
+			clear(c.entries)
+		}
+	}
+	c.entries[key] = entry[V]{value: value, version: version, expires: now.Add(c.ttl)}
+	return value, nil
+}
@@ -0,0 +1,550 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waBinary "go.mau.fi/whatsmeow/binary"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/audit"
+	"whatsapp-client/pagination"
+	"whatsapp-client/scheduler"
+	"whatsapp-client/validate"
+)
+
+// maxCatalogPage is the most products WhatsApp returns for one catalog query
+const maxCatalogPage = 100
+
+// maxCatalogSearch bounds how many products are looked through for one by ID
+const maxCatalogSearch = 1000
+
+// catalogImageSize is the size, in pixels, of the product images WhatsApp is
+// asked for
+const catalogImageSize = "800"
+
+// maxProductTextLength bounds the body and footer of a product message, in
+// characters
+const maxProductTextLength = 1024
+
+// Product is an item in a business's catalog
+type Product struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// PriceAmount1000 is the price times 1000 in Currency, as WhatsApp has it
+	PriceAmount1000 int64    `json:"price_amount_1000,omitempty"`
+	Currency        string   `json:"currency,omitempty"`
+	RetailerID      string   `json:"retailer_id,omitempty"`
+	URL             string   `json:"url,omitempty"`
+	ImageURLs       []string `json:"image_urls"`
+	// Hidden products are in the catalog but not shown to customers
+	Hidden bool `json:"hidden,omitempty"`
+	// ReviewStatus is where WhatsApp's review of the product is, like APPROVED
+	ReviewStatus string `json:"review_status,omitempty"`
+}
+
+// BusinessProfile is the public profile of a business account
+type BusinessProfile struct {
+	JID        string   `json:"jid"`
+	Address    string   `json:"address,omitempty"`
+	Email      string   `json:"email,omitempty"`
+	Categories []string `json:"categories"`
+	// HoursTimeZone is the time zone of Hours
+	HoursTimeZone string          `json:"hours_time_zone,omitempty"`
+	Hours         []BusinessHours `json:"hours"`
+}
+
+// BusinessHours are when a business is open on one day of the week
+type BusinessHours struct {
+	Day string `json:"day"`
+	// Mode is specific_hours, open_24h or appointment_only; OpenTime and
+	// CloseTime, in minutes after midnight, only apply to specific_hours
+	Mode      string `json:"mode"`
+	OpenTime  string `json:"open_time,omitempty"`
+	CloseTime string `json:"close_time,omitempty"`
+}
+
+// catalogCursor is the page of a catalog to get next, as WhatsApp names it
+type catalogCursor struct {
+	After string `json:"after"`
+}
+
+// SendProductRequest is the body of POST /v1/send/product
+type SendProductRequest struct {
+	Recipient string `json:"recipient"`
+	// Business owns the catalog the product is in, the bridge's own account if
+	// unset
+	Business  string `json:"business,omitempty"`
+	ProductID string `json:"product_id"`
+	// Body and Footer are shown with the product card
+	Body   string `json:"body,omitempty"`
+	Footer string `json:"footer,omitempty"`
+	scheduler.SendOptions
+}
+
+// validate checks the request and normalizes the recipient and business
+func (req *SendProductRequest) validate(v *validate.Validator) {
+	req.Recipient = v.Recipient("recipient", req.Recipient)
+	req.SendOptions.Validate(v, req.Recipient)
+	validateCatalogRecipient(v, req.Recipient)
+	req.ValidateNotText(v, "product messages")
+	req.ValidateViewOnce(v, "")
+	if len(req.Mentions) > 0 {
+		v.Add("mentions", validate.CodeInvalidValue, "must be empty, product messages can't mention anyone")
+	}
+	if req.Business != "" {
+		req.Business = v.Recipient("business", req.Business)
+	}
+	v.Required("product_id", req.ProductID)
+	v.MaxLength("body", req.Body, maxProductTextLength)
+	v.MaxLength("footer", req.Footer, maxProductTextLength)
+}
+
+// SendCatalogRequest is the body of POST /v1/send/catalog
+type SendCatalogRequest struct {
+	Recipient string `json:"recipient"`
+	// Business owns the catalog, the bridge's own account if unset
+	Business string `json:"business,omitempty"`
+	// Message is sent before the link to the catalog
+	Message string `json:"message,omitempty"`
+	scheduler.SendOptions
+}
+
+// validate checks the request and normalizes the recipient and business
+func (req *SendCatalogRequest) validate(v *validate.Validator) {
+	req.Recipient = v.Recipient("recipient", req.Recipient)
+	req.SendOptions.Validate(v, req.Recipient)
+	validateCatalogRecipient(v, req.Recipient)
+	req.ValidateViewOnce(v, "")
+	if req.Business != "" {
+		req.Business = v.Recipient("business", req.Business)
+	}
+	v.MaxLength("message", req.Message, validate.MaxMessageLength)
+}
+
+// validateCatalogRecipient fails the recipients products and catalogs can't
+// be sent to
+func validateCatalogRecipient(v *validate.Validator, recipient string) {
+	switch {
+	case scheduler.IsStatus(recipient):
+		v.Add("recipient", validate.CodeInvalidValue, "can't be the status, status updates are only text, photos and videos")
+	case scheduler.IsNewsletter(recipient):
+		v.Add("recipient", validate.CodeInvalidValue, "can't be a channel, channel posts can't carry products")
+	}
+}
+
+// catalogBusiness is the business a request names, or the bridge's own
+// account when it names none
+func catalogBusiness(client *whatsmeow.Client, business string) (types.JID, error) {
+	if business != "" {
+		return parseRecipient(business)
+	}
+	if client.Store.ID == nil {
+		return types.EmptyJID, fmt.Errorf("not logged in to WhatsApp")
+	}
+	return client.Store.ID.ToNonAD(), nil
+}
+
+// childText is the text content of node's first child with tag, or "" if it
+// has none
+func childText(node waBinary.Node, tag string) string {
+	content, _ := node.GetChildByTag(tag).Content.([]byte)
+	return string(content)
+}
+
+// getCatalog gets a page of up to limit products of business's catalog, from
+// the page named after on. It also returns the name of the next page, "" on
+// the last. whatsmeow has no catalog query, so this sends the one WhatsApp
+// apps do.
+func getCatalog(ctx context.Context, client *whatsmeow.Client, business types.JID, limit int, after string) ([]Product, string, error) {
+	params := []waBinary.Node{
+		{Tag: "limit", Content: []byte(strconv.Itoa(limit))},
+		{Tag: "width", Content: []byte(catalogImageSize)},
+		{Tag: "height", Content: []byte(catalogImageSize)},
+	}
+	if after != "" {
+		params = append(params, waBinary.Node{Tag: "after", Content: []byte(after)})
+	}
+	resp, err := client.DangerousInternals().SendIQ(whatsmeow.DangerousInfoQuery{
+		Namespace: "w:biz:catalog",
+		Type:      "get",
+		To:        types.ServerJID,
+		Context:   ctx,
+		Content: []waBinary.Node{{
+			Tag:     "product_catalog",
+			Attrs:   waBinary.Attrs{"jid": business, "allow_shop_source": "true"},
+			Content: params,
+		}},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return parseCatalog(resp)
+}
+
+// parseCatalog reads the products, and the name of the next page, out of
+// WhatsApp's response to a catalog query
+func parseCatalog(resp *waBinary.Node) ([]Product, string, error) {
+	catalog, ok := resp.GetOptionalChildByTag("product_catalog")
+	if !ok {
+		return nil, "", fmt.Errorf("no catalog in WhatsApp's response")
+	}
+
+	products := []Product{}
+	for _, node := range catalog.GetChildrenByTag("product") {
+		product := Product{
+			ID:           childText(node, "id"),
+			Name:         childText(node, "name"),
+			Description:  childText(node, "description"),
+			Currency:     childText(node, "currency"),
+			RetailerID:   childText(node, "retailer_id"),
+			URL:          childText(node, "url"),
+			ImageURLs:    []string{},
+			Hidden:       node.AttrGetter().OptionalString("is_hidden") == "true",
+			ReviewStatus: childText(node.GetChildByTag("status_info"), "status"),
+		}
+		product.PriceAmount1000, _ = strconv.ParseInt(childText(node, "price"), 10, 64)
+		media := node.GetChildByTag("media")
+		for _, image := range media.GetChildrenByTag("image") {
+			if url := childText(image, "request_image_url"); url != "" {
+				product.ImageURLs = append(product.ImageURLs, url)
+			}
+		}
+		products = append(products, product)
+	}
+	next := ""
+	if paging, ok := catalog.GetOptionalChildByTag("paging"); ok {
+		next = childText(paging, "after")
+	}
+	return products, next, nil
+}
+
+// findProduct looks through business's catalog for the product with id. It
+// returns nil, and no error, when the catalog has no such product.
+func findProduct(ctx context.Context, client *whatsmeow.Client, business types.JID, id string) (*Product, error) {
+	after := ""
+	for seen := 0; seen < maxCatalogSearch; {
+		products, next, err := getCatalog(ctx, client, business, maxCatalogPage, after)
+		if err != nil {
+			return nil, err
+		}
+		for i := range products {
+			if products[i].ID == id {
+				return &products[i], nil
+			}
+		}
+		seen += len(products)
+		if next == "" || len(products) == 0 {
+			break
+		}
+		after = next
+	}
+	return nil, nil
+}
+
+// productMessage builds the card for a product of business, with its first
+// image. The image is fetched from WhatsApp and uploaded again, as message
+// media must be.
+func productMessage(ctx context.Context, client *whatsmeow.Client, business types.JID, product Product, body, footer string) (*waProto.Message, error) {
+	if len(product.ImageURLs) == 0 {
+		return nil, fmt.Errorf("product %s has no image, which product messages need", product.ID)
+	}
+	media, _, err := readMedia(ctx, nil, "", "", product.ImageURLs[0], mediaSizeLimits[mediaImage])
+	if err == nil {
+		err = prepareMedia(ctx, mediaImage, media)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("product image %v", err)
+	}
+	resp, err := client.Upload(ctx, media.data, whatsmeow.MediaImage)
+	if err != nil {
+		return nil, fmt.Errorf("error uploading product image: %v", err)
+	}
+
+	image := &waProto.ImageMessage{
+		Mimetype:      proto.String(media.mimetype),
+		URL:           &resp.URL,
+		DirectPath:    &resp.DirectPath,
+		MediaKey:      resp.MediaKey,
+		FileEncSHA256: resp.FileEncSHA256,
+		FileSHA256:    resp.FileSHA256,
+		FileLength:    &resp.FileLength,
+		JPEGThumbnail: media.thumbnail,
+	}
+	if media.width > 0 {
+		image.Width = proto.Uint32(media.width)
+		image.Height = proto.Uint32(media.height)
+	}
+	snapshot := &waProto.ProductMessage_ProductSnapshot{
+		ProductImage:      image,
+		ProductID:         proto.String(product.ID),
+		Title:             proto.String(product.Name),
+		Description:       proto.String(product.Description),
+		CurrencyCode:      proto.String(product.Currency),
+		PriceAmount1000:   proto.Int64(product.PriceAmount1000),
+		RetailerID:        proto.String(product.RetailerID),
+		URL:               proto.String(product.URL),
+		ProductImageCount: proto.Uint32(uint32(len(product.ImageURLs))),
+	}
+	msg := &waProto.ProductMessage{
+		Product:          snapshot,
+		BusinessOwnerJID: proto.String(business.String()),
+	}
+	if body != "" {
+		msg.Body = proto.String(body)
+	}
+	if footer != "" {
+		msg.Footer = proto.String(footer)
+	}
+	return &waProto.Message{ProductMessage: msg}, nil
+}
+
+// productSummary is the text stored for a product or catalog message, so it
+// shows up in message lists like any other
+func productSummary(msg *waProto.ProductMessage) string {
+	if product := msg.GetProduct(); product != nil {
+		return "Product: " + product.GetTitle()
+	}
+	return "Catalog: " + msg.GetCatalog().GetTitle()
+}
+
+// catalogLink is the link WhatsApp apps open as business's catalog
+func catalogLink(business types.JID) string {
+	return "https://wa.me/c/" + business.User
+}
+
+// registerCatalogHandlers adds the endpoints for reading business profiles
+// and catalogs, and for sending products and catalogs
+func registerCatalogHandlers(mux *http.ServeMux, client *whatsmeow.Client, store *MessageStore) {
+	// businessJID reads the business account at the {jid} of the path. When
+	// it returns false, the error response has been written.
+	businessJID := func(w http.ResponseWriter, r *http.Request) (types.JID, bool) {
+		var v validate.Validator
+		business := v.Recipient("jid", r.PathValue("jid"))
+		if !v.Valid() {
+			v.Write(w)
+			return types.EmptyJID, false
+		}
+		jid, err := parseRecipient(business)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return types.EmptyJID, false
+		}
+		if !client.IsConnected() {
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not connected to WhatsApp")
+			return types.EmptyJID, false
+		}
+		return jid, true
+	}
+
+	// GET /v1/business/{jid} - The profile of a business account: address,
+	// email, categories and opening hours
+	mux.HandleFunc("GET /v1/business/{jid}", func(w http.ResponseWriter, r *http.Request) {
+		jid, ok := businessJID(w, r)
+		if !ok {
+			return
+		}
+		profile, err := client.GetBusinessProfile(jid)
+		if err != nil {
+			slog.WarnContext(r.Context(), "Failed to get business profile", "jid", jid, "error", err)
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, "Failed to get business profile: "+err.Error())
+			return
+		}
+
+		business := BusinessProfile{
+			JID:           profile.JID.String(),
+			Address:       profile.Address,
+			Email:         profile.Email,
+			Categories:    make([]string, 0, len(profile.Categories)),
+			HoursTimeZone: profile.BusinessHoursTimeZone,
+			Hours:         make([]BusinessHours, 0, len(profile.BusinessHours)),
+		}
+		for _, category := range profile.Categories {
+			business.Categories = append(business.Categories, category.Name)
+		}
+		for _, hours := range profile.BusinessHours {
+			business.Hours = append(business.Hours, BusinessHours{
+				Day:       hours.DayOfWeek,
+				Mode:      hours.Mode,
+				OpenTime:  hours.OpenTime,
+				CloseTime: hours.CloseTime,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"business": business,
+		})
+	})
+
+	// GET /v1/business/{jid}/catalog - The products of a business's catalog,
+	// in the business's order, a page of at most 100 at a time
+	mux.HandleFunc("GET /v1/business/{jid}/catalog", func(w http.ResponseWriter, r *http.Request) {
+		page, err := pagination.FromRequest(r)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+		var cursor catalogCursor
+		if _, err := page.Decode(&cursor); err != nil {
+			apierror.BadRequest(w, "Invalid cursor")
+			return
+		}
+		jid, ok := businessJID(w, r)
+		if !ok {
+			return
+		}
+
+		products, after, err := getCatalog(r.Context(), client, jid, min(page.Limit, maxCatalogPage), cursor.After)
+		if err != nil {
+			slog.WarnContext(r.Context(), "Failed to get catalog", "jid", jid, "error", err)
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, "Failed to get catalog: "+err.Error())
+			return
+		}
+		next := ""
+		if after != "" && len(products) > 0 {
+			next = pagination.Encode(catalogCursor{After: after})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":     true,
+			"business":    jid.String(),
+			"products":    products,
+			"next_cursor": next,
+		})
+	})
+
+	// POST /v1/send/product - Send a product of a business's catalog as a
+	// card with its image, name and price
+	mux.HandleFunc("POST /v1/send/product", func(w http.ResponseWriter, r *http.Request) {
+		var req SendProductRequest
+		var v validate.Validator
+		if v.Decode(r, &req) {
+			req.validate(&v)
+		}
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		contextInfo, ok := requestContext(w, r, store, client, req.Recipient, req.SendOptions)
+		if !ok {
+			return
+		}
+
+		if !client.IsConnected() {
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not connected to WhatsApp")
+			return
+		}
+		business, err := catalogBusiness(client, req.Business)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+
+		// A client hanging up must not abort a send that is under way
+		ctx := context.WithoutCancel(r.Context())
+		start := time.Now()
+		product, err := findProduct(ctx, client, business, req.ProductID)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to get catalog", "business", business, "error", err)
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, "Failed to get catalog: "+err.Error())
+			return
+		}
+		if product == nil {
+			apierror.NotFound(w, fmt.Sprintf("Product %s not found in the catalog of %s", req.ProductID, business.User))
+			return
+		}
+		msg, err := productMessage(ctx, client, business, *product, req.Body, req.Footer)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to build product message", "product_id", req.ProductID, "error", err)
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, err.Error())
+			return
+		}
+		setContextInfo(msg, contextInfo)
+		result := sendBuilt(ctx, client, req.Recipient, "product", msg)
+		latency := time.Since(start)
+		storeSent(ctx, client, store, result, msg)
+		if !result.Success {
+			slog.WarnContext(ctx, "Failed to send product", "recipient", req.Recipient, "product_id", req.ProductID, "status", "failed", "latency", latency, "error", result.Message)
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, result.Message)
+			return
+		}
+
+		slog.InfoContext(ctx, "Sent product", "whatsapp_message_id", result.MessageID, "recipient", req.Recipient, "product_id", req.ProductID, "status", "sent", "latency", latency)
+		audit.SetResource(r.Context(), result.MessageID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(SendMessageResponse{
+			Success:   true,
+			Message:   result.Message,
+			MessageID: result.MessageID,
+			ChatJID:   result.ChatJID,
+		})
+	})
+
+	// POST /v1/send/catalog - Send the link to a business's whole catalog,
+	// which WhatsApp apps open as the catalog
+	mux.HandleFunc("POST /v1/send/catalog", func(w http.ResponseWriter, r *http.Request) {
+		var req SendCatalogRequest
+		var v validate.Validator
+		if v.Decode(r, &req) {
+			req.validate(&v)
+		}
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		contextInfo, ok := requestContext(w, r, store, client, req.Recipient, req.SendOptions)
+		if !ok {
+			return
+		}
+
+		if !client.IsConnected() {
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not connected to WhatsApp")
+			return
+		}
+		business, err := catalogBusiness(client, req.Business)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+
+		text := catalogLink(business)
+		if req.Message != "" {
+			text = req.Message + "\n" + text
+		}
+		// A client hanging up must not abort a send that is under way
+		ctx := context.WithoutCancel(r.Context())
+		start := time.Now()
+		result := sendWhatsAppMessage(ctx, client, store, req.Recipient, text, "", req.SendOptions, contextInfo)
+		latency := time.Since(start)
+		if !result.Success {
+			slog.WarnContext(ctx, "Failed to send catalog", "recipient", req.Recipient, "business", business, "status", "failed", "latency", latency, "error", result.Message)
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, result.Message)
+			return
+		}
+
+		slog.InfoContext(ctx, "Sent catalog", "whatsapp_message_id", result.MessageID, "recipient", req.Recipient, "business", business, "status", "sent", "latency", latency)
+		audit.SetResource(r.Context(), result.MessageID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(SendMessageResponse{
+			Success:   true,
+			Message:   result.Message,
+			MessageID: result.MessageID,
+			ChatJID:   result.ChatJID,
+		})
+	})
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/pagination"
+	"whatsapp-client/scheduler"
+	"whatsapp-client/validate"
+)
+
+// Types of chat, stored with each chat
+const (
+	chatIndividual = "individual"
+	chatGroup      = "group"
+	chatBroadcast  = "broadcast"
+	chatNewsletter = "newsletter"
+	// chatStatus is the one chat holding everyone's status updates
+	chatStatus = "status"
+)
+
+// statusChatName is the name of the chat holding status updates
+const statusChatName = "Status updates"
+
+// chatType is the type of the chat with JID jid
+func chatType(jid string) string {
+	switch parsed, _ := types.ParseJID(jid); {
+	case scheduler.IsStatus(jid):
+		return chatStatus
+	case parsed.Server == types.GroupServer:
+		return chatGroup
+	case parsed.Server == types.BroadcastServer:
+		return chatBroadcast
+	case parsed.Server == types.NewsletterServer:
+		return chatNewsletter
+	default:
+		return chatIndividual
+	}
+}
+
+// migrateChats adds the type column to a chats table made before chats had
+// types, and sets the type of the chats stored without one
+func migrateChats(db *sql.DB) error {
+	var hasType bool
+	if err := db.QueryRow("SELECT COUNT(*) > 0 FROM pragma_table_info('chats') WHERE name = 'type'").Scan(&hasType); err != nil {
+		return err
+	}
+	if !hasType {
+		if _, err := db.Exec("ALTER TABLE chats ADD COLUMN type TEXT"); err != nil {
+			return err
+		}
+	}
+
+	rows, err := db.Query("SELECT jid FROM chats WHERE type IS NULL")
+	if err != nil {
+		return err
+	}
+	var jids []string
+	for rows.Next() {
+		var jid string
+		if err := rows.Scan(&jid); err != nil {
+			rows.Close()
+			return err
+		}
+		jids = append(jids, jid)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, jid := range jids {
+		if _, err := db.Exec("UPDATE chats SET type = ? WHERE jid = ?", chatType(jid), jid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maxPreviewLength bounds the text of the last message shown with a chat, in
+// characters
+const maxPreviewLength = 100
+
+// ChatSummary is a chat as listed, with its latest message
+type ChatSummary struct {
+	JID  string `json:"jid"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// LastMessageTime is when the latest message was sent, nil for a chat
+	// without messages
+	LastMessageTime *time.Time `json:"last_message_time,omitempty"`
+	// LastMessage is the latest message stored, with its text cut to
+	// maxPreviewLength
+	LastMessage *MessagePreview `json:"last_message,omitempty"`
+	// UnreadCount is how many messages others sent that the account hasn't
+	// read
+	UnreadCount int  `json:"unread_count"`
+	Archived    bool `json:"archived"`
+	Muted       bool `json:"muted"`
+	// MutedUntil is nil for a chat muted until it is unmuted
+	MutedUntil *time.Time `json:"muted_until,omitempty"`
+}
+
+// MessagePreview is a message shown with the chat it is in
+type MessagePreview struct {
+	ID        string    `json:"id"`
+	Sender    string    `json:"sender"`
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"`
+	IsFromMe  bool      `json:"is_from_me"`
+	MediaType string    `json:"media_type,omitempty"`
+}
+
+// ChatFilter narrows a chat list
+type ChatFilter struct {
+	// Type keeps the chats of one type, when set
+	Type string
+	// Archived keeps the archived chats, or those not archived, when set
+	Archived *bool
+}
+
+// chatsCursor is the sort key of the last chat of a page
+type chatsCursor struct {
+	// LastMessageTime is nil for a chat without messages, which come last
+	LastMessageTime *time.Time `json:"t,omitempty"`
+	JID             string     `json:"j"`
+}
+
+// preview cuts text to maxPreviewLength characters
+func preview(text string) string {
+	runes := []rune(text)
+	if len(runes) <= maxPreviewLength {
+		return text
+	}
+	return string(runes[:maxPreviewLength-1]) + "…"
+}
+
+// StoreArchive records whether a chat is archived. A setting older than the
+// one stored is ignored.
+func (store *MessageStore) StoreArchive(chatJID string, archived bool, setAt time.Time) error {
+	_, err := store.db.Exec(
+		`INSERT INTO chat_archives (chat_jid, archived, set_at) VALUES (?, ?, ?)
+		ON CONFLICT (chat_jid) DO UPDATE SET archived = excluded.archived, set_at = excluded.set_at
+		WHERE excluded.set_at >= chat_archives.set_at`,
+		chatJID, archived, setAt,
+	)
+	return err
+}
+
+// handleArchive records a chat archived or unarchived on any of the account's
+// devices
+func handleArchive(store *MessageStore, archive *events.Archive) {
+	if err := store.StoreArchive(archive.JID.String(), archive.Action.GetArchived(), archive.Timestamp); err != nil {
+		slog.Warn("Failed to store archive", "chat_jid", archive.JID.String(), "error", err)
+	}
+}
+
+// ListChats returns the stored chats, latest active first, with their latest
+// message, unread count and archive and mute settings at now
+func (store *MessageStore) ListChats(filter ChatFilter, now time.Time, after *chatsCursor, limit int) ([]ChatSummary, error) {
+	query := `SELECT c.jid, c.name, c.type, c.last_message_time,
+		m.id, m.sender, m.content, m.timestamp, m.is_from_me, m.media_type,
+		(SELECT COUNT(*) FROM messages u WHERE u.chat_jid = c.jid AND ` + unreadCondition + `),
+		COALESCE(a.archived, 0), mu.muted, mu.muted_until
+		FROM chats c
+		LEFT JOIN messages m ON m.rowid = (SELECT rowid FROM messages WHERE chat_jid = c.jid ORDER BY timestamp DESC LIMIT 1)
+		LEFT JOIN chat_archives a ON a.chat_jid = c.jid
+		LEFT JOIN chat_mutes mu ON mu.chat_jid = c.jid
+		WHERE 1 = 1`
+	var args []interface{}
+	if filter.Type != "" {
+		query += " AND c.type = ?"
+		args = append(args, filter.Type)
+	}
+	if filter.Archived != nil {
+		query += " AND COALESCE(a.archived, 0) = ?"
+		args = append(args, *filter.Archived)
+	}
+	switch {
+	case after == nil:
+	case after.LastMessageTime == nil:
+		query += " AND c.last_message_time IS NULL AND c.jid > ?"
+		args = append(args, after.JID)
+	default:
+		query += ` AND (c.last_message_time < ? OR c.last_message_time IS NULL
+			OR (c.last_message_time = ? AND c.jid > ?))`
+		args = append(args, *after.LastMessageTime, *after.LastMessageTime, after.JID)
+	}
+	// NULLs sort last when descending
+	query += " ORDER BY c.last_message_time DESC, c.jid LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chats := []ChatSummary{}
+	for rows.Next() {
+		var chat ChatSummary
+		var name, chatKind, id, sender, content, mediaType sql.NullString
+		var lastMessageTime, timestamp, mutedUntil sql.NullTime
+		var isFromMe, muted sql.NullBool
+		if err := rows.Scan(&chat.JID, &name, &chatKind, &lastMessageTime,
+			&id, &sender, &content, &timestamp, &isFromMe, &mediaType,
+			&chat.UnreadCount, &chat.Archived, &muted, &mutedUntil); err != nil {
+			return nil, err
+		}
+		chat.Name, chat.Type = name.String, chatKind.String
+		if chat.Type == "" {
+			chat.Type = chatType(chat.JID)
+		}
+		if lastMessageTime.Valid {
+			chat.LastMessageTime = &lastMessageTime.Time
+		}
+		if id.Valid {
+			chat.LastMessage = &MessagePreview{
+				ID:        id.String,
+				Sender:    sender.String,
+				Content:   preview(content.String),
+				Timestamp: timestamp.Time,
+				IsFromMe:  isFromMe.Bool,
+				MediaType: mediaType.String,
+			}
+		}
+		// Like GetMute, a mute that ran out isn't one
+		if muted.Bool && (!mutedUntil.Valid || mutedUntil.Time.After(now)) {
+			chat.Muted = true
+			if mutedUntil.Valid {
+				chat.MutedUntil = &mutedUntil.Time
+			}
+		}
+		chats = append(chats, chat)
+	}
+	return chats, rows.Err()
+}
+
+// registerChatHandlers adds the endpoint listing chats
+func registerChatHandlers(mux *http.ServeMux, store *MessageStore) {
+	// GET /v1/chats - The stored chats, latest active first, a page at a time,
+	// each with its latest message and unread count. ?type= keeps the chats of
+	// one type, ?archived=true|false the archived chats or the others.
+	mux.HandleFunc("GET /v1/chats", func(w http.ResponseWriter, r *http.Request) {
+		page, err := pagination.FromRequest(r)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+		var v validate.Validator
+		var filter ChatFilter
+		filter.Type = r.URL.Query().Get("type")
+		if filter.Type != "" {
+			v.OneOf("type", filter.Type, chatIndividual, chatGroup, chatBroadcast, chatNewsletter, chatStatus)
+		}
+		if archived := r.URL.Query().Get("archived"); archived != "" {
+			v.OneOf("archived", archived, "true", "false")
+			filter.Archived = proto.Bool(archived == "true")
+		}
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		var cursor *chatsCursor
+		var decoded chatsCursor
+		if ok, err := page.Decode(&decoded); err != nil {
+			apierror.BadRequest(w, "Invalid cursor")
+			return
+		} else if ok {
+			cursor = &decoded
+		}
+
+		chats, err := store.ListChats(filter, time.Now(), cursor, page.Limit+1)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to get chats", "error", err)
+			apierror.Internal(w, "Failed to get chats")
+			return
+		}
+		chats, next := pagination.Page(chats, page, func(chat ChatSummary) interface{} {
+			return chatsCursor{LastMessageTime: chat.LastMessageTime, JID: chat.JID}
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":     true,
+			"chats":       chats,
+			"next_cursor": next,
+		})
+	})
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// scheduledMessage holds the fields of a scheduled message the CLI shows
+type scheduledMessage struct {
+	ID               string          `json:"id"`
+	Recipient        string          `json:"recipient"`
+	Message          string          `json:"message"`
+	ScheduledTime    time.Time       `json:"scheduled_time"`
+	CreatedAt        time.Time       `json:"created_at"`
+	CheckForResponse bool            `json:"check_for_response"`
+	Status           string          `json:"status"`
+	SentAt           *time.Time      `json:"sent_at"`
+	ErrorMessage     *string         `json:"error_message"`
+	DeliveryMode     string          `json:"delivery_mode"`
+	Precision        string          `json:"precision"`
+	Metadata         json.RawMessage `json:"metadata"`
+	CreatedBy        string          `json:"created_by"`
+}
+
+// chatMessage holds the fields of a stored chat message the CLI shows
+type chatMessage struct {
+	ID        string    `json:"id"`
+	Sender    string    `json:"sender"`
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"`
+	IsFromMe  bool      `json:"is_from_me"`
+	Media     *struct {
+		Type     string `json:"type"`
+		Filename string `json:"filename"`
+	} `json:"media"`
+	Revoked *struct{} `json:"revoked"`
+}
+
+// newFlags returns the flag set of a subcommand
+func newFlags(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: wa-bridge-cli %s\n", commands[name].usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// oneID parses the arguments of the commands that take a single message ID
+func oneID(name string, args []string) (string, error) {
+	flags := newFlags(name)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return "", fmt.Errorf("usage: wa-bridge-cli %s", commands[name].usage)
+	}
+	return flags.Arg(0), nil
+}
+
+// runStatus prints the readiness of each component. It fails when the bridge
+// isn't ready, so it can gate scripts.
+func runStatus(c *client, args []string) error {
+	newFlags("status").Parse(args)
+
+	status, data, err := c.send(http.MethodGet, "/readyz", nil, nil)
+	if err != nil {
+		return err
+	}
+	var ready struct {
+		Status     string `json:"status"`
+		Components map[string]struct {
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(data, &ready); err != nil {
+		return fmt.Errorf("HTTP %d: %s", status, strings.TrimSpace(string(data)))
+	}
+
+	if c.json {
+		c.printJSON(data)
+	} else {
+		names := make([]string, 0, len(ready.Components))
+		for name := range ready.Components {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+		for _, name := range names {
+			component := ready.Components[name]
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", name, component.Status, component.Error)
+		}
+		tw.Flush()
+	}
+
+	if ready.Status != "ok" {
+		return errors.New("bridge is not ready")
+	}
+	return nil
+}
+
+func runSend(c *client, args []string) error {
+	flags := newFlags("send")
+	media := flags.String("media", "", "file on the bridge host to send, with the message as caption")
+	flags.Parse(args)
+	if flags.NArg() < 1 || (flags.NArg() < 2 && *media == "") {
+		return fmt.Errorf("usage: wa-bridge-cli %s", commands["send"].usage)
+	}
+
+	var resp struct {
+		MessageID string `json:"message_id"`
+		ChatJID   string `json:"chat_jid"`
+	}
+	err := c.do(http.MethodPost, "/v1/send", nil, map[string]string{
+		"recipient":  flags.Arg(0),
+		"message":    strings.Join(flags.Args()[1:], " "),
+		"media_path": *media,
+	}, &resp)
+	if err != nil {
+		return err
+	}
+	if !c.json {
+		fmt.Fprintf(c.out, "Sent %s to %s\n", resp.MessageID, resp.ChatJID)
+	}
+	return nil
+}
+
+func runSchedule(c *client, args []string) error {
+	flags := newFlags("schedule")
+	at := flags.String("at", "", "send time, RFC 3339 (2025-10-06T15:00:00Z) or local \"2006-01-02 15:04\"")
+	in := flags.Duration("in", 0, "send after this long, e.g. 90m")
+	checkResponse := flags.Bool("check-response", false, "pause the message if the recipient writes first")
+	online := flags.Int("online-window", 0, "send when the recipient is online during this many minutes before the time")
+	precise := flags.Bool("precise", false, "send within seconds of the time")
+	metadata := flags.String("metadata", "", "JSON stored with the message")
+	flags.Parse(args)
+	if flags.NArg() < 2 || (*at == "") == (*in == 0) {
+		return fmt.Errorf("usage: wa-bridge-cli %s", commands["schedule"].usage)
+	}
+
+	scheduledTime := time.Now().Add(*in)
+	if *at != "" {
+		var err error
+		if scheduledTime, err = parseTime(*at); err != nil {
+			return err
+		}
+	}
+
+	body := map[string]interface{}{
+		"recipient":          flags.Arg(0),
+		"message":            strings.Join(flags.Args()[1:], " "),
+		"scheduled_time":     scheduledTime.UTC().Format(time.RFC3339),
+		"check_for_response": *checkResponse,
+	}
+	if *online > 0 {
+		body["delivery_mode"] = "online"
+		body["online_window_minutes"] = *online
+	}
+	if *precise {
+		body["precision"] = "precise"
+	}
+	if *metadata != "" {
+		if !json.Valid([]byte(*metadata)) {
+			return errors.New("-metadata must be valid JSON")
+		}
+		body["metadata"] = json.RawMessage(*metadata)
+	}
+
+	var resp struct {
+		Message *scheduledMessage `json:"scheduled_message"`
+	}
+	if err := c.do(http.MethodPost, "/v1/schedule", nil, body, &resp); err != nil {
+		return err
+	}
+	if !c.json && resp.Message != nil {
+		fmt.Fprintf(c.out, "Scheduled %s for %s\n", resp.Message.ID, resp.Message.ScheduledTime.Local().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// parseTime reads an RFC 3339 time, or a local date and time without zone
+func parseTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02 15:04:05"} {
+		if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, use RFC 3339 or \"2006-01-02 15:04\"", v)
+}
+
+func runList(c *client, args []string) error {
+	flags := newFlags("list")
+	status := flags.String("status", "", "only messages with this status")
+	recipient := flags.String("recipient", "", "only messages to this recipient")
+	createdBy := flags.String("created-by", "", "only messages scheduled by this API key or client")
+	limit := flags.Int("limit", 0, "page size (the bridge's default when 0)")
+	all := flags.Bool("all", false, "follow next_cursor and print every page")
+	flags.Parse(args)
+
+	query := url.Values{}
+	for key, value := range map[string]string{"status": *status, "recipient": *recipient, "created_by": *createdBy} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	if *limit > 0 {
+		query.Set("limit", strconv.Itoa(*limit))
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	if !c.json {
+		fmt.Fprintln(tw, "ID\tSTATUS\tSCHEDULED\tRECIPIENT\tMESSAGE")
+	}
+	for {
+		var page struct {
+			Messages   []scheduledMessage `json:"messages"`
+			NextCursor string             `json:"next_cursor"`
+		}
+		if err := c.do(http.MethodGet, "/v1/scheduled", query, nil, &page); err != nil {
+			return err
+		}
+		if !c.json {
+			for _, msg := range page.Messages {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", msg.ID, msg.Status, msg.ScheduledTime.Local().Format("2006-01-02 15:04"), msg.Recipient, truncate(msg.Message, 40))
+			}
+		}
+		if !*all || page.NextCursor == "" {
+			if !c.json && page.NextCursor != "" {
+				fmt.Fprintln(os.Stderr, "More messages available, use -all to list them")
+			}
+			break
+		}
+		query.Set("cursor", page.NextCursor)
+	}
+	return tw.Flush()
+}
+
+func runHistory(c *client, args []string) error {
+	flags := newFlags("history")
+	limit := flags.Int("limit", 0, "page size (the bridge's default when 0)")
+	all := flags.Bool("all", false, "follow next_cursor and print every page")
+	flags.Parse(args)
+	if flags.NArg() < 1 {
+		return fmt.Errorf("usage: wa-bridge-cli %s", commands["history"].usage)
+	}
+	// The flags may also follow the JID
+	jid := flags.Arg(0)
+	flags.Parse(flags.Args()[1:])
+	if flags.NArg() > 0 {
+		return fmt.Errorf("usage: wa-bridge-cli %s", commands["history"].usage)
+	}
+
+	query := url.Values{}
+	if *limit > 0 {
+		query.Set("limit", strconv.Itoa(*limit))
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	if !c.json {
+		fmt.Fprintln(tw, "ID\tSENT\tFROM\tMESSAGE")
+	}
+	for {
+		var page struct {
+			Messages   []chatMessage `json:"messages"`
+			NextCursor string        `json:"next_cursor"`
+		}
+		if err := c.do(http.MethodGet, "/v1/chats/"+url.PathEscape(jid)+"/messages", query, nil, &page); err != nil {
+			return err
+		}
+		if !c.json {
+			for _, msg := range page.Messages {
+				from := msg.Sender
+				if msg.IsFromMe {
+					from = "me"
+				}
+				text := msg.Content
+				switch {
+				case msg.Revoked != nil:
+					text = "(deleted)"
+				case msg.Media != nil:
+					label := msg.Media.Type
+					if msg.Media.Filename != "" {
+						label += " " + msg.Media.Filename
+					}
+					text = strings.TrimSpace("[" + label + "] " + text)
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", msg.ID, msg.Timestamp.Local().Format("2006-01-02 15:04"), from, truncate(text, 60))
+			}
+		}
+		if !*all || page.NextCursor == "" {
+			if !c.json && page.NextCursor != "" {
+				fmt.Fprintln(os.Stderr, "More messages available, use -all to list them")
+			}
+			break
+		}
+		query.Set("cursor", page.NextCursor)
+	}
+	return tw.Flush()
+}
+
+// truncate shortens s to at most n runes for a table cell
+func truncate(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return s
+}
+
+func runGet(c *client, args []string) error {
+	id, err := oneID("get", args)
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Message scheduledMessage `json:"message"`
+	}
+	if err := c.do(http.MethodGet, "/v1/scheduled/"+url.PathEscape(id), nil, nil, &resp); err != nil {
+		return err
+	}
+	if c.json {
+		return nil
+	}
+
+	msg := resp.Message
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID\t%s\n", msg.ID)
+	fmt.Fprintf(tw, "Status\t%s\n", msg.Status)
+	fmt.Fprintf(tw, "Recipient\t%s\n", msg.Recipient)
+	fmt.Fprintf(tw, "Scheduled\t%s\n", msg.ScheduledTime.Local().Format(time.RFC3339))
+	if msg.SentAt != nil {
+		fmt.Fprintf(tw, "Sent\t%s\n", msg.SentAt.Local().Format(time.RFC3339))
+	}
+	if msg.ErrorMessage != nil {
+		fmt.Fprintf(tw, "Error\t%s\n", *msg.ErrorMessage)
+	}
+	fmt.Fprintf(tw, "Delivery\t%s, %s\n", msg.DeliveryMode, msg.Precision)
+	if msg.CreatedBy != "" {
+		fmt.Fprintf(tw, "Created by\t%s\n", msg.CreatedBy)
+	}
+	if len(msg.Metadata) > 0 {
+		fmt.Fprintf(tw, "Metadata\t%s\n", msg.Metadata)
+	}
+	fmt.Fprintf(tw, "Message\t%s\n", msg.Message)
+	return tw.Flush()
+}
+
+func runCancel(c *client, args []string) error {
+	id, err := oneID("cancel", args)
+	if err != nil {
+		return err
+	}
+	return c.report(c.do(http.MethodDelete, "/v1/scheduled/"+url.PathEscape(id), nil, nil, nil), "Cancelled "+id)
+}
+
+func runPause(c *client, args []string) error {
+	return setPaused(c, "pause", args)
+}
+
+func runResume(c *client, args []string) error {
+	return setPaused(c, "resume", args)
+}
+
+// setPaused pauses or resumes a message with PATCH /v1/scheduled/{id}
+func setPaused(c *client, action string, args []string) error {
+	id, err := oneID(action, args)
+	if err != nil {
+		return err
+	}
+	err = c.do(http.MethodPatch, "/v1/scheduled/"+url.PathEscape(id), nil, map[string]string{"action": action}, nil)
+	return c.report(err, fmt.Sprintf("%sd %s", strings.ToUpper(action[:1])+action[1:], id))
+}
+
+// report prints done unless the call failed or -json already printed the response
+func (c *client) report(err error, done string) error {
+	if err == nil && !c.json {
+		fmt.Fprintln(c.out, done)
+	}
+	return err
+}
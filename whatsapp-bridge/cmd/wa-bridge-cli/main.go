@@ -0,0 +1,213 @@
+// Command wa-bridge-cli calls the bridge's HTTP API from the shell, for cron
+// jobs and scripts.
+//
+//	wa-bridge-cli [-url URL | -socket PATH] [-key KEY] [-json] <command> [flags] [args]
+//
+// The URL, socket and API key default to WHATSAPP_BRIDGE_URL,
+// WHATSAPP_BRIDGE_SOCKET and BRIDGE_API_KEY, like the MCP server. It exits with
+// status 1 when a call fails.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// command is one subcommand
+type command struct {
+	usage string
+	help  string
+	run   func(c *client, args []string) error
+}
+
+// commands are the subcommands by name, filled in by init since their
+// functions refer back to it for usage texts
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"status":   {"status", "Show whether WhatsApp, the databases and the scheduler are ready", runStatus},
+		"send":     {"send [-media PATH] RECIPIENT [MESSAGE...]", "Send a message right away", runSend},
+		"schedule": {"schedule (-at TIME | -in DURATION) [flags] RECIPIENT MESSAGE...", "Schedule a message", runSchedule},
+		"list":     {"list [-status S] [-recipient R] [-created-by C] [-limit N] [-all]", "List scheduled messages, latest first", runList},
+		"get":      {"get ID", "Show a scheduled message", runGet},
+		"cancel":   {"cancel ID", "Cancel a pending or paused message", runCancel},
+		"pause":    {"pause ID", "Pause a pending message", runPause},
+		"resume":   {"resume ID", "Resume a paused message", runResume},
+		"history":  {"history JID [-limit N] [-all]", "Show the stored messages of a chat, latest first", runHistory},
+	}
+}
+
+// commandOrder is the order commands are listed in the usage text
+var commandOrder = []string{"status", "send", "schedule", "list", "get", "cancel", "pause", "resume", "history"}
+
+func main() {
+	flags := flag.NewFlagSet("wa-bridge-cli", flag.ExitOnError)
+	baseURL := flags.String("url", envOr("WHATSAPP_BRIDGE_URL", "http://localhost:8080"), "bridge URL")
+	socket := flags.String("socket", os.Getenv("WHATSAPP_BRIDGE_SOCKET"), "unix socket of the bridge, used instead of the URL's host")
+	apiKey := flags.String("key", os.Getenv("BRIDGE_API_KEY"), "API key")
+	asJSON := flags.Bool("json", false, "print the raw JSON responses")
+	timeout := flags.Duration("timeout", 30*time.Second, "request timeout")
+	flags.Usage = func() { usage(flags) }
+	flags.Parse(os.Args[1:])
+
+	if flags.NArg() == 0 {
+		usage(flags)
+		os.Exit(2)
+	}
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "wa-bridge-cli: unknown command %q\n\n", flags.Arg(0))
+		usage(flags)
+		os.Exit(2)
+	}
+
+	c := &client{
+		baseURL: strings.TrimSuffix(*baseURL, "/"),
+		apiKey:  *apiKey,
+		json:    *asJSON,
+		http:    &http.Client{Timeout: *timeout},
+		out:     os.Stdout,
+	}
+	if *socket != "" {
+		c.http.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", *socket)
+			},
+		}
+	}
+	if err := cmd.run(c, flags.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "wa-bridge-cli: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage(flags *flag.FlagSet) {
+	out := flags.Output()
+	fmt.Fprintf(out, "Usage: wa-bridge-cli [flags] <command> [args]\n\nCommands:\n")
+	for _, name := range commandOrder {
+		cmd := commands[name]
+		fmt.Fprintf(out, "  %-68s %s\n", cmd.usage, cmd.help)
+	}
+	fmt.Fprintf(out, "\nFlags:\n")
+	flags.PrintDefaults()
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// client calls the bridge API
+type client struct {
+	baseURL string
+	apiKey  string
+	json    bool
+	http    *http.Client
+	out     io.Writer
+}
+
+// apiError is the error envelope of a failed call
+type apiError struct {
+	Status    int
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id"`
+}
+
+func (e *apiError) Error() string {
+	msg := fmt.Sprintf("HTTP %d %s: %s", e.Status, e.Code, e.Message)
+	if e.RequestID != "" {
+		msg += fmt.Sprintf(" (request %s)", e.RequestID)
+	}
+	return msg
+}
+
+// do sends a request with an optional JSON body and decodes the JSON response
+// into out. With -json the response is printed as is as well. Responses other
+// than 2xx become an *apiError.
+func (c *client) do(method, path string, query url.Values, body interface{}, out interface{}) error {
+	status, data, err := c.send(method, path, query, body)
+	if err != nil {
+		return err
+	}
+	if status >= 300 {
+		var envelope struct {
+			Error *apiError `json:"error"`
+		}
+		if json.Unmarshal(data, &envelope) == nil && envelope.Error != nil {
+			envelope.Error.Status = status
+			return envelope.Error
+		}
+		return fmt.Errorf("HTTP %d: %s", status, strings.TrimSpace(string(data)))
+	}
+
+	c.printJSON(data)
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("unexpected response: %w", err)
+		}
+	}
+	return nil
+}
+
+// send makes a request and returns the response status and body
+func (c *client) send(method, path string, query url.Values, body interface{}) (int, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	// Scheduled messages are attributed to the CLI when authentication is off
+	req.Header.Set("X-Created-By", "wa-bridge-cli")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, data, err
+}
+
+// printJSON prints a response body when -json is set
+func (c *client) printJSON(data []byte) {
+	if !c.json {
+		return
+	}
+	c.out.Write(data)
+	if !bytes.HasSuffix(data, []byte("\n")) {
+		fmt.Fprintln(c.out)
+	}
+}
@@ -0,0 +1,939 @@
+// Package config loads the bridge settings from an optional YAML file and
+// environment variables.
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"whatsapp-client/events"
+)
+
+// FileEnv names the environment variable holding the path of the config file
+const FileEnv = "BRIDGE_CONFIG_FILE"
+
+// Config holds the bridge settings
+type Config struct {
+	// WhatsAppDBPath is the SQLite file holding the WhatsApp session
+	WhatsAppDBPath string
+	// MessagesDBPath is the SQLite file holding chats and message history
+	MessagesDBPath string
+	// Port is the TCP port the HTTP API listens on, 0 to serve it on SocketPath only
+	Port int
+	// SocketPath is a unix socket the HTTP API listens on too, when set
+	SocketPath string
+	// SocketMode is the permission bits of the socket file
+	SocketMode os.FileMode
+	// GRPCPort is the TCP port of the gRPC API, 0 to not serve it. Only builds
+	// with the grpc tag have a gRPC server.
+	GRPCPort int
+	// ShutdownTimeout is how long in-flight API requests get to finish on shutdown
+	ShutdownTimeout time.Duration
+	API             APIConfig
+	Scheduler       SchedulerConfig
+	Webhooks        WebhookConfig
+	Log             LogConfig
+	Media           MediaConfig
+}
+
+// MediaConfig controls where downloaded media is kept and which received media
+// is downloaded as it arrives
+type MediaConfig struct {
+	// Dir holds downloaded files, in a directory per chat
+	Dir string
+	// QuotaMB bounds the total size of the downloaded files. The least recently
+	// downloaded are deleted to make room. 0 for no limit.
+	QuotaMB int
+	// TypeQuotaMB bounds the total size of the downloaded files of some media
+	// types, within QuotaMB
+	TypeQuotaMB map[string]int
+	// RetentionDays is how long downloaded files are kept, 0 for ever
+	RetentionDays int
+	// TypeRetentionDays overrides RetentionDays for some media types, 0 keeping
+	// them for ever
+	TypeRetentionDays map[string]int
+	// AutoDownload are the MediaTypes downloaded as they arrive, in chats
+	// without a rule of their own
+	AutoDownload []string
+	// AutoDownloadChats are the media types downloaded in some chats, keyed by
+	// chat JID, or by a server like "@g.us" for every chat on it. An empty list
+	// downloads nothing in the chat.
+	AutoDownloadChats map[string][]string
+	// AutoDownloadMaxSizeMB skips larger files, 0 for no limit
+	AutoDownloadMaxSizeMB int
+}
+
+// MediaTypes are the media types accepted in MediaConfig
+var MediaTypes = []string{"image", "video", "audio", "document"}
+
+// WebhookConfig lists the endpoints that are told about bridge events
+type WebhookConfig struct {
+	// URLs are http or https endpoints
+	URLs []string
+	// Secret signs each delivery with HMAC-SHA256; required when URLs are set
+	Secret string
+	// Events are the event types delivered, e.g. "message"
+	Events []string
+	// Timeout bounds each delivery request
+	Timeout time.Duration
+	// MaxAttempts is how many times a delivery is tried before it is dead-lettered
+	MaxAttempts int
+}
+
+// LogConfig controls the bridge's log output
+type LogConfig struct {
+	// Level is debug, info, warn or error
+	Level string
+	// Format is text or json
+	Format string
+	// File writes logs to this file instead of standard error when set
+	File string
+	// MaxSizeMB is the size at which the log file is rotated
+	MaxSizeMB int
+	// MaxBackups is how many rotated log files are kept
+	MaxBackups int
+}
+
+// Log levels and formats accepted in LogConfig
+var (
+	LogLevels  = []string{"debug", "info", "warn", "error"}
+	LogFormats = []string{"text", "json"}
+)
+
+// APIConfig holds the HTTP API settings
+type APIConfig struct {
+	// Keys are the API keys accepted on /api routes
+	Keys []APIKey
+	// KeyScopes limits keys, by name, to some scopes. Keys not listed have
+	// ScopeAdmin.
+	KeyScopes map[string][]string
+	// AuthDisabled lets the API run without keys, for trusted local setups
+	AuthDisabled bool
+	// AllowedIPs, when not empty, are the only client networks the bridge
+	// answers. Single addresses are stored as /32 or /128 prefixes.
+	AllowedIPs []netip.Prefix
+	CORS       CORSConfig
+	RateLimit  RateLimitConfig
+	// AuditRetentionDays is how long the audit log of mutating calls is kept, 0 for ever
+	AuditRetentionDays int
+	// CacheTTL is how long list responses are cached between writes, 0 to turn caching off
+	CacheTTL time.Duration
+}
+
+// RateLimitConfig limits API requests with token buckets. A limit of 0 turns
+// that bucket off.
+type RateLimitConfig struct {
+	// PerKey is the sustained requests per minute allowed for each API key
+	PerKey float64
+	// PerIP is the sustained requests per minute allowed for each client address,
+	// counting unauthenticated requests too
+	PerIP float64
+	// Burst is how many requests a client may make at once before the limit applies
+	Burst int
+}
+
+// CORSConfig controls which browser origins may call the API. CORS is off
+// while AllowedOrigins is empty.
+type CORSConfig struct {
+	// AllowedOrigins are origins like "http://localhost:3000", or "*" for any
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	// MaxAge is how long browsers may cache a preflight response
+	MaxAge time.Duration
+}
+
+// APIKey is a named API key. The name is recorded as the creator of the
+// messages scheduled with the key.
+type APIKey struct {
+	Name string
+	Key  string
+	// Scopes are what the key may do, filled in from KeyScopes
+	Scopes []string
+}
+
+// Allows reports whether the key has scope, which ScopeAdmin always grants
+func (k APIKey) Allows(scope string) bool {
+	return slices.Contains(k.Scopes, ScopeAdmin) || slices.Contains(k.Scopes, scope)
+}
+
+// API key scopes
+const (
+	// ScopeRead allows reading messages, schedules, stats and events
+	ScopeRead = "read"
+	// ScopeSchedule allows sending, scheduling, pausing, resuming and cancelling messages
+	ScopeSchedule = "schedule"
+	// ScopeAdmin allows everything, including configuration, the audit log and
+	// the WhatsApp session
+	ScopeAdmin = "admin"
+)
+
+// Scopes are the API key scopes accepted in KeyScopes
+var Scopes = []string{ScopeRead, ScopeSchedule, ScopeAdmin}
+
+// MinAPIKeyLength is the shortest API key accepted
+const MinAPIKeyLength = 16
+
+// SchedulerConfig holds the message scheduler settings
+type SchedulerConfig struct {
+	// DBDSN is a SQLite path or a postgres:// URL
+	DBDSN string
+	// SingleDB keeps the scheduler tables in the messages database; DBDSN is ignored
+	SingleDB bool
+	// CheckInterval is how often normal-precision messages are checked
+	CheckInterval time.Duration
+	// RetentionMode is archive, delete or off
+	RetentionMode string
+	// RetentionDays is how long finished messages are kept
+	RetentionDays int
+	// BackupDir enables daily backups when set
+	BackupDir string
+	// BackupKeep is how many backup files are kept (0 uses the scheduler default)
+	BackupKeep int
+	// EncryptionKey is the base64 or hex key for encrypting message bodies
+	EncryptionKey string
+	// QuietHours holds back messages that fall due inside the window until it
+	// ends. Start and End are offsets from midnight; the window is off while they
+	// are equal and wraps past midnight when End is before Start.
+	QuietHours QuietHours
+}
+
+// QuietHours is a daily time-of-day window
+type QuietHours struct {
+	Start time.Duration
+	End   time.Duration
+	// Timezone is an IANA zone name like "Europe/Madrid"; empty uses the local zone
+	Timezone string
+}
+
+// Enabled reports whether the window is set
+func (q QuietHours) Enabled() bool {
+	return q.Start != q.End
+}
+
+// String formats the window as "22:00-07:00", or "" when it is off
+func (q QuietHours) String() string {
+	if !q.Enabled() {
+		return ""
+	}
+	return formatClock(q.Start) + "-" + formatClock(q.End)
+}
+
+// MinCheckInterval is the shortest allowed scheduler check interval
+const MinCheckInterval = time.Second
+
+// Default returns the settings used when nothing is configured
+func Default() *Config {
+	return &Config{
+		WhatsAppDBPath:  "store/whatsapp.db",
+		MessagesDBPath:  "store/messages.db",
+		Port:            8080,
+		SocketMode:      0660,
+		ShutdownTimeout: 30 * time.Second,
+		API: APIConfig{
+			CORS: CORSConfig{
+				AllowedMethods: []string{"GET", "POST", "PATCH", "DELETE"},
+				AllowedHeaders: []string{"Authorization", "Content-Type", "X-API-Key", "X-API-Version", "X-Created-By", "X-Request-ID"},
+				MaxAge:         10 * time.Minute,
+			},
+			RateLimit: RateLimitConfig{
+				PerKey: 120,
+				PerIP:  300,
+				Burst:  20,
+			},
+			AuditRetentionDays: 90,
+			CacheTTL:           2 * time.Second,
+		},
+		Webhooks: WebhookConfig{
+			Events:      []string{"message"},
+			Timeout:     10 * time.Second,
+			MaxAttempts: 8,
+		},
+		Scheduler: SchedulerConfig{
+			DBDSN:         "store/scheduler.db",
+			CheckInterval: time.Minute,
+			RetentionMode: "archive",
+			RetentionDays: 30,
+		},
+		Log: LogConfig{
+			Level:      "info",
+			Format:     "text",
+			MaxSizeMB:  100,
+			MaxBackups: 5,
+		},
+		Media: MediaConfig{
+			Dir:                   "store",
+			AutoDownloadMaxSizeMB: 16,
+		},
+	}
+}
+
+// setting maps a config file key and an environment variable to a field
+type setting struct {
+	key   string
+	env   string
+	apply func(c *Config, value string) error
+}
+
+var settings = []setting{
+	{"whatsapp_db_path", "WHATSAPP_DB_PATH", func(c *Config, v string) error {
+		c.WhatsAppDBPath = v
+		return nil
+	}},
+	{"messages_db_path", "MESSAGES_DB_PATH", func(c *Config, v string) error {
+		c.MessagesDBPath = v
+		return nil
+	}},
+	{"port", "BRIDGE_PORT", func(c *Config, v string) error {
+		return parseInt(v, &c.Port)
+	}},
+	{"socket_path", "BRIDGE_SOCKET", func(c *Config, v string) error {
+		c.SocketPath = v
+		return nil
+	}},
+	{"socket_mode", "BRIDGE_SOCKET_MODE", func(c *Config, v string) error {
+		mode, err := strconv.ParseUint(v, 8, 32)
+		if err != nil || mode > 0777 {
+			return fmt.Errorf("invalid file mode %q, use octal like 0660", v)
+		}
+		c.SocketMode = os.FileMode(mode)
+		return nil
+	}},
+	{"grpc_port", "BRIDGE_GRPC_PORT", func(c *Config, v string) error {
+		return parseInt(v, &c.GRPCPort)
+	}},
+	{"shutdown_timeout", "BRIDGE_SHUTDOWN_TIMEOUT", func(c *Config, v string) error {
+		return parseDuration(v, &c.ShutdownTimeout)
+	}},
+	{"api.keys", "BRIDGE_API_KEYS", func(c *Config, v string) error {
+		keys, err := parseAPIKeys(v)
+		if err != nil {
+			return err
+		}
+		c.API.Keys = keys
+		return nil
+	}},
+	{"api.key_scopes", "BRIDGE_API_KEY_SCOPES", func(c *Config, v string) error {
+		scopes, err := parseKeyScopes(v)
+		if err != nil {
+			return err
+		}
+		c.API.KeyScopes = scopes
+		return nil
+	}},
+	{"api.auth_disabled", "BRIDGE_AUTH_DISABLED", func(c *Config, v string) error {
+		return parseBool(v, &c.API.AuthDisabled)
+	}},
+	{"api.allowed_ips", "BRIDGE_ALLOWED_IPS", func(c *Config, v string) error {
+		prefixes, err := parsePrefixes(v)
+		if err != nil {
+			return err
+		}
+		c.API.AllowedIPs = prefixes
+		return nil
+	}},
+	{"api.cors.allowed_origins", "BRIDGE_CORS_ORIGINS", func(c *Config, v string) error {
+		c.API.CORS.AllowedOrigins = parseList(v)
+		return nil
+	}},
+	{"api.cors.allowed_methods", "BRIDGE_CORS_METHODS", func(c *Config, v string) error {
+		c.API.CORS.AllowedMethods = parseList(strings.ToUpper(v))
+		return nil
+	}},
+	{"api.cors.allowed_headers", "BRIDGE_CORS_HEADERS", func(c *Config, v string) error {
+		c.API.CORS.AllowedHeaders = parseList(v)
+		return nil
+	}},
+	{"api.cors.max_age", "BRIDGE_CORS_MAX_AGE", func(c *Config, v string) error {
+		return parseDuration(v, &c.API.CORS.MaxAge)
+	}},
+	{"api.rate_limit.per_key", "BRIDGE_RATE_LIMIT_PER_KEY", func(c *Config, v string) error {
+		return parseFloat(v, &c.API.RateLimit.PerKey)
+	}},
+	{"api.rate_limit.per_ip", "BRIDGE_RATE_LIMIT_PER_IP", func(c *Config, v string) error {
+		return parseFloat(v, &c.API.RateLimit.PerIP)
+	}},
+	{"api.rate_limit.burst", "BRIDGE_RATE_LIMIT_BURST", func(c *Config, v string) error {
+		return parseInt(v, &c.API.RateLimit.Burst)
+	}},
+	{"api.audit_retention_days", "BRIDGE_AUDIT_RETENTION_DAYS", func(c *Config, v string) error {
+		return parseInt(v, &c.API.AuditRetentionDays)
+	}},
+	{"api.cache_ttl", "BRIDGE_CACHE_TTL", func(c *Config, v string) error {
+		return parseDuration(v, &c.API.CacheTTL)
+	}},
+	{"scheduler.db_dsn", "SCHEDULER_DB_DSN", func(c *Config, v string) error {
+		c.Scheduler.DBDSN = v
+		return nil
+	}},
+	{"scheduler.single_db", "SCHEDULER_SINGLE_DB", func(c *Config, v string) error {
+		return parseBool(v, &c.Scheduler.SingleDB)
+	}},
+	{"scheduler.check_interval", "SCHEDULER_CHECK_INTERVAL", func(c *Config, v string) error {
+		return parseDuration(v, &c.Scheduler.CheckInterval)
+	}},
+	{"scheduler.retention_mode", "SCHEDULER_RETENTION_MODE", func(c *Config, v string) error {
+		c.Scheduler.RetentionMode = strings.ToLower(v)
+		return nil
+	}},
+	{"scheduler.retention_days", "SCHEDULER_RETENTION_DAYS", func(c *Config, v string) error {
+		return parseInt(v, &c.Scheduler.RetentionDays)
+	}},
+	{"scheduler.backup_dir", "SCHEDULER_BACKUP_DIR", func(c *Config, v string) error {
+		c.Scheduler.BackupDir = v
+		return nil
+	}},
+	{"scheduler.backup_keep", "SCHEDULER_BACKUP_KEEP", func(c *Config, v string) error {
+		return parseInt(v, &c.Scheduler.BackupKeep)
+	}},
+	{"scheduler.encryption_key", "SCHEDULER_ENCRYPTION_KEY", func(c *Config, v string) error {
+		c.Scheduler.EncryptionKey = v
+		return nil
+	}},
+	{"scheduler.quiet_hours", "SCHEDULER_QUIET_HOURS", func(c *Config, v string) error {
+		return parseQuietHours(v, &c.Scheduler.QuietHours)
+	}},
+	{"scheduler.quiet_hours_timezone", "SCHEDULER_QUIET_HOURS_TIMEZONE", func(c *Config, v string) error {
+		c.Scheduler.QuietHours.Timezone = v
+		return nil
+	}},
+	{"webhooks.urls", "BRIDGE_WEBHOOK_URLS", func(c *Config, v string) error {
+		c.Webhooks.URLs = parseList(v)
+		return nil
+	}},
+	{"webhooks.secret", "BRIDGE_WEBHOOK_SECRET", func(c *Config, v string) error {
+		c.Webhooks.Secret = v
+		return nil
+	}},
+	{"webhooks.events", "BRIDGE_WEBHOOK_EVENTS", func(c *Config, v string) error {
+		c.Webhooks.Events = parseList(strings.ToLower(v))
+		return nil
+	}},
+	{"webhooks.timeout", "BRIDGE_WEBHOOK_TIMEOUT", func(c *Config, v string) error {
+		return parseDuration(v, &c.Webhooks.Timeout)
+	}},
+	{"webhooks.max_attempts", "BRIDGE_WEBHOOK_MAX_ATTEMPTS", func(c *Config, v string) error {
+		return parseInt(v, &c.Webhooks.MaxAttempts)
+	}},
+	{"log.level", "BRIDGE_LOG_LEVEL", func(c *Config, v string) error {
+		c.Log.Level = strings.ToLower(v)
+		return nil
+	}},
+	{"log.format", "BRIDGE_LOG_FORMAT", func(c *Config, v string) error {
+		c.Log.Format = strings.ToLower(v)
+		return nil
+	}},
+	{"log.file", "BRIDGE_LOG_FILE", func(c *Config, v string) error {
+		c.Log.File = v
+		return nil
+	}},
+	{"log.max_size_mb", "BRIDGE_LOG_MAX_SIZE_MB", func(c *Config, v string) error {
+		return parseInt(v, &c.Log.MaxSizeMB)
+	}},
+	{"log.max_backups", "BRIDGE_LOG_MAX_BACKUPS", func(c *Config, v string) error {
+		return parseInt(v, &c.Log.MaxBackups)
+	}},
+	{"media.dir", "BRIDGE_MEDIA_DIR", func(c *Config, v string) error {
+		c.Media.Dir = v
+		return nil
+	}},
+	{"media.quota_mb", "BRIDGE_MEDIA_QUOTA_MB", func(c *Config, v string) error {
+		return parseInt(v, &c.Media.QuotaMB)
+	}},
+	{"media.type_quota_mb", "BRIDGE_MEDIA_TYPE_QUOTA_MB", func(c *Config, v string) error {
+		quotas, err := parseTypeLimits(v, "media type quota")
+		if err != nil {
+			return err
+		}
+		c.Media.TypeQuotaMB = quotas
+		return nil
+	}},
+	{"media.retention_days", "BRIDGE_MEDIA_RETENTION_DAYS", func(c *Config, v string) error {
+		return parseInt(v, &c.Media.RetentionDays)
+	}},
+	{"media.type_retention_days", "BRIDGE_MEDIA_TYPE_RETENTION_DAYS", func(c *Config, v string) error {
+		days, err := parseTypeLimits(v, "media type retention")
+		if err != nil {
+			return err
+		}
+		c.Media.TypeRetentionDays = days
+		return nil
+	}},
+	{"media.auto_download", "BRIDGE_MEDIA_AUTO_DOWNLOAD", func(c *Config, v string) error {
+		c.Media.AutoDownload = parseMediaTypes(v)
+		return nil
+	}},
+	{"media.auto_download_chats", "BRIDGE_MEDIA_AUTO_DOWNLOAD_CHATS", func(c *Config, v string) error {
+		chats, err := parseChatMediaTypes(v)
+		if err != nil {
+			return err
+		}
+		c.Media.AutoDownloadChats = chats
+		return nil
+	}},
+	{"media.auto_download_max_size_mb", "BRIDGE_MEDIA_AUTO_DOWNLOAD_MAX_SIZE_MB", func(c *Config, v string) error {
+		return parseInt(v, &c.Media.AutoDownloadMaxSizeMB)
+	}},
+}
+
+// listSettings take a comma-separated list, which the config file can also
+// give as a YAML sequence
+var listSettings = map[string]bool{
+	"api.keys":                  true,
+	"api.key_scopes":            true,
+	"api.allowed_ips":           true,
+	"api.cors.allowed_origins":  true,
+	"api.cors.allowed_methods":  true,
+	"api.cors.allowed_headers":  true,
+	"webhooks.urls":             true,
+	"webhooks.events":           true,
+	"media.type_quota_mb":       true,
+	"media.type_retention_days": true,
+	"media.auto_download":       true,
+	"media.auto_download_chats": true,
+}
+
+// Load returns Default overridden first by the YAML file named in
+// BRIDGE_CONFIG_FILE, if any, and then by environment variables
+func Load() (*Config, error) {
+	return LoadFile(os.Getenv(FileEnv))
+}
+
+// LoadFile is Load with an explicit config file path. An empty path skips the file.
+func LoadFile(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := cfg.applyFile(string(data)); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+	}
+
+	for _, s := range settings {
+		if v := os.Getenv(s.env); v != "" {
+			if err := s.apply(cfg, v); err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", s.env, err)
+			}
+		}
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyFile applies the settings from a YAML config file
+func (c *Config) applyFile(data string) error {
+	values, err := parseYAML(data)
+	if err != nil {
+		return err
+	}
+
+	byKey := make(map[string]setting, len(settings))
+	for _, s := range settings {
+		byKey[s.key] = s
+	}
+
+	// Report unknown keys in a stable order
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s, ok := byKey[key]
+		if !ok {
+			return fmt.Errorf("line %d: unknown setting %q", values[key].line, key)
+		}
+		if values[key].list && !listSettings[key] {
+			return fmt.Errorf("line %d: %s takes a single value, not a list", values[key].line, key)
+		}
+		if err := s.apply(c, values[key].value); err != nil {
+			return fmt.Errorf("line %d: %s: %w", values[key].line, key, err)
+		}
+	}
+	return nil
+}
+
+// validate checks values that can be rejected without opening anything
+func (c *Config) validate() error {
+	if c.WhatsAppDBPath == "" || c.MessagesDBPath == "" {
+		return fmt.Errorf("database paths must not be empty")
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		return fmt.Errorf("port must be between 0 and 65535")
+	}
+	if c.Port == 0 && c.SocketPath == "" {
+		return fmt.Errorf("port 0 turns off TCP, so a socket path must be set")
+	}
+	if c.GRPCPort < 0 || c.GRPCPort > 65535 {
+		return fmt.Errorf("gRPC port must be between 0 and 65535")
+	}
+	if c.GRPCPort != 0 && c.GRPCPort == c.Port {
+		return fmt.Errorf("gRPC port must differ from the HTTP port")
+	}
+	if c.ShutdownTimeout <= 0 {
+		return fmt.Errorf("shutdown timeout must be positive")
+	}
+	if len(c.API.Keys) == 0 && !c.API.AuthDisabled {
+		return fmt.Errorf("no API keys configured: set BRIDGE_API_KEYS, or BRIDGE_AUTH_DISABLED=true to run without authentication")
+	}
+	// Scopes are matched to keys here, since the keys and their scopes may come
+	// from different sources
+	for name := range c.API.KeyScopes {
+		if !slices.ContainsFunc(c.API.Keys, func(key APIKey) bool { return key.Name == name }) {
+			return fmt.Errorf("API key scopes are set for %q, which is not an API key name", name)
+		}
+	}
+	for i, key := range c.API.Keys {
+		scopes, ok := c.API.KeyScopes[key.Name]
+		if !ok {
+			scopes = []string{ScopeAdmin}
+		}
+		c.API.Keys[i].Scopes = scopes
+	}
+	for _, origin := range c.API.CORS.AllowedOrigins {
+		if origin != "*" && !strings.Contains(origin, "://") {
+			return fmt.Errorf("CORS origin %q must include the scheme, e.g. http://localhost:3000", origin)
+		}
+	}
+	if c.API.RateLimit.PerKey < 0 || c.API.RateLimit.PerIP < 0 {
+		return fmt.Errorf("rate limits must not be negative")
+	}
+	if c.API.RateLimit.Burst < 1 {
+		return fmt.Errorf("rate limit burst must be at least 1")
+	}
+	if c.API.AuditRetentionDays < 0 {
+		return fmt.Errorf("audit retention days must not be negative")
+	}
+	if c.API.CacheTTL < 0 {
+		return fmt.Errorf("cache TTL must not be negative")
+	}
+	if !c.Scheduler.SingleDB && c.Scheduler.DBDSN == "" {
+		return fmt.Errorf("scheduler database DSN must not be empty")
+	}
+	if c.Scheduler.CheckInterval < MinCheckInterval {
+		return fmt.Errorf("scheduler check interval must be at least %s", MinCheckInterval)
+	}
+	if c.Scheduler.RetentionDays < 0 {
+		return fmt.Errorf("scheduler retention days must not be negative")
+	}
+	if c.Scheduler.BackupKeep < 0 {
+		return fmt.Errorf("scheduler backup keep must not be negative")
+	}
+	if tz := c.Scheduler.QuietHours.Timezone; tz != "" {
+		if _, err := time.LoadLocation(tz); err != nil {
+			return fmt.Errorf("unknown quiet hours timezone %q", tz)
+		}
+	}
+	for _, raw := range c.Webhooks.URLs {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook URL %q must be an http or https URL", raw)
+		}
+	}
+	if len(c.Webhooks.URLs) > 0 && c.Webhooks.Secret == "" {
+		return fmt.Errorf("webhook secret must be set when webhook URLs are configured")
+	}
+	for _, t := range c.Webhooks.Events {
+		if !slices.Contains(events.Types, t) {
+			return fmt.Errorf("unknown webhook event %q, expected one of %s", t, strings.Join(events.Types, ", "))
+		}
+	}
+	if c.Webhooks.Timeout <= 0 {
+		return fmt.Errorf("webhook timeout must be positive")
+	}
+	if c.Webhooks.MaxAttempts < 1 {
+		return fmt.Errorf("webhook max attempts must be at least 1")
+	}
+	if !slices.Contains(LogLevels, c.Log.Level) {
+		return fmt.Errorf("log level must be one of %s", strings.Join(LogLevels, ", "))
+	}
+	if !slices.Contains(LogFormats, c.Log.Format) {
+		return fmt.Errorf("log format must be one of %s", strings.Join(LogFormats, ", "))
+	}
+	if c.Log.MaxSizeMB < 1 {
+		return fmt.Errorf("log max size must be at least 1 MB")
+	}
+	if c.Log.MaxBackups < 0 {
+		return fmt.Errorf("log max backups must not be negative")
+	}
+	if c.Media.Dir == "" {
+		return fmt.Errorf("media directory must not be empty")
+	}
+	if c.Media.QuotaMB < 0 || c.Media.AutoDownloadMaxSizeMB < 0 {
+		return fmt.Errorf("media quota and auto-download max size must not be negative")
+	}
+	if c.Media.RetentionDays < 0 {
+		return fmt.Errorf("media retention days must not be negative")
+	}
+	for what, limits := range map[string]map[string]int{"quota": c.Media.TypeQuotaMB, "retention": c.Media.TypeRetentionDays} {
+		for t, limit := range limits {
+			if !slices.Contains(MediaTypes, t) {
+				return fmt.Errorf("media type %s has unknown media type %q, expected one of %s", what, t, strings.Join(MediaTypes, ", "))
+			}
+			if limit < 0 {
+				return fmt.Errorf("media type %s of %q must not be negative", what, t)
+			}
+		}
+	}
+	for _, t := range c.Media.AutoDownload {
+		if !slices.Contains(MediaTypes, t) {
+			return fmt.Errorf("unknown media type %q, expected one of %s", t, strings.Join(MediaTypes, ", "))
+		}
+	}
+	for chat, types := range c.Media.AutoDownloadChats {
+		for _, t := range types {
+			if !slices.Contains(MediaTypes, t) {
+				return fmt.Errorf("chat %q has unknown media type %q, expected one of %s", chat, t, strings.Join(MediaTypes, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// parseDuration accepts Go durations ("30s", "2m") or a plain number of seconds
+func parseDuration(v string, out *time.Duration) error {
+	if secs, err := strconv.Atoi(v); err == nil {
+		*out = time.Duration(secs) * time.Second
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", v)
+	}
+	*out = d
+	return nil
+}
+
+// parseAPIKeys reads a comma-separated list of name:key pairs. A key without
+// a name is named after its position, e.g. "key-2".
+func parseAPIKeys(v string) ([]APIKey, error) {
+	var keys []APIKey
+	seen := make(map[string]bool)
+	for i, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		key := APIKey{Name: fmt.Sprintf("key-%d", i+1), Key: item}
+		if name, value, ok := strings.Cut(item, ":"); ok {
+			key = APIKey{Name: strings.TrimSpace(name), Key: strings.TrimSpace(value)}
+		}
+		if key.Name == "" {
+			return nil, fmt.Errorf("API key %d has an empty name", i+1)
+		}
+		if len(key.Key) < MinAPIKeyLength {
+			return nil, fmt.Errorf("API key %q is shorter than %d characters", key.Name, MinAPIKeyLength)
+		}
+		if seen[key.Name] {
+			return nil, fmt.Errorf("API key name %q is used twice", key.Name)
+		}
+		seen[key.Name] = true
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// parseKeyScopes reads a comma-separated list of name:scopes pairs, with the
+// scopes of a key joined by "+", e.g. "dashboard:read,cron:read+schedule"
+func parseKeyScopes(v string) (map[string][]string, error) {
+	scopes := make(map[string][]string)
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, list, ok := strings.Cut(item, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("API key scopes %q must be name:scope", item)
+		}
+		if _, dup := scopes[name]; dup {
+			return nil, fmt.Errorf("API key scopes for %q are set twice", name)
+		}
+		var keyScopes []string
+		for _, scope := range strings.Split(list, "+") {
+			scope = strings.TrimSpace(scope)
+			if !slices.Contains(Scopes, scope) {
+				return nil, fmt.Errorf("API key %q has unknown scope %q, use %s", name, scope, strings.Join(Scopes, ", "))
+			}
+			if !slices.Contains(keyScopes, scope) {
+				keyScopes = append(keyScopes, scope)
+			}
+		}
+		scopes[name] = keyScopes
+	}
+	return scopes, nil
+}
+
+// parseMediaTypes reads a comma-separated list of media types. "none" is an
+// empty list, for turning a default off.
+func parseMediaTypes(v string) []string {
+	types := []string{}
+	for _, t := range parseList(strings.ToLower(v)) {
+		if t != "none" && !slices.Contains(types, t) {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// parseChatMediaTypes reads a comma-separated list of chat:types pairs, with
+// the media types of a chat joined by "+", e.g.
+// "5491156543944@s.whatsapp.net:image+audio,@g.us:none"
+func parseChatMediaTypes(v string) (map[string][]string, error) {
+	chats := make(map[string][]string)
+	for _, item := range parseList(v) {
+		chat, list, ok := strings.Cut(item, ":")
+		chat = strings.TrimSpace(chat)
+		if !ok {
+			return nil, fmt.Errorf("auto-download rule %q must be chat:type", item)
+		}
+		if !strings.Contains(chat, "@") {
+			return nil, fmt.Errorf("auto-download rule %q must name a chat JID or a server like @g.us", item)
+		}
+		if _, dup := chats[chat]; dup {
+			return nil, fmt.Errorf("auto-download rule for %q is set twice", chat)
+		}
+		chats[chat] = parseMediaTypes(strings.ReplaceAll(list, "+", ","))
+	}
+	return chats, nil
+}
+
+// parseTypeLimits reads a comma-separated list of type:number pairs setting a
+// limit for some media types, e.g. "video:7,document:90"
+func parseTypeLimits(v, what string) (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, item := range parseList(strings.ToLower(v)) {
+		t, value, ok := strings.Cut(item, ":")
+		t = strings.TrimSpace(t)
+		if !ok {
+			return nil, fmt.Errorf("%s %q must be type:number", what, item)
+		}
+		if !slices.Contains(MediaTypes, t) {
+			return nil, fmt.Errorf("%s %q has unknown media type %q, expected one of %s", what, item, t, strings.Join(MediaTypes, ", "))
+		}
+		if _, dup := limits[t]; dup {
+			return nil, fmt.Errorf("%s for %q is set twice", what, t)
+		}
+		limit, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("%s %q must be a whole number, at least 0", what, item)
+		}
+		limits[t] = limit
+	}
+	return limits, nil
+}
+
+// parsePrefixes reads a comma-separated list of CIDR ranges and single addresses
+func parsePrefixes(v string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range parseList(v) {
+		if strings.Contains(item, "/") {
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR range %q", item)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address %q", item)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// parseList splits a comma-separated list, dropping empty items
+func parseList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseQuietHours reads a "22:00-07:00" window. An empty value or "off" turns
+// quiet hours off.
+func parseQuietHours(v string, out *QuietHours) error {
+	if v == "" || strings.EqualFold(v, "off") {
+		out.Start, out.End = 0, 0
+		return nil
+	}
+	from, to, ok := strings.Cut(v, "-")
+	if !ok {
+		return fmt.Errorf("invalid quiet hours %q, expected HH:MM-HH:MM", v)
+	}
+	start, err := parseClock(strings.TrimSpace(from))
+	if err != nil {
+		return err
+	}
+	end, err := parseClock(strings.TrimSpace(to))
+	if err != nil {
+		return err
+	}
+	if start == end {
+		return fmt.Errorf("quiet hours %q start and end at the same time", v)
+	}
+	out.Start, out.End = start, end
+	return nil
+}
+
+// parseClock reads an HH:MM time of day as an offset from midnight
+func parseClock(v string) (time.Duration, error) {
+	t, err := time.Parse("15:04", v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", v)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func formatClock(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+}
+
+func parseInt(v string, out *int) error {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("invalid number %q", v)
+	}
+	*out = n
+	return nil
+}
+
+func parseFloat(v string, out *float64) error {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return fmt.Errorf("invalid number %q", v)
+	}
+	*out = f
+	return nil
+}
+
+func parseBool(v string, out *bool) error {
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("invalid boolean %q", v)
+	}
+	*out = b
+	return nil
+}
@@ -0,0 +1,207 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// redactedValue replaces secrets in Redacted
+const redactedValue = "[redacted]"
+
+// Redacted returns the settings keyed like the config file, safe to show to API
+// clients: API keys are listed by name only, and the encryption key, webhook
+// secret and any database password are masked.
+func (c *Config) Redacted() map[string]interface{} {
+	return c.view(true)
+}
+
+// Changed returns the file keys of the settings that differ between old and
+// new, sorted
+func Changed(old, new *Config) []string {
+	before, after := flatten(old.view(false), ""), flatten(new.view(false), "")
+	var changed []string
+	for key, value := range after {
+		if !reflect.DeepEqual(before[key], value) {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// flatten turns nested setting maps into dotted file keys
+func flatten(values map[string]interface{}, prefix string) map[string]interface{} {
+	flat := make(map[string]interface{})
+	for key, value := range values {
+		if nested, ok := value.(map[string]interface{}); ok {
+			for k, v := range flatten(nested, prefix+key+".") {
+				flat[k] = v
+			}
+			continue
+		}
+		flat[prefix+key] = value
+	}
+	return flat
+}
+
+// view returns the settings keyed like the config file, with secrets masked
+// when redact is set
+func (c *Config) view(redact bool) map[string]interface{} {
+	allowedIPs := make([]string, 0, len(c.API.AllowedIPs))
+	for _, prefix := range c.API.AllowedIPs {
+		allowedIPs = append(allowedIPs, prefix.String())
+	}
+
+	dsn, encryptionKey, webhookSecret, webhookURLs := c.Scheduler.DBDSN, c.Scheduler.EncryptionKey, c.Webhooks.Secret, c.Webhooks.URLs
+	if redact {
+		dsn = redactDSN(dsn)
+		encryptionKey = redactSecret(encryptionKey)
+		webhookSecret = redactSecret(webhookSecret)
+		webhookURLs = redactURLs(webhookURLs)
+	}
+
+	autoDownloadChats := make(map[string][]string, len(c.Media.AutoDownloadChats))
+	for chat, types := range c.Media.AutoDownloadChats {
+		autoDownloadChats[chat] = emptyIfNil(types)
+	}
+
+	api := map[string]interface{}{
+		"auth_disabled": c.API.AuthDisabled,
+		"allowed_ips":   allowedIPs,
+		"cors": map[string]interface{}{
+			"allowed_origins": emptyIfNil(c.API.CORS.AllowedOrigins),
+			"allowed_methods": emptyIfNil(c.API.CORS.AllowedMethods),
+			"allowed_headers": emptyIfNil(c.API.CORS.AllowedHeaders),
+			"max_age":         c.API.CORS.MaxAge.String(),
+		},
+		"rate_limit": map[string]interface{}{
+			"per_key": c.API.RateLimit.PerKey,
+			"per_ip":  c.API.RateLimit.PerIP,
+			"burst":   c.API.RateLimit.Burst,
+		},
+		"audit_retention_days": c.API.AuditRetentionDays,
+		"cache_ttl":            c.API.CacheTTL.String(),
+	}
+	keyScopes := make(map[string][]string, len(c.API.Keys))
+	for _, key := range c.API.Keys {
+		keyScopes[key.Name] = key.Scopes
+	}
+	api["key_scopes"] = keyScopes
+	if redact {
+		keyNames := make([]string, 0, len(c.API.Keys))
+		for _, key := range c.API.Keys {
+			keyNames = append(keyNames, key.Name)
+		}
+		api["key_names"] = keyNames
+	} else {
+		api["keys"] = c.API.Keys
+	}
+
+	return map[string]interface{}{
+		"whatsapp_db_path": c.WhatsAppDBPath,
+		"messages_db_path": c.MessagesDBPath,
+		"port":             c.Port,
+		"socket_path":      c.SocketPath,
+		"socket_mode":      fmt.Sprintf("%04o", c.SocketMode),
+		"grpc_port":        c.GRPCPort,
+		"shutdown_timeout": c.ShutdownTimeout.String(),
+		"api":              api,
+		"scheduler": map[string]interface{}{
+			"db_dsn":               dsn,
+			"single_db":            c.Scheduler.SingleDB,
+			"check_interval":       c.Scheduler.CheckInterval.String(),
+			"retention_mode":       c.Scheduler.RetentionMode,
+			"retention_days":       c.Scheduler.RetentionDays,
+			"backup_dir":           c.Scheduler.BackupDir,
+			"backup_keep":          c.Scheduler.BackupKeep,
+			"encryption_key":       encryptionKey,
+			"quiet_hours":          c.Scheduler.QuietHours.String(),
+			"quiet_hours_timezone": c.Scheduler.QuietHours.Timezone,
+		},
+		"webhooks": map[string]interface{}{
+			"urls":         emptyIfNil(webhookURLs),
+			"secret":       webhookSecret,
+			"events":       emptyIfNil(c.Webhooks.Events),
+			"timeout":      c.Webhooks.Timeout.String(),
+			"max_attempts": c.Webhooks.MaxAttempts,
+		},
+		"log": map[string]interface{}{
+			"level":       c.Log.Level,
+			"format":      c.Log.Format,
+			"file":        c.Log.File,
+			"max_size_mb": c.Log.MaxSizeMB,
+			"max_backups": c.Log.MaxBackups,
+		},
+		"media": map[string]interface{}{
+			"dir":                       c.Media.Dir,
+			"quota_mb":                  c.Media.QuotaMB,
+			"type_quota_mb":             emptyLimitsIfNil(c.Media.TypeQuotaMB),
+			"retention_days":            c.Media.RetentionDays,
+			"type_retention_days":       emptyLimitsIfNil(c.Media.TypeRetentionDays),
+			"auto_download":             emptyIfNil(c.Media.AutoDownload),
+			"auto_download_chats":       autoDownloadChats,
+			"auto_download_max_size_mb": c.Media.AutoDownloadMaxSizeMB,
+		},
+	}
+}
+
+// redactSecret masks a secret, leaving unset ones empty
+func redactSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redactedValue
+}
+
+// redactDSN masks the password of a database URL; SQLite paths are returned as is
+func redactDSN(dsn string) string {
+	if !strings.Contains(dsn, "://") {
+		return dsn
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return redactedValue
+	}
+	// Query parameters can carry a password too; mask it the way URL.Redacted does
+	if q := u.Query(); q.Has("password") {
+		q.Set("password", "xxxxx")
+		u.RawQuery = q.Encode()
+	}
+	return u.Redacted()
+}
+
+// redactURLs masks credentials and query strings, which often hold tokens
+func redactURLs(urls []string) []string {
+	out := make([]string, 0, len(urls))
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil {
+			out = append(out, redactedValue)
+			continue
+		}
+		if u.RawQuery != "" {
+			u.RawQuery = redactedValue
+		}
+		out = append(out, u.Redacted())
+	}
+	return out
+}
+
+// emptyIfNil keeps unset lists as [] rather than null in JSON
+func emptyIfNil(items []string) []string {
+	if items == nil {
+		return []string{}
+	}
+	return items
+}
+
+// emptyLimitsIfNil is emptyIfNil for limits by media type
+func emptyLimitsIfNil(limits map[string]int) map[string]int {
+	if limits == nil {
+		return map[string]int{}
+	}
+	return limits
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlValue is one setting read from the config file
+type yamlValue struct {
+	value string
+	// list is set when the value was a YAML sequence; value then holds its
+	// items joined with commas, as in the environment variable
+	list bool
+	line int
+}
+
+// parseYAML reads the config file: nested mappings whose leaves are scalars or
+// sequences of scalars, in block or flow style. Nested keys are flattened with
+// dots, e.g. "scheduler.check_interval". A key left empty is ignored.
+func parseYAML(data string) (map[string]yamlValue, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(data), &doc); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]yamlValue)
+	if len(doc.Content) == 0 {
+		// Empty or only comments
+		return values, nil
+	}
+	root := resolveAlias(doc.Content[0])
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: expected \"key: value\" settings", root.Line)
+	}
+	if err := flattenYAML(root, "", values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// flattenYAML adds the settings of a mapping to values, its keys prefixed
+// with prefix
+func flattenYAML(mapping *yaml.Node, prefix string, values map[string]yamlValue) error {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		keyNode, node := mapping.Content[i], resolveAlias(mapping.Content[i+1])
+		if keyNode.Kind != yaml.ScalarNode || keyNode.Value == "" {
+			return fmt.Errorf("line %d: missing key", keyNode.Line)
+		}
+		key := prefix + keyNode.Value
+
+		var value yamlValue
+		switch node.Kind {
+		case yaml.MappingNode:
+			if err := flattenYAML(node, key+".", values); err != nil {
+				return err
+			}
+			continue
+		case yaml.ScalarNode:
+			if node.Tag == "!!null" {
+				continue
+			}
+			value = yamlValue{value: node.Value, line: node.Line}
+		case yaml.SequenceNode:
+			items := make([]string, 0, len(node.Content))
+			for _, item := range node.Content {
+				item = resolveAlias(item)
+				if item.Kind != yaml.ScalarNode {
+					return fmt.Errorf("line %d: %s: list items must be plain values", item.Line, key)
+				}
+				if strings.Contains(item.Value, ",") {
+					return fmt.Errorf("line %d: %s: list item %q must not contain a comma", item.Line, key, item.Value)
+				}
+				items = append(items, item.Value)
+			}
+			value = yamlValue{value: strings.Join(items, ","), list: true, line: node.Line}
+		default:
+			return fmt.Errorf("line %d: %s: unsupported value", node.Line, key)
+		}
+
+		if _, dup := values[key]; dup {
+			return fmt.Errorf("line %d: %s is set twice", keyNode.Line, key)
+		}
+		values[key] = value
+	}
+	return nil
+}
+
+// resolveAlias returns the node an alias like *defaults points to
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
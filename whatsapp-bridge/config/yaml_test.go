@@ -0,0 +1,144 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want map[string]yamlValue
+	}{
+		{
+			name: "nested mappings and comments",
+			data: `# Bridge settings
+messages_db_path: /data/messages.db
+scheduler:
+  check_interval: 30s # every tick
+  quiet_hours: "22:00-07:00"
+`,
+			want: map[string]yamlValue{
+				"messages_db_path":         {value: "/data/messages.db", line: 2},
+				"scheduler.check_interval": {value: "30s", line: 4},
+				"scheduler.quiet_hours":    {value: "22:00-07:00", line: 5},
+			},
+		},
+		{
+			name: "flow sequence",
+			data: "api:\n  cors:\n    allowed_origins: [http://a, http://b]\n",
+			want: map[string]yamlValue{
+				"api.cors.allowed_origins": {value: "http://a,http://b", list: true, line: 3},
+			},
+		},
+		{
+			name: "block sequence",
+			data: "webhooks:\n  events:\n    - message\n    - receipt\n",
+			want: map[string]yamlValue{
+				"webhooks.events": {value: "message,receipt", list: true, line: 3},
+			},
+		},
+		{
+			name: "empty sequence",
+			data: "media:\n  auto_download: []\n",
+			want: map[string]yamlValue{
+				"media.auto_download": {value: "", list: true, line: 2},
+			},
+		},
+		{
+			name: "quoted values keep brackets",
+			data: `socket_path: '/run/it''s.sock'
+webhooks:
+  secret: "[not a list]"
+`,
+			want: map[string]yamlValue{
+				"socket_path":     {value: "/run/it's.sock", line: 1},
+				"webhooks.secret": {value: "[not a list]", line: 3},
+			},
+		},
+		{
+			name: "flow mapping",
+			data: "scheduler: {check_interval: 10s, retention_days: 7}\n",
+			want: map[string]yamlValue{
+				"scheduler.check_interval": {value: "10s", line: 1},
+				"scheduler.retention_days": {value: "7", line: 1},
+			},
+		},
+		{
+			name: "empty key is ignored",
+			data: "socket_path:\nport: 8080\n",
+			want: map[string]yamlValue{
+				"port": {value: "8080", line: 2},
+			},
+		},
+		{
+			name: "only comments",
+			data: "# nothing set\n",
+			want: map[string]yamlValue{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseYAML(tt.data)
+			if err != nil {
+				t.Fatalf("parseYAML: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseYAML = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"unterminated flow sequence", "api:\n  cors:\n    allowed_origins: [http://a, http://b\n", "yaml:"},
+		{"tab indentation", "scheduler:\n\tcheck_interval: 30s\n", "yaml:"},
+		{"not a mapping", "- port: 8080\n", "line 1"},
+		{"nested list item", "webhooks:\n  urls:\n    - [http://a]\n", "list items must be plain values"},
+		{"comma in list item", "webhooks:\n  events: [\"message,receipt\"]\n", "must not contain a comma"},
+		{"set twice", "scheduler.check_interval: 10s\nscheduler:\n  check_interval: 30s\n", "scheduler.check_interval is set twice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseYAML(tt.data)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("parseYAML error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyFileLists(t *testing.T) {
+	c := Default()
+	err := c.applyFile(`api:
+  cors:
+    allowed_origins: [http://a.example, http://b.example]
+webhooks:
+  events:
+    - message
+    - receipt
+`)
+	if err != nil {
+		t.Fatalf("applyFile: %v", err)
+	}
+	if want := []string{"http://a.example", "http://b.example"}; !reflect.DeepEqual(c.API.CORS.AllowedOrigins, want) {
+		t.Errorf("allowed origins = %q, want %q", c.API.CORS.AllowedOrigins, want)
+	}
+	if want := []string{"message", "receipt"}; !reflect.DeepEqual(c.Webhooks.Events, want) {
+		t.Errorf("webhook events = %q, want %q", c.Webhooks.Events, want)
+	}
+
+	err = Default().applyFile("scheduler:\n  check_interval: [10s, 20s]\n")
+	if err == nil || !strings.Contains(err.Error(), "takes a single value, not a list") {
+		t.Errorf("applyFile error = %v, want a list rejected for a single value", err)
+	}
+}
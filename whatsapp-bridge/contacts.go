@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"google.golang.org/protobuf/proto"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/audit"
+	"whatsapp-client/scheduler"
+	"whatsapp-client/validate"
+)
+
+// maxContactCards is the most contacts sent in one message
+const maxContactCards = 50
+
+// maxContactNameLength bounds the name on a contact card, in characters
+const maxContactNameLength = 256
+
+// maxCheckPhones is the most phone numbers one POST /v1/contacts/check takes
+const maxCheckPhones = 500
+
+// maxCheckQuery is how many phone numbers go in one query to WhatsApp
+const maxCheckQuery = 100
+
+// ContactCard is a contact to send, as a name and a phone number the bridge
+// turns into a vCard
+type ContactCard struct {
+	Name  string `json:"name"`
+	Phone string `json:"phone"`
+	// Organization is shown under the name, when set
+	Organization string `json:"organization,omitempty"`
+}
+
+// SendContactsRequest is the body of POST /v1/send/contact
+type SendContactsRequest struct {
+	Recipient string        `json:"recipient"`
+	Contacts  []ContactCard `json:"contacts"`
+	scheduler.SendOptions
+}
+
+// validate checks the request and normalizes the recipient and phone numbers
+func (req *SendContactsRequest) validate(v *validate.Validator) {
+	req.Recipient = v.Recipient("recipient", req.Recipient)
+	if len(req.Mentions) > 0 {
+		v.Add("mentions", validate.CodeInvalidValue, "must be empty, contact cards have no text to mention in")
+	}
+	req.ValidateNotText(v, "contact cards")
+	req.ValidateViewOnce(v, "")
+	switch {
+	case len(req.Contacts) == 0:
+		v.Add("contacts", validate.CodeRequired, "is required")
+	case len(req.Contacts) > maxContactCards:
+		v.Add("contacts", validate.CodeTooLong, fmt.Sprintf("must have at most %d contacts, got %d", maxContactCards, len(req.Contacts)))
+	}
+	for i := range req.Contacts {
+		card := &req.Contacts[i]
+		field := "contacts." + strconv.Itoa(i) + "."
+		if v.Required(field+"name", card.Name) {
+			v.MaxLength(field+"name", card.Name, maxContactNameLength)
+		}
+		if v.Required(field+"phone", card.Phone) {
+			card.Phone = v.Phone(field+"phone", card.Phone)
+		}
+		v.MaxLength(field+"organization", card.Organization, maxContactNameLength)
+	}
+}
+
+// CheckContactsRequest is the body of POST /v1/contacts/check
+type CheckContactsRequest struct {
+	Phones []string `json:"phones"`
+}
+
+// validate checks the request and normalizes the phone numbers
+func (req *CheckContactsRequest) validate(v *validate.Validator) {
+	switch {
+	case len(req.Phones) == 0:
+		v.Add("phones", validate.CodeRequired, "is required")
+	case len(req.Phones) > maxCheckPhones:
+		v.Add("phones", validate.CodeTooLong, fmt.Sprintf("must have at most %d phone numbers, got %d", maxCheckPhones, len(req.Phones)))
+	}
+	for i := range req.Phones {
+		field := "phones." + strconv.Itoa(i)
+		if v.Required(field, req.Phones[i]) {
+			req.Phones[i] = v.Phone(field, req.Phones[i])
+		}
+	}
+}
+
+// ContactCheck is whether a phone number has a WhatsApp account
+type ContactCheck struct {
+	Phone      string `json:"phone"`
+	OnWhatsApp bool   `json:"on_whatsapp"`
+	// JID is the account to send to, which may differ from the number as
+	// written, for example in countries that changed their numbering
+	JID string `json:"jid,omitempty"`
+	// VerifiedName is the name of a verified business account
+	VerifiedName string `json:"verified_name,omitempty"`
+}
+
+// checkContacts asks WhatsApp which of the phone numbers have an account,
+// returning one check per number in the same order. WhatsApp leaves numbers
+// it can't parse out of its answer; they count as not registered.
+func checkContacts(client *whatsmeow.Client, phones []string) ([]ContactCheck, error) {
+	found := make(map[string]ContactCheck, len(phones))
+	unique := make([]string, 0, len(phones))
+	for _, phone := range phones {
+		if _, ok := found[phone]; !ok {
+			found[phone] = ContactCheck{Phone: phone}
+			unique = append(unique, "+"+phone)
+		}
+	}
+	for len(unique) > 0 {
+		query := unique[:min(len(unique), maxCheckQuery)]
+		unique = unique[len(query):]
+		responses, err := client.IsOnWhatsApp(query)
+		if err != nil {
+			return nil, err
+		}
+		for _, response := range responses {
+			phone := strings.TrimPrefix(response.Query, "+")
+			if _, ok := found[phone]; !ok {
+				continue
+			}
+			check := ContactCheck{Phone: phone, OnWhatsApp: response.IsIn}
+			if response.IsIn {
+				check.JID = response.JID.String()
+			}
+			if response.VerifiedName != nil {
+				check.VerifiedName = response.VerifiedName.Details.GetVerifiedName()
+			}
+			found[phone] = check
+		}
+	}
+
+	checks := make([]ContactCheck, len(phones))
+	for i, phone := range phones {
+		checks[i] = found[phone]
+	}
+	return checks, nil
+}
+
+// vCard renders the card as the vCard 3.0 WhatsApp itself sends. The waid
+// parameter lets the recipient's phone open a chat with the number.
+func (card ContactCard) vCard() string {
+	var b strings.Builder
+	b.WriteString("BEGIN:VCARD\nVERSION:3.0\n")
+	fmt.Fprintf(&b, "N:;%s;;;\n", vCardEscape(card.Name))
+	fmt.Fprintf(&b, "FN:%s\n", vCardEscape(card.Name))
+	if card.Organization != "" {
+		fmt.Fprintf(&b, "ORG:%s\n", vCardEscape(card.Organization))
+	}
+	fmt.Fprintf(&b, "TEL;type=CELL;type=VOICE;waid=%s:+%s\n", card.Phone, card.Phone)
+	b.WriteString("END:VCARD")
+	return b.String()
+}
+
+// vCardEscaper escapes the characters that are special in vCard values
+var vCardEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\r\n", `\n`, "\n", `\n`)
+
+func vCardEscape(s string) string {
+	return vCardEscaper.Replace(s)
+}
+
+// vCardUnescaper reverses vCardEscaper
+var vCardUnescaper = strings.NewReplacer(`\\`, `\`, `\,`, ",", `\;`, ";", `\n`, "\n", `\N`, "\n")
+
+// contactsMessage builds the message for the cards: a contact message for one,
+// a contacts array for several
+func contactsMessage(cards []ContactCard) *waProto.Message {
+	messages := make([]*waProto.ContactMessage, len(cards))
+	for i, card := range cards {
+		messages[i] = &waProto.ContactMessage{
+			DisplayName: proto.String(card.Name),
+			Vcard:       proto.String(card.vCard()),
+		}
+	}
+	if len(messages) == 1 {
+		return &waProto.Message{ContactMessage: messages[0]}
+	}
+	return &waProto.Message{ContactsArrayMessage: &waProto.ContactsArrayMessage{
+		DisplayName: proto.String(fmt.Sprintf("%d contacts", len(messages))),
+		Contacts:    messages,
+	}}
+}
+
+// SharedContact is a contact card received in a message
+type SharedContact struct {
+	Name string `json:"name"`
+	// Phone is the first phone number on the card, as written there
+	Phone string `json:"phone,omitempty"`
+	// WhatsAppJID is set when the card says which WhatsApp account the number has
+	WhatsAppJID string `json:"whatsapp_jid,omitempty"`
+	VCard       string `json:"vcard"`
+}
+
+// extractContacts returns the contact cards a message shares, if any
+func extractContacts(msg *waProto.Message) []SharedContact {
+	var cards []*waProto.ContactMessage
+	if contact := msg.GetContactMessage(); contact != nil {
+		cards = append(cards, contact)
+	}
+	if array := msg.GetContactsArrayMessage(); array != nil {
+		cards = append(cards, array.GetContacts()...)
+	}
+
+	contacts := make([]SharedContact, 0, len(cards))
+	for _, card := range cards {
+		contact := parseVCard(card.GetVcard())
+		if contact.Name == "" {
+			contact.Name = card.GetDisplayName()
+		}
+		contacts = append(contacts, contact)
+	}
+	return contacts
+}
+
+// parseVCard reads the name and first phone number of a vCard. Lines folded
+// onto the next one are joined first.
+func parseVCard(vcard string) SharedContact {
+	contact := SharedContact{VCard: vcard}
+	unfolded := strings.NewReplacer("\r\n ", "", "\r\n\t", "", "\n ", "", "\n\t", "").Replace(vcard)
+	for _, line := range strings.Split(unfolded, "\n") {
+		name, value, ok := strings.Cut(strings.TrimRight(line, "\r"), ":")
+		if !ok {
+			continue
+		}
+		params := strings.Split(name, ";")
+		// Grouped properties look like item1.TEL
+		property := strings.ToUpper(params[0])
+		if _, after, grouped := strings.Cut(property, "."); grouped {
+			property = after
+		}
+
+		switch {
+		case property == "FN" && contact.Name == "":
+			contact.Name = vCardUnescaper.Replace(value)
+		case property == "TEL" && contact.Phone == "":
+			contact.Phone = value
+			for _, param := range params[1:] {
+				if key, waid, ok := strings.Cut(param, "="); ok && strings.EqualFold(key, "waid") && waid != "" {
+					contact.WhatsAppJID = waid + "@s.whatsapp.net"
+				}
+			}
+		}
+	}
+	return contact
+}
+
+// contactsSummary is the text stored for a message that shares contacts, so
+// it shows up in message lists like any other
+func contactsSummary(contacts []SharedContact) string {
+	names := make([]string, len(contacts))
+	for i, contact := range contacts {
+		names[i] = contact.Name
+	}
+	if len(names) == 1 {
+		return "Contact: " + names[0]
+	}
+	return "Contacts: " + strings.Join(names, ", ")
+}
+
+// StoreContacts records the contact cards of a message, replacing any stored
+// before for it
+func (store *MessageStore) StoreContacts(id, chatJID string, contacts []SharedContact) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM message_contacts WHERE message_id = ? AND chat_jid = ?", id, chatJID); err != nil {
+		return err
+	}
+	for i, contact := range contacts {
+		if _, err := tx.Exec(
+			`INSERT INTO message_contacts (message_id, chat_jid, position, name, phone, whatsapp_jid, vcard)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			id, chatJID, i, contact.Name, contact.Phone, contact.WhatsAppJID, contact.VCard,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// storeMessageContacts stores the contact cards of a message that has any
+func storeMessageContacts(store *MessageStore, id, chatJID string, msg *waProto.Message) {
+	contacts := extractContacts(msg)
+	if len(contacts) == 0 {
+		return
+	}
+	if err := store.StoreContacts(id, chatJID, contacts); err != nil {
+		slog.Warn("Failed to store contact cards", "message_id", id, "chat_jid", chatJID, "error", err)
+	}
+}
+
+// registerContactHandlers adds the endpoints for sending contact cards and
+// checking phone numbers
+func registerContactHandlers(mux *http.ServeMux, client *whatsmeow.Client, store *MessageStore) {
+	// POST /v1/send/contact - Send one or more contacts, as a name and phone number each
+	mux.HandleFunc("POST /v1/send/contact", func(w http.ResponseWriter, r *http.Request) {
+		var req SendContactsRequest
+		var v validate.Validator
+		if v.Decode(r, &req) {
+			req.validate(&v)
+		}
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		contextInfo, ok := requestContext(w, r, store, client, req.Recipient, req.SendOptions)
+		if !ok {
+			return
+		}
+
+		if !client.IsConnected() {
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not connected to WhatsApp")
+			return
+		}
+
+		// A client hanging up must not abort a send that is under way
+		ctx := context.WithoutCancel(r.Context())
+		start := time.Now()
+		msg := contactsMessage(req.Contacts)
+		setContextInfo(msg, contextInfo)
+		result := sendBuilt(ctx, client, req.Recipient, "contact", msg)
+		latency := time.Since(start)
+		storeSent(ctx, client, store, result, msg)
+		if !result.Success {
+			slog.WarnContext(ctx, "Failed to send contacts", "recipient", req.Recipient, "status", "failed", "latency", latency, "error", result.Message)
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, result.Message)
+			return
+		}
+
+		slog.InfoContext(ctx, "Sent contacts", "whatsapp_message_id", result.MessageID, "recipient", req.Recipient, "count", len(req.Contacts), "status", "sent", "latency", latency)
+		audit.SetResource(r.Context(), result.MessageID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(SendMessageResponse{
+			Success:   true,
+			Message:   result.Message,
+			MessageID: result.MessageID,
+			ChatJID:   result.ChatJID,
+		})
+	})
+
+	// POST /v1/contacts/check - Whether phone numbers have a WhatsApp account and
+	// the JID to send to. Sending to a number without one fails, so check a list
+	// before scheduling messages to it.
+	mux.HandleFunc("POST /v1/contacts/check", func(w http.ResponseWriter, r *http.Request) {
+		var req CheckContactsRequest
+		var v validate.Validator
+		if v.Decode(r, &req) {
+			req.validate(&v)
+		}
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+
+		if !client.IsConnected() {
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not connected to WhatsApp")
+			return
+		}
+
+		checks, err := checkContacts(client, req.Phones)
+		if err != nil {
+			slog.WarnContext(r.Context(), "Failed to check phone numbers", "count", len(req.Phones), "error", err)
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, "Failed to check phone numbers: "+err.Error())
+			return
+		}
+		registered := 0
+		for _, check := range checks {
+			if check.OnWhatsApp {
+				registered++
+			}
+		}
+		slog.InfoContext(r.Context(), "Checked phone numbers", "count", len(checks), "registered", registered)
+		audit.SetResource(r.Context(), fmt.Sprintf("%d phone numbers", len(checks)))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    true,
+			"registered": registered,
+			"results":    checks,
+		})
+	})
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/validate"
+)
+
+const (
+	// defaultContactMatches and maxContactMatches are how many contacts a
+	// search returns by default and at most
+	defaultContactMatches = 10
+	maxContactMatches     = 100
+	// minPhoneDigits is how many digits a query needs to be matched against
+	// phone numbers
+	minPhoneDigits = 3
+)
+
+// Scores of how a word of a query matches a word of a name, best first
+const (
+	scoreExact     = 100
+	scorePrefix    = 80
+	scoreSubstring = 40
+	// scoreTypo is for a word within a typo or two of the start of a name
+	// word, less 10 for each typo
+	scoreTypo = 40
+)
+
+// ContactMatch is a contact found by a search, with how well it matched
+type ContactMatch struct {
+	StoredContact
+	// Score ranks the matches, higher is better
+	Score int `json:"score"`
+	// MatchedField is the field that matched best: full_name, first_name,
+	// push_name, business_name or phone_number
+	MatchedField string `json:"matched_field"`
+}
+
+// folder lowercases text and strips its accents, so José matches jose
+var folder = transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+// foldWords splits text into words, lowercased and without accents
+func foldWords(text string) []string {
+	folded, _, err := transform.String(folder, strings.ToLower(text))
+	if err != nil {
+		folded = strings.ToLower(text)
+	}
+	return strings.FieldsFunc(folded, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// maxTypos is how many typos a query word of n characters may have
+func maxTypos(n int) int {
+	switch {
+	case n < 4:
+		return 0
+	case n < 7:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// editDistance is the number of insertions, deletions, substitutions and
+// swaps of adjacent characters that turn a into b
+func editDistance(a, b []rune) int {
+	// Three rows of the table: two back, the previous one and this one
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	row := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		row[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			row[j] = min(min(prev[j]+1, row[j-1]+1), prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				row[j] = min(row[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, row = prev, row, prev2
+	}
+	return prev[len(b)]
+}
+
+// wordScore is how well the query word q matches the name word w, 0 if it
+// doesn't
+func wordScore(q, w string) int {
+	switch {
+	case q == w:
+		return scoreExact
+	case strings.HasPrefix(w, q):
+		return scorePrefix
+	case len(q) >= 3 && strings.Contains(w, q):
+		return scoreSubstring
+	}
+	typos := maxTypos(len([]rune(q)))
+	if typos == 0 {
+		return 0
+	}
+	// A typo in what was typed so far of w, or in the whole of w
+	qr, wr := []rune(q), []rune(w)
+	distance := editDistance(qr, wr)
+	if len(wr) > len(qr) {
+		distance = min(distance, editDistance(qr, wr[:len(qr)]))
+	}
+	if distance > typos {
+		return 0
+	}
+	return scoreTypo - 10*distance
+}
+
+// nameScore is how well the words of a query match a name: the sum of each
+// word's best match, 0 unless every word matches. Words matching the start
+// of the name, in order, score a little more.
+func nameScore(query, name []string) int {
+	total := 0
+	for i, q := range query {
+		best := 0
+		for j, w := range name {
+			score := wordScore(q, w)
+			if score > 0 && i == j {
+				score += 5
+			}
+			best = max(best, score)
+		}
+		if best == 0 {
+			return 0
+		}
+		total += best
+	}
+	return total
+}
+
+// phoneScore is how well the digits of a query match a phone number
+func phoneScore(digits, phone string) int {
+	switch {
+	case len(digits) < minPhoneDigits || phone == "":
+		return 0
+	case digits == phone:
+		return scoreExact
+	case strings.HasPrefix(phone, digits):
+		return scorePrefix
+	case strings.Contains(phone, digits):
+		return scoreSubstring
+	}
+	return 0
+}
+
+// matchContact scores a contact against a query's words and digits, 0 if it
+// doesn't match. A saved name matching counts a little more than the names
+// people gave themselves.
+func matchContact(contact StoredContact, words []string, digits string) ContactMatch {
+	match := ContactMatch{StoredContact: contact}
+	for _, field := range []struct {
+		name  string
+		value string
+		bonus int
+	}{
+		{"full_name", contact.FullName, 10},
+		{"first_name", contact.FirstName, 10},
+		{"business_name", contact.BusinessName, 0},
+		{"push_name", contact.PushName, 0},
+	} {
+		if field.value == "" {
+			continue
+		}
+		if score := nameScore(words, foldWords(field.value)); score > 0 && score+field.bonus > match.Score {
+			match.Score, match.MatchedField = score+field.bonus, field.name
+		}
+	}
+	if score := phoneScore(digits, contact.PhoneNumber); score*len(words) > match.Score {
+		// A number scores like a name matching every word
+		match.Score, match.MatchedField = score*len(words), "phone_number"
+	}
+	return match
+}
+
+// queryDigits is the phone number a query is, without +, spaces and dashes,
+// or "" when it has other characters
+func queryDigits(query string) string {
+	var digits strings.Builder
+	for _, r := range query {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' || r == ' ' || r == '-' || r == '(' || r == ')' || r == '.':
+		default:
+			return ""
+		}
+	}
+	return digits.String()
+}
+
+// GetAllContacts returns every stored contact
+func (store *MessageStore) GetAllContacts() ([]StoredContact, error) {
+	rows, err := store.db.Query("SELECT " + contactColumns + " FROM contacts")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contacts []StoredContact
+	for rows.Next() {
+		contact, err := scanContact(rows)
+		if err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, contact)
+	}
+	return contacts, rows.Err()
+}
+
+// SearchContacts returns the limit contacts that best match query, by saved
+// name, push name, business name or phone number. Words in query match the
+// start of name words, or anywhere in them, or with a typo or two.
+func (store *MessageStore) SearchContacts(query string, limit int) ([]ContactMatch, error) {
+	words := foldWords(query)
+	digits := queryDigits(query)
+	if len(words) == 0 {
+		return []ContactMatch{}, nil
+	}
+	contacts, err := store.GetAllContacts()
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []ContactMatch{}
+	for _, contact := range contacts {
+		if match := matchContact(contact, words, digits); match.Score > 0 {
+			matches = append(matches, match)
+		}
+	}
+	slices.SortFunc(matches, func(a, b ContactMatch) int {
+		return cmp.Or(
+			cmp.Compare(b.Score, a.Score),
+			cmp.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)),
+			cmp.Compare(a.JID, b.JID),
+		)
+	})
+	return matches[:min(len(matches), limit)], nil
+}
+
+// registerContactSearchHandlers adds the contact search endpoint
+func registerContactSearchHandlers(mux *http.ServeMux, store *MessageStore) {
+	// GET /v1/contacts/search?q=jo - The contacts that best match q, best
+	// first, to pick a recipient by name. ?limit= sets how many, 10 by
+	// default.
+	mux.HandleFunc("GET /v1/contacts/search", func(w http.ResponseWriter, r *http.Request) {
+		var v validate.Validator
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		if v.Required("q", query) {
+			v.MaxLength("q", query, maxContactQueryLength)
+			if len(foldWords(query)) == 0 {
+				v.Add("q", validate.CodeInvalidValue, "must have a name or number to search for")
+			}
+		}
+		limit := defaultContactMatches
+		if value := r.URL.Query().Get("limit"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > maxContactMatches {
+				v.Add("limit", validate.CodeInvalidValue, "must be between 1 and "+strconv.Itoa(maxContactMatches))
+			}
+			limit = n
+		}
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+
+		matches, err := store.SearchContacts(query, limit)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to search contacts", "error", err)
+			apierror.Internal(w, "Failed to search contacts")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"query":    query,
+			"contacts": matches,
+		})
+	})
+}
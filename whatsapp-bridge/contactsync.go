@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/pagination"
+	"whatsapp-client/validate"
+)
+
+// contactSyncInterval is how often the whole contact store is copied again,
+// to catch names whatsmeow stored without an event, like those in history
+// syncs
+const contactSyncInterval = time.Hour
+
+// maxContactQueryLength bounds the text contacts are searched for, in
+// characters
+const maxContactQueryLength = 256
+
+// StoredContact is a person the account knows, as whatsmeow's contact store
+// had them when last synced
+type StoredContact struct {
+	JID string `json:"jid"`
+	// PhoneNumber is the person's number without +, when the bridge knows it
+	PhoneNumber string `json:"phone_number,omitempty"`
+	// Name is the best name the bridge has: the saved name, else the business
+	// name, else the push name, else the phone number
+	Name string `json:"name"`
+	// FullName and FirstName are the name saved in the account's address book
+	FullName  string `json:"full_name,omitempty"`
+	FirstName string `json:"first_name,omitempty"`
+	// PushName is the name the person gave themselves
+	PushName string `json:"push_name,omitempty"`
+	// BusinessName is the verified name of a business account
+	BusinessName string    `json:"business_name,omitempty"`
+	IsBusiness   bool      `json:"is_business"`
+	SyncedAt     time.Time `json:"synced_at"`
+}
+
+// contactsCursor is the sort key of the last contact of a page
+type contactsCursor struct {
+	Name string `json:"n"`
+	JID  string `json:"j"`
+}
+
+// storedContact turns whatsmeow's info on jid into a StoredContact. A contact
+// known by LID gets the phone number whatsmeow maps it to, if any.
+func storedContact(ctx context.Context, client *whatsmeow.Client, jid types.JID, info types.ContactInfo, syncedAt time.Time) StoredContact {
+	contact := StoredContact{
+		JID:          jid.String(),
+		FullName:     info.FullName,
+		FirstName:    info.FirstName,
+		PushName:     info.PushName,
+		BusinessName: info.BusinessName,
+		IsBusiness:   info.BusinessName != "",
+		SyncedAt:     syncedAt,
+	}
+	switch jid.Server {
+	case types.DefaultUserServer:
+		contact.PhoneNumber = jid.User
+	case types.HiddenUserServer:
+		if pn, err := client.Store.LIDs.GetPNForLID(ctx, jid); err == nil && !pn.IsEmpty() {
+			contact.PhoneNumber = pn.User
+		}
+	}
+	for _, name := range []string{contact.FullName, contact.BusinessName, contact.PushName, contact.PhoneNumber, jid.User} {
+		if name != "" {
+			contact.Name = name
+			break
+		}
+	}
+	return contact
+}
+
+// contactColumns are the columns of the contacts table, in the order
+// contacts are written and scanContact reads them
+const contactColumns = "jid, phone_number, name, full_name, first_name, push_name, business_name, is_business, synced_at"
+
+// insertContact is the statement writing a contact from its contactArgs
+const insertContact = "INSERT OR REPLACE INTO contacts (" + contactColumns + ") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+// contactArgs are the values of contactColumns for contact
+func contactArgs(contact StoredContact) []interface{} {
+	return []interface{}{contact.JID, contact.PhoneNumber, contact.Name, contact.FullName, contact.FirstName,
+		contact.PushName, contact.BusinessName, contact.IsBusiness, contact.SyncedAt}
+}
+
+// StoreContact records one contact
+func (store *MessageStore) StoreContact(contact StoredContact) error {
+	_, err := store.db.Exec(insertContact, contactArgs(contact)...)
+	return err
+}
+
+// ReplaceContacts records the whole contact store, synced at syncedAt, and
+// forgets the contacts no longer in it
+func (store *MessageStore) ReplaceContacts(contacts []StoredContact, syncedAt time.Time) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, contact := range contacts {
+		if _, err := tx.Exec(insertContact, contactArgs(contact)...); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM contacts WHERE synced_at < ?", syncedAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// scanContact reads a contact from a row of contactColumns
+func scanContact(row interface{ Scan(...interface{}) error }) (StoredContact, error) {
+	var contact StoredContact
+	err := row.Scan(&contact.JID, &contact.PhoneNumber, &contact.Name, &contact.FullName, &contact.FirstName,
+		&contact.PushName, &contact.BusinessName, &contact.IsBusiness, &contact.SyncedAt)
+	return contact, err
+}
+
+// GetContacts returns the stored contacts by name, then JID. A query keeps
+// those with it in one of their names or their phone number.
+func (store *MessageStore) GetContacts(query string, after *contactsCursor, limit int) ([]StoredContact, error) {
+	sqlQuery := "SELECT " + contactColumns + " FROM contacts WHERE 1 = 1"
+	var args []interface{}
+	if query != "" {
+		pattern := "%" + likeEscaper.Replace(query) + "%"
+		sqlQuery += ` AND (name LIKE ? ESCAPE '\' OR full_name LIKE ? ESCAPE '\' OR push_name LIKE ? ESCAPE '\'
+			OR business_name LIKE ? ESCAPE '\' OR phone_number LIKE ? ESCAPE '\')`
+		args = append(args, pattern, pattern, pattern, pattern, pattern)
+	}
+	if after != nil {
+		sqlQuery += " AND (name > ? COLLATE NOCASE OR (name = ? COLLATE NOCASE AND jid > ?))"
+		args = append(args, after.Name, after.Name, after.JID)
+	}
+	sqlQuery += " ORDER BY name COLLATE NOCASE, jid LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := store.db.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contacts := []StoredContact{}
+	for rows.Next() {
+		contact, err := scanContact(rows)
+		if err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, contact)
+	}
+	return contacts, rows.Err()
+}
+
+// contactSyncer copies whatsmeow's contact store into the message store: all
+// of it every contactSyncInterval, after each connect and after history
+// syncs, and one contact at a time as their names change
+type contactSyncer struct {
+	client *whatsmeow.Client
+	store  *MessageStore
+	// all asks for a full sync, contacts for one of a contact
+	all      chan struct{}
+	contacts chan types.JID
+}
+
+// newContactSyncer creates a contactSyncer; Run starts it
+func newContactSyncer(client *whatsmeow.Client, store *MessageStore) *contactSyncer {
+	return &contactSyncer{
+		client:   client,
+		store:    store,
+		all:      make(chan struct{}, 1),
+		contacts: make(chan types.JID, 256),
+	}
+}
+
+// SyncAll asks for every contact to be copied again
+func (s *contactSyncer) SyncAll() {
+	select {
+	case s.all <- struct{}{}:
+	default:
+		// A full sync is already due
+	}
+}
+
+// handleEvent copies a contact again when whatsmeow stored a new name for it.
+// whatsmeow updates its store before dispatching these events.
+func (s *contactSyncer) handleEvent(evt interface{}) {
+	var jid types.JID
+	switch v := evt.(type) {
+	case *events.Connected, *events.HistorySync:
+		s.SyncAll()
+		return
+	case *events.Contact:
+		jid = v.JID
+	case *events.PushName:
+		jid = v.JID
+	case *events.BusinessName:
+		jid = v.JID
+	default:
+		return
+	}
+	select {
+	case s.contacts <- jid.ToNonAD():
+	default:
+		// Plenty of contacts are queued; the next full sync catches this one
+	}
+}
+
+// Run syncs contacts until ctx is done
+func (s *contactSyncer) Run(ctx context.Context) {
+	ticker := time.NewTicker(contactSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.syncAll(ctx)
+		case <-s.all:
+			s.syncAll(ctx)
+		case jid := <-s.contacts:
+			s.syncContact(ctx, jid)
+		}
+	}
+}
+
+// syncAll copies the whole contact store
+func (s *contactSyncer) syncAll(ctx context.Context) {
+	if s.client.Store.ID == nil {
+		return
+	}
+	all, err := s.client.Store.Contacts.GetAllContacts(ctx)
+	if err != nil {
+		slog.Warn("Failed to sync contacts", "error", err)
+		return
+	}
+	now := time.Now()
+	contacts := make([]StoredContact, 0, len(all))
+	for jid, info := range all {
+		contacts = append(contacts, storedContact(ctx, s.client, jid, info, now))
+	}
+	if err := s.store.ReplaceContacts(contacts, now); err != nil {
+		slog.Warn("Failed to store contacts", "error", err)
+		return
+	}
+	slog.Info("Synced contacts", "contacts", len(contacts))
+}
+
+// syncContact copies one contact
+func (s *contactSyncer) syncContact(ctx context.Context, jid types.JID) {
+	if s.client.Store.ID == nil {
+		return
+	}
+	info, err := s.client.Store.Contacts.GetContact(ctx, jid)
+	if err == nil && info.Found {
+		err = s.store.StoreContact(storedContact(ctx, s.client, jid, info, time.Now()))
+	}
+	if err != nil {
+		slog.Warn("Failed to sync contact", "jid", jid.String(), "error", err)
+	}
+}
+
+// registerContactListHandlers adds the endpoint listing the stored contacts
+func registerContactListHandlers(mux *http.ServeMux, store *MessageStore) {
+	// GET /v1/contacts - The contacts synced from WhatsApp, by name, a page at
+	// a time. ?q= keeps those with it in a name or their phone number.
+	mux.HandleFunc("GET /v1/contacts", func(w http.ResponseWriter, r *http.Request) {
+		page, err := pagination.FromRequest(r)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+		var v validate.Validator
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		v.MaxLength("q", query, maxContactQueryLength)
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		// Phone numbers are stored without +
+		query = strings.TrimPrefix(query, "+")
+		var cursor *contactsCursor
+		var decoded contactsCursor
+		if ok, err := page.Decode(&decoded); err != nil {
+			apierror.BadRequest(w, "Invalid cursor")
+			return
+		} else if ok {
+			cursor = &decoded
+		}
+
+		contacts, err := store.GetContacts(query, cursor, page.Limit+1)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to get contacts", "error", err)
+			apierror.Internal(w, "Failed to get contacts")
+			return
+		}
+		contacts, next := pagination.Page(contacts, page, func(contact StoredContact) interface{} {
+			return contactsCursor{Name: contact.Name, JID: contact.JID}
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":     true,
+			"contacts":    contacts,
+			"next_cursor": next,
+		})
+	})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/audit"
+	"whatsapp-client/validate"
+)
+
+// disappearingTimers are the timers WhatsApp apps offer, by the names the API
+// takes. Apps ignore any other duration.
+var disappearingTimers = map[string]time.Duration{
+	"off": whatsmeow.DisappearingTimerOff,
+	"24h": whatsmeow.DisappearingTimer24Hours,
+	"7d":  whatsmeow.DisappearingTimer7Days,
+	"90d": whatsmeow.DisappearingTimer90Days,
+}
+
+// SetDisappearingRequest is the body of PUT /v1/chats/{jid}/disappearing
+type SetDisappearingRequest struct {
+	// Timer is how long messages last: off, 24h, 7d or 90d
+	Timer string `json:"timer"`
+}
+
+// StoreDisappearingTimer records the disappearing-message timer of a chat, in
+// seconds with 0 for off. A setting older than the one stored is ignored.
+func (store *MessageStore) StoreDisappearingTimer(chatJID string, seconds uint32, setAt time.Time) error {
+	_, err := store.db.Exec(
+		`INSERT INTO chat_disappearing (chat_jid, timer, set_at) VALUES (?, ?, ?)
+		ON CONFLICT (chat_jid) DO UPDATE SET timer = excluded.timer, set_at = excluded.set_at
+		WHERE excluded.set_at >= chat_disappearing.set_at`,
+		chatJID, seconds, setAt,
+	)
+	return err
+}
+
+// GetDisappearingTimer returns the disappearing-message timer of a chat in
+// seconds and when it was set. A chat the bridge knows no timer for has it off.
+func (store *MessageStore) GetDisappearingTimer(chatJID string) (uint32, time.Time, error) {
+	var seconds uint32
+	var setAt time.Time
+	err := store.db.QueryRow("SELECT timer, set_at FROM chat_disappearing WHERE chat_jid = ?", chatJID).Scan(&seconds, &setAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, time.Time{}, nil
+	}
+	return seconds, setAt, err
+}
+
+// isDisappearingSetting reports whether msg turns disappearing messages on or
+// off in a chat
+func isDisappearingSetting(msg *waProto.Message) bool {
+	return msg.GetProtocolMessage().GetType() == waProto.ProtocolMessage_EPHEMERAL_SETTING
+}
+
+// handleDisappearingSetting records the timer someone set in a chat, or that
+// the account set from another device
+func handleDisappearingSetting(store *MessageStore, msg *events.Message) {
+	setting := msg.Message.GetProtocolMessage()
+	setAt := msg.Info.Timestamp
+	if ts := setting.GetEphemeralSettingTimestamp(); ts > 0 {
+		setAt = time.Unix(ts, 0)
+	}
+	if err := store.StoreDisappearingTimer(msg.Info.Chat.String(), setting.GetEphemeralExpiration(), setAt); err != nil {
+		slog.Warn("Failed to store disappearing timer", "chat_jid", msg.Info.Chat.String(), "error", err)
+	}
+}
+
+// handleGroupDisappearing records a group's timer when an admin changes it.
+// Groups announce the change as a group update rather than a message.
+func handleGroupDisappearing(store *MessageStore, info *events.GroupInfo) {
+	if info.Ephemeral == nil {
+		return
+	}
+	var seconds uint32
+	if info.Ephemeral.IsEphemeral {
+		seconds = info.Ephemeral.DisappearingTimer
+	}
+	if err := store.StoreDisappearingTimer(info.JID.String(), seconds, info.Timestamp); err != nil {
+		slog.Warn("Failed to store disappearing timer", "chat_jid", info.JID.String(), "error", err)
+	}
+}
+
+// registerDisappearingHandlers adds the endpoint for setting a chat's
+// disappearing-message timer
+func registerDisappearingHandlers(mux *http.ServeMux, client *whatsmeow.Client, store *MessageStore) {
+	// PUT /v1/chats/{jid}/disappearing - Turn disappearing messages on with one
+	// of WhatsApp's timers, or off. Messages the bridge sends to the chat
+	// disappear after the same time.
+	mux.HandleFunc("PUT /v1/chats/{jid}/disappearing", func(w http.ResponseWriter, r *http.Request) {
+		var req SetDisappearingRequest
+		var v validate.Validator
+		chat := v.Recipient("jid", r.PathValue("jid"))
+		if v.Decode(r, &req) {
+			if v.Required("timer", req.Timer) {
+				v.OneOf("timer", req.Timer, "off", "24h", "7d", "90d")
+			}
+		}
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		chatJID, err := parseRecipient(chat)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+
+		if !client.IsConnected() {
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not connected to WhatsApp")
+			return
+		}
+
+		timer := disappearingTimers[req.Timer]
+		setAt := time.Now()
+		if err := client.SetDisappearingTimer(chatJID, timer, setAt); err != nil {
+			slog.WarnContext(r.Context(), "Failed to set disappearing timer", "chat_jid", chatJID, "timer", req.Timer, "error", err)
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, "Failed to set disappearing timer: "+err.Error())
+			return
+		}
+		slog.InfoContext(r.Context(), "Set disappearing timer", "chat_jid", chatJID, "timer", req.Timer)
+		audit.SetResource(r.Context(), chatJID.String())
+
+		// The phone's own change is echoed back, but not the bridge's
+		if err := store.StoreDisappearingTimer(chatJID.String(), uint32(timer.Seconds()), setAt); err != nil {
+			slog.WarnContext(r.Context(), "Failed to store disappearing timer", "chat_jid", chatJID, "error", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"chat_jid": chatJID.String(),
+			"timer":    req.Timer,
+			"seconds":  int(timer.Seconds()),
+		})
+	})
+}
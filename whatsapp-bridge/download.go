@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"go.mau.fi/whatsmeow"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/validate"
+)
+
+// sniffLength is how much of a file is read to work out its content type
+const sniffLength = 512
+
+// mediaContentType returns the content type of a downloaded file, from its
+// extension when that is known and from its first bytes otherwise
+func mediaContentType(f *os.File, filename string) (string, error) {
+	if contentType := mime.TypeByExtension(filepath.Ext(filename)); contentType != "" {
+		return contentType, nil
+	}
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return sniffMimetype(head[:n]), nil
+}
+
+// registerMediaDownloadHandlers adds the endpoint for fetching the media of a
+// received message
+func registerMediaDownloadHandlers(mux *http.ServeMux, client *whatsmeow.Client, store *MessageStore) {
+	// GET /v1/messages/{chat}/{id}/media - The attachment of a stored message,
+	// downloaded from WhatsApp and decrypted the first time it is asked for and
+	// served from the local copy after that. Range requests are supported.
+	mux.HandleFunc("GET /v1/messages/{chat}/{id}/media", func(w http.ResponseWriter, r *http.Request) {
+		var v validate.Validator
+		chat := v.Recipient("chat", r.PathValue("chat"))
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		chatJID, err := parseRecipient(chat)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+		messageID := r.PathValue("id")
+
+		success, _, filename, path, err := downloadMedia(client, store, messageID, chatJID.String())
+		if !success || err != nil {
+			status, code, message := downloadFailure(err)
+			apierror.Write(w, status, code, message)
+			return
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to open downloaded media", "path", path, "error", err)
+			apierror.Internal(w, "Failed to read downloaded media")
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		var contentType string
+		if err == nil {
+			contentType, err = mediaContentType(f, filename)
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to read downloaded media", "path", path, "error", err)
+			apierror.Internal(w, "Failed to read downloaded media")
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": filename}))
+		http.ServeContent(w, r, filename, info.ModTime(), f)
+	})
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/audit"
+	bridgeevents "whatsapp-client/events"
+	"whatsapp-client/validate"
+)
+
+// EditMessageRequest is the body of PUT /v1/messages/{chat}/{id}
+type EditMessageRequest struct {
+	// Message is the new text
+	Message string `json:"message"`
+}
+
+// MessageEdit is one edit of a message: the text it replaced, and when
+type MessageEdit struct {
+	PreviousContent string    `json:"previous_content"`
+	EditedAt        time.Time `json:"edited_at"`
+}
+
+// EditMessage replaces the text of a stored message and keeps the old text in
+// its edit history. It returns sql.ErrNoRows when the message isn't stored. An
+// edit older than the latest one stored is ignored, as is the same edit twice.
+func (store *MessageStore) EditMessage(id, chatJID, content string, editedAt time.Time) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var previous string
+	if err := tx.QueryRow("SELECT content FROM messages WHERE id = ? AND chat_jid = ?", id, chatJID).Scan(&previous); err != nil {
+		return err
+	}
+	var newer bool
+	err = tx.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM message_edits WHERE message_id = ? AND chat_jid = ? AND edited_at >= ?)",
+		id, chatJID, editedAt,
+	).Scan(&newer)
+	if err != nil || newer {
+		return err
+	}
+
+	if _, err := tx.Exec(
+		"INSERT INTO message_edits (message_id, chat_jid, previous_content, edited_at) VALUES (?, ?, ?, ?)",
+		id, chatJID, previous, editedAt,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE messages SET content = ? WHERE id = ? AND chat_jid = ?", content, id, chatJID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetMessageEdits returns the edit history of a message, oldest first
+func (store *MessageStore) GetMessageEdits(id, chatJID string) ([]MessageEdit, error) {
+	rows, err := store.db.Query(
+		"SELECT previous_content, edited_at FROM message_edits WHERE message_id = ? AND chat_jid = ? ORDER BY edited_at",
+		id, chatJID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	edits := []MessageEdit{}
+	for rows.Next() {
+		var edit MessageEdit
+		if err := rows.Scan(&edit.PreviousContent, &edit.EditedAt); err != nil {
+			return nil, err
+		}
+		edits = append(edits, edit)
+	}
+	return edits, rows.Err()
+}
+
+// isEdit reports whether msg edits an earlier message
+func isEdit(msg *waProto.Message) bool {
+	return msg.GetProtocolMessage().GetType() == waProto.ProtocolMessage_MESSAGE_EDIT
+}
+
+// editedText is the new text of an edited message, or the new caption of an
+// edited media message
+func editedText(msg *waProto.Message) string {
+	if text := extractTextContent(msg); text != "" {
+		return text
+	}
+	switch {
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetCaption()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetCaption()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage().GetCaption()
+	}
+	return ""
+}
+
+// handleEdit applies an edit someone made to a message, or that the account
+// made from another device, and publishes it
+func handleEdit(store *MessageStore, bus *bridgeevents.Bus, msg *events.Message) {
+	edit := msg.Message.GetProtocolMessage()
+	messageID := edit.GetKey().GetID()
+	chatJID := msg.Info.Chat.String()
+	content := editedText(edit.GetEditedMessage())
+	editedAt := msg.Info.Timestamp
+	if ms := edit.GetTimestampMS(); ms > 0 {
+		editedAt = time.UnixMilli(ms)
+	}
+
+	err := store.RecordMessageEvent(MessageEvent{
+		MessageID: messageID,
+		ChatJID:   chatJID,
+		Type:      eventEdit,
+		Sender:    msg.Info.Sender.User,
+		Content:   content,
+		Timestamp: editedAt,
+		fromMe:    msg.Info.IsFromMe,
+		senderAlt: msg.Info.SenderAlt.User,
+	})
+	if errors.Is(err, errNotSender) {
+		slog.Warn("Ignoring edit of a message by someone other than its sender", "message_id", messageID, "chat_jid", chatJID, "sender", msg.Info.Sender.User)
+		return
+	}
+	if err != nil {
+		slog.Warn("Failed to store message edit", "message_id", messageID, "chat_jid", chatJID, "error", err)
+		return
+	}
+	bus.Publish(bridgeevents.TypeMessageEdit, map[string]interface{}{
+		"message_id": messageID,
+		"chat_jid":   chatJID,
+		"sender":     msg.Info.Sender.User,
+		"content":    content,
+		"edited_at":  editedAt,
+		"is_from_me": msg.Info.IsFromMe,
+	})
+}
+
+// registerEditHandlers adds the endpoints for editing messages and reading
+// their edit history
+func registerEditHandlers(mux *http.ServeMux, client *whatsmeow.Client, store *MessageStore) {
+	// PUT /v1/messages/{chat}/{id} - Replace the text of a message the account
+	// sent, within WhatsApp's edit window
+	mux.HandleFunc("PUT /v1/messages/{chat}/{id}", func(w http.ResponseWriter, r *http.Request) {
+		var req EditMessageRequest
+		var v validate.Validator
+		chat := v.Recipient("chat", r.PathValue("chat"))
+		if v.Decode(r, &req) {
+			v.Message("message", req.Message)
+		}
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		chatJID, err := parseRecipient(chat)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+		messageID := r.PathValue("id")
+
+		msg, err := store.GetMessage(messageID, chatJID.String())
+		if errors.Is(err, sql.ErrNoRows) {
+			apierror.NotFound(w, "Message not found")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to read message", "error", err)
+			apierror.Internal(w, "Failed to read message")
+			return
+		}
+		switch {
+		case !msg.IsFromMe:
+			apierror.BadRequest(w, "Only messages the account sent can be edited")
+			return
+		case msg.MediaType != "":
+			apierror.BadRequest(w, "Only text messages can be edited")
+			return
+		case time.Since(msg.Time) > whatsmeow.EditWindow:
+			apierror.Conflict(w, fmt.Sprintf("Messages can only be edited for %d minutes after they are sent", int(whatsmeow.EditWindow.Minutes())))
+			return
+		}
+
+		if !client.IsConnected() {
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not connected to WhatsApp")
+			return
+		}
+
+		// A client hanging up must not abort a send that is under way
+		ctx := context.WithoutCancel(r.Context())
+		edit := client.BuildEdit(chatJID, messageID, &waProto.Message{Conversation: proto.String(req.Message)})
+		result := sendBuilt(ctx, client, chatJID.String(), "edit", edit)
+		if !result.Success {
+			slog.WarnContext(ctx, "Failed to send edit", "message_id", messageID, "chat_jid", chatJID, "status", "failed", "error", result.Message)
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, result.Message)
+			return
+		}
+		slog.InfoContext(ctx, "Sent edit", "message_id", messageID, "chat_jid", chatJID, "status", "sent")
+		audit.SetResource(r.Context(), messageID)
+
+		// Like sent messages, the bridge's own edits aren't echoed back
+		sent := MessageEvent{MessageID: messageID, ChatJID: chatJID.String(), Type: eventEdit, Content: req.Message, Timestamp: result.Timestamp, fromMe: true}
+		if client.Store.ID != nil {
+			sent.Sender = client.Store.ID.User
+		}
+		if err := store.RecordMessageEvent(sent); err != nil {
+			slog.WarnContext(ctx, "Failed to store sent edit", "message_id", messageID, "error", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(SendMessageResponse{
+			Success:   true,
+			Message:   result.Message,
+			MessageID: messageID,
+			ChatJID:   result.ChatJID,
+		})
+	})
+
+	// GET /v1/messages/{chat}/{id}/edits - The current text of a message and
+	// the texts it had before each edit
+	mux.HandleFunc("GET /v1/messages/{chat}/{id}/edits", func(w http.ResponseWriter, r *http.Request) {
+		var v validate.Validator
+		chat := v.Recipient("chat", r.PathValue("chat"))
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		chatJID, err := parseRecipient(chat)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+		messageID := r.PathValue("id")
+
+		msg, err := store.GetMessage(messageID, chatJID.String())
+		if errors.Is(err, sql.ErrNoRows) {
+			apierror.NotFound(w, "Message not found")
+			return
+		}
+		var edits []MessageEdit
+		if err == nil {
+			edits, err = store.GetMessageEdits(messageID, chatJID.String())
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to read message edits", "error", err)
+			apierror.Internal(w, "Failed to read message edits")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    true,
+			"message_id": messageID,
+			"chat_jid":   chatJID.String(),
+			"content":    msg.Content,
+			"edited":     len(edits) > 0,
+			"edits":      edits,
+		})
+	})
+}
@@ -0,0 +1,172 @@
+// Package events fans out bridge events (incoming messages, receipts, presence,
+// scheduler status changes) to live subscribers such as the SSE endpoint.
+package events
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	TypeMessage          = "message"
+	TypeReceipt          = "receipt"
+	TypePresence         = "presence"
+	TypeSchedulerStatus  = "scheduler.status"
+	TypePollVote         = "poll.vote"
+	TypeReaction         = "reaction"
+	TypeMessageEdit      = "message.edit"
+	TypeMessageRevoke    = "message.revoke"
+	TypeMessagePin       = "message.pin"
+	TypeGroupJoinRequest = "group.join_request"
+)
+
+// Types lists every event type, for validating subscription filters
+var Types = []string{TypeMessage, TypeReceipt, TypePresence, TypeSchedulerStatus, TypePollVote, TypeReaction, TypeMessageEdit, TypeMessageRevoke, TypeMessagePin, TypeGroupJoinRequest}
+
+// Event is one published event. IDs increase by one per event, so a subscriber
+// that reconnects can ask for everything after the last ID it saw.
+type Event struct {
+	ID   uint64      `json:"id"`
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// HistorySize is how many recent events are kept for subscribers that reconnect
+const HistorySize = 256
+
+// subscriberBuffer is how many events may queue up for a subscriber before it
+// is considered too slow and dropped
+const subscriberBuffer = 64
+
+// Bus delivers published events to subscribers and listeners. The zero value
+// is not usable; create one with NewBus.
+type Bus struct {
+	mu        sync.Mutex
+	nextID    uint64
+	history   []Event
+	subs      map[*Subscription]struct{}
+	listeners []func(Event)
+	closed    bool
+}
+
+// NewBus creates an empty Bus
+func NewBus() *Bus {
+	return &Bus{
+		nextID: 1,
+		subs:   make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscription receives events from a Bus until it is closed
+type Subscription struct {
+	bus   *Bus
+	types []string
+	ch    chan Event
+	once  sync.Once
+}
+
+// C delivers the events. It is closed when the subscription ends, either by
+// Close, by the bus closing, or because the subscriber fell too far behind.
+func (s *Subscription) C() <-chan Event {
+	return s.ch
+}
+
+// Close ends the subscription
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.remove(s)
+}
+
+// wants reports whether the subscription's type filter matches
+func (s *Subscription) wants(eventType string) bool {
+	return len(s.types) == 0 || slices.Contains(s.types, eventType)
+}
+
+// AddListener registers fn to be called with every event, in publish order.
+// Unlike subscribers, listeners keep getting events after Close. fn runs with
+// the bus locked and must not block or publish.
+func (b *Bus) AddListener(fn func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners = append(b.listeners, fn)
+}
+
+// Publish sends an event to every listener, and to every subscriber that wants its type
+func (b *Bus) Publish(eventType string, data interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	evt := Event{ID: b.nextID, Type: eventType, Time: time.Now().UTC(), Data: data}
+	b.nextID++
+
+	b.history = append(b.history, evt)
+	if len(b.history) > HistorySize {
+		b.history = b.history[len(b.history)-HistorySize:]
+	}
+
+	for _, fn := range b.listeners {
+		fn(evt)
+	}
+
+	for sub := range b.subs {
+		if !sub.wants(eventType) {
+			continue
+		}
+		select {
+		case sub.ch <- evt:
+		default:
+			// It can catch up by reconnecting with the last ID it got
+			b.remove(sub)
+		}
+	}
+}
+
+// Subscribe starts a subscription to the given event types, or all types when
+// types is empty. Kept events published after afterID are delivered first; pass
+// 0 to receive only new events.
+func (b *Bus) Subscribe(types []string, afterID uint64) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &Subscription{bus: b, types: types}
+
+	var backlog []Event
+	if afterID > 0 {
+		for _, evt := range b.history {
+			if evt.ID > afterID && sub.wants(evt.Type) {
+				backlog = append(backlog, evt)
+			}
+		}
+	}
+	sub.ch = make(chan Event, subscriberBuffer+len(backlog))
+	for _, evt := range backlog {
+		sub.ch <- evt
+	}
+
+	if b.closed {
+		b.remove(sub)
+		return sub
+	}
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+// Close ends every subscription, and any made later straight away
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subs {
+		b.remove(sub)
+	}
+}
+
+// remove ends a subscription; b.mu must be held
+func (b *Bus) remove(sub *Subscription) {
+	delete(b.subs, sub)
+	sub.once.Do(func() { close(sub.ch) })
+}
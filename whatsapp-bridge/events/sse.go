@@ -0,0 +1,99 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"whatsapp-client/apierror"
+)
+
+// keepaliveInterval is how often an idle stream gets a comment line, so proxies
+// don't close it
+const keepaliveInterval = 25 * time.Second
+
+// Handler streams events as Server-Sent Events. The optional types query
+// parameter takes a comma-separated list of event types. A client that
+// reconnects with the Last-Event-ID header (or last_event_id parameter) first
+// gets the kept events it missed.
+func Handler(bus *Bus) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var types []string
+		if v := r.URL.Query().Get("types"); v != "" {
+			for _, t := range strings.Split(v, ",") {
+				t = strings.TrimSpace(t)
+				if !slices.Contains(Types, t) {
+					apierror.BadRequest(w, fmt.Sprintf("Unknown event type %q, expected one of %s", t, strings.Join(Types, ", ")))
+					return
+				}
+				types = append(types, t)
+			}
+		}
+
+		lastID := r.Header.Get("Last-Event-ID")
+		if lastID == "" {
+			lastID = r.URL.Query().Get("last_event_id")
+		}
+		var afterID uint64
+		if lastID != "" {
+			id, err := strconv.ParseUint(lastID, 10, 64)
+			if err != nil {
+				apierror.BadRequest(w, "Invalid last event ID")
+				return
+			}
+			afterID = id
+		}
+
+		rc := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		// Stop nginx from buffering the stream
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		if err := rc.Flush(); err != nil {
+			slog.WarnContext(r.Context(), "Event stream can't be flushed", "error", err)
+			return
+		}
+
+		sub := bus.Subscribe(types, afterID)
+		defer sub.Close()
+
+		keepalive := time.NewTicker(keepaliveInterval)
+		defer keepalive.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+
+			case <-keepalive.C:
+				if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+					return
+				}
+
+			case evt, ok := <-sub.C():
+				if !ok {
+					// Dropped for falling behind, or shutting down
+					return
+				}
+				data, err := json.Marshal(evt)
+				if err != nil {
+					slog.ErrorContext(r.Context(), "Error encoding event", "event_type", evt.Type, "error", err)
+					continue
+				}
+				if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", evt.ID, evt.Type, data); err != nil {
+					return
+				}
+			}
+
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/audit"
+	"whatsapp-client/jobs"
+	"whatsapp-client/scheduler"
+	"whatsapp-client/validate"
+)
+
+// maxFanOutRecipients is the most recipients one POST /v1/send/batch takes
+const maxFanOutRecipients = 500
+
+// Bounds of the pause between sends of a fan-out, in seconds
+const (
+	defaultFanOutDelay = 2
+	maxFanOutDelay     = 300
+)
+
+// Outcomes of sending to one recipient of a fan-out
+const (
+	fanOutSent    = "sent"
+	fanOutFailed  = "failed"
+	fanOutSkipped = "skipped"
+)
+
+// templateVariable finds {{name}} placeholders in a fan-out message
+var templateVariable = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// FanOutRequest is the body of POST /v1/send/batch
+type FanOutRequest struct {
+	// Message is the text sent to everyone, with {{name}} placeholders filled
+	// in from each recipient's variables
+	Message    string            `json:"message"`
+	Recipients []FanOutRecipient `json:"recipients"`
+	// DelaySeconds is the pause between two sends, defaultFanOutDelay if unset
+	DelaySeconds *float64 `json:"delay_seconds,omitempty"`
+	// LinkPreview shows a preview of the first link in each message
+	LinkPreview bool `json:"link_preview,omitempty"`
+	// Typing shows the account typing before each message, as the scheduler does
+	Typing bool `json:"typing,omitempty"`
+}
+
+// FanOutRecipient is one recipient of a fan-out and the values of its
+// message's placeholders
+type FanOutRecipient struct {
+	Recipient string            `json:"recipient"`
+	Variables map[string]string `json:"variables,omitempty"`
+
+	message string
+}
+
+// FanOutResult is the outcome of sending to one recipient, in request order
+type FanOutResult struct {
+	Index     int    `json:"index"`
+	Recipient string `json:"recipient"`
+	Status    string `json:"status"`
+	MessageID string `json:"message_id,omitempty"`
+	ChatJID   string `json:"chat_jid,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// fillTemplate replaces the placeholders in message with variables, and
+// returns the names of any it has no value for
+func fillTemplate(message string, variables map[string]string) (string, []string) {
+	var missing []string
+	filled := templateVariable.ReplaceAllStringFunc(message, func(placeholder string) string {
+		name := templateVariable.FindStringSubmatch(placeholder)[1]
+		value, ok := variables[name]
+		if !ok {
+			missing = append(missing, name)
+			return placeholder
+		}
+		return value
+	})
+	return filled, missing
+}
+
+// validate checks every field of the request, normalizes the recipients and
+// fills in each recipient's message. It returns the delay between sends.
+func (req *FanOutRequest) validate(v *validate.Validator) time.Duration {
+	v.Message("message", req.Message)
+	switch {
+	case len(req.Recipients) == 0:
+		v.Add("recipients", validate.CodeRequired, "must have at least one recipient")
+	case len(req.Recipients) > maxFanOutRecipients:
+		v.Add("recipients", validate.CodeTooLong, fmt.Sprintf("must have at most %d recipients", maxFanOutRecipients))
+	}
+	seen := make(map[string]int, len(req.Recipients))
+	for i := range req.Recipients {
+		recipient := &req.Recipients[i]
+		var sub validate.Validator
+		recipient.Recipient = sub.Recipient("recipient", recipient.Recipient)
+		if first, ok := seen[recipient.Recipient]; ok && !sub.Failed("recipient") {
+			sub.Add("recipient", validate.CodeInvalidValue, fmt.Sprintf("is the same as recipients.%d", first))
+		}
+		seen[recipient.Recipient] = i
+		message, missing := fillTemplate(req.Message, recipient.Variables)
+		if len(missing) > 0 {
+			sub.Add("variables", validate.CodeRequired, fmt.Sprintf("must set %s, used in the message", missing[0]))
+		}
+		sub.MaxLength("message", message, validate.MaxMessageLength)
+		recipient.message = message
+		v.Nest("recipients."+strconv.Itoa(i), &sub)
+	}
+
+	delay := float64(defaultFanOutDelay)
+	if req.DelaySeconds != nil {
+		delay = *req.DelaySeconds
+	}
+	if delay < 0 || delay > maxFanOutDelay {
+		v.Add("delay_seconds", validate.CodeInvalidValue, fmt.Sprintf("must be between 0 and %d", maxFanOutDelay))
+	}
+	return time.Duration(delay * float64(time.Second))
+}
+
+// sendFanOut sends each recipient its message in turn, pausing delay between
+// sends, and reports each outcome. One failed send doesn't stop the others;
+// once ctx is cancelled the recipients left are skipped.
+func sendFanOut(ctx context.Context, client *whatsmeow.Client, store *MessageStore, req FanOutRequest, delay time.Duration, report func(done, total int)) []FanOutResult {
+	send := scheduledMessageSender(store)
+	opts := scheduler.SendOptions{LinkPreview: req.LinkPreview}
+	results := make([]FanOutResult, len(req.Recipients))
+	for i, recipient := range req.Recipients {
+		results[i] = FanOutResult{Index: i, Recipient: recipient.Recipient, Status: fanOutSkipped}
+	}
+
+	for i, recipient := range req.Recipients {
+		if i > 0 && delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+			case <-timer.C:
+			}
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		if req.Typing && !scheduler.IsBroadcastList(recipient.Recipient) && !scheduler.IsNewsletter(recipient.Recipient) && !scheduler.IsStatus(recipient.Recipient) {
+			if chat, err := parseRecipient(recipient.Recipient); err == nil {
+				if err := scheduler.ShowTyping(ctx, client, chat, recipient.message, false); err != nil {
+					slog.WarnContext(ctx, "Error showing typing", "recipient", recipient.Recipient, "error", err)
+				}
+			}
+		}
+
+		result := send(ctx, client, recipient.Recipient, recipient.message, "", opts)
+		if result.Success {
+			results[i].Status = fanOutSent
+			results[i].MessageID = result.MessageID
+			results[i].ChatJID = result.ChatJID
+			slog.InfoContext(ctx, "Sent message", "whatsapp_message_id", result.MessageID, "recipient", recipient.Recipient, "status", "sent")
+		} else {
+			results[i].Status = fanOutFailed
+			results[i].Error = result.Message
+			slog.WarnContext(ctx, "Failed to send message", "recipient", recipient.Recipient, "status", "failed", "error", result.Message)
+		}
+		report(i+1, len(req.Recipients))
+	}
+	return results
+}
+
+// fanOutSummary is the response to a fan-out, and the result of its job
+func fanOutSummary(results []FanOutResult) map[string]interface{} {
+	counts := map[string]int{fanOutSent: 0, fanOutFailed: 0, fanOutSkipped: 0}
+	for _, result := range results {
+		counts[result.Status]++
+	}
+	return map[string]interface{}{
+		"success":  true,
+		"all_sent": counts[fanOutSent] == len(results),
+		"sent":     counts[fanOutSent],
+		"failed":   counts[fanOutFailed],
+		"skipped":  counts[fanOutSkipped],
+		"results":  results,
+	}
+}
+
+// registerFanOutHandlers adds the endpoint for sending one message to many
+// recipients
+func registerFanOutHandlers(mux *http.ServeMux, client *whatsmeow.Client, store *MessageStore, jobManager *jobs.Manager) {
+	// POST /v1/send/batch - Send a message, or a variant of it for each
+	// recipient, to up to 500 recipients one after another. Sending to many
+	// takes a while, so clients should usually ask for a job.
+	mux.HandleFunc("POST /v1/send/batch", func(w http.ResponseWriter, r *http.Request) {
+		var req FanOutRequest
+		var v validate.Validator
+		var delay time.Duration
+		if v.Decode(r, &req) {
+			delay = req.validate(&v)
+		}
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+
+		if !client.IsConnected() {
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not connected to WhatsApp")
+			return
+		}
+
+		if jobs.Async(r) {
+			job, err := jobManager.Start(r.Context(), "send_batch", scheduler.RequestCreator(r), func(ctx context.Context, report func(int, int)) (interface{}, error) {
+				report(0, len(req.Recipients))
+				return fanOutSummary(sendFanOut(ctx, client, store, req, delay, report)), nil
+			})
+			if err != nil {
+				jobs.StartError(w, err)
+				return
+			}
+			jobs.Accepted(w, r, job)
+			return
+		}
+
+		// A client hanging up must not abort the sends left
+		ctx := context.WithoutCancel(r.Context())
+		results := sendFanOut(ctx, client, store, req, delay, func(int, int) {})
+		summary := fanOutSummary(results)
+		slog.InfoContext(ctx, "Sent batch", "sent", summary["sent"], "failed", summary["failed"])
+		audit.SetResource(r.Context(), fmt.Sprintf("%d recipients", len(results)))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(summary)
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ffmpegTimeout bounds one ffmpeg run
+const ffmpegTimeout = 2 * time.Minute
+
+// transcodeTimeout bounds re-encoding a video, which takes longer
+const transcodeTimeout = 10 * time.Minute
+
+// errNoFFmpeg is returned by runFFmpeg when ffmpeg isn't installed
+var errNoFFmpeg = errors.New("ffmpeg is not installed")
+
+// runFFmpeg runs ffmpeg on input, which it reads from a temporary file since
+// formats like MP4 need seeking, and returns what it writes to stdout. args go
+// after the input; the last of them is usually pipe:1.
+func runFFmpeg(ctx context.Context, input []byte, args ...string) ([]byte, error) {
+	return execFFmpeg(ctx, ffmpegTimeout, input, args)
+}
+
+// transcodeFFmpeg is runFFmpeg for output that can't be streamed, like MP4
+// with its index at the start: ffmpeg writes a temporary file named with ext,
+// whose content is returned. It gets transcodeTimeout.
+func transcodeFFmpeg(ctx context.Context, input []byte, ext string, args ...string) ([]byte, error) {
+	out, err := os.CreateTemp("", "bridge-media-*"+ext)
+	if err != nil {
+		return nil, err
+	}
+	out.Close()
+	defer os.Remove(out.Name())
+
+	if _, err := execFFmpeg(ctx, transcodeTimeout, input, append(args, "-y", out.Name())); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(out.Name())
+}
+
+func execFFmpeg(ctx context.Context, timeout time.Duration, input []byte, args []string) ([]byte, error) {
+	path, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, errNoFFmpeg
+	}
+
+	in, err := os.CreateTemp("", "bridge-media-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(in.Name())
+	_, err = in.Write(input)
+	if closeErr := in.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path, append([]string{"-nostdin", "-v", "error", "-i", in.Name()}, args...)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("ffmpeg failed: %v: %s", err, msg)
+		}
+		return nil, fmt.Errorf("ffmpeg failed: %v", err)
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/audit"
+	bridgeevents "whatsapp-client/events"
+	"whatsapp-client/pagination"
+	"whatsapp-client/scheduler"
+	"whatsapp-client/validate"
+)
+
+// Limits WhatsApp puts on a group's name and description, in characters
+const (
+	maxGroupNameLength  = 100
+	maxGroupTopicLength = 2048
+)
+
+// groupPhotoSize is the largest side of the square JPEG a group photo is sent
+// as, in pixels, the size WhatsApp's apps upload
+const groupPhotoSize = 640
+
+// groupPhotoQuality is the JPEG quality of group photos
+const groupPhotoQuality = 85
+
+// UpdateGroupRequest is the body of PATCH /v1/groups/{jid}. Fields left out
+// stay as they are. As a multipart form it has the same fields, with the
+// uploaded photo in "file" instead of a path or URL.
+type UpdateGroupRequest struct {
+	// Name is the group's subject
+	Name *string `json:"name,omitempty"`
+	// Topic is the group's description; empty removes it
+	Topic *string `json:"topic,omitempty"`
+	// Path is a photo on the bridge host to set as the group's
+	Path string `json:"path,omitempty"`
+	// URL is an http or https URL the bridge fetches the photo from
+	URL string `json:"url,omitempty"`
+	// RemovePhoto removes the group's photo
+	RemovePhoto bool `json:"remove_photo,omitempty"`
+	// Announce sets whether only admins can send messages
+	Announce *bool `json:"announce,omitempty"`
+	// Locked sets whether only admins can edit the group's info
+	Locked *bool `json:"locked,omitempty"`
+}
+
+// JoinRequestsRequest is the body of POST /v1/groups/{jid}/requests/approve
+// and /reject
+type JoinRequestsRequest struct {
+	// Participants are the phone numbers or JIDs of the people whose requests
+	// to join are answered
+	Participants []string `json:"participants"`
+}
+
+// JoinRequest is someone waiting for an admin to let them into a group
+type JoinRequest struct {
+	JID string `json:"jid"`
+	// Name is their name in the account's address book, if they are in it
+	Name        string    `json:"name,omitempty"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+// JoinRequestResult is how WhatsApp took the answer to one request to join
+type JoinRequestResult struct {
+	JID string `json:"jid"`
+	// Error is WhatsApp's code for why the request couldn't be answered, such
+	// as 404 for one that was withdrawn or already answered. 0 means it was.
+	Error int `json:"error,omitempty"`
+}
+
+// groupJID checks the JID of a group at field and returns it
+func groupJID(v *validate.Validator, field, value string) types.JID {
+	if !v.Required(field, value) {
+		return types.EmptyJID
+	}
+	jid, err := types.ParseJID(value)
+	if err != nil || jid.Server != types.GroupServer || jid.User == "" {
+		v.Add(field, validate.CodeInvalidFormat, "must be a group JID, like 120363043815313110@g.us")
+		return types.EmptyJID
+	}
+	return jid
+}
+
+// SetChatName records the name of a chat the bridge has stored, such as a
+// group renamed from the API or by a member
+func (store *MessageStore) SetChatName(jid, name string) error {
+	_, err := store.db.Exec("UPDATE chats SET name = ? WHERE jid = ?", name, jid)
+	return err
+}
+
+// handleGroupName keeps the stored name of a group up to date when a member
+// renames it
+func handleGroupName(store *MessageStore, info *events.GroupInfo) {
+	if info.Name == nil || info.Name.Name == "" {
+		return
+	}
+	if err := store.SetChatName(info.JID.String(), info.Name.Name); err != nil {
+		slog.Warn("Failed to store group name", "chat_jid", info.JID.String(), "error", err)
+	}
+}
+
+// handleGroupJoinRequests publishes requests to join a group, and requests
+// withdrawn or rejected, that WhatsApp tells admins of the group about
+func handleGroupJoinRequests(bus *bridgeevents.Bus, info *events.GroupInfo) {
+	for _, change := range info.UnknownChanges {
+		var action string
+		switch change.Tag {
+		case "created_membership_requests":
+			action = "created"
+		case "revoked_membership_requests":
+			action = "rejected"
+		default:
+			continue
+		}
+
+		// The notification comes from whoever made the change; the request is
+		// theirs unless it names the people it is about
+		var requesters []types.JID
+		for _, participant := range change.GetChildrenByTag("participant") {
+			if jid, ok := participant.Attrs["jid"].(types.JID); ok {
+				requesters = append(requesters, jid)
+			}
+		}
+		if len(requesters) == 0 && info.Sender != nil {
+			requesters = append(requesters, *info.Sender)
+		}
+		for _, requester := range requesters {
+			event := map[string]interface{}{
+				"group_jid": info.JID.String(),
+				"requester": requester.String(),
+				"action":    action,
+				"timestamp": info.Timestamp,
+			}
+			switch {
+			case action == "created":
+				event["method"] = change.AttrGetter().OptionalString("request_method")
+			case info.Sender != nil && info.Sender.User == requester.User:
+				event["action"] = "withdrawn"
+			}
+			if info.Sender != nil {
+				event["by"] = info.Sender.String()
+			}
+			bus.Publish(bridgeevents.TypeGroupJoinRequest, event)
+		}
+	}
+}
+
+// groupPhoto crops a photo to a centered square and encodes it as the JPEG
+// WhatsApp takes for group photos
+func groupPhoto(ctx context.Context, media *outgoingMedia) ([]byte, error) {
+	media.mimetype = sniffMimetype(media.data)
+	if !slices.Contains(mediaMimetypes[mediaImage], media.mimetype) {
+		return nil, fmt.Errorf("is %s, expected a JPEG, PNG or WebP image", media.mimetype)
+	}
+	img, err := decodeImage(ctx, media)
+	if err != nil {
+		return nil, err
+	}
+	if img == nil {
+		return nil, errors.New("is a WebP image, which needs ffmpeg on the bridge host to be decoded")
+	}
+
+	bounds := img.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	if side == 0 {
+		return nil, errors.New("is empty")
+	}
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	offset := image.Pt(bounds.Min.X+(bounds.Dx()-side)/2, bounds.Min.Y+(bounds.Dy()-side)/2)
+	draw.Draw(square, square.Bounds(), img, offset, draw.Src)
+	scaled, err := scaleImage(square, groupPhotoSize)
+	if err != nil {
+		return nil, err
+	}
+	return encodeJPEG(scaled, groupPhotoQuality)
+}
+
+// formBool is the form field of a multipart request as a bool, nil when it
+// isn't set
+func formBool(v *validate.Validator, r *http.Request, field string) *bool {
+	values, ok := r.MultipartForm.Value[field]
+	if !ok || len(values) == 0 {
+		return nil
+	}
+	value, err := strconv.ParseBool(values[0])
+	if err != nil {
+		v.Add(field, validate.CodeInvalidFormat, "must be true or false")
+		return nil
+	}
+	return &value
+}
+
+// readGroupUpdate reads and checks a group update and the photo it names or
+// carries, if any. The photo is nil when there is none or validation failed.
+func readGroupUpdate(w http.ResponseWriter, r *http.Request, v *validate.Validator) (UpdateGroupRequest, []byte) {
+	var req UpdateGroupRequest
+	var upload io.Reader
+	limit := mediaSizeLimits[mediaImage]
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		// Leave room for the other fields and the multipart framing
+		r.Body = http.MaxBytesReader(w, r.Body, limit+1<<20)
+		if err := r.ParseMultipartForm(multipartMemory); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				v.Add("file", validate.CodeTooLong, fmt.Sprintf("must be at most %s", formatSize(limit)))
+			} else {
+				v.Add("body", validate.CodeInvalidFormat, fmt.Sprintf("is not a valid multipart form: %v", err))
+			}
+			return req, nil
+		}
+		if values, ok := r.MultipartForm.Value["name"]; ok && len(values) > 0 {
+			req.Name = &values[0]
+		}
+		if values, ok := r.MultipartForm.Value["topic"]; ok && len(values) > 0 {
+			req.Topic = &values[0]
+		}
+		req.Path = r.FormValue("path")
+		req.URL = r.FormValue("url")
+		req.RemovePhoto = r.FormValue("remove_photo") == "true"
+		req.Announce = formBool(v, r, "announce")
+		req.Locked = formBool(v, r, "locked")
+		if file, _, err := r.FormFile("file"); err == nil {
+			defer file.Close()
+			upload = file
+		}
+	} else if !v.Decode(r, &req) {
+		return req, nil
+	}
+
+	if req.Name != nil && v.Required("name", *req.Name) {
+		v.MaxLength("name", *req.Name, maxGroupNameLength)
+	}
+	if req.Topic != nil {
+		v.MaxLength("topic", *req.Topic, maxGroupTopicLength)
+	}
+	sources := 0
+	for _, set := range []bool{upload != nil, req.Path != "", req.URL != ""} {
+		if set {
+			sources++
+		}
+	}
+	switch {
+	case sources > 1:
+		v.Add("file", validate.CodeInvalidValue, "at most one of file, path or url can be set")
+	case sources == 1 && req.RemovePhoto:
+		v.Add("remove_photo", validate.CodeInvalidValue, "must be false when setting a photo")
+	case sources == 0 && !req.RemovePhoto && req.Name == nil && req.Topic == nil && req.Announce == nil && req.Locked == nil:
+		v.Add("body", validate.CodeRequired, "must change at least one of name, topic, the photo, announce or locked")
+	}
+	if sources != 1 || !v.Valid() {
+		return req, nil
+	}
+
+	media, field, err := readMedia(r.Context(), upload, "", req.Path, req.URL, limit)
+	switch {
+	case errors.Is(err, errMediaTooLarge):
+		v.Add(field, validate.CodeTooLong, fmt.Sprintf("must be at most %s", formatSize(limit)))
+		return req, nil
+	case err != nil:
+		v.Add(field, validate.CodeInvalidValue, err.Error())
+		return req, nil
+	}
+	photo, err := groupPhoto(r.Context(), media)
+	if err != nil {
+		v.Add(field, validate.CodeInvalidValue, err.Error())
+		return req, nil
+	}
+	return req, photo
+}
+
+// registerGroupHandlers adds the endpoints for managing groups
+func registerGroupHandlers(mux *http.ServeMux, client *whatsmeow.Client, store *MessageStore) {
+	// PATCH /v1/groups/{jid} - Change a group's name, description or photo.
+	// Each change is made in turn; one WhatsApp rejects stops the rest.
+	mux.HandleFunc("PATCH /v1/groups/{jid}", func(w http.ResponseWriter, r *http.Request) {
+		var v validate.Validator
+		jid := groupJID(&v, "jid", r.PathValue("jid"))
+		req, photo := readGroupUpdate(w, r, &v)
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+
+		if !client.IsConnected() {
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not connected to WhatsApp")
+			return
+		}
+
+		var changed []string
+		// What was changed shows in GET /v1/groups right away, not only after
+		// the next sync
+		defer func() {
+			if len(changed) == 0 {
+				return
+			}
+			if err := store.SetGroupSettings(jid.String(), req.Name, req.Topic, req.Announce, req.Locked, changed); err != nil {
+				slog.WarnContext(r.Context(), "Failed to store group settings", "chat_jid", jid, "error", err)
+			}
+		}()
+		fail := func(what string, err error) {
+			slog.WarnContext(r.Context(), "Failed to update group", "chat_jid", jid, "change", what, "changed", changed, "error", err)
+			message := fmt.Sprintf("Failed to set group %s: %v", what, err)
+			if len(changed) > 0 {
+				message += fmt.Sprintf(" (%v already changed)", changed)
+			}
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, message)
+		}
+		response := map[string]interface{}{"success": true, "jid": jid.String()}
+
+		if req.Name != nil {
+			if err := client.SetGroupName(jid, *req.Name); err != nil {
+				fail("name", err)
+				return
+			}
+			changed = append(changed, "name")
+			response["name"] = *req.Name
+			if err := store.SetChatName(jid.String(), *req.Name); err != nil {
+				slog.WarnContext(r.Context(), "Failed to store group name", "chat_jid", jid, "error", err)
+			}
+		}
+		if req.Topic != nil {
+			if err := client.SetGroupTopic(jid, "", "", *req.Topic); err != nil {
+				fail("topic", err)
+				return
+			}
+			changed = append(changed, "topic")
+			response["topic"] = *req.Topic
+		}
+		if photo != nil || req.RemovePhoto {
+			pictureID, err := client.SetGroupPhoto(jid, photo)
+			if err != nil {
+				fail("photo", err)
+				return
+			}
+			changed = append(changed, "photo")
+			response["picture_id"] = pictureID
+		}
+		if req.Announce != nil {
+			if err := client.SetGroupAnnounce(jid, *req.Announce); err != nil {
+				fail("announce", err)
+				return
+			}
+			changed = append(changed, "announce")
+			response["announce"] = *req.Announce
+		}
+		if req.Locked != nil {
+			if err := client.SetGroupLocked(jid, *req.Locked); err != nil {
+				fail("locked", err)
+				return
+			}
+			changed = append(changed, "locked")
+			response["locked"] = *req.Locked
+		}
+		slog.InfoContext(r.Context(), "Updated group", "chat_jid", jid, "changed", changed)
+		audit.SetResource(r.Context(), jid.String())
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	})
+
+	// GET /v1/groups - The groups the account is in, by name, a page at a
+	// time, as of the last sync with WhatsApp
+	mux.HandleFunc("GET /v1/groups", func(w http.ResponseWriter, r *http.Request) {
+		page, err := pagination.FromRequest(r)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+		var cursor *groupsCursor
+		var decoded groupsCursor
+		if ok, err := page.Decode(&decoded); err != nil {
+			apierror.BadRequest(w, "Invalid cursor")
+			return
+		} else if ok {
+			cursor = &decoded
+		}
+
+		groups, err := store.GetGroups(cursor, page.Limit+1)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to get groups", "error", err)
+			apierror.Internal(w, "Failed to get groups")
+			return
+		}
+		groups, next := pagination.Page(groups, page, func(group Group) interface{} {
+			return groupsCursor{Name: group.Name, JID: group.JID}
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":     true,
+			"groups":      groups,
+			"next_cursor": next,
+		})
+	})
+
+	// GET /v1/groups/{jid}/requests - The people waiting to be let into a
+	// group that needs admin approval to join, oldest request first
+	mux.HandleFunc("GET /v1/groups/{jid}/requests", func(w http.ResponseWriter, r *http.Request) {
+		var v validate.Validator
+		jid := groupJID(&v, "jid", r.PathValue("jid"))
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+
+		if !client.IsConnected() {
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not connected to WhatsApp")
+			return
+		}
+
+		pending, err := client.GetGroupRequestParticipants(jid)
+		if err != nil {
+			slog.WarnContext(r.Context(), "Failed to get join requests", "chat_jid", jid, "error", err)
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, "Failed to get join requests: "+err.Error())
+			return
+		}
+		requests := make([]JoinRequest, len(pending))
+		for i, request := range pending {
+			requests[i] = JoinRequest{
+				JID:         request.JID.String(),
+				Name:        contactName(r.Context(), client, request.JID.User),
+				RequestedAt: request.RequestedAt,
+			}
+		}
+		slices.SortStableFunc(requests, func(a, b JoinRequest) int { return a.RequestedAt.Compare(b.RequestedAt) })
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"group_jid": jid.String(),
+			"requests":  requests,
+		})
+	})
+
+	// answer serves approve, which lets people into a group, and reject
+	answer := func(action whatsmeow.ParticipantRequestChange) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			var req JoinRequestsRequest
+			var v validate.Validator
+			jid := groupJID(&v, "jid", r.PathValue("jid"))
+			if v.Decode(r, &req) && len(req.Participants) == 0 {
+				v.Add("participants", validate.CodeRequired, "is required")
+			}
+			for i, participant := range req.Participants {
+				req.Participants[i] = scheduler.PersonJID(&v, fmt.Sprintf("participants.%d", i), participant)
+			}
+			if !v.Valid() {
+				v.Write(w)
+				return
+			}
+			participants := make([]types.JID, len(req.Participants))
+			for i, participant := range req.Participants {
+				participants[i], _ = types.ParseJID(participant)
+			}
+
+			if !client.IsConnected() {
+				apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not connected to WhatsApp")
+				return
+			}
+
+			answered, err := client.UpdateGroupRequestParticipants(jid, participants, action)
+			if err != nil {
+				slog.WarnContext(r.Context(), "Failed to answer join requests", "chat_jid", jid, "action", action, "error", err)
+				apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, fmt.Sprintf("Failed to %s join requests: %v", action, err))
+				return
+			}
+			results := make([]JoinRequestResult, len(answered))
+			for i, participant := range answered {
+				results[i] = JoinRequestResult{JID: participant.JID.String(), Error: participant.Error}
+			}
+			slog.InfoContext(r.Context(), "Answered join requests", "chat_jid", jid, "action", action, "count", len(participants))
+			audit.SetResource(r.Context(), jid.String())
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success":      true,
+				"group_jid":    jid.String(),
+				"participants": results,
+			})
+		}
+	}
+
+	// POST /v1/groups/{jid}/requests/approve - Let people who asked to join a
+	// group in
+	mux.HandleFunc("POST /v1/groups/{jid}/requests/approve", answer(whatsmeow.ParticipantChangeApprove))
+	// POST /v1/groups/{jid}/requests/reject - Turn down requests to join
+	mux.HandleFunc("POST /v1/groups/{jid}/requests/reject", answer(whatsmeow.ParticipantChangeReject))
+}
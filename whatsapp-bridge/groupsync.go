@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// groupSyncInterval is how often the groups the account is in are fetched
+// again from WhatsApp, to catch changes whose notifications were missed
+const groupSyncInterval = 30 * time.Minute
+
+// Group is a group the account is in, as of when it was last synced
+type Group struct {
+	JID              string `json:"jid"`
+	Name             string `json:"name"`
+	Topic            string `json:"topic,omitempty"`
+	ParticipantCount int    `json:"participant_count"`
+	// IsAdmin is whether the account is an admin of the group, IsSuperAdmin
+	// whether it is the group's creator
+	IsAdmin      bool `json:"is_admin"`
+	IsSuperAdmin bool `json:"is_super_admin"`
+	// Announce is whether only admins can send messages, Locked whether only
+	// admins can edit the group's info
+	Announce bool `json:"announce"`
+	Locked   bool `json:"locked"`
+	// JoinApproval is whether an admin has to let in people who ask to join
+	JoinApproval bool       `json:"join_approval"`
+	CreatedAt    *time.Time `json:"created_at,omitempty"`
+	SyncedAt     time.Time  `json:"synced_at"`
+}
+
+// groupsCursor is the sort key of the last group of a page
+type groupsCursor struct {
+	Name string `json:"n"`
+	JID  string `json:"j"`
+}
+
+// ownParticipant is the account among a group's participants, nil if it isn't
+// one of them
+func ownParticipant(client *whatsmeow.Client, participants []types.GroupParticipant) *types.GroupParticipant {
+	if client.Store.ID == nil {
+		return nil
+	}
+	lid := client.Store.GetLID()
+	for i, participant := range participants {
+		if participant.PhoneNumber.User == client.Store.ID.User || (!lid.IsEmpty() && participant.LID.User == lid.User) {
+			return &participants[i]
+		}
+	}
+	return nil
+}
+
+// StoreGroup records a group's settings and the account's role in it, as
+// WhatsApp returned them at syncedAt, and keeps the group's chat name current
+func (store *MessageStore) StoreGroup(client *whatsmeow.Client, info *types.GroupInfo, syncedAt time.Time) error {
+	var isAdmin, isSuperAdmin bool
+	if own := ownParticipant(client, info.Participants); own != nil {
+		isAdmin, isSuperAdmin = own.IsAdmin, own.IsSuperAdmin
+	}
+	var createdAt *time.Time
+	if !info.GroupCreated.IsZero() {
+		createdAt = &info.GroupCreated
+	}
+	_, err := store.db.Exec(
+		`INSERT OR REPLACE INTO group_metadata
+		(jid, name, topic, participant_count, is_admin, is_super_admin, announce, locked, join_approval, created_at, synced_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		info.JID.String(), info.Name, info.Topic, len(info.Participants), isAdmin, isSuperAdmin,
+		info.IsAnnounce, info.IsLocked, info.IsJoinApprovalRequired, createdAt, syncedAt,
+	)
+	if err != nil {
+		return err
+	}
+	if info.Name != "" {
+		return store.SetChatName(info.JID.String(), info.Name)
+	}
+	return nil
+}
+
+// DeleteGroupsExcept forgets the groups not in jids, which the account has
+// left or was removed from
+func (store *MessageStore) DeleteGroupsExcept(jids []string) error {
+	rows, err := store.db.Query("SELECT jid FROM group_metadata")
+	if err != nil {
+		return err
+	}
+	var stale []string
+	for rows.Next() {
+		var jid string
+		if err := rows.Scan(&jid); err != nil {
+			rows.Close()
+			return err
+		}
+		if !slices.Contains(jids, jid) {
+			stale = append(stale, jid)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, jid := range stale {
+		if err := store.DeleteGroup(jid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteGroup forgets one group the account is no longer in
+func (store *MessageStore) DeleteGroup(jid string) error {
+	_, err := store.db.Exec("DELETE FROM group_metadata WHERE jid = ?", jid)
+	return err
+}
+
+// SetGroupSettings records the name, topic, announce and locked settings of a
+// stored group that are in changed, until the next sync fetches them
+func (store *MessageStore) SetGroupSettings(jid string, name, topic *string, announce, locked *bool, changed []string) error {
+	set := func(setting string, value interface{}) interface{} {
+		if !slices.Contains(changed, setting) {
+			return nil
+		}
+		return value
+	}
+	_, err := store.db.Exec(
+		`UPDATE group_metadata SET name = COALESCE(?, name), topic = COALESCE(?, topic),
+		announce = COALESCE(?, announce), locked = COALESCE(?, locked) WHERE jid = ?`,
+		set("name", name), set("topic", topic), set("announce", announce), set("locked", locked), jid,
+	)
+	return err
+}
+
+// GetGroups returns the stored groups by name, then JID
+func (store *MessageStore) GetGroups(after *groupsCursor, limit int) ([]Group, error) {
+	query := `SELECT jid, name, topic, participant_count, is_admin, is_super_admin, announce, locked, join_approval, created_at, synced_at
+		FROM group_metadata`
+	var args []interface{}
+	if after != nil {
+		query += " WHERE name > ? COLLATE NOCASE OR (name = ? COLLATE NOCASE AND jid > ?)"
+		args = append(args, after.Name, after.Name, after.JID)
+	}
+	query += " ORDER BY name COLLATE NOCASE, jid LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []Group{}
+	for rows.Next() {
+		var group Group
+		var createdAt sql.NullTime
+		if err := rows.Scan(&group.JID, &group.Name, &group.Topic, &group.ParticipantCount, &group.IsAdmin, &group.IsSuperAdmin,
+			&group.Announce, &group.Locked, &group.JoinApproval, &createdAt, &group.SyncedAt); err != nil {
+			return nil, err
+		}
+		if createdAt.Valid {
+			group.CreatedAt = &createdAt.Time
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
+
+// groupSyncer keeps the stored groups in step with WhatsApp: all of them every
+// groupSyncInterval and after each connect, and one at a time as their
+// notifications arrive
+type groupSyncer struct {
+	client *whatsmeow.Client
+	store  *MessageStore
+	// all asks for a full sync, groups for one of a group
+	all    chan struct{}
+	groups chan types.JID
+}
+
+// newGroupSyncer creates a groupSyncer; Run starts it
+func newGroupSyncer(client *whatsmeow.Client, store *MessageStore) *groupSyncer {
+	return &groupSyncer{
+		client: client,
+		store:  store,
+		all:    make(chan struct{}, 1),
+		groups: make(chan types.JID, 64),
+	}
+}
+
+// SyncAll asks for every group to be fetched again
+func (s *groupSyncer) SyncAll() {
+	select {
+	case s.all <- struct{}{}:
+	default:
+		// A full sync is already due
+	}
+}
+
+// handleEvent fetches a group again when WhatsApp says it changed, or stores
+// one the account just joined
+func (s *groupSyncer) handleEvent(evt interface{}) {
+	switch v := evt.(type) {
+	case *events.Connected:
+		s.SyncAll()
+	case *events.JoinedGroup:
+		if err := s.store.StoreGroup(s.client, &v.GroupInfo, time.Now()); err != nil {
+			slog.Warn("Failed to store group", "chat_jid", v.JID.String(), "error", err)
+		}
+	case *events.GroupInfo:
+		select {
+		case s.groups <- v.JID:
+		default:
+			// Plenty of groups are queued; the next full sync catches this one
+		}
+	}
+}
+
+// Run syncs groups until ctx is done
+func (s *groupSyncer) Run(ctx context.Context) {
+	ticker := time.NewTicker(groupSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.syncAll(ctx)
+		case <-s.all:
+			s.syncAll(ctx)
+		case jid := <-s.groups:
+			s.syncGroup(jid)
+		}
+	}
+}
+
+// syncAll stores every group the account is in and forgets those it left
+func (s *groupSyncer) syncAll(ctx context.Context) {
+	if !s.client.IsConnected() || s.client.Store.ID == nil {
+		return
+	}
+	groups, err := s.client.GetJoinedGroups(ctx)
+	if err != nil {
+		slog.Warn("Failed to sync groups", "error", err)
+		return
+	}
+	now := time.Now()
+	jids := make([]string, len(groups))
+	for i, group := range groups {
+		jids[i] = group.JID.String()
+		if err := s.store.StoreGroup(s.client, group, now); err != nil {
+			slog.Warn("Failed to store group", "chat_jid", jids[i], "error", err)
+		}
+	}
+	if err := s.store.DeleteGroupsExcept(jids); err != nil {
+		slog.Warn("Failed to forget left groups", "error", err)
+	}
+	slog.Info("Synced groups", "groups", len(groups))
+}
+
+// syncGroup stores one group again, or forgets it if the account is no
+// longer in it
+func (s *groupSyncer) syncGroup(jid types.JID) {
+	if !s.client.IsConnected() {
+		return
+	}
+	info, err := s.client.GetGroupInfo(jid)
+	switch {
+	case errors.Is(err, whatsmeow.ErrNotInGroup), errors.Is(err, whatsmeow.ErrGroupNotFound):
+		err = s.store.DeleteGroup(jid.String())
+	case err == nil:
+		err = s.store.StoreGroup(s.client, info, time.Now())
+	}
+	if err != nil {
+		slog.Warn("Failed to sync group", "chat_jid", jid.String(), "error", err)
+	}
+}
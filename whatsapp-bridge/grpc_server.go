@@ -0,0 +1,476 @@
+//go:build grpc
+
+package main
+
+// Serves the gRPC API defined in bridgepb/bridge.proto on BRIDGE_GRPC_PORT.
+// Build with: go build -tags grpc .
+// It goes through the same scheduler and send path as the REST handlers, and
+// accepts the same API keys, as "authorization: Bearer <key>" or "x-api-key"
+// metadata.
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"whatsapp-client/audit"
+	"whatsapp-client/bridgepb"
+	"whatsapp-client/config"
+	bridgeevents "whatsapp-client/events"
+	"whatsapp-client/middleware"
+	"whatsapp-client/pagination"
+	"whatsapp-client/scheduler"
+	"whatsapp-client/validate"
+)
+
+func init() {
+	startGRPC = startGRPCServer
+}
+
+// startGRPCServer listens on cfg.GRPCPort and serves the Bridge service
+func startGRPCServer(cfg *config.Config, client *whatsmeow.Client, store *MessageStore, msgScheduler *scheduler.MessageScheduler, bus *bridgeevents.Bus, auditStore *audit.Store) (func(context.Context), error) {
+	addr := fmt.Sprintf(":%d", cfg.GRPCPort)
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	guard := grpcGuard{allowed: cfg.API.AllowedIPs, authDisabled: cfg.API.AuthDisabled, match: middleware.MatchAPIKey(cfg.API.Keys)}
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(guard.unary, grpcAudit(auditStore)),
+		grpc.ChainStreamInterceptor(guard.stream),
+	)
+	bridgepb.RegisterBridgeServer(server, &grpcBridge{client: client, store: store, msgScheduler: msgScheduler, bus: bus})
+
+	slog.Info("Starting gRPC server", "addr", addr)
+	go func() {
+		if err := server.Serve(lis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			slog.Error("gRPC server error", "error", err)
+		}
+	}()
+
+	return func(ctx context.Context) {
+		done := make(chan struct{})
+		go func() {
+			server.GracefulStop()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			// Event streams and slow calls are cut off
+			slog.Warn("gRPC server did not finish in-flight calls in time")
+			server.Stop()
+		}
+	}, nil
+}
+
+// grpcGuard applies the IP allowlist and API key checks of the REST API to gRPC calls
+type grpcGuard struct {
+	allowed      []netip.Prefix
+	authDisabled bool
+	match        func(presented string) (config.APIKey, bool)
+}
+
+// grpcScopes are the API key scopes the calls need, like their REST routes.
+// Calls not listed need config.ScopeRead.
+var grpcScopes = map[string]string{
+	bridgepb.Bridge_ScheduleMessage_FullMethodName:        config.ScopeSchedule,
+	bridgepb.Bridge_CancelScheduledMessage_FullMethodName: config.ScopeSchedule,
+	bridgepb.Bridge_SendMessage_FullMethodName:            config.ScopeSchedule,
+}
+
+func (g grpcGuard) unary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := g.check(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (g grpcGuard) stream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if _, err := g.check(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// check returns ctx attributed to the caller's API key, or the status to fail a
+// call to method with
+func (g grpcGuard) check(ctx context.Context, method string) (context.Context, error) {
+	if len(g.allowed) > 0 {
+		p, ok := peer.FromContext(ctx)
+		if !ok {
+			return nil, status.Error(codes.PermissionDenied, "Client address not allowed")
+		}
+		addrPort, err := netip.ParseAddrPort(p.Addr.String())
+		if err != nil || !slices.ContainsFunc(g.allowed, func(prefix netip.Prefix) bool { return prefix.Contains(addrPort.Addr().Unmap()) }) {
+			return nil, status.Error(codes.PermissionDenied, "Client address not allowed")
+		}
+	}
+	if g.authDisabled {
+		return ctx, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	presented := ""
+	if values := md.Get("authorization"); len(values) > 0 {
+		scheme, token, ok := strings.Cut(values[0], " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			presented = strings.TrimSpace(token)
+		}
+	} else if values := md.Get("x-api-key"); len(values) > 0 {
+		presented = strings.TrimSpace(values[0])
+	}
+	if presented == "" {
+		return nil, status.Error(codes.Unauthenticated, "API key required")
+	}
+	key, ok := g.match(presented)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "Invalid API key")
+	}
+	scope := grpcScopes[method]
+	if scope == "" {
+		scope = config.ScopeRead
+	}
+	if !key.Allows(scope) {
+		return nil, status.Errorf(codes.PermissionDenied, "API key %q lacks the %s scope", key.Name, scope)
+	}
+	return scheduler.WithCreator(ctx, key.Name), nil
+}
+
+// grpcAudited are the calls recorded in the audit log, the counterparts of the
+// REST API's mutating routes
+var grpcAudited = map[string]bool{
+	bridgepb.Bridge_ScheduleMessage_FullMethodName:        true,
+	bridgepb.Bridge_CancelScheduledMessage_FullMethodName: true,
+	bridgepb.Bridge_SendMessage_FullMethodName:            true,
+}
+
+// grpcAudit records the calls in grpcAudited, like audit.Middleware does for
+// the REST API. Entries have the method GRPC, the full method name as endpoint
+// and the gRPC code as error code.
+func grpcAudit(store *audit.Store) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !grpcAudited[info.FullMethod] {
+			return handler(ctx, req)
+		}
+
+		start := time.Now()
+		resp, err := handler(ctx, req)
+
+		entry := &audit.Entry{
+			ID:         uuid.New().String(),
+			Time:       start,
+			Actor:      grpcCreator(ctx),
+			Method:     "GRPC",
+			Endpoint:   info.FullMethod,
+			ResourceID: grpcResource(req, resp),
+			Status:     grpcHTTPStatus(status.Code(err)),
+			Duration:   time.Since(start).Milliseconds(),
+		}
+		if p, ok := peer.FromContext(ctx); ok {
+			if addrPort, perr := netip.ParseAddrPort(p.Addr.String()); perr == nil {
+				entry.RemoteIP = addrPort.Addr().Unmap().String()
+			}
+		}
+		if msg, ok := req.(proto.Message); ok {
+			if data, merr := protojson.Marshal(msg); merr == nil {
+				entry.Payload = audit.Summarize("application/json", data, -1)
+			}
+		}
+		if err != nil {
+			entry.ErrorCode = status.Code(err).String()
+		}
+		if rerr := store.Record(context.WithoutCancel(ctx), entry); rerr != nil {
+			slog.ErrorContext(ctx, "Failed to record gRPC call in the audit log", "method", info.FullMethod, "error", rerr)
+		}
+		return resp, err
+	}
+}
+
+// grpcResource returns the ID of the message an audited call created or changed
+func grpcResource(req, resp any) string {
+	switch resp := resp.(type) {
+	case *bridgepb.ScheduledMessage:
+		return resp.GetId()
+	case *bridgepb.SendMessageResponse:
+		return resp.GetMessageId()
+	}
+	if req, ok := req.(*bridgepb.CancelScheduledMessageRequest); ok {
+		return req.GetId()
+	}
+	return ""
+}
+
+// grpcHTTPStatus maps a gRPC code to the HTTP status the REST API would answer
+// with, so audit entries of both APIs filter alike
+func grpcHTTPStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.FailedPrecondition, codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// grpcBridge implements bridgepb.BridgeServer
+type grpcBridge struct {
+	bridgepb.UnimplementedBridgeServer
+	client       *whatsmeow.Client
+	store        *MessageStore
+	msgScheduler *scheduler.MessageScheduler
+	bus          *bridgeevents.Bus
+}
+
+func (b *grpcBridge) ScheduleMessage(ctx context.Context, req *bridgepb.ScheduleMessageRequest) (*bridgepb.ScheduledMessage, error) {
+	// Check the fields like POST /v1/schedule does
+	schedule := scheduler.ScheduleMessageRequest{
+		Recipient:           req.GetRecipient(),
+		Message:             req.GetMessage(),
+		DeliveryMode:        req.GetDeliveryMode(),
+		OnlineWindowMinutes: int(req.GetOnlineWindowMinutes()),
+		Precision:           req.GetPrecision(),
+	}
+	if req.GetScheduledTime() != nil {
+		schedule.ScheduledTime = req.GetScheduledTime().AsTime().Format(time.RFC3339Nano)
+	}
+	if req.GetMetadataJson() != "" {
+		schedule.Metadata = json.RawMessage(req.GetMetadataJson())
+	}
+	var v validate.Validator
+	scheduledTime := schedule.Validate(&v)
+	if err := v.Err(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	msg, err := b.msgScheduler.ScheduleMessage(ctx, schedule.Recipient, schedule.Message, scheduledTime, req.GetCheckForResponse(),
+		scheduler.ScheduleOptions{
+			DeliveryMode: schedule.DeliveryMode,
+			OnlineWindow: time.Duration(schedule.OnlineWindowMinutes) * time.Minute,
+			Precision:    schedule.Precision,
+			Metadata:     schedule.Metadata,
+			CreatedBy:    grpcCreator(ctx),
+		})
+	if err != nil {
+		var invalid *scheduler.ValidationError
+		if errors.As(err, &invalid) {
+			return nil, status.Error(codes.InvalidArgument, invalid.Message)
+		}
+		slog.ErrorContext(ctx, "Error scheduling message", "error", err)
+		return nil, status.Error(codes.Internal, "Failed to schedule message")
+	}
+	return scheduledMessageProto(msg), nil
+}
+
+func (b *grpcBridge) GetScheduledMessage(ctx context.Context, req *bridgepb.GetScheduledMessageRequest) (*bridgepb.ScheduledMessage, error) {
+	msg, err := b.msgScheduler.GetMessage(ctx, req.GetId())
+	if err != nil {
+		return nil, lookupStatus(ctx, err)
+	}
+	return scheduledMessageProto(msg), nil
+}
+
+func (b *grpcBridge) ListScheduledMessages(ctx context.Context, req *bridgepb.ListScheduledMessagesRequest) (*bridgepb.ListScheduledMessagesResponse, error) {
+	page := pagination.Params{Limit: int(req.GetLimit()), Cursor: req.GetCursor()}
+	if page.Limit == 0 {
+		page.Limit = pagination.DefaultLimit
+	}
+	if page.Limit < 1 || page.Limit > pagination.MaxLimit {
+		return nil, status.Errorf(codes.InvalidArgument, "limit must be between 1 and %d", pagination.MaxLimit)
+	}
+
+	filter := scheduler.MessageFilter{Status: req.GetStatus(), Recipient: req.GetRecipient(), CreatedBy: req.GetCreatedBy(), Limit: page.Limit + 1}
+	var after scheduler.MessageCursor
+	if ok, err := page.Decode(&after); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid cursor")
+	} else if ok {
+		filter.After = &after
+	}
+
+	messages, err := b.msgScheduler.ListMessages(ctx, filter)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting scheduled messages", "error", err)
+		return nil, status.Error(codes.Internal, "Failed to get scheduled messages")
+	}
+	messages, next := pagination.Page(messages, page, func(msg *scheduler.ScheduledMessage) interface{} {
+		return scheduler.MessageCursor{ScheduledTime: msg.ScheduledTime, ID: msg.ID}
+	})
+
+	resp := &bridgepb.ListScheduledMessagesResponse{NextCursor: next}
+	for _, msg := range messages {
+		resp.Messages = append(resp.Messages, scheduledMessageProto(msg))
+	}
+	return resp, nil
+}
+
+func (b *grpcBridge) CancelScheduledMessage(ctx context.Context, req *bridgepb.CancelScheduledMessageRequest) (*bridgepb.ScheduledMessage, error) {
+	msg, err := b.msgScheduler.Cancel(ctx, req.GetId())
+	if errors.Is(err, scheduler.ErrNotCancellable) {
+		return nil, status.Error(codes.FailedPrecondition, "Can only cancel pending or paused messages")
+	}
+	if err != nil {
+		return nil, lookupStatus(ctx, err)
+	}
+	return scheduledMessageProto(msg), nil
+}
+
+func (b *grpcBridge) SendMessage(ctx context.Context, req *bridgepb.SendMessageRequest) (*bridgepb.SendMessageResponse, error) {
+	send := SendMessageRequest{Recipient: req.GetRecipient(), Message: req.GetMessage(), MediaPath: req.GetMediaPath()}
+	var v validate.Validator
+	send.validate(&v)
+	if err := v.Err(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !b.client.IsConnected() {
+		return nil, status.Error(codes.Unavailable, "Not connected to WhatsApp")
+	}
+
+	// A client hanging up must not abort a send that is under way
+	ctx = context.WithoutCancel(ctx)
+	result := sendWhatsAppMessage(ctx, b.client, b.store, send.Recipient, send.Message, send.MediaPath, scheduler.SendOptions{}, nil)
+	if !result.Success {
+		slog.WarnContext(ctx, "Failed to send message", "recipient", send.Recipient, "status", "failed", "error", result.Message)
+		return nil, status.Error(codes.Unavailable, result.Message)
+	}
+	slog.InfoContext(ctx, "Sent message", "whatsapp_message_id", result.MessageID, "recipient", send.Recipient, "status", "sent")
+	return &bridgepb.SendMessageResponse{MessageId: result.MessageID, ChatJid: result.ChatJID}, nil
+}
+
+func (b *grpcBridge) StreamEvents(req *bridgepb.StreamEventsRequest, stream grpc.ServerStreamingServer[bridgepb.Event]) error {
+	for _, t := range req.GetTypes() {
+		if !slices.Contains(bridgeevents.Types, t) {
+			return status.Errorf(codes.InvalidArgument, "Unknown event type %q, expected one of %s", t, strings.Join(bridgeevents.Types, ", "))
+		}
+	}
+
+	sub := b.bus.Subscribe(req.GetTypes(), req.GetAfterId())
+	defer sub.Close()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case evt, ok := <-sub.C():
+			if !ok {
+				// Dropped for falling behind, or the bridge is shutting down
+				return status.Error(codes.Unavailable, "Event stream closed")
+			}
+			msg, err := eventProto(evt)
+			if err != nil {
+				slog.Warn("Failed to encode event", "event_id", evt.ID, "error", err)
+				continue
+			}
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// grpcCreator works out who is scheduling a message, like
+// scheduler.RequestCreator: the API key name, or else the x-created-by metadata
+func grpcCreator(ctx context.Context) string {
+	if creator := scheduler.ContextCreator(ctx); creator != "" {
+		return creator
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	creator := ""
+	if values := md.Get("x-created-by"); len(values) > 0 {
+		creator = strings.TrimSpace(values[0])
+	}
+	if len(creator) > 200 {
+		creator = creator[:200]
+	}
+	return creator
+}
+
+// lookupStatus reports a failed message lookup
+func lookupStatus(ctx context.Context, err error) error {
+	if errors.Is(err, scheduler.ErrMessageNotFound) {
+		return status.Error(codes.NotFound, "Message not found")
+	}
+	slog.ErrorContext(ctx, "Error getting scheduled message", "error", err)
+	return status.Error(codes.Internal, "Failed to get scheduled message")
+}
+
+// scheduledMessageProto converts a scheduled message for the gRPC API
+func scheduledMessageProto(msg *scheduler.ScheduledMessage) *bridgepb.ScheduledMessage {
+	out := &bridgepb.ScheduledMessage{
+		Id:                  msg.ID,
+		Recipient:           msg.Recipient,
+		Message:             msg.Message,
+		ScheduledTime:       timestamppb.New(msg.ScheduledTime),
+		CreatedAt:           timestamppb.New(msg.CreatedAt),
+		CheckForResponse:    msg.CheckForResponse,
+		Status:              msg.Status,
+		WhatsappMessageId:   msg.WhatsAppMessageID,
+		ChatJid:             msg.ChatJID,
+		DeliveryMode:        msg.DeliveryMode,
+		OnlineWindowMinutes: int32(msg.OnlineWindowMinutes),
+		Precision:           msg.Precision,
+		MetadataJson:        string(msg.Metadata),
+		CreatedBy:           msg.CreatedBy,
+	}
+	if msg.SentAt != nil {
+		out.SentAt = timestamppb.New(*msg.SentAt)
+	}
+	if msg.ErrorMessage != nil {
+		out.ErrorMessage = *msg.ErrorMessage
+	}
+	if msg.DeliveredAt != nil {
+		out.DeliveredAt = timestamppb.New(*msg.DeliveredAt)
+	}
+	if msg.ReadAt != nil {
+		out.ReadAt = timestamppb.New(*msg.ReadAt)
+	}
+	return out
+}
+
+// eventProto converts a bus event, whose data is encoded the same way as on
+// the SSE stream
+func eventProto(evt bridgeevents.Event) (*bridgepb.Event, error) {
+	data, err := json.Marshal(evt.Data)
+	if err != nil {
+		return nil, err
+	}
+	fields := &structpb.Struct{}
+	if err := protojson.Unmarshal(data, fields); err != nil {
+		return nil, err
+	}
+	return &bridgepb.Event{Id: evt.ID, Type: evt.Type, Time: timestamppb.New(evt.Time), Data: fields}, nil
+}
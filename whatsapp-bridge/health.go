@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+
+	"whatsapp-client/config"
+	"whatsapp-client/scheduler"
+)
+
+// readinessTimeout bounds the database pings of a readiness check
+const readinessTimeout = 2 * time.Second
+
+// ComponentStatus is the readiness of one part of the bridge
+type ComponentStatus struct {
+	Status string      `json:"status"` // "ok" or "unavailable"
+	Error  string      `json:"error,omitempty"`
+	Detail interface{} `json:"detail,omitempty"`
+}
+
+// ReadinessResponse is the body of GET /readyz
+type ReadinessResponse struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components"`
+}
+
+// componentStatus turns a check result into a ComponentStatus
+func componentStatus(err error, detail interface{}) ComponentStatus {
+	if err != nil {
+		return ComponentStatus{Status: "unavailable", Error: err.Error(), Detail: detail}
+	}
+	return ComponentStatus{Status: "ok", Detail: detail}
+}
+
+// registerHealthHandlers adds the liveness and readiness endpoints. They live
+// outside the versioned API and need no API key, so container runtimes and
+// monitoring can probe them.
+func registerHealthHandlers(mux *http.ServeMux, client *whatsmeow.Client, messageStore *MessageStore, msgScheduler *scheduler.MessageScheduler) {
+	// GET /healthz - The process is up and serving HTTP
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "ok",
+		})
+	})
+
+	// GET /readyz - WhatsApp is connected, both databases answer and the scheduler runs
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		resp := checkReadiness(r.Context(), client, messageStore, msgScheduler)
+
+		status := http.StatusOK
+		if resp.Status != "ok" {
+			status = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	})
+}
+
+// checkReadiness checks every component. The readiness endpoint and the
+// systemd watchdog share it.
+func checkReadiness(ctx context.Context, client *whatsmeow.Client, messageStore *MessageStore, msgScheduler *scheduler.MessageScheduler) ReadinessResponse {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	var whatsappErr error
+	switch {
+	case !client.IsConnected():
+		whatsappErr = errNotConnected
+	case !client.IsLoggedIn():
+		whatsappErr = errNotLoggedIn
+	}
+
+	schedulerHealth, schedulerErr := msgScheduler.Health()
+
+	resp := ReadinessResponse{
+		Status: "ok",
+		Components: map[string]ComponentStatus{
+			"whatsapp":     componentStatus(whatsappErr, nil),
+			"messages_db":  componentStatus(messageStore.db.PingContext(ctx), nil),
+			"scheduler_db": componentStatus(msgScheduler.PingDB(ctx), nil),
+			"scheduler":    componentStatus(schedulerErr, schedulerHealth),
+		},
+	}
+	for _, component := range resp.Components {
+		if component.Status != "ok" {
+			resp.Status = "unavailable"
+		}
+	}
+	return resp
+}
+
+// healthcheckTimeout bounds the request of --healthcheck
+const healthcheckTimeout = 5 * time.Second
+
+// runHealthcheck asks the bridge running on this host, on its TCP port or else
+// its unix socket, whether it is ready and returns the exit status: 0 when GET
+// /readyz answers 200, 1 otherwise. It is meant for Docker's HEALTHCHECK, so
+// the container is restarted when the WhatsApp connection or the scheduler
+// wedges.
+func runHealthcheck() int {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		return 1
+	}
+
+	// Without TCP, ask over the unix socket
+	httpClient := &http.Client{Timeout: healthcheckTimeout}
+	target := fmt.Sprintf("http://127.0.0.1:%d/readyz", cfg.Port)
+	if cfg.Port == 0 {
+		httpClient.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", cfg.SocketPath)
+			},
+		}
+		target = "http://unix/readyz"
+	}
+	resp, err := httpClient.Get(target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Bridge not reachable: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	// Docker keeps the output in the container's health log
+	io.Copy(os.Stdout, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/pagination"
+	"whatsapp-client/validate"
+)
+
+// HistoryMessage is a stored message of a chat, as its history lists it
+type HistoryMessage struct {
+	ID        string    `json:"id"`
+	ChatJID   string    `json:"chat_jid"`
+	Sender    string    `json:"sender"`
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"`
+	IsFromMe  bool      `json:"is_from_me"`
+	// Media describes the file the message carries, nil for text
+	Media *MessageMedia `json:"media,omitempty"`
+	// Edited is whether Content is the text of an edit
+	Edited bool `json:"edited"`
+	// Revoked is who deleted the message for everyone, nil if nobody did
+	Revoked *MessageRevocation `json:"revoked,omitempty"`
+	// Reactions are everyone's current reactions, oldest first
+	Reactions []MessageReaction `json:"reactions,omitempty"`
+}
+
+// MessageMedia is the file a message carries
+type MessageMedia struct {
+	Type     string `json:"type"`
+	Filename string `json:"filename,omitempty"`
+	// Size is the file's size in bytes, as its sender gave it
+	Size int64 `json:"size,omitempty"`
+	// Downloaded is whether the bridge has the file on disk, so
+	// GET /v1/messages/{chat}/{id}/media serves it without a connection
+	Downloaded bool `json:"downloaded"`
+	// RemovedAt is when the bridge deleted the file it had downloaded, to keep
+	// within the media policy
+	RemovedAt *time.Time `json:"removed_at,omitempty"`
+}
+
+// historyCursor is the sort key of the last message of a page
+type historyCursor struct {
+	Timestamp time.Time `json:"t"`
+	ID        string    `json:"id"`
+}
+
+// ChatExists reports whether the bridge has stored a chat
+func (store *MessageStore) ChatExists(chatJID string) (bool, error) {
+	var exists bool
+	err := store.db.QueryRow("SELECT 1 FROM chats WHERE jid = ?", chatJID).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return exists, err
+}
+
+// GetChatHistory returns the messages of a chat, latest first, sent at or
+// after since and before until when they aren't zero, with their latest text
+// and whether they were edited, deleted or reacted to
+func (store *MessageStore) GetChatHistory(chatJID string, since, until time.Time, before *historyCursor, limit int) ([]HistoryMessage, error) {
+	query := `SELECT m.id, m.sender, m.content, m.timestamp, m.is_from_me, m.media_type, m.filename, m.file_length, f.path IS NOT NULL, f.removed_at,
+		EXISTS (SELECT 1 FROM message_edits e WHERE e.message_id = m.id AND e.chat_jid = m.chat_jid), r.revoked_by, r.revoked_at
+		FROM messages m LEFT JOIN media_files f ON f.message_id = m.id AND f.chat_jid = m.chat_jid
+		LEFT JOIN message_revocations r ON r.message_id = m.id AND r.chat_jid = m.chat_jid
+		WHERE m.chat_jid = ?`
+	args := []interface{}{chatJID}
+	// Timestamps are stored with the offset of the time zone they came in,
+	// so they are compared as times rather than as text
+	if !since.IsZero() {
+		query += " AND julianday(m.timestamp) >= julianday(?)"
+		args = append(args, since)
+	}
+	if !until.IsZero() {
+		query += " AND julianday(m.timestamp) < julianday(?)"
+		args = append(args, until)
+	}
+	if before != nil {
+		query += " AND (julianday(m.timestamp) < julianday(?) OR (julianday(m.timestamp) = julianday(?) AND m.id < ?))"
+		args = append(args, before.Timestamp, before.Timestamp, before.ID)
+	}
+	query += " ORDER BY m.timestamp DESC, m.id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []HistoryMessage{}
+	var ids []string
+	for rows.Next() {
+		msg := HistoryMessage{ChatJID: chatJID}
+		var sender, content, mediaType, filename, revokedBy sql.NullString
+		var fileLength sql.NullInt64
+		var downloaded bool
+		var removedAt, revokedAt sql.NullTime
+		if err := rows.Scan(&msg.ID, &sender, &content, &msg.Timestamp, &msg.IsFromMe, &mediaType, &filename, &fileLength, &downloaded,
+			&removedAt, &msg.Edited, &revokedBy, &revokedAt); err != nil {
+			return nil, err
+		}
+		msg.Sender, msg.Content = sender.String, content.String
+		if revokedAt.Valid {
+			msg.Revoked = &MessageRevocation{RevokedBy: revokedBy.String, RevokedAt: revokedAt.Time}
+		}
+		if mediaType.String != "" {
+			msg.Media = &MessageMedia{
+				Type:       mediaType.String,
+				Filename:   filename.String,
+				Size:       fileLength.Int64,
+				Downloaded: downloaded,
+			}
+			if removedAt.Valid {
+				msg.Media.RemovedAt = &removedAt.Time
+			}
+		}
+		messages = append(messages, msg)
+		ids = append(ids, msg.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	reactions, err := store.GetMessageReactions(chatJID, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range messages {
+		messages[i].Reactions = reactions[messages[i].ID]
+	}
+	return messages, nil
+}
+
+// historyPageLimit is the page size of a chat's history when the request
+// doesn't set one
+const historyPageLimit = 50
+
+// registerHistoryHandlers adds the endpoint listing a chat's messages
+func registerHistoryHandlers(mux *http.ServeMux, store *MessageStore) {
+	// GET /v1/chats/{jid}/messages - The stored messages of a chat, latest
+	// first, a page at a time, each page going further back. since and until
+	// bound when the messages were sent. before is another name for cursor,
+	// as the page is of the messages before the previous one.
+	mux.HandleFunc("GET /v1/chats/{jid}/messages", func(w http.ResponseWriter, r *http.Request) {
+		page, err := pagination.FromRequestLimit(r, historyPageLimit)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+		query := r.URL.Query()
+		if before := query.Get("before"); before != "" {
+			if page.Cursor != "" && page.Cursor != before {
+				apierror.BadRequest(w, "before and cursor are the same parameter, pass only one")
+				return
+			}
+			page.Cursor = before
+		}
+		var v validate.Validator
+		chat := v.Recipient("jid", r.PathValue("jid"))
+		var since, until time.Time
+		if value := query.Get("since"); value != "" {
+			since = v.Time("since", value)
+		}
+		if value := query.Get("until"); value != "" {
+			until = v.Time("until", value)
+		}
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		chatJID, err := parseRecipient(chat)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+		var cursor *historyCursor
+		var decoded historyCursor
+		if ok, err := page.Decode(&decoded); err != nil {
+			apierror.BadRequest(w, "Invalid cursor")
+			return
+		} else if ok {
+			cursor = &decoded
+		}
+
+		exists, err := store.ChatExists(chatJID.String())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to get chat", "chat_jid", chatJID, "error", err)
+			apierror.Internal(w, "Failed to get chat")
+			return
+		}
+		if !exists {
+			apierror.NotFound(w, "No chat stored with "+chatJID.String())
+			return
+		}
+		messages, err := store.GetChatHistory(chatJID.String(), since, until, cursor, page.Limit+1)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to get chat history", "chat_jid", chatJID, "error", err)
+			apierror.Internal(w, "Failed to get chat history")
+			return
+		}
+		messages, next := pagination.Page(messages, page, func(msg HistoryMessage) interface{} {
+			return historyCursor{Timestamp: msg.Timestamp, ID: msg.ID}
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":     true,
+			"chat_jid":    chatJID.String(),
+			"messages":    messages,
+			"next_cursor": next,
+		})
+	})
+}
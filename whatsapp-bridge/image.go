@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"log/slog"
+	"path/filepath"
+	"strings"
+)
+
+// maxImageSide is the longest side of an image sent as it is, in pixels.
+// Larger ones are scaled down, as WhatsApp's own apps do, so they upload
+// faster and the recipient's phone doesn't shrink them again.
+const maxImageSide = 2560
+
+// scaledImageQuality is the JPEG quality of scaled-down images
+const scaledImageQuality = 85
+
+// prepareImage scales down an oversized image and reads its size and a
+// thumbnail
+func prepareImage(ctx context.Context, media *outgoingMedia) error {
+	img, err := decodeImage(ctx, media)
+	if err != nil || img == nil {
+		return err
+	}
+
+	bounds := img.Bounds()
+	if max(bounds.Dx(), bounds.Dy()) > maxImageSide {
+		scaled, err := scaleImage(img, maxImageSide)
+		var data []byte
+		if err == nil {
+			data, err = encodeJPEG(scaled, scaledImageQuality)
+		}
+		if err != nil {
+			return fmt.Errorf("can't be scaled down: %v", err)
+		}
+		slog.InfoContext(ctx, "Scaled down image", "width", bounds.Dx(), "height", bounds.Dy(), "size", len(media.data), "scaled_size", len(data))
+		media.data, media.mimetype = data, "image/jpeg"
+		media.filename = strings.TrimSuffix(media.filename, filepath.Ext(media.filename)) + ".jpg"
+		img, bounds = scaled, scaled.Bounds()
+	}
+
+	media.width, media.height = uint32(bounds.Dx()), uint32(bounds.Dy())
+	if media.thumbnail, err = jpegThumbnail(img, thumbnailSize); err != nil {
+		slog.Warn("Failed to make image thumbnail", "error", err)
+	}
+	return nil
+}
+
+// decodeImage decodes an image to send. WebP has no decoder in the standard
+// library, so ffmpeg decodes it; without ffmpeg, or for animated WebP, the
+// image is nil and goes as it is, without a preview.
+func decodeImage(ctx context.Context, media *outgoingMedia) (image.Image, error) {
+	data := media.data
+	if media.mimetype == "image/webp" {
+		frame, err := runFFmpeg(ctx, media.data, "-frames:v", "1", "-f", "image2pipe", "-c:v", "png", "pipe:1")
+		if err != nil {
+			if !errors.Is(err, errNoFFmpeg) {
+				slog.Warn("Failed to decode WebP image", "error", err)
+			}
+			return nil, nil
+		}
+		data = frame
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	switch {
+	case err != nil && media.mimetype == "image/webp":
+		slog.Warn("Failed to decode WebP image", "error", err)
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("is not a valid image: %v", err)
+	}
+	return img, nil
+}
+
+// jpegThumbnail scales img down so its longest side is at most size pixels
+// and encodes it as JPEG
+func jpegThumbnail(img image.Image, size int) ([]byte, error) {
+	thumb, err := scaleImage(img, size)
+	if err != nil {
+		return nil, err
+	}
+	return encodeJPEG(thumb, 75)
+}
+
+// scaleImage scales img down so its longest side is at most size pixels,
+// averaging the pixels each new pixel covers. Smaller images are copied as
+// they are.
+func scaleImage(img image.Image, size int) (*image.RGBA, error) {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w == 0 || h == 0 {
+		return nil, errors.New("image is empty")
+	}
+	tw, th := w, h
+	if w > size || h > size {
+		if w >= h {
+			tw, th = size, max(1, h*size/w)
+		} else {
+			tw, th = max(1, w*size/h), size
+		}
+	}
+
+	scaled := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := bounds.Min.Y+y*h/th, bounds.Min.Y+max((y+1)*h/th, y*h/th+1)
+		for x := 0; x < tw; x++ {
+			x0, x1 := bounds.Min.X+x*w/tw, bounds.Min.X+max((x+1)*w/tw, x*w/tw+1)
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, b, a, n = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa), n+1
+				}
+			}
+			i := scaled.PixOffset(x, y)
+			scaled.Pix[i] = uint8(r / n >> 8)
+			scaled.Pix[i+1] = uint8(g / n >> 8)
+			scaled.Pix[i+2] = uint8(b / n >> 8)
+			scaled.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return scaled, nil
+}
+
+// encodeJPEG encodes img as JPEG over a white background, since JPEG has no
+// transparency and transparent pixels would turn black
+func encodeJPEG(img image.Image, quality int) ([]byte, error) {
+	flat := image.NewRGBA(img.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, flat, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
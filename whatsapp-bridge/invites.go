@@ -0,0 +1,312 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/audit"
+	"whatsapp-client/pagination"
+	"whatsapp-client/validate"
+)
+
+// GroupInvite is an invite to join a group, received as a message
+type GroupInvite struct {
+	MessageID string `json:"message_id"`
+	ChatJID   string `json:"chat_jid"`
+	// Inviter is the admin who sent the invite
+	Inviter   string `json:"inviter"`
+	GroupJID  string `json:"group_jid"`
+	GroupName string `json:"group_name"`
+	Caption   string `json:"caption,omitempty"`
+	// ExpiresAt is when the invite stops working, nil if it doesn't say
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Timestamp time.Time  `json:"timestamp"`
+	// AcceptedAt is when the bridge joined the group with the invite
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+
+	// code is what WhatsApp takes to join with the invite; it isn't shown, so
+	// the invite works only through the bridge
+	code string
+}
+
+// invitesCursor is the sort key of the last invite of a page
+type invitesCursor struct {
+	Timestamp time.Time `json:"t"`
+	ChatJID   string    `json:"c"`
+	MessageID string    `json:"id"`
+}
+
+// groupInvite is the invite a message carries, nil if it isn't one
+func groupInvite(msg *waProto.Message, id, chatJID, inviter string, timestamp time.Time) *GroupInvite {
+	invite := msg.GetGroupInviteMessage()
+	if invite == nil || invite.GetGroupJID() == "" || invite.GetInviteCode() == "" {
+		return nil
+	}
+	stored := &GroupInvite{
+		MessageID: id,
+		ChatJID:   chatJID,
+		Inviter:   inviter,
+		GroupJID:  invite.GetGroupJID(),
+		GroupName: invite.GetGroupName(),
+		Caption:   invite.GetCaption(),
+		Timestamp: timestamp,
+		code:      invite.GetInviteCode(),
+	}
+	if expiration := invite.GetInviteExpiration(); expiration > 0 {
+		expiresAt := time.Unix(expiration, 0)
+		stored.ExpiresAt = &expiresAt
+	}
+	return stored
+}
+
+// inviteSummary is the text stored for a group invite message, so it shows up
+// in message lists like any other
+func inviteSummary(invite *waProto.GroupInviteMessage) string {
+	summary := "Group invite: " + invite.GetGroupName()
+	if caption := invite.GetCaption(); caption != "" {
+		summary += "\n" + caption
+	}
+	return summary
+}
+
+// StoreGroupInvite records an invite received as a message. An invite stored
+// before keeps when it was accepted.
+func (store *MessageStore) StoreGroupInvite(invite *GroupInvite) error {
+	_, err := store.db.Exec(
+		`INSERT INTO group_invites (message_id, chat_jid, inviter, group_jid, group_name, code, caption, expires_at, timestamp)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (message_id, chat_jid) DO UPDATE SET inviter = excluded.inviter, group_jid = excluded.group_jid,
+			group_name = excluded.group_name, code = excluded.code, caption = excluded.caption,
+			expires_at = excluded.expires_at, timestamp = excluded.timestamp`,
+		invite.MessageID, invite.ChatJID, invite.Inviter, invite.GroupJID, invite.GroupName, invite.code, invite.Caption,
+		invite.ExpiresAt, invite.Timestamp,
+	)
+	return err
+}
+
+// storeMessageInvite stores the group invite a message carries, if it is one
+func storeMessageInvite(store *MessageStore, id, chatJID, inviter string, timestamp time.Time, msg *waProto.Message) {
+	invite := groupInvite(msg, id, chatJID, inviter, timestamp)
+	if invite == nil {
+		return
+	}
+	if err := store.StoreGroupInvite(invite); err != nil {
+		slog.Warn("Failed to store group invite", "message_id", id, "chat_jid", chatJID, "error", err)
+	}
+}
+
+// inviteColumns are the columns scanInvite reads, in order
+const inviteColumns = "message_id, chat_jid, inviter, group_jid, group_name, code, caption, expires_at, timestamp, accepted_at"
+
+// scanInvite reads an invite from a row of inviteColumns
+func scanInvite(row interface{ Scan(...interface{}) error }) (*GroupInvite, error) {
+	var invite GroupInvite
+	var caption sql.NullString
+	var expiresAt, acceptedAt sql.NullTime
+	if err := row.Scan(&invite.MessageID, &invite.ChatJID, &invite.Inviter, &invite.GroupJID, &invite.GroupName, &invite.code,
+		&caption, &expiresAt, &invite.Timestamp, &acceptedAt); err != nil {
+		return nil, err
+	}
+	invite.Caption = caption.String
+	if expiresAt.Valid {
+		invite.ExpiresAt = &expiresAt.Time
+	}
+	if acceptedAt.Valid {
+		invite.AcceptedAt = &acceptedAt.Time
+	}
+	return &invite, nil
+}
+
+// GetGroupInvite returns the invite message id in a chat carries, nil if the
+// bridge has no invite stored for it
+func (store *MessageStore) GetGroupInvite(messageID, chatJID string) (*GroupInvite, error) {
+	invite, err := scanInvite(store.db.QueryRow(
+		"SELECT "+inviteColumns+" FROM group_invites WHERE message_id = ? AND chat_jid = ?",
+		messageID, chatJID,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return invite, err
+}
+
+// GetGroupInvites returns the stored invites, latest first, from one chat or
+// from all when chatJID is empty. pending keeps those not accepted that
+// haven't expired at now.
+func (store *MessageStore) GetGroupInvites(chatJID string, pending bool, now time.Time, after *invitesCursor, limit int) ([]GroupInvite, error) {
+	query := "SELECT " + inviteColumns + " FROM group_invites WHERE 1 = 1"
+	var args []interface{}
+	if chatJID != "" {
+		query += " AND chat_jid = ?"
+		args = append(args, chatJID)
+	}
+	if pending {
+		query += " AND accepted_at IS NULL AND (expires_at IS NULL OR expires_at > ?)"
+		args = append(args, now)
+	}
+	if after != nil {
+		query += ` AND (timestamp < ? OR (timestamp = ? AND (chat_jid < ? OR (chat_jid = ? AND message_id < ?))))`
+		args = append(args, after.Timestamp, after.Timestamp, after.ChatJID, after.ChatJID, after.MessageID)
+	}
+	query += " ORDER BY timestamp DESC, chat_jid DESC, message_id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []GroupInvite{}
+	for rows.Next() {
+		invite, err := scanInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, *invite)
+	}
+	return invites, rows.Err()
+}
+
+// SetInviteAccepted records that the bridge joined a group with an invite
+func (store *MessageStore) SetInviteAccepted(messageID, chatJID string, at time.Time) error {
+	_, err := store.db.Exec(
+		"UPDATE group_invites SET accepted_at = ? WHERE message_id = ? AND chat_jid = ?",
+		at, messageID, chatJID,
+	)
+	return err
+}
+
+// registerInviteHandlers adds the endpoints for listing group invites received
+// as messages and joining groups with them
+func registerInviteHandlers(mux *http.ServeMux, client *whatsmeow.Client, store *MessageStore) {
+	// GET /v1/groups/invites - Invites received as messages, latest first, a
+	// page at a time. ?chat= keeps those of one chat, ?pending=true those not
+	// accepted that haven't expired.
+	mux.HandleFunc("GET /v1/groups/invites", func(w http.ResponseWriter, r *http.Request) {
+		page, err := pagination.FromRequest(r)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+		var v validate.Validator
+		var chatJID string
+		if chat := r.URL.Query().Get("chat"); chat != "" {
+			chat = v.Recipient("chat", chat)
+			if jid, err := parseRecipient(chat); err == nil && !v.Failed("chat") {
+				chatJID = jid.String()
+			}
+		}
+		pending := r.URL.Query().Get("pending")
+		v.OneOf("pending", pending, "true", "false")
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		var cursor *invitesCursor
+		var decoded invitesCursor
+		if ok, err := page.Decode(&decoded); err != nil {
+			apierror.BadRequest(w, "Invalid cursor")
+			return
+		} else if ok {
+			cursor = &decoded
+		}
+
+		invites, err := store.GetGroupInvites(chatJID, pending == "true", time.Now(), cursor, page.Limit+1)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to get group invites", "error", err)
+			apierror.Internal(w, "Failed to get group invites")
+			return
+		}
+		invites, next := pagination.Page(invites, page, func(invite GroupInvite) interface{} {
+			return invitesCursor{Timestamp: invite.Timestamp, ChatJID: invite.ChatJID, MessageID: invite.MessageID}
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":     true,
+			"invites":     invites,
+			"next_cursor": next,
+		})
+	})
+
+	// POST /v1/messages/{chat}/{id}/join - Join the group an invite message
+	// is for
+	mux.HandleFunc("POST /v1/messages/{chat}/{id}/join", func(w http.ResponseWriter, r *http.Request) {
+		var v validate.Validator
+		chat := v.Recipient("chat", r.PathValue("chat"))
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		chatJID, err := parseRecipient(chat)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+		messageID := r.PathValue("id")
+
+		invite, err := store.GetGroupInvite(messageID, chatJID.String())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to get group invite", "message_id", messageID, "error", err)
+			apierror.Internal(w, "Failed to get group invite")
+			return
+		}
+		if invite == nil {
+			apierror.NotFound(w, "No group invite stored for message "+messageID+" in "+chatJID.String())
+			return
+		}
+		if invite.ExpiresAt != nil && !invite.ExpiresAt.After(time.Now()) {
+			apierror.BadRequest(w, "The invite expired at "+invite.ExpiresAt.UTC().Format(time.RFC3339))
+			return
+		}
+		groupJID, err := types.ParseJID(invite.GroupJID)
+		if err != nil {
+			apierror.Internal(w, "Invalid group JID stored for the invite")
+			return
+		}
+		inviter, err := parseRecipient(invite.Inviter)
+		if err != nil {
+			apierror.Internal(w, "Invalid inviter stored for the invite")
+			return
+		}
+
+		if !client.IsConnected() {
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not connected to WhatsApp")
+			return
+		}
+
+		var expiration int64
+		if invite.ExpiresAt != nil {
+			expiration = invite.ExpiresAt.Unix()
+		}
+		if err := client.JoinGroupWithInvite(groupJID, inviter, invite.code, expiration); err != nil {
+			slog.WarnContext(r.Context(), "Failed to join group", "message_id", messageID, "group_jid", groupJID, "error", err)
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, "Failed to join group: "+err.Error())
+			return
+		}
+		slog.InfoContext(r.Context(), "Joined group with invite", "message_id", messageID, "group_jid", groupJID)
+		audit.SetResource(r.Context(), groupJID.String())
+
+		if err := store.SetInviteAccepted(messageID, chatJID.String(), time.Now()); err != nil {
+			slog.WarnContext(r.Context(), "Failed to store accepted invite", "message_id", messageID, "error", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    true,
+			"message_id": messageID,
+			"group_jid":  groupJID.String(),
+			"group_name": invite.GroupName,
+		})
+	})
+}
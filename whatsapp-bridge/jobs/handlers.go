@@ -0,0 +1,124 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/audit"
+	"whatsapp-client/pagination"
+)
+
+// Async reports whether the client asked for a request to run as a job, with
+// "Prefer: respond-async" or ?async=true
+func Async(r *http.Request) bool {
+	if r.URL.Query().Get("async") == "true" {
+		return true
+	}
+	for _, prefer := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(prefer, ",") {
+			if strings.EqualFold(strings.TrimSpace(pref), "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Accepted answers a request that started job with 202 and the job's URL
+func Accepted(w http.ResponseWriter, r *http.Request, job Job) {
+	audit.SetResource(r.Context(), job.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/v1/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"job":     job,
+	})
+}
+
+// StartError answers a request whose job couldn't be started
+func StartError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrStopped) {
+		apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeInternal, "The bridge is shutting down")
+		return
+	}
+	apierror.Internal(w, "Failed to start the job")
+}
+
+// SetupHandlers returns the HTTP handler serving the jobs under /v1/jobs
+func SetupHandlers(m *Manager) http.Handler {
+	mux := http.NewServeMux()
+
+	// GET /v1/jobs - Recent jobs, newest first, a page at a time
+	mux.HandleFunc("GET /v1/jobs", func(w http.ResponseWriter, r *http.Request) {
+		page, err := pagination.FromRequest(r)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+		filter := Filter{Type: r.URL.Query().Get("type"), Status: r.URL.Query().Get("status")}
+		if filter.Status != "" && !slices.Contains(Statuses, filter.Status) {
+			apierror.BadRequest(w, "status must be one of "+strings.Join(Statuses, ", "))
+			return
+		}
+		var after Cursor
+		if ok, err := page.Decode(&after); err != nil {
+			apierror.BadRequest(w, "Invalid cursor")
+			return
+		} else if ok {
+			filter.After = &after
+		}
+		filter.Limit = page.Limit + 1
+
+		jobs, next := pagination.Page(m.List(filter), page, func(j Job) interface{} {
+			return Cursor{CreatedAt: j.CreatedAt, ID: j.ID}
+		})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":     true,
+			"jobs":        jobs,
+			"next_cursor": next,
+		})
+	})
+
+	// GET /v1/jobs/{id} - Progress of a job, and its result once it finished
+	mux.HandleFunc("GET /v1/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		job, err := m.Get(r.PathValue("id"))
+		if err != nil {
+			apierror.NotFound(w, "Job not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"job":     job,
+		})
+	})
+
+	// DELETE /v1/jobs/{id} - Cancel a queued or running job
+	mux.HandleFunc("DELETE /v1/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		audit.SetResource(r.Context(), id)
+		job, err := m.Cancel(id)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			apierror.NotFound(w, "Job not found")
+			return
+		case errors.Is(err, ErrFinished):
+			apierror.Conflict(w, "The job already finished")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Cancellation requested",
+			"job":     job,
+		})
+	})
+
+	return apierror.Wrap(mux)
+}
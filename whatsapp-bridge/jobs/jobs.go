@@ -0,0 +1,362 @@
+// Package jobs runs long operations in the background, so API clients get a job
+// ID right away and poll GET /v1/jobs/{id} for progress and the result instead
+// of holding a request open for minutes.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"whatsapp-client/apierror"
+)
+
+// Job statuses
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// Statuses lists every job status, for validating filters
+var Statuses = []string{StatusQueued, StatusRunning, StatusSucceeded, StatusFailed, StatusCancelled}
+
+// Defaults for NewManager
+const (
+	DefaultWorkers = 2
+	DefaultKeep    = time.Hour
+)
+
+// maxFinished bounds the finished jobs kept in memory, whatever their age
+const maxFinished = 1000
+
+var (
+	// ErrNotFound is returned for an unknown or expired job ID
+	ErrNotFound = errors.New("job not found")
+	// ErrFinished is returned by Cancel for a job that already finished
+	ErrFinished = errors.New("job already finished")
+	// ErrStopped is returned by Start once Stop has been called
+	ErrStopped = errors.New("job manager is stopped")
+)
+
+// Job is a background operation and, once it finished, its outcome
+type Job struct {
+	ID string `json:"id"`
+	// Type names the operation, e.g. "import" or "send"
+	Type      string `json:"type"`
+	Status    string `json:"status"`
+	CreatedBy string `json:"created_by,omitempty"`
+	// Progress is how far a running job got, when the operation reports it
+	Progress   *Progress       `json:"progress,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      *apierror.Body  `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	StartedAt  *time.Time      `json:"started_at,omitempty"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+}
+
+// Progress counts the items a job has handled
+type Progress struct {
+	Done int `json:"done"`
+	// Total is 0 while unknown
+	Total int `json:"total"`
+}
+
+// Finished reports whether the job succeeded, failed or was cancelled
+func (j *Job) Finished() bool {
+	return j.Status == StatusSucceeded || j.Status == StatusFailed || j.Status == StatusCancelled
+}
+
+// Func runs a job. It should stop early when ctx is cancelled, may call report
+// as it goes, and returns the result, which is stored as JSON. A cancelled job
+// keeps the result it returns, saying how far it got. Errors made with
+// Fail are shown to the client as they are; others are logged and reported as
+// internal errors.
+type Func func(ctx context.Context, report func(done, total int)) (interface{}, error)
+
+// Failure is a job error meant for the client
+type Failure struct {
+	Code    string
+	Message string
+}
+
+func (f *Failure) Error() string {
+	return f.Message
+}
+
+// Fail returns a Failure with an apierror code and message
+func Fail(code, message string) error {
+	return &Failure{Code: code, Message: message}
+}
+
+// Manager runs jobs on a few workers and keeps finished ones for a while
+type Manager struct {
+	keep    time.Duration
+	workers chan struct{}
+
+	mu      sync.Mutex
+	jobs    map[string]*entry
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+type entry struct {
+	job    Job
+	cancel context.CancelFunc
+}
+
+// NewManager returns a manager running up to workers jobs at once and keeping
+// finished jobs for keep
+func NewManager(workers int, keep time.Duration) *Manager {
+	return &Manager{
+		keep:    keep,
+		workers: make(chan struct{}, max(workers, 1)),
+		jobs:    make(map[string]*entry),
+	}
+}
+
+// Start queues run as a job of jobType and returns it. The job keeps ctx's
+// values, such as the request ID, but not its cancellation, so it outlives the
+// request that started it.
+func (m *Manager) Start(ctx context.Context, jobType, createdBy string, run Func) (Job, error) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+
+	m.mu.Lock()
+	if m.stopped {
+		m.mu.Unlock()
+		cancel()
+		return Job{}, ErrStopped
+	}
+	m.prune(time.Now())
+	e := &entry{
+		job: Job{
+			ID:        uuid.New().String(),
+			Type:      jobType,
+			Status:    StatusQueued,
+			CreatedBy: createdBy,
+			CreatedAt: time.Now().UTC(),
+		},
+		cancel: cancel,
+	}
+	m.jobs[e.job.ID] = e
+	job := e.job
+	m.wg.Add(1)
+	m.mu.Unlock()
+
+	go m.run(ctx, e, run)
+	return job, nil
+}
+
+// run waits for a worker, then runs the job and records its outcome
+func (m *Manager) run(ctx context.Context, e *entry, run Func) {
+	defer m.wg.Done()
+	defer e.cancel()
+
+	select {
+	case m.workers <- struct{}{}:
+		defer func() { <-m.workers }()
+	case <-ctx.Done():
+		m.finish(e, nil, context.Canceled)
+		return
+	}
+
+	if ctx.Err() != nil {
+		m.finish(e, nil, context.Canceled)
+		return
+	}
+
+	m.mu.Lock()
+	now := time.Now().UTC()
+	e.job.Status = StatusRunning
+	e.job.StartedAt = &now
+	m.mu.Unlock()
+
+	slog.InfoContext(ctx, "Job started", "job_id", e.job.ID, "type", e.job.Type)
+	result, err := run(ctx, func(done, total int) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		e.job.Progress = &Progress{Done: done, Total: total}
+	})
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	m.finish(e, result, err)
+}
+
+// finish records the outcome of a job
+func (m *Manager) finish(e *entry, result interface{}, err error) {
+	var data json.RawMessage
+	if result != nil && (err == nil || errors.Is(err, context.Canceled)) {
+		var marshalErr error
+		if data, marshalErr = json.Marshal(result); marshalErr != nil && err == nil {
+			err = marshalErr
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now().UTC()
+	e.job.FinishedAt = &now
+
+	var failure *Failure
+	switch {
+	case err == nil:
+		e.job.Status = StatusSucceeded
+		e.job.Result = data
+	case errors.Is(err, context.Canceled):
+		e.job.Status = StatusCancelled
+		e.job.Result = data
+	case errors.As(err, &failure):
+		e.job.Status = StatusFailed
+		e.job.Error = &apierror.Body{Code: failure.Code, Message: failure.Message}
+	default:
+		slog.Error("Job failed", "job_id", e.job.ID, "type", e.job.Type, "error", err)
+		e.job.Status = StatusFailed
+		e.job.Error = &apierror.Body{Code: apierror.CodeInternal, Message: "The job failed, see the bridge's logs"}
+	}
+	slog.Info("Job finished", "job_id", e.job.ID, "type", e.job.Type, "status", e.job.Status)
+}
+
+// Get returns a copy of the job with the given ID, or ErrNotFound
+func (m *Manager) Get(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prune(time.Now())
+	e, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return e.job, nil
+}
+
+// Filter selects jobs in List. Empty fields match everything.
+type Filter struct {
+	Type   string
+	Status string
+	// After starts the list after this job, for paging
+	After *Cursor
+	// Limit caps the number of jobs returned; 0 returns all
+	Limit int
+}
+
+// Cursor is the sort key of a job in List order
+type Cursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        string    `json:"id"`
+}
+
+// before reports whether job j sorts before the cursor position in List order
+func (c *Cursor) before(j Job) bool {
+	if !j.CreatedAt.Equal(c.CreatedAt) {
+		return j.CreatedAt.After(c.CreatedAt)
+	}
+	return j.ID >= c.ID
+}
+
+// List returns copies of the jobs matching filter, newest first
+func (m *Manager) List(filter Filter) []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prune(time.Now())
+	jobs := make([]Job, 0, len(m.jobs))
+	for _, e := range m.jobs {
+		if (filter.Type == "" || e.job.Type == filter.Type) && (filter.Status == "" || e.job.Status == filter.Status) {
+			jobs = append(jobs, e.job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].CreatedAt.Equal(jobs[j].CreatedAt) {
+			return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+		}
+		return jobs[i].ID > jobs[j].ID
+	})
+	if filter.After != nil {
+		n := 0
+		for n < len(jobs) && filter.After.before(jobs[n]) {
+			n++
+		}
+		jobs = jobs[n:]
+	}
+	if filter.Limit > 0 && len(jobs) > filter.Limit {
+		jobs = jobs[:filter.Limit]
+	}
+	return jobs
+}
+
+// Cancel stops a queued or running job. A running job ends once its operation
+// notices; work it already did, like messages it imported, stays done. It fails
+// with ErrNotFound or ErrFinished.
+func (m *Manager) Cancel(id string) (Job, error) {
+	m.mu.Lock()
+	e, ok := m.jobs[id]
+	if !ok {
+		m.mu.Unlock()
+		return Job{}, ErrNotFound
+	}
+	if e.job.Finished() {
+		m.mu.Unlock()
+		return Job{}, ErrFinished
+	}
+	job := e.job
+	m.mu.Unlock()
+
+	e.cancel()
+	return job, nil
+}
+
+// Stop refuses new jobs and waits for the queued and running ones until ctx is
+// done, then cancels those left
+func (m *Manager) Stop(ctx context.Context) {
+	m.mu.Lock()
+	m.stopped = true
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+
+	slog.Warn("Cancelling jobs still running at shutdown")
+	m.mu.Lock()
+	for _, e := range m.jobs {
+		e.cancel()
+	}
+	m.mu.Unlock()
+}
+
+// prune forgets finished jobs older than keep, and the oldest ones beyond
+// maxFinished. m.mu must be held.
+func (m *Manager) prune(now time.Time) {
+	var finished []*entry
+	for id, e := range m.jobs {
+		if !e.job.Finished() {
+			continue
+		}
+		if now.Sub(*e.job.FinishedAt) > m.keep {
+			delete(m.jobs, id)
+			continue
+		}
+		finished = append(finished, e)
+	}
+	if len(finished) <= maxFinished {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].job.FinishedAt.Before(*finished[j].job.FinishedAt) })
+	for _, e := range finished[:len(finished)-maxFinished] {
+		delete(m.jobs, e.job.ID)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"golang.org/x/net/html"
+	"google.golang.org/protobuf/proto"
+)
+
+// linkPreviewTimeout bounds fetching a page and its image for a preview. A
+// preview that takes longer is left out rather than holding up the message.
+const linkPreviewTimeout = 10 * time.Second
+
+// maxLinkPreviewPage is how much of a page is read looking for its title and
+// description, in bytes
+const maxLinkPreviewPage = 1 << 20
+
+// maxLinkPreviewImage is the largest preview image fetched, in bytes
+const maxLinkPreviewImage = 5 << 20
+
+// maxLinkPreviewText bounds the title and the description, in characters
+const maxLinkPreviewText = 300
+
+// linkPattern finds links in message text
+var linkPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// linkHTTPClient fetches pages and images for link previews
+var linkHTTPClient = &http.Client{Timeout: linkPreviewTimeout}
+
+// linkPreview is what a page says about itself, as shown under a link
+type linkPreview struct {
+	title       string
+	description string
+	imageURL    string
+	thumbnail   []byte
+}
+
+// firstLink returns the first http or https link in text, without the
+// punctuation a sentence may end it with
+func firstLink(text string) string {
+	link := linkPattern.FindString(text)
+	return strings.TrimRight(link, ".,;:!?)]}'")
+}
+
+// fetchLinkPreview reads the title, description and image of the page at
+// link, from its Open Graph tags or else its title and meta description
+func fetchLinkPreview(ctx context.Context, link string) (*linkPreview, error) {
+	ctx, cancel := context.WithTimeout(ctx, linkPreviewTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/html")
+	resp, err := linkHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/html" {
+		return nil, fmt.Errorf("page is %s, not HTML", mediaType)
+	}
+
+	preview := parseLinkPreview(io.LimitReader(resp.Body, maxLinkPreviewPage))
+	if preview.title == "" {
+		return nil, errors.New("page has no title")
+	}
+	if preview.imageURL != "" {
+		// The image is relative to the page, after any redirects
+		if imageURL, err := resp.Request.URL.Parse(preview.imageURL); err == nil {
+			thumbnail, err := fetchLinkThumbnail(ctx, imageURL)
+			if err != nil {
+				slog.DebugContext(ctx, "Link preview without image", "url", link, "image_url", imageURL.String(), "error", err)
+			}
+			preview.thumbnail = thumbnail
+		}
+	}
+	return preview, nil
+}
+
+// parseLinkPreview reads the head of a page. Open Graph tags win over the
+// title element and the meta description, whichever comes first.
+func parseLinkPreview(page io.Reader) *linkPreview {
+	var title, description, ogTitle, ogDescription, ogImage string
+	tokens := html.NewTokenizer(page)
+head:
+	for {
+		switch tokens.Next() {
+		case html.ErrorToken:
+			break head
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokens.Token()
+			switch token.Data {
+			case "title":
+				if title == "" && tokens.Next() == html.TextToken {
+					title = strings.TrimSpace(string(tokens.Text()))
+				}
+			case "meta":
+				var key, content string
+				for _, attr := range token.Attr {
+					switch attr.Key {
+					case "property", "name":
+						key = strings.ToLower(attr.Val)
+					case "content":
+						content = strings.TrimSpace(attr.Val)
+					}
+				}
+				switch key {
+				case "og:title":
+					ogTitle = firstNonEmpty(ogTitle, content)
+				case "og:description":
+					ogDescription = firstNonEmpty(ogDescription, content)
+				case "og:image":
+					ogImage = firstNonEmpty(ogImage, content)
+				case "description":
+					description = firstNonEmpty(description, content)
+				}
+			case "body":
+				// Everything a preview needs is in the head
+				break head
+			}
+		}
+	}
+	return &linkPreview{
+		title:       shortenPreviewText(firstNonEmpty(ogTitle, title)),
+		description: shortenPreviewText(firstNonEmpty(ogDescription, description)),
+		imageURL:    ogImage,
+	}
+}
+
+// fetchLinkThumbnail fetches a preview image and scales it down for the message
+func fetchLinkThumbnail(ctx context.Context, imageURL *url.URL) ([]byte, error) {
+	if imageURL.Scheme != "http" && imageURL.Scheme != "https" {
+		return nil, errors.New("image is not an http or https URL")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imageURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := linkHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	data, err := readLimited(resp.Body, maxLinkPreviewImage)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return jpegThumbnail(img, thumbnailSize)
+}
+
+// addLinkPreview attaches a preview of the first link in a text message. The
+// message goes out without one when there is no link or the page can't be
+// read, since the preview is only a nicety.
+func addLinkPreview(ctx context.Context, msg *waProto.Message) {
+	text := msg.GetConversation()
+	if text == "" {
+		text = msg.GetExtendedTextMessage().GetText()
+	}
+	link := firstLink(text)
+	if link == "" {
+		return
+	}
+	preview, err := fetchLinkPreview(ctx, link)
+	if err != nil {
+		slog.WarnContext(ctx, "Sending without link preview", "url", link, "error", err)
+		return
+	}
+
+	if msg.ExtendedTextMessage == nil {
+		msg.ExtendedTextMessage = &waProto.ExtendedTextMessage{Text: proto.String(text)}
+		msg.Conversation = nil
+	}
+	extended := msg.ExtendedTextMessage
+	extended.MatchedText = proto.String(link)
+	extended.Title = proto.String(preview.title)
+	if preview.description != "" {
+		extended.Description = proto.String(preview.description)
+	}
+	extended.JPEGThumbnail = preview.thumbnail
+	extended.PreviewType = waProto.ExtendedTextMessage_NONE.Enum()
+}
+
+// shortenPreviewText collapses the whitespace in text and cuts it to
+// maxLinkPreviewText characters
+func shortenPreviewText(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > maxLinkPreviewText {
+		text = string(runes[:maxLinkPreviewText-1]) + "…"
+	}
+	return text
+}
+
+// firstNonEmpty returns the first of values that isn't empty
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,37 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// WhatsAppLogger returns a whatsmeow logger that writes to the default slog
+// logger, tagging each line with the module it came from
+func WhatsAppLogger(module string) waLog.Logger {
+	return waLogger{module: module}
+}
+
+type waLogger struct {
+	module string
+}
+
+func (l waLogger) log(level slog.Level, msg string, args []interface{}) {
+	logger := slog.Default()
+	// Skip formatting the noisy debug output when it would be dropped anyway
+	if !logger.Enabled(context.Background(), level) {
+		return
+	}
+	logger.Log(context.Background(), level, fmt.Sprintf(msg, args...), "module", l.module)
+}
+
+func (l waLogger) Errorf(msg string, args ...interface{}) { l.log(slog.LevelError, msg, args) }
+func (l waLogger) Warnf(msg string, args ...interface{})  { l.log(slog.LevelWarn, msg, args) }
+func (l waLogger) Infof(msg string, args ...interface{})  { l.log(slog.LevelInfo, msg, args) }
+func (l waLogger) Debugf(msg string, args ...interface{}) { l.log(slog.LevelDebug, msg, args) }
+
+func (l waLogger) Sub(module string) waLog.Logger {
+	return waLogger{module: l.module + "/" + module}
+}
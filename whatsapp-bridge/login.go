@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"rsc.io/qr"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/validate"
+)
+
+// qrScale is how many PNG pixels each QR module takes
+const qrScale = 8
+
+// pairClientDisplayName is how the bridge shows up under Linked devices when
+// paired with a code. WhatsApp only accepts common "Browser (OS)" names.
+const pairClientDisplayName = "Chrome (Linux)"
+
+// PairPhoneRequest is the body of POST /v1/auth/pair
+type PairPhoneRequest struct {
+	// Phone is the number of the account to link, with country code
+	Phone string `json:"phone"`
+}
+
+// Pairing QR events sent on GET /v1/auth/qr/stream. The stream ends after
+// anything but a code.
+const (
+	qrEventCode    = "code"
+	qrEventSuccess = "success"
+	qrEventTimeout = "timeout"
+	qrEventError   = "error"
+)
+
+// QRCode is a pairing QR code, or how pairing ended
+type QRCode struct {
+	Event     string    `json:"event"`
+	Code      string    `json:"code,omitempty"`
+	PNG       string    `json:"png_base64,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// loginState holds the QR code the bridge is waiting to have scanned, so the
+// API can show it, and hands every new one to the streams that wait for it
+type loginState struct {
+	mu      sync.Mutex
+	current QRCode
+	subs    map[chan QRCode]struct{}
+	closed  bool
+}
+
+func newLoginState() *loginState {
+	return &loginState{subs: make(map[chan QRCode]struct{})}
+}
+
+// update records an item of whatsmeow's QR channel
+func (ls *loginState) update(item whatsmeow.QRChannelItem) {
+	var code QRCode
+	switch item.Event {
+	case whatsmeow.QRChannelEventCode:
+		png, err := qrPNG(item.Code)
+		if err != nil {
+			slog.Error("Failed to encode pairing QR code", "error", err)
+		}
+		code = QRCode{Event: qrEventCode, Code: item.Code, PNG: png, ExpiresAt: time.Now().Add(item.Timeout)}
+	case whatsmeow.QRChannelSuccess.Event:
+		code = QRCode{Event: qrEventSuccess}
+	case whatsmeow.QRChannelTimeout.Event:
+		code = QRCode{Event: qrEventTimeout, Error: "the QR code was not scanned in time, restart the bridge to pair"}
+	default:
+		msg := item.Event
+		if item.Error != nil {
+			msg = item.Error.Error()
+		}
+		code = QRCode{Event: qrEventError, Error: msg}
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.current = code
+	for ch := range ls.subs {
+		// A stream that hasn't taken the previous code gets the latest instead
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- code
+	}
+}
+
+// Current returns the latest QR code, or how pairing ended. Event is empty
+// while the first code is still being fetched.
+func (ls *loginState) Current() QRCode {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.current
+}
+
+// subscribe returns a channel that gets the current QR code, if there is one,
+// and every one after it. Call the returned function to stop.
+func (ls *loginState) subscribe() (<-chan QRCode, func()) {
+	ch := make(chan QRCode, 1)
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	if ls.current.Event != "" {
+		ch <- ls.current
+	}
+	ls.subs[ch] = struct{}{}
+
+	return ch, func() {
+		ls.mu.Lock()
+		defer ls.mu.Unlock()
+		if _, ok := ls.subs[ch]; ok {
+			delete(ls.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Close ends the streams, when the server shuts down
+func (ls *loginState) Close() {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.closed = true
+	for ch := range ls.subs {
+		delete(ls.subs, ch)
+		close(ch)
+	}
+}
+
+// qrPNG renders a QR code as a base64 PNG
+func qrPNG(text string) (string, error) {
+	code, err := qr.Encode(text, qr.L)
+	if err != nil {
+		return "", err
+	}
+	code.Scale = qrScale
+	return base64.StdEncoding.EncodeToString(code.PNG()), nil
+}
+
+// pairing returns the current QR code if the bridge is waiting to be paired.
+// Otherwise it writes why not and returns false.
+func pairing(w http.ResponseWriter, client *whatsmeow.Client, login *loginState) (QRCode, bool) {
+	if client.Store.ID != nil {
+		apierror.Conflict(w, "Already logged in to WhatsApp")
+		return QRCode{}, false
+	}
+	code := login.Current()
+	switch code.Event {
+	case qrEventCode:
+		return code, true
+	case "":
+		w.Header().Set("Retry-After", "1")
+		apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not ready to pair yet, try again shortly")
+	case qrEventSuccess:
+		apierror.Conflict(w, "Already logged in to WhatsApp")
+	default:
+		apierror.NotFound(w, "The bridge is no longer waiting to be paired: "+code.Error)
+	}
+	return QRCode{}, false
+}
+
+// registerLoginHandlers adds the endpoints for pairing the bridge with a phone
+func registerLoginHandlers(mux *http.ServeMux, client *whatsmeow.Client, login *loginState) {
+	// GET /v1/auth/qr - The QR code to scan, as JSON or, when the client accepts
+	// image/png, the image itself
+	mux.HandleFunc("GET /v1/auth/qr", func(w http.ResponseWriter, r *http.Request) {
+		code, ok := pairing(w, client, login)
+		if !ok {
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		if strings.Contains(r.Header.Get("Accept"), "image/png") {
+			png, err := base64.StdEncoding.DecodeString(code.PNG)
+			if err != nil || len(png) == 0 {
+				apierror.Internal(w, "Failed to render the QR code")
+				return
+			}
+			w.Header().Set("Content-Type", "image/png")
+			w.Header().Set("Expires", code.ExpiresAt.UTC().Format(http.TimeFormat))
+			w.Write(png)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    true,
+			"code":       code.Code,
+			"png_base64": code.PNG,
+			"expires_at": code.ExpiresAt,
+		})
+	})
+
+	// POST /v1/auth/pair - A code to type into the phone instead of scanning the
+	// QR code, for when the bridge runs without a screen
+	mux.HandleFunc("POST /v1/auth/pair", func(w http.ResponseWriter, r *http.Request) {
+		var req PairPhoneRequest
+		var v validate.Validator
+		if v.Decode(r, &req) && v.Required("phone", req.Phone) {
+			req.Phone = v.Phone("phone", req.Phone)
+		}
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		if _, ok := pairing(w, client, login); !ok {
+			return
+		}
+
+		code, err := client.PairPhone(r.Context(), req.Phone, true, whatsmeow.PairClientChrome, pairClientDisplayName)
+		if errors.Is(err, whatsmeow.ErrPhoneNumberTooShort) || errors.Is(err, whatsmeow.ErrPhoneNumberIsNotInternational) {
+			v.Add("phone", validate.CodeInvalidFormat, err.Error())
+			v.Write(w)
+			return
+		}
+		if err != nil {
+			slog.WarnContext(r.Context(), "Failed to request pairing code", "error", err)
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, fmt.Sprintf("Failed to request pairing code: %v", err))
+			return
+		}
+		slog.InfoContext(r.Context(), "Requested pairing code, enter it on the phone to log in")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"code":    code,
+		})
+	})
+
+	// GET /v1/auth/qr/stream - Every new QR code as a Server-Sent Event, until the
+	// phone is paired or pairing fails
+	mux.HandleFunc("GET /v1/auth/qr/stream", func(w http.ResponseWriter, r *http.Request) {
+		if client.Store.ID != nil {
+			apierror.Conflict(w, "Already logged in to WhatsApp")
+			return
+		}
+
+		rc := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		if err := rc.Flush(); err != nil {
+			slog.WarnContext(r.Context(), "QR code stream can't be flushed", "error", err)
+			return
+		}
+
+		codes, stop := login.subscribe()
+		defer stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case code, ok := <-codes:
+				if !ok {
+					return
+				}
+				data, err := json.Marshal(code)
+				if err != nil {
+					slog.ErrorContext(r.Context(), "Error encoding QR code", "error", err)
+					return
+				}
+				if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", code.Event, data); err != nil {
+					return
+				}
+				if err := rc.Flush(); err != nil || code.Event != qrEventCode {
+					return
+				}
+			}
+		}
+	})
+}
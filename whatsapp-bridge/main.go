@@ -5,15 +5,20 @@ import (
 	"database/sql"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
 	"math"
 	"math/rand"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -24,13 +29,25 @@ import (
 
 	"go.mau.fi/whatsmeow"
 	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/proto/waHistorySync"
 	"go.mau.fi/whatsmeow/store/sqlstore"
 	"go.mau.fi/whatsmeow/types"
 	"go.mau.fi/whatsmeow/types/events"
 	waLog "go.mau.fi/whatsmeow/util/log"
 	"google.golang.org/protobuf/proto"
-	
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/audit"
+	"whatsapp-client/config"
+	bridgeevents "whatsapp-client/events"
+	"whatsapp-client/jobs"
+	"whatsapp-client/logging"
+	"whatsapp-client/middleware"
 	"whatsapp-client/scheduler"
+	"whatsapp-client/sqlitedb"
+	"whatsapp-client/tracing"
+	"whatsapp-client/validate"
+	"whatsapp-client/webhook"
 )
 
 // Message represents a chat message for our client
@@ -46,17 +63,26 @@ type Message struct {
 // Database handler for storing message history
 type MessageStore struct {
 	db *sql.DB
+
+	// Downloaded media, see SetMediaStorage
+	mediaDir    string
+	mediaPolicy MediaPolicy
+	mediaMu     sync.Mutex
+
+	// fts is whether messages are indexed for full-text search, see
+	// setupMessageSearch
+	fts bool
 }
 
 // Initialize message store
-func NewMessageStore() (*MessageStore, error) {
+func NewMessageStore(path string, opts sqlitedb.Options) (*MessageStore, error) {
 	// Create directory for database if it doesn't exist
-	if err := os.MkdirAll("store", 0755); err != nil {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return nil, fmt.Errorf("failed to create store directory: %v", err)
 	}
 
 	// Open SQLite database for messages
-	db, err := sql.Open("sqlite3", "file:store/messages.db?_foreign_keys=on")
+	db, err := sqlitedb.Open(path, opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open message database: %v", err)
 	}
@@ -66,7 +92,8 @@ func NewMessageStore() (*MessageStore, error) {
 		CREATE TABLE IF NOT EXISTS chats (
 			jid TEXT PRIMARY KEY,
 			name TEXT,
-			last_message_time TIMESTAMP
+			last_message_time TIMESTAMP,
+			type TEXT
 		);
 		
 		CREATE TABLE IF NOT EXISTS messages (
@@ -86,13 +113,276 @@ func NewMessageStore() (*MessageStore, error) {
 			PRIMARY KEY (id, chat_jid),
 			FOREIGN KEY (chat_jid) REFERENCES chats(jid)
 		);
+
+		-- Used by the scheduler to find the latest incoming message per chat
+		CREATE INDEX IF NOT EXISTS idx_messages_chat_timestamp ON messages(chat_jid, is_from_me, timestamp);
+
+		-- Used to page through a chat's history, and to find its latest message
+		CREATE INDEX IF NOT EXISTS idx_messages_chat_history ON messages(chat_jid, timestamp, id);
+
+		-- Contact cards shared in messages, in the order they were sent
+		CREATE TABLE IF NOT EXISTS message_contacts (
+			message_id TEXT,
+			chat_jid TEXT,
+			position INTEGER,
+			name TEXT,
+			phone TEXT,
+			whatsapp_jid TEXT,
+			vcard TEXT,
+			PRIMARY KEY (message_id, chat_jid, position)
+		);
+
+		-- The current reaction of each person to a message
+		CREATE TABLE IF NOT EXISTS message_reactions (
+			message_id TEXT,
+			chat_jid TEXT,
+			sender TEXT,
+			emoji TEXT,
+			timestamp TIMESTAMP,
+			PRIMARY KEY (message_id, chat_jid, sender)
+		);
+
+		-- Polls sent or received, with their options as a JSON array
+		CREATE TABLE IF NOT EXISTS polls (
+			message_id TEXT,
+			chat_jid TEXT,
+			sender TEXT,
+			question TEXT,
+			options TEXT,
+			selectable_count INTEGER,
+			created_at TIMESTAMP,
+			PRIMARY KEY (message_id, chat_jid)
+		);
+
+		-- The latest vote of each voter, as a JSON array of SHA-256 option hashes
+		CREATE TABLE IF NOT EXISTS poll_votes (
+			poll_id TEXT,
+			chat_jid TEXT,
+			voter TEXT,
+			selected TEXT,
+			voted_at TIMESTAMP,
+			PRIMARY KEY (poll_id, chat_jid, voter)
+		);
+
+		-- The text each edit of a message replaced
+		CREATE TABLE IF NOT EXISTS message_edits (
+			message_id TEXT,
+			chat_jid TEXT,
+			previous_content TEXT,
+			edited_at TIMESTAMP,
+			PRIMARY KEY (message_id, chat_jid, edited_at)
+		);
+
+		-- Messages deleted for everyone, and by whom
+		CREATE TABLE IF NOT EXISTS message_revocations (
+			message_id TEXT,
+			chat_jid TEXT,
+			revoked_by TEXT,
+			revoked_at TIMESTAMP,
+			PRIMARY KEY (message_id, chat_jid)
+		);
+
+		-- Everything done to messages after they were sent, as it arrived:
+		-- edits, deletions for everyone and reactions
+		CREATE TABLE IF NOT EXISTS message_events (
+			message_id TEXT,
+			chat_jid TEXT,
+			type TEXT,
+			sender TEXT,
+			content TEXT,
+			timestamp TIMESTAMP,
+			PRIMARY KEY (message_id, chat_jid, type, sender, timestamp)
+		);
+
+		-- The disappearing-message timer of each chat, in seconds, 0 when off
+		CREATE TABLE IF NOT EXISTS chat_disappearing (
+			chat_jid TEXT PRIMARY KEY,
+			timer INTEGER,
+			set_at TIMESTAMP
+		);
+
+		-- Whether each chat is muted, until muted_until or, when it is NULL,
+		-- until unmuted
+		CREATE TABLE IF NOT EXISTS chat_mutes (
+			chat_jid TEXT PRIMARY KEY,
+			muted BOOLEAN,
+			muted_until TIMESTAMP,
+			set_at TIMESTAMP
+		);
+
+		-- Whether each chat is archived
+		CREATE TABLE IF NOT EXISTS chat_archives (
+			chat_jid TEXT PRIMARY KEY,
+			archived BOOLEAN,
+			set_at TIMESTAMP
+		);
+
+		-- Photos and videos sent to be viewed once
+		CREATE TABLE IF NOT EXISTS view_once_messages (
+			message_id TEXT,
+			chat_jid TEXT,
+			PRIMARY KEY (message_id, chat_jid)
+		);
+
+		-- Messages others sent that the account has read, here or on another device
+		CREATE TABLE IF NOT EXISTS message_reads (
+			message_id TEXT,
+			chat_jid TEXT,
+			read_at TIMESTAMP,
+			PRIMARY KEY (message_id, chat_jid)
+		);
+
+		-- When each recipient of a message the account sent got, read and played it
+		CREATE TABLE IF NOT EXISTS message_receipts (
+			message_id TEXT,
+			chat_jid TEXT,
+			participant TEXT,
+			delivered_at TIMESTAMP,
+			read_at TIMESTAMP,
+			played_at TIMESTAMP,
+			PRIMARY KEY (message_id, chat_jid, participant)
+		);
+
+		-- The latest pin or unpin of each message, and when a pin runs out
+		CREATE TABLE IF NOT EXISTS message_pins (
+			message_id TEXT,
+			chat_jid TEXT,
+			pinned_by TEXT,
+			pinned_at TIMESTAMP,
+			expires_at TIMESTAMP,
+			unpinned BOOLEAN,
+			PRIMARY KEY (message_id, chat_jid)
+		);
+
+		-- Messages the account starred, on any of its devices
+		CREATE TABLE IF NOT EXISTS starred_messages (
+			message_id TEXT,
+			chat_jid TEXT,
+			starred_at TIMESTAMP,
+			PRIMARY KEY (message_id, chat_jid)
+		);
+
+		-- Broadcast lists and their recipients, as a JSON array of JIDs
+		CREATE TABLE IF NOT EXISTS broadcast_lists (
+			jid TEXT PRIMARY KEY,
+			recipients TEXT,
+			updated_at TIMESTAMP
+		);
+
+		-- Each recipient's copy of a message sent to a broadcast list
+		CREATE TABLE IF NOT EXISTS broadcast_deliveries (
+			broadcast_id TEXT,
+			list_jid TEXT,
+			recipient TEXT,
+			message_id TEXT,
+			error TEXT,
+			sent_at TIMESTAMP,
+			PRIMARY KEY (broadcast_id, recipient)
+		);
+
+		-- The server ID of each stored channel post, and its view and reaction
+		-- counts (a JSON object of emoji to count) when it was last fetched
+		CREATE TABLE IF NOT EXISTS newsletter_posts (
+			message_id TEXT,
+			chat_jid TEXT,
+			server_id INTEGER,
+			views INTEGER,
+			reactions TEXT,
+			fetched_at TIMESTAMP,
+			PRIMARY KEY (message_id, chat_jid)
+		);
+
+		-- Groups the account is in, its role in each and their settings, as of
+		-- the last sync with WhatsApp
+		CREATE TABLE IF NOT EXISTS group_metadata (
+			jid TEXT PRIMARY KEY,
+			name TEXT,
+			topic TEXT,
+			participant_count INTEGER,
+			is_admin BOOLEAN,
+			is_super_admin BOOLEAN,
+			announce BOOLEAN,
+			locked BOOLEAN,
+			join_approval BOOLEAN,
+			created_at TIMESTAMP,
+			synced_at TIMESTAMP
+		);
+
+		-- Invites to join groups received as messages, and when the bridge
+		-- accepted them
+		CREATE TABLE IF NOT EXISTS group_invites (
+			message_id TEXT,
+			chat_jid TEXT,
+			inviter TEXT,
+			group_jid TEXT,
+			group_name TEXT,
+			code TEXT,
+			caption TEXT,
+			expires_at TIMESTAMP,
+			timestamp TIMESTAMP,
+			accepted_at TIMESTAMP,
+			PRIMARY KEY (message_id, chat_jid)
+		);
+
+		-- When members the bridge saw join a group joined it
+		CREATE TABLE IF NOT EXISTS group_joins (
+			chat_jid TEXT,
+			participant TEXT,
+			joined_at TIMESTAMP,
+			PRIMARY KEY (chat_jid, participant)
+		);
+
+		-- whatsmeow's contact store, copied for listing and searching
+		CREATE TABLE IF NOT EXISTS contacts (
+			jid TEXT PRIMARY KEY,
+			phone_number TEXT,
+			name TEXT,
+			full_name TEXT,
+			first_name TEXT,
+			push_name TEXT,
+			business_name TEXT,
+			is_business BOOLEAN,
+			synced_at TIMESTAMP
+		);
+
+		-- Where the media of each downloaded message was saved, and its size in
+		-- bytes. A file deleted to keep within the media policy has no path.
+		CREATE TABLE IF NOT EXISTS media_files (
+			message_id TEXT,
+			chat_jid TEXT,
+			path TEXT,
+			size INTEGER,
+			downloaded_at TIMESTAMP,
+			removed_at TIMESTAMP,
+			PRIMARY KEY (message_id, chat_jid)
+		);
 	`)
 	if err != nil {
 		db.Close()
 		return nil, fmt.Errorf("failed to create tables: %v", err)
 	}
+	if err := migrateChats(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to add chat types: %v", err)
+	}
+	if err := migrateMediaFiles(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate media files: %v", err)
+	}
+	if err := migrateReads(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate read state: %v", err)
+	}
+	fts, err := setupMessageSearch(db)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to set up message search: %v", err)
+	}
+	if !fts {
+		slog.Warn("SQLite was built without FTS5, message search falls back to slow substring matching; build with -tags sqlite_fts5")
+	}
 
-	return &MessageStore{db: db}, nil
+	return &MessageStore{db: db, mediaDir: "store", fts: fts}, nil
 }
 
 // Close the database connection
@@ -102,9 +392,13 @@ func (store *MessageStore) Close() error {
 
 // Store a chat in the database
 func (store *MessageStore) StoreChat(jid, name string, lastMessageTime time.Time) error {
+	// Older history, like a backfill's, doesn't move the chat's last activity back
 	_, err := store.db.Exec(
-		"INSERT OR REPLACE INTO chats (jid, name, last_message_time) VALUES (?, ?, ?)",
-		jid, name, lastMessageTime,
+		`INSERT INTO chats (jid, name, last_message_time, type) VALUES (?, ?, ?, ?)
+		ON CONFLICT (jid) DO UPDATE SET name = excluded.name, type = excluded.type,
+			last_message_time = CASE WHEN chats.last_message_time IS NULL OR excluded.last_message_time > chats.last_message_time
+				THEN excluded.last_message_time ELSE chats.last_message_time END`,
+		jid, name, lastMessageTime, chatType(jid),
 	)
 	return err
 }
@@ -123,7 +417,10 @@ func (store *MessageStore) StoreMessage(id, chatJID, sender, content string, tim
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		id, chatJID, sender, content, timestamp, isFromMe, mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	return store.reapplyEvents(id, chatJID)
 }
 
 // Get messages from a chat
@@ -152,6 +449,20 @@ func (store *MessageStore) GetMessages(chatJID string, limit int) ([]Message, er
 	return messages, nil
 }
 
+// GetMessage returns one stored message, or sql.ErrNoRows when the bridge
+// doesn't have it
+func (store *MessageStore) GetMessage(id, chatJID string) (*Message, error) {
+	var msg Message
+	err := store.db.QueryRow(
+		"SELECT sender, content, timestamp, is_from_me, media_type, filename FROM messages WHERE id = ? AND chat_jid = ?",
+		id, chatJID,
+	).Scan(&msg.Sender, &msg.Content, &msg.Time, &msg.IsFromMe, &msg.MediaType, &msg.Filename)
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
 // Get all chats
 func (store *MessageStore) GetChats() (map[string]time.Time, error) {
 	rows, err := store.db.Query("SELECT jid, last_message_time FROM chats ORDER BY last_message_time DESC")
@@ -185,16 +496,26 @@ func extractTextContent(msg *waProto.Message) string {
 		return text
 	} else if extendedText := msg.GetExtendedTextMessage(); extendedText != nil {
 		return extendedText.GetText()
+	} else if contacts := extractContacts(msg); len(contacts) > 0 {
+		return contactsSummary(contacts)
+	} else if poll := pollCreation(msg); poll != nil {
+		return "Poll: " + poll.GetName()
+	} else if product := msg.GetProductMessage(); product != nil {
+		return productSummary(product)
+	} else if invite := msg.GetGroupInviteMessage(); invite != nil {
+		return inviteSummary(invite)
 	}
 
-	// For now, we're ignoring non-text messages
+	// Media is stored by extractMediaInfo, other messages are ignored
 	return ""
 }
 
 // SendMessageResponse represents the response for the send message API
 type SendMessageResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
+	Success   bool   `json:"success"`
+	Message   string `json:"message"`
+	MessageID string `json:"message_id,omitempty"`
+	ChatJID   string `json:"chat_jid,omitempty"`
 }
 
 // SendMessageRequest represents the request body for the send message API
@@ -202,43 +523,78 @@ type SendMessageRequest struct {
 	Recipient string `json:"recipient"`
 	Message   string `json:"message"`
 	MediaPath string `json:"media_path,omitempty"`
+	scheduler.SendOptions
 }
 
-// Function to send a WhatsApp message
-func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message string, mediaPath string) (bool, string) {
-	if !client.IsConnected() {
-		return false, "Not connected to WhatsApp"
+// validate checks every field of the request and normalizes the recipient
+func (req *SendMessageRequest) validate(v *validate.Validator) {
+	req.Recipient = v.Recipient("recipient", req.Recipient)
+	req.SendOptions.Validate(v, req.Recipient)
+	// With media, the message is the optional caption
+	if req.Message == "" && req.MediaPath == "" {
+		v.Add("message", validate.CodeRequired, "is required unless media_path is set")
+	}
+	v.MaxLength("message", req.Message, validate.MaxMessageLength)
+	if req.MediaPath != "" {
+		req.ValidateNotText(v, "media messages")
+	}
+	if scheduler.IsStatus(req.Recipient) {
+		if req.MediaPath != "" {
+			v.Add("media_path", validate.CodeInvalidValue, "must be empty for status updates; post photos and videos with POST /v1/status or /v1/send/{image,video}")
+		}
+		v.MaxLength("message", req.Message, scheduler.MaxStatusLength)
 	}
+	req.ValidateViewOnce(v, "")
+	req.Message = req.MentionText(req.Message)
+}
 
-	// Create JID for recipient
-	var recipientJID types.JID
-	var err error
+// Function to send a WhatsApp message, returning the sent message ID on success
+func sendWhatsAppMessage(ctx context.Context, client *whatsmeow.Client, store *MessageStore, recipient string, message string, mediaPath string, opts scheduler.SendOptions, contextInfo *waProto.ContextInfo) (result scheduler.SendResult) {
+	ctx, span := tracing.Start(ctx, "whatsapp.send",
+		tracing.String("recipient", recipient),
+		tracing.Bool("media", mediaPath != ""),
+	)
+	defer func() {
+		if result.Success {
+			span.SetAttributes(tracing.String("whatsapp.message_id", result.MessageID))
+		} else {
+			span.RecordError(errors.New(result.Message))
+		}
+		span.End()
+	}()
 
-	// Check if recipient is a JID
-	isJID := strings.Contains(recipient, "@")
+	if !client.IsConnected() {
+		return scheduler.SendResult{Message: "Not connected to WhatsApp"}
+	}
+	if scheduler.IsBroadcastList(recipient) {
+		return sendToBroadcast(ctx, client, store, recipient, func(ctx context.Context, to string, info *waProto.ContextInfo) scheduler.SendResult {
+			return sendWhatsAppMessage(ctx, client, store, to, message, mediaPath, opts, info)
+		})
+	}
 
-	if isJID {
-		// Parse the JID string
-		recipientJID, err = types.ParseJID(recipient)
-		if err != nil {
-			return false, fmt.Sprintf("Error parsing JID: %v", err)
-		}
-	} else {
-		// Create JID from phone number
-		recipientJID = types.JID{
-			User:   recipient,
-			Server: "s.whatsapp.net", // For personal chats
+	if scheduler.IsStatus(recipient) {
+		if err := checkStatusAudience(ctx, client, opts.Audience); err != nil {
+			return scheduler.SendResult{Message: err.Error()}
 		}
 	}
 
+	// Create JID for recipient
+	recipientJID, err := parseRecipient(recipient)
+	if err != nil {
+		return scheduler.SendResult{Message: err.Error()}
+	}
+
 	msg := &waProto.Message{}
+	var extra []whatsmeow.SendRequestExtra
 
 	// Check if we have media to send
-	if mediaPath != "" {
+	if scheduler.IsStatus(recipient) && mediaPath == "" {
+		msg = textStatusMessage(ctx, message, opts)
+	} else if mediaPath != "" {
 		// Read media file
 		mediaData, err := os.ReadFile(mediaPath)
 		if err != nil {
-			return false, fmt.Sprintf("Error reading media file: %v", err)
+			return scheduler.SendResult{Message: fmt.Sprintf("Error reading media file: %v", err)}
 		}
 
 		// Determine media type and mime type based on file extension
@@ -285,12 +641,13 @@ func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message str
 		}
 
 		// Upload media to WhatsApp servers
-		resp, err := client.Upload(context.Background(), mediaData, mediaType)
+		var resp whatsmeow.UploadResponse
+		resp, extra, err = uploadFor(ctx, client, recipientJID, mediaData, mediaType)
 		if err != nil {
-			return false, fmt.Sprintf("Error uploading media: %v", err)
+			return scheduler.SendResult{Message: fmt.Sprintf("Error uploading media: %v", err)}
 		}
 
-		fmt.Println("Media uploaded", resp)
+		slog.DebugContext(ctx, "Media uploaded", "media_path", mediaPath, "size", resp.FileLength)
 
 		// Create the appropriate message type based on media type
 		switch mediaType {
@@ -317,10 +674,10 @@ func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message str
 					seconds = analyzedSeconds
 					waveform = analyzedWaveform
 				} else {
-					return false, fmt.Sprintf("Failed to analyze Ogg Opus file: %v", err)
+					return scheduler.SendResult{Message: fmt.Sprintf("Failed to analyze Ogg Opus file: %v", err)}
 				}
 			} else {
-				fmt.Printf("Not an Ogg Opus file: %s\n", mimeType)
+				slog.WarnContext(ctx, "Not an Ogg Opus file", "media_path", mediaPath, "mime_type", mimeType)
 			}
 
 			msg.AudioMessage = &waProto.AudioMessage{
@@ -361,16 +718,28 @@ func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message str
 		}
 	} else {
 		msg.Conversation = proto.String(message)
+		if opts.LinkPreview {
+			addLinkPreview(ctx, msg)
+		}
 	}
+	setContextInfo(msg, contextInfo)
 
 	// Send message
-	_, err = client.SendMessage(context.Background(), recipientJID, msg)
+	resp, err := client.SendMessage(ctx, recipientJID, msg, extra...)
 
 	if err != nil {
-		return false, fmt.Sprintf("Error sending message: %v", err)
+		return scheduler.SendResult{Message: fmt.Sprintf("Error sending message: %v", err)}
 	}
 
-	return true, fmt.Sprintf("Message sent to %s", recipient)
+	result = scheduler.SendResult{
+		Success:   true,
+		Message:   fmt.Sprintf("Message sent to %s", recipient),
+		MessageID: resp.ID,
+		ChatJID:   recipientJID.String(),
+		Timestamp: resp.Timestamp,
+	}
+	storeSent(ctx, client, store, result, msg)
+	return result
 }
 
 // Extract media info from a message
@@ -411,13 +780,38 @@ func extractMediaInfo(msg *waProto.Message) (mediaType string, filename string,
 }
 
 // Handle regular incoming messages with media support
-func handleMessage(client *whatsmeow.Client, messageStore *MessageStore, msg *events.Message, logger waLog.Logger) {
+func handleMessage(client *whatsmeow.Client, messageStore *MessageStore, autoDownload *mediaAutoDownloader, bus *bridgeevents.Bus, msg *events.Message, logger waLog.Logger) {
 	// Save message to database
 	chatJID := msg.Info.Chat.String()
 	sender := msg.Info.Sender.User
 
-	// Get appropriate chat name (pass nil for conversation since we don't have one for regular messages)
 	ctx := context.Background()
+	if msg.Message.GetPollUpdateMessage() != nil {
+		handlePollVote(ctx, client, messageStore, bus, msg)
+		return
+	}
+	if msg.Message.GetReactionMessage() != nil {
+		handleReaction(messageStore, bus, msg)
+		return
+	}
+	if isEdit(msg.Message) {
+		handleEdit(messageStore, bus, msg)
+		return
+	}
+	if isRevoke(msg.Message) {
+		handleRevoke(client, messageStore, bus, msg)
+		return
+	}
+	if isDisappearingSetting(msg.Message) {
+		handleDisappearingSetting(messageStore, msg)
+		return
+	}
+	if msg.Message.GetPinInChatMessage() != nil {
+		handlePin(messageStore, bus, msg)
+		return
+	}
+
+	// Get appropriate chat name (pass nil for conversation since we don't have one for regular messages)
 	name := GetChatName(ctx, client, messageStore, msg.Info.Chat, chatJID, nil, sender, logger)
 
 	// Update chat in database with the message timestamp (keeps last message time updated)
@@ -425,6 +819,9 @@ func handleMessage(client *whatsmeow.Client, messageStore *MessageStore, msg *ev
 	if err != nil {
 		logger.Warnf("Failed to store chat: %v", err)
 	}
+	if len(msg.Info.BroadcastRecipients) > 0 && scheduler.IsBroadcastList(chatJID) {
+		handleBroadcastRecipients(messageStore, msg)
+	}
 
 	// Extract text content
 	content := extractTextContent(msg.Message)
@@ -457,20 +854,60 @@ func handleMessage(client *whatsmeow.Client, messageStore *MessageStore, msg *ev
 	if err != nil {
 		logger.Warnf("Failed to store message: %v", err)
 	} else {
-		// Log message reception
-		timestamp := msg.Info.Timestamp.Format("2006-01-02 15:04:05")
-		direction := "←"
-		if msg.Info.IsFromMe {
-			direction = "→"
+		storeMessageContacts(messageStore, msg.Info.ID, chatJID, msg.Message)
+		storeMessagePoll(messageStore, msg.Info.ID, chatJID, sender, msg.Info.Timestamp, msg.Message)
+		storeMessageInvite(messageStore, msg.Info.ID, chatJID, msg.Info.Sender.ToNonAD().String(), msg.Info.Timestamp, msg.Message)
+		if msg.IsViewOnce {
+			if err := messageStore.StoreViewOnce(msg.Info.ID, chatJID); err != nil {
+				logger.Warnf("Failed to flag view-once message: %v", err)
+			}
 		}
-
-		// Log based on message type
 		if mediaType != "" {
-			fmt.Printf("[%s] %s %s: [%s: %s] %s\n", timestamp, direction, sender, mediaType, filename, content)
-		} else if content != "" {
-			fmt.Printf("[%s] %s %s: %s\n", timestamp, direction, sender, content)
+			autoDownload.consider(msg.Info.ID, chatJID, mediaType, fileLength, msg.IsViewOnce)
 		}
+
+		// Message contents are only logged at debug level
+		slog.Debug("Stored message",
+			"message_id", msg.Info.ID,
+			"chat_jid", chatJID,
+			"sender", sender,
+			"from_me", msg.Info.IsFromMe,
+			"media_type", mediaType,
+			"content", content,
+		)
+	}
+
+	event := map[string]interface{}{
+		"id":          msg.Info.ID,
+		"chat_jid":    chatJID,
+		"chat_name":   name,
+		"sender":      sender,
+		"content":     content,
+		"timestamp":   msg.Info.Timestamp,
+		"is_from_me":  msg.Info.IsFromMe,
+		"media_type":  mediaType,
+		"filename":    filename,
+		"file_length": fileLength,
+		"push_name":   msg.Info.PushName,
+	}
+	if msg.IsViewOnce {
+		event["view_once"] = true
+	}
+	if contacts := extractContacts(msg.Message); len(contacts) > 0 {
+		event["contacts"] = contacts
+	}
+	if invite := groupInvite(msg.Message, msg.Info.ID, chatJID, msg.Info.Sender.ToNonAD().String(), msg.Info.Timestamp); invite != nil {
+		event["group_invite"] = invite
+	}
+	bus.Publish(bridgeevents.TypeMessage, event)
+}
+
+// receiptTypeName names a receipt type; WhatsApp leaves plain delivery receipts untyped
+func receiptTypeName(t types.ReceiptType) string {
+	if t == types.ReceiptTypeDelivered {
+		return "delivered"
 	}
+	return string(t)
 }
 
 // DownloadMediaRequest represents the request body for the download media API
@@ -557,6 +994,19 @@ func (d *MediaDownloader) GetMediaType() whatsmeow.MediaType {
 }
 
 // Function to download media from a message
+// Errors reported when the bridge can't reach WhatsApp
+var (
+	errNotConnected = errors.New("not connected to WhatsApp")
+	errNotLoggedIn  = errors.New("not logged in to WhatsApp, scan the QR code")
+)
+
+// Errors from downloadMedia that the API reports with their own error code
+var (
+	errMediaMessageNotFound = errors.New("failed to find message")
+	errNotMediaMessage      = errors.New("not a media message")
+	errMediaDownloadFailed  = errors.New("failed to download media")
+)
+
 func downloadMedia(client *whatsmeow.Client, messageStore *MessageStore, messageID, chatJID string) (bool, string, string, string, error) {
 	// Query the database for the message
 	var mediaType, filename, url string
@@ -564,8 +1014,6 @@ func downloadMedia(client *whatsmeow.Client, messageStore *MessageStore, message
 	var fileLength uint64
 	var err error
 
-	// First, check if we already have this file
-	chatDir := fmt.Sprintf("store/%s", strings.ReplaceAll(chatJID, ":", "_"))
 	localPath := ""
 
 	// Get media info from the database
@@ -579,22 +1027,31 @@ func downloadMedia(client *whatsmeow.Client, messageStore *MessageStore, message
 		).Scan(&mediaType, &filename)
 
 		if err != nil {
-			return false, "", "", "", fmt.Errorf("failed to find message: %v", err)
+			return false, "", "", "", fmt.Errorf("%w: %v", errMediaMessageNotFound, err)
 		}
 	}
 
 	// Check if this is a media message
 	if mediaType == "" {
-		return false, "", "", "", fmt.Errorf("not a media message")
+		return false, "", "", "", errNotMediaMessage
+	}
+
+	// Serve the file downloaded before, unless the quota made room by deleting it
+	if path, err := messageStore.GetMediaFile(messageID, chatJID); err == nil {
+		if _, err := os.Stat(path); err == nil {
+			return true, mediaType, filename, path, nil
+		}
 	}
 
 	// Create directory for the chat if it doesn't exist
+	chatDir := messageStore.mediaChatDir(chatJID)
 	if err := os.MkdirAll(chatDir, 0755); err != nil {
 		return false, "", "", "", fmt.Errorf("failed to create chat directory: %v", err)
 	}
 
-	// Generate a local path for the file
-	localPath = fmt.Sprintf("%s/%s", chatDir, filename)
+	// Generate a local path for the file, named after the message rather
+	// than the sender's file name
+	localPath = filepath.Join(chatDir, mediaFileName(messageID, filename))
 
 	// Get absolute path
 	absPath, err := filepath.Abs(localPath)
@@ -602,18 +1059,12 @@ func downloadMedia(client *whatsmeow.Client, messageStore *MessageStore, message
 		return false, "", "", "", fmt.Errorf("failed to get absolute path: %v", err)
 	}
 
-	// Check if file already exists
-	if _, err := os.Stat(localPath); err == nil {
-		// File exists, return it
-		return true, mediaType, filename, absPath, nil
-	}
-
 	// If we don't have all the media info we need, we can't download
 	if url == "" || len(mediaKey) == 0 || len(fileSHA256) == 0 || len(fileEncSHA256) == 0 || fileLength == 0 {
 		return false, "", "", "", fmt.Errorf("incomplete media information for download")
 	}
 
-	fmt.Printf("Attempting to download media for message %s in chat %s...\n", messageID, chatJID)
+	slog.Info("Downloading media", "message_id", messageID, "chat_jid", chatJID, "media_type", mediaType)
 
 	// Extract direct path from URL
 	directPath := extractDirectPathFromURL(url)
@@ -647,15 +1098,16 @@ func downloadMedia(client *whatsmeow.Client, messageStore *MessageStore, message
 	ctx := context.Background()
 	mediaData, err := client.Download(ctx, downloader)
 	if err != nil {
-		return false, "", "", "", fmt.Errorf("failed to download media: %v", err)
+		return false, "", "", "", fmt.Errorf("%w: %v", errMediaDownloadFailed, err)
 	}
 
 	// Save the downloaded media to file
 	if err := os.WriteFile(localPath, mediaData, 0644); err != nil {
 		return false, "", "", "", fmt.Errorf("failed to save media file: %v", err)
 	}
+	messageStore.recordMediaFile(messageID, chatJID, absPath, int64(len(mediaData)))
 
-	fmt.Printf("Successfully downloaded %s media to %s (%d bytes)\n", mediaType, absPath, len(mediaData))
+	slog.Info("Downloaded media", "message_id", messageID, "media_type", mediaType, "path", absPath, "size", len(mediaData))
 	return true, mediaType, filename, absPath, nil
 }
 
@@ -679,100 +1131,232 @@ func extractDirectPathFromURL(url string) string {
 	return "/" + pathPart
 }
 
+// downloadFailure returns the status, error code and message for a failed
+// media download
+func downloadFailure(err error) (int, string, string) {
+	errMsg := "Unknown error"
+	if err != nil {
+		errMsg = err.Error()
+	}
+	message := fmt.Sprintf("Failed to download media: %s", errMsg)
+
+	switch {
+	case errors.Is(err, errMediaMessageNotFound):
+		return http.StatusNotFound, apierror.CodeNotFound, "Message not found"
+	case errors.Is(err, errNotMediaMessage):
+		return http.StatusBadRequest, apierror.CodeInvalidRequest, "Message has no media"
+	case errors.Is(err, errMediaDownloadFailed):
+		return http.StatusBadGateway, apierror.CodeWhatsAppError, message
+	default:
+		return http.StatusInternalServerError, apierror.CodeInternal, message
+	}
+}
+
 // Start a REST API server to expose the WhatsApp client functionality
-func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, msgScheduler *scheduler.MessageScheduler, port int) {
-	// Setup scheduler endpoints
-	scheduler.SetupHandlers(msgScheduler)
-	
-	// Handler for sending messages
-	http.HandleFunc("/api/send", func(w http.ResponseWriter, r *http.Request) {
-		// Only allow POST requests
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, msgScheduler *scheduler.MessageScheduler, bus *bridgeevents.Bus, webhookStore *webhook.Store, auditStore *audit.Store, jobManager *jobs.Manager, login *loginState, settings *reloader) (*http.Server, error) {
+	cfg := settings.Config()
+	apiConfig := cfg.API
+	mux := http.NewServeMux()
+
+	// Liveness and readiness probes
+	registerHealthHandlers(mux, client, messageStore, msgScheduler)
+
+	// Pairing a new device
+	registerLoginHandlers(mux, client, login)
+
+	// Scheduler endpoints
+	schedulerHandler := scheduler.SetupHandlers(msgScheduler, jobManager)
+	mux.Handle("/v1/schedule", schedulerHandler)
+	mux.Handle("/v1/scheduled", schedulerHandler)
+	mux.Handle("/v1/scheduled/", schedulerHandler)
+	mux.Handle("/v1/scheduler/", schedulerHandler)
+	mux.Handle("/v1/batch", schedulerHandler)
+
+	// Dead-lettered webhook deliveries
+	mux.Handle("/v1/webhooks/", webhook.SetupHandlers(webhookStore))
+
+	// Who changed what
+	mux.Handle("GET /v1/audit", audit.SetupHandlers(auditStore))
+
+	// Background jobs started with Prefer: respond-async
+	jobsHandler := jobs.SetupHandlers(jobManager)
+	mux.Handle("/v1/jobs", jobsHandler)
+	mux.Handle("/v1/jobs/", jobsHandler)
+
+	// Effective settings, with API keys and other secrets masked
+	mux.HandleFunc("GET /v1/config", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"config":  settings.Config().Redacted(),
+		})
+	})
+
+	// Re-read the configuration and apply what can change without a restart
+	mux.HandleFunc("POST /v1/admin/reload", func(w http.ResponseWriter, r *http.Request) {
+		result, err := settings.Reload()
+		if err != nil {
+			slog.WarnContext(r.Context(), "Configuration reload failed", "error", err)
+			apierror.BadRequest(w, "Invalid configuration, nothing was changed: "+err.Error())
 			return
 		}
 
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":          true,
+			"applied":          result.Applied,
+			"restart_required": result.RestartRequired,
+		})
+	})
+
+	// Live stream of incoming messages, receipts, presence, edits, revokes, reactions, pins, poll votes and scheduler status changes
+	mux.Handle("GET /v1/events", bridgeevents.Handler(bus))
+
+	// Handler for sending messages
+	mux.HandleFunc("POST /v1/send", func(w http.ResponseWriter, r *http.Request) {
 		// Parse the request body
 		var req SendMessageRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request format", http.StatusBadRequest)
+		var v validate.Validator
+		if v.Decode(r, &req) {
+			req.validate(&v)
+		}
+		if !v.Valid() {
+			v.Write(w)
 			return
 		}
-
-		// Validate request
-		if req.Recipient == "" {
-			http.Error(w, "Recipient is required", http.StatusBadRequest)
+		contextInfo, ok := requestContext(w, r, messageStore, client, req.Recipient, req.SendOptions)
+		if !ok {
 			return
 		}
 
-		if req.Message == "" && req.MediaPath == "" {
-			http.Error(w, "Message or media path is required", http.StatusBadRequest)
+		if !client.IsConnected() {
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not connected to WhatsApp")
 			return
 		}
 
-		fmt.Println("Received request to send message", req.Message, req.MediaPath)
-
-		// Send the message
-		success, message := sendWhatsAppMessage(client, req.Recipient, req.Message, req.MediaPath)
-		fmt.Println("Message sent", success, message)
-		// Set response headers
-		w.Header().Set("Content-Type", "application/json")
+		// Media uploads can take a while, so the client may ask for a job
+		if jobs.Async(r) {
+			job, err := jobManager.Start(r.Context(), "send", scheduler.RequestCreator(r), func(ctx context.Context, _ func(int, int)) (interface{}, error) {
+				result := sendWhatsAppMessage(ctx, client, messageStore, req.Recipient, req.Message, req.MediaPath, req.SendOptions, contextInfo)
+				if !result.Success {
+					slog.WarnContext(ctx, "Failed to send message", "recipient", req.Recipient, "status", "failed", "error", result.Message)
+					return nil, jobs.Fail(apierror.CodeWhatsAppError, result.Message)
+				}
+				slog.InfoContext(ctx, "Sent message", "whatsapp_message_id", result.MessageID, "recipient", req.Recipient, "status", "sent")
+				return map[string]string{"message_id": result.MessageID, "chat_jid": result.ChatJID}, nil
+			})
+			if err != nil {
+				jobs.StartError(w, err)
+				return
+			}
+			jobs.Accepted(w, r, job)
+			return
+		}
 
-		// Set appropriate status code
-		if !success {
-			w.WriteHeader(http.StatusInternalServerError)
+		// Send the message. A client hanging up must not abort a send that is under way.
+		ctx := context.WithoutCancel(r.Context())
+		start := time.Now()
+		result := sendWhatsAppMessage(ctx, client, messageStore, req.Recipient, req.Message, req.MediaPath, req.SendOptions, contextInfo)
+		latency := time.Since(start)
+		if !result.Success {
+			slog.WarnContext(ctx, "Failed to send message", "recipient", req.Recipient, "status", "failed", "latency", latency, "error", result.Message)
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, result.Message)
+			return
 		}
 
+		slog.InfoContext(ctx, "Sent message", "whatsapp_message_id", result.MessageID, "recipient", req.Recipient, "status", "sent", "latency", latency)
+		audit.SetResource(r.Context(), result.MessageID)
+
 		// Send response
+		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(SendMessageResponse{
-			Success: success,
-			Message: message,
+			Success:   result.Success,
+			Message:   result.Message,
+			MessageID: result.MessageID,
+			ChatJID:   result.ChatJID,
 		})
 	})
 
-	// Handler for downloading media
-	http.HandleFunc("/api/download", func(w http.ResponseWriter, r *http.Request) {
-		// Only allow POST requests
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
+	// Handlers for sending media with the details WhatsApp shows for each kind
+	mux.HandleFunc("POST /v1/send/image", mediaSendHandler(client, messageStore, jobManager, mediaImage))
+	mux.HandleFunc("POST /v1/send/video", mediaSendHandler(client, messageStore, jobManager, mediaVideo))
+	mux.HandleFunc("POST /v1/send/document", mediaSendHandler(client, messageStore, jobManager, mediaDocument))
+	mux.HandleFunc("POST /v1/send/voice", mediaSendHandler(client, messageStore, jobManager, mediaVoice))
+	registerChatHandlers(mux, messageStore)
+	registerHistoryHandlers(mux, messageStore)
+	registerContactHandlers(mux, client, messageStore)
+	registerContactListHandlers(mux, messageStore)
+	registerContactSearchHandlers(mux, messageStore)
+	registerBackfillHandlers(mux, client, messageStore, jobManager)
+	registerMediaCleanupHandlers(mux, messageStore, jobManager)
+	registerCatalogHandlers(mux, client, messageStore)
+	registerPollHandlers(mux, client, messageStore)
+	registerReactionHandlers(mux, client, messageStore)
+	registerPinHandlers(mux, client, messageStore)
+	registerStarHandlers(mux, client, messageStore)
+	registerEditHandlers(mux, client, messageStore)
+	registerRevokeHandlers(mux, client, messageStore, msgScheduler)
+	registerDisappearingHandlers(mux, client, messageStore)
+	registerMuteHandlers(mux, client, messageStore)
+	registerGroupHandlers(mux, client, messageStore)
+	registerParticipantHandlers(mux, client, messageStore)
+	registerInviteHandlers(mux, client, messageStore)
+	registerSearchHandlers(mux, messageStore)
+	registerPresenceHandlers(mux, client)
+	registerReadHandlers(mux, client, messageStore)
+	registerReceiptHandlers(mux, messageStore)
+	registerMessageEventHandlers(mux, messageStore)
+	registerBroadcastHandlers(mux, messageStore)
+	registerFanOutHandlers(mux, client, messageStore, jobManager)
+	registerMediaDownloadHandlers(mux, client, messageStore)
+	registerNewsletterHandlers(mux, client, messageStore)
+	registerStatusHandlers(mux, client, messageStore, jobManager)
 
+	// Handler for downloading media
+	mux.HandleFunc("POST /v1/download", func(w http.ResponseWriter, r *http.Request) {
 		// Parse the request body
 		var req DownloadMediaRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request format", http.StatusBadRequest)
+		var v validate.Validator
+		if v.Decode(r, &req) {
+			v.Required("message_id", req.MessageID)
+			if v.Required("chat_jid", req.ChatJID) {
+				v.JID("chat_jid", req.ChatJID)
+			}
+		}
+		if !v.Valid() {
+			v.Write(w)
 			return
 		}
 
-		// Validate request
-		if req.MessageID == "" || req.ChatJID == "" {
-			http.Error(w, "Message ID and Chat JID are required", http.StatusBadRequest)
+		if jobs.Async(r) {
+			job, err := jobManager.Start(r.Context(), "download", scheduler.RequestCreator(r), func(ctx context.Context, _ func(int, int)) (interface{}, error) {
+				success, mediaType, filename, path, err := downloadMedia(client, messageStore, req.MessageID, req.ChatJID)
+				if !success || err != nil {
+					_, code, message := downloadFailure(err)
+					return nil, jobs.Fail(code, message)
+				}
+				return map[string]string{"media_type": mediaType, "filename": filename, "path": path}, nil
+			})
+			if err != nil {
+				jobs.StartError(w, err)
+				return
+			}
+			jobs.Accepted(w, r, job)
 			return
 		}
 
 		// Download the media
 		success, mediaType, filename, path, err := downloadMedia(client, messageStore, req.MessageID, req.ChatJID)
 
-		// Set response headers
-		w.Header().Set("Content-Type", "application/json")
-
 		// Handle download result
 		if !success || err != nil {
-			errMsg := "Unknown error"
-			if err != nil {
-				errMsg = err.Error()
-			}
-
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(DownloadMediaResponse{
-				Success: false,
-				Message: fmt.Sprintf("Failed to download media: %s", errMsg),
-			})
+			status, code, message := downloadFailure(err)
+			apierror.Write(w, status, code, message)
 			return
 		}
 
 		// Send successful response
+		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(DownloadMediaResponse{
 			Success:  true,
 			Message:  fmt.Sprintf("Successfully downloaded %s media", mediaType),
@@ -781,38 +1365,191 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, msgSc
 		})
 	})
 
-	// Start the server
-	serverAddr := fmt.Sprintf(":%d", port)
-	fmt.Printf("Starting REST API server on %s...\n", serverAddr)
+	// Record every mutating call. It sits inside authentication so the key name
+	// is known; calls rejected before reaching the routes changed nothing.
+	var handler http.Handler = audit.Middleware(auditStore, scheduler.RequestCreator, apierror.Wrap(mux))
 
-	// Run server in a goroutine so it doesn't block
-	go func() {
-		if err := http.ListenAndServe(serverAddr, nil); err != nil {
-			fmt.Printf("REST API server error: %v\n", err)
+	// Require an API key on every /api route unless authentication is turned off
+	if apiConfig.AuthDisabled {
+		slog.Warn("API authentication is disabled, anyone who can reach the bridge can use it")
+	} else {
+		handler = settings.rateLimiter.ByKey(handler)
+		handler = middleware.RequireAPIKey(apiConfig.Keys, handler)
+	}
+	handler = settings.rateLimiter.ByIP(handler)
+
+	// Serve the routes under /v1, with /api as an alias for existing clients
+	handler = middleware.APIVersions([]string{"v1"}, handler)
+
+	// Compress JSON and CSV responses for clients that accept gzip or deflate
+	handler = middleware.Compress(handler)
+
+	// Let configured browser origins call the API
+	handler = middleware.CORS(apiConfig.CORS, handler)
+
+	// Only answer the configured client networks, if any
+	handler = middleware.AllowIPs(apiConfig.AllowedIPs, handler)
+
+	// Tag every request with an ID that shows up in its log lines and error body
+	handler = middleware.RequestID(handler)
+
+	// Record a span for every request (a no-op unless built with -tags otel)
+	handler = tracing.Middleware(handler)
+
+	server := &http.Server{Handler: handler, ConnContext: middleware.MarkUnixSocket}
+	// Event streams never finish on their own, so end them when shutdown starts
+	server.RegisterOnShutdown(bus.Close)
+	server.RegisterOnShutdown(login.Close)
+
+	// Listen on TCP and on the unix socket, whichever are configured
+	var listeners []net.Listener
+	if cfg.Port != 0 {
+		serverAddr := fmt.Sprintf(":%d", cfg.Port)
+		ln, err := net.Listen("tcp", serverAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", serverAddr, err)
 		}
-	}()
+		slog.Info("Starting REST API server", "addr", serverAddr)
+		listeners = append(listeners, ln)
+	}
+	if cfg.SocketPath != "" {
+		ln, err := listenUnix(cfg.SocketPath, cfg.SocketMode)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		slog.Info("Starting REST API server", "socket", cfg.SocketPath)
+		listeners = append(listeners, ln)
+	}
+
+	// Serve in goroutines so it doesn't block
+	for _, ln := range listeners {
+		go func() {
+			if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("REST API server error", "error", err)
+			}
+		}()
+	}
+	return server, nil
+}
+
+// listenUnix listens on a unix socket at path with the given permissions. A
+// socket left behind by a bridge that didn't shut down cleanly is replaced; one
+// that another process still answers on is not.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("socket %s is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket %s: %w", path, err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return ln, nil
 }
 
+// startGRPC serves the gRPC API on cfg.GRPCPort and returns a function that
+// stops it. It is nil unless the bridge is built with -tags grpc (see grpc_server.go).
+var startGRPC func(cfg *config.Config, client *whatsmeow.Client, store *MessageStore, msgScheduler *scheduler.MessageScheduler, bus *bridgeevents.Bus, auditStore *audit.Store) (stop func(context.Context), err error)
+
+// startTracing installs an exporting tracer and returns its shutdown function.
+// It is nil unless the bridge is built with -tags otel (see tracing_otel.go).
+var startTracing func(ctx context.Context) (shutdown func(context.Context) error, err error)
+
 func main() {
+	// --healthcheck probes an already running bridge instead of starting one
+	healthcheck := flag.Bool("healthcheck", false, "exit 0 if the bridge running on this host is ready, for Docker HEALTHCHECK")
+	flag.Parse()
+	if *healthcheck {
+		os.Exit(runHealthcheck())
+	}
+
 	// Set up logger
-	logger := waLog.Stdout("Client", "INFO", true)
+	logger := logging.WhatsAppLogger("Client")
 	logger.Infof("Starting WhatsApp client...")
 
 	// Create database connection for storing session data
-	dbLog := waLog.Stdout("Database", "INFO", true)
+	dbLog := logging.WhatsAppLogger("Database")
 
-	// Create directory for database if it doesn't exist
+	// Database paths and scheduler settings (BRIDGE_CONFIG_FILE and environment)
+	cfg, err := config.Load()
+	if err != nil {
+		logger.Errorf("Invalid configuration: %v", err)
+		return
+	}
+
+	// Switch to the configured level, format and output
+	logFile, err := logging.Setup(cfg.Log)
+	if err != nil {
+		logger.Errorf("Failed to set up logging: %v", err)
+		return
+	}
+	defer logFile.Close()
+
+	// Create directories for the databases if they don't exist
 	if err := os.MkdirAll("store", 0755); err != nil {
 		logger.Errorf("Failed to create store directory: %v", err)
 		return
 	}
+	if err := os.MkdirAll(filepath.Dir(cfg.WhatsAppDBPath), 0755); err != nil {
+		logger.Errorf("Failed to create database directory: %v", err)
+		return
+	}
+
+	// SQLite connection settings shared by all databases
+	dbOptions, err := sqlitedb.OptionsFromEnv()
+	if err != nil {
+		logger.Errorf("Invalid database configuration: %v", err)
+		return
+	}
 
 	ctx := context.Background()
-	container, err := sqlstore.New(ctx, "sqlite3", "file:store/whatsapp.db?_foreign_keys=on", dbLog)
+	if startTracing != nil {
+		shutdown, err := startTracing(ctx)
+		if err != nil {
+			logger.Errorf("Failed to start tracing: %v", err)
+			return
+		}
+		// Flush buffered spans on the way out
+		defer func() {
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := shutdown(flushCtx); err != nil {
+				logger.Warnf("Failed to flush traces: %v", err)
+			}
+		}()
+		logger.Infof("Exporting traces over OTLP")
+	}
+
+	whatsappDB, err := sqlitedb.Open(cfg.WhatsAppDBPath, dbOptions)
 	if err != nil {
 		logger.Errorf("Failed to connect to database: %v", err)
 		return
 	}
+	defer whatsappDB.Close()
+	container := sqlstore.NewWithDB(whatsappDB, "sqlite3", dbLog)
+	if err := container.Upgrade(ctx); err != nil {
+		logger.Errorf("Failed to upgrade database: %v", err)
+		return
+	}
 
 	// Get device store - This contains session information
 	deviceStore, err := container.GetFirstDevice(ctx)
@@ -835,33 +1572,175 @@ func main() {
 	}
 
 	// Initialize message store
-	messageStore, err := NewMessageStore()
+	messageStore, err := NewMessageStore(cfg.MessagesDBPath, dbOptions)
 	if err != nil {
 		logger.Errorf("Failed to initialize message store: %v", err)
 		return
 	}
 	defer messageStore.Close()
 
-	// Initialize scheduler database
-	schedulerDB, err := scheduler.NewSchedulerDB("store/scheduler.db")
+	// Keep downloaded media within the quotas and for as long as the policy
+	// says, and download what the policy picks as it arrives
+	messageStore.SetMediaStorage(cfg.Media.Dir, mediaPolicy(cfg.Media))
+	if messageStore.mediaPolicy.limits() {
+		go keepMediaWithinPolicy(context.Background(), messageStore)
+	}
+	autoDownload := startMediaAutoDownloader(client, messageStore, cfg.Media)
+	if types := cfg.Media.AutoDownload; len(types) > 0 || len(cfg.Media.AutoDownloadChats) > 0 {
+		slog.Info("Downloading received media", "types", strings.Join(types, ","), "chat_rules", len(cfg.Media.AutoDownloadChats), "max_size_mb", cfg.Media.AutoDownloadMaxSizeMB)
+	}
+
+	// Initialize scheduler database: a SQLite file unless the DSN points at
+	// Postgres, or the message store itself in single-database mode
+	var schedulerDB *scheduler.SchedulerDB
+	if cfg.Scheduler.SingleDB {
+		schedulerDB, err = scheduler.NewSharedSchedulerDB(messageStore.db)
+	} else {
+		schedulerDB, err = scheduler.OpenSchedulerDB(cfg.Scheduler.DBDSN, dbOptions)
+	}
 	if err != nil {
 		logger.Errorf("Failed to initialize scheduler database: %v", err)
 		return
 	}
 	defer schedulerDB.Close()
 
+	// Optionally encrypt scheduled message bodies at rest
+	if keyValue := cfg.Scheduler.EncryptionKey; keyValue != "" {
+		key, err := scheduler.ParseEncryptionKey(keyValue)
+		if err != nil {
+			logger.Errorf("Invalid SCHEDULER_ENCRYPTION_KEY: %v", err)
+			return
+		}
+		if err := schedulerDB.SetEncryptionKey(key); err != nil {
+			logger.Errorf("Failed to enable scheduler encryption: %v", err)
+			return
+		}
+		logger.Infof("Scheduler message encryption enabled")
+	}
+
 	// Initialize message scheduler
-	messageScheduler := scheduler.NewMessageScheduler(schedulerDB, messageStore.db, client, sendWhatsAppMessage)
-	// Start scheduler worker (check every minute)
-	messageScheduler.Start(1 * time.Minute)
+	messageScheduler := scheduler.NewMessageScheduler(schedulerDB, messageStore.db, client, scheduledMessageSender(messageStore))
+	messageScheduler.SetMediaSender(scheduledMediaSender(messageStore), checkScheduledMedia)
+
+	// Events for the /v1/events stream
+	bus := bridgeevents.NewBus()
+	messageScheduler.OnStatusChange(func(change scheduler.StatusChange) {
+		bus.Publish(bridgeevents.TypeSchedulerStatus, change)
+	})
+
+	// Signed webhook deliveries of the configured event types, queued in the
+	// message store so they survive restarts
+	webhookStore, err := webhook.NewStore(messageStore.db)
+	if err != nil {
+		logger.Errorf("Failed to set up the webhook queue: %v", err)
+		return
+	}
+	// The dispatcher runs even without URLs, so a reload can add some
+	webhooks := webhook.New(webhookStore, webhookOptions(cfg.Webhooks))
+	bus.AddListener(webhooks.Enqueue)
+	if len(cfg.Webhooks.URLs) > 0 {
+		slog.Info("Delivering webhooks", "urls", len(cfg.Webhooks.URLs), "events", strings.Join(cfg.Webhooks.Events, ","))
+	}
+
+	// Audit log of mutating API calls, in the message store too
+	auditStore, err := audit.NewStore(messageStore.db)
+	if err != nil {
+		logger.Errorf("Failed to set up the audit log: %v", err)
+		return
+	}
+	if cfg.API.AuditRetentionDays > 0 {
+		go audit.KeepFor(context.Background(), auditStore, time.Duration(cfg.API.AuditRetentionDays)*24*time.Hour)
+	}
+
+	// Long operations run as jobs when the client asks for it
+	jobManager := jobs.NewManager(jobs.DefaultWorkers, jobs.DefaultKeep)
+
+	// Configure cleanup of finished messages (archive, delete or off)
+	retention := scheduler.RetentionOptions{
+		Mode:      cfg.Scheduler.RetentionMode,
+		Retention: time.Duration(cfg.Scheduler.RetentionDays) * 24 * time.Hour,
+	}
+	if err := messageScheduler.SetRetention(retention); err != nil {
+		logger.Errorf("Invalid scheduler retention settings: %v", err)
+		return
+	}
+
+	// Hold back sending during quiet hours
+	if err := messageScheduler.SetQuietHours(schedulerQuietHours(cfg.Scheduler.QuietHours)); err != nil {
+		logger.Errorf("Invalid quiet hours: %v", err)
+		return
+	}
+
+	// Serve repeated list requests from memory between writes
+	messageScheduler.SetListCacheTTL(cfg.API.CacheTTL)
+
+	// Optional daily backups of the scheduler data
+	if backupDir := cfg.Scheduler.BackupDir; backupDir != "" {
+		if err := messageScheduler.SetBackupDir(backupDir, cfg.Scheduler.BackupKeep); err != nil {
+			logger.Errorf("Failed to set up scheduler backups: %v", err)
+			return
+		}
+	}
+
+	// Start scheduler worker
+	messageScheduler.Start(cfg.Scheduler.CheckInterval)
 	defer messageScheduler.Stop()
 
+	// Keep the stored groups in step with WhatsApp
+	groupSync := newGroupSyncer(client, messageStore)
+	client.AddEventHandler(groupSync.handleEvent)
+	go groupSync.Run(context.Background())
+
+	// Keep the stored contacts in step with whatsmeow's contact store
+	contactSync := newContactSyncer(client, messageStore)
+	client.AddEventHandler(contactSync.handleEvent)
+	go contactSync.Run(context.Background())
+
 	// Setup event handling for messages and history sync
 	client.AddEventHandler(func(evt interface{}) {
 		switch v := evt.(type) {
 		case *events.Message:
 			// Process regular messages
-			handleMessage(client, messageStore, v, logger)
+			handleMessage(client, messageStore, autoDownload, bus, v, logger)
+
+		case *events.Receipt:
+			if v.IsFromMe {
+				handleOwnReceipt(messageStore, v)
+			} else {
+				handleReceipt(messageStore, v)
+			}
+			bus.Publish(bridgeevents.TypeReceipt, map[string]interface{}{
+				"message_ids": v.MessageIDs,
+				"chat_jid":    v.Chat.String(),
+				"sender":      v.Sender.User,
+				"type":        receiptTypeName(v.Type),
+				"timestamp":   v.Timestamp,
+			})
+
+		case *events.Presence:
+			presence := map[string]interface{}{
+				"jid":       v.From.String(),
+				"available": !v.Unavailable,
+			}
+			if !v.LastSeen.IsZero() {
+				presence["last_seen"] = v.LastSeen
+			}
+			bus.Publish(bridgeevents.TypePresence, presence)
+
+		case *events.Star:
+			handleStar(messageStore, v)
+
+		case *events.Mute:
+			handleMute(messageStore, v)
+
+		case *events.Archive:
+			handleArchive(messageStore, v)
+
+		case *events.GroupInfo:
+			handleGroupDisappearing(messageStore, v)
+			handleGroupName(messageStore, v)
+			handleGroupJoinRequests(bus, v)
+			handleGroupJoins(messageStore, v)
 
 		case *events.HistorySync:
 			// Process history sync events
@@ -875,6 +1754,38 @@ func main() {
 		}
 	})
 
+	// Start REST API server. It comes up before the connection, so a new device
+	// can be paired with the QR code it serves.
+	settings := &reloader{
+		cfg:          cfg,
+		msgScheduler: messageScheduler,
+		webhooks:     webhooks,
+		rateLimiter:  middleware.NewRateLimiter(cfg.API.RateLimit),
+	}
+	login := newLoginState()
+	server, err := startRESTServer(client, messageStore, messageScheduler, bus, webhookStore, auditStore, jobManager, login, settings)
+	if err != nil {
+		logger.Errorf("Failed to start REST API server: %v", err)
+		shutdown(nil, func(context.Context) {}, jobManager, messageScheduler, client, webhooks, cfg.ShutdownTimeout)
+		return
+	}
+
+	// Serve the gRPC API alongside, when it is configured and compiled in
+	stopGRPC := func(context.Context) {}
+	if cfg.GRPCPort != 0 {
+		if startGRPC == nil {
+			logger.Warnf("BRIDGE_GRPC_PORT is set but this build has no gRPC server, rebuild with -tags grpc")
+		} else {
+			stop, err := startGRPC(cfg, client, messageStore, messageScheduler, bus, auditStore)
+			if err != nil {
+				logger.Errorf("Failed to start gRPC server: %v", err)
+				shutdown(server, stopGRPC, jobManager, messageScheduler, client, webhooks, cfg.ShutdownTimeout)
+				return
+			}
+			stopGRPC = stop
+		}
+	}
+
 	// Create channel to track connection success
 	connected := make(chan bool, 1)
 
@@ -885,12 +1796,15 @@ func main() {
 		err = client.Connect()
 		if err != nil {
 			logger.Errorf("Failed to connect: %v", err)
+			shutdown(server, stopGRPC, jobManager, messageScheduler, client, webhooks, cfg.ShutdownTimeout)
 			return
 		}
 
-		// Print QR code for pairing with phone
+		// Print QR code for pairing with phone, and serve it on GET /v1/auth/qr
 		for evt := range qrChan {
+			login.update(evt)
 			if evt.Event == "code" {
+				// The QR code goes to the terminal, not the log
 				fmt.Println("\nScan this QR code with your WhatsApp app:")
 				qrterminal.GenerateHalfBlock(evt.Code, qrterminal.L, os.Stdout)
 			} else if evt.Event == "success" {
@@ -902,9 +1816,10 @@ func main() {
 		// Wait for connection
 		select {
 		case <-connected:
-			fmt.Println("\nSuccessfully connected and authenticated!")
+			logger.Infof("Successfully connected and authenticated")
 		case <-time.After(3 * time.Minute):
 			logger.Errorf("Timeout waiting for QR code scan")
+			shutdown(server, stopGRPC, jobManager, messageScheduler, client, webhooks, cfg.ShutdownTimeout)
 			return
 		}
 	} else {
@@ -912,6 +1827,7 @@ func main() {
 		err = client.Connect()
 		if err != nil {
 			logger.Errorf("Failed to connect: %v", err)
+			shutdown(server, stopGRPC, jobManager, messageScheduler, client, webhooks, cfg.ShutdownTimeout)
 			return
 		}
 		connected <- true
@@ -922,30 +1838,65 @@ func main() {
 
 	if !client.IsConnected() {
 		logger.Errorf("Failed to establish stable connection")
+		shutdown(server, stopGRPC, jobManager, messageScheduler, client, webhooks, cfg.ShutdownTimeout)
 		return
 	}
 
-	fmt.Println("\n✓ Connected to WhatsApp! Type 'help' for commands.")
+	logger.Infof("Connected to WhatsApp")
+
+	// SIGHUP reloads the configuration, like POST /v1/admin/reload
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
+	go func() {
+		for range reloadChan {
+			if _, err := settings.Reload(); err != nil {
+				slog.Error("Configuration reload failed, nothing was changed", "error", err)
+			}
+		}
+	}()
 
-	// Start REST API server
-	startRESTServer(client, messageStore, messageScheduler, 8080)
+	// Tell systemd the bridge is up, and keep its watchdog fed while it stays ready
+	if err := sdNotify("READY=1\nSTATUS=Connected to WhatsApp, serving the API"); err != nil {
+		slog.Warn("Failed to notify systemd", "error", err)
+	}
+	watchdogCtx, stopWatchdog := context.WithCancel(context.Background())
+	defer stopWatchdog()
+	if interval := watchdogInterval(); interval > 0 {
+		go runWatchdog(watchdogCtx, interval, func(ctx context.Context) ReadinessResponse {
+			return checkReadiness(ctx, client, messageStore, messageScheduler)
+		})
+	}
 
 	// Create a channel to keep the main goroutine alive
 	exitChan := make(chan os.Signal, 1)
 	signal.Notify(exitChan, syscall.SIGINT, syscall.SIGTERM)
 
-	fmt.Println("REST server is running. Press Ctrl+C to disconnect and exit.")
+	logger.Infof("REST server is running. Press Ctrl+C to disconnect and exit.")
 
 	// Wait for termination signal
-	<-exitChan
+	sig := <-exitChan
 
-	fmt.Println("Disconnecting...")
-	// Disconnect client
-	client.Disconnect()
+	// A second signal skips the graceful shutdown
+	go func() {
+		<-exitChan
+		logger.Warnf("Received second signal, exiting immediately")
+		os.Exit(1)
+	}()
+
+	logger.Infof("Received %s, shutting down", sig)
+	stopWatchdog()
+	sdNotify("STOPPING=1")
+	shutdown(server, stopGRPC, jobManager, messageScheduler, client, webhooks, cfg.ShutdownTimeout)
+	// The deferred calls close the databases and flush logs and traces
 }
 
 // GetChatName determines the appropriate name for a chat based on JID and other info
 func GetChatName(ctx context.Context, client *whatsmeow.Client, messageStore *MessageStore, jid types.JID, chatJID string, conversation interface{}, sender string, logger waLog.Logger) string {
+	// Status updates of every contact share one chat, not named after any of them
+	if scheduler.IsStatus(chatJID) {
+		return statusChatName
+	}
+
 	// First, check if chat already exists in database with a name
 	var existingName string
 	err := messageStore.db.QueryRow("SELECT name FROM chats WHERE jid = ?", chatJID).Scan(&existingName)
@@ -1029,7 +1980,7 @@ func GetChatName(ctx context.Context, client *whatsmeow.Client, messageStore *Me
 
 // Handle history sync events
 func handleHistorySync(client *whatsmeow.Client, messageStore *MessageStore, historySync *events.HistorySync, logger waLog.Logger) {
-	fmt.Printf("Received history sync event with %d conversations\n", len(historySync.Data.Conversations))
+	slog.Info("Received history sync", "conversations", len(historySync.Data.Conversations))
 
 	syncedCount := 0
 	for _, conversation := range historySync.Data.Conversations {
@@ -1075,16 +2026,10 @@ func handleHistorySync(client *whatsmeow.Client, messageStore *MessageStore, his
 				if msg == nil || msg.Message == nil {
 					continue
 				}
+				storeHistoryEvents(client, messageStore, jid, msg.Message)
 
 				// Extract text content
-				var content string
-				if msg.Message.Message != nil {
-					if conv := msg.Message.Message.GetConversation(); conv != "" {
-						content = conv
-					} else if ext := msg.Message.Message.GetExtendedTextMessage(); ext != nil {
-						content = ext.GetText()
-					}
-				}
+				content := extractTextContent(msg.Message.Message)
 
 				// Extract media info
 				var mediaType, filename, url string
@@ -1095,9 +2040,6 @@ func handleHistorySync(client *whatsmeow.Client, messageStore *MessageStore, his
 					mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength = extractMediaInfo(msg.Message.Message)
 				}
 
-				// Log the message content for debugging
-				logger.Infof("Message content: %v, Media Type: %v", content, mediaType)
-
 				// Skip messages with no content and no media
 				if content == "" && mediaType == "" {
 					continue
@@ -1154,56 +2096,33 @@ func handleHistorySync(client *whatsmeow.Client, messageStore *MessageStore, his
 					logger.Warnf("Failed to store history message: %v", err)
 				} else {
 					syncedCount++
-					// Log successful message storage
-					if mediaType != "" {
-						logger.Infof("Stored message: [%s] %s -> %s: [%s: %s] %s",
-							timestamp.Format("2006-01-02 15:04:05"), sender, chatJID, mediaType, filename, content)
-					} else {
-						logger.Infof("Stored message: [%s] %s -> %s: %s",
-							timestamp.Format("2006-01-02 15:04:05"), sender, chatJID, content)
-					}
+					storeMessageContacts(messageStore, msgID, chatJID, msg.Message.Message)
+					storeMessagePoll(messageStore, msgID, chatJID, sender, timestamp, msg.Message.Message)
+					storeMessageInvite(messageStore, msgID, chatJID, sender, timestamp, msg.Message.Message)
+					// Like live messages, contents are only logged at debug level
+					slog.Debug("Stored history message",
+						"message_id", msgID,
+						"chat_jid", chatJID,
+						"sender", sender,
+						"timestamp", timestamp,
+						"media_type", mediaType,
+						"filename", filename,
+						"content", content,
+					)
 				}
 			}
-		}
-	}
-
-	fmt.Printf("History sync complete. Stored %d messages.\n", syncedCount)
-}
-
-// Request history sync from the server
-func requestHistorySync(client *whatsmeow.Client) {
-	if client == nil {
-		fmt.Println("Client is not initialized. Cannot request history sync.")
-		return
-	}
-
-	if !client.IsConnected() {
-		fmt.Println("Client is not connected. Please ensure you are connected to WhatsApp first.")
-		return
-	}
 
-	if client.Store.ID == nil {
-		fmt.Println("Client is not logged in. Please scan the QR code first.")
-		return
-	}
-
-	// Build and send a history sync request
-	historyMsg := client.BuildHistorySyncRequest(nil, 100)
-	if historyMsg == nil {
-		fmt.Println("Failed to build history sync request.")
-		return
+			// The phone's unread count tells which of the chat's messages are
+			// read. On-demand syncs are older messages and don't change it.
+			if conversation.UnreadCount != nil && historySync.Data.GetSyncType() != waHistorySync.HistorySync_ON_DEMAND {
+				if err := messageStore.StoreHistoryReads(chatJID, int(conversation.GetUnreadCount())); err != nil {
+					logger.Warnf("Failed to store read state of %s: %v", chatJID, err)
+				}
+			}
+		}
 	}
 
-	_, err := client.SendMessage(context.Background(), types.JID{
-		Server: "s.whatsapp.net",
-		User:   "status",
-	}, historyMsg)
-
-	if err != nil {
-		fmt.Printf("Failed to request history sync: %v\n", err)
-	} else {
-		fmt.Println("History sync requested. Waiting for server response...")
-	}
+	slog.Info("History sync complete", "stored", syncedCount)
 }
 
 // analyzeOggOpus tries to extract duration and generate a simple waveform from an Ogg Opus file
@@ -1265,7 +2184,7 @@ func analyzeOggOpus(data []byte) (duration uint32, waveform []byte, err error) {
 					preSkip = binary.LittleEndian.Uint16(pageData[headPos+10 : headPos+12])
 					sampleRate = binary.LittleEndian.Uint32(pageData[headPos+12 : headPos+16])
 					foundOpusHead = true
-					fmt.Printf("Found OpusHead: sampleRate=%d, preSkip=%d\n", sampleRate, preSkip)
+					slog.Debug("Found OpusHead", "sample_rate", sampleRate, "pre_skip", preSkip)
 				}
 			}
 		}
@@ -1280,7 +2199,7 @@ func analyzeOggOpus(data []byte) (duration uint32, waveform []byte, err error) {
 	}
 
 	if !foundOpusHead {
-		fmt.Println("Warning: OpusHead not found, using default values")
+		slog.Warn("OpusHead not found, using default values")
 	}
 
 	// Calculate duration based on granule position
@@ -1288,11 +2207,10 @@ func analyzeOggOpus(data []byte) (duration uint32, waveform []byte, err error) {
 		// Formula for duration: (lastGranule - preSkip) / sampleRate
 		durationSeconds := float64(lastGranule-uint64(preSkip)) / float64(sampleRate)
 		duration = uint32(math.Ceil(durationSeconds))
-		fmt.Printf("Calculated Opus duration from granule: %f seconds (lastGranule=%d)\n",
-			durationSeconds, lastGranule)
+		slog.Debug("Calculated Opus duration from granule", "seconds", durationSeconds, "last_granule", lastGranule)
 	} else {
 		// Fallback to rough estimation if granule position not found
-		fmt.Println("Warning: No valid granule position found, using estimation")
+		slog.Warn("No valid granule position found, estimating Opus duration")
 		durationEstimate := float64(len(data)) / 2000.0 // Very rough approximation
 		duration = uint32(durationEstimate)
 	}
@@ -1307,8 +2225,7 @@ func analyzeOggOpus(data []byte) (duration uint32, waveform []byte, err error) {
 	// Generate waveform
 	waveform = placeholderWaveform(duration)
 
-	fmt.Printf("Ogg Opus analysis: size=%d bytes, calculated duration=%d sec, waveform=%d bytes\n",
-		len(data), duration, len(waveform))
+	slog.Debug("Analyzed Ogg Opus file", "size", len(data), "duration_seconds", duration, "waveform_size", len(waveform))
 
 	return duration, waveform, nil
 }
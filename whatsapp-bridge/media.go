@@ -0,0 +1,629 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/audit"
+	"whatsapp-client/jobs"
+	"whatsapp-client/scheduler"
+	"whatsapp-client/tracing"
+	"whatsapp-client/validate"
+)
+
+// Kinds of media the /v1/send/{kind} endpoints send, which scheduled
+// messages can carry too
+const (
+	mediaImage    = scheduler.MediaImage
+	mediaVideo    = scheduler.MediaVideo
+	mediaDocument = scheduler.MediaDocument
+	mediaVoice    = scheduler.MediaVoice
+)
+
+// mediaSizeLimits are the largest files accepted per kind, in bytes
+var mediaSizeLimits = map[string]int64{
+	mediaImage:    16 << 20,
+	mediaVideo:    64 << 20,
+	mediaDocument: 100 << 20,
+	mediaVoice:    16 << 20,
+}
+
+// mediaMimetypes are the sniffed content types accepted per kind. Documents
+// can be anything.
+var mediaMimetypes = map[string][]string{
+	mediaImage: {"image/jpeg", "image/png", "image/webp"},
+	// Videos other than MP4 are converted, which needs ffmpeg
+	mediaVideo: {"video/mp4", "video/quicktime", "video/3gpp", "video/webm", "video/avi"},
+	// WebM is what browsers record; it sniffs as video
+	mediaVoice: {"audio/ogg", "audio/mpeg", "audio/mp4", "audio/aac", "audio/wave", "audio/flac", "audio/aiff", "audio/amr", "video/webm"},
+}
+
+// mediaTypeHints are added to the error for a file of the wrong type
+var mediaTypeHints = map[string]string{
+	mediaVideo: convertVideoHint,
+}
+
+// thumbnailSize is the longest side of the JPEG preview sent with images, in pixels
+const thumbnailSize = 72
+
+// multipartMemory is how much of an upload is held in memory before the rest
+// goes to a temporary file
+const multipartMemory = 8 << 20
+
+// mediaFetchTimeout bounds fetching a file given by URL
+const mediaFetchTimeout = 2 * time.Minute
+
+// mediaHTTPClient fetches media given by URL
+var mediaHTTPClient = &http.Client{Timeout: mediaFetchTimeout}
+
+// SendMediaRequest is the body of POST /v1/send/{kind}. As a multipart form it
+// has the same fields, with the uploaded file in "file" instead of a path or
+// URL.
+type SendMediaRequest struct {
+	Recipient string `json:"recipient"`
+	Caption   string `json:"caption,omitempty"`
+	// Path is a file on the bridge host
+	Path string `json:"path,omitempty"`
+	// URL is an http or https URL the bridge fetches the file from
+	URL string `json:"url,omitempty"`
+	// Filename is the name a document is shown with, instead of the file's own
+	Filename string `json:"filename,omitempty"`
+	// Mimetype is a document's type, instead of the one worked out from its
+	// name and content
+	Mimetype string `json:"mimetype,omitempty"`
+	// GIF sends a video to play like a GIF: looping, silent and without
+	// controls. An animated GIF file is converted to MP4 for it.
+	GIF bool `json:"gif,omitempty"`
+	scheduler.SendOptions
+}
+
+// outgoingMedia is a file to send, with what was found out about it
+type outgoingMedia struct {
+	data     []byte
+	filename string
+	mimetype string
+	// width, height and thumbnail are set for images that could be decoded,
+	// and for videos; a video thumbnail needs ffmpeg
+	width     uint32
+	height    uint32
+	thumbnail []byte
+	// seconds is the length of a video or voice note
+	seconds uint32
+	// waveform and ptt are set for audio that goes out as a voice note
+	waveform []byte
+	ptt      bool
+	// gif is set for a video that plays as a GIF
+	gif bool
+}
+
+// named sets the filename and mimetype a document was given, if any
+func (media *outgoingMedia) named(filename, mimetype string) {
+	if filename != "" {
+		media.filename = filename
+	}
+	media.mimetype = mimetype
+}
+
+// errMediaTooLarge is returned by readMedia for a file over the kind's limit
+var errMediaTooLarge = errors.New("file is too large")
+
+// readMediaRequest reads and checks a media send request and the file it
+// names or carries. The file is nil when validation failed.
+func readMediaRequest(w http.ResponseWriter, r *http.Request, kind string, v *validate.Validator) (SendMediaRequest, *outgoingMedia) {
+	var req SendMediaRequest
+	limit := mediaSizeLimits[kind]
+	var upload io.Reader
+	var uploadName string
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		// Leave room for the other fields and the multipart framing
+		r.Body = http.MaxBytesReader(w, r.Body, limit+1<<20)
+		if err := r.ParseMultipartForm(multipartMemory); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				v.Add("file", validate.CodeTooLong, fmt.Sprintf("must be at most %s", formatSize(limit)))
+			} else {
+				v.Add("body", validate.CodeInvalidFormat, fmt.Sprintf("is not a valid multipart form: %v", err))
+			}
+			return req, nil
+		}
+		req.Recipient = r.FormValue("recipient")
+		req.Caption = r.FormValue("caption")
+		req.Path = r.FormValue("path")
+		req.URL = r.FormValue("url")
+		req.Filename = r.FormValue("filename")
+		req.Mimetype = r.FormValue("mimetype")
+		req.QuotedMessageID = r.FormValue("quoted_message_id")
+		req.Mentions = r.MultipartForm.Value["mentions"]
+		req.Audience = r.MultipartForm.Value["audience"]
+		req.LinkPreview = r.FormValue("link_preview") == "true"
+		req.ViewOnce = r.FormValue("view_once") == "true"
+		req.GIF = r.FormValue("gif") == "true"
+		if file, header, err := r.FormFile("file"); err == nil {
+			defer file.Close()
+			upload, uploadName = file, header.Filename
+		}
+	} else if !v.Decode(r, &req) {
+		return req, nil
+	}
+
+	req.Recipient = v.Recipient("recipient", req.Recipient)
+	v.MaxLength("caption", req.Caption, validate.MaxMessageLength)
+	scheduler.ValidateDocumentFields(v, "", kind, req.Filename, req.Mimetype)
+	if scheduler.IsStatus(req.Recipient) && kind != mediaImage && kind != mediaVideo {
+		v.Add("recipient", validate.CodeInvalidValue, fmt.Sprintf("can't be %s for a %s, status updates are photos or videos", req.Recipient, kind))
+	}
+	if kind == mediaVoice && req.Caption != "" {
+		v.Add("caption", validate.CodeInvalidValue, "must be empty, voice notes have no caption")
+	}
+	if req.GIF && kind != mediaVideo {
+		v.Add("gif", validate.CodeInvalidValue, "can only be set for videos")
+	}
+	if kind == mediaVoice && len(req.Mentions) > 0 {
+		v.Add("mentions", validate.CodeInvalidValue, "must be empty, voice notes have no caption to mention in")
+	}
+	req.SendOptions.Validate(v, req.Recipient)
+	req.ValidateNotText(v, "media messages")
+	req.ValidateViewOnce(v, kind)
+	req.Caption = req.MentionText(req.Caption)
+
+	sources := 0
+	for _, set := range []bool{upload != nil, req.Path != "", req.URL != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		v.Add("file", validate.CodeRequired, "exactly one of file, path or url is required")
+		return req, nil
+	}
+	if !v.Valid() {
+		return req, nil
+	}
+
+	media, field, err := readMedia(r.Context(), upload, uploadName, req.Path, req.URL, limit)
+	switch {
+	case errors.Is(err, errMediaTooLarge):
+		v.Add(field, validate.CodeTooLong, fmt.Sprintf("must be at most %s", formatSize(limit)))
+		return req, nil
+	case err != nil:
+		v.Add(field, validate.CodeInvalidValue, err.Error())
+		return req, nil
+	}
+	media.named(req.Filename, req.Mimetype)
+	media.gif = req.GIF
+	if err := prepareMedia(r.Context(), kind, media); err != nil {
+		v.Add(field, validate.CodeInvalidValue, err.Error())
+		return req, nil
+	}
+	return req, media
+}
+
+// readMedia reads an upload, a file on the host or a URL, at most limit bytes.
+// It returns the name of the request field the file came from, for errors.
+func readMedia(ctx context.Context, upload io.Reader, uploadName, path, rawURL string, limit int64) (*outgoingMedia, string, error) {
+	switch {
+	case upload != nil:
+		data, err := readLimited(upload, limit)
+		return &outgoingMedia{data: data, filename: filepath.Base(uploadName)}, "file", err
+
+	case path != "":
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, "path", fmt.Errorf("can't be read: %v", err)
+		}
+		defer file.Close()
+		data, err := readLimited(file, limit)
+		return &outgoingMedia{data: data, filename: filepath.Base(path)}, "path", err
+
+	default:
+		u, err := url.Parse(rawURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, "url", errors.New("must be an http or https URL")
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+		if err != nil {
+			return nil, "url", err
+		}
+		resp, err := mediaHTTPClient.Do(req)
+		if err != nil {
+			return nil, "url", fmt.Errorf("can't be fetched: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, "url", fmt.Errorf("can't be fetched: HTTP %d", resp.StatusCode)
+		}
+		if resp.ContentLength > limit {
+			return nil, "url", errMediaTooLarge
+		}
+		data, err := readLimited(resp.Body, limit)
+		return &outgoingMedia{data: data, filename: filepath.Base(u.Path)}, "url", err
+	}
+}
+
+// readLimited reads all of r, or fails with errMediaTooLarge past limit bytes
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("can't be read: %v", err)
+	}
+	if int64(len(data)) > limit {
+		return nil, errMediaTooLarge
+	}
+	if len(data) == 0 {
+		return nil, errors.New("is empty")
+	}
+	return data, nil
+}
+
+// prepareMedia checks that a file's content suits kind and fills in the
+// details sent with it
+func prepareMedia(ctx context.Context, kind string, media *outgoingMedia) error {
+	if kind == mediaDocument {
+		prepareDocument(media)
+		return nil
+	}
+
+	media.mimetype = sniffMimetype(media.data)
+	if kind == mediaVideo && media.mimetype == "image/gif" {
+		if media.gif {
+			return prepareGIF(ctx, media)
+		}
+		return errors.New("is an animated GIF; set gif to true to send it as one")
+	}
+	if !slices.Contains(mediaMimetypes[kind], media.mimetype) {
+		msg := fmt.Sprintf("is %s, expected one of %s", media.mimetype, strings.Join(mediaMimetypes[kind], ", "))
+		if hint := mediaTypeHints[kind]; hint != "" {
+			msg += ". " + hint
+		}
+		return errors.New(msg)
+	}
+
+	switch kind {
+	case mediaVideo:
+		return prepareVideo(ctx, media)
+	case mediaVoice:
+		return prepareVoice(ctx, media)
+	case mediaImage:
+		return prepareImage(ctx, media)
+	}
+	return nil
+}
+
+// prepareDocument fills in the name and type a document is shown with, when
+// the request didn't give them. The extension decides the type when it's a
+// known one, since office files all sniff as ZIP or plain binary.
+func prepareDocument(media *outgoingMedia) {
+	if media.filename == "" || media.filename == "." || media.filename == "/" {
+		media.filename = "document"
+	}
+	if media.mimetype != "" {
+		return
+	}
+	if byExt := mime.TypeByExtension(filepath.Ext(media.filename)); byExt != "" {
+		media.mimetype, _, _ = mime.ParseMediaType(byExt)
+	}
+	if media.mimetype == "" {
+		media.mimetype = sniffMimetype(media.data)
+	}
+}
+
+// sniffMimetype works out a file's content type from its first bytes. On top
+// of what net/http knows it tells QuickTime, 3GP and M4A files from MP4 video,
+// and knows the audio formats voice notes are recorded in.
+func sniffMimetype(data []byte) string {
+	if len(data) >= 12 && string(data[4:8]) == "ftyp" {
+		switch brand := string(data[8:12]); {
+		case brand == "qt  ":
+			return "video/quicktime"
+		case strings.HasPrefix(brand, "3gp"):
+			return "video/3gpp"
+		case brand == "M4A " || brand == "M4B ":
+			return "audio/mp4"
+		}
+	}
+	switch {
+	case bytes.HasPrefix(data, []byte("fLaC")):
+		return "audio/flac"
+	case bytes.HasPrefix(data, []byte("#!AMR")):
+		return "audio/amr"
+	case len(data) >= 2 && data[0] == 0xff && data[1]&0xe0 == 0xe0:
+		// An MPEG frame without ID3 tags; layer 0 means AAC in ADTS
+		if data[1]&0x06 == 0 {
+			return "audio/aac"
+		}
+		return "audio/mpeg"
+	}
+	mimetype, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	if mimetype == "application/ogg" {
+		return "audio/ogg"
+	}
+	return mimetype
+}
+
+// formatSize writes a byte size in whole megabytes or kilobytes
+func formatSize(n int64) string {
+	if n >= 1<<20 {
+		return fmt.Sprintf("%d MB", n>>20)
+	}
+	return fmt.Sprintf("%d KB", n>>10)
+}
+
+// parseRecipient turns a phone number or JID into the JID to send to
+func parseRecipient(recipient string) (types.JID, error) {
+	if strings.Contains(recipient, "@") {
+		jid, err := types.ParseJID(recipient)
+		if err != nil {
+			return types.JID{}, fmt.Errorf("Error parsing JID: %v", err)
+		}
+		return jid, nil
+	}
+	return types.JID{User: recipient, Server: types.DefaultUserServer}, nil
+}
+
+// sendMedia uploads a prepared file and sends it as kind with an optional caption
+func sendMedia(ctx context.Context, client *whatsmeow.Client, store *MessageStore, recipient, caption, kind string, media *outgoingMedia, opts scheduler.SendOptions, contextInfo *waProto.ContextInfo) (result scheduler.SendResult) {
+	ctx, span := tracing.Start(ctx, "whatsapp.send",
+		tracing.String("recipient", recipient),
+		tracing.Bool("media", true),
+		tracing.String("media.kind", kind),
+	)
+	defer func() {
+		if result.Success {
+			span.SetAttributes(tracing.String("whatsapp.message_id", result.MessageID))
+		} else {
+			span.RecordError(errors.New(result.Message))
+		}
+		span.End()
+	}()
+
+	if !client.IsConnected() {
+		return scheduler.SendResult{Message: "Not connected to WhatsApp"}
+	}
+	if scheduler.IsBroadcastList(recipient) {
+		return sendToBroadcast(ctx, client, store, recipient, func(ctx context.Context, to string, info *waProto.ContextInfo) scheduler.SendResult {
+			return sendMedia(ctx, client, store, to, caption, kind, media, opts, info)
+		})
+	}
+	if scheduler.IsStatus(recipient) {
+		if err := checkStatusAudience(ctx, client, opts.Audience); err != nil {
+			return scheduler.SendResult{Message: err.Error()}
+		}
+	}
+	recipientJID, err := parseRecipient(recipient)
+	if err != nil {
+		return scheduler.SendResult{Message: err.Error()}
+	}
+
+	var mediaType whatsmeow.MediaType
+	switch kind {
+	case mediaImage:
+		mediaType = whatsmeow.MediaImage
+	case mediaVideo:
+		mediaType = whatsmeow.MediaVideo
+	case mediaDocument:
+		mediaType = whatsmeow.MediaDocument
+	case mediaVoice:
+		mediaType = whatsmeow.MediaAudio
+	default:
+		return scheduler.SendResult{Message: fmt.Sprintf("Unsupported media kind %q", kind)}
+	}
+
+	resp, extra, err := uploadFor(ctx, client, recipientJID, media.data, mediaType)
+	if err != nil {
+		return scheduler.SendResult{Message: fmt.Sprintf("Error uploading media: %v", err)}
+	}
+	slog.DebugContext(ctx, "Media uploaded", "kind", kind, "mime_type", media.mimetype, "size", resp.FileLength)
+
+	msg := &waProto.Message{}
+	switch kind {
+	case mediaImage:
+		msg.ImageMessage = &waProto.ImageMessage{
+			Caption:       proto.String(caption),
+			Mimetype:      proto.String(media.mimetype),
+			URL:           &resp.URL,
+			DirectPath:    &resp.DirectPath,
+			MediaKey:      resp.MediaKey,
+			FileEncSHA256: resp.FileEncSHA256,
+			FileSHA256:    resp.FileSHA256,
+			FileLength:    &resp.FileLength,
+			JPEGThumbnail: media.thumbnail,
+		}
+		if media.width > 0 {
+			msg.ImageMessage.Width = proto.Uint32(media.width)
+			msg.ImageMessage.Height = proto.Uint32(media.height)
+		}
+	case mediaVideo:
+		msg.VideoMessage = &waProto.VideoMessage{
+			Caption:       proto.String(caption),
+			Mimetype:      proto.String(media.mimetype),
+			URL:           &resp.URL,
+			DirectPath:    &resp.DirectPath,
+			MediaKey:      resp.MediaKey,
+			FileEncSHA256: resp.FileEncSHA256,
+			FileSHA256:    resp.FileSHA256,
+			FileLength:    &resp.FileLength,
+			Seconds:       proto.Uint32(media.seconds),
+			JPEGThumbnail: media.thumbnail,
+		}
+		if media.width > 0 {
+			msg.VideoMessage.Width = proto.Uint32(media.width)
+			msg.VideoMessage.Height = proto.Uint32(media.height)
+		}
+		if media.gif {
+			msg.VideoMessage.GifPlayback = proto.Bool(true)
+		}
+	case mediaDocument:
+		msg.DocumentMessage = &waProto.DocumentMessage{
+			Title:         proto.String(media.filename),
+			FileName:      proto.String(media.filename),
+			Caption:       proto.String(caption),
+			Mimetype:      proto.String(media.mimetype),
+			URL:           &resp.URL,
+			DirectPath:    &resp.DirectPath,
+			MediaKey:      resp.MediaKey,
+			FileEncSHA256: resp.FileEncSHA256,
+			FileSHA256:    resp.FileSHA256,
+			FileLength:    &resp.FileLength,
+		}
+	case mediaVoice:
+		msg.AudioMessage = &waProto.AudioMessage{
+			Mimetype:      proto.String(media.mimetype),
+			URL:           &resp.URL,
+			DirectPath:    &resp.DirectPath,
+			MediaKey:      resp.MediaKey,
+			FileEncSHA256: resp.FileEncSHA256,
+			FileSHA256:    resp.FileSHA256,
+			FileLength:    &resp.FileLength,
+			PTT:           proto.Bool(media.ptt),
+			Waveform:      media.waveform,
+		}
+		if media.seconds > 0 {
+			msg.AudioMessage.Seconds = proto.Uint32(media.seconds)
+		}
+	}
+
+	setContextInfo(msg, contextInfo)
+	if opts.ViewOnce {
+		msg = viewOnceMessage(msg)
+	}
+
+	sent, err := client.SendMessage(ctx, recipientJID, msg, extra...)
+	if err != nil {
+		return scheduler.SendResult{Message: fmt.Sprintf("Error sending message: %v", err)}
+	}
+	result = scheduler.SendResult{
+		Success:   true,
+		Message:   fmt.Sprintf("Sent %s to %s", kind, recipient),
+		MessageID: sent.ID,
+		ChatJID:   recipientJID.String(),
+		Timestamp: sent.Timestamp,
+	}
+	storeSent(ctx, client, store, result, msg)
+	return result
+}
+
+// loadScheduledMedia reads and checks the media of a scheduled message. Errors
+// name the request field at fault.
+func loadScheduledMedia(ctx context.Context, m scheduler.Media) (*outgoingMedia, error) {
+	limit, ok := mediaSizeLimits[m.Kind]
+	if !ok {
+		return nil, fmt.Errorf("media.kind %q can't be sent", m.Kind)
+	}
+	media, field, err := readMedia(ctx, nil, "", m.Path, m.URL, limit)
+	if errors.Is(err, errMediaTooLarge) {
+		return nil, fmt.Errorf("media.%s must be at most %s", field, formatSize(limit))
+	}
+	if err == nil {
+		media.named(m.Filename, m.Mimetype)
+		err = prepareMedia(ctx, m.Kind, media)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("media.%s %v", field, err)
+	}
+	return media, nil
+}
+
+// checkScheduledMedia is the scheduler's MediaChecker
+func checkScheduledMedia(ctx context.Context, m scheduler.Media) error {
+	_, err := loadScheduledMedia(ctx, m)
+	return err
+}
+
+// scheduledMediaSender is the scheduler's MediaSender. It reads the file
+// again, as it may have changed since the message was scheduled.
+func scheduledMediaSender(store *MessageStore) scheduler.MediaSender {
+	return func(ctx context.Context, client *whatsmeow.Client, recipient, caption string, m scheduler.Media, opts scheduler.SendOptions) scheduler.SendResult {
+		media, err := loadScheduledMedia(ctx, m)
+		if err != nil {
+			return scheduler.SendResult{Message: fmt.Sprintf("Error reading media: %v", err)}
+		}
+		info, err := sendContext(store, client, recipient, opts)
+		if err != nil {
+			return scheduler.SendResult{Message: fmt.Sprintf("Error building message context: %v", err)}
+		}
+		return sendMedia(ctx, client, store, recipient, caption, m.Kind, media, opts, info)
+	}
+}
+
+// mediaSendHandler serves POST /v1/send/{kind}. Like POST /v1/send it can run
+// as a job, since large uploads take a while.
+func mediaSendHandler(client *whatsmeow.Client, store *MessageStore, jobManager *jobs.Manager, kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var v validate.Validator
+		req, media := readMediaRequest(w, r, kind, &v)
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		contextInfo, ok := requestContext(w, r, store, client, req.Recipient, req.SendOptions)
+		if !ok {
+			return
+		}
+
+		if !client.IsConnected() {
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not connected to WhatsApp")
+			return
+		}
+
+		if jobs.Async(r) {
+			job, err := jobManager.Start(r.Context(), "send", scheduler.RequestCreator(r), func(ctx context.Context, _ func(int, int)) (interface{}, error) {
+				result := sendMedia(ctx, client, store, req.Recipient, req.Caption, kind, media, req.SendOptions, contextInfo)
+				if !result.Success {
+					slog.WarnContext(ctx, "Failed to send media", "kind", kind, "recipient", req.Recipient, "status", "failed", "error", result.Message)
+					return nil, jobs.Fail(apierror.CodeWhatsAppError, result.Message)
+				}
+				slog.InfoContext(ctx, "Sent media", "kind", kind, "whatsapp_message_id", result.MessageID, "recipient", req.Recipient, "status", "sent")
+				return map[string]string{"message_id": result.MessageID, "chat_jid": result.ChatJID}, nil
+			})
+			if err != nil {
+				jobs.StartError(w, err)
+				return
+			}
+			jobs.Accepted(w, r, job)
+			return
+		}
+
+		// A client hanging up must not abort a send that is under way
+		ctx := context.WithoutCancel(r.Context())
+		start := time.Now()
+		result := sendMedia(ctx, client, store, req.Recipient, req.Caption, kind, media, req.SendOptions, contextInfo)
+		latency := time.Since(start)
+		if !result.Success {
+			slog.WarnContext(ctx, "Failed to send media", "kind", kind, "recipient", req.Recipient, "status", "failed", "latency", latency, "error", result.Message)
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, result.Message)
+			return
+		}
+
+		slog.InfoContext(ctx, "Sent media", "kind", kind, "whatsapp_message_id", result.MessageID, "recipient", req.Recipient, "status", "sent", "latency", latency)
+		audit.SetResource(r.Context(), result.MessageID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(SendMessageResponse{
+			Success:   true,
+			Message:   result.Message,
+			MessageID: result.MessageID,
+			ChatJID:   result.ChatJID,
+		})
+	}
+}
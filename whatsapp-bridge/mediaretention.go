@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/config"
+	"whatsapp-client/jobs"
+	"whatsapp-client/scheduler"
+	"whatsapp-client/validate"
+)
+
+// mediaCleanupInterval is how often downloaded media is checked against the
+// retention policy
+const mediaCleanupInterval = time.Hour
+
+// Why a downloaded file was deleted
+const (
+	mediaExpired   = "expired"
+	mediaOverQuota = "over_quota"
+)
+
+// MediaPolicy is how much downloaded media is kept, and for how long. Files
+// deleted by it keep their media_files row, without a path.
+type MediaPolicy struct {
+	// Quota bounds the total size of the files in bytes, 0 for no limit
+	Quota int64
+	// TypeQuota bounds the total size of the files of some media types
+	TypeQuota map[string]int64
+	// MaxAge is how long files are kept after they are downloaded, 0 for ever
+	MaxAge time.Duration
+	// TypeMaxAge overrides MaxAge for some media types
+	TypeMaxAge map[string]time.Duration
+}
+
+// mediaPolicy is the MediaPolicy the configuration sets
+func mediaPolicy(cfg config.MediaConfig) MediaPolicy {
+	policy := MediaPolicy{
+		Quota:      int64(cfg.QuotaMB) << 20,
+		TypeQuota:  make(map[string]int64, len(cfg.TypeQuotaMB)),
+		MaxAge:     time.Duration(cfg.RetentionDays) * 24 * time.Hour,
+		TypeMaxAge: make(map[string]time.Duration, len(cfg.TypeRetentionDays)),
+	}
+	for mediaType, mb := range cfg.TypeQuotaMB {
+		policy.TypeQuota[mediaType] = int64(mb) << 20
+	}
+	for mediaType, days := range cfg.TypeRetentionDays {
+		policy.TypeMaxAge[mediaType] = time.Duration(days) * 24 * time.Hour
+	}
+	return policy
+}
+
+// maxAge is how long files of mediaType are kept, 0 for ever
+func (p MediaPolicy) maxAge(mediaType string) time.Duration {
+	if maxAge, ok := p.TypeMaxAge[mediaType]; ok {
+		return maxAge
+	}
+	return p.MaxAge
+}
+
+// quota is the most bytes of files of mediaType kept, the lower of its own
+// quota and the overall one, 0 for no limit
+func (p MediaPolicy) quota(mediaType string) int64 {
+	quota := p.Quota
+	if typeQuota := p.TypeQuota[mediaType]; typeQuota > 0 && (quota == 0 || typeQuota < quota) {
+		quota = typeQuota
+	}
+	return quota
+}
+
+// expires is whether the policy deletes files for their age
+func (p MediaPolicy) expires() bool {
+	for _, maxAge := range p.TypeMaxAge {
+		if maxAge > 0 {
+			return true
+		}
+	}
+	return p.MaxAge > 0
+}
+
+// limits is whether the policy deletes any files
+func (p MediaPolicy) limits() bool {
+	for _, quota := range p.TypeQuota {
+		if quota > 0 {
+			return true
+		}
+	}
+	return p.Quota > 0 || p.expires()
+}
+
+// mediaExpiry deletes the files downloaded before a time, on top of the
+// policy, of some media types or all of them
+type mediaExpiry struct {
+	before time.Time
+	types  []string
+}
+
+// covers is whether the expiry deletes file
+func (e mediaExpiry) covers(file RemovedMedia) bool {
+	return !e.before.IsZero() && file.DownloadedAt.Before(e.before) && (len(e.types) == 0 || slices.Contains(e.types, file.MediaType))
+}
+
+// RemovedMedia is a downloaded file a cleanup deleted, or would delete
+type RemovedMedia struct {
+	MessageID    string    `json:"message_id"`
+	ChatJID      string    `json:"chat_jid"`
+	MediaType    string    `json:"media_type"`
+	Size         int64     `json:"size"`
+	DownloadedAt time.Time `json:"downloaded_at"`
+	// Reason is expired or over_quota
+	Reason string `json:"reason,omitempty"`
+
+	path string
+}
+
+// MediaCleanup is what a cleanup of downloaded media deleted, and what it left
+type MediaCleanup struct {
+	// Expired and OverQuota count the files deleted for their age, and to fit
+	// in the quotas
+	Expired    int   `json:"expired"`
+	OverQuota  int   `json:"over_quota"`
+	FreedBytes int64 `json:"freed_bytes"`
+	// Files and Bytes are what is left on disk
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+	// Removed lists the files a dry run would delete
+	Removed []RemovedMedia `json:"removed,omitempty"`
+}
+
+// migrateMediaFiles adds the removed_at column to a media_files table made
+// before deleted files kept their rows
+func migrateMediaFiles(db *sql.DB) error {
+	var hasRemovedAt bool
+	if err := db.QueryRow("SELECT COUNT(*) > 0 FROM pragma_table_info('media_files') WHERE name = 'removed_at'").Scan(&hasRemovedAt); err != nil {
+		return err
+	}
+	if hasRemovedAt {
+		return nil
+	}
+	_, err := db.Exec("ALTER TABLE media_files ADD COLUMN removed_at TIMESTAMP")
+	return err
+}
+
+// overMediaQuota is whether the downloaded files take more than a quota allows
+func (store *MessageStore) overMediaQuota() (bool, error) {
+	if store.mediaPolicy.Quota <= 0 && len(store.mediaPolicy.TypeQuota) == 0 {
+		return false, nil
+	}
+	rows, err := store.db.Query(
+		`SELECT COALESCE(m.media_type, ''), SUM(f.size) FROM media_files f
+		LEFT JOIN messages m ON m.id = f.message_id AND m.chat_jid = f.chat_jid
+		WHERE f.path IS NOT NULL GROUP BY 1`,
+	)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	var total int64
+	over := false
+	for rows.Next() {
+		var mediaType string
+		var size int64
+		if err := rows.Scan(&mediaType, &size); err != nil {
+			return false, err
+		}
+		if quota := store.mediaPolicy.TypeQuota[mediaType]; quota > 0 && size > quota {
+			over = true
+		}
+		total += size
+	}
+	if quota := store.mediaPolicy.Quota; quota > 0 && total > quota {
+		over = true
+	}
+	return over, rows.Err()
+}
+
+// downloadedMedia returns the files on disk, least recently downloaded first
+func (store *MessageStore) downloadedMedia() ([]RemovedMedia, error) {
+	rows, err := store.db.Query(
+		`SELECT f.message_id, f.chat_jid, COALESCE(m.media_type, ''), f.size, f.downloaded_at, f.path FROM media_files f
+		LEFT JOIN messages m ON m.id = f.message_id AND m.chat_jid = f.chat_jid
+		WHERE f.path IS NOT NULL ORDER BY f.downloaded_at`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []RemovedMedia
+	for rows.Next() {
+		var file RemovedMedia
+		if err := rows.Scan(&file.MessageID, &file.ChatJID, &file.MediaType, &file.Size, &file.DownloadedAt, &file.path); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// CleanMedia deletes the downloaded files the policy no longer keeps, and
+// those expiry covers. A dry run only lists them.
+func (store *MessageStore) CleanMedia(now time.Time, expiry mediaExpiry, dryRun bool) (MediaCleanup, error) {
+	store.mediaMu.Lock()
+	defer store.mediaMu.Unlock()
+	return store.cleanMedia(now, "", expiry, dryRun)
+}
+
+// cleanMedia deletes the files past their type's maximum age, then the least
+// recently downloaded of each type over its quota, then of all of them over
+// the overall quota. The file at keep stays. Their rows stay too, with the
+// path cleared, so the files can be downloaded again.
+func (store *MessageStore) cleanMedia(now time.Time, keep string, expiry mediaExpiry, dryRun bool) (MediaCleanup, error) {
+	files, err := store.downloadedMedia()
+	if err != nil {
+		return MediaCleanup{}, err
+	}
+
+	var result MediaCleanup
+	remove := func(file RemovedMedia, reason string) error {
+		file.Reason = reason
+		if dryRun {
+			result.Removed = append(result.Removed, file)
+		} else {
+			if err := os.Remove(file.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			if _, err := store.db.Exec(
+				"UPDATE media_files SET path = NULL, removed_at = ? WHERE message_id = ? AND chat_jid = ?",
+				now, file.MessageID, file.ChatJID,
+			); err != nil {
+				return err
+			}
+		}
+		if reason == mediaExpired {
+			result.Expired++
+		} else {
+			result.OverQuota++
+		}
+		result.FreedBytes += file.Size
+		return nil
+	}
+
+	var left []RemovedMedia
+	for _, file := range files {
+		maxAge := store.mediaPolicy.maxAge(file.MediaType)
+		if file.path != keep && ((maxAge > 0 && now.Sub(file.DownloadedAt) > maxAge) || expiry.covers(file)) {
+			if err := remove(file, mediaExpired); err != nil {
+				return result, err
+			}
+			continue
+		}
+		left = append(left, file)
+	}
+
+	// Each type's quota first, so the overall one deletes no more than needed
+	bytes := make(map[string]int64)
+	var total int64
+	for _, file := range left {
+		bytes[file.MediaType] += file.Size
+		total += file.Size
+	}
+	kept := left[:0]
+	for _, file := range left {
+		if quota := store.mediaPolicy.TypeQuota[file.MediaType]; file.path != keep && quota > 0 && bytes[file.MediaType] > quota {
+			if err := remove(file, mediaOverQuota); err != nil {
+				return result, err
+			}
+			bytes[file.MediaType] -= file.Size
+			total -= file.Size
+			continue
+		}
+		kept = append(kept, file)
+	}
+	for _, file := range kept {
+		if quota := store.mediaPolicy.Quota; file.path != keep && quota > 0 && total > quota {
+			if err := remove(file, mediaOverQuota); err != nil {
+				return result, err
+			}
+			total -= file.Size
+			continue
+		}
+		result.Files++
+	}
+	result.Bytes = total
+	return result, nil
+}
+
+// keepMediaWithinPolicy deletes the downloaded files the policy no longer
+// keeps, now and every mediaCleanupInterval, until ctx is done
+func keepMediaWithinPolicy(ctx context.Context, store *MessageStore) {
+	ticker := time.NewTicker(mediaCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		cleanup, err := store.CleanMedia(time.Now(), mediaExpiry{}, false)
+		if err != nil {
+			slog.Warn("Failed to clean up downloaded media", "error", err)
+		} else if cleanup.Expired > 0 || cleanup.OverQuota > 0 {
+			slog.Info("Cleaned up downloaded media", "expired", cleanup.Expired, "over_quota", cleanup.OverQuota,
+				"freed_bytes", cleanup.FreedBytes, "files", cleanup.Files, "bytes", cleanup.Bytes)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// MediaCleanupRequest is the body of POST /v1/media/cleanup
+type MediaCleanupRequest struct {
+	// DownloadedBefore also deletes the files downloaded before it, RFC 3339,
+	// whatever the policy says
+	DownloadedBefore string `json:"downloaded_before,omitempty"`
+	// Types limits DownloadedBefore to some media types
+	Types []string `json:"types,omitempty"`
+	// DryRun lists what would be deleted, without deleting it
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// registerMediaCleanupHandlers adds the endpoint cleaning up downloaded media
+func registerMediaCleanupHandlers(mux *http.ServeMux, store *MessageStore, jobManager *jobs.Manager) {
+	// POST /v1/media/cleanup - Delete the downloaded files the retention policy
+	// no longer keeps now, rather than at the next hourly cleanup, and
+	// optionally the ones downloaded before a time
+	mux.HandleFunc("POST /v1/media/cleanup", func(w http.ResponseWriter, r *http.Request) {
+		var req MediaCleanupRequest
+		var v validate.Validator
+		var expiry mediaExpiry
+		// The body is optional
+		if r.ContentLength != 0 && v.Decode(r, &req) {
+			if req.DownloadedBefore != "" {
+				expiry.before = v.Time("downloaded_before", req.DownloadedBefore)
+			} else if len(req.Types) > 0 {
+				v.Add("types", validate.CodeInvalidValue, "only applies with downloaded_before")
+			}
+			for _, mediaType := range req.Types {
+				v.OneOf("types", mediaType, config.MediaTypes...)
+			}
+			expiry.types = req.Types
+		}
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+
+		if jobs.Async(r) {
+			job, err := jobManager.Start(r.Context(), "media_cleanup", scheduler.RequestCreator(r), func(ctx context.Context, _ func(int, int)) (interface{}, error) {
+				cleanup, err := store.CleanMedia(time.Now(), expiry, req.DryRun)
+				if err != nil {
+					slog.ErrorContext(ctx, "Failed to clean up downloaded media", "error", err)
+					return nil, jobs.Fail(apierror.CodeInternal, "Failed to clean up downloaded media")
+				}
+				return map[string]interface{}{"success": true, "dry_run": req.DryRun, "cleanup": cleanup}, nil
+			})
+			if err != nil {
+				jobs.StartError(w, err)
+				return
+			}
+			jobs.Accepted(w, r, job)
+			return
+		}
+
+		cleanup, err := store.CleanMedia(time.Now(), expiry, req.DryRun)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to clean up downloaded media", "error", err)
+			apierror.Internal(w, "Failed to clean up downloaded media")
+			return
+		}
+		slog.InfoContext(r.Context(), "Cleaned up downloaded media", "dry_run", req.DryRun, "expired", cleanup.Expired,
+			"over_quota", cleanup.OverQuota, "freed_bytes", cleanup.FreedBytes)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"dry_run": req.DryRun,
+			"cleanup": cleanup,
+		})
+	})
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+
+	"whatsapp-client/config"
+)
+
+// maxMediaExtension is the longest extension, with its dot, a downloaded
+// file keeps from the name it was sent with
+const maxMediaExtension = 10
+
+// autoDownloadQueue is how many received files can wait to be downloaded.
+// Files that don't fit are left for downloading on demand.
+const autoDownloadQueue = 256
+
+// SetMediaStorage sets the directory downloaded media goes in and how much
+// of it is kept there, and for how long
+func (store *MessageStore) SetMediaStorage(dir string, policy MediaPolicy) {
+	store.mediaDir = dir
+	store.mediaPolicy = policy
+}
+
+// mediaChatDir is the directory a chat's downloaded media goes in
+func (store *MessageStore) mediaChatDir(chatJID string) string {
+	return filepath.Join(store.mediaDir, strings.ReplaceAll(chatJID, ":", "_"))
+}
+
+// mediaFileName is the name the media of a message is downloaded as: its ID,
+// with the extension of the file name it was sent with. Both come from the
+// sender, so anything but letters, digits, '-' and '_' is replaced and the
+// file stays in the chat's directory.
+func mediaFileName(messageID, filename string) string {
+	name := strings.Map(fileNameRune, messageID)
+	if ext := filepath.Ext(filename); len(ext) > 1 && len(ext) <= maxMediaExtension && strings.Map(fileNameRune, ext[1:]) == ext[1:] {
+		name += ext
+	}
+	return name
+}
+
+// fileNameRune keeps the ASCII letters and digits, '-' and '_' of a file
+// name and replaces anything else with '_'
+func fileNameRune(r rune) rune {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+		return r
+	}
+	return '_'
+}
+
+// GetMediaFile returns the local path the media of a message was downloaded to
+func (store *MessageStore) GetMediaFile(id, chatJID string) (string, error) {
+	var path string
+	err := store.db.QueryRow(
+		"SELECT path FROM media_files WHERE message_id = ? AND chat_jid = ? AND path IS NOT NULL",
+		id, chatJID,
+	).Scan(&path)
+	return path, err
+}
+
+// StoreMediaFile records where the media of a message was downloaded to
+func (store *MessageStore) StoreMediaFile(id, chatJID, path string, size int64, downloadedAt time.Time) error {
+	_, err := store.db.Exec(
+		`INSERT INTO media_files (message_id, chat_jid, path, size, downloaded_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (message_id, chat_jid) DO UPDATE SET path = excluded.path, size = excluded.size, downloaded_at = excluded.downloaded_at,
+			removed_at = NULL`,
+		id, chatJID, path, size, downloadedAt,
+	)
+	return err
+}
+
+// recordMediaFile records a downloaded file and deletes older ones to keep
+// within the quotas. Failing to do either doesn't fail the download.
+func (store *MessageStore) recordMediaFile(id, chatJID, path string, size int64) {
+	store.mediaMu.Lock()
+	defer store.mediaMu.Unlock()
+
+	if err := store.StoreMediaFile(id, chatJID, path, size, time.Now()); err != nil {
+		slog.Warn("Failed to record downloaded media", "message_id", id, "chat_jid", chatJID, "error", err)
+		return
+	}
+	if err := store.makeMediaRoom(path); err != nil {
+		slog.Warn("Failed to keep downloaded media within the quota", "error", err)
+	}
+}
+
+// makeMediaRoom deletes the least recently downloaded files until the rest
+// fit in the quotas. The file at keep, just downloaded, stays even when it is
+// larger than a quota by itself.
+func (store *MessageStore) makeMediaRoom(keep string) error {
+	over, err := store.overMediaQuota()
+	if err != nil || !over {
+		return err
+	}
+	cleanup, err := store.cleanMedia(time.Now(), keep, mediaExpiry{}, false)
+	if err != nil {
+		return err
+	}
+	if cleanup.OverQuota > 0 || cleanup.Expired > 0 {
+		slog.Info("Deleted downloaded media to stay within the quota", "files", cleanup.OverQuota, "expired", cleanup.Expired,
+			"bytes", cleanup.FreedBytes, "quota", store.mediaPolicy.Quota)
+	}
+	return nil
+}
+
+// autoDownload is a received file waiting to be downloaded
+type autoDownload struct {
+	messageID string
+	chatJID   string
+	mediaType string
+}
+
+// mediaAutoDownloader downloads the received media the configured policy
+// picks, one file at a time, so handling messages doesn't wait for it
+type mediaAutoDownloader struct {
+	client *whatsmeow.Client
+	store  *MessageStore
+	policy config.MediaConfig
+	queue  chan autoDownload
+}
+
+// startMediaAutoDownloader starts downloading received media as policy says
+func startMediaAutoDownloader(client *whatsmeow.Client, store *MessageStore, policy config.MediaConfig) *mediaAutoDownloader {
+	d := &mediaAutoDownloader{
+		client: client,
+		store:  store,
+		policy: policy,
+		queue:  make(chan autoDownload, autoDownloadQueue),
+	}
+	go d.run()
+	return d
+}
+
+// mediaTypes returns the media types downloaded in a chat: those of its own
+// rule, else those of its server's, else the default
+func (d *mediaAutoDownloader) mediaTypes(chatJID string) []string {
+	if types, ok := d.policy.AutoDownloadChats[chatJID]; ok {
+		return types
+	}
+	if i := strings.LastIndex(chatJID, "@"); i >= 0 {
+		if types, ok := d.policy.AutoDownloadChats[chatJID[i:]]; ok {
+			return types
+		}
+	}
+	return d.policy.AutoDownload
+}
+
+// consider queues the media of a received message when the policy wants it.
+// View-once media is left alone, as the sender meant it to be seen only once.
+func (d *mediaAutoDownloader) consider(messageID, chatJID, mediaType string, size uint64, viewOnce bool) {
+	if viewOnce || !slices.Contains(d.mediaTypes(chatJID), mediaType) {
+		return
+	}
+	if maxSize := uint64(d.policy.AutoDownloadMaxSizeMB) << 20; maxSize > 0 && size > maxSize {
+		slog.Debug("Not auto-downloading large media", "message_id", messageID, "chat_jid", chatJID, "size", size)
+		return
+	}
+	if quota := uint64(d.store.mediaPolicy.quota(mediaType)); quota > 0 && size > quota {
+		slog.Debug("Not auto-downloading media larger than the quota", "message_id", messageID, "chat_jid", chatJID, "size", size)
+		return
+	}
+
+	select {
+	case d.queue <- autoDownload{messageID: messageID, chatJID: chatJID, mediaType: mediaType}:
+	default:
+		slog.Warn("Auto-download queue is full, leaving media for download on demand", "message_id", messageID, "chat_jid", chatJID)
+	}
+}
+
+func (d *mediaAutoDownloader) run() {
+	for item := range d.queue {
+		if _, _, _, _, err := downloadMedia(d.client, d.store, item.messageID, item.chatJID); err != nil {
+			slog.Warn("Failed to auto-download media", "message_id", item.messageID, "chat_jid", item.chatJID, "media_type", item.mediaType, "error", err)
+		}
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/validate"
+)
+
+// Types of MessageEvent
+const (
+	eventEdit     = "edit"
+	eventRevoke   = "revoke"
+	eventReaction = "reaction"
+)
+
+// MessageEvent is something done to a message after it was sent: an edit, a
+// deletion for everyone, or a reaction added, changed or removed
+type MessageEvent struct {
+	// MessageID and ChatJID are of the message the event is about
+	MessageID string `json:"-"`
+	ChatJID   string `json:"-"`
+	Type      string `json:"type"`
+	// Sender is who edited, deleted or reacted, the user part of their JID
+	Sender string `json:"sender"`
+	// Content is the new text of an edit or the emoji of a reaction, empty
+	// for a reaction removed
+	Content   string    `json:"content,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	// Authorized is set on an edit or revoke already checked to be allowed,
+	// like a group admin deleting someone else's message. Others are only
+	// applied when they come from the message's sender.
+	Authorized bool `json:"-"`
+	// fromMe and senderAlt are what a live event says about its sender:
+	// whether it is the account, and the other JID it goes by
+	fromMe    bool
+	senderAlt string
+}
+
+// errNotSender is returned for an edit or revoke of a stored message by
+// someone other than its sender, which is dropped
+var errNotSender = errors.New("not from the message's sender")
+
+// senderUser is the user part of a sender as the store has it, a JID or
+// already its user part
+func senderUser(sender string) string {
+	user, _, _ := strings.Cut(sender, "@")
+	user, _, _ = strings.Cut(user, ":")
+	return user
+}
+
+// sentBy reports whether event comes from whoever sent msg
+func sentBy(msg *Message, event MessageEvent) bool {
+	if msg.IsFromMe && event.fromMe {
+		return true
+	}
+	sender := senderUser(msg.Sender)
+	return sender != "" && (sender == senderUser(event.Sender) || sender == senderUser(event.senderAlt))
+}
+
+// MessageRevocation is who deleted a message for everyone, and when
+type MessageRevocation struct {
+	RevokedBy string    `json:"revoked_by"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+// MessageReaction is someone's current reaction to a message
+type MessageReaction struct {
+	Sender    string    `json:"sender"`
+	Emoji     string    `json:"emoji"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// RecordMessageEvent adds an event to the log of its message and applies it:
+// an edit replaces the text, a revoke marks the message deleted and a reaction
+// updates the sender's. An edit or revoke must come from the message's sender
+// unless it is Authorized, and returns errNotSender otherwise; for a message
+// not stored yet that is checked, and the event applied, once it is. An event
+// already recorded is ignored.
+func (store *MessageStore) RecordMessageEvent(event MessageEvent) error {
+	var deferred bool
+	if (event.Type == eventEdit || event.Type == eventRevoke) && !event.Authorized {
+		msg, err := store.GetMessage(event.MessageID, event.ChatJID)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			deferred = true
+		case err != nil:
+			return err
+		case !sentBy(msg, event):
+			return errNotSender
+		}
+	}
+
+	result, err := store.db.Exec(
+		`INSERT INTO message_events (message_id, chat_jid, type, sender, content, timestamp) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING`,
+		event.MessageID, event.ChatJID, event.Type, event.Sender, event.Content, event.Timestamp,
+	)
+	if err != nil {
+		return err
+	}
+	if recorded, _ := result.RowsAffected(); recorded == 0 {
+		return nil
+	}
+	if deferred {
+		// In case the message was stored meanwhile
+		return store.reapplyEvents(event.MessageID, event.ChatJID)
+	}
+
+	switch event.Type {
+	case eventEdit:
+		err := store.EditMessage(event.MessageID, event.ChatJID, event.Content, event.Timestamp)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	case eventRevoke:
+		return store.StoreRevocation(event.MessageID, event.ChatJID, event.Sender, event.Timestamp)
+	case eventReaction:
+		return store.StoreReaction(event.MessageID, event.ChatJID, event.Sender, event.Content, event.Timestamp)
+	}
+	return nil
+}
+
+// reapplyEvents brings a message just stored up to date with the edits and
+// deletion in its log: those that arrived before it, and all of them when it
+// was stored again with the text it was sent with, as history syncs do. Those
+// not from its sender are dropped from the log, apart from deletions already
+// stored as they arrived.
+func (store *MessageStore) reapplyEvents(id, chatJID string) error {
+	msg, err := store.GetMessage(id, chatJID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	rows, err := store.db.Query(
+		`SELECT e.rowid, e.type, e.sender, e.content, e.timestamp, r.message_id IS NOT NULL
+		FROM message_events e
+		LEFT JOIN message_revocations r ON e.type = ? AND r.message_id = e.message_id AND r.chat_jid = e.chat_jid AND r.revoked_by = e.sender
+		WHERE e.message_id = ? AND e.chat_jid = ? AND e.type IN (?, ?) ORDER BY e.timestamp`,
+		eventRevoke, id, chatJID, eventEdit, eventRevoke,
+	)
+	if err != nil {
+		return err
+	}
+	var edits, revokes []MessageEvent
+	var dropped []int64
+	for rows.Next() {
+		var rowID int64
+		var event MessageEvent
+		var stored bool
+		if err := rows.Scan(&rowID, &event.Type, &event.Sender, &event.Content, &event.Timestamp, &stored); err != nil {
+			rows.Close()
+			return err
+		}
+		switch {
+		case stored:
+		case !sentBy(msg, event):
+			dropped = append(dropped, rowID)
+		case event.Type == eventRevoke:
+			revokes = append(revokes, event)
+		default:
+			edits = append(edits, event)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, rowID := range dropped {
+		slog.Warn("Dropping message event not from the message's sender", "message_id", id, "chat_jid", chatJID)
+		if _, err := store.db.Exec("DELETE FROM message_events WHERE rowid = ?", rowID); err != nil {
+			return err
+		}
+	}
+	for _, revoke := range revokes {
+		if err := store.StoreRevocation(id, chatJID, revoke.Sender, revoke.Timestamp); err != nil {
+			return err
+		}
+	}
+	if len(edits) == 0 {
+		return nil
+	}
+
+	// Edits already in the edit history are skipped
+	for _, edit := range edits {
+		if err := store.EditMessage(id, chatJID, edit.Content, edit.Timestamp); err != nil {
+			return err
+		}
+	}
+	_, err = store.db.Exec("UPDATE messages SET content = ? WHERE id = ? AND chat_jid = ? AND content != ?",
+		edits[len(edits)-1].Content, id, chatJID, edits[len(edits)-1].Content)
+	return err
+}
+
+// GetMessageEvents returns the event log of a message, oldest first
+func (store *MessageStore) GetMessageEvents(id, chatJID string) ([]MessageEvent, error) {
+	rows, err := store.db.Query(
+		"SELECT type, sender, content, timestamp FROM message_events WHERE message_id = ? AND chat_jid = ? ORDER BY timestamp, rowid",
+		id, chatJID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []MessageEvent{}
+	for rows.Next() {
+		event := MessageEvent{MessageID: id, ChatJID: chatJID}
+		if err := rows.Scan(&event.Type, &event.Sender, &event.Content, &event.Timestamp); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// GetMessageReactions returns everyone's current reactions to messages of a
+// chat, by message ID, oldest first
+func (store *MessageStore) GetMessageReactions(chatJID string, ids []string) (map[string][]MessageReaction, error) {
+	reactions := make(map[string][]MessageReaction)
+	if len(ids) == 0 {
+		return reactions, nil
+	}
+	query := "SELECT message_id, sender, emoji, timestamp FROM message_reactions WHERE chat_jid = ? AND message_id IN (?" +
+		strings.Repeat(", ?", len(ids)-1) + ") ORDER BY timestamp"
+	args := []interface{}{chatJID}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var reaction MessageReaction
+		if err := rows.Scan(&id, &reaction.Sender, &reaction.Emoji, &reaction.Timestamp); err != nil {
+			return nil, err
+		}
+		reactions[id] = append(reactions[id], reaction)
+	}
+	return reactions, rows.Err()
+}
+
+// historyEventSender is who sent a message of a history sync, by its key, as
+// the user part of their JID
+func historyEventSender(client *whatsmeow.Client, chat types.JID, key *waProto.MessageKey) string {
+	switch {
+	case key.GetFromMe():
+		if client.Store.ID != nil {
+			return client.Store.ID.User
+		}
+		return ""
+	case key.GetParticipant() != "":
+		if jid, err := types.ParseJID(key.GetParticipant()); err == nil {
+			return jid.User
+		}
+		return key.GetParticipant()
+	}
+	return chat.User
+}
+
+// historyMessageEvents are the events a message of a history sync carries:
+// the reactions to it and whether it was deleted for everyone, or the edit,
+// revoke or reaction it is itself
+func historyMessageEvents(client *whatsmeow.Client, chat types.JID, msg *waProto.WebMessageInfo) []MessageEvent {
+	var events []MessageEvent
+	sender := historyEventSender(client, chat, msg.GetKey())
+	timestamp := time.Unix(int64(msg.GetMessageTimestamp()), 0)
+
+	for _, reaction := range msg.GetReactions() {
+		reactedAt := timestamp
+		if ms := reaction.GetSenderTimestampMS(); ms > 0 {
+			reactedAt = time.UnixMilli(ms)
+		}
+		events = append(events, MessageEvent{
+			MessageID: msg.GetKey().GetID(),
+			ChatJID:   chat.String(),
+			Type:      eventReaction,
+			Sender:    historyEventSender(client, chat, reaction.GetKey()),
+			Content:   reaction.GetText(),
+			Timestamp: reactedAt,
+		})
+	}
+	if msg.GetMessageStubType() == waProto.WebMessageInfo_REVOKE {
+		// The message was replaced by the stub saying it was deleted, which
+		// WhatsApp only does for a deletion it allowed
+		events = append(events, MessageEvent{
+			MessageID:  msg.GetKey().GetID(),
+			ChatJID:    chat.String(),
+			Type:       eventRevoke,
+			Sender:     sender,
+			Timestamp:  timestamp,
+			Authorized: true,
+		})
+	}
+
+	message := msg.GetMessage()
+	switch {
+	case isEdit(message):
+		edit := message.GetProtocolMessage()
+		if ms := edit.GetTimestampMS(); ms > 0 {
+			timestamp = time.UnixMilli(ms)
+		}
+		events = append(events, MessageEvent{
+			MessageID: edit.GetKey().GetID(),
+			ChatJID:   chat.String(),
+			Type:      eventEdit,
+			Sender:    sender,
+			Content:   editedText(edit.GetEditedMessage()),
+			Timestamp: timestamp,
+		})
+	case isRevoke(message):
+		events = append(events, MessageEvent{
+			MessageID: message.GetProtocolMessage().GetKey().GetID(),
+			ChatJID:   chat.String(),
+			Type:      eventRevoke,
+			Sender:    sender,
+			Timestamp: timestamp,
+		})
+	case message.GetReactionMessage() != nil:
+		reaction := message.GetReactionMessage()
+		if ms := reaction.GetSenderTimestampMS(); ms > 0 {
+			timestamp = time.UnixMilli(ms)
+		}
+		events = append(events, MessageEvent{
+			MessageID: reaction.GetKey().GetID(),
+			ChatJID:   chat.String(),
+			Type:      eventReaction,
+			Sender:    sender,
+			Content:   reaction.GetText(),
+			Timestamp: timestamp,
+		})
+	}
+	return events
+}
+
+// storeHistoryEvents records the events a message of a history sync carries
+func storeHistoryEvents(client *whatsmeow.Client, store *MessageStore, chat types.JID, msg *waProto.WebMessageInfo) {
+	for _, event := range historyMessageEvents(client, chat, msg) {
+		if event.MessageID == "" {
+			continue
+		}
+		if err := store.RecordMessageEvent(event); err != nil {
+			slog.Warn("Failed to store history message event", "message_id", event.MessageID, "chat_jid", event.ChatJID, "type", event.Type, "error", err)
+		}
+	}
+}
+
+// registerMessageEventHandlers adds the endpoint reading the event log of a
+// message
+func registerMessageEventHandlers(mux *http.ServeMux, store *MessageStore) {
+	// GET /v1/messages/{chat}/{id}/events - The edits, deletion and reactions
+	// of a message, oldest first, with its current text. The log is also kept
+	// for messages the bridge hasn't stored.
+	mux.HandleFunc("GET /v1/messages/{chat}/{id}/events", func(w http.ResponseWriter, r *http.Request) {
+		var v validate.Validator
+		chat := v.Recipient("chat", r.PathValue("chat"))
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		chatJID, err := parseRecipient(chat)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+		messageID := r.PathValue("id")
+
+		msg, err := store.GetMessage(messageID, chatJID.String())
+		stored := err == nil
+		if errors.Is(err, sql.ErrNoRows) {
+			err = nil
+		}
+		var events []MessageEvent
+		if err == nil {
+			events, err = store.GetMessageEvents(messageID, chatJID.String())
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to read message events", "error", err)
+			apierror.Internal(w, "Failed to read message events")
+			return
+		}
+		if !stored && len(events) == 0 {
+			apierror.NotFound(w, "Message not found")
+			return
+		}
+
+		response := map[string]interface{}{
+			"success":    true,
+			"message_id": messageID,
+			"chat_jid":   chatJID.String(),
+			"stored":     stored,
+			"events":     events,
+		}
+		if stored {
+			response["content"] = msg.Content
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	})
+}
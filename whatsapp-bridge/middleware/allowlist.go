@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"net/netip"
+
+	"whatsapp-client/apierror"
+)
+
+// AllowIPs answers 403 to clients whose address is not in one of allowed, on
+// every route including the health checks. The address is the one connected to
+// the bridge, as for RateLimitByIP; forwarding headers are not trusted. An
+// empty allowed list disables the check. Requests over the unix socket pass,
+// since the socket's file permissions decide who can connect.
+func AllowIPs(allowed []netip.Prefix, next http.Handler) http.Handler {
+	if len(allowed) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if FromUnixSocket(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		addr, err := netip.ParseAddr(clientIP(r))
+		if err == nil {
+			// IPv4 clients of a dual-stack listener show up as ::ffff:a.b.c.d
+			addr = addr.Unmap()
+			for _, prefix := range allowed {
+				if prefix.Contains(addr) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+
+		slog.WarnContext(r.Context(), "Rejected request from address not in the allowlist", "client", clientIP(r), "path", r.URL.Path)
+		apierror.Write(w, http.StatusForbidden, apierror.CodeForbidden, "Client address not allowed")
+	})
+}
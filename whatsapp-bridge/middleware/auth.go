@@ -0,0 +1,100 @@
+// Package middleware holds the HTTP middleware wrapped around the bridge API.
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/config"
+	"whatsapp-client/scheduler"
+)
+
+// RequireAPIKey rejects requests to API routes (/v1/... and the legacy /api/...)
+// that don't carry one of keys, either as "Authorization: Bearer <key>" or in
+// the X-API-Key header, and answers 403 when the key lacks the scope the route
+// needs (see RequiredScope). The name of the matching key is attached to the
+// request with scheduler.WithCreator. Other routes are passed through untouched.
+func RequireAPIKey(keys []config.APIKey, next http.Handler) http.Handler {
+	match := MatchAPIKey(keys)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAPIPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		presented := requestAPIKey(r)
+		if presented == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="whatsapp-bridge"`)
+			apierror.Write(w, http.StatusUnauthorized, apierror.CodeUnauthorized, "API key required")
+			return
+		}
+
+		key, ok := match(presented)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="whatsapp-bridge", error="invalid_token"`)
+			apierror.Write(w, http.StatusUnauthorized, apierror.CodeUnauthorized, "Invalid API key")
+			return
+		}
+		if scope := RequiredScope(r.Method, r.URL.Path); !key.Allows(scope) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="whatsapp-bridge", error="insufficient_scope", scope=%q`, scope))
+			apierror.Write(w, http.StatusForbidden, apierror.CodeInsufficientScope, fmt.Sprintf("API key %q lacks the %s scope", key.Name, scope))
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), apiKeyNameKey{}, key.Name)
+		next.ServeHTTP(w, r.WithContext(scheduler.WithCreator(ctx, key.Name)))
+	})
+}
+
+// MatchAPIKey returns a function that finds the key a client presented among
+// keys, reporting false if it isn't one of them
+func MatchAPIKey(keys []config.APIKey) func(presented string) (config.APIKey, bool) {
+	// Compare fixed-size hashes so the comparison time doesn't depend on key length
+	hashes := make([][sha256.Size]byte, len(keys))
+	for i, key := range keys {
+		hashes[i] = sha256.Sum256([]byte(key.Key))
+	}
+
+	return func(presented string) (config.APIKey, bool) {
+		hash := sha256.Sum256([]byte(presented))
+		found := -1
+		for i := range hashes {
+			// Check every key so timing doesn't reveal which one matched
+			if subtle.ConstantTimeCompare(hash[:], hashes[i][:]) == 1 {
+				found = i
+			}
+		}
+		if found < 0 {
+			return config.APIKey{}, false
+		}
+		return keys[found], true
+	}
+}
+
+// apiKeyNameKey is the request context key holding the name of the API key used
+type apiKeyNameKey struct{}
+
+// APIKeyName returns the name of the API key that authenticated r, or "" when
+// the request wasn't authenticated
+func APIKeyName(r *http.Request) string {
+	name, _ := r.Context().Value(apiKeyNameKey{}).(string)
+	return name
+}
+
+// requestAPIKey returns the key from the Authorization or X-API-Key header
+func requestAPIKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		scheme, token, ok := strings.Cut(auth, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
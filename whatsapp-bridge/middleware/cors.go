@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/config"
+)
+
+// exposedHeaders are the response headers browser code may read
+var exposedHeaders = []string{VersionHeader, "Retry-After", "X-Trace-ID", apierror.RequestIDHeader}
+
+// CORS lets the configured browser origins call the API. It answers preflight
+// requests itself, before authentication, since browsers send them without
+// credentials. Requests from other origins get no CORS headers, so browsers
+// block them; non-browser clients are unaffected.
+func CORS(cfg config.CORSConfig, next http.Handler) http.Handler {
+	if len(cfg.AllowedOrigins) == 0 {
+		return next
+	}
+
+	anyOrigin := false
+	origins := make(map[string]bool, len(cfg.AllowedOrigins))
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			anyOrigin = true
+		}
+		origins[strings.TrimSuffix(strings.ToLower(origin), "/")] = true
+	}
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+	exposed := strings.Join(exposedHeaders, ", ")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		if !anyOrigin && !origins[strings.ToLower(origin)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Echo the origin instead of "*" so caches keyed on Vary stay correct
+		h.Set("Access-Control-Allow-Origin", origin)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", methods)
+			h.Set("Access-Control-Allow-Headers", headers)
+			h.Set("Access-Control-Max-Age", maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		h.Set("Access-Control-Expose-Headers", exposed)
+		next.ServeHTTP(w, r)
+	})
+}
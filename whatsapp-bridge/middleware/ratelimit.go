@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/config"
+)
+
+// bucketIdleTimeout is how long an unused bucket is kept before it is dropped
+const bucketIdleTimeout = 10 * time.Minute
+
+// limiter is a set of token buckets, one per client, refilled at rate tokens
+// per second up to burst
+type limiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(perMinute float64, burst int) *limiter {
+	return &limiter{
+		rate:    perMinute / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// allow takes a token from the bucket of client. When the bucket is empty it
+// returns false and how long until the next token is available.
+func (l *limiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > bucketIdleTimeout {
+		for name, b := range l.buckets {
+			if now.Sub(b.last) > bucketIdleTimeout {
+				delete(l.buckets, name)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// RateLimiter holds the per-address and per-key token buckets. Its limits can
+// be changed while the server runs.
+type RateLimiter struct {
+	mu    sync.Mutex
+	cfg   config.RateLimitConfig
+	byIP  *limiter
+	byKey *limiter
+}
+
+// NewRateLimiter creates a RateLimiter enforcing cfg
+func NewRateLimiter(cfg config.RateLimitConfig) *RateLimiter {
+	rl := &RateLimiter{}
+	rl.Update(cfg)
+	return rl
+}
+
+// Update switches to new limits. Buckets whose limit changed start over full.
+func (rl *RateLimiter) Update(cfg config.RateLimitConfig) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.byIP == nil || cfg.PerIP != rl.cfg.PerIP || cfg.Burst != rl.cfg.Burst {
+		rl.byIP = newLimiter(cfg.PerIP, cfg.Burst)
+	}
+	if rl.byKey == nil || cfg.PerKey != rl.cfg.PerKey || cfg.Burst != rl.cfg.Burst {
+		rl.byKey = newLimiter(cfg.PerKey, cfg.Burst)
+	}
+	rl.cfg = cfg
+}
+
+// ByIP limits API requests per client address. It runs before authentication,
+// so it also slows down clients guessing API keys.
+func (rl *RateLimiter) ByIP(next http.Handler) http.Handler {
+	return rateLimit(func() *limiter { return rl.current(&rl.byIP) }, clientIP, next)
+}
+
+// ByKey limits requests per API key. It must run after RequireAPIKey;
+// unauthenticated requests are not limited by it.
+func (rl *RateLimiter) ByKey(next http.Handler) http.Handler {
+	return rateLimit(func() *limiter { return rl.current(&rl.byKey) }, APIKeyName, next)
+}
+
+// current returns the limiter in *l, or nil when its limit is 0 (off)
+func (rl *RateLimiter) current(l **limiter) *limiter {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if (*l).rate <= 0 {
+		return nil
+	}
+	return *l
+}
+
+// rateLimit answers 429 with Retry-After once the bucket for clientOf(r) is empty
+func rateLimit(limiterOf func() *limiter, clientOf func(*http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := limiterOf()
+		client := clientOf(r)
+		if l == nil || client == "" || !isAPIPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if ok, wait := l.allow(client, time.Now()); !ok {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			apierror.Write(w, http.StatusTooManyRequests, apierror.CodeRateLimited,
+				"Too many requests, retry in "+strconv.Itoa(retryAfter)+"s")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the address of the client connected to the bridge, or
+// "unix" for clients of the unix socket, which have none
+func clientIP(r *http.Request) string {
+	if FromUnixSocket(r) {
+		return "unix"
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
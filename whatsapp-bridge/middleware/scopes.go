@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"whatsapp-client/config"
+)
+
+// routeScope is the scope needed for the routes under path, or at path
+// exactly when it doesn't end in "/". An empty method matches any method.
+type routeScope struct {
+	method string
+	path   string
+	scope  string
+}
+
+// routeScopes are the routes that need another scope than their method implies,
+// checked in order
+var routeScopes = []routeScope{
+	{"", "/v1/admin/", config.ScopeAdmin},
+	{"", "/v1/auth/", config.ScopeAdmin},
+	{"", "/v1/config", config.ScopeAdmin},
+	{"", "/v1/audit", config.ScopeAdmin},
+	{"", "/v1/webhooks/", config.ScopeAdmin},
+	{"", "/v1/scheduler/maintenance", config.ScopeAdmin},
+	{http.MethodPost, "/v1/media/cleanup", config.ScopeAdmin},
+	{"", "/v1/scheduled/import", config.ScopeAdmin},
+	// Downloading media saves it on the bridge host without changing anything in
+	// WhatsApp, and an export job only reads
+	{http.MethodPost, "/v1/download", config.ScopeRead},
+	{http.MethodPost, "/v1/scheduled/export", config.ScopeRead},
+}
+
+// RequiredScope returns the API key scope a request to path needs: admin for
+// settings, pairing, the audit log, webhooks, imports and maintenance, read for other GET and HEAD
+// requests, and schedule for the rest, which send or change messages. The path
+// must already be versioned.
+func RequiredScope(method, path string) string {
+	for _, route := range routeScopes {
+		if route.method != "" && route.method != method {
+			continue
+		}
+		if path == strings.TrimSuffix(route.path, "/") || (strings.HasSuffix(route.path, "/") && strings.HasPrefix(path, route.path)) {
+			return route.scope
+		}
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		return config.ScopeRead
+	}
+	return config.ScopeSchedule
+}
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+)
+
+// unixSocketKey marks the context of connections accepted on a unix socket
+type unixSocketKey struct{}
+
+// MarkUnixSocket is an http.Server ConnContext that marks the requests of
+// connections accepted on a unix socket, so FromUnixSocket can tell them apart
+func MarkUnixSocket(ctx context.Context, c net.Conn) context.Context {
+	if _, ok := c.LocalAddr().(*net.UnixAddr); ok {
+		return context.WithValue(ctx, unixSocketKey{}, true)
+	}
+	return ctx
+}
+
+// FromUnixSocket reports whether r arrived over the unix socket rather than TCP
+func FromUnixSocket(r *http.Request) bool {
+	unix, _ := r.Context().Value(unixSocketKey{}).(bool)
+	return unix
+}
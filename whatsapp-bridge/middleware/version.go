@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"whatsapp-client/apierror"
+)
+
+// LegacyPrefix is the unversioned path prefix the API used before /v1. It stays
+// an alias for LegacyVersion so existing clients keep working.
+const LegacyPrefix = "/api/"
+
+// LegacyVersion is the version served under LegacyPrefix when the client
+// doesn't ask for another one
+const LegacyVersion = "v1"
+
+// VersionHeader lets clients of the legacy prefix pick a version, and reports
+// the version that served each API response
+const VersionHeader = "X-API-Version"
+
+// APIVersions negotiates the API version of each request. Versioned paths
+// (/v1/...) must name one of supported. Legacy /api/... paths are served by the
+// version in the X-API-Version header, or LegacyVersion, by rewriting them to
+// the versioned path, so next only has to route /vN/ paths. Other paths are
+// passed through untouched.
+func APIVersions(supported []string, next http.Handler) http.Handler {
+	known := make(map[string]bool, len(supported))
+	for _, v := range supported {
+		known[v] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version, ok := pathVersion(r.URL.Path)
+		if !ok {
+			rest, legacy := strings.CutPrefix(r.URL.Path, LegacyPrefix)
+			if !legacy {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			version = LegacyVersion
+			if requested := strings.ToLower(strings.TrimSpace(r.Header.Get(VersionHeader))); requested != "" {
+				version = requested
+			}
+			if known[version] {
+				r = withPath(r, "/"+version+"/"+rest)
+			}
+		}
+
+		if !known[version] {
+			apierror.Write(w, http.StatusNotFound, apierror.CodeUnsupportedVersion,
+				"Unsupported API version "+version+", supported: "+strings.Join(supported, ", "))
+			return
+		}
+
+		w.Header().Set(VersionHeader, version)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isAPIPath reports whether path belongs to the API, under a version or the legacy prefix
+func isAPIPath(path string) bool {
+	_, ok := pathVersion(path)
+	return ok || strings.HasPrefix(path, LegacyPrefix)
+}
+
+// pathVersion returns the version of a /vN/... path
+func pathVersion(path string) (string, bool) {
+	segment, _, found := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if !found || len(segment) < 2 || segment[0] != 'v' {
+		return "", false
+	}
+	for _, c := range segment[1:] {
+		if c < '0' || c > '9' {
+			return "", false
+		}
+	}
+	return segment, true
+}
+
+// withPath returns a shallow copy of r addressed to path
+func withPath(r *http.Request, path string) *http.Request {
+	r2 := new(http.Request)
+	*r2 = *r
+	u := *r.URL
+	u.Path = path
+	u.RawPath = ""
+	r2.URL = &u
+	return r2
+}
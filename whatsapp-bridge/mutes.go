@@ -0,0 +1,200 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/appstate"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/audit"
+	"whatsapp-client/validate"
+)
+
+// muteDurations are how long WhatsApp apps mute a chat for, by the names the
+// API takes. 0 mutes it until it is unmuted.
+var muteDurations = map[string]time.Duration{
+	"8h":     8 * time.Hour,
+	"1w":     7 * 24 * time.Hour,
+	"always": 0,
+}
+
+// muteForever is the mute end WhatsApp syncs for a chat muted until unmuted
+const muteForever = -1
+
+// MuteRequest is the body of PUT /v1/chats/{jid}/mute
+type MuteRequest struct {
+	// Duration is how long the chat stays muted: 8h, 1w or always
+	Duration string `json:"duration"`
+}
+
+// ChatMute is whether a chat is muted, and until when
+type ChatMute struct {
+	Muted bool `json:"muted"`
+	// MutedUntil is nil for a chat muted until it is unmuted
+	MutedUntil *time.Time `json:"muted_until,omitempty"`
+}
+
+// StoreMute records a chat's mute setting. The chat is muted until mutedUntil,
+// or until unmuted when mutedUntil is nil. A setting older than the one
+// stored is ignored.
+func (store *MessageStore) StoreMute(chatJID string, muted bool, mutedUntil *time.Time, setAt time.Time) error {
+	_, err := store.db.Exec(
+		`INSERT INTO chat_mutes (chat_jid, muted, muted_until, set_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (chat_jid) DO UPDATE SET muted = excluded.muted, muted_until = excluded.muted_until, set_at = excluded.set_at
+		WHERE excluded.set_at >= chat_mutes.set_at`,
+		chatJID, muted, mutedUntil, setAt,
+	)
+	return err
+}
+
+// GetMute returns whether a chat is muted at now. A chat the bridge knows no
+// setting for isn't muted.
+func (store *MessageStore) GetMute(chatJID string, now time.Time) (ChatMute, error) {
+	var mute ChatMute
+	var mutedUntil sql.NullTime
+	err := store.db.QueryRow("SELECT muted, muted_until FROM chat_mutes WHERE chat_jid = ?", chatJID).Scan(&mute.Muted, &mutedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ChatMute{}, nil
+	}
+	if err != nil {
+		return ChatMute{}, err
+	}
+	if mutedUntil.Valid {
+		if !mutedUntil.Time.After(now) {
+			return ChatMute{}, nil
+		}
+		mute.MutedUntil = &mutedUntil.Time
+	}
+	return mute, nil
+}
+
+// handleMute records a chat muted or unmuted from another device, or from the
+// bridge as WhatsApp syncs it back
+func handleMute(store *MessageStore, mute *events.Mute) {
+	var mutedUntil *time.Time
+	if end := mute.Action.GetMuteEndTimestamp(); mute.Action.GetMuted() && end > 0 {
+		until := time.UnixMilli(end)
+		mutedUntil = &until
+	}
+	if err := store.StoreMute(mute.JID.String(), mute.Action.GetMuted(), mutedUntil, mute.Timestamp); err != nil {
+		slog.Warn("Failed to store mute", "chat_jid", mute.JID.String(), "error", err)
+	}
+}
+
+// registerMuteHandlers adds the endpoints for muting and unmuting chats
+func registerMuteHandlers(mux *http.ServeMux, client *whatsmeow.Client, store *MessageStore) {
+	// mute serves PUT, which mutes a chat, and DELETE, which unmutes it
+	mute := func(w http.ResponseWriter, r *http.Request) {
+		var req MuteRequest
+		var v validate.Validator
+		chat := v.Recipient("jid", r.PathValue("jid"))
+		muted := r.Method == http.MethodPut
+		if muted && v.Decode(r, &req) {
+			if v.Required("duration", req.Duration) {
+				v.OneOf("duration", req.Duration, "8h", "1w", "always")
+			}
+		}
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		chatJID, err := parseRecipient(chat)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+
+		if !client.IsConnected() {
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not connected to WhatsApp")
+			return
+		}
+
+		// Mutes are app state, which every device of the account syncs
+		setAt := time.Now()
+		var mutedUntil *time.Time
+		var end *int64
+		if muted {
+			end = proto.Int64(muteForever)
+			if duration := muteDurations[req.Duration]; duration > 0 {
+				until := setAt.Add(duration)
+				mutedUntil = &until
+				end = proto.Int64(until.UnixMilli())
+			}
+		}
+		what := "mute"
+		if !muted {
+			what = "unmute"
+		}
+		if err := client.SendAppState(r.Context(), appstate.BuildMuteAbs(chatJID, muted, end)); err != nil {
+			slog.WarnContext(r.Context(), "Failed to mute chat", "chat_jid", chatJID, "muted", muted, "error", err)
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, "Failed to "+what+" chat: "+err.Error())
+			return
+		}
+		slog.InfoContext(r.Context(), "Muted chat", "chat_jid", chatJID, "muted", muted, "duration", req.Duration)
+		audit.SetResource(r.Context(), chatJID.String())
+
+		if err := store.StoreMute(chatJID.String(), muted, mutedUntil, setAt); err != nil {
+			slog.WarnContext(r.Context(), "Failed to store mute", "chat_jid", chatJID, "error", err)
+		}
+
+		response := map[string]interface{}{
+			"success":  true,
+			"chat_jid": chatJID.String(),
+			"muted":    muted,
+		}
+		if mutedUntil != nil {
+			response["muted_until"] = *mutedUntil
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+
+	// PUT /v1/chats/{jid}/mute - Mute a chat on all the account's devices for
+	// 8h, 1w or always, until it is unmuted
+	mux.HandleFunc("PUT /v1/chats/{jid}/mute", mute)
+	// DELETE /v1/chats/{jid}/mute - Unmute a chat
+	mux.HandleFunc("DELETE /v1/chats/{jid}/mute", mute)
+
+	// GET /v1/chats/{jid}/mute - Whether a chat is muted, and until when
+	mux.HandleFunc("GET /v1/chats/{jid}/mute", func(w http.ResponseWriter, r *http.Request) {
+		var v validate.Validator
+		chat := v.Recipient("jid", r.PathValue("jid"))
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		chatJID, err := parseRecipient(chat)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+
+		mute, err := store.GetMute(chatJID.String(), time.Now())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to get mute", "chat_jid", chatJID, "error", err)
+			apierror.Internal(w, "Failed to get mute")
+			return
+		}
+
+		response := map[string]interface{}{
+			"success":  true,
+			"chat_jid": chatJID.String(),
+			"muted":    mute.Muted,
+		}
+		if mute.MutedUntil != nil {
+			response["muted_until"] = *mute.MutedUntil
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	})
+}
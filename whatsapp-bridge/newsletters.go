@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/audit"
+	"whatsapp-client/validate"
+)
+
+// Bounds of how many posts one POST /v1/newsletters/{jid}/sync fetches
+const (
+	defaultNewsletterSync = 50
+	maxNewsletterSync     = 100
+)
+
+// newsletterInviteURL is the start of a channel's invite link
+const newsletterInviteURL = "https://whatsapp.com/channel/"
+
+// Newsletter is a WhatsApp channel the account follows or runs
+type Newsletter struct {
+	JID         string    `json:"jid"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Subscribers int       `json:"subscribers"`
+	Verified    bool      `json:"verified"`
+	InviteLink  string    `json:"invite_link,omitempty"`
+	State       string    `json:"state"`
+	CreatedAt   time.Time `json:"created_at"`
+	// Role is the account's role in the channel: owner, admin, subscriber or
+	// guest. Only owners and admins can post.
+	Role  string `json:"role"`
+	Muted bool   `json:"muted"`
+}
+
+// NewsletterPost is a post in a channel, with its view and reaction counts
+// when it was fetched
+type NewsletterPost struct {
+	ID        string         `json:"id"`
+	ServerID  int            `json:"server_id"`
+	Timestamp time.Time      `json:"timestamp"`
+	Content   string         `json:"content"`
+	MediaType string         `json:"media_type,omitempty"`
+	Views     int            `json:"views"`
+	Reactions map[string]int `json:"reactions"`
+}
+
+// SyncNewsletterRequest is the optional body of POST /v1/newsletters/{jid}/sync
+type SyncNewsletterRequest struct {
+	// Count is how many posts to fetch, defaultNewsletterSync if unset
+	Count int `json:"count,omitempty"`
+	// Before fetches the posts older than the one with this server_id, to page
+	// back through a channel; the newest are fetched otherwise
+	Before int `json:"before,omitempty"`
+}
+
+// validate checks every field of the request and fills in the default count
+func (req *SyncNewsletterRequest) validate(v *validate.Validator) {
+	v.NotNegative("before", req.Before)
+	if req.Count == 0 {
+		req.Count = defaultNewsletterSync
+	}
+	if req.Count < 0 || req.Count > maxNewsletterSync {
+		v.Add("count", validate.CodeInvalidValue, fmt.Sprintf("must be between 1 and %d", maxNewsletterSync))
+	}
+}
+
+// newsletterFromMetadata is the channel WhatsApp describes in meta
+func newsletterFromMetadata(meta *types.NewsletterMetadata) Newsletter {
+	newsletter := Newsletter{
+		JID:         meta.ID.String(),
+		Name:        meta.ThreadMeta.Name.Text,
+		Description: meta.ThreadMeta.Description.Text,
+		Subscribers: meta.ThreadMeta.SubscriberCount,
+		Verified:    meta.ThreadMeta.VerificationState == types.NewsletterVerificationStateVerified,
+		State:       string(meta.State.Type),
+		CreatedAt:   meta.ThreadMeta.CreationTime.Time,
+	}
+	if meta.ThreadMeta.InviteCode != "" {
+		newsletter.InviteLink = newsletterInviteURL + meta.ThreadMeta.InviteCode
+	}
+	if meta.ViewerMeta != nil {
+		newsletter.Role = string(meta.ViewerMeta.Role)
+		newsletter.Muted = meta.ViewerMeta.Mute == types.NewsletterMuteOn
+	}
+	return newsletter
+}
+
+// canPost reports whether the account can post in the channel
+func (newsletter Newsletter) canPost() bool {
+	return newsletter.Role == string(types.NewsletterRoleOwner) || newsletter.Role == string(types.NewsletterRoleAdmin)
+}
+
+// StoreNewsletterPost records the server ID and counts of a channel post whose
+// message is stored
+func (store *MessageStore) StoreNewsletterPost(chatJID string, post NewsletterPost, fetchedAt time.Time) error {
+	reactions, err := json.Marshal(post.Reactions)
+	if err != nil {
+		return err
+	}
+	_, err = store.db.Exec(
+		`INSERT INTO newsletter_posts (message_id, chat_jid, server_id, views, reactions, fetched_at) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (message_id, chat_jid) DO UPDATE SET
+			server_id = excluded.server_id, views = excluded.views, reactions = excluded.reactions, fetched_at = excluded.fetched_at`,
+		post.ID, chatJID, post.ServerID, post.Views, string(reactions), fetchedAt,
+	)
+	return err
+}
+
+// syncNewsletter fetches posts of a channel and stores them like messages in
+// any other chat. Posts are stored as the account's own in channels it can
+// post in. The posts come back newest first.
+func syncNewsletter(client *whatsmeow.Client, store *MessageStore, newsletter Newsletter, jid types.JID, req SyncNewsletterRequest) ([]NewsletterPost, error) {
+	messages, err := client.GetNewsletterMessages(jid, &whatsmeow.GetNewsletterMessagesParams{
+		Count:  req.Count,
+		Before: types.MessageServerID(req.Before),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	chatJID := jid.String()
+	fetchedAt := time.Now()
+	// Paging back mustn't make the chat look older than it is
+	if req.Before == 0 && len(messages) > 0 {
+		latest := messages[0].Timestamp
+		for _, msg := range messages {
+			if msg.Timestamp.After(latest) {
+				latest = msg.Timestamp
+			}
+		}
+		if err := store.StoreChat(chatJID, newsletter.Name, latest); err != nil {
+			slog.Warn("Failed to store chat", "chat_jid", chatJID, "error", err)
+		}
+	}
+
+	posts := make([]NewsletterPost, 0, len(messages))
+	for _, msg := range messages {
+		if msg.Message == nil {
+			continue
+		}
+		post := NewsletterPost{
+			ID:        msg.MessageID,
+			ServerID:  msg.MessageServerID,
+			Timestamp: msg.Timestamp,
+			Content:   extractTextContent(msg.Message),
+			Views:     msg.ViewsCount,
+			Reactions: msg.ReactionCounts,
+		}
+		if post.Reactions == nil {
+			post.Reactions = map[string]int{}
+		}
+		mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength := extractMediaInfo(msg.Message)
+		post.MediaType = mediaType
+
+		err := store.StoreMessage(post.ID, chatJID, jid.User, post.Content, post.Timestamp, newsletter.canPost(),
+			mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength)
+		if err == nil {
+			err = store.StoreNewsletterPost(chatJID, post, fetchedAt)
+		}
+		if err != nil {
+			slog.Warn("Failed to store channel post", "message_id", post.ID, "chat_jid", chatJID, "error", err)
+		}
+		posts = append(posts, post)
+	}
+	slices.SortFunc(posts, func(a, b NewsletterPost) int { return b.ServerID - a.ServerID })
+	return posts, nil
+}
+
+// uploadFor uploads media to send to chat. Channel posts aren't end-to-end
+// encrypted, so channels have an upload of their own, and the message must
+// carry the handle it returns.
+func uploadFor(ctx context.Context, client *whatsmeow.Client, chat types.JID, data []byte, mediaType whatsmeow.MediaType) (whatsmeow.UploadResponse, []whatsmeow.SendRequestExtra, error) {
+	if chat.Server != types.NewsletterServer {
+		resp, err := client.Upload(ctx, data, mediaType)
+		return resp, nil, err
+	}
+	resp, err := client.UploadNewsletter(ctx, data, mediaType)
+	return resp, []whatsmeow.SendRequestExtra{{MediaHandle: resp.Handle}}, err
+}
+
+// registerNewsletterHandlers adds the endpoints for reading WhatsApp
+// channels. Posting goes through the usual send and schedule endpoints.
+func registerNewsletterHandlers(mux *http.ServeMux, client *whatsmeow.Client, store *MessageStore) {
+	// GET /v1/newsletters - The channels the account follows or runs
+	mux.HandleFunc("GET /v1/newsletters", func(w http.ResponseWriter, r *http.Request) {
+		if !client.IsConnected() {
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not connected to WhatsApp")
+			return
+		}
+		subscribed, err := client.GetSubscribedNewsletters()
+		if err != nil {
+			slog.WarnContext(r.Context(), "Failed to get followed channels", "error", err)
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, "Failed to get followed channels: "+err.Error())
+			return
+		}
+		newsletters := make([]Newsletter, 0, len(subscribed))
+		for _, meta := range subscribed {
+			newsletters = append(newsletters, newsletterFromMetadata(meta))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":     true,
+			"newsletters": newsletters,
+		})
+	})
+
+	// POST /v1/newsletters/{jid}/sync - Fetch posts of a channel from WhatsApp
+	// into the message store, newest first unless the body pages back with
+	// before. Posts already stored get their latest counts.
+	mux.HandleFunc("POST /v1/newsletters/{jid}/sync", func(w http.ResponseWriter, r *http.Request) {
+		var req SyncNewsletterRequest
+		var v validate.Validator
+		v.JID("jid", r.PathValue("jid"))
+		jid, _ := types.ParseJID(r.PathValue("jid"))
+		if !v.Failed("jid") && jid.Server != types.NewsletterServer {
+			v.Add("jid", validate.CodeInvalidFormat, "must be a channel JID, ending in @"+types.NewsletterServer)
+		}
+		// The body is optional
+		if r.ContentLength != 0 {
+			v.Decode(r, &req)
+		}
+		req.validate(&v)
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+
+		if !client.IsConnected() {
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not connected to WhatsApp")
+			return
+		}
+		meta, err := client.GetNewsletterInfo(jid)
+		if err != nil {
+			slog.WarnContext(r.Context(), "Failed to get channel info", "chat_jid", jid, "error", err)
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, "Failed to get channel info: "+err.Error())
+			return
+		}
+		if meta == nil {
+			apierror.NotFound(w, "Channel not found")
+			return
+		}
+		newsletter := newsletterFromMetadata(meta)
+
+		posts, err := syncNewsletter(client, store, newsletter, jid, req)
+		if err != nil {
+			slog.WarnContext(r.Context(), "Failed to fetch channel posts", "chat_jid", jid, "error", err)
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, "Failed to fetch channel posts: "+err.Error())
+			return
+		}
+		slog.InfoContext(r.Context(), "Synced channel posts", "chat_jid", jid, "count", len(posts))
+		audit.SetResource(r.Context(), jid.String())
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    true,
+			"newsletter": newsletter,
+			"posts":      posts,
+		})
+	})
+}
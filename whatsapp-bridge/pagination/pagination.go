@@ -0,0 +1,89 @@
+// Package pagination implements the cursor pagination shared by the API's list
+// endpoints. A request takes ?limit=N and the ?cursor= returned as next_cursor
+// by the previous page. Cursors are opaque to clients: they encode the sort key
+// of the last item returned, so pages stay consistent while items are added.
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// DefaultLimit is the page size when the request doesn't set one
+	DefaultLimit = 100
+	// MaxLimit is the largest page size accepted
+	MaxLimit = 1000
+)
+
+// ErrInvalidCursor is returned for a cursor this API didn't produce
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Params are the pagination parameters of a request
+type Params struct {
+	// Limit is the page size
+	Limit int
+	// Cursor is the raw cursor, empty for the first page
+	Cursor string
+}
+
+// FromRequest reads the limit and cursor query parameters
+func FromRequest(r *http.Request) (Params, error) {
+	return FromRequestLimit(r, DefaultLimit)
+}
+
+// FromRequestLimit is FromRequest for an endpoint whose page size defaults to
+// defaultLimit instead of DefaultLimit
+func FromRequestLimit(r *http.Request, defaultLimit int) (Params, error) {
+	query := r.URL.Query()
+	p := Params{Limit: defaultLimit, Cursor: query.Get("cursor")}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			return p, fmt.Errorf("limit must be between 1 and %d", MaxLimit)
+		}
+		p.Limit = limit
+	}
+	return p, nil
+}
+
+// Decode reads the cursor into v. It reports false for the first page, when
+// there is no cursor.
+func (p Params) Decode(v interface{}) (bool, error) {
+	if p.Cursor == "" {
+		return false, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(p.Cursor)
+	if err != nil {
+		return false, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, ErrInvalidCursor
+	}
+	return true, nil
+}
+
+// Encode turns a sort key into a cursor
+func Encode(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		// Sort keys are plain structs; this can't happen
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Page cuts items, fetched with a limit of p.Limit+1, down to p.Limit and
+// returns the cursor of the next page, or "" when this is the last one
+func Page[T any](items []T, p Params, key func(T) interface{}) ([]T, string) {
+	if len(items) <= p.Limit {
+		return items, ""
+	}
+	items = items[:p.Limit]
+	return items, Encode(key(items[len(items)-1]))
+}
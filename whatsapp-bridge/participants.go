@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/validate"
+)
+
+// GroupParticipant is a member of a group
+type GroupParticipant struct {
+	JID string `json:"jid"`
+	// PhoneNumber and LID are the member's two JIDs, when WhatsApp tells them;
+	// JID is always one of them
+	PhoneNumber string `json:"phone_number,omitempty"`
+	LID         string `json:"lid,omitempty"`
+	// Name is the member's name in the account's address book, else the name
+	// they gave themselves
+	Name         string `json:"name,omitempty"`
+	IsAdmin      bool   `json:"is_admin"`
+	IsSuperAdmin bool   `json:"is_super_admin"`
+	// JoinedAt is when the member joined or was added, for those the bridge
+	// saw join
+	JoinedAt *time.Time `json:"joined_at,omitempty"`
+}
+
+// StoreGroupJoins records when members joined a group
+func (store *MessageStore) StoreGroupJoins(chatJID string, participants []types.JID, joinedAt time.Time) error {
+	for _, participant := range participants {
+		_, err := store.db.Exec(
+			"INSERT OR REPLACE INTO group_joins (chat_jid, participant, joined_at) VALUES (?, ?, ?)",
+			chatJID, participant.ToNonAD().String(), joinedAt,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteGroupJoins forgets when members who left a group joined it
+func (store *MessageStore) DeleteGroupJoins(chatJID string, participants []types.JID) error {
+	for _, participant := range participants {
+		_, err := store.db.Exec(
+			"DELETE FROM group_joins WHERE chat_jid = ? AND participant = ?",
+			chatJID, participant.ToNonAD().String(),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetGroupJoins returns when the members of a group the bridge saw join did,
+// by JID
+func (store *MessageStore) GetGroupJoins(chatJID string) (map[string]time.Time, error) {
+	rows, err := store.db.Query("SELECT participant, joined_at FROM group_joins WHERE chat_jid = ?", chatJID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	joins := make(map[string]time.Time)
+	for rows.Next() {
+		var participant string
+		var joinedAt time.Time
+		if err := rows.Scan(&participant, &joinedAt); err != nil {
+			return nil, err
+		}
+		joins[participant] = joinedAt
+	}
+	return joins, rows.Err()
+}
+
+// handleGroupJoins records when members join a group, and forgets it when
+// they leave
+func handleGroupJoins(store *MessageStore, info *events.GroupInfo) {
+	chatJID := info.JID.String()
+	if len(info.Join) > 0 {
+		if err := store.StoreGroupJoins(chatJID, info.Join, info.Timestamp); err != nil {
+			slog.Warn("Failed to store group joins", "chat_jid", chatJID, "error", err)
+		}
+	}
+	if len(info.Leave) > 0 {
+		if err := store.DeleteGroupJoins(chatJID, info.Leave); err != nil {
+			slog.Warn("Failed to forget group joins", "chat_jid", chatJID, "error", err)
+		}
+	}
+}
+
+// participantName is the name the account knows a group member by: the one
+// in its address book, else the one they gave themselves
+func participantName(ctx context.Context, client *whatsmeow.Client, participant types.GroupParticipant) string {
+	for _, jid := range []types.JID{participant.PhoneNumber, participant.LID, participant.JID} {
+		if jid.IsEmpty() {
+			continue
+		}
+		contact, err := client.Store.Contacts.GetContact(ctx, jid)
+		if err != nil || !contact.Found {
+			continue
+		}
+		for _, name := range []string{contact.FullName, contact.BusinessName, contact.PushName} {
+			if name != "" {
+				return name
+			}
+		}
+	}
+	return participant.DisplayName
+}
+
+// registerParticipantHandlers adds the endpoint listing the members of a group
+func registerParticipantHandlers(mux *http.ServeMux, client *whatsmeow.Client, store *MessageStore) {
+	// GET /v1/groups/{jid}/participants - The members of a group, admins
+	// first, fetched from WhatsApp. The stored group is updated with what
+	// comes back.
+	mux.HandleFunc("GET /v1/groups/{jid}/participants", func(w http.ResponseWriter, r *http.Request) {
+		var v validate.Validator
+		jid := groupJID(&v, "jid", r.PathValue("jid"))
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+
+		if !client.IsConnected() {
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not connected to WhatsApp")
+			return
+		}
+
+		info, err := client.GetGroupInfo(jid)
+		switch {
+		case errors.Is(err, whatsmeow.ErrNotInGroup), errors.Is(err, whatsmeow.ErrGroupNotFound):
+			apierror.NotFound(w, "Not a group the account is in: "+jid.String())
+			return
+		case err != nil:
+			slog.WarnContext(r.Context(), "Failed to get group info", "chat_jid", jid, "error", err)
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, "Failed to get group info: "+err.Error())
+			return
+		}
+		if err := store.StoreGroup(client, info, time.Now()); err != nil {
+			slog.WarnContext(r.Context(), "Failed to store group", "chat_jid", jid, "error", err)
+		}
+		joins, err := store.GetGroupJoins(jid.String())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to get group joins", "chat_jid", jid, "error", err)
+			apierror.Internal(w, "Failed to get group joins")
+			return
+		}
+
+		participants := make([]GroupParticipant, 0, len(info.Participants))
+		for _, member := range info.Participants {
+			participant := GroupParticipant{
+				JID:          member.JID.String(),
+				Name:         participantName(r.Context(), client, member),
+				IsAdmin:      member.IsAdmin,
+				IsSuperAdmin: member.IsSuperAdmin,
+			}
+			if !member.PhoneNumber.IsEmpty() {
+				participant.PhoneNumber = member.PhoneNumber.String()
+			}
+			if !member.LID.IsEmpty() {
+				participant.LID = member.LID.String()
+			}
+			// A join is stored under whichever JID the notification used
+			for _, key := range []string{participant.JID, participant.PhoneNumber, participant.LID} {
+				if joinedAt, ok := joins[key]; ok && key != "" {
+					participant.JoinedAt = &joinedAt
+					break
+				}
+			}
+			participants = append(participants, participant)
+		}
+		slices.SortStableFunc(participants, func(a, b GroupParticipant) int {
+			if a.IsAdmin != b.IsAdmin {
+				if a.IsAdmin {
+					return -1
+				}
+				return 1
+			}
+			return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":      true,
+			"group_jid":    jid.String(),
+			"name":         info.Name,
+			"participants": participants,
+		})
+	})
+}
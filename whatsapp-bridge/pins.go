@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/audit"
+	bridgeevents "whatsapp-client/events"
+	"whatsapp-client/validate"
+)
+
+// pinDurations are how long WhatsApp apps keep a message pinned, by the
+// names the API takes
+var pinDurations = map[string]time.Duration{
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+}
+
+// defaultPinDuration is how long a message stays pinned when the request, or
+// a pin from another device, doesn't say
+const defaultPinDuration = "7d"
+
+// PinRequest is the body of POST /v1/messages/{chat}/{id}/pin, and the
+// optional body of DELETE on the same path
+type PinRequest struct {
+	// Duration is how long the message stays pinned: 24h, 7d or 30d
+	Duration string `json:"duration,omitempty"`
+	// Sender is who sent the message, for one the bridge hasn't stored
+	Sender string `json:"sender,omitempty"`
+}
+
+// PinnedMessage is a message pinned in a chat
+type PinnedMessage struct {
+	MessageID string `json:"message_id"`
+	// Sender, Content and MediaType are empty for a message the bridge
+	// hasn't stored
+	Sender    string    `json:"sender,omitempty"`
+	Content   string    `json:"content"`
+	MediaType string    `json:"media_type,omitempty"`
+	PinnedBy  string    `json:"pinned_by"`
+	PinnedAt  time.Time `json:"pinned_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// StorePin records that a message was pinned until expiresAt. A pin or unpin
+// newer than this one is kept.
+func (store *MessageStore) StorePin(messageID, chatJID, pinnedBy string, pinnedAt, expiresAt time.Time) error {
+	_, err := store.db.Exec(
+		`INSERT INTO message_pins (message_id, chat_jid, pinned_by, pinned_at, expires_at, unpinned) VALUES (?, ?, ?, ?, ?, FALSE)
+		ON CONFLICT (message_id, chat_jid) DO UPDATE SET
+			pinned_by = excluded.pinned_by, pinned_at = excluded.pinned_at, expires_at = excluded.expires_at, unpinned = FALSE
+		WHERE excluded.pinned_at >= message_pins.pinned_at`,
+		messageID, chatJID, pinnedBy, pinnedAt, expiresAt,
+	)
+	return err
+}
+
+// StoreUnpin records that a message was unpinned at unpinnedAt, unless it was
+// pinned again since. The unpin is kept so an older pin arriving late doesn't
+// bring the message back.
+func (store *MessageStore) StoreUnpin(messageID, chatJID, unpinnedBy string, unpinnedAt time.Time) error {
+	_, err := store.db.Exec(
+		`INSERT INTO message_pins (message_id, chat_jid, pinned_by, pinned_at, expires_at, unpinned) VALUES (?, ?, ?, ?, ?, TRUE)
+		ON CONFLICT (message_id, chat_jid) DO UPDATE SET
+			pinned_by = excluded.pinned_by, pinned_at = excluded.pinned_at, expires_at = excluded.expires_at, unpinned = TRUE
+		WHERE excluded.pinned_at >= message_pins.pinned_at`,
+		messageID, chatJID, unpinnedBy, unpinnedAt, unpinnedAt,
+	)
+	return err
+}
+
+// GetPinnedMessages returns the messages pinned in a chat whose pin hasn't
+// expired, latest pinned first
+func (store *MessageStore) GetPinnedMessages(chatJID string, now time.Time) ([]PinnedMessage, error) {
+	rows, err := store.db.Query(
+		`SELECT p.message_id, m.sender, m.content, m.media_type, p.pinned_by, p.pinned_at, p.expires_at
+		FROM message_pins p LEFT JOIN messages m ON m.id = p.message_id AND m.chat_jid = p.chat_jid
+		WHERE p.chat_jid = ? AND NOT p.unpinned AND p.expires_at > ?
+		ORDER BY p.pinned_at DESC`,
+		chatJID, now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pins := []PinnedMessage{}
+	for rows.Next() {
+		var pin PinnedMessage
+		var sender, content, mediaType sql.NullString
+		if err := rows.Scan(&pin.MessageID, &sender, &content, &mediaType, &pin.PinnedBy, &pin.PinnedAt, &pin.ExpiresAt); err != nil {
+			return nil, err
+		}
+		pin.Sender, pin.Content, pin.MediaType = sender.String, content.String, mediaType.String
+		pins = append(pins, pin)
+	}
+	return pins, rows.Err()
+}
+
+// buildPin builds the message that pins message id of sender in chat for
+// duration, or unpins it when duration is 0
+func buildPin(client *whatsmeow.Client, chat, sender types.JID, id string, duration time.Duration, at time.Time) *waProto.Message {
+	pin := &waProto.PinInChatMessage{
+		Key:               client.BuildMessageKey(chat, sender, id),
+		Type:              waProto.PinInChatMessage_UNPIN_FOR_ALL.Enum(),
+		SenderTimestampMS: proto.Int64(at.UnixMilli()),
+	}
+	msg := &waProto.Message{PinInChatMessage: pin}
+	if duration > 0 {
+		pin.Type = waProto.PinInChatMessage_PIN_FOR_ALL.Enum()
+		msg.MessageContextInfo = &waProto.MessageContextInfo{
+			MessageAddOnDurationInSecs: proto.Uint32(uint32(duration.Seconds())),
+		}
+	}
+	return msg
+}
+
+// handlePin stores a pin or unpin someone made, or that the account made from
+// another device, and publishes it
+func handlePin(store *MessageStore, bus *bridgeevents.Bus, msg *events.Message) {
+	pin := msg.Message.GetPinInChatMessage()
+	messageID := pin.GetKey().GetID()
+	chatJID := msg.Info.Chat.String()
+	sender := msg.Info.Sender.User
+	at := msg.Info.Timestamp
+	if ms := pin.GetSenderTimestampMS(); ms > 0 {
+		at = time.UnixMilli(ms)
+	}
+
+	pinned := pin.GetType() == waProto.PinInChatMessage_PIN_FOR_ALL
+	event := map[string]interface{}{
+		"message_id": messageID,
+		"chat_jid":   chatJID,
+		"pinned_by":  sender,
+		"pinned":     pinned,
+		"timestamp":  at,
+		"is_from_me": msg.Info.IsFromMe,
+	}
+	var err error
+	if pinned {
+		duration := pinDurations[defaultPinDuration]
+		if secs := msg.Message.GetMessageContextInfo().GetMessageAddOnDurationInSecs(); secs > 0 {
+			duration = time.Duration(secs) * time.Second
+		}
+		expiresAt := at.Add(duration)
+		event["expires_at"] = expiresAt
+		err = store.StorePin(messageID, chatJID, sender, at, expiresAt)
+	} else {
+		err = store.StoreUnpin(messageID, chatJID, sender, at)
+	}
+	if err != nil {
+		slog.Warn("Failed to store pin", "message_id", messageID, "chat_jid", chatJID, "error", err)
+		return
+	}
+	bus.Publish(bridgeevents.TypeMessagePin, event)
+}
+
+// registerPinHandlers adds the endpoints for pinning messages and listing the
+// pinned ones
+func registerPinHandlers(mux *http.ServeMux, client *whatsmeow.Client, store *MessageStore) {
+	// pin serves POST, which pins a message, and DELETE, which unpins it
+	pin := func(w http.ResponseWriter, r *http.Request) {
+		var req PinRequest
+		var v validate.Validator
+		chat := v.Recipient("chat", r.PathValue("chat"))
+		unpin := r.Method == http.MethodDelete
+		// The body is optional
+		if r.ContentLength != 0 {
+			v.Decode(r, &req)
+		}
+		if unpin && req.Duration != "" {
+			v.Add("duration", validate.CodeInvalidValue, "must be empty when unpinning")
+		}
+		v.OneOf("duration", req.Duration, "24h", "7d", "30d")
+		if req.Sender != "" {
+			req.Sender = v.Recipient("sender", req.Sender)
+		}
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		chatJID, err := parseRecipient(chat)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+		messageID := r.PathValue("id")
+		what := "pin"
+		if unpin {
+			what = "unpin"
+		}
+		sender, ok := targetSender(w, r, store, chatJID, messageID, req.Sender, what)
+		if !ok {
+			return
+		}
+
+		if !client.IsConnected() {
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not connected to WhatsApp")
+			return
+		}
+
+		if req.Duration == "" && !unpin {
+			req.Duration = defaultPinDuration
+		}
+		duration := pinDurations[req.Duration]
+		at := time.Now()
+		// A client hanging up must not abort a send that is under way
+		ctx := context.WithoutCancel(r.Context())
+		result := sendBuilt(ctx, client, chatJID.String(), what, buildPin(client, chatJID, sender, messageID, duration, at))
+		if !result.Success {
+			slog.WarnContext(ctx, "Failed to send pin", "message_id", messageID, "chat_jid", chatJID, "unpin", unpin, "status", "failed", "error", result.Message)
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, result.Message)
+			return
+		}
+		slog.InfoContext(ctx, "Sent pin", "message_id", messageID, "chat_jid", chatJID, "unpin", unpin, "duration", req.Duration, "status", "sent")
+		audit.SetResource(r.Context(), messageID)
+
+		// WhatsApp doesn't echo the bridge's own pins back, so they are stored here
+		if client.Store.ID != nil {
+			if unpin {
+				err = store.StoreUnpin(messageID, chatJID.String(), client.Store.ID.User, at)
+			} else {
+				err = store.StorePin(messageID, chatJID.String(), client.Store.ID.User, at, at.Add(duration))
+			}
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to store sent pin", "message_id", messageID, "error", err)
+			}
+		}
+
+		response := map[string]interface{}{
+			"success":    true,
+			"message_id": messageID,
+			"chat_jid":   chatJID.String(),
+			"pinned":     !unpin,
+		}
+		if !unpin {
+			response["expires_at"] = at.Add(duration)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+
+	// POST /v1/messages/{chat}/{id}/pin - Pin a message for everyone in the
+	// chat, for 24h, 7d (the default) or 30d
+	mux.HandleFunc("POST /v1/messages/{chat}/{id}/pin", pin)
+	// DELETE /v1/messages/{chat}/{id}/pin - Unpin a message for everyone
+	mux.HandleFunc("DELETE /v1/messages/{chat}/{id}/pin", pin)
+
+	// GET /v1/chats/{jid}/pins - The messages pinned in a chat, latest pinned
+	// first, as far as the bridge has seen them pinned
+	mux.HandleFunc("GET /v1/chats/{jid}/pins", func(w http.ResponseWriter, r *http.Request) {
+		var v validate.Validator
+		chat := v.Recipient("jid", r.PathValue("jid"))
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		chatJID, err := parseRecipient(chat)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+
+		pins, err := store.GetPinnedMessages(chatJID.String(), time.Now())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to get pinned messages", "chat_jid", chatJID, "error", err)
+			apierror.Internal(w, "Failed to get pinned messages")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"chat_jid": chatJID.String(),
+			"pins":     pins,
+		})
+	})
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/audit"
+	bridgeevents "whatsapp-client/events"
+	"whatsapp-client/scheduler"
+	"whatsapp-client/validate"
+)
+
+// Limits WhatsApp puts on polls
+const (
+	maxPollQuestionLength = 255
+	maxPollOptionLength   = 100
+	minPollOptions        = 2
+	maxPollOptions        = 12
+)
+
+// SendPollRequest is the body of POST /v1/send/poll
+type SendPollRequest struct {
+	Recipient string   `json:"recipient"`
+	Question  string   `json:"question"`
+	Options   []string `json:"options"`
+	// MultiSelect lets voters pick any number of options rather than one
+	MultiSelect bool `json:"multi_select,omitempty"`
+	scheduler.SendOptions
+}
+
+// validate checks the request and normalizes the recipient
+func (req *SendPollRequest) validate(v *validate.Validator) {
+	req.Recipient = v.Recipient("recipient", req.Recipient)
+	if len(req.Mentions) > 0 {
+		v.Add("mentions", validate.CodeInvalidValue, "must be empty, polls have no text to mention in")
+	}
+	req.ValidateNotText(v, "polls")
+	req.ValidateViewOnce(v, "")
+	if v.Required("question", req.Question) {
+		v.MaxLength("question", req.Question, maxPollQuestionLength)
+	}
+	if len(req.Options) < minPollOptions || len(req.Options) > maxPollOptions {
+		v.Add("options", validate.CodeInvalidValue, fmt.Sprintf("must have %d to %d options, got %d", minPollOptions, maxPollOptions, len(req.Options)))
+	}
+	for i, option := range req.Options {
+		field := "options." + strconv.Itoa(i)
+		if !v.Required(field, option) {
+			continue
+		}
+		v.MaxLength(field, option, maxPollOptionLength)
+		// Votes name options by hash, so two alike would be counted as one
+		if slices.Index(req.Options, option) < i {
+			v.Add(field, validate.CodeInvalidValue, "must not repeat another option")
+		}
+	}
+}
+
+// selectableCount is how many options a voter may pick; 0 means any number
+func (req *SendPollRequest) selectableCount() int {
+	if req.MultiSelect {
+		return 0
+	}
+	return 1
+}
+
+// Poll is a poll sent or received, as stored in the message store
+type Poll struct {
+	MessageID string   `json:"message_id"`
+	ChatJID   string   `json:"chat_jid"`
+	Sender    string   `json:"sender"`
+	Question  string   `json:"question"`
+	Options   []string `json:"-"`
+	// SelectableCount is how many options a voter may pick; 0 means any number
+	SelectableCount int       `json:"selectable_count"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// PollOptionResult is the tally of one option
+type PollOptionResult struct {
+	Name   string   `json:"name"`
+	Votes  int      `json:"votes"`
+	Voters []string `json:"voters"`
+}
+
+// PollResults is the response of GET /v1/polls/{message_id}/results
+type PollResults struct {
+	Poll
+	Options []PollOptionResult `json:"options"`
+	// Voters is how many people have an option picked
+	Voters    int        `json:"voters"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// pollCreation returns the poll a message starts, in any of its versions
+func pollCreation(msg *waProto.Message) *waProto.PollCreationMessage {
+	switch {
+	case msg.GetPollCreationMessage() != nil:
+		return msg.GetPollCreationMessage()
+	case msg.GetPollCreationMessageV2() != nil:
+		return msg.GetPollCreationMessageV2()
+	case msg.GetPollCreationMessageV3() != nil:
+		return msg.GetPollCreationMessageV3()
+	}
+	return nil
+}
+
+// pollOptionHash is how a vote names an option
+func pollOptionHash(option string) string {
+	sum := sha256.Sum256([]byte(option))
+	return hex.EncodeToString(sum[:])
+}
+
+// StorePoll records a poll, so the votes for it can be tallied
+func (store *MessageStore) StorePoll(poll Poll) error {
+	options, err := json.Marshal(poll.Options)
+	if err != nil {
+		return err
+	}
+	_, err = store.db.Exec(
+		`INSERT OR REPLACE INTO polls (message_id, chat_jid, sender, question, options, selectable_count, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		poll.MessageID, poll.ChatJID, poll.Sender, poll.Question, string(options), poll.SelectableCount, poll.CreatedAt,
+	)
+	return err
+}
+
+// GetPoll returns a poll by message ID, in chatJID when it is set. It returns
+// sql.ErrNoRows for an unknown poll.
+func (store *MessageStore) GetPoll(messageID, chatJID string) (*Poll, error) {
+	query := "SELECT message_id, chat_jid, sender, question, options, selectable_count, created_at FROM polls WHERE message_id = ?"
+	args := []interface{}{messageID}
+	if chatJID != "" {
+		query += " AND chat_jid = ?"
+		args = append(args, chatJID)
+	}
+	query += " ORDER BY created_at DESC LIMIT 1"
+
+	var poll Poll
+	var options string
+	err := store.db.QueryRow(query, args...).Scan(&poll.MessageID, &poll.ChatJID, &poll.Sender, &poll.Question, &options, &poll.SelectableCount, &poll.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(options), &poll.Options); err != nil {
+		return nil, fmt.Errorf("poll %s has invalid options: %v", messageID, err)
+	}
+	return &poll, nil
+}
+
+// StorePollVote records what a voter has picked in a poll, replacing their
+// earlier vote unless this one is older. selected holds option hashes, so votes
+// that arrive before their poll still count once it is known.
+func (store *MessageStore) StorePollVote(pollID, chatJID, voter string, selected []string, votedAt time.Time) error {
+	data, err := json.Marshal(selected)
+	if err != nil {
+		return err
+	}
+	_, err = store.db.Exec(
+		`INSERT INTO poll_votes (poll_id, chat_jid, voter, selected, voted_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (poll_id, chat_jid, voter) DO UPDATE SET selected = excluded.selected, voted_at = excluded.voted_at
+		WHERE excluded.voted_at >= poll_votes.voted_at`,
+		pollID, chatJID, voter, string(data), votedAt,
+	)
+	return err
+}
+
+// PollResults tallies the votes for a poll
+func (store *MessageStore) PollResults(poll *Poll) (*PollResults, error) {
+	results := &PollResults{Poll: *poll, Options: make([]PollOptionResult, len(poll.Options))}
+	byHash := make(map[string]int, len(poll.Options))
+	for i, option := range poll.Options {
+		results.Options[i] = PollOptionResult{Name: option, Voters: []string{}}
+		byHash[pollOptionHash(option)] = i
+	}
+
+	rows, err := store.db.Query("SELECT voter, selected, voted_at FROM poll_votes WHERE poll_id = ? AND chat_jid = ? ORDER BY voted_at", poll.MessageID, poll.ChatJID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var voter, data string
+		var votedAt time.Time
+		if err := rows.Scan(&voter, &data, &votedAt); err != nil {
+			return nil, err
+		}
+		var selected []string
+		if err := json.Unmarshal([]byte(data), &selected); err != nil {
+			continue
+		}
+
+		counted := false
+		for _, hash := range selected {
+			if i, ok := byHash[hash]; ok {
+				results.Options[i].Votes++
+				results.Options[i].Voters = append(results.Options[i].Voters, voter)
+				counted = true
+			}
+		}
+		if counted {
+			results.Voters++
+		}
+		results.UpdatedAt = &votedAt
+	}
+	return results, rows.Err()
+}
+
+// storeMessagePoll stores the poll a message starts, if it is one
+func storeMessagePoll(store *MessageStore, id, chatJID, sender string, timestamp time.Time, msg *waProto.Message) {
+	creation := pollCreation(msg)
+	if creation == nil {
+		return
+	}
+	poll := Poll{
+		MessageID:       id,
+		ChatJID:         chatJID,
+		Sender:          sender,
+		Question:        creation.GetName(),
+		SelectableCount: int(creation.GetSelectableOptionsCount()),
+		CreatedAt:       timestamp,
+	}
+	for _, option := range creation.GetOptions() {
+		poll.Options = append(poll.Options, option.GetOptionName())
+	}
+	if err := store.StorePoll(poll); err != nil {
+		slog.Warn("Failed to store poll", "message_id", id, "chat_jid", chatJID, "error", err)
+	}
+}
+
+// handlePollVote decrypts a poll vote, stores it and publishes it with the
+// names of the options picked, when the poll is known
+func handlePollVote(ctx context.Context, client *whatsmeow.Client, store *MessageStore, bus *bridgeevents.Bus, msg *events.Message) {
+	update := msg.Message.GetPollUpdateMessage()
+	pollID := update.GetPollCreationMessageKey().GetID()
+	chatJID := msg.Info.Chat.String()
+	vote, err := client.DecryptPollVote(ctx, msg)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to decrypt poll vote", "poll_id", pollID, "chat_jid", chatJID, "error", err)
+		return
+	}
+
+	selected := make([]string, len(vote.GetSelectedOptions()))
+	for i, hash := range vote.GetSelectedOptions() {
+		selected[i] = hex.EncodeToString(hash)
+	}
+	votedAt := msg.Info.Timestamp
+	if ms := update.GetSenderTimestampMS(); ms > 0 {
+		votedAt = time.UnixMilli(ms)
+	}
+	voter := msg.Info.Sender.ToNonAD().String()
+	if err := store.StorePollVote(pollID, chatJID, voter, selected, votedAt); err != nil {
+		slog.WarnContext(ctx, "Failed to store poll vote", "poll_id", pollID, "chat_jid", chatJID, "error", err)
+		return
+	}
+
+	options := []string{}
+	if poll, err := store.GetPoll(pollID, chatJID); err == nil {
+		for _, option := range poll.Options {
+			if slices.Contains(selected, pollOptionHash(option)) {
+				options = append(options, option)
+			}
+		}
+	}
+	bus.Publish(bridgeevents.TypePollVote, map[string]interface{}{
+		"poll_id":   pollID,
+		"chat_jid":  chatJID,
+		"voter":     voter,
+		"options":   options,
+		"timestamp": votedAt,
+	})
+}
+
+// registerPollHandlers adds the endpoints for sending polls and reading their results
+func registerPollHandlers(mux *http.ServeMux, client *whatsmeow.Client, store *MessageStore) {
+	// POST /v1/send/poll - Send a poll with a question and 2 to 12 options
+	mux.HandleFunc("POST /v1/send/poll", func(w http.ResponseWriter, r *http.Request) {
+		var req SendPollRequest
+		var v validate.Validator
+		if v.Decode(r, &req) {
+			req.validate(&v)
+		}
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		contextInfo, ok := requestContext(w, r, store, client, req.Recipient, req.SendOptions)
+		if !ok {
+			return
+		}
+
+		if !client.IsConnected() {
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not connected to WhatsApp")
+			return
+		}
+
+		// A client hanging up must not abort a send that is under way
+		ctx := context.WithoutCancel(r.Context())
+		msg := client.BuildPollCreation(req.Question, req.Options, req.selectableCount())
+		setContextInfo(msg, contextInfo)
+		result := sendBuilt(ctx, client, req.Recipient, "poll", msg)
+		storeSent(ctx, client, store, result, msg)
+		if !result.Success {
+			slog.WarnContext(ctx, "Failed to send poll", "recipient", req.Recipient, "status", "failed", "error", result.Message)
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, result.Message)
+			return
+		}
+		slog.InfoContext(ctx, "Sent poll", "whatsapp_message_id", result.MessageID, "recipient", req.Recipient, "status", "sent")
+		audit.SetResource(r.Context(), result.MessageID)
+
+		var sender string
+		if client.Store.ID != nil {
+			sender = client.Store.ID.User
+		}
+		poll := Poll{
+			MessageID:       result.MessageID,
+			ChatJID:         result.ChatJID,
+			Sender:          sender,
+			Question:        req.Question,
+			Options:         req.Options,
+			SelectableCount: req.selectableCount(),
+			CreatedAt:       result.Timestamp,
+		}
+		if err := store.StorePoll(poll); err != nil {
+			slog.ErrorContext(ctx, "Failed to store sent poll, its votes won't be tallied", "whatsapp_message_id", result.MessageID, "error", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(SendMessageResponse{
+			Success:   true,
+			Message:   result.Message,
+			MessageID: result.MessageID,
+			ChatJID:   result.ChatJID,
+		})
+	})
+
+	// GET /v1/polls/{message_id}/results - The votes for each option so far. A
+	// chat_jid query parameter picks the poll when the ID is in several chats.
+	mux.HandleFunc("GET /v1/polls/{message_id}/results", func(w http.ResponseWriter, r *http.Request) {
+		poll, err := store.GetPoll(r.PathValue("message_id"), r.URL.Query().Get("chat_jid"))
+		if errors.Is(err, sql.ErrNoRows) {
+			apierror.NotFound(w, "Poll not found")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to read poll", "error", err)
+			apierror.Internal(w, "Failed to read poll")
+			return
+		}
+		results, err := store.PollResults(poll)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to tally poll votes", "error", err)
+			apierror.Internal(w, "Failed to tally poll votes")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"poll":    results,
+		})
+	})
+}
@@ -0,0 +1,7 @@
+//go:build postgres
+
+package main
+
+// Registers the "pgx" database/sql driver so SCHEDULER_DB_DSN can point at Postgres.
+// Build with: go build -tags postgres .
+import _ "github.com/jackc/pgx/v5/stdlib"
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/audit"
+	"whatsapp-client/validate"
+)
+
+// Chat states POST /v1/chats/{jid}/typing sends
+const (
+	chatStateComposing = "composing"
+	chatStateRecording = "recording"
+	chatStatePaused    = "paused"
+)
+
+// ChatStateRequest is the body of POST /v1/chats/{jid}/typing
+type ChatStateRequest struct {
+	// State is composing, recording (a voice note) or paused
+	State string `json:"state"`
+}
+
+// PresenceRequest is the body of PUT /v1/presence
+type PresenceRequest struct {
+	// Presence is available or unavailable
+	Presence string `json:"presence"`
+}
+
+// registerPresenceHandlers adds the endpoints for showing the account typing
+// in a chat and online or offline
+func registerPresenceHandlers(mux *http.ServeMux, client *whatsmeow.Client) {
+	// POST /v1/chats/{jid}/typing - Show the account typing or recording in a
+	// chat, or stop. WhatsApp hides the indicator by itself after a while, and
+	// when a message is sent.
+	mux.HandleFunc("POST /v1/chats/{jid}/typing", func(w http.ResponseWriter, r *http.Request) {
+		var req ChatStateRequest
+		var v validate.Validator
+		chat := v.Recipient("jid", r.PathValue("jid"))
+		if v.Decode(r, &req) {
+			if v.Required("state", req.State) {
+				v.OneOf("state", req.State, chatStateComposing, chatStateRecording, chatStatePaused)
+			}
+		}
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		chatJID, err := parseRecipient(chat)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+
+		if !client.IsConnected() {
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not connected to WhatsApp")
+			return
+		}
+
+		state, media := types.ChatPresenceComposing, types.ChatPresenceMediaText
+		switch req.State {
+		case chatStateRecording:
+			media = types.ChatPresenceMediaAudio
+		case chatStatePaused:
+			state = types.ChatPresencePaused
+		}
+		if err := client.SendChatPresence(chatJID, state, media); err != nil {
+			slog.WarnContext(r.Context(), "Failed to send chat state", "chat_jid", chatJID, "state", req.State, "error", err)
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, "Failed to send chat state: "+err.Error())
+			return
+		}
+		slog.InfoContext(r.Context(), "Sent chat state", "chat_jid", chatJID, "state", req.State)
+		audit.SetResource(r.Context(), chatJID.String())
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"chat_jid": chatJID.String(),
+			"state":    req.State,
+		})
+	})
+
+	// PUT /v1/presence - Show the account online or offline to its contacts.
+	// While it is unavailable WhatsApp stops sending it others' presence, which
+	// online-mode scheduled messages wait for.
+	mux.HandleFunc("PUT /v1/presence", func(w http.ResponseWriter, r *http.Request) {
+		var req PresenceRequest
+		var v validate.Validator
+		if v.Decode(r, &req) {
+			if v.Required("presence", req.Presence) {
+				v.OneOf("presence", req.Presence, string(types.PresenceAvailable), string(types.PresenceUnavailable))
+			}
+		}
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+
+		if !client.IsConnected() {
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not connected to WhatsApp")
+			return
+		}
+
+		if err := client.SendPresence(types.Presence(req.Presence)); err != nil {
+			slog.WarnContext(r.Context(), "Failed to send presence", "presence", req.Presence, "error", err)
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, "Failed to send presence: "+err.Error())
+			return
+		}
+		slog.InfoContext(r.Context(), "Sent presence", "presence", req.Presence)
+		audit.SetResource(r.Context(), req.Presence)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"presence": req.Presence,
+		})
+	})
+}
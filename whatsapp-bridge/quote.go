@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/scheduler"
+	"whatsapp-client/validate"
+)
+
+// errQuotedNotFound is returned when the message to reply to isn't stored
+var errQuotedNotFound = errors.New("is not a message in the chat")
+
+// quotedContext builds the context that makes a message a reply to a stored
+// message in chat. The quoted snippet the recipient sees above the reply is
+// rebuilt from what the store kept of the message.
+func quotedContext(store *MessageStore, client *whatsmeow.Client, chat types.JID, id string) (*waProto.ContextInfo, error) {
+	msg, err := store.GetMessage(id, chat.String())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errQuotedNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	participant := messageSender(chat, msg)
+	if participant.IsEmpty() && client.Store.ID != nil {
+		participant = client.Store.ID.ToNonAD()
+	}
+	info := &waProto.ContextInfo{
+		StanzaID:      proto.String(id),
+		QuotedMessage: quotedSnippet(msg),
+	}
+	if !participant.IsEmpty() {
+		info.Participant = proto.String(participant.String())
+	}
+	return info, nil
+}
+
+// quotedSnippet is the stored message as a reply quotes it: its text, or its
+// kind of media with the caption or file name
+func quotedSnippet(msg *Message) *waProto.Message {
+	switch msg.MediaType {
+	case "image":
+		return &waProto.Message{ImageMessage: &waProto.ImageMessage{Caption: proto.String(msg.Content)}}
+	case "video":
+		return &waProto.Message{VideoMessage: &waProto.VideoMessage{Caption: proto.String(msg.Content)}}
+	case "audio":
+		return &waProto.Message{AudioMessage: &waProto.AudioMessage{}}
+	case "document":
+		return &waProto.Message{DocumentMessage: &waProto.DocumentMessage{
+			FileName: proto.String(msg.Filename),
+			Caption:  proto.String(msg.Content),
+		}}
+	default:
+		return &waProto.Message{Conversation: proto.String(msg.Content)}
+	}
+}
+
+// sendContext resolves the options of a send, and the chat's disappearing
+// timer, to the context the message carries, or nil when it needs none
+func sendContext(store *MessageStore, client *whatsmeow.Client, recipient string, opts scheduler.SendOptions) (*waProto.ContextInfo, error) {
+	chat, err := parseRecipient(recipient)
+	if err != nil {
+		return nil, err
+	}
+	timer, setAt, err := store.GetDisappearingTimer(chat.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read disappearing timer: %w", err)
+	}
+	if opts.QuotedMessageID == "" && len(opts.Mentions) == 0 && timer == 0 {
+		return nil, nil
+	}
+
+	info := &waProto.ContextInfo{}
+	if opts.QuotedMessageID != "" {
+		if info, err = quotedContext(store, client, chat, opts.QuotedMessageID); err != nil {
+			return nil, err
+		}
+	}
+	// The text tags each mention, which recipients show as the person's name
+	info.MentionedJID = opts.Mentions
+	// Without the chat's timer, recipients keep the message for good
+	if timer > 0 {
+		info.Expiration = proto.Uint32(timer)
+		info.EphemeralSettingTimestamp = proto.Int64(setAt.Unix())
+	}
+	return info, nil
+}
+
+// requestContext resolves the options of a send request whose fields are
+// valid. A quoted message the bridge doesn't have is a validation error. When
+// it returns false, the error response has been written.
+func requestContext(w http.ResponseWriter, r *http.Request, store *MessageStore, client *whatsmeow.Client, recipient string, opts scheduler.SendOptions) (*waProto.ContextInfo, bool) {
+	info, err := sendContext(store, client, recipient, opts)
+	switch {
+	case errors.Is(err, errQuotedNotFound):
+		var v validate.Validator
+		v.Add("quoted_message_id", validate.CodeInvalidValue, err.Error())
+		v.Write(w)
+		return nil, false
+	case err != nil:
+		slog.ErrorContext(r.Context(), "Failed to build message context", "quoted_message_id", opts.QuotedMessageID, "error", err)
+		apierror.Internal(w, "Failed to build message context")
+		return nil, false
+	}
+	return info, true
+}
+
+// setContextInfo attaches info to msg. Plain text becomes an extended text
+// message, as a conversation can't carry context.
+func setContextInfo(msg *waProto.Message, info *waProto.ContextInfo) {
+	if info == nil {
+		return
+	}
+	if msg.Conversation != nil {
+		msg.ExtendedTextMessage = &waProto.ExtendedTextMessage{Text: msg.Conversation}
+		msg.Conversation = nil
+	}
+
+	switch {
+	case msg.ExtendedTextMessage != nil:
+		msg.ExtendedTextMessage.ContextInfo = info
+	case msg.ImageMessage != nil:
+		msg.ImageMessage.ContextInfo = info
+	case msg.VideoMessage != nil:
+		msg.VideoMessage.ContextInfo = info
+	case msg.DocumentMessage != nil:
+		msg.DocumentMessage.ContextInfo = info
+	case msg.AudioMessage != nil:
+		msg.AudioMessage.ContextInfo = info
+	case msg.ContactMessage != nil:
+		msg.ContactMessage.ContextInfo = info
+	case msg.ContactsArrayMessage != nil:
+		msg.ContactsArrayMessage.ContextInfo = info
+	case pollCreation(msg) != nil:
+		pollCreation(msg).ContextInfo = info
+	case msg.ProductMessage != nil:
+		msg.ProductMessage.ContextInfo = info
+	}
+}
+
+// scheduledMessageSender is the scheduler's MessageSender, which looks up the
+// quoted message when the message is sent
+func scheduledMessageSender(store *MessageStore) scheduler.MessageSender {
+	return func(ctx context.Context, client *whatsmeow.Client, recipient, message, mediaPath string, opts scheduler.SendOptions) scheduler.SendResult {
+		info, err := sendContext(store, client, recipient, opts)
+		if err != nil {
+			return scheduler.SendResult{Message: fmt.Sprintf("Error building message context: %v", err)}
+		}
+		return sendWhatsAppMessage(ctx, client, store, recipient, message, mediaPath, opts, info)
+	}
+}
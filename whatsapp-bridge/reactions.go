@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/audit"
+	bridgeevents "whatsapp-client/events"
+	"whatsapp-client/validate"
+)
+
+// maxReactionLength bounds a reaction, in characters. One emoji can take
+// several, as with skin tones and families.
+const maxReactionLength = 16
+
+// ReactRequest is the body of POST /v1/messages/{chat}/{id}/react
+type ReactRequest struct {
+	// Emoji is the reaction; empty removes the bridge's reaction
+	Emoji string `json:"emoji"`
+	// Sender is who sent the message, for one the bridge hasn't stored
+	Sender string `json:"sender,omitempty"`
+}
+
+// StoreReaction records someone's reaction to a message, or removes it when
+// emoji is empty. An older reaction than the one stored is ignored.
+func (store *MessageStore) StoreReaction(messageID, chatJID, sender, emoji string, timestamp time.Time) error {
+	if emoji == "" {
+		_, err := store.db.Exec(
+			"DELETE FROM message_reactions WHERE message_id = ? AND chat_jid = ? AND sender = ? AND timestamp <= ?",
+			messageID, chatJID, sender, timestamp,
+		)
+		return err
+	}
+	_, err := store.db.Exec(
+		`INSERT INTO message_reactions (message_id, chat_jid, sender, emoji, timestamp) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (message_id, chat_jid, sender) DO UPDATE SET emoji = excluded.emoji, timestamp = excluded.timestamp
+		WHERE excluded.timestamp >= message_reactions.timestamp`,
+		messageID, chatJID, sender, emoji, timestamp,
+	)
+	return err
+}
+
+// handleReaction stores a reaction someone sent, or that the account sent from
+// another device, and publishes it
+func handleReaction(store *MessageStore, bus *bridgeevents.Bus, msg *events.Message) {
+	reaction := msg.Message.GetReactionMessage()
+	messageID := reaction.GetKey().GetID()
+	chatJID := msg.Info.Chat.String()
+	sender := msg.Info.Sender.User
+	timestamp := msg.Info.Timestamp
+	if ms := reaction.GetSenderTimestampMS(); ms > 0 {
+		timestamp = time.UnixMilli(ms)
+	}
+
+	err := store.RecordMessageEvent(MessageEvent{
+		MessageID: messageID,
+		ChatJID:   chatJID,
+		Type:      eventReaction,
+		Sender:    sender,
+		Content:   reaction.GetText(),
+		Timestamp: timestamp,
+	})
+	if err != nil {
+		slog.Warn("Failed to store reaction", "message_id", messageID, "chat_jid", chatJID, "error", err)
+		return
+	}
+	bus.Publish(bridgeevents.TypeReaction, map[string]interface{}{
+		"message_id": messageID,
+		"chat_jid":   chatJID,
+		"sender":     sender,
+		"emoji":      reaction.GetText(),
+		"removed":    reaction.GetText() == "",
+		"timestamp":  timestamp,
+		"is_from_me": msg.Info.IsFromMe,
+	})
+}
+
+// registerReactionHandlers adds the endpoint for reacting to messages
+func registerReactionHandlers(mux *http.ServeMux, client *whatsmeow.Client, store *MessageStore) {
+	// POST /v1/messages/{chat}/{id}/react - React to a message with an emoji, or
+	// remove the reaction with an empty one
+	mux.HandleFunc("POST /v1/messages/{chat}/{id}/react", func(w http.ResponseWriter, r *http.Request) {
+		var req ReactRequest
+		var v validate.Validator
+		chat := v.Recipient("chat", r.PathValue("chat"))
+		if v.Decode(r, &req) {
+			v.MaxLength("emoji", req.Emoji, maxReactionLength)
+			if req.Sender != "" {
+				req.Sender = v.Recipient("sender", req.Sender)
+			}
+		}
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		chatJID, err := parseRecipient(chat)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+		messageID := r.PathValue("id")
+
+		sender, ok := targetSender(w, r, store, chatJID, messageID, req.Sender, "react to")
+		if !ok {
+			return
+		}
+
+		if !client.IsConnected() {
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not connected to WhatsApp")
+			return
+		}
+
+		// A client hanging up must not abort a send that is under way
+		ctx := context.WithoutCancel(r.Context())
+		result := sendBuilt(ctx, client, chatJID.String(), "reaction", client.BuildReaction(chatJID, sender, messageID, req.Emoji))
+		if !result.Success {
+			slog.WarnContext(ctx, "Failed to send reaction", "message_id", messageID, "chat_jid", chatJID, "status", "failed", "error", result.Message)
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, result.Message)
+			return
+		}
+		slog.InfoContext(ctx, "Sent reaction", "message_id", messageID, "chat_jid", chatJID, "removed", req.Emoji == "", "status", "sent")
+		audit.SetResource(r.Context(), messageID)
+
+		// WhatsApp doesn't echo the bridge's own reactions back, so they are stored here
+		if client.Store.ID != nil {
+			reaction := MessageEvent{MessageID: messageID, ChatJID: chatJID.String(), Type: eventReaction, Sender: client.Store.ID.User, Content: req.Emoji, Timestamp: result.Timestamp}
+			if err := store.RecordMessageEvent(reaction); err != nil {
+				slog.WarnContext(ctx, "Failed to store sent reaction", "message_id", messageID, "error", err)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(SendMessageResponse{
+			Success:   true,
+			Message:   result.Message,
+			MessageID: result.MessageID,
+			ChatJID:   result.ChatJID,
+		})
+	})
+}
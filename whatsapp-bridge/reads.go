@@ -0,0 +1,300 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/audit"
+	"whatsapp-client/validate"
+)
+
+// maxReadMessages bounds the message IDs one mark-read request names
+const maxReadMessages = 1000
+
+// maxReceiptIDs is how many message IDs go in one read receipt
+const maxReceiptIDs = 100
+
+// MarkReadRequest is the body of POST /v1/chats/{jid}/read
+type MarkReadRequest struct {
+	// MessageIDs are the messages to mark read. Empty marks every unread
+	// message in the chat.
+	MessageIDs []string `json:"message_ids,omitempty"`
+}
+
+// unreadMessage is a message someone else sent that the account hasn't read
+type unreadMessage struct {
+	id     string
+	sender types.JID
+}
+
+// unreadCondition matches the messages, aliased u, that others sent and the
+// account hasn't read: those after the read point of their chat that have no
+// read of their own
+const unreadCondition = `NOT u.is_from_me
+	AND NOT EXISTS (SELECT 1 FROM chat_reads cr WHERE cr.chat_jid = u.chat_jid AND u.timestamp <= cr.read_until)
+	AND NOT EXISTS (SELECT 1 FROM message_reads r WHERE r.message_id = u.id AND r.chat_jid = u.chat_jid)`
+
+// migrateReads adds the chat_reads table of read points, the time up to which
+// the account has read a chat. The messages stored before it are taken as
+// read, as the bridge only knew of reads it made or saw arrive.
+func migrateReads(db *sql.DB) error {
+	var exists bool
+	if err := db.QueryRow("SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'chat_reads'").Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`CREATE TABLE chat_reads (
+		chat_jid TEXT PRIMARY KEY,
+		read_until TIMESTAMP NOT NULL
+	)`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO chat_reads (chat_jid, read_until)
+		SELECT chat_jid, MAX(timestamp) FROM messages WHERE NOT is_from_me GROUP BY chat_jid`,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// StoreReads records that the account read messages in a chat, from the bridge
+// or another device. Messages already read keep their first read time. Like
+// on the phone, the messages sent before them are read too.
+func (store *MessageStore) StoreReads(chatJID string, messageIDs []string, readAt time.Time) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, id := range messageIDs {
+		if _, err := tx.Exec(
+			`INSERT INTO message_reads (message_id, chat_jid, read_at) VALUES (?, ?, ?)
+			ON CONFLICT (message_id, chat_jid) DO NOTHING`,
+			id, chatJID, readAt,
+		); err != nil {
+			return err
+		}
+		// A read point only moves forward
+		if _, err := tx.Exec(
+			`INSERT INTO chat_reads (chat_jid, read_until)
+			SELECT chat_jid, timestamp FROM messages WHERE id = ? AND chat_jid = ? AND NOT is_from_me
+			ON CONFLICT (chat_jid) DO UPDATE SET read_until = excluded.read_until
+			WHERE excluded.read_until > chat_reads.read_until`,
+			id, chatJID,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// StoreHistoryReads sets the read point of a chat from a history sync, which
+// tells how many of the latest messages others sent are unread. The messages
+// before those are read.
+func (store *MessageStore) StoreHistoryReads(chatJID string, unread int) error {
+	_, err := store.db.Exec(
+		`INSERT INTO chat_reads (chat_jid, read_until)
+		SELECT chat_jid, timestamp FROM messages WHERE chat_jid = ? AND NOT is_from_me
+		ORDER BY timestamp DESC LIMIT 1 OFFSET ?
+		ON CONFLICT (chat_jid) DO UPDATE SET read_until = excluded.read_until
+		WHERE excluded.read_until > chat_reads.read_until`,
+		chatJID, unread,
+	)
+	return err
+}
+
+// GetUnreadMessages returns the messages others sent to a chat that the
+// account hasn't read, oldest first
+func (store *MessageStore) GetUnreadMessages(chat types.JID) ([]unreadMessage, error) {
+	rows, err := store.db.Query(
+		`SELECT id, sender FROM messages u
+		WHERE chat_jid = ? AND `+unreadCondition+`
+		ORDER BY timestamp`,
+		chat.String(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var unread []unreadMessage
+	for rows.Next() {
+		var msg Message
+		var id string
+		if err := rows.Scan(&id, &msg.Sender); err != nil {
+			return nil, err
+		}
+		unread = append(unread, unreadMessage{id: id, sender: messageSender(chat, &msg)})
+	}
+	return unread, rows.Err()
+}
+
+// GetUnreadCount returns how many messages others sent to a chat the account
+// hasn't read
+func (store *MessageStore) GetUnreadCount(chatJID string) (int, error) {
+	var count int
+	err := store.db.QueryRow(
+		`SELECT COUNT(*) FROM messages u WHERE chat_jid = ? AND `+unreadCondition,
+		chatJID,
+	).Scan(&count)
+	return count, err
+}
+
+// handleOwnReceipt records the messages the account read on another device,
+// which sends its read receipts to the account's other devices too
+func handleOwnReceipt(store *MessageStore, receipt *events.Receipt) {
+	switch receipt.Type {
+	case types.ReceiptTypeRead, types.ReceiptTypeReadSelf, types.ReceiptTypePlayed, types.ReceiptTypePlayedSelf:
+	default:
+		return
+	}
+	if err := store.StoreReads(receipt.Chat.String(), receipt.MessageIDs, receipt.Timestamp); err != nil {
+		slog.Warn("Failed to store read messages", "chat_jid", receipt.Chat.String(), "error", err)
+	}
+}
+
+// unreadToMark resolves the messages a mark-read request names. Messages the
+// account sent can't be marked read, and without IDs every unread message is.
+func unreadToMark(store *MessageStore, chat types.JID, ids []string) ([]unreadMessage, error) {
+	if len(ids) == 0 {
+		return store.GetUnreadMessages(chat)
+	}
+	messages := make([]unreadMessage, 0, len(ids))
+	for _, id := range ids {
+		msg, err := store.GetMessage(id, chat.String())
+		if err != nil {
+			return nil, err
+		}
+		if msg.IsFromMe {
+			return nil, errOwnMessage
+		}
+		messages = append(messages, unreadMessage{id: id, sender: messageSender(chat, msg)})
+	}
+	return messages, nil
+}
+
+// errOwnMessage is returned by unreadToMark for a message the account sent
+var errOwnMessage = errors.New("only messages others sent can be marked read")
+
+// sendReadReceipts tells the senders of messages that the account read them. A
+// receipt names the messages of one sender, so they are grouped by sender.
+func sendReadReceipts(client *whatsmeow.Client, chat types.JID, messages []unreadMessage, readAt time.Time) error {
+	var senders []types.JID
+	bySender := make(map[types.JID][]types.MessageID)
+	for _, msg := range messages {
+		if _, ok := bySender[msg.sender]; !ok {
+			senders = append(senders, msg.sender)
+		}
+		bySender[msg.sender] = append(bySender[msg.sender], msg.id)
+	}
+	for _, sender := range senders {
+		ids := bySender[sender]
+		for len(ids) > 0 {
+			batch := ids[:min(len(ids), maxReceiptIDs)]
+			ids = ids[len(batch):]
+			if err := client.MarkRead(batch, readAt, chat, sender); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// registerReadHandlers adds the endpoint for marking chats read
+func registerReadHandlers(mux *http.ServeMux, client *whatsmeow.Client, store *MessageStore) {
+	// POST /v1/chats/{jid}/read - Send read receipts for messages in a chat, or
+	// for all its unread messages, so the phone shows the chat read too
+	mux.HandleFunc("POST /v1/chats/{jid}/read", func(w http.ResponseWriter, r *http.Request) {
+		var req MarkReadRequest
+		var v validate.Validator
+		chat := v.Recipient("jid", r.PathValue("jid"))
+		// The body is optional
+		if r.ContentLength != 0 && v.Decode(r, &req) {
+			if len(req.MessageIDs) > maxReadMessages {
+				v.Add("message_ids", validate.CodeTooLong, fmt.Sprintf("must have at most %d messages", maxReadMessages))
+			}
+		}
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		chatJID, err := parseRecipient(chat)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+
+		messages, err := unreadToMark(store, chatJID, req.MessageIDs)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			apierror.NotFound(w, "Message not found")
+			return
+		case errors.Is(err, errOwnMessage):
+			apierror.BadRequest(w, "Only messages others sent can be marked read")
+			return
+		case err != nil:
+			slog.ErrorContext(r.Context(), "Failed to read unread messages", "error", err)
+			apierror.Internal(w, "Failed to read unread messages")
+			return
+		}
+
+		if len(messages) > 0 {
+			if !client.IsConnected() {
+				apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not connected to WhatsApp")
+				return
+			}
+
+			readAt := time.Now()
+			if err := sendReadReceipts(client, chatJID, messages, readAt); err != nil {
+				slog.WarnContext(r.Context(), "Failed to send read receipts", "chat_jid", chatJID, "error", err)
+				apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, "Failed to send read receipts: "+err.Error())
+				return
+			}
+			ids := make([]string, len(messages))
+			for i, msg := range messages {
+				ids[i] = msg.id
+			}
+			// Like sent messages, the bridge's own receipts aren't echoed back
+			if err := store.StoreReads(chatJID.String(), ids, readAt); err != nil {
+				slog.WarnContext(r.Context(), "Failed to store read messages", "chat_jid", chatJID, "error", err)
+			}
+			slog.InfoContext(r.Context(), "Marked messages read", "chat_jid", chatJID, "count", len(messages))
+		}
+		audit.SetResource(r.Context(), chatJID.String())
+
+		unread, err := store.GetUnreadCount(chatJID.String())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to count unread messages", "error", err)
+			apierror.Internal(w, "Failed to count unread messages")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"chat_jid": chatJID.String(),
+			"marked":   len(messages),
+			"unread":   unread,
+		})
+	})
+}
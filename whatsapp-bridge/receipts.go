@@ -0,0 +1,153 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/validate"
+)
+
+// MessageReceipt is when one recipient of a message got it, read it and, for
+// voice notes and view-once media, played or opened it. Times the recipient's
+// privacy settings hide are null.
+type MessageReceipt struct {
+	Participant string     `json:"participant"`
+	DeliveredAt *time.Time `json:"delivered_at"`
+	ReadAt      *time.Time `json:"read_at"`
+	PlayedAt    *time.Time `json:"played_at,omitempty"`
+}
+
+// StoreReceipt records a receipt a recipient's phone sent for messages in a
+// chat. Only the first receipt of each kind counts, and reading or playing a
+// message implies it was delivered, in case that receipt was missed.
+func (store *MessageStore) StoreReceipt(chatJID, participant string, messageIDs []string, receiptType types.ReceiptType, at time.Time) error {
+	var deliveredAt, readAt, playedAt *time.Time
+	switch receiptType {
+	case types.ReceiptTypeDelivered:
+		deliveredAt = &at
+	case types.ReceiptTypeRead:
+		deliveredAt, readAt = &at, &at
+	case types.ReceiptTypePlayed:
+		deliveredAt, readAt, playedAt = &at, &at, &at
+	default:
+		return nil
+	}
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, id := range messageIDs {
+		if _, err := tx.Exec(
+			`INSERT INTO message_receipts (message_id, chat_jid, participant, delivered_at, read_at, played_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (message_id, chat_jid, participant) DO UPDATE SET
+				delivered_at = COALESCE(message_receipts.delivered_at, excluded.delivered_at),
+				read_at = COALESCE(message_receipts.read_at, excluded.read_at),
+				played_at = COALESCE(message_receipts.played_at, excluded.played_at)`,
+			id, chatJID, participant, deliveredAt, readAt, playedAt,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetMessageReceipts returns the receipts of a message, one per recipient
+func (store *MessageStore) GetMessageReceipts(id, chatJID string) ([]MessageReceipt, error) {
+	rows, err := store.db.Query(
+		`SELECT participant, delivered_at, read_at, played_at FROM message_receipts
+		WHERE message_id = ? AND chat_jid = ? ORDER BY participant`,
+		id, chatJID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	receipts := []MessageReceipt{}
+	for rows.Next() {
+		var receipt MessageReceipt
+		var deliveredAt, readAt, playedAt sql.NullTime
+		if err := rows.Scan(&receipt.Participant, &deliveredAt, &readAt, &playedAt); err != nil {
+			return nil, err
+		}
+		receipt.DeliveredAt = nullTimePtr(deliveredAt)
+		receipt.ReadAt = nullTimePtr(readAt)
+		receipt.PlayedAt = nullTimePtr(playedAt)
+		receipts = append(receipts, receipt)
+	}
+	return receipts, rows.Err()
+}
+
+// nullTimePtr is the time t holds, or nil when it is null
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// handleReceipt records a receipt for messages the account sent. Each of a
+// recipient's devices sends its own, so they are kept per person.
+func handleReceipt(store *MessageStore, receipt *events.Receipt) {
+	participant := receipt.Sender.ToNonAD().String()
+	if err := store.StoreReceipt(receipt.Chat.String(), participant, receipt.MessageIDs, receipt.Type, receipt.Timestamp); err != nil {
+		slog.Warn("Failed to store receipt", "chat_jid", receipt.Chat.String(), "participant", participant, "error", err)
+	}
+}
+
+// registerReceiptHandlers adds the endpoint for reading the receipts of a
+// message
+func registerReceiptHandlers(mux *http.ServeMux, store *MessageStore) {
+	// GET /v1/messages/{chat}/{id}/receipts - When each recipient of a message
+	// the account sent got it and read it. In groups there is one entry per
+	// member whose phone has sent a receipt.
+	mux.HandleFunc("GET /v1/messages/{chat}/{id}/receipts", func(w http.ResponseWriter, r *http.Request) {
+		var v validate.Validator
+		chat := v.Recipient("chat", r.PathValue("chat"))
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		chatJID, err := parseRecipient(chat)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+		messageID := r.PathValue("id")
+
+		_, err = store.GetMessage(messageID, chatJID.String())
+		if errors.Is(err, sql.ErrNoRows) {
+			apierror.NotFound(w, "Message not found")
+			return
+		}
+		var receipts []MessageReceipt
+		if err == nil {
+			receipts, err = store.GetMessageReceipts(messageID, chatJID.String())
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to read message receipts", "error", err)
+			apierror.Internal(w, "Failed to read message receipts")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    true,
+			"message_id": messageID,
+			"chat_jid":   chatJID.String(),
+			"receipts":   receipts,
+		})
+	})
+}
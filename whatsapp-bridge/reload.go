@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"whatsapp-client/config"
+	"whatsapp-client/logging"
+	"whatsapp-client/middleware"
+	"whatsapp-client/scheduler"
+	"whatsapp-client/webhook"
+)
+
+// reloadableSettings are the file keys a reload applies. Everything else only
+// takes effect on restart.
+var reloadableSettings = []string{
+	"api.rate_limit.burst",
+	"api.rate_limit.per_ip",
+	"api.rate_limit.per_key",
+	"log.level",
+	"scheduler.quiet_hours",
+	"scheduler.quiet_hours_timezone",
+	"webhooks.events",
+	"webhooks.max_attempts",
+	"webhooks.secret",
+	"webhooks.timeout",
+	"webhooks.urls",
+}
+
+// ReloadResult lists the settings a reload changed
+type ReloadResult struct {
+	// Applied are the changed settings now in effect
+	Applied []string `json:"applied"`
+	// RestartRequired are the changed settings that need a restart
+	RestartRequired []string `json:"restart_required"`
+}
+
+// reloader re-reads the configuration and applies what can change without
+// restarting, so the WhatsApp session stays connected
+type reloader struct {
+	mu  sync.Mutex
+	cfg *config.Config
+
+	msgScheduler *scheduler.MessageScheduler
+	webhooks     *webhook.Dispatcher
+	rateLimiter  *middleware.RateLimiter
+}
+
+// Config returns the settings in effect
+func (rl *reloader) Config() *config.Config {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.cfg
+}
+
+// Reload loads the config file and environment again. Nothing is applied when
+// the new configuration is invalid.
+func (rl *reloader) Reload() (*ReloadResult, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	next, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ReloadResult{Applied: []string{}, RestartRequired: []string{}}
+	changed := config.Changed(rl.cfg, next)
+	for _, key := range changed {
+		if slices.Contains(reloadableSettings, key) {
+			result.Applied = append(result.Applied, key)
+		} else {
+			result.RestartRequired = append(result.RestartRequired, key)
+		}
+	}
+
+	// Checked by config.Load, so these can't fail halfway. Reapplying unchanged
+	// settings is harmless.
+	if err := rl.msgScheduler.SetQuietHours(schedulerQuietHours(next.Scheduler.QuietHours)); err != nil {
+		return nil, fmt.Errorf("invalid quiet hours: %w", err)
+	}
+	if err := logging.SetLevel(next.Log.Level); err != nil {
+		return nil, err
+	}
+	rl.rateLimiter.Update(next.API.RateLimit)
+	rl.webhooks.Update(webhookOptions(next.Webhooks))
+
+	// Keep the settings that were not applied, so /v1/config shows what is in effect
+	applied := *rl.cfg
+	applied.API.RateLimit = next.API.RateLimit
+	applied.Log.Level = next.Log.Level
+	applied.Scheduler.QuietHours = next.Scheduler.QuietHours
+	applied.Webhooks = next.Webhooks
+	rl.cfg = &applied
+
+	slog.Info("Reloaded configuration", "applied", strings.Join(result.Applied, ","),
+		"restart_required", strings.Join(result.RestartRequired, ","))
+	return result, nil
+}
+
+// schedulerQuietHours converts the configured quiet hours for the scheduler
+func schedulerQuietHours(q config.QuietHours) scheduler.QuietHours {
+	location := time.Local
+	if q.Timezone != "" {
+		// Already checked by config.Load
+		location, _ = time.LoadLocation(q.Timezone)
+	}
+	return scheduler.QuietHours{Start: q.Start, End: q.End, Location: location}
+}
+
+// webhookOptions converts the webhook settings for the dispatcher
+func webhookOptions(cfg config.WebhookConfig) webhook.Options {
+	return webhook.Options{
+		URLs:        cfg.URLs,
+		Secret:      cfg.Secret,
+		Events:      cfg.Events,
+		Timeout:     cfg.Timeout,
+		MaxAttempts: cfg.MaxAttempts,
+	}
+}
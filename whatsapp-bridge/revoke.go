@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/audit"
+	bridgeevents "whatsapp-client/events"
+	"whatsapp-client/scheduler"
+	"whatsapp-client/validate"
+)
+
+// StoreRevocation records that a message was deleted for everyone. The message
+// itself stays in the store.
+func (store *MessageStore) StoreRevocation(messageID, chatJID, revokedBy string, revokedAt time.Time) error {
+	_, err := store.db.Exec(
+		`INSERT INTO message_revocations (message_id, chat_jid, revoked_by, revoked_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (message_id, chat_jid) DO NOTHING`,
+		messageID, chatJID, revokedBy, revokedAt,
+	)
+	return err
+}
+
+// isRevoke reports whether msg deletes an earlier message for everyone
+func isRevoke(msg *waProto.Message) bool {
+	return msg.GetProtocolMessage().GetType() == waProto.ProtocolMessage_REVOKE
+}
+
+// isGroupAdmin reports whether user administers group now
+func isGroupAdmin(client *whatsmeow.Client, group, user types.JID) bool {
+	info, err := client.GetGroupInfo(group)
+	if err != nil {
+		slog.Warn("Failed to get group info", "group_jid", group, "error", err)
+		return false
+	}
+	for _, participant := range info.Participants {
+		if participant.JID.User == user.User || participant.LID.User == user.User || participant.PhoneNumber.User == user.User {
+			return participant.IsAdmin || participant.IsSuperAdmin
+		}
+	}
+	return false
+}
+
+// handleRevoke records a message someone deleted for everyone, or that the
+// account deleted from another device, and publishes it. Only its sender may
+// do that, or in a group an admin.
+func handleRevoke(client *whatsmeow.Client, store *MessageStore, bus *bridgeevents.Bus, msg *events.Message) {
+	messageID := msg.Message.GetProtocolMessage().GetKey().GetID()
+	chatJID := msg.Info.Chat.String()
+	revokedBy := msg.Info.Sender.User
+
+	revoke := MessageEvent{
+		MessageID: messageID,
+		ChatJID:   chatJID,
+		Type:      eventRevoke,
+		Sender:    revokedBy,
+		Timestamp: msg.Info.Timestamp,
+		fromMe:    msg.Info.IsFromMe,
+		senderAlt: msg.Info.SenderAlt.User,
+	}
+	if msg.Info.IsGroup {
+		// Asking WhatsApp is only needed for someone else's message, or one
+		// the bridge doesn't have to tell
+		stored, err := store.GetMessage(messageID, chatJID)
+		if err != nil || !sentBy(stored, revoke) {
+			revoke.Authorized = isGroupAdmin(client, msg.Info.Chat, msg.Info.Sender)
+		}
+	}
+
+	err := store.RecordMessageEvent(revoke)
+	if errors.Is(err, errNotSender) {
+		slog.Warn("Ignoring revoke of a message by someone other than its sender", "message_id", messageID, "chat_jid", chatJID, "revoked_by", revokedBy)
+		return
+	}
+	if err != nil {
+		slog.Warn("Failed to store revocation", "message_id", messageID, "chat_jid", chatJID, "error", err)
+		return
+	}
+	bus.Publish(bridgeevents.TypeMessageRevoke, map[string]interface{}{
+		"message_id": messageID,
+		"chat_jid":   chatJID,
+		"revoked_by": revokedBy,
+		"timestamp":  msg.Info.Timestamp,
+		"is_from_me": msg.Info.IsFromMe,
+	})
+}
+
+// revokeSender works out whose message id in chat is, as a revoke names it. A
+// message the bridge hasn't stored may still be one the scheduler sent, which
+// is reported by scheduled.
+func revokeSender(ctx context.Context, store *MessageStore, msgScheduler *scheduler.MessageScheduler, chat types.JID, id string) (sender types.JID, scheduled bool, err error) {
+	msg, err := store.GetMessage(id, chat.String())
+	if err == nil {
+		return messageSender(chat, msg), false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return types.EmptyJID, false, err
+	}
+
+	sent, err := msgScheduler.GetSentMessage(ctx, id)
+	if errors.Is(err, scheduler.ErrMessageNotFound) || (err == nil && sent.ChatJID != chat.String()) {
+		return types.EmptyJID, false, sql.ErrNoRows
+	}
+	return types.EmptyJID, err == nil, err
+}
+
+// registerRevokeHandlers adds the endpoint for deleting messages for everyone
+func registerRevokeHandlers(mux *http.ServeMux, client *whatsmeow.Client, store *MessageStore, msgScheduler *scheduler.MessageScheduler) {
+	// DELETE /v1/messages/{chat}/{id} - Delete a message for everyone: one the
+	// account sent, including through the scheduler, or anyone's in a group
+	// the account administers
+	mux.HandleFunc("DELETE /v1/messages/{chat}/{id}", func(w http.ResponseWriter, r *http.Request) {
+		var v validate.Validator
+		chat := v.Recipient("chat", r.PathValue("chat"))
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		chatJID, err := parseRecipient(chat)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+		messageID := r.PathValue("id")
+
+		sender, scheduled, err := revokeSender(r.Context(), store, msgScheduler, chatJID, messageID)
+		if errors.Is(err, sql.ErrNoRows) {
+			apierror.NotFound(w, "Message not found")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to read message", "error", err)
+			apierror.Internal(w, "Failed to read message")
+			return
+		}
+		if !sender.IsEmpty() && chatJID.Server != types.GroupServer {
+			apierror.BadRequest(w, "Only messages the account sent can be deleted for everyone, outside groups it administers")
+			return
+		}
+
+		if !client.IsConnected() {
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not connected to WhatsApp")
+			return
+		}
+
+		// A client hanging up must not abort a send that is under way
+		ctx := context.WithoutCancel(r.Context())
+		result := sendBuilt(ctx, client, chatJID.String(), "revoke", client.BuildRevoke(chatJID, sender, messageID))
+		if !result.Success {
+			slog.WarnContext(ctx, "Failed to send revoke", "message_id", messageID, "chat_jid", chatJID, "status", "failed", "error", result.Message)
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, result.Message)
+			return
+		}
+		slog.InfoContext(ctx, "Sent revoke", "message_id", messageID, "chat_jid", chatJID, "scheduled", scheduled, "status", "sent")
+		audit.SetResource(r.Context(), messageID)
+
+		// Like sent messages, the bridge's own revokes aren't echoed back
+		if client.Store.ID != nil {
+			// WhatsApp took it, so the account was allowed to
+			revoke := MessageEvent{MessageID: messageID, ChatJID: chatJID.String(), Type: eventRevoke, Sender: client.Store.ID.User, Timestamp: result.Timestamp, Authorized: true}
+			if err := store.RecordMessageEvent(revoke); err != nil {
+				slog.WarnContext(ctx, "Failed to store sent revoke", "message_id", messageID, "error", err)
+			}
+		}
+		if sender.IsEmpty() {
+			if err := msgScheduler.MarkRevoked(ctx, messageID, result.Timestamp); err != nil {
+				slog.WarnContext(ctx, "Failed to mark scheduled message revoked", "message_id", messageID, "error", err)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(SendMessageResponse{
+			Success:   true,
+			Message:   result.Message,
+			MessageID: messageID,
+			ChatJID:   result.ChatJID,
+		})
+	})
+}
@@ -0,0 +1,109 @@
+package scheduler
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// dialect captures the differences between the SQL databases SchedulerDB can use.
+// Queries are written with ? placeholders and rebound for the target database.
+type dialect interface {
+	// name identifies the dialect in logs and errors
+	name() string
+	// driverName is the database/sql driver to open connections with
+	driverName() string
+	// rebind rewrites ? placeholders into the dialect's placeholder syntax
+	rebind(query string) string
+	// migrationsDir is the embedded directory holding this dialect's migrations
+	migrationsDir() string
+	// timestampType is the column type used for timestamps
+	timestampType() string
+	// tableExists reports whether a table exists
+	tableExists(db *sql.DB, table string) (bool, error)
+	// columnExists reports whether a table has the given column
+	columnExists(db *sql.DB, table string, column string) (bool, error)
+}
+
+// sqliteDialect is the default, file-based backend
+type sqliteDialect struct{}
+
+func (sqliteDialect) name() string               { return "sqlite" }
+func (sqliteDialect) driverName() string         { return "sqlite3" }
+func (sqliteDialect) rebind(query string) string { return query }
+func (sqliteDialect) migrationsDir() string      { return "migrations/sqlite" }
+func (sqliteDialect) timestampType() string      { return "DATETIME" }
+
+func (sqliteDialect) tableExists(db *sql.DB, table string) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&count)
+	return count > 0, err
+}
+
+func (sqliteDialect) columnExists(db *sql.DB, table string, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// postgresDialect is the shared, network-accessible backend for multi-instance
+// deployments. The driver is only linked into binaries built with -tags postgres.
+type postgresDialect struct{}
+
+func (postgresDialect) name() string          { return "postgres" }
+func (postgresDialect) driverName() string    { return "pgx" }
+func (postgresDialect) migrationsDir() string { return "migrations/postgres" }
+func (postgresDialect) timestampType() string { return "TIMESTAMPTZ" }
+
+func (postgresDialect) rebind(query string) string {
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+
+	n := 0
+	for i := 0; i < len(query); i++ {
+		if query[i] == '?' {
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(query[i])
+	}
+	return b.String()
+}
+
+func (postgresDialect) tableExists(db *sql.DB, table string) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1", table).Scan(&count)
+	return count > 0, err
+}
+
+func (postgresDialect) columnExists(db *sql.DB, table string, column string) (bool, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2
+	`, table, column).Scan(&count)
+	return count > 0, err
+}
+
+// isPostgresDSN reports whether a scheduler DSN points at Postgres rather than a SQLite file
+func isPostgresDSN(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
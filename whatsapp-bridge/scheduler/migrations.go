@@ -0,0 +1,175 @@
+package scheduler
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations live in migrations/<dialect>/NNNN_description.sql and are applied
+// in order. Never edit a migration that has shipped; add a new file instead, with
+// the same version number for every dialect.
+//
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+var migrationFiles embed.FS
+
+// migration is a single versioned schema change
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// loadMigrations reads the embedded migrations in dir sorted by version
+func loadMigrations(dir string) ([]migration, error) {
+	entries, err := migrationFiles.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	for _, entry := range entries {
+		name := entry.Name()
+		prefix, _, ok := strings.Cut(name, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s is not named NNNN_description.sql", name)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s has an invalid version: %w", name, err)
+		}
+
+		content, err := migrationFiles.ReadFile(path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, migration{
+			version: version,
+			name:    strings.TrimSuffix(name, ".sql"),
+			sql:     string(content),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].version == migrations[i-1].version {
+			return nil, fmt.Errorf("duplicate migration version %d", migrations[i].version)
+		}
+	}
+
+	return migrations, nil
+}
+
+// migrate brings the scheduler schema up to date, applying each pending
+// migration in its own transaction and recording it in schema_version
+func migrate(db *sql.DB, d dialect) error {
+	migrations, err := loadMigrations(d.migrationsDir())
+	if err != nil {
+		return fmt.Errorf("failed to load migrations: %w", err)
+	}
+
+	hasVersionTable, err := d.tableExists(db, "schema_version")
+	if err != nil {
+		return err
+	}
+
+	if !hasVersionTable {
+		// Databases created before the migration framework have tables but no history
+		legacyVersion, err := detectLegacyVersion(db, d)
+		if err != nil {
+			return fmt.Errorf("failed to inspect existing schema: %w", err)
+		}
+
+		if _, err := db.Exec(fmt.Sprintf(`
+			CREATE TABLE schema_version (
+				version INTEGER PRIMARY KEY,
+				name TEXT NOT NULL,
+				applied_at %s NOT NULL
+			)
+		`, d.timestampType())); err != nil {
+			return fmt.Errorf("failed to create schema_version table: %w", err)
+		}
+
+		for _, m := range migrations {
+			if m.version > legacyVersion {
+				break
+			}
+			if _, err := db.Exec(d.rebind("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)"), m.version, m.name, time.Now()); err != nil {
+				return fmt.Errorf("failed to record legacy migration %s: %w", m.name, err)
+			}
+		}
+		if legacyVersion > 0 {
+			slog.Info("Existing scheduler database adopted", "schema_version", legacyVersion)
+		}
+	}
+
+	var current int
+	if err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(m.sql); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s failed: %w", m.name, err)
+		}
+		if _, err := tx.Exec(d.rebind("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)"), m.version, m.name, time.Now()); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %s: %w", m.name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %s: %w", m.name, err)
+		}
+
+		slog.Info("Applied scheduler migration", "dialect", d.name(), "migration", m.name)
+	}
+
+	return nil
+}
+
+// detectLegacyVersion works out which migrations a database created before
+// schema_version existed already has, based on the columns it contains
+func detectLegacyVersion(db *sql.DB, d dialect) (int, error) {
+	exists, err := d.tableExists(db, "scheduled_messages")
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	// Columns added by migrations 2-4, newest first
+	for _, check := range []struct {
+		version int
+		column  string
+	}{
+		{4, "precision"},
+		{3, "delivery_mode"},
+		{2, "whatsapp_message_id"},
+	} {
+		has, err := d.columnExists(db, "scheduled_messages", check.column)
+		if err != nil {
+			return 0, err
+		}
+		if has {
+			return check.version, nil
+		}
+	}
+
+	return 1, nil
+}
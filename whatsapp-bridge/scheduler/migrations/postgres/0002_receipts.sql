@@ -0,0 +1,6 @@
+-- Delivery and read tracking for sent messages
+ALTER TABLE scheduled_messages ADD COLUMN IF NOT EXISTS whatsapp_message_id TEXT;
+ALTER TABLE scheduled_messages ADD COLUMN IF NOT EXISTS delivered_at TIMESTAMPTZ;
+ALTER TABLE scheduled_messages ADD COLUMN IF NOT EXISTS read_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_whatsapp_message_id ON scheduled_messages(whatsapp_message_id);
@@ -0,0 +1,3 @@
+-- "Send when recipient comes online" mode
+ALTER TABLE scheduled_messages ADD COLUMN IF NOT EXISTS delivery_mode TEXT DEFAULT 'scheduled';
+ALTER TABLE scheduled_messages ADD COLUMN IF NOT EXISTS online_window_minutes INTEGER DEFAULT 0;
@@ -0,0 +1,2 @@
+-- Scheduling precision tier
+ALTER TABLE scheduled_messages ADD COLUMN IF NOT EXISTS precision TEXT DEFAULT 'normal';
@@ -0,0 +1,12 @@
+-- Finished messages moved out of scheduled_messages by the maintenance job.
+-- The full row is kept as JSON so later schema changes don't need to touch this table.
+CREATE TABLE IF NOT EXISTS scheduled_messages_archive (
+	id TEXT PRIMARY KEY,
+	recipient TEXT NOT NULL,
+	status TEXT NOT NULL,
+	scheduled_time TIMESTAMPTZ NOT NULL,
+	payload TEXT NOT NULL,
+	archived_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_archive_scheduled_time ON scheduled_messages_archive(scheduled_time);
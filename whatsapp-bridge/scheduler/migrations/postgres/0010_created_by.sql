@@ -0,0 +1,4 @@
+-- Who scheduled the message (API key, MCP session or user)
+ALTER TABLE scheduled_messages ADD COLUMN IF NOT EXISTS created_by TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_scheduled_created_by ON scheduled_messages(created_by);
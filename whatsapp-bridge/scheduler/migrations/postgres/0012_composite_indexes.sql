@@ -0,0 +1,8 @@
+-- Match the hot queries: due/pending lookups by status and time, and
+-- per-recipient lookups by status
+CREATE INDEX IF NOT EXISTS idx_status_scheduled_time ON scheduled_messages(status, scheduled_time);
+CREATE INDEX IF NOT EXISTS idx_recipient_status ON scheduled_messages(recipient, status);
+
+-- Superseded by the composite indexes above
+DROP INDEX IF EXISTS idx_status;
+DROP INDEX IF EXISTS idx_recipient;
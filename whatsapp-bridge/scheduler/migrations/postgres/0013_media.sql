@@ -0,0 +1,2 @@
+-- The file sent with a message, as JSON with its kind and path or URL
+ALTER TABLE scheduled_messages ADD COLUMN IF NOT EXISTS media TEXT;
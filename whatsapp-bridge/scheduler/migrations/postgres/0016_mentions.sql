@@ -0,0 +1,2 @@
+-- The group members a scheduled message mentions, as a JSON array of JIDs
+ALTER TABLE scheduled_messages ADD COLUMN IF NOT EXISTS mentions TEXT;
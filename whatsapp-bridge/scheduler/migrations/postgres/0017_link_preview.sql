@@ -0,0 +1,2 @@
+-- Whether a scheduled text message is sent with a preview of its first link
+ALTER TABLE scheduled_messages ADD COLUMN IF NOT EXISTS link_preview BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,2 @@
+-- Whether the account shows as typing before a scheduled message is sent
+ALTER TABLE scheduled_messages ADD COLUMN IF NOT EXISTS typing BOOLEAN NOT NULL DEFAULT FALSE;
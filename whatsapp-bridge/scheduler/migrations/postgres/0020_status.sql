@@ -0,0 +1,5 @@
+-- How a scheduled text status looks, and the JSON array of JIDs of the only
+-- people a scheduled status may reach
+ALTER TABLE scheduled_messages ADD COLUMN IF NOT EXISTS background_color TEXT;
+ALTER TABLE scheduled_messages ADD COLUMN IF NOT EXISTS font TEXT;
+ALTER TABLE scheduled_messages ADD COLUMN IF NOT EXISTS audience TEXT;
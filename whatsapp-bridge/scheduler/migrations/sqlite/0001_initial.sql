@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS scheduled_messages (
+	id TEXT PRIMARY KEY,
+	recipient TEXT NOT NULL,
+	message TEXT NOT NULL,
+	scheduled_time DATETIME NOT NULL,
+	created_at DATETIME NOT NULL,
+	last_message_at DATETIME,
+	check_for_response BOOLEAN DEFAULT 1,
+	status TEXT DEFAULT 'pending',
+	sent_at DATETIME,
+	error_message TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_scheduled_time ON scheduled_messages(scheduled_time);
+CREATE INDEX IF NOT EXISTS idx_status ON scheduled_messages(status);
+CREATE INDEX IF NOT EXISTS idx_recipient ON scheduled_messages(recipient);
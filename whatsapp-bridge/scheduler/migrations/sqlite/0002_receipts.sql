@@ -0,0 +1,6 @@
+-- Delivery and read tracking for sent messages
+ALTER TABLE scheduled_messages ADD COLUMN whatsapp_message_id TEXT;
+ALTER TABLE scheduled_messages ADD COLUMN delivered_at DATETIME;
+ALTER TABLE scheduled_messages ADD COLUMN read_at DATETIME;
+
+CREATE INDEX IF NOT EXISTS idx_whatsapp_message_id ON scheduled_messages(whatsapp_message_id);
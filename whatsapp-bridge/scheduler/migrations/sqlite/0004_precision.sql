@@ -0,0 +1,2 @@
+-- Scheduling precision tier
+ALTER TABLE scheduled_messages ADD COLUMN precision TEXT DEFAULT 'normal';
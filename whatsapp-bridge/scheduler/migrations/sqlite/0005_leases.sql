@@ -0,0 +1,6 @@
+-- Processing lease shared by bridge instances using the same database
+CREATE TABLE IF NOT EXISTS scheduler_leases (
+	name TEXT PRIMARY KEY,
+	holder TEXT NOT NULL,
+	expires_at INTEGER NOT NULL
+);
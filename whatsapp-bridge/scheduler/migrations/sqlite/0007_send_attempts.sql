@@ -0,0 +1,10 @@
+-- One row per attempt to send a scheduled message
+CREATE TABLE IF NOT EXISTS send_attempts (
+	message_id TEXT NOT NULL,
+	attempt INTEGER NOT NULL,
+	started_at DATETIME NOT NULL,
+	finished_at DATETIME,
+	success BOOLEAN NOT NULL DEFAULT 0,
+	error TEXT,
+	PRIMARY KEY (message_id, attempt)
+);
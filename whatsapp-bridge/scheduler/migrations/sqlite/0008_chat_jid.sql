@@ -0,0 +1,2 @@
+-- Chat JID the scheduled message was actually sent to
+ALTER TABLE scheduled_messages ADD COLUMN chat_jid TEXT;
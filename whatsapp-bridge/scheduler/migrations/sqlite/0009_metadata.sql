@@ -0,0 +1,2 @@
+-- Caller-supplied JSON passed through the API untouched
+ALTER TABLE scheduled_messages ADD COLUMN metadata TEXT;
@@ -0,0 +1,9 @@
+-- Per-day, per-recipient counters maintained as messages change status
+CREATE TABLE IF NOT EXISTS scheduler_daily_stats (
+	day TEXT NOT NULL,
+	recipient TEXT NOT NULL,
+	sent_count INTEGER NOT NULL DEFAULT 0,
+	failed_count INTEGER NOT NULL DEFAULT 0,
+	paused_count INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (day, recipient)
+);
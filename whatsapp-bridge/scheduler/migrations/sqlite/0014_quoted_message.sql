@@ -0,0 +1,2 @@
+-- The message a scheduled message replies to
+ALTER TABLE scheduled_messages ADD COLUMN quoted_message_id TEXT;
@@ -0,0 +1,2 @@
+-- When a sent message was deleted for everyone
+ALTER TABLE scheduled_messages ADD COLUMN revoked_at DATETIME;
@@ -0,0 +1,2 @@
+-- The group members a scheduled message mentions, as a JSON array of JIDs
+ALTER TABLE scheduled_messages ADD COLUMN mentions TEXT;
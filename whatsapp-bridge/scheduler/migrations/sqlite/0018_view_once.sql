@@ -0,0 +1,2 @@
+-- Whether a scheduled photo or video can be opened only once
+ALTER TABLE scheduled_messages ADD COLUMN view_once BOOLEAN NOT NULL DEFAULT 0;
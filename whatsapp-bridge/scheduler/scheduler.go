@@ -1,119 +1,528 @@
 package scheduler
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/cache"
+	"whatsapp-client/tracing"
 )
 
-// MessageSender is a function type for sending WhatsApp messages
-type MessageSender func(client *whatsmeow.Client, recipient string, message string, mediaPath string) (bool, string)
+// SendResult is the outcome of sending a WhatsApp message
+type SendResult struct {
+	Success bool
+	// Message describes the outcome, and holds the error when Success is false
+	Message string
+	// MessageID, ChatJID and Timestamp identify the sent message on WhatsApp
+	MessageID string
+	ChatJID   string
+	Timestamp time.Time
+}
+
+// MessageSender is a function type for sending WhatsApp messages. ctx carries
+// the caller's trace.
+type MessageSender func(ctx context.Context, client *whatsmeow.Client, recipient string, message string, mediaPath string, opts SendOptions) SendResult
 
 // MessageScheduler handles the scheduling and sending of messages
 type MessageScheduler struct {
-	schedulerDB   *SchedulerDB
-	whatsappDB    *sql.DB
-	client        *whatsmeow.Client
-	ticker        *time.Ticker
-	stopChan      chan bool
-	messageSender MessageSender
+	schedulerDB    Store
+	whatsappDB     *sql.DB
+	client         *whatsmeow.Client
+	ticker         *time.Ticker
+	preciseTicker  *time.Ticker
+	maintTicker    *time.Ticker
+	messageSender  MessageSender
+	mediaSender    MediaSender
+	mediaChecker   MediaChecker
+	eventHandlerID uint32
+
+	// lifecycleMu guards ctx/cancel so no work is tracked by wg once Stop has begun
+	lifecycleMu sync.Mutex
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+
+	// processMu serializes sending so presence-triggered sends don't race the ticker
+	processMu sync.Mutex
+	// presenceMu guards the set of recipients we've subscribed to presence updates for
+	presenceMu         sync.Mutex
+	presenceSubscribed map[string]bool
+	presenceAnnounced  bool
+
+	// Only the instance holding the processing lease sends messages, so several
+	// bridges can share one scheduler database without double-sending
+	leaseMu    sync.Mutex
+	instanceID string
+	leaseTTL   time.Duration
+	isLeader   bool
+	// needsRecovery is set when this instance takes the lease, until
+	// recoverAfterTakeover has dealt with interrupted sends
+	needsRecovery bool
+
+	maintenanceMu sync.Mutex
+	retention     RetentionOptions
+	backupDir     string
+	backupKeep    int
+
+	quietMu    sync.Mutex
+	quietHours QuietHours
+
+	// listCache holds recent ListMessages results (see SetListCacheTTL)
+	listCache *cache.Cache[[]*ScheduledMessage]
+
+	// onStatusChange is told about status changes (see OnStatusChange)
+	onStatusChange func(StatusChange)
+
+	// lastTick is when the worker last finished handling a tick, in Unix nanoseconds
+	lastTick atomic.Int64
 }
 
+// ScheduleOptions holds optional settings for a scheduled message
+type ScheduleOptions struct {
+	// DeliveryMode is DeliveryModeScheduled (default) or DeliveryModeOnline
+	DeliveryMode string
+	// OnlineWindow is how long before the scheduled time an online-mode message
+	// may be sent early if the recipient comes online
+	OnlineWindow time.Duration
+	// Precision is PrecisionNormal (default) or PrecisionPrecise
+	Precision string
+	// Metadata is arbitrary JSON stored with the message and returned untouched
+	Metadata json.RawMessage
+	// CreatedBy records who scheduled the message
+	CreatedBy string
+	// Media is sent with the message as its caption, when set
+	Media *Media
+	// Typing shows the account typing for TypingDelay before the message is sent
+	Typing bool
+	// SendOptions are how the message is sent
+	SendOptions
+}
+
+// MaxMetadataSize is the largest metadata document accepted for a scheduled message
+const MaxMetadataSize = 16 * 1024
+
+// DefaultOnlineWindow is used for online-mode messages that don't specify a window
+const DefaultOnlineWindow = time.Hour
+
+// PreciseCheckInterval is how often the fast path looks for due precise messages
+const PreciseCheckInterval = 5 * time.Second
+
 // NewMessageScheduler creates a new message scheduler
-func NewMessageScheduler(schedulerDB *SchedulerDB, whatsappDB *sql.DB, client *whatsmeow.Client, messageSender MessageSender) *MessageScheduler {
+func NewMessageScheduler(schedulerDB Store, whatsappDB *sql.DB, client *whatsmeow.Client, messageSender MessageSender) *MessageScheduler {
 	return &MessageScheduler{
 		schedulerDB:   schedulerDB,
 		whatsappDB:    whatsappDB,
 		client:        client,
-		stopChan:      make(chan bool),
 		messageSender: messageSender,
+
+		presenceSubscribed: make(map[string]bool),
+
+		instanceID: newInstanceID(),
+		leaseTTL:   DefaultLeaseTTL,
+
+		retention: DefaultRetentionOptions(),
 	}
 }
 
-// Start begins the scheduler background worker
+// Start begins the scheduler background worker. Normal-precision messages are
+// checked every checkInterval; precise messages every PreciseCheckInterval.
 func (ms *MessageScheduler) Start(checkInterval time.Duration) {
-	log.Println("📅 Starting message scheduler worker...")
+	slog.Info("Starting message scheduler worker", "check_interval", checkInterval)
+	ms.checkIntegrity()
+
 	ms.ticker = time.NewTicker(checkInterval)
+	ms.preciseTicker = time.NewTicker(PreciseCheckInterval)
+	ms.maintTicker = time.NewTicker(MaintenanceInterval)
+
+	ms.lifecycleMu.Lock()
+	ms.ctx, ms.cancel = context.WithCancel(context.Background())
+	ctx := ms.ctx
+	ms.lifecycleMu.Unlock()
+
+	// Subscribe to receipts so sent messages get delivered/read timestamps,
+	// and to presence so online-mode messages can go out when the recipient appears
+	if ms.client != nil {
+		ms.eventHandlerID = ms.client.AddEventHandler(ms.handleEvent)
+	}
 
+	ms.lastTick.Store(time.Now().UnixNano())
+	ms.wg.Add(1)
 	go func() {
+		defer ms.wg.Done()
 		for {
 			select {
 			case <-ms.ticker.C:
 				ms.processScheduledMessages()
-			case <-ms.stopChan:
-				log.Println("📅 Stopping message scheduler worker...")
+			case <-ms.preciseTicker.C:
+				ms.processPreciseMessages()
+			case <-ms.maintTicker.C:
+				ms.runScheduledMaintenance()
+			case <-ctx.Done():
+				slog.Info("Stopping message scheduler worker")
 				return
 			}
+			ms.lastTick.Store(time.Now().UnixNano())
 		}
 	}()
 }
 
-// Stop stops the scheduler
+// Stop stops the scheduler and waits for in-flight sends to finish.
+// It is safe to call Stop more than once, or without a prior Start.
 func (ms *MessageScheduler) Stop() {
+	if ms.client != nil && ms.eventHandlerID != 0 {
+		ms.client.RemoveEventHandler(ms.eventHandlerID)
+		ms.eventHandlerID = 0
+	}
+
+	ms.lifecycleMu.Lock()
+	if ms.cancel == nil {
+		ms.lifecycleMu.Unlock()
+		return
+	}
+	ms.cancel()
+	ms.cancel = nil
+	ms.lifecycleMu.Unlock()
+
+	// The worker finishes the message it is sending before noticing cancellation
+	ms.wg.Wait()
+	ms.releaseLease()
+
 	if ms.ticker != nil {
 		ms.ticker.Stop()
 	}
-	ms.stopChan <- true
+	if ms.preciseTicker != nil {
+		ms.preciseTicker.Stop()
+	}
+	if ms.maintTicker != nil {
+		ms.maintTicker.Stop()
+	}
+	slog.Info("Message scheduler stopped")
+}
+
+// goTracked runs fn in a goroutine that Stop waits for. It does nothing if the
+// scheduler is not running.
+func (ms *MessageScheduler) goTracked(fn func()) {
+	ms.lifecycleMu.Lock()
+	defer ms.lifecycleMu.Unlock()
+
+	if ms.ctx == nil || ms.ctx.Err() != nil {
+		return
+	}
+
+	ms.wg.Add(1)
+	go func() {
+		defer ms.wg.Done()
+		fn()
+	}()
+}
+
+// stopping reports whether Stop has been called, so batch loops can bail out
+// between messages rather than in the middle of a send
+func (ms *MessageScheduler) stopping() bool {
+	ms.lifecycleMu.Lock()
+	defer ms.lifecycleMu.Unlock()
+	return ms.ctx == nil || ms.ctx.Err() != nil
+}
+
+// handleEvent processes WhatsApp events relevant to the scheduler
+func (ms *MessageScheduler) handleEvent(evt interface{}) {
+	switch v := evt.(type) {
+	case *events.Receipt:
+		ms.handleReceipt(v)
+	case *events.Presence:
+		if !v.Unavailable {
+			recipient := v.From.ToNonAD().String()
+			ms.goTracked(func() { ms.handleRecipientOnline(recipient) })
+		}
+	case *events.Connected:
+		// Presence subscriptions don't survive a reconnect
+		ms.presenceMu.Lock()
+		ms.presenceSubscribed = make(map[string]bool)
+		ms.presenceAnnounced = false
+		ms.presenceMu.Unlock()
+	}
+}
+
+// subscribeOnlineRecipients subscribes to presence updates for recipients of
+// online-mode messages whose delivery window has opened
+func (ms *MessageScheduler) subscribeOnlineRecipients(ctx context.Context, now time.Time) error {
+	if ms.client == nil || !ms.client.IsConnected() {
+		return nil
+	}
+
+	messages, err := ms.schedulerDB.GetPendingOnlineMessages(ctx, "", now)
+	if err != nil {
+		return err
+	}
+
+	ms.presenceMu.Lock()
+	defer ms.presenceMu.Unlock()
+
+	for _, msg := range messages {
+		if now.Before(msg.OnlineWindowStart()) || ms.presenceSubscribed[msg.Recipient] {
+			continue
+		}
+
+		jid, err := types.ParseJID(msg.Recipient)
+		if err != nil {
+			slog.WarnContext(ctx, "Invalid recipient for online-mode message", "message_id", msg.ID, "recipient", msg.Recipient, "error", err)
+			continue
+		}
+
+		// WhatsApp only delivers presence updates to clients that are themselves available
+		if !ms.presenceAnnounced {
+			if err := ms.client.SendPresence(types.PresenceAvailable); err != nil {
+				return fmt.Errorf("failed to send presence: %w", err)
+			}
+			ms.presenceAnnounced = true
+		}
+
+		if err := ms.client.SubscribePresence(jid); err != nil {
+			slog.WarnContext(ctx, "Error subscribing to presence", "recipient", msg.Recipient, "error", err)
+			continue
+		}
+		ms.presenceSubscribed[msg.Recipient] = true
+		slog.InfoContext(ctx, "Waiting for recipient to come online", "message_id", msg.ID, "recipient", msg.Recipient)
+	}
+
+	return nil
+}
+
+// handleRecipientOnline sends online-mode messages for a recipient that was just seen online
+func (ms *MessageScheduler) handleRecipientOnline(recipient string) {
+	ctx, span := tracing.Start(context.Background(), "scheduler.recipient_online", tracing.String("recipient", recipient))
+	defer span.End()
+
+	now := time.Now()
+	if ms.inQuietHours(now) {
+		return
+	}
+	messages, err := ms.schedulerDB.GetPendingOnlineMessages(ctx, recipient, now)
+	if err != nil {
+		slog.WarnContext(ctx, "Error getting online-mode messages", "recipient", recipient, "error", err)
+		return
+	}
+
+	ms.processMu.Lock()
+	defer ms.processMu.Unlock()
+
+	if !ms.holdLease(ctx) {
+		return
+	}
+	ms.recoverAfterTakeover(ctx)
+
+	for _, msg := range messages {
+		if ms.stopping() {
+			return
+		}
+		if now.Before(msg.OnlineWindowStart()) {
+			continue
+		}
+
+		// The ticker may have handled it while we were waiting for the lock
+		claimed, err := ms.schedulerDB.ClaimMessage(ctx, msg.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error claiming message", "message_id", msg.ID, "error", err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		msg.Status = "sending"
+
+		slog.InfoContext(ctx, "Recipient is online, sending message early", "message_id", msg.ID, "recipient", recipient)
+		if err := ms.processSingleMessage(ctx, msg); err != nil {
+			slog.ErrorContext(ctx, "Error processing message", "message_id", msg.ID, "recipient", msg.Recipient, "error", err)
+		}
+	}
+}
+
+// handleReceipt records delivery and read receipts for messages sent by the scheduler
+func (ms *MessageScheduler) handleReceipt(receipt *events.Receipt) {
+	// Receipts from our own devices don't tell us anything about the recipient
+	if receipt.IsFromMe {
+		return
+	}
+
+	ctx, span := tracing.Start(context.Background(), "scheduler.receipt", tracing.String("receipt.type", string(receipt.Type)))
+	defer span.End()
+
+	for _, id := range receipt.MessageIDs {
+		var err error
+		switch receipt.Type {
+		case types.ReceiptTypeDelivered:
+			err = ms.schedulerDB.MarkDelivered(ctx, id, receipt.Timestamp)
+		case types.ReceiptTypeRead, types.ReceiptTypePlayed:
+			err = ms.schedulerDB.MarkRead(ctx, id, receipt.Timestamp)
+		default:
+			continue
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "Error recording receipt", "receipt_type", string(receipt.Type), "whatsapp_message_id", id, "error", err)
+		}
+	}
 }
 
 // processScheduledMessages checks and sends messages that are due
 func (ms *MessageScheduler) processScheduledMessages() {
+	// Work that has started is finished even if Stop is called, so the span's
+	// context must not be the worker's cancellable one
+	ctx, span := tracing.Start(context.Background(), "scheduler.tick")
+	defer span.End()
+
+	ms.processMu.Lock()
+	defer ms.processMu.Unlock()
+
+	if !ms.holdLease(ctx) {
+		return
+	}
+	ms.recoverAfterTakeover(ctx)
+
 	now := time.Now()
 
 	// Step 1: Check for future messages that should be paused due to responses
-	if err := ms.checkAndPauseFutureMessages(now); err != nil {
-		log.Printf("⚠️ Error checking future messages: %v", err)
+	if err := ms.checkAndPauseFutureMessages(ctx, now); err != nil {
+		slog.WarnContext(ctx, "Error checking future messages", "error", err)
 	}
 
-	// Step 2: Get pending messages that should be sent now
-	messages, err := ms.schedulerDB.GetPendingMessages(now)
-	if err != nil {
-		log.Printf("❌ Error getting pending messages: %v", err)
+	// Step 2: Watch for recipients of online-mode messages whose window has opened
+	if err := ms.subscribeOnlineRecipients(ctx, now); err != nil {
+		slog.WarnContext(ctx, "Error subscribing to recipient presence", "error", err)
+	}
+
+	// Step 3: Claim pending messages that should be sent now, unless it's quiet hours
+	if ms.inQuietHours(now) {
+		slog.DebugContext(ctx, "Quiet hours, holding back due messages")
 		return
 	}
+	messages, err := ms.schedulerDB.ClaimPendingMessages(ctx, now, "")
+	if err != nil {
+		// Messages claimed before the error are still sent below
+		slog.ErrorContext(ctx, "Error claiming pending messages", "error", err)
+	}
 
 	if len(messages) == 0 {
 		return
 	}
 
-	log.Printf("📬 Processing %d scheduled messages...", len(messages))
+	span.SetAttributes(tracing.Int("scheduler.messages", len(messages)))
+	slog.InfoContext(ctx, "Processing scheduled messages", "count", len(messages))
 
-	for _, msg := range messages {
-		if err := ms.processSingleMessage(msg); err != nil {
-			log.Printf("❌ Error processing message %s: %v", msg.ID, err)
+	ms.sendClaimedMessages(ctx, messages)
+}
+
+// processPreciseMessages is the fast path that sends due precise messages
+// without waiting for the regular tick
+func (ms *MessageScheduler) processPreciseMessages() {
+	ctx, span := tracing.Start(context.Background(), "scheduler.precise_tick")
+	defer span.End()
+
+	ms.processMu.Lock()
+	defer ms.processMu.Unlock()
+
+	if !ms.holdLease(ctx) {
+		return
+	}
+	ms.recoverAfterTakeover(ctx)
+
+	now := time.Now()
+	if ms.inQuietHours(now) {
+		return
+	}
+	messages, err := ms.schedulerDB.ClaimPendingMessages(ctx, now, PrecisionPrecise)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error claiming pending precise messages", "error", err)
+	}
+	span.SetAttributes(tracing.Int("scheduler.messages", len(messages)))
+
+	ms.sendClaimedMessages(ctx, messages)
+}
+
+// sendClaimedMessages sends claimed messages in order. If the scheduler stops or
+// loses its lease partway through, the unsent messages are handed back as pending.
+func (ms *MessageScheduler) sendClaimedMessages(ctx context.Context, messages []*ScheduledMessage) {
+	for i, msg := range messages {
+		// Renew the lease as we go so a long batch can't outlive it
+		if ms.stopping() || !ms.holdLease(ctx) {
+			for _, unsent := range messages[i:] {
+				if err := ms.schedulerDB.ReleaseClaim(ctx, unsent.ID); err != nil {
+					slog.ErrorContext(ctx, "Error releasing claim on message", "message_id", unsent.ID, "error", err)
+				}
+			}
+			return
+		}
+		if err := ms.processSingleMessage(ctx, msg); err != nil {
+			slog.ErrorContext(ctx, "Error processing message", "message_id", msg.ID, "recipient", msg.Recipient, "error", err)
 		}
 	}
 }
 
+// historyStore is implemented by stores that share a database with the
+// WhatsApp message history and can find responded messages with a JOIN
+type historyStore interface {
+	hasMessageHistory() bool
+	GetRespondedMessages(ctx context.Context) ([]*ScheduledMessage, error)
+}
+
 // checkAndPauseFutureMessages checks if any future pending messages should be paused
-func (ms *MessageScheduler) checkAndPauseFutureMessages(now time.Time) error {
+func (ms *MessageScheduler) checkAndPauseFutureMessages(ctx context.Context, now time.Time) error {
+	if hs, ok := ms.schedulerDB.(historyStore); ok && hs.hasMessageHistory() {
+		responded, err := hs.GetRespondedMessages(ctx)
+		if err != nil {
+			return err
+		}
+		for _, msg := range responded {
+			slog.InfoContext(ctx, "Pausing message, recipient has responded", "message_id", msg.ID, "recipient", msg.Recipient, "status", "paused")
+			if err := ms.updateStatus(ctx, msg.ID, "paused", nil, stringPtr("Recipient responded before scheduled time")); err != nil {
+				slog.ErrorContext(ctx, "Error pausing message", "message_id", msg.ID, "error", err)
+			}
+		}
+		return nil
+	}
+
 	// Get all pending messages with check_for_response = true
-	allPending, err := ms.schedulerDB.GetAllScheduledMessages("pending", "")
+	pending, err := ms.schedulerDB.GetPendingResponseChecks(ctx)
 	if err != nil {
 		return err
 	}
+	if len(pending) == 0 {
+		return nil
+	}
 
-	for _, msg := range allPending {
-		if !msg.CheckForResponse {
-			continue
+	// Look up the latest incoming message of every recipient in one batch
+	recipients := make([]string, 0, len(pending))
+	seen := make(map[string]bool)
+	for _, msg := range pending {
+		if !seen[msg.Recipient] {
+			seen[msg.Recipient] = true
+			recipients = append(recipients, msg.Recipient)
 		}
+	}
+	lastIncoming, err := ms.lastIncomingTimes(ctx, recipients)
+	if err != nil {
+		return err
+	}
 
+	for _, msg := range pending {
 		// Check if recipient has sent a message after the scheduled message was created
-		hasNewMessage, err := ms.hasRecipientResponded(msg.Recipient, msg.CreatedAt)
-		if err != nil {
-			log.Printf("⚠️ Error checking response for %s: %v", msg.ID, err)
-			continue
-		}
-
-		if hasNewMessage {
+		if last, ok := lastIncoming[msg.Recipient]; ok && last.After(msg.CreatedAt) {
 			// Pause the message
-			log.Printf("⏸️ Pausing message %s - recipient %s has responded", msg.ID, msg.Recipient)
-			if err := ms.schedulerDB.UpdateMessageStatus(msg.ID, "paused", nil, stringPtr("Recipient responded before scheduled time")); err != nil {
-				log.Printf("❌ Error pausing message %s: %v", msg.ID, err)
+			slog.InfoContext(ctx, "Pausing message, recipient has responded", "message_id", msg.ID, "recipient", msg.Recipient, "status", "paused")
+			if err := ms.updateStatus(ctx, msg.ID, "paused", nil, stringPtr("Recipient responded before scheduled time")); err != nil {
+				slog.ErrorContext(ctx, "Error pausing message", "message_id", msg.ID, "error", err)
 			}
 		}
 	}
@@ -122,23 +531,32 @@ func (ms *MessageScheduler) checkAndPauseFutureMessages(now time.Time) error {
 }
 
 // processSingleMessage processes and sends a single scheduled message
-func (ms *MessageScheduler) processSingleMessage(msg *ScheduledMessage) error {
+func (ms *MessageScheduler) processSingleMessage(ctx context.Context, msg *ScheduledMessage) (err error) {
+	ctx, span := tracing.Start(ctx, "scheduler.send",
+		tracing.String("message.id", msg.ID),
+		tracing.String("recipient", msg.Recipient),
+	)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
 	// Check if we should send the message (verify condition)
 	shouldSend := true
 
 	if msg.CheckForResponse {
-		hasResponded, err := ms.hasRecipientResponded(msg.Recipient, msg.CreatedAt)
+		hasResponded, err := ms.hasRecipientResponded(ctx, msg.Recipient, msg.CreatedAt)
 		if err != nil {
 			errMsg := fmt.Sprintf("Error checking recipient response: %v", err)
-			ms.schedulerDB.UpdateMessageStatus(msg.ID, "failed", nil, &errMsg)
+			ms.updateStatus(ctx, msg.ID, "failed", nil, &errMsg)
 			return err
 		}
 
 		if hasResponded {
 			// Don't send - recipient has responded
 			shouldSend = false
-			log.Printf("⏸️ Pausing message %s - recipient %s has responded", msg.ID, msg.Recipient)
-			return ms.schedulerDB.UpdateMessageStatus(msg.ID, "paused", nil, stringPtr("Recipient responded before scheduled time"))
+			slog.InfoContext(ctx, "Pausing message, recipient has responded", "message_id", msg.ID, "recipient", msg.Recipient, "status", "paused")
+			return ms.updateStatus(ctx, msg.ID, "paused", nil, stringPtr("Recipient responded before scheduled time"))
 		}
 	}
 
@@ -147,55 +565,248 @@ func (ms *MessageScheduler) processSingleMessage(msg *ScheduledMessage) error {
 	}
 
 	// Send the message
-	log.Printf("📤 Sending scheduled message %s to %s", msg.ID, msg.Recipient)
-	
-	success, errMsg := ms.messageSender(ms.client, msg.Recipient, msg.Message, "")
-	if !success {
-		ms.schedulerDB.UpdateMessageStatus(msg.ID, "failed", nil, &errMsg)
-		return fmt.Errorf("failed to send message: %s", errMsg)
+	slog.InfoContext(ctx, "Sending scheduled message", "message_id", msg.ID, "recipient", msg.Recipient)
+
+	attempt, err := ms.schedulerDB.StartSendAttempt(ctx, msg.ID, time.Now())
+	if err != nil {
+		slog.WarnContext(ctx, "Error recording send attempt", "message_id", msg.ID, "error", err)
 	}
 
-	// Mark as sent
+	if msg.Typing {
+		ms.showTyping(ctx, msg)
+	}
+
+	start := time.Now()
+	var result SendResult
+	if msg.Media != nil {
+		result = ms.sendMedia(ctx, msg)
+	} else {
+		result = ms.messageSender(ctx, ms.client, msg.Recipient, msg.Message, "", msg.SendOptions)
+	}
 	now := time.Now()
-	if err := ms.schedulerDB.UpdateMessageStatus(msg.ID, "sent", &now, nil); err != nil {
+	latency := now.Sub(start)
+
+	if attempt > 0 {
+		var attemptErr *string
+		if !result.Success {
+			attemptErr = &result.Message
+		}
+		if err := ms.schedulerDB.FinishSendAttempt(ctx, msg.ID, attempt, now, result.Success, attemptErr); err != nil {
+			slog.WarnContext(ctx, "Error recording result of send attempt", "message_id", msg.ID, "attempt", attempt, "error", err)
+		}
+	}
+
+	if !result.Success {
+		ms.updateStatus(ctx, msg.ID, "failed", nil, &result.Message)
+		return fmt.Errorf("failed to send message: %s", result.Message)
+	}
+
+	// Mark as sent
+	if err := ms.updateStatus(ctx, msg.ID, "sent", &now, nil); err != nil {
 		return err
 	}
 
-	log.Printf("✅ Successfully sent scheduled message %s to %s", msg.ID, msg.Recipient)
+	// Remember where the message went so receipts can be matched to this row and
+	// later tooling can react to, quote or revoke it
+	if err := ms.schedulerDB.SetWhatsAppMessageID(ctx, msg.ID, result.MessageID, result.ChatJID); err != nil {
+		slog.WarnContext(ctx, "Error storing WhatsApp message ID", "message_id", msg.ID, "error", err)
+	}
+
+	slog.InfoContext(ctx, "Sent scheduled message", "message_id", msg.ID, "recipient", msg.Recipient, "status", "sent", "latency", latency)
 	return nil
 }
 
 // hasRecipientResponded checks if the recipient has sent a message after the given time
-func (ms *MessageScheduler) hasRecipientResponded(recipient string, afterTime time.Time) (bool, error) {
+func (ms *MessageScheduler) hasRecipientResponded(ctx context.Context, recipient string, afterTime time.Time) (bool, error) {
 	// Normalize recipient to JID format if needed
 	recipientJID := recipient
 	if !contains(recipient, "@") {
 		recipientJID = recipient + "@s.whatsapp.net"
 	}
 
-	// Query the messages table for any message from this recipient after the given time
-	var count int
-	err := ms.whatsappDB.QueryRow(`
-		SELECT COUNT(*)
-		FROM messages
-		WHERE sender = ?
-		  AND is_from_me = 0
-		  AND timestamp > ?
-		LIMIT 1
-	`, recipientJID, afterTime.Format(time.RFC3339)).Scan(&count)
+	// Query the messages table for any incoming message in this chat after the given time.
+	// Timestamps are stored with their own UTC offset, so compare them as julian days.
+	var exists int
+	err := ms.queryMessagesRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM messages
+			WHERE chat_jid = ?
+			  AND is_from_me = 0
+			  AND julianday(timestamp) > julianday(?)
+		)
+	`, recipientJID, afterTime).Scan(&exists)
+
+	if err != nil {
+		return false, err
+	}
+
+	return exists == 1, nil
+}
 
+// hasMessage checks if the message history holds the message in the chat
+func (ms *MessageScheduler) hasMessage(ctx context.Context, chatJID, id string) (bool, error) {
+	var exists int
+	err := ms.queryMessagesRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM messages
+			WHERE id = ?
+			  AND chat_jid = ?
+		)
+	`, id, chatJID).Scan(&exists)
 	if err != nil {
 		return false, err
 	}
+	return exists == 1, nil
+}
+
+// lastIncomingBatchSize keeps the IN list below SQLite's bound parameter limit
+const lastIncomingBatchSize = 500
+
+// lastIncomingTimes returns when each recipient last wrote to us. Recipients
+// who never did are missing from the map.
+func (ms *MessageScheduler) lastIncomingTimes(ctx context.Context, recipients []string) (map[string]time.Time, error) {
+	result := make(map[string]time.Time, len(recipients))
+
+	for start := 0; start < len(recipients); start += lastIncomingBatchSize {
+		end := min(start+lastIncomingBatchSize, len(recipients))
+		batch := recipients[start:end]
+
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(batch)), ", ")
+		args := make([]interface{}, len(batch))
+		for i, recipient := range batch {
+			args[i] = recipient
+		}
+
+		rows, err := ms.queryMessages(ctx, `
+			SELECT chat_jid, MAX(julianday(timestamp))
+			FROM messages
+			WHERE chat_jid IN (`+placeholders+`)
+			  AND is_from_me = 0
+			GROUP BY chat_jid
+		`, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var chatJID string
+			var julianDay sql.NullFloat64
+			if err := rows.Scan(&chatJID, &julianDay); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if julianDay.Valid {
+				result[chatJID] = julianDayToTime(julianDay.Float64)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	return count > 0, nil
+	return result, nil
+}
+
+// queryMessages runs a query against the WhatsApp message history
+func (ms *MessageScheduler) queryMessages(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := startMessagesSpan(ctx, query)
+	defer span.End()
+	rows, err := ms.whatsappDB.QueryContext(ctx, query, args...)
+	span.RecordError(err)
+	return rows, err
+}
+
+// queryMessagesRow runs a single-row query against the WhatsApp message history
+func (ms *MessageScheduler) queryMessagesRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, span := startMessagesSpan(ctx, query)
+	defer span.End()
+	return ms.whatsappDB.QueryRowContext(ctx, query, args...)
+}
+
+// startMessagesSpan begins the span for one query against the message history
+func startMessagesSpan(ctx context.Context, query string) (context.Context, tracing.Span) {
+	return tracing.Start(ctx, "db SELECT",
+		tracing.String("db.system", "sqlite"),
+		tracing.String("db.name", "messages"),
+		tracing.String("db.statement", strings.TrimSpace(query)),
+	)
+}
+
+// julianDayToTime converts a SQLite julian day number to a time
+func julianDayToTime(julianDay float64) time.Time {
+	const unixEpochJulianDay = 2440587.5
+	millis := (julianDay - unixEpochJulianDay) * 86400 * 1000
+	return time.UnixMilli(int64(millis))
+}
+
+// ValidationError reports a ScheduleMessage argument the caller has to fix.
+// Code is one of the apierror codes.
+type ValidationError struct {
+	Code    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
 }
 
 // ScheduleMessage creates a new scheduled message
-func (ms *MessageScheduler) ScheduleMessage(recipient string, message string, scheduledTime time.Time, checkForResponse bool) (*ScheduledMessage, error) {
+func (ms *MessageScheduler) ScheduleMessage(ctx context.Context, recipient string, message string, scheduledTime time.Time, checkForResponse bool, opts ScheduleOptions) (*ScheduledMessage, error) {
 	// Validate scheduled time is in the future
 	if scheduledTime.Before(time.Now()) {
-		return nil, fmt.Errorf("scheduled time must be in the future")
+		return nil, &ValidationError{Code: apierror.CodeInvalidTime, Message: "scheduled time must be in the future"}
+	}
+
+	// Validate delivery mode
+	var onlineWindowMinutes int
+	switch opts.DeliveryMode {
+	case "":
+		opts.DeliveryMode = DeliveryModeScheduled
+	case DeliveryModeScheduled:
+	case DeliveryModeOnline:
+		if opts.OnlineWindow < 0 {
+			return nil, &ValidationError{Code: apierror.CodeInvalidRequest, Message: "online window must not be negative"}
+		}
+		if opts.OnlineWindow == 0 {
+			opts.OnlineWindow = DefaultOnlineWindow
+		}
+		onlineWindowMinutes = int(opts.OnlineWindow / time.Minute)
+	default:
+		return nil, &ValidationError{Code: apierror.CodeInvalidRequest, Message: fmt.Sprintf("invalid delivery mode %q", opts.DeliveryMode)}
+	}
+
+	// Validate precision
+	switch opts.Precision {
+	case "":
+		opts.Precision = PrecisionNormal
+	case PrecisionNormal, PrecisionPrecise:
+	default:
+		return nil, &ValidationError{Code: apierror.CodeInvalidRequest, Message: fmt.Sprintf("invalid precision %q", opts.Precision)}
+	}
+
+	// Validate metadata; JSON null is the same as no metadata
+	if len(opts.Metadata) > MaxMetadataSize {
+		return nil, &ValidationError{Code: apierror.CodeInvalidRequest, Message: fmt.Sprintf("metadata must be at most %d bytes", MaxMetadataSize)}
+	}
+	if len(opts.Metadata) > 0 && !json.Valid(opts.Metadata) {
+		return nil, &ValidationError{Code: apierror.CodeInvalidRequest, Message: "metadata must be valid JSON"}
+	}
+	if string(opts.Metadata) == "null" {
+		opts.Metadata = nil
+	}
+
+	// Check the media file now, rather than failing at send time
+	if opts.Media != nil {
+		if ms.mediaChecker == nil {
+			return nil, &ValidationError{Code: apierror.CodeInvalidRequest, Message: "this bridge can't send media"}
+		}
+		if err := ms.mediaChecker(ctx, *opts.Media); err != nil {
+			return nil, &ValidationError{Code: apierror.CodeInvalidRequest, Message: err.Error()}
+		}
 	}
 
 	// Normalize recipient to JID format if needed
@@ -204,10 +815,24 @@ func (ms *MessageScheduler) ScheduleMessage(recipient string, message string, sc
 		recipientJID = recipient + "@s.whatsapp.net"
 	}
 
+	// The quoted message has to be stored to build the reply at send time
+	if opts.QuotedMessageID != "" {
+		stored, err := ms.hasMessage(ctx, recipientJID, opts.QuotedMessageID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up quoted message: %w", err)
+		}
+		if !stored {
+			return nil, &ValidationError{Code: apierror.CodeInvalidRequest, Message: fmt.Sprintf("quoted message %s is not in the chat", opts.QuotedMessageID)}
+		}
+	}
+
+	// Stored with its tags, so the message reads as it will be sent
+	message = opts.MentionText(message)
+
 	// Get last message time from recipient
-	lastMessageAt, err := ms.getLastMessageTime(recipientJID)
+	lastMessageAt, err := ms.getLastMessageTime(ctx, recipientJID)
 	if err != nil {
-		log.Printf("⚠️ Could not get last message time for %s: %v", recipientJID, err)
+		slog.WarnContext(ctx, "Could not get last message time", "recipient", recipientJID, "error", err)
 		// Continue anyway with zero time
 		lastMessageAt = time.Time{}
 	}
@@ -222,37 +847,36 @@ func (ms *MessageScheduler) ScheduleMessage(recipient string, message string, sc
 		LastMessageAt:    lastMessageAt,
 		CheckForResponse: checkForResponse,
 		Status:           "pending",
+
+		DeliveryMode:        opts.DeliveryMode,
+		OnlineWindowMinutes: onlineWindowMinutes,
+		Precision:           opts.Precision,
+		Metadata:            opts.Metadata,
+		CreatedBy:           opts.CreatedBy,
+		Media:               opts.Media,
+		Typing:              opts.Typing,
+		SendOptions:         opts.SendOptions,
 	}
 
 	// Insert into database
-	if err := ms.schedulerDB.InsertScheduledMessage(scheduledMsg); err != nil {
+	if err := ms.schedulerDB.InsertScheduledMessage(ctx, scheduledMsg); err != nil {
 		return nil, fmt.Errorf("failed to insert scheduled message: %w", err)
 	}
+	ms.notifyStatus(scheduledMsg.ID, scheduledMsg.Status, nil)
 
-	log.Printf("✅ Scheduled message %s for %s at %s", scheduledMsg.ID, recipient, scheduledTime.Format(time.RFC3339))
+	slog.InfoContext(ctx, "Scheduled message", "message_id", scheduledMsg.ID, "recipient", scheduledMsg.Recipient, "scheduled_time", scheduledTime.Format(time.RFC3339), "status", scheduledMsg.Status)
 	return scheduledMsg, nil
 }
 
 // getLastMessageTime gets the timestamp of the last message received from a recipient
-func (ms *MessageScheduler) getLastMessageTime(recipient string) (time.Time, error) {
-	var timestamp string
-	err := ms.whatsappDB.QueryRow(`
-		SELECT timestamp
-		FROM messages
-		WHERE sender = ?
-		  AND is_from_me = 0
-		ORDER BY timestamp DESC
-		LIMIT 1
-	`, recipient).Scan(&timestamp)
-
-	if err == sql.ErrNoRows {
-		return time.Time{}, nil // No messages found
-	}
+func (ms *MessageScheduler) getLastMessageTime(ctx context.Context, recipient string) (time.Time, error) {
+	lastIncoming, err := ms.lastIncomingTimes(ctx, []string{recipient})
 	if err != nil {
 		return time.Time{}, err
 	}
 
-	return time.Parse(time.RFC3339, timestamp)
+	// Zero if no messages were found
+	return lastIncoming[recipient], nil
 }
 
 // Helper functions
@@ -262,8 +886,8 @@ func stringPtr(s string) *string {
 }
 
 func contains(s string, substr string) bool {
-	return len(s) > 0 && len(substr) > 0 && s != "" && substr != "" && 
-		   (s == substr || (len(s) > len(substr) && (s[:len(substr)] == substr || s[len(s)-len(substr):] == substr || containsMiddle(s, substr))))
+	return len(s) > 0 && len(substr) > 0 && s != "" && substr != "" &&
+		(s == substr || (len(s) > len(substr) && (s[:len(substr)] == substr || s[len(s)-len(substr):] == substr || containsMiddle(s, substr))))
 }
 
 func containsMiddle(s string, substr string) bool {
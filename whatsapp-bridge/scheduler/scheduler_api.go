@@ -0,0 +1,124 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"whatsapp-client/cache"
+)
+
+var (
+	// ErrNotCancellable is returned by Cancel for a message that is no longer
+	// pending or paused
+	ErrNotCancellable = errors.New("can only cancel pending or paused messages")
+	// ErrNotPausable is returned by Pause for a message that isn't pending
+	ErrNotPausable = errors.New("can only pause pending messages")
+	// ErrNotResumable is returned by Resume for a message that isn't paused
+	ErrNotResumable = errors.New("can only resume paused messages")
+)
+
+// GetMessage returns the scheduled message with the given ID, or
+// ErrMessageNotFound
+func (ms *MessageScheduler) GetMessage(ctx context.Context, id string) (*ScheduledMessage, error) {
+	return ms.schedulerDB.GetScheduledMessage(ctx, id)
+}
+
+// GetSentMessage returns the message the scheduler sent with the given
+// WhatsApp message ID, or ErrMessageNotFound
+func (ms *MessageScheduler) GetSentMessage(ctx context.Context, waMessageID string) (*ScheduledMessage, error) {
+	return ms.schedulerDB.GetSentMessage(ctx, waMessageID)
+}
+
+// MarkRevoked records that the sent message with the given WhatsApp message ID
+// was deleted for everyone
+func (ms *MessageScheduler) MarkRevoked(ctx context.Context, waMessageID string, revokedAt time.Time) error {
+	return ms.schedulerDB.MarkRevoked(ctx, waMessageID, revokedAt)
+}
+
+// Pause holds back a pending message and returns it as it is now. It fails
+// with ErrMessageNotFound or ErrNotPausable.
+func (ms *MessageScheduler) Pause(ctx context.Context, id string) (*ScheduledMessage, error) {
+	msg, err := ms.schedulerDB.GetScheduledMessage(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Status != "pending" {
+		return nil, ErrNotPausable
+	}
+
+	if err := ms.updateStatus(ctx, id, "paused", nil, stringPtr("Paused by user")); err != nil {
+		return nil, err
+	}
+	msg.Status = "paused"
+	msg.ErrorMessage = stringPtr("Paused by user")
+	return msg, nil
+}
+
+// Resume returns a paused message to pending and returns it as it is now. It
+// fails with ErrMessageNotFound or ErrNotResumable.
+func (ms *MessageScheduler) Resume(ctx context.Context, id string) (*ScheduledMessage, error) {
+	msg, err := ms.schedulerDB.GetScheduledMessage(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Status != "paused" {
+		return nil, ErrNotResumable
+	}
+
+	if err := ms.updateStatus(ctx, id, "pending", nil, nil); err != nil {
+		return nil, err
+	}
+	msg.Status = "pending"
+	msg.ErrorMessage = nil
+	return msg, nil
+}
+
+// ListMessages returns the messages matching filter, latest scheduled time
+// first. Results may come from the list cache, so callers must not modify them.
+func (ms *MessageScheduler) ListMessages(ctx context.Context, filter MessageFilter) ([]*ScheduledMessage, error) {
+	versioned, ok := ms.schedulerDB.(interface{ Version() uint64 })
+	if !ok || ms.listCache == nil {
+		return ms.schedulerDB.GetAllScheduledMessages(ctx, filter)
+	}
+	return ms.listCache.Get(filter.cacheKey(), versioned.Version(), func() ([]*ScheduledMessage, error) {
+		return ms.schedulerDB.GetAllScheduledMessages(ctx, filter)
+	})
+}
+
+// SetListCacheTTL keeps ListMessages results for up to ttl, so dashboards
+// polling the list don't each query the database. Any write through this
+// bridge drops them earlier. A ttl of 0 turns the cache off. It must be called
+// before Start.
+func (ms *MessageScheduler) SetListCacheTTL(ttl time.Duration) {
+	ms.listCache = cache.New[[]*ScheduledMessage](ttl)
+}
+
+// Cancel cancels a pending or paused message and returns it as it is now. It
+// fails with ErrMessageNotFound or ErrNotCancellable.
+func (ms *MessageScheduler) Cancel(ctx context.Context, id string) (*ScheduledMessage, error) {
+	msg, err := ms.schedulerDB.GetScheduledMessage(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Status != "pending" && msg.Status != "paused" {
+		return nil, ErrNotCancellable
+	}
+
+	if err := ms.updateStatus(ctx, id, "cancelled", nil, stringPtr("Cancelled by user")); err != nil {
+		return nil, err
+	}
+	msg.Status = "cancelled"
+	msg.ErrorMessage = stringPtr("Cancelled by user")
+	return msg, nil
+}
+
+// cacheKey identifies the filter in the list cache
+func (f MessageFilter) cacheKey() string {
+	key := fmt.Sprintf("%q %q %q %d", f.Status, f.Recipient, f.CreatedBy, f.Limit)
+	if f.After != nil {
+		key += fmt.Sprintf(" %d %q", f.After.ScheduledTime.UnixNano(), f.After.ID)
+	}
+	return key
+}
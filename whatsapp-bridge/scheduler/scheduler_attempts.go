@@ -0,0 +1,95 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// SendAttempt records one attempt to send a scheduled message
+type SendAttempt struct {
+	MessageID  string     `json:"message_id"`
+	Attempt    int        `json:"attempt"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Success    bool       `json:"success"`
+	Error      *string    `json:"error,omitempty"`
+}
+
+// StartSendAttempt records the start of a new attempt for messageID and returns its number
+func (sdb *SchedulerDB) StartSendAttempt(ctx context.Context, messageID string, startedAt time.Time) (int, error) {
+	var attempt int
+	if err := sdb.queryRow(ctx, "SELECT COALESCE(MAX(attempt), 0) + 1 FROM send_attempts WHERE message_id = ?", messageID).Scan(&attempt); err != nil {
+		return 0, err
+	}
+
+	// Only the lease holder sends, so the attempt number can't race with another instance
+	_, err := sdb.exec(ctx, `
+		INSERT INTO send_attempts (message_id, attempt, started_at, success)
+		VALUES (?, ?, ?, ?)
+	`, messageID, attempt, startedAt, false)
+	if err != nil {
+		return 0, err
+	}
+	return attempt, nil
+}
+
+// FinishSendAttempt records the outcome of an attempt started with StartSendAttempt
+func (sdb *SchedulerDB) FinishSendAttempt(ctx context.Context, messageID string, attempt int, finishedAt time.Time, success bool, errorMsg *string) error {
+	_, err := sdb.exec(ctx, `
+		UPDATE send_attempts
+		SET finished_at = ?, success = ?, error = ?
+		WHERE message_id = ? AND attempt = ?
+	`, finishedAt, success, errorMsg, messageID, attempt)
+	return err
+}
+
+// GetSendAttempts retrieves the attempts for messageID, oldest first
+func (sdb *SchedulerDB) GetSendAttempts(ctx context.Context, messageID string) ([]*SendAttempt, error) {
+	return sdb.querySendAttempts(ctx, `
+		SELECT message_id, attempt, started_at, finished_at, success, error
+		FROM send_attempts
+		WHERE message_id = ?
+		ORDER BY attempt ASC
+	`, messageID)
+}
+
+// GetAllSendAttempts retrieves every recorded attempt
+func (sdb *SchedulerDB) GetAllSendAttempts(ctx context.Context) ([]*SendAttempt, error) {
+	return sdb.querySendAttempts(ctx, `
+		SELECT message_id, attempt, started_at, finished_at, success, error
+		FROM send_attempts
+		ORDER BY message_id, attempt ASC
+	`)
+}
+
+// querySendAttempts runs a send_attempts query and scans the rows
+func (sdb *SchedulerDB) querySendAttempts(ctx context.Context, query string, args ...interface{}) ([]*SendAttempt, error) {
+	rows, err := sdb.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []*SendAttempt{}
+	for rows.Next() {
+		attempt := &SendAttempt{}
+		var finishedAt sql.NullTime
+		var errorMsg sql.NullString
+
+		if err := rows.Scan(&attempt.MessageID, &attempt.Attempt, &attempt.StartedAt, &finishedAt, &attempt.Success, &errorMsg); err != nil {
+			return nil, err
+		}
+
+		if finishedAt.Valid {
+			attempt.FinishedAt = &finishedAt.Time
+		}
+		if errorMsg.Valid {
+			attempt.Error = &errorMsg.String
+		}
+
+		attempts = append(attempts, attempt)
+	}
+
+	return attempts, rows.Err()
+}
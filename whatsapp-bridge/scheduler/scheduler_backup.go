@@ -0,0 +1,391 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"whatsapp-client/apierror"
+)
+
+// BackupFormatVersion is bumped whenever the export format changes incompatibly
+const BackupFormatVersion = 1
+
+// Backup is a full dump of the scheduler data, including archived history
+type Backup struct {
+	Version    int                 `json:"version"`
+	ExportedAt time.Time           `json:"exported_at"`
+	Messages   []*ScheduledMessage `json:"messages"`
+	Archived   []*ScheduledMessage `json:"archived"`
+	Attempts   []*SendAttempt      `json:"attempts,omitempty"`
+}
+
+// RestoreResult reports what an import did
+type RestoreResult struct {
+	Imported int `json:"imported"`
+	Archived int `json:"archived"`
+	Attempts int `json:"attempts"`
+	Skipped  int `json:"skipped"`
+}
+
+// BackupInterval is how often the backup writer saves a new file
+const BackupInterval = 24 * time.Hour
+
+// DefaultBackupKeep is how many backup files are kept when no limit is configured
+const DefaultBackupKeep = 7
+
+const backupFilePrefix = "scheduler-backup-"
+
+// GetArchivedMessages retrieves every message in the archive table
+func (sdb *SchedulerDB) GetArchivedMessages(ctx context.Context) ([]*ScheduledMessage, error) {
+	rows, err := sdb.query(ctx, "SELECT id, payload FROM scheduled_messages_archive ORDER BY scheduled_time ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*ScheduledMessage
+	for rows.Next() {
+		var id, payload string
+		if err := rows.Scan(&id, &payload); err != nil {
+			return nil, err
+		}
+
+		msg, err := sdb.decodeArchivePayload(id, payload)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+
+	return messages, rows.Err()
+}
+
+// RestoreMessages inserts messages, archived messages and send attempts from a
+// backup in a single transaction. Rows that already exist are left untouched.
+func (sdb *SchedulerDB) RestoreMessages(ctx context.Context, messages []*ScheduledMessage, archived []*ScheduledMessage, attempts []*SendAttempt) (*RestoreResult, error) {
+	tx, err := sdb.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result := &RestoreResult{}
+
+	for _, msg := range messages {
+		body, err := sdb.encrypt(msg.Message, msg.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := sdb.txExec(ctx, tx, `
+			INSERT INTO scheduled_messages
+			(id, recipient, message, scheduled_time, created_at, last_message_at, check_for_response, status,
+			 sent_at, error_message, whatsapp_message_id, delivered_at, read_at,
+			 delivery_mode, online_window_minutes, precision, chat_jid, metadata, created_by, media, quoted_message_id,
+			 revoked_at, mentions, link_preview, view_once, typing, background_color, font, audience)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (id) DO NOTHING
+		`,
+			msg.ID,
+			msg.Recipient,
+			body,
+			msg.ScheduledTime,
+			msg.CreatedAt,
+			msg.LastMessageAt,
+			msg.CheckForResponse,
+			msg.Status,
+			msg.SentAt,
+			msg.ErrorMessage,
+			nullIfEmpty(msg.WhatsAppMessageID),
+			msg.DeliveredAt,
+			msg.ReadAt,
+			msg.DeliveryMode,
+			msg.OnlineWindowMinutes,
+			msg.Precision,
+			nullIfEmpty(msg.ChatJID),
+			metadataValue(msg.Metadata),
+			nullIfEmpty(msg.CreatedBy),
+			mediaValue(msg.Media),
+			nullIfEmpty(msg.QuotedMessageID),
+			msg.RevokedAt,
+			jidsValue(msg.Mentions),
+			msg.LinkPreview,
+			msg.ViewOnce,
+			msg.Typing,
+			nullIfEmpty(msg.BackgroundColor),
+			nullIfEmpty(msg.Font),
+			jidsValue(msg.Audience),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore message %s: %w", msg.ID, err)
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			result.Imported++
+		} else {
+			result.Skipped++
+		}
+	}
+
+	now := time.Now()
+	for _, msg := range archived {
+		payload, err := sdb.encodeArchivePayload(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := sdb.txExec(ctx, tx, `
+			INSERT INTO scheduled_messages_archive (id, recipient, status, scheduled_time, payload, archived_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (id) DO NOTHING
+		`, msg.ID, msg.Recipient, msg.Status, msg.ScheduledTime, payload, now)
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore archived message %s: %w", msg.ID, err)
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			result.Archived++
+		} else {
+			result.Skipped++
+		}
+	}
+
+	for _, attempt := range attempts {
+		res, err := sdb.txExec(ctx, tx, `
+			INSERT INTO send_attempts (message_id, attempt, started_at, finished_at, success, error)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (message_id, attempt) DO NOTHING
+		`, attempt.MessageID, attempt.Attempt, attempt.StartedAt, attempt.FinishedAt, attempt.Success, attempt.Error)
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore attempt %d of message %s: %w", attempt.Attempt, attempt.MessageID, err)
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			result.Attempts++
+		}
+	}
+
+	if err := sdb.commit(tx); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// encodeArchivePayload serializes an archived message, encrypting it when a key is configured
+func (sdb *SchedulerDB) encodeArchivePayload(msg *ScheduledMessage) (string, error) {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode message %s: %w", msg.ID, err)
+	}
+	return sdb.encrypt(string(payload), msg.ID)
+}
+
+// decodeArchivePayload reverses encodeArchivePayload for the archived message
+// with ID id
+func (sdb *SchedulerDB) decodeArchivePayload(id, payload string) (*ScheduledMessage, error) {
+	plaintext, err := sdb.decrypt(payload, id)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &ScheduledMessage{}
+	if err := json.Unmarshal([]byte(plaintext), msg); err != nil {
+		return nil, fmt.Errorf("failed to decode archived message: %w", err)
+	}
+	return msg, nil
+}
+
+// nullIfEmpty stores empty strings as NULL
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// Export builds a full backup of the scheduler data
+func (ms *MessageScheduler) Export(ctx context.Context) (*Backup, error) {
+	messages, err := ms.schedulerDB.GetAllScheduledMessages(ctx, MessageFilter{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scheduled messages: %w", err)
+	}
+	archived, err := ms.schedulerDB.GetArchivedMessages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archived messages: %w", err)
+	}
+	attempts, err := ms.schedulerDB.GetAllSendAttempts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read send attempts: %w", err)
+	}
+
+	if messages == nil {
+		messages = []*ScheduledMessage{}
+	}
+	if archived == nil {
+		archived = []*ScheduledMessage{}
+	}
+
+	return &Backup{
+		Version:    BackupFormatVersion,
+		ExportedAt: time.Now(),
+		Messages:   messages,
+		Archived:   archived,
+		Attempts:   attempts,
+	}, nil
+}
+
+// Import restores a backup created by Export. Existing messages are kept, and
+// messages that were mid-send when the backup was taken go back to pending.
+func (ms *MessageScheduler) Import(ctx context.Context, backup *Backup) (*RestoreResult, error) {
+	if backup.Version != BackupFormatVersion {
+		return nil, &ValidationError{Code: apierror.CodeInvalidRequest, Message: fmt.Sprintf("unsupported backup version %d", backup.Version)}
+	}
+
+	for _, msg := range backup.Messages {
+		if msg.ID == "" || msg.Recipient == "" {
+			return nil, &ValidationError{Code: apierror.CodeInvalidRequest, Message: "backup contains a message without id or recipient"}
+		}
+		if msg.Status == "sending" {
+			msg.Status = "pending"
+		}
+		if msg.DeliveryMode == "" {
+			msg.DeliveryMode = DeliveryModeScheduled
+		}
+		if msg.Precision == "" {
+			msg.Precision = PrecisionNormal
+		}
+	}
+	for _, msg := range backup.Archived {
+		if msg.ID == "" || msg.Recipient == "" {
+			return nil, &ValidationError{Code: apierror.CodeInvalidRequest, Message: "backup contains an archived message without id or recipient"}
+		}
+	}
+
+	result, err := ms.schedulerDB.RestoreMessages(ctx, backup.Messages, backup.Archived, backup.Attempts)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "Imported scheduler backup",
+		"imported", result.Imported, "archived", result.Archived, "skipped", result.Skipped)
+	return result, nil
+}
+
+// WriteBackup writes a JSON backup to w
+func (ms *MessageScheduler) WriteBackup(ctx context.Context, w io.Writer) error {
+	backup, err := ms.Export(ctx)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(backup)
+}
+
+// SetBackupDir enables the daily backup writer. Backups are written to dir and
+// only the newest keep files are kept. An empty dir disables backups.
+func (ms *MessageScheduler) SetBackupDir(dir string, keep int) error {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create backup directory: %w", err)
+		}
+	}
+	if keep <= 0 {
+		keep = DefaultBackupKeep
+	}
+
+	ms.maintenanceMu.Lock()
+	defer ms.maintenanceMu.Unlock()
+	ms.backupDir = dir
+	ms.backupKeep = keep
+	return nil
+}
+
+// runScheduledBackup writes a backup if the newest one is older than BackupInterval
+func (ms *MessageScheduler) runScheduledBackup(ctx context.Context) {
+	ms.maintenanceMu.Lock()
+	dir, keep := ms.backupDir, ms.backupKeep
+	ms.maintenanceMu.Unlock()
+
+	if dir == "" {
+		return
+	}
+
+	files, err := listBackupFiles(dir)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error listing backups", "dir", dir, "error", err)
+		return
+	}
+	if len(files) > 0 {
+		if info, err := os.Stat(files[len(files)-1]); err == nil && time.Since(info.ModTime()) < BackupInterval {
+			return
+		}
+	}
+
+	path, err := ms.backupToDir(ctx, dir)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error writing scheduler backup", "dir", dir, "error", err)
+		return
+	}
+	slog.InfoContext(ctx, "Wrote scheduler backup", "path", path)
+
+	files = append(files, path)
+	for len(files) > keep {
+		if err := os.Remove(files[0]); err != nil {
+			slog.WarnContext(ctx, "Failed to remove old backup", "path", files[0], "error", err)
+		}
+		files = files[1:]
+	}
+}
+
+// backupToDir writes a backup to a new timestamped file in dir. The file is
+// written under a temporary name first so a crash never leaves a partial backup.
+func (ms *MessageScheduler) backupToDir(ctx context.Context, dir string) (string, error) {
+	name := backupFilePrefix + time.Now().UTC().Format("20060102T150405Z") + ".json"
+	path := filepath.Join(dir, name)
+
+	tmp, err := os.CreateTemp(dir, ".tmp-"+backupFilePrefix)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := ms.WriteBackup(ctx, tmp); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// listBackupFiles returns the backup files in dir, oldest first
+func listBackupFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, backupFilePrefix) || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		files = append(files, filepath.Join(dir, name))
+	}
+
+	// Timestamped names sort chronologically
+	sort.Strings(files)
+	return files, nil
+}
@@ -0,0 +1,258 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/validate"
+)
+
+// MaxBatchOperations is the most operations one batch may hold
+const MaxBatchOperations = 100
+
+// Batch operations
+const (
+	BatchSchedule = "schedule"
+	BatchCancel   = "cancel"
+	BatchPause    = "pause"
+	BatchResume   = "resume"
+)
+
+// Outcomes of a batch operation
+const (
+	BatchOK         = "ok"
+	BatchFailed     = "failed"
+	BatchSkipped    = "skipped"
+	BatchRolledBack = "rolled_back"
+)
+
+// BatchRequest is the body of POST /v1/batch
+type BatchRequest struct {
+	Operations []BatchOperation `json:"operations"`
+	// Atomic, the default, applies the operations only if all of them can be
+	// applied, and undoes the applied ones if a later one fails
+	Atomic *bool `json:"atomic"`
+}
+
+// BatchOperation is one change in a batch: a message to schedule, with the
+// fields of POST /v1/schedule, or the ID of a message to cancel, pause or resume
+type BatchOperation struct {
+	Op string `json:"op"`
+	ID string `json:"id,omitempty"`
+	ScheduleMessageRequest
+
+	scheduledTime time.Time
+}
+
+// BatchResult is the outcome of one operation, in request order
+type BatchResult struct {
+	Index  int    `json:"index"`
+	Op     string `json:"op"`
+	ID     string `json:"id,omitempty"`
+	Status string `json:"status"`
+	// Message is the message as the operation left it
+	Message *ScheduledMessage `json:"scheduled_message,omitempty"`
+	Error   *apierror.Body    `json:"error,omitempty"`
+}
+
+// Validate checks the batch and returns whether it is atomic
+func (req *BatchRequest) Validate(v *validate.Validator) bool {
+	switch {
+	case len(req.Operations) == 0:
+		v.Add("operations", validate.CodeRequired, "must hold at least one operation")
+	case len(req.Operations) > MaxBatchOperations:
+		v.Add("operations", validate.CodeTooLong, fmt.Sprintf("must hold at most %d operations", MaxBatchOperations))
+	}
+	for i := range req.Operations {
+		op := &req.Operations[i]
+		var sub validate.Validator
+		if sub.Required("op", op.Op) {
+			sub.OneOf("op", op.Op, BatchSchedule, BatchCancel, BatchPause, BatchResume)
+		}
+		switch {
+		case sub.Failed("op"):
+		case op.Op == BatchSchedule:
+			op.scheduledTime = op.ScheduleMessageRequest.Validate(&sub)
+		default:
+			sub.Required("id", op.ID)
+		}
+		v.Nest("operations."+strconv.Itoa(i), &sub)
+	}
+	return req.Atomic == nil || *req.Atomic
+}
+
+// batchStep is an applied operation and how to undo it
+type batchStep struct {
+	index int
+	undo  func(ctx context.Context) error
+}
+
+// RunBatch applies validated operations in order and reports each outcome,
+// and whether they all succeeded. An atomic batch first checks every operation
+// against the messages' current status, taking the earlier operations into
+// account, and applies none if one would fail. If an operation still fails
+// while applying, because a message changed in the meantime, the operations
+// before it are undone: scheduled messages are deleted and changed statuses
+// are set back.
+func (ms *MessageScheduler) RunBatch(ctx context.Context, ops []BatchOperation, atomic bool, createdBy string) ([]BatchResult, bool) {
+	results := make([]BatchResult, len(ops))
+	for i, op := range ops {
+		results[i] = BatchResult{Index: i, Op: op.Op, ID: op.ID, Status: BatchSkipped}
+	}
+
+	if atomic {
+		if i, failure := ms.checkBatch(ctx, ops); failure != nil {
+			results[i].Status = BatchFailed
+			results[i].Error = failure
+			return results, false
+		}
+	}
+
+	var applied []batchStep
+	ok := true
+	for i, op := range ops {
+		msg, undo, failure := ms.applyBatchOperation(ctx, op, createdBy)
+		if failure != nil {
+			results[i].Status = BatchFailed
+			results[i].Error = failure
+			ok = false
+			if atomic {
+				ms.undoBatch(ctx, applied, results)
+				return results, false
+			}
+			continue
+		}
+		results[i].Status = BatchOK
+		results[i].ID = msg.ID
+		results[i].Message = msg
+		applied = append(applied, batchStep{index: i, undo: undo})
+	}
+	return results, ok
+}
+
+// checkBatch finds the first operation that couldn't be applied, without
+// changing anything
+func (ms *MessageScheduler) checkBatch(ctx context.Context, ops []BatchOperation) (int, *apierror.Body) {
+	statuses := make(map[string]string)
+	for i, op := range ops {
+		if op.Op == BatchSchedule {
+			continue
+		}
+		status, seen := statuses[op.ID]
+		if !seen {
+			msg, err := ms.schedulerDB.GetScheduledMessage(ctx, op.ID)
+			if err != nil {
+				return i, batchFailure(err)
+			}
+			status = msg.Status
+		}
+		next, err := batchTransition(op.Op, status)
+		if err != nil {
+			return i, batchFailure(err)
+		}
+		statuses[op.ID] = next
+	}
+	return 0, nil
+}
+
+// batchTransition returns the status op leaves a message in that has status
+func batchTransition(op, status string) (string, error) {
+	switch {
+	case op == BatchCancel && (status == "pending" || status == "paused"):
+		return "cancelled", nil
+	case op == BatchCancel:
+		return "", ErrNotCancellable
+	case op == BatchPause && status == "pending":
+		return "paused", nil
+	case op == BatchPause:
+		return "", ErrNotPausable
+	case op == BatchResume && status == "paused":
+		return "pending", nil
+	default:
+		return "", ErrNotResumable
+	}
+}
+
+// applyBatchOperation applies op and returns the message as it is now and a
+// function that undoes the change
+func (ms *MessageScheduler) applyBatchOperation(ctx context.Context, op BatchOperation, createdBy string) (*ScheduledMessage, func(context.Context) error, *apierror.Body) {
+	if op.Op == BatchSchedule {
+		msg, err := ms.ScheduleMessage(ctx, op.Recipient, op.Message, op.scheduledTime, op.CheckForResponse, ScheduleOptions{
+			DeliveryMode: op.DeliveryMode,
+			OnlineWindow: time.Duration(op.OnlineWindowMinutes) * time.Minute,
+			Precision:    op.Precision,
+			Metadata:     op.Metadata,
+			CreatedBy:    createdBy,
+			Media:        op.Media,
+			Typing:       op.Typing,
+			SendOptions:  op.SendOptions,
+		})
+		if err != nil {
+			return nil, nil, batchFailure(err)
+		}
+		undo := func(ctx context.Context) error {
+			return ms.schedulerDB.DeleteScheduledMessage(ctx, msg.ID)
+		}
+		return msg, undo, nil
+	}
+
+	before, err := ms.schedulerDB.GetScheduledMessage(ctx, op.ID)
+	if err != nil {
+		return nil, nil, batchFailure(err)
+	}
+	var msg *ScheduledMessage
+	switch op.Op {
+	case BatchCancel:
+		msg, err = ms.Cancel(ctx, op.ID)
+	case BatchPause:
+		msg, err = ms.Pause(ctx, op.ID)
+	case BatchResume:
+		msg, err = ms.Resume(ctx, op.ID)
+	}
+	if err != nil {
+		return nil, nil, batchFailure(err)
+	}
+	undo := func(ctx context.Context) error {
+		return ms.updateStatus(ctx, op.ID, before.Status, nil, before.ErrorMessage)
+	}
+	return msg, undo, nil
+}
+
+// undoBatch reverts applied steps, latest first, and marks them in results
+func (ms *MessageScheduler) undoBatch(ctx context.Context, applied []batchStep, results []BatchResult) {
+	ctx = context.WithoutCancel(ctx)
+	for i := len(applied) - 1; i >= 0; i-- {
+		step := applied[i]
+		if err := step.undo(ctx); err != nil {
+			slog.ErrorContext(ctx, "Failed to undo batch operation", "index", step.index, "id", results[step.index].ID, "error", err)
+			continue
+		}
+		results[step.index].Status = BatchRolledBack
+		results[step.index].Message = nil
+	}
+}
+
+// batchFailure turns a scheduler error into the error of a batch result
+func batchFailure(err error) *apierror.Body {
+	var invalid *ValidationError
+	switch {
+	case errors.Is(err, ErrMessageNotFound):
+		return &apierror.Body{Code: apierror.CodeNotFound, Message: "Message not found"}
+	case errors.Is(err, ErrNotCancellable):
+		return &apierror.Body{Code: apierror.CodeConflict, Message: "Can only cancel pending or paused messages"}
+	case errors.Is(err, ErrNotPausable):
+		return &apierror.Body{Code: apierror.CodeConflict, Message: "Can only pause pending messages"}
+	case errors.Is(err, ErrNotResumable):
+		return &apierror.Body{Code: apierror.CodeConflict, Message: "Can only resume paused messages"}
+	case errors.As(err, &invalid):
+		return &apierror.Body{Code: invalid.Code, Message: invalid.Message}
+	default:
+		slog.Error("Batch operation failed", "error", err)
+		return &apierror.Body{Code: apierror.CodeInternal, Message: "Failed to apply the operation"}
+	}
+}
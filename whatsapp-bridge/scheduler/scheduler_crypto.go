@@ -0,0 +1,106 @@
+package scheduler
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Prefixes of the values encrypt writes. Values without one are plaintext, so
+// rows written before encryption was enabled stay readable.
+const (
+	// encryptedPrefix marks values sealed with the ID of their row as
+	// additional data, so they can't be moved to another row
+	encryptedPrefix = "enc:v2:"
+	// encryptedPrefixV1 marks values sealed before that, without it
+	encryptedPrefixV1 = "enc:v1:"
+	// plaintextPrefix marks plaintext that would otherwise look encrypted,
+	// written without a key
+	plaintextPrefix = "enc:none:"
+)
+
+// ErrEncryptionKeyRequired is returned when an encrypted value is read without a key
+var ErrEncryptionKeyRequired = errors.New("scheduler data is encrypted but no encryption key is configured")
+
+// ParseEncryptionKey decodes a 32-byte AES-256 key given as base64 or hex
+func ParseEncryptionKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if key, err := hex.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, fmt.Errorf("encryption key must be 32 bytes encoded as base64 or hex")
+}
+
+// SetEncryptionKey enables AES-GCM encryption of message bodies. Reads decrypt
+// transparently; existing plaintext rows are left as they are until rewritten.
+func (sdb *SchedulerDB) SetEncryptionKey(key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("invalid encryption key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	sdb.aead = aead
+	return nil
+}
+
+// encrypt seals plaintext for the row with ID rowID when a key is configured,
+// and returns it unchanged otherwise, unless it starts like an encrypted value
+func (sdb *SchedulerDB) encrypt(plaintext, rowID string) (string, error) {
+	if sdb.aead == nil {
+		if strings.HasPrefix(plaintext, "enc:") {
+			return plaintextPrefix + plaintext, nil
+		}
+		return plaintext, nil
+	}
+
+	nonce := make([]byte, sdb.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := sdb.aead.Seal(nonce, nonce, []byte(plaintext), []byte(rowID))
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt opens a value encrypt wrote for the row with ID rowID. Plaintext
+// values are returned as is.
+func (sdb *SchedulerDB) decrypt(value, rowID string) (string, error) {
+	if plaintext, ok := strings.CutPrefix(value, plaintextPrefix); ok {
+		return plaintext, nil
+	}
+	additionalData := []byte(rowID)
+	encoded, ok := strings.CutPrefix(value, encryptedPrefix)
+	if !ok {
+		if encoded, ok = strings.CutPrefix(value, encryptedPrefixV1); !ok {
+			return value, nil
+		}
+		additionalData = nil
+	}
+	if sdb.aead == nil {
+		return "", ErrEncryptionKeyRequired
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("corrupt encrypted value: %w", err)
+	}
+	nonceSize := sdb.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return "", fmt.Errorf("corrupt encrypted value")
+	}
+	plaintext, err := sdb.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], additionalData)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value (wrong key, or moved from another row?): %w", err)
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,66 @@
+package scheduler
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+	"time"
+)
+
+// csvHeader lists the columns written by WriteMessagesCSV
+var csvHeader = []string{
+	"id", "status", "recipient", "message",
+	"scheduled_time", "created_at", "sent_at", "delivered_at", "read_at",
+	"error_message", "created_by",
+}
+
+// WriteMessagesCSV writes messages as CSV with a header row, for reporting in spreadsheets
+func WriteMessagesCSV(w io.Writer, messages []*ScheduledMessage) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+
+	for _, msg := range messages {
+		errorMessage := ""
+		if msg.ErrorMessage != nil {
+			errorMessage = *msg.ErrorMessage
+		}
+
+		record := []string{
+			msg.ID,
+			msg.Status,
+			csvText(msg.Recipient),
+			csvText(msg.Message),
+			csvTime(&msg.ScheduledTime),
+			csvTime(&msg.CreatedAt),
+			csvTime(msg.SentAt),
+			csvTime(msg.DeliveredAt),
+			csvTime(msg.ReadAt),
+			csvText(errorMessage),
+			csvText(msg.CreatedBy),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// csvTime formats a timestamp for spreadsheets, leaving missing times empty
+func csvTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// csvText keeps spreadsheet apps from treating user text as a formula
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
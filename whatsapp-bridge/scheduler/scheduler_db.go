@@ -1,323 +1,914 @@
 package scheduler
 
 import (
+	"context"
+	"crypto/cipher"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"whatsapp-client/sqlitedb"
+	"whatsapp-client/tracing"
 )
 
 // ScheduledMessage represents a message scheduled to be sent in the future
 type ScheduledMessage struct {
-	ID              string    `json:"id"`
-	Recipient       string    `json:"recipient"`
-	Message         string    `json:"message"`
-	ScheduledTime   time.Time `json:"scheduled_time"`
-	CreatedAt       time.Time `json:"created_at"`
-	LastMessageAt   time.Time `json:"last_message_at"`
-	CheckForResponse bool     `json:"check_for_response"`
-	Status          string    `json:"status"` // pending, sent, paused, cancelled, failed
-	SentAt          *time.Time `json:"sent_at,omitempty"`
-	ErrorMessage    *string   `json:"error_message,omitempty"`
-}
-
-// SchedulerDB handles database operations for scheduled messages
+	ID                string     `json:"id"`
+	Recipient         string     `json:"recipient"`
+	Message           string     `json:"message"`
+	ScheduledTime     time.Time  `json:"scheduled_time"`
+	CreatedAt         time.Time  `json:"created_at"`
+	LastMessageAt     time.Time  `json:"last_message_at"`
+	CheckForResponse  bool       `json:"check_for_response"`
+	Status            string     `json:"status"` // pending, sending, sent, paused, cancelled, failed
+	SentAt            *time.Time `json:"sent_at,omitempty"`
+	ErrorMessage      *string    `json:"error_message,omitempty"`
+	WhatsAppMessageID string     `json:"whatsapp_message_id,omitempty"`
+	ChatJID           string     `json:"chat_jid,omitempty"`
+	DeliveredAt       *time.Time `json:"delivered_at,omitempty"`
+	ReadAt            *time.Time `json:"read_at,omitempty"`
+	// RevokedAt is when the sent message was deleted for everyone
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	// DeliveryMode is "scheduled" (send at ScheduledTime) or "online" (send as soon as
+	// the recipient is seen online during the OnlineWindowMinutes before ScheduledTime)
+	DeliveryMode        string `json:"delivery_mode"`
+	OnlineWindowMinutes int    `json:"online_window_minutes,omitempty"`
+	// Precision is "normal" (checked on the regular scheduler tick) or "precise"
+	// (checked on the fast tick so it lands within seconds of ScheduledTime)
+	Precision string `json:"precision"`
+	// Metadata is arbitrary JSON supplied by the caller and returned untouched
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+	// CreatedBy identifies who scheduled the message, e.g. an API key name or MCP session
+	CreatedBy string `json:"created_by,omitempty"`
+	// Media is the file sent with the message, which is then its caption
+	Media *Media `json:"media,omitempty"`
+	// Typing shows the account typing in the chat for a while before sending
+	Typing bool `json:"typing,omitempty"`
+	// SendOptions are how the message is sent, such as the message it replies to
+	SendOptions
+}
+
+// MessageFilter narrows down GetAllScheduledMessages. Empty fields match everything.
+type MessageFilter struct {
+	Status    string
+	Recipient string
+	CreatedBy string
+	// After starts the list after this message, for paging
+	After *MessageCursor
+	// Limit caps the number of messages returned; 0 returns all
+	Limit int
+}
+
+// MessageCursor is the sort key of a message in GetAllScheduledMessages order
+type MessageCursor struct {
+	ScheduledTime time.Time `json:"t"`
+	ID            string    `json:"id"`
+}
+
+// Delivery modes for scheduled messages
+const (
+	DeliveryModeScheduled = "scheduled"
+	DeliveryModeOnline    = "online"
+)
+
+// Scheduling precision tiers
+const (
+	PrecisionNormal  = "normal"
+	PrecisionPrecise = "precise"
+)
+
+// OnlineWindowStart returns when an online-mode message starts waiting for the recipient
+func (msg *ScheduledMessage) OnlineWindowStart() time.Time {
+	return msg.ScheduledTime.Add(-time.Duration(msg.OnlineWindowMinutes) * time.Minute)
+}
+
+// Store is the persistence layer used by the scheduler
+type Store interface {
+	InsertScheduledMessage(ctx context.Context, msg *ScheduledMessage) error
+	GetPendingMessages(ctx context.Context, now time.Time, precision string) ([]*ScheduledMessage, error)
+	ClaimMessage(ctx context.Context, id string) (bool, error)
+	ReleaseClaim(ctx context.Context, id string) error
+	ClaimPendingMessages(ctx context.Context, now time.Time, precision string) ([]*ScheduledMessage, error)
+	GetAllScheduledMessages(ctx context.Context, filter MessageFilter) ([]*ScheduledMessage, error)
+	GetMessagesBetween(ctx context.Context, from time.Time, to time.Time) ([]*ScheduledMessage, error)
+	GetScheduledMessage(ctx context.Context, id string) (*ScheduledMessage, error)
+	UpdateMessageStatus(ctx context.Context, id string, status string, sentAt *time.Time, errorMsg *string) error
+	SetWhatsAppMessageID(ctx context.Context, id string, waMessageID string, chatJID string) error
+	MarkDelivered(ctx context.Context, waMessageID string, deliveredAt time.Time) error
+	MarkRead(ctx context.Context, waMessageID string, readAt time.Time) error
+	GetSentMessage(ctx context.Context, waMessageID string) (*ScheduledMessage, error)
+	MarkRevoked(ctx context.Context, waMessageID string, revokedAt time.Time) error
+	DeleteScheduledMessage(ctx context.Context, id string) error
+	GetFutureMessagesForRecipient(ctx context.Context, recipient string, now time.Time) ([]*ScheduledMessage, error)
+	GetPendingResponseChecks(ctx context.Context) ([]*ScheduledMessage, error)
+	GetPendingOnlineMessages(ctx context.Context, recipient string, now time.Time) ([]*ScheduledMessage, error)
+	AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration, now time.Time) (bool, error)
+	ReleaseLease(ctx context.Context, name string, holder string) error
+	GetFinishedMessagesBefore(ctx context.Context, cutoff time.Time, limit int) ([]*ScheduledMessage, error)
+	ArchiveMessages(ctx context.Context, messages []*ScheduledMessage, archivedAt time.Time) error
+	DeleteFinishedMessagesBefore(ctx context.Context, cutoff time.Time) (int64, error)
+	GetArchivedMessages(ctx context.Context) ([]*ScheduledMessage, error)
+	RestoreMessages(ctx context.Context, messages []*ScheduledMessage, archived []*ScheduledMessage, attempts []*SendAttempt) (*RestoreResult, error)
+	StartSendAttempt(ctx context.Context, messageID string, startedAt time.Time) (int, error)
+	FinishSendAttempt(ctx context.Context, messageID string, attempt int, finishedAt time.Time, success bool, errorMsg *string) error
+	GetSendAttempts(ctx context.Context, messageID string) ([]*SendAttempt, error)
+	GetAllSendAttempts(ctx context.Context) ([]*SendAttempt, error)
+	GetDailyStats(ctx context.Context, since time.Time, recipient string) ([]*DailyStats, error)
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// SchedulerDB handles database operations for scheduled messages. It implements
+// Store on top of SQLite or Postgres.
 type SchedulerDB struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect dialect
+	// aead encrypts message bodies at rest when set (see SetEncryptionKey)
+	aead cipher.AEAD
+
+	stmtMu sync.Mutex
+	stmts  map[string]*sql.Stmt
+
+	// shared is set when the connection belongs to the message store (single-database
+	// mode); the scheduler then can JOIN against messages and must not close it
+	shared bool
+
+	// version is bumped after every write, for caches of query results
+	version atomic.Uint64
 }
 
-// NewSchedulerDB creates a new scheduler database connection
-func NewSchedulerDB(dbPath string) (*SchedulerDB, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+// NewSchedulerDB creates a new scheduler database connection to a SQLite file
+func NewSchedulerDB(dbPath string, opts sqlitedb.Options) (*SchedulerDB, error) {
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create scheduler database directory: %w", err)
+	}
+
+	db, err := sqlitedb.Open(dbPath, opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open scheduler database: %w", err)
 	}
 
-	// Create table if not exists
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS scheduled_messages (
-			id TEXT PRIMARY KEY,
-			recipient TEXT NOT NULL,
-			message TEXT NOT NULL,
-			scheduled_time DATETIME NOT NULL,
-			created_at DATETIME NOT NULL,
-			last_message_at DATETIME,
-			check_for_response BOOLEAN DEFAULT 1,
-			status TEXT DEFAULT 'pending',
-			sent_at DATETIME,
-			error_message TEXT
-		);
-
-		CREATE INDEX IF NOT EXISTS idx_scheduled_time ON scheduled_messages(scheduled_time);
-		CREATE INDEX IF NOT EXISTS idx_status ON scheduled_messages(status);
-		CREATE INDEX IF NOT EXISTS idx_recipient ON scheduled_messages(recipient);
-	`)
+	return newSchedulerDB(db, sqliteDialect{})
+}
+
+// OpenSchedulerDB opens the scheduler database described by dsn: a
+// postgres:// or postgresql:// URL selects Postgres, anything else is treated
+// as a SQLite file path. opts only applies to SQLite, except for the pool sizes.
+func OpenSchedulerDB(dsn string, opts sqlitedb.Options) (*SchedulerDB, error) {
+	if !isPostgresDSN(dsn) {
+		return NewSchedulerDB(dsn, opts)
+	}
+
+	d := postgresDialect{}
+	db, err := sql.Open(d.driverName(), dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open scheduler database (is the bridge built with -tags postgres?): %w", err)
+	}
+	db.SetMaxOpenConns(opts.MaxOpenConns)
+	db.SetMaxIdleConns(opts.MaxIdleConns)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to scheduler database: %w", err)
+	}
+
+	return newSchedulerDB(db, d)
+}
+
+// NewSharedSchedulerDB keeps the scheduler tables in the bridge's existing SQLite
+// message store, so an install has a single database file to back up. Close
+// leaves db open; its owner closes it.
+func NewSharedSchedulerDB(db *sql.DB) (*SchedulerDB, error) {
+	if err := migrate(db, sqliteDialect{}); err != nil {
+		return nil, fmt.Errorf("failed to migrate scheduler tables: %w", err)
+	}
+
+	return &SchedulerDB{db: db, dialect: sqliteDialect{}, stmts: make(map[string]*sql.Stmt), shared: true}, nil
+}
+
+// newSchedulerDB migrates an open connection and wraps it in a SchedulerDB
+func newSchedulerDB(db *sql.DB, d dialect) (*SchedulerDB, error) {
+	if err := migrate(db, d); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate scheduler database: %w", err)
+	}
+
+	return &SchedulerDB{db: db, dialect: d, stmts: make(map[string]*sql.Stmt)}, nil
+}
+
+// exec runs a statement written with ? placeholders
+func (sdb *SchedulerDB) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := sdb.startSpan(ctx, query)
+	defer span.End()
+	result, err := sdb.db.ExecContext(ctx, sdb.dialect.rebind(query), args...)
+	sdb.version.Add(1)
+	span.RecordError(err)
+	return result, err
+}
+
+// query runs a query written with ? placeholders
+func (sdb *SchedulerDB) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := sdb.startSpan(ctx, query)
+	defer span.End()
+	rows, err := sdb.db.QueryContext(ctx, sdb.dialect.rebind(query), args...)
+	span.RecordError(err)
+	return rows, err
+}
+
+// queryRow runs a single-row query written with ? placeholders
+func (sdb *SchedulerDB) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, span := sdb.startSpan(ctx, query)
+	defer span.End()
+	return sdb.db.QueryRowContext(ctx, sdb.dialect.rebind(query), args...)
+}
+
+// txExec runs a statement written with ? placeholders inside tx
+func (sdb *SchedulerDB) txExec(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := sdb.startSpan(ctx, query)
+	defer span.End()
+	result, err := tx.ExecContext(ctx, sdb.dialect.rebind(query), args...)
+	span.RecordError(err)
+	return result, err
+}
+
+// commit commits a transaction of txExec statements
+func (sdb *SchedulerDB) commit(tx *sql.Tx) error {
+	err := tx.Commit()
+	sdb.version.Add(1)
+	return err
+}
+
+// Version changes after every write, so cached query results can be checked
+// for staleness. Writes by other bridges sharing the database don't change it.
+func (sdb *SchedulerDB) Version() uint64 {
+	return sdb.version.Load()
+}
+
+// execPrepared runs a statement through its prepared form (see prepared)
+func (sdb *SchedulerDB) execPrepared(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := sdb.startSpan(ctx, query)
+	defer span.End()
+	stmt, err := sdb.prepared(query)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	result, err := stmt.ExecContext(ctx, args...)
+	sdb.version.Add(1)
+	span.RecordError(err)
+	return result, err
+}
+
+// queryPrepared runs a query through its prepared form (see prepared)
+func (sdb *SchedulerDB) queryPrepared(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := sdb.startSpan(ctx, query)
+	defer span.End()
+	stmt, err := sdb.prepared(query)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	rows, err := stmt.QueryContext(ctx, args...)
+	span.RecordError(err)
+	return rows, err
+}
+
+// startSpan begins the span for one statement. It is named after the SQL
+// operation so slow queries stand out in a trace without reading the statement.
+func (sdb *SchedulerDB) startSpan(ctx context.Context, query string) (context.Context, tracing.Span) {
+	query = strings.TrimSpace(query)
+	operation := query
+	if i := strings.IndexAny(query, " \n\t"); i > 0 {
+		operation = query[:i]
+	}
+	return tracing.Start(ctx, "db "+strings.ToUpper(operation),
+		tracing.String("db.system", sdb.dialect.name()),
+		tracing.String("db.statement", query),
+	)
+}
+
+// prepared returns a prepared statement for a query written with ? placeholders,
+// preparing it on first use. Use it for statements that run on every tick.
+func (sdb *SchedulerDB) prepared(query string) (*sql.Stmt, error) {
+	sdb.stmtMu.Lock()
+	defer sdb.stmtMu.Unlock()
+
+	if stmt, ok := sdb.stmts[query]; ok {
+		return stmt, nil
+	}
+	if sdb.stmts == nil {
+		return nil, fmt.Errorf("scheduler database is closed")
+	}
+
+	stmt, err := sdb.db.Prepare(sdb.dialect.rebind(query))
+	if err != nil {
+		return nil, err
+	}
+	sdb.stmts[query] = stmt
+	return stmt, nil
+}
+
+// scheduledMessageColumns lists the scheduled_messages columns in the order
+// scanScheduledMessage expects them. New columns go here and in scanScheduledMessage.
+const scheduledMessageColumns = `id, recipient, message, scheduled_time, created_at, last_message_at,
+		       check_for_response, status, sent_at, error_message,
+		       whatsapp_message_id, delivered_at, read_at,
+		       delivery_mode, online_window_minutes, precision,
+		       chat_jid, metadata, created_by, media, quoted_message_id, revoked_at, mentions, link_preview, view_once, typing,
+		       background_color, font, audience`
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanScheduledMessage reads one row selected with scheduledMessageColumns
+func (sdb *SchedulerDB) scanScheduledMessage(row rowScanner) (*ScheduledMessage, error) {
+	msg := &ScheduledMessage{}
+	var sentAt sql.NullTime
+	var errorMsg sql.NullString
+	var lastMessageAt sql.NullTime
+	var waMessageID sql.NullString
+	var deliveredAt sql.NullTime
+	var readAt sql.NullTime
+	var deliveryMode sql.NullString
+	var precision sql.NullString
+	var chatJID sql.NullString
+	var metadata sql.NullString
+	var createdBy sql.NullString
+	var media sql.NullString
+	var quotedMessageID sql.NullString
+	var revokedAt sql.NullTime
+	var mentions sql.NullString
+	var backgroundColor, font, audience sql.NullString
+
+	err := row.Scan(
+		&msg.ID,
+		&msg.Recipient,
+		&msg.Message,
+		&msg.ScheduledTime,
+		&msg.CreatedAt,
+		&lastMessageAt,
+		&msg.CheckForResponse,
+		&msg.Status,
+		&sentAt,
+		&errorMsg,
+		&waMessageID,
+		&deliveredAt,
+		&readAt,
+		&deliveryMode,
+		&msg.OnlineWindowMinutes,
+		&precision,
+		&chatJID,
+		&metadata,
+		&createdBy,
+		&media,
+		&quotedMessageID,
+		&revokedAt,
+		&mentions,
+		&msg.LinkPreview,
+		&msg.ViewOnce,
+		&msg.Typing,
+		&backgroundColor,
+		&font,
+		&audience,
+	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create scheduler table: %w", err)
+		return nil, err
+	}
+
+	if sentAt.Valid {
+		msg.SentAt = &sentAt.Time
+	}
+	if errorMsg.Valid {
+		msg.ErrorMessage = &errorMsg.String
+	}
+	if lastMessageAt.Valid {
+		msg.LastMessageAt = lastMessageAt.Time
+	}
+	if waMessageID.Valid {
+		msg.WhatsAppMessageID = waMessageID.String
+	}
+	if deliveredAt.Valid {
+		msg.DeliveredAt = &deliveredAt.Time
+	}
+	if readAt.Valid {
+		msg.ReadAt = &readAt.Time
+	}
+	msg.DeliveryMode = DeliveryModeScheduled
+	if deliveryMode.Valid && deliveryMode.String != "" {
+		msg.DeliveryMode = deliveryMode.String
+	}
+	msg.Precision = PrecisionNormal
+	if precision.Valid && precision.String != "" {
+		msg.Precision = precision.String
+	}
+	if chatJID.Valid {
+		msg.ChatJID = chatJID.String
+	}
+	if metadata.Valid && metadata.String != "" {
+		msg.Metadata = json.RawMessage(metadata.String)
+	}
+	if createdBy.Valid {
+		msg.CreatedBy = createdBy.String
+	}
+	if media.Valid && media.String != "" {
+		msg.Media = &Media{}
+		if err := json.Unmarshal([]byte(media.String), msg.Media); err != nil {
+			return nil, fmt.Errorf("invalid media of message %s: %w", msg.ID, err)
+		}
+	}
+	if quotedMessageID.Valid {
+		msg.QuotedMessageID = quotedMessageID.String
+	}
+	if revokedAt.Valid {
+		msg.RevokedAt = &revokedAt.Time
+	}
+	if mentions.Valid && mentions.String != "" {
+		if err := json.Unmarshal([]byte(mentions.String), &msg.Mentions); err != nil {
+			return nil, fmt.Errorf("invalid mentions of message %s: %w", msg.ID, err)
+		}
 	}
+	msg.BackgroundColor = backgroundColor.String
+	msg.Font = font.String
+	if audience.Valid && audience.String != "" {
+		if err := json.Unmarshal([]byte(audience.String), &msg.Audience); err != nil {
+			return nil, fmt.Errorf("invalid audience of message %s: %w", msg.ID, err)
+		}
+	}
+
+	if msg.Message, err = sdb.decrypt(msg.Message, msg.ID); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// scanScheduledMessages reads every row selected with scheduledMessageColumns and closes rows
+func (sdb *SchedulerDB) scanScheduledMessages(rows *sql.Rows) ([]*ScheduledMessage, error) {
+	defer rows.Close()
 
-	return &SchedulerDB{db: db}, nil
+	var messages []*ScheduledMessage
+	for rows.Next() {
+		msg, err := sdb.scanScheduledMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+
+	return messages, rows.Err()
 }
 
 // InsertScheduledMessage adds a new scheduled message to the database
-func (sdb *SchedulerDB) InsertScheduledMessage(msg *ScheduledMessage) error {
-	_, err := sdb.db.Exec(`
+func (sdb *SchedulerDB) InsertScheduledMessage(ctx context.Context, msg *ScheduledMessage) error {
+	body, err := sdb.encrypt(msg.Message, msg.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = sdb.exec(ctx, `
 		INSERT INTO scheduled_messages 
-		(id, recipient, message, scheduled_time, created_at, last_message_at, check_for_response, status)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		(id, recipient, message, scheduled_time, created_at, last_message_at, check_for_response, status,
+		 delivery_mode, online_window_minutes, precision, metadata, created_by, media, quoted_message_id, mentions,
+		 link_preview, view_once, typing, background_color, font, audience)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
 		msg.ID,
 		msg.Recipient,
-		msg.Message,
+		body,
 		msg.ScheduledTime,
 		msg.CreatedAt,
 		msg.LastMessageAt,
 		msg.CheckForResponse,
 		msg.Status,
+		msg.DeliveryMode,
+		msg.OnlineWindowMinutes,
+		msg.Precision,
+		metadataValue(msg.Metadata),
+		nullIfEmpty(msg.CreatedBy),
+		mediaValue(msg.Media),
+		nullIfEmpty(msg.QuotedMessageID),
+		jidsValue(msg.Mentions),
+		msg.LinkPreview,
+		msg.ViewOnce,
+		msg.Typing,
+		nullIfEmpty(msg.BackgroundColor),
+		nullIfEmpty(msg.Font),
+		jidsValue(msg.Audience),
 	)
 	return err
 }
 
-// GetPendingMessages retrieves messages that should be sent now
-func (sdb *SchedulerDB) GetPendingMessages(now time.Time) ([]*ScheduledMessage, error) {
-	rows, err := sdb.db.Query(`
-		SELECT id, recipient, message, scheduled_time, created_at, last_message_at, 
-		       check_for_response, status, sent_at, error_message
+// GetPendingMessages retrieves messages that should be sent now, optionally
+// restricted to a single precision tier
+func (sdb *SchedulerDB) GetPendingMessages(ctx context.Context, now time.Time, precision string) ([]*ScheduledMessage, error) {
+	query, args := pendingMessagesQuery(now, precision)
+
+	// Runs on every scheduler tick, so keep it prepared
+	rows, err := sdb.queryPrepared(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return sdb.scanScheduledMessages(rows)
+}
+
+// pendingMessagesQuery builds the query of GetPendingMessages
+func pendingMessagesQuery(now time.Time, precision string) (string, []interface{}) {
+	query := `
+		SELECT ` + scheduledMessageColumns + `
 		FROM scheduled_messages
-		WHERE status = 'pending' 
+		WHERE status = 'pending'
 		  AND scheduled_time <= ?
+	`
+	args := []interface{}{now}
+
+	if precision != "" {
+		query += " AND precision = ?"
+		args = append(args, precision)
+	}
+
+	query += " ORDER BY scheduled_time ASC"
+	return query, args
+}
+
+// GetMessagesBetween retrieves the pending messages scheduled to go out from
+// from (inclusive) to to (exclusive), soonest first
+func (sdb *SchedulerDB) GetMessagesBetween(ctx context.Context, from time.Time, to time.Time) ([]*ScheduledMessage, error) {
+	rows, err := sdb.query(ctx, `
+		SELECT `+scheduledMessageColumns+`
+		FROM scheduled_messages
+		WHERE status = 'pending'
+		  AND scheduled_time >= ?
+		  AND scheduled_time < ?
 		ORDER BY scheduled_time ASC
-	`, now)
+	`, from, to)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return sdb.scanScheduledMessages(rows)
+}
 
-	var messages []*ScheduledMessage
-	for rows.Next() {
-		msg := &ScheduledMessage{}
-		var sentAt sql.NullTime
-		var errorMsg sql.NullString
-		var lastMessageAt sql.NullTime
-
-		err := rows.Scan(
-			&msg.ID,
-			&msg.Recipient,
-			&msg.Message,
-			&msg.ScheduledTime,
-			&msg.CreatedAt,
-			&lastMessageAt,
-			&msg.CheckForResponse,
-			&msg.Status,
-			&sentAt,
-			&errorMsg,
-		)
-		if err != nil {
-			return nil, err
-		}
+// ClaimMessage atomically moves a pending message to the sending state. It
+// reports false if the message was no longer pending, e.g. because another
+// tick or instance already claimed it.
+func (sdb *SchedulerDB) ClaimMessage(ctx context.Context, id string) (bool, error) {
+	result, err := sdb.execPrepared(ctx, claimMessageQuery, id)
+	if err != nil {
+		return false, err
+	}
 
-		if sentAt.Valid {
-			msg.SentAt = &sentAt.Time
-		}
-		if errorMsg.Valid {
-			msg.ErrorMessage = &errorMsg.String
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// claimMessageQuery is the statement of ClaimMessage
+const claimMessageQuery = `
+		UPDATE scheduled_messages
+		SET status = 'sending'
+		WHERE id = ?
+		  AND status = 'pending'
+	`
+
+// ReleaseClaim returns a claimed message that wasn't sent to the pending state
+func (sdb *SchedulerDB) ReleaseClaim(ctx context.Context, id string) error {
+	_, err := sdb.exec(ctx, `
+		UPDATE scheduled_messages
+		SET status = 'pending'
+		WHERE id = ?
+		  AND status = 'sending'
+	`, id)
+	return err
+}
+
+// ClaimPendingMessages retrieves the messages that should be sent now and
+// claims them for sending. Only messages this call managed to claim are
+// returned, so overlapping ticks never send the same message twice.
+func (sdb *SchedulerDB) ClaimPendingMessages(ctx context.Context, now time.Time, precision string) ([]*ScheduledMessage, error) {
+	candidates, err := sdb.GetPendingMessages(ctx, now, precision)
+	if err != nil {
+		return nil, err
+	}
+
+	var claimed []*ScheduledMessage
+	for _, msg := range candidates {
+		ok, err := sdb.ClaimMessage(ctx, msg.ID)
+		if err != nil {
+			return claimed, fmt.Errorf("failed to claim message %s: %w", msg.ID, err)
 		}
-		if lastMessageAt.Valid {
-			msg.LastMessageAt = lastMessageAt.Time
+		if !ok {
+			continue
 		}
-
-		messages = append(messages, msg)
+		msg.Status = "sending"
+		claimed = append(claimed, msg)
 	}
 
-	return messages, nil
+	return claimed, nil
 }
 
 // GetAllScheduledMessages retrieves all scheduled messages with optional filters
-func (sdb *SchedulerDB) GetAllScheduledMessages(status string, recipient string) ([]*ScheduledMessage, error) {
+func (sdb *SchedulerDB) GetAllScheduledMessages(ctx context.Context, filter MessageFilter) ([]*ScheduledMessage, error) {
+	query, args := scheduledMessagesQuery(filter)
+	rows, err := sdb.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return sdb.scanScheduledMessages(rows)
+}
+
+// scheduledMessagesQuery builds the query of GetAllScheduledMessages
+func scheduledMessagesQuery(filter MessageFilter) (string, []interface{}) {
 	query := `
-		SELECT id, recipient, message, scheduled_time, created_at, last_message_at,
-		       check_for_response, status, sent_at, error_message
+		SELECT ` + scheduledMessageColumns + `
 		FROM scheduled_messages
 		WHERE 1=1
 	`
 	args := []interface{}{}
 
-	if status != "" {
+	if filter.Status != "" {
 		query += " AND status = ?"
-		args = append(args, status)
+		args = append(args, filter.Status)
 	}
 
-	if recipient != "" {
+	if filter.Recipient != "" {
 		query += " AND recipient = ?"
-		args = append(args, recipient)
+		args = append(args, filter.Recipient)
 	}
 
-	query += " ORDER BY scheduled_time DESC"
-
-	rows, err := sdb.db.Query(query, args...)
-	if err != nil {
-		return nil, err
+	if filter.CreatedBy != "" {
+		query += " AND created_by = ?"
+		args = append(args, filter.CreatedBy)
 	}
-	defer rows.Close()
 
-	var messages []*ScheduledMessage
-	for rows.Next() {
-		msg := &ScheduledMessage{}
-		var sentAt sql.NullTime
-		var errorMsg sql.NullString
-		var lastMessageAt sql.NullTime
-
-		err := rows.Scan(
-			&msg.ID,
-			&msg.Recipient,
-			&msg.Message,
-			&msg.ScheduledTime,
-			&msg.CreatedAt,
-			&lastMessageAt,
-			&msg.CheckForResponse,
-			&msg.Status,
-			&sentAt,
-			&errorMsg,
-		)
-		if err != nil {
-			return nil, err
-		}
+	if filter.After != nil {
+		query += " AND (scheduled_time < ? OR (scheduled_time = ? AND id < ?))"
+		args = append(args, filter.After.ScheduledTime, filter.After.ScheduledTime, filter.After.ID)
+	}
 
-		if sentAt.Valid {
-			msg.SentAt = &sentAt.Time
-		}
-		if errorMsg.Valid {
-			msg.ErrorMessage = &errorMsg.String
-		}
-		if lastMessageAt.Valid {
-			msg.LastMessageAt = lastMessageAt.Time
-		}
+	// The ID breaks ties so pages don't skip or repeat messages due at the same time
+	query += " ORDER BY scheduled_time DESC, id DESC"
 
-		messages = append(messages, msg)
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
 	}
-
-	return messages, nil
+	return query, args
 }
 
-// GetScheduledMessage retrieves a specific scheduled message by ID
-func (sdb *SchedulerDB) GetScheduledMessage(id string) (*ScheduledMessage, error) {
-	msg := &ScheduledMessage{}
-	var sentAt sql.NullTime
-	var errorMsg sql.NullString
-	var lastMessageAt sql.NullTime
+// ErrMessageNotFound is returned by GetScheduledMessage for an unknown ID
+var ErrMessageNotFound = errors.New("scheduled message not found")
 
-	err := sdb.db.QueryRow(`
-		SELECT id, recipient, message, scheduled_time, created_at, last_message_at,
-		       check_for_response, status, sent_at, error_message
+// GetScheduledMessage retrieves a specific scheduled message by ID
+func (sdb *SchedulerDB) GetScheduledMessage(ctx context.Context, id string) (*ScheduledMessage, error) {
+	msg, err := sdb.scanScheduledMessage(sdb.queryRow(ctx, `
+		SELECT `+scheduledMessageColumns+`
 		FROM scheduled_messages
 		WHERE id = ?
-	`, id).Scan(
-		&msg.ID,
-		&msg.Recipient,
-		&msg.Message,
-		&msg.ScheduledTime,
-		&msg.CreatedAt,
-		&lastMessageAt,
-		&msg.CheckForResponse,
-		&msg.Status,
-		&sentAt,
-		&errorMsg,
-	)
-
+	`, id))
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("scheduled message not found")
+		return nil, ErrMessageNotFound
 	}
+	return msg, err
+}
+
+// UpdateMessageStatus updates the status of a scheduled message and counts
+// sent, failed and paused messages in the daily stats
+func (sdb *SchedulerDB) UpdateMessageStatus(ctx context.Context, id string, status string, sentAt *time.Time, errorMsg *string) error {
+	tx, err := sdb.db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer tx.Rollback()
 
-	if sentAt.Valid {
-		msg.SentAt = &sentAt.Time
-	}
-	if errorMsg.Valid {
-		msg.ErrorMessage = &errorMsg.String
+	result, err := sdb.txExec(ctx, tx, `
+		UPDATE scheduled_messages
+		SET status = ?, sent_at = ?, error_message = ?
+		WHERE id = ?
+		  AND status <> ?
+	`, status, sentAt, errorMsg, id, status)
+	if err != nil {
+		return err
 	}
-	if lastMessageAt.Valid {
-		msg.LastMessageAt = lastMessageAt.Time
+
+	// Only count real transitions, so repeating an update doesn't inflate the stats
+	if changed, err := result.RowsAffected(); err != nil {
+		return err
+	} else if changed > 0 {
+		if err := sdb.incrementDailyStats(ctx, tx, id, status, time.Now()); err != nil {
+			return err
+		}
 	}
 
-	return msg, nil
+	return sdb.commit(tx)
 }
 
-// UpdateMessageStatus updates the status of a scheduled message
-func (sdb *SchedulerDB) UpdateMessageStatus(id string, status string, sentAt *time.Time, errorMsg *string) error {
-	_, err := sdb.db.Exec(`
+// SetWhatsAppMessageID records the WhatsApp message ID and chat JID returned when a scheduled message was sent
+func (sdb *SchedulerDB) SetWhatsAppMessageID(ctx context.Context, id string, waMessageID string, chatJID string) error {
+	_, err := sdb.exec(ctx, `
 		UPDATE scheduled_messages
-		SET status = ?, sent_at = ?, error_message = ?
+		SET whatsapp_message_id = ?, chat_jid = ?
 		WHERE id = ?
-	`, status, sentAt, errorMsg, id)
+	`, waMessageID, nullIfEmpty(chatJID), id)
+	return err
+}
+
+// MarkDelivered sets delivered_at for the sent message with the given WhatsApp message ID
+func (sdb *SchedulerDB) MarkDelivered(ctx context.Context, waMessageID string, deliveredAt time.Time) error {
+	_, err := sdb.exec(ctx, `
+		UPDATE scheduled_messages
+		SET delivered_at = ?
+		WHERE whatsapp_message_id = ?
+		  AND delivered_at IS NULL
+	`, deliveredAt, waMessageID)
+	return err
+}
+
+// MarkRead sets read_at (and delivered_at, if a delivery receipt was missed)
+// for the sent message with the given WhatsApp message ID
+func (sdb *SchedulerDB) MarkRead(ctx context.Context, waMessageID string, readAt time.Time) error {
+	_, err := sdb.exec(ctx, `
+		UPDATE scheduled_messages
+		SET read_at = COALESCE(read_at, ?),
+		    delivered_at = COALESCE(delivered_at, ?)
+		WHERE whatsapp_message_id = ?
+	`, readAt, readAt, waMessageID)
 	return err
 }
 
+// GetSentMessage retrieves the sent message with the given WhatsApp message ID,
+// or fails with ErrMessageNotFound
+func (sdb *SchedulerDB) GetSentMessage(ctx context.Context, waMessageID string) (*ScheduledMessage, error) {
+	msg, err := sdb.scanScheduledMessage(sdb.queryRow(ctx, `
+		SELECT `+scheduledMessageColumns+`
+		FROM scheduled_messages
+		WHERE whatsapp_message_id = ?
+	`, waMessageID))
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
+	return msg, err
+}
+
+// MarkRevoked sets revoked_at for the sent message with the given WhatsApp message ID
+func (sdb *SchedulerDB) MarkRevoked(ctx context.Context, waMessageID string, revokedAt time.Time) error {
+	_, err := sdb.exec(ctx, `
+		UPDATE scheduled_messages
+		SET revoked_at = ?
+		WHERE whatsapp_message_id = ?
+		  AND revoked_at IS NULL
+	`, revokedAt, waMessageID)
+	return err
+}
+
+// GetPendingResponseChecks retrieves the pending messages that should be
+// paused if their recipient writes first
+func (sdb *SchedulerDB) GetPendingResponseChecks(ctx context.Context) ([]*ScheduledMessage, error) {
+	rows, err := sdb.queryPrepared(ctx, `
+		SELECT `+scheduledMessageColumns+`
+		FROM scheduled_messages
+		WHERE status = 'pending'
+		  AND check_for_response
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return sdb.scanScheduledMessages(rows)
+}
+
+// hasMessageHistory reports whether the messages table is in this database
+func (sdb *SchedulerDB) hasMessageHistory() bool {
+	return sdb.shared
+}
+
+// GetRespondedMessages finds pending messages with check_for_response whose
+// recipient has written since the message was scheduled, in a single JOIN. It
+// only works in single-database mode (see NewSharedSchedulerDB).
+func (sdb *SchedulerDB) GetRespondedMessages(ctx context.Context) ([]*ScheduledMessage, error) {
+	if !sdb.shared {
+		return nil, fmt.Errorf("message history is not in the scheduler database")
+	}
+
+	rows, err := sdb.queryPrepared(ctx, `
+		SELECT `+scheduledMessageColumns+`
+		FROM scheduled_messages s
+		WHERE s.status = 'pending'
+		  AND s.check_for_response
+		  AND EXISTS (
+			SELECT 1 FROM messages m
+			WHERE m.chat_jid = s.recipient
+			  AND m.is_from_me = 0
+			  AND julianday(m.timestamp) > julianday(s.created_at)
+		  )
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return sdb.scanScheduledMessages(rows)
+}
+
 // DeleteScheduledMessage deletes a scheduled message
-func (sdb *SchedulerDB) DeleteScheduledMessage(id string) error {
-	_, err := sdb.db.Exec("DELETE FROM scheduled_messages WHERE id = ?", id)
+func (sdb *SchedulerDB) DeleteScheduledMessage(ctx context.Context, id string) error {
+	if _, err := sdb.exec(ctx, "DELETE FROM send_attempts WHERE message_id = ?", id); err != nil {
+		return err
+	}
+	_, err := sdb.exec(ctx, "DELETE FROM scheduled_messages WHERE id = ?", id)
 	return err
 }
 
 // GetFutureMessagesForRecipient gets all future pending messages for a recipient
-func (sdb *SchedulerDB) GetFutureMessagesForRecipient(recipient string, now time.Time) ([]*ScheduledMessage, error) {
-	rows, err := sdb.db.Query(`
-		SELECT id, recipient, message, scheduled_time, created_at, last_message_at,
-		       check_for_response, status, sent_at, error_message
+func (sdb *SchedulerDB) GetFutureMessagesForRecipient(ctx context.Context, recipient string, now time.Time) ([]*ScheduledMessage, error) {
+	rows, err := sdb.queryPrepared(ctx, `
+		SELECT `+scheduledMessageColumns+`
 		FROM scheduled_messages
 		WHERE recipient = ?
 		  AND status = 'pending'
 		  AND scheduled_time > ?
-		  AND check_for_response = 1
+		  AND check_for_response
 		ORDER BY scheduled_time ASC
 	`, recipient, now)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return sdb.scanScheduledMessages(rows)
+}
 
-	var messages []*ScheduledMessage
-	for rows.Next() {
-		msg := &ScheduledMessage{}
-		var sentAt sql.NullTime
-		var errorMsg sql.NullString
-		var lastMessageAt sql.NullTime
-
-		err := rows.Scan(
-			&msg.ID,
-			&msg.Recipient,
-			&msg.Message,
-			&msg.ScheduledTime,
-			&msg.CreatedAt,
-			&lastMessageAt,
-			&msg.CheckForResponse,
-			&msg.Status,
-			&sentAt,
-			&errorMsg,
-		)
-		if err != nil {
-			return nil, err
-		}
+// GetPendingOnlineMessages retrieves pending online-mode messages that are not due yet,
+// optionally restricted to a single recipient
+func (sdb *SchedulerDB) GetPendingOnlineMessages(ctx context.Context, recipient string, now time.Time) ([]*ScheduledMessage, error) {
+	query := `
+		SELECT ` + scheduledMessageColumns + `
+		FROM scheduled_messages
+		WHERE status = 'pending'
+		  AND delivery_mode = 'online'
+		  AND scheduled_time > ?
+	`
+	args := []interface{}{now}
 
-		if sentAt.Valid {
-			msg.SentAt = &sentAt.Time
-		}
-		if errorMsg.Valid {
-			msg.ErrorMessage = &errorMsg.String
-		}
-		if lastMessageAt.Valid {
-			msg.LastMessageAt = lastMessageAt.Time
-		}
+	if recipient != "" {
+		query += " AND recipient = ?"
+		args = append(args, recipient)
+	}
 
-		messages = append(messages, msg)
+	query += " ORDER BY scheduled_time ASC"
+
+	rows, err := sdb.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
 	}
+	return sdb.scanScheduledMessages(rows)
+}
 
-	return messages, nil
+// GetFinishedMessagesBefore retrieves up to limit sent, cancelled or failed
+// messages scheduled before cutoff, oldest first
+func (sdb *SchedulerDB) GetFinishedMessagesBefore(ctx context.Context, cutoff time.Time, limit int) ([]*ScheduledMessage, error) {
+	rows, err := sdb.query(ctx, `
+		SELECT `+scheduledMessageColumns+`
+		FROM scheduled_messages
+		WHERE status IN ('sent', 'cancelled', 'failed')
+		  AND scheduled_time < ?
+		ORDER BY scheduled_time ASC
+		LIMIT ?
+	`, cutoff, limit)
+	if err != nil {
+		return nil, err
+	}
+	return sdb.scanScheduledMessages(rows)
 }
 
-// Close closes the database connection
+// metadataValue stores empty metadata as NULL
+func metadataValue(metadata json.RawMessage) interface{} {
+	if len(metadata) == 0 {
+		return nil
+	}
+	return string(metadata)
+}
+
+// Ping checks that the scheduler database is reachable
+func (sdb *SchedulerDB) Ping(ctx context.Context) error {
+	return sdb.db.PingContext(ctx)
+}
+
+// Close closes the prepared statements and the database connection
 func (sdb *SchedulerDB) Close() error {
+	sdb.stmtMu.Lock()
+	for _, stmt := range sdb.stmts {
+		stmt.Close()
+	}
+	sdb.stmts = nil
+	sdb.stmtMu.Unlock()
+
+	if sdb.shared {
+		return nil
+	}
 	return sdb.db.Close()
 }
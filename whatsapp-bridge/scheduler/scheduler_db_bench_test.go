@@ -0,0 +1,214 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"whatsapp-client/sqlitedb"
+)
+
+// Size of the benchmark database: benchMessages scheduled messages, of which
+// benchPending are pending and benchDue of those are due
+const (
+	benchMessages = 100_000
+	benchPending  = 2_000
+	benchDue      = 1_000
+)
+
+// benchPageLimit is one default page of GET /v1/scheduled plus the row that
+// tells whether there is a next one
+const benchPageLimit = 101
+
+// openBenchDB creates a SQLite scheduler database in a temporary directory,
+// the way the bridge opens it, and fills it with benchMessages messages
+func openBenchDB(b *testing.B, now time.Time) *SchedulerDB {
+	b.Helper()
+	// The migration log would bury the results
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	sdb, err := NewSchedulerDB(filepath.Join(b.TempDir(), "scheduler.db"), sqlitedb.DefaultOptions())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { sdb.Close() })
+
+	tx, err := sdb.db.Begin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	stmt, err := tx.Prepare(`
+		INSERT INTO scheduled_messages (id, recipient, message, scheduled_time, created_at, last_message_at, check_for_response, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < benchMessages; i++ {
+		// The due messages are the oldest pending ones, the rest of the pending
+		// ones are in the future and everything else went out in the past
+		scheduled := now.Add(-time.Duration(i+1) * time.Minute)
+		status := "sent"
+		switch {
+		case i < benchDue:
+			status = "pending"
+		case i < benchPending:
+			status = "pending"
+			scheduled = now.Add(time.Duration(i) * time.Minute)
+		case i%10 == 0:
+			status = "cancelled"
+		}
+		_, err := stmt.Exec(
+			fmt.Sprintf("bench-%06d", i),
+			fmt.Sprintf("%d", 15550000000+i%500),
+			"Benchmark message",
+			scheduled,
+			scheduled.Add(-time.Hour),
+			scheduled.Add(-2*time.Hour),
+			i%2 == 0,
+			status,
+		)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	stmt.Close()
+	if err := tx.Commit(); err != nil {
+		b.Fatal(err)
+	}
+	return sdb
+}
+
+// getPendingMessagesUnprepared is GetPendingMessages without the prepared statement
+func getPendingMessagesUnprepared(ctx context.Context, sdb *SchedulerDB, now time.Time, precision string) ([]*ScheduledMessage, error) {
+	query, args := pendingMessagesQuery(now, precision)
+	rows, err := sdb.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return sdb.scanScheduledMessages(rows)
+}
+
+// claimPendingMessagesUnprepared is ClaimPendingMessages without the prepared statements
+func claimPendingMessagesUnprepared(ctx context.Context, sdb *SchedulerDB, now time.Time, precision string) ([]*ScheduledMessage, error) {
+	candidates, err := getPendingMessagesUnprepared(ctx, sdb, now, precision)
+	if err != nil {
+		return nil, err
+	}
+
+	var claimed []*ScheduledMessage
+	for _, msg := range candidates {
+		result, err := sdb.exec(ctx, claimMessageQuery, msg.ID)
+		if err != nil {
+			return claimed, err
+		}
+		if affected, _ := result.RowsAffected(); affected == 1 {
+			claimed = append(claimed, msg)
+		}
+	}
+	return claimed, nil
+}
+
+// getAllScheduledMessagesPrepared is GetAllScheduledMessages through a prepared statement
+func getAllScheduledMessagesPrepared(ctx context.Context, sdb *SchedulerDB, filter MessageFilter) ([]*ScheduledMessage, error) {
+	query, args := scheduledMessagesQuery(filter)
+	rows, err := sdb.queryPrepared(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return sdb.scanScheduledMessages(rows)
+}
+
+// expectMessages fails the benchmark unless a query returned want messages
+func expectMessages(b *testing.B, messages []*ScheduledMessage, err error, want int) {
+	b.Helper()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if len(messages) != want {
+		b.Fatalf("got %d messages, want %d", len(messages), want)
+	}
+}
+
+// BenchmarkGetPendingMessages is the due-message lookup of every scheduler tick
+func BenchmarkGetPendingMessages(b *testing.B) {
+	ctx := context.Background()
+	now := time.Now()
+	sdb := openBenchDB(b, now)
+
+	b.Run("prepared", func(b *testing.B) {
+		for b.Loop() {
+			messages, err := sdb.GetPendingMessages(ctx, now, "")
+			expectMessages(b, messages, err, benchDue)
+		}
+	})
+	b.Run("unprepared", func(b *testing.B) {
+		for b.Loop() {
+			messages, err := getPendingMessagesUnprepared(ctx, sdb, now, "")
+			expectMessages(b, messages, err, benchDue)
+		}
+	})
+}
+
+// BenchmarkClaimPendingMessages claims every due message, then puts them back
+// to pending outside the timed part for the next iteration
+func BenchmarkClaimPendingMessages(b *testing.B) {
+	ctx := context.Background()
+	now := time.Now()
+	sdb := openBenchDB(b, now)
+
+	release := func(b *testing.B) {
+		b.StopTimer()
+		if _, err := sdb.exec(ctx, "UPDATE scheduled_messages SET status = 'pending' WHERE status = 'sending'"); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+	}
+
+	b.Run("prepared", func(b *testing.B) {
+		for b.Loop() {
+			messages, err := sdb.ClaimPendingMessages(ctx, now, "")
+			expectMessages(b, messages, err, benchDue)
+			release(b)
+		}
+	})
+	b.Run("unprepared", func(b *testing.B) {
+		for b.Loop() {
+			messages, err := claimPendingMessagesUnprepared(ctx, sdb, now, "")
+			expectMessages(b, messages, err, benchDue)
+			release(b)
+		}
+	})
+}
+
+// BenchmarkGetAllScheduledMessages reads the first page of GET /v1/scheduled,
+// unfiltered and for one status
+func BenchmarkGetAllScheduledMessages(b *testing.B) {
+	ctx := context.Background()
+	sdb := openBenchDB(b, time.Now())
+
+	filters := []struct {
+		name   string
+		filter MessageFilter
+	}{
+		{"all", MessageFilter{Limit: benchPageLimit}},
+		{"pending", MessageFilter{Status: "pending", Limit: benchPageLimit}},
+	}
+	for _, f := range filters {
+		b.Run(f.name+"/prepared", func(b *testing.B) {
+			for b.Loop() {
+				messages, err := getAllScheduledMessagesPrepared(ctx, sdb, f.filter)
+				expectMessages(b, messages, err, benchPageLimit)
+			}
+		})
+		b.Run(f.name+"/unprepared", func(b *testing.B) {
+			for b.Loop() {
+				messages, err := sdb.GetAllScheduledMessages(ctx, f.filter)
+				expectMessages(b, messages, err, benchPageLimit)
+			}
+		})
+	}
+}
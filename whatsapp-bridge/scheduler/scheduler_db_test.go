@@ -0,0 +1,215 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"whatsapp-client/sqlitedb"
+)
+
+// openTestDB opens the scheduler database at path the way the bridge does,
+// creating it when it doesn't exist yet. Opening the same path again is
+// another bridge instance sharing the database.
+func openTestDB(t *testing.T, path string) *SchedulerDB {
+	t.Helper()
+	// The migration log would bury the results
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	sdb, err := NewSchedulerDB(path, sqlitedb.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sdb.Close() })
+	return sdb
+}
+
+// insertTestMessage schedules a message to 15550000000 for scheduled
+func insertTestMessage(t *testing.T, sdb *SchedulerDB, id string, scheduled time.Time, status, precision string) {
+	t.Helper()
+	err := sdb.InsertScheduledMessage(context.Background(), &ScheduledMessage{
+		ID:            id,
+		Recipient:     "15550000000",
+		Message:       "Test message",
+		ScheduledTime: scheduled,
+		CreatedAt:     scheduled.Add(-time.Hour),
+		LastMessageAt: scheduled.Add(-time.Hour),
+		Status:        status,
+		DeliveryMode:  "scheduled",
+		Precision:     precision,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// messageStatus reads the status of a scheduled message
+func messageStatus(t *testing.T, sdb *SchedulerDB, id string) string {
+	t.Helper()
+	msg, err := sdb.GetScheduledMessage(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg.Status
+}
+
+func TestClaimPendingMessages(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Minute)
+
+	type message struct {
+		id        string
+		scheduled time.Time
+		status    string
+		precision string
+	}
+	tests := []struct {
+		name      string
+		messages  []message
+		precision string
+		want      []string
+	}{
+		{
+			name: "only due pending messages",
+			messages: []message{
+				{"due", past, "pending", "normal"},
+				{"future", now.Add(time.Minute), "pending", "normal"},
+				{"claimed", past, "sending", "normal"},
+				{"cancelled", past, "cancelled", "normal"},
+				{"sent", past, "sent", "normal"},
+			},
+			want: []string{"due"},
+		},
+		{
+			name: "scheduled at the tick",
+			messages: []message{
+				{"now", now, "pending", "normal"},
+			},
+			want: []string{"now"},
+		},
+		{
+			name: "one precision",
+			messages: []message{
+				{"normal", past, "pending", "normal"},
+				{"precise", past, "pending", "precise"},
+			},
+			precision: "precise",
+			want:      []string{"precise"},
+		},
+		{
+			name: "nothing due",
+			messages: []message{
+				{"future", now.Add(time.Hour), "pending", "normal"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sdb := openTestDB(t, filepath.Join(t.TempDir(), "scheduler.db"))
+			for _, msg := range tt.messages {
+				insertTestMessage(t, sdb, msg.id, msg.scheduled, msg.status, msg.precision)
+			}
+
+			claimed, err := sdb.ClaimPendingMessages(context.Background(), now, tt.precision)
+			if err != nil {
+				t.Fatalf("ClaimPendingMessages: %v", err)
+			}
+			var got []string
+			for _, msg := range claimed {
+				if msg.Status != "sending" {
+					t.Errorf("claimed message %s has status %q, want sending", msg.ID, msg.Status)
+				}
+				if status := messageStatus(t, sdb, msg.ID); status != "sending" {
+					t.Errorf("stored message %s has status %q, want sending", msg.ID, status)
+				}
+				got = append(got, msg.ID)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("claimed %v, want %v", got, tt.want)
+			}
+
+			// A second tick finds nothing left to claim
+			again, err := sdb.ClaimPendingMessages(context.Background(), now, tt.precision)
+			if err != nil {
+				t.Fatalf("ClaimPendingMessages: %v", err)
+			}
+			if len(again) != 0 {
+				t.Errorf("second claim got %d messages, want none", len(again))
+			}
+		})
+	}
+}
+
+func TestClaimPendingMessagesConcurrently(t *testing.T) {
+	const messages = 200
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		claimers int
+	}{
+		{"one claimer", 1},
+		{"two claimers", 2},
+		{"four claimers", 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "scheduler.db")
+			sdb := openTestDB(t, path)
+			for i := 0; i < messages; i++ {
+				insertTestMessage(t, sdb, fmt.Sprintf("msg-%03d", i), now.Add(-time.Duration(i+1)*time.Second), "pending", "normal")
+			}
+
+			// Each claimer is a bridge instance with its own connections,
+			// claiming at the same tick
+			claimedBy := make([][]string, tt.claimers)
+			errs := make([]error, tt.claimers)
+			start := make(chan struct{})
+			var wg sync.WaitGroup
+			for c := 0; c < tt.claimers; c++ {
+				instance := openTestDB(t, path)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+					claimed, err := instance.ClaimPendingMessages(context.Background(), now, "")
+					for _, msg := range claimed {
+						claimedBy[c] = append(claimedBy[c], msg.ID)
+					}
+					errs[c] = err
+				}()
+			}
+			close(start)
+			wg.Wait()
+
+			seen := make(map[string]int)
+			for c, ids := range claimedBy {
+				if errs[c] != nil {
+					t.Fatalf("claimer %d: %v", c, errs[c])
+				}
+				for _, id := range ids {
+					if other, dup := seen[id]; dup {
+						t.Errorf("message %s claimed by claimers %d and %d", id, other, c)
+					}
+					seen[id] = c
+				}
+			}
+			if len(seen) != messages {
+				var missing []string
+				for i := 0; i < messages; i++ {
+					id := fmt.Sprintf("msg-%03d", i)
+					if _, ok := seen[id]; !ok {
+						missing = append(missing, id)
+					}
+				}
+				t.Errorf("claimed %d of %d messages, missing %v", len(seen), messages, missing)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+)
+
+// StatusChange reports a scheduled message moving to a new status
+type StatusChange struct {
+	MessageID string    `json:"message_id"`
+	Status    string    `json:"status"`
+	Error     *string   `json:"error,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// OnStatusChange registers a function called after each status change the
+// scheduler or its API makes. Call it before Start; fn must not block.
+func (ms *MessageScheduler) OnStatusChange(fn func(StatusChange)) {
+	ms.onStatusChange = fn
+}
+
+// updateStatus stores a status change and reports it to the OnStatusChange listener
+func (ms *MessageScheduler) updateStatus(ctx context.Context, id string, status string, sentAt *time.Time, errorMsg *string) error {
+	if err := ms.schedulerDB.UpdateMessageStatus(ctx, id, status, sentAt, errorMsg); err != nil {
+		return err
+	}
+	ms.notifyStatus(id, status, errorMsg)
+	return nil
+}
+
+// notifyStatus reports a status change that was stored some other way
+func (ms *MessageScheduler) notifyStatus(id string, status string, errorMsg *string) {
+	if ms.onStatusChange != nil {
+		ms.onStatusChange(StatusChange{MessageID: id, Status: status, Error: errorMsg, At: time.Now().UTC()})
+	}
+}
@@ -1,10 +1,21 @@
 package scheduler
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/audit"
+	"whatsapp-client/jobs"
+	"whatsapp-client/pagination"
+	"whatsapp-client/validate"
 )
 
 // ScheduleMessageRequest represents the request to schedule a message
@@ -13,197 +24,551 @@ type ScheduleMessageRequest struct {
 	Message          string `json:"message"`
 	ScheduledTime    string `json:"scheduled_time"` // ISO-8601 format
 	CheckForResponse bool   `json:"check_for_response"`
+	// DeliveryMode is "scheduled" (default) or "online"
+	DeliveryMode        string `json:"delivery_mode,omitempty"`
+	OnlineWindowMinutes int    `json:"online_window_minutes,omitempty"`
+	// Precision is "normal" (default) or "precise"
+	Precision string `json:"precision,omitempty"`
+	// Metadata is any JSON value, stored with the message and returned untouched
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+	// Media is a file to send, with the message as its optional caption
+	Media *Media `json:"media,omitempty"`
+	// Typing shows "typing…" in the chat before sending, longer for longer messages
+	Typing bool `json:"typing,omitempty"`
+	SendOptions
 }
 
-// SetupHandlers registers HTTP handlers for scheduler endpoints
-func SetupHandlers(scheduler *MessageScheduler) {
-	// POST /api/schedule - Schedule a new message
-	http.HandleFunc("/api/schedule", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
+// Validate checks every field of the request and returns the scheduled time.
+// The recipient is normalized for sending.
+func (req *ScheduleMessageRequest) Validate(v *validate.Validator) time.Time {
+	req.Recipient = v.Recipient("recipient", req.Recipient)
+	req.SendOptions.Validate(v, req.Recipient)
+	if req.Media != nil {
+		req.Media.Validate(v, "media")
+		ValidateStatusKind(v, "media.kind", req.Recipient, req.Media.Kind)
+		if req.Media.Kind == MediaVoice && req.Message != "" {
+			v.Add("message", validate.CodeInvalidValue, "must be empty, voice notes have no caption")
 		}
-
-		var req ScheduleMessageRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
-			return
+		if req.Media.Kind == MediaVoice && len(req.Mentions) > 0 {
+			v.Add("mentions", validate.CodeInvalidValue, "must be empty, voice notes have no caption to mention in")
 		}
-
-		// Validate required fields
-		if req.Recipient == "" {
-			http.Error(w, "Recipient is required", http.StatusBadRequest)
-			return
-		}
-		if req.Message == "" {
-			http.Error(w, "Message is required", http.StatusBadRequest)
-			return
+		req.ValidateNotText(v, "media messages")
+		req.ValidateViewOnce(v, req.Media.Kind)
+		v.MaxLength("message", req.Message, validate.MaxMessageLength)
+	} else {
+		v.Message("message", req.Message)
+		if IsStatus(req.Recipient) {
+			v.MaxLength("message", req.Message, MaxStatusLength)
 		}
-		if req.ScheduledTime == "" {
-			http.Error(w, "Scheduled time is required", http.StatusBadRequest)
+		req.ValidateViewOnce(v, "")
+	}
+	scheduledTime := v.Time("scheduled_time", req.ScheduledTime)
+	if !v.Failed("scheduled_time") && scheduledTime.Before(time.Now()) {
+		v.Add("scheduled_time", validate.CodeInvalidTime, "must be in the future")
+	}
+	v.OneOf("delivery_mode", req.DeliveryMode, DeliveryModeScheduled, DeliveryModeOnline)
+	if req.DeliveryMode == DeliveryModeOnline && (IsBroadcastList(req.Recipient) || IsNewsletter(req.Recipient) || IsStatus(req.Recipient)) {
+		v.Add("delivery_mode", validate.CodeInvalidValue, "must be scheduled for broadcast lists, channels and status updates, which are never online")
+	}
+	if req.CheckForResponse && IsStatus(req.Recipient) {
+		v.Add("check_for_response", validate.CodeInvalidValue, "must be false for status updates, which have no chat to respond in")
+	}
+	v.NotNegative("online_window_minutes", req.OnlineWindowMinutes)
+	v.OneOf("precision", req.Precision, PrecisionNormal, PrecisionPrecise)
+	if len(req.Metadata) > MaxMetadataSize {
+		v.Add("metadata", validate.CodeTooLong, fmt.Sprintf("must be at most %d bytes", MaxMetadataSize))
+	}
+	return scheduledTime
+}
+
+// creatorKey is the request context key holding the authenticated caller
+type creatorKey struct{}
+
+// WithCreator returns a context that attributes scheduled messages to creator.
+// Authentication middleware uses it to record which API key made a request.
+func WithCreator(ctx context.Context, creator string) context.Context {
+	return context.WithValue(ctx, creatorKey{}, creator)
+}
+
+// ContextCreator returns the caller attached with WithCreator, or ""
+func ContextCreator(ctx context.Context) string {
+	creator, _ := ctx.Value(creatorKey{}).(string)
+	return creator
+}
+
+// RequestCreator works out who is scheduling a message: the authenticated caller
+// if there is one, otherwise the self-reported X-Created-By header (for example
+// the MCP server and its session)
+func RequestCreator(r *http.Request) string {
+	if creator := ContextCreator(r.Context()); creator != "" {
+		return creator
+	}
+
+	creator := strings.TrimSpace(r.Header.Get("X-Created-By"))
+	if len(creator) > 200 {
+		creator = creator[:200]
+	}
+	return creator
+}
+
+// messageFilterFromQuery reads the status, recipient and created_by list filters
+func messageFilterFromQuery(r *http.Request) MessageFilter {
+	query := r.URL.Query()
+	return MessageFilter{
+		Status:    query.Get("status"),
+		Recipient: query.Get("recipient"),
+		CreatedBy: query.Get("created_by"),
+	}
+}
+
+// writeLookupError reports a failed GetScheduledMessage
+func writeLookupError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrMessageNotFound) {
+		apierror.NotFound(w, "Message not found")
+		return
+	}
+	slog.Error("Error getting scheduled message", "error", err)
+	apierror.Internal(w, "Failed to get scheduled message")
+}
+
+// maxImportSize is the largest backup POST /v1/scheduled/import reads, so a
+// huge or endless body can't exhaust the bridge's memory while it is decoded
+const maxImportSize = 256 << 20
+
+// SetupHandlers returns the HTTP handler serving the scheduler endpoints, which
+// live under /v1/schedule, /v1/scheduled and /v1/scheduler. Requests with a
+// method a route doesn't support get 405 with an Allow header. Errors use the
+// apierror envelope. Imports and exports can run as jobs of jobManager.
+func SetupHandlers(scheduler *MessageScheduler, jobManager *jobs.Manager) http.Handler {
+	mux := http.NewServeMux()
+
+	// POST /v1/schedule - Schedule a new message
+	mux.HandleFunc("POST /v1/schedule", func(w http.ResponseWriter, r *http.Request) {
+		var req ScheduleMessageRequest
+		var v validate.Validator
+		if !v.Decode(r, &req) {
+			v.Write(w)
 			return
 		}
-
-		// Parse scheduled time
-		scheduledTime, err := time.Parse(time.RFC3339, req.ScheduledTime)
-		if err != nil {
-			http.Error(w, "Invalid scheduled_time format. Use ISO-8601 (e.g., 2025-10-06T15:30:00Z)", http.StatusBadRequest)
+		scheduledTime := req.Validate(&v)
+		if !v.Valid() {
+			v.Write(w)
 			return
 		}
 
 		// Schedule the message
 		scheduledMsg, err := scheduler.ScheduleMessage(
+			r.Context(),
 			req.Recipient,
 			req.Message,
 			scheduledTime,
 			req.CheckForResponse,
+			ScheduleOptions{
+				DeliveryMode: req.DeliveryMode,
+				OnlineWindow: time.Duration(req.OnlineWindowMinutes) * time.Minute,
+				Precision:    req.Precision,
+				Metadata:     req.Metadata,
+				CreatedBy:    RequestCreator(r),
+				Media:        req.Media,
+				Typing:       req.Typing,
+				SendOptions:  req.SendOptions,
+			},
 		)
 		if err != nil {
-			log.Printf("Error scheduling message: %v", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			var invalid *ValidationError
+			if errors.As(err, &invalid) {
+				apierror.Write(w, http.StatusBadRequest, invalid.Code, invalid.Message)
+				return
+			}
+			slog.ErrorContext(r.Context(), "Error scheduling message", "error", err)
+			apierror.Internal(w, "Failed to schedule message")
 			return
 		}
+		audit.SetResource(r.Context(), scheduledMsg.ID)
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success":          true,
-			"message":          "Message scheduled successfully",
+			"success":           true,
+			"message":           "Message scheduled successfully",
 			"scheduled_message": scheduledMsg,
 		})
 	})
 
-	// GET /api/scheduled - List all scheduled messages
-	http.HandleFunc("/api/scheduled", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	// POST /v1/batch - Schedule, cancel, pause and resume several messages at once
+	mux.HandleFunc("POST /v1/batch", func(w http.ResponseWriter, r *http.Request) {
+		var req BatchRequest
+		var v validate.Validator
+		if !v.Decode(r, &req) {
+			v.Write(w)
+			return
+		}
+		atomic := req.Validate(&v)
+		if !v.Valid() {
+			v.Write(w)
 			return
 		}
 
-		// Get query parameters
-		status := r.URL.Query().Get("status")
-		recipient := r.URL.Query().Get("recipient")
+		results, ok := scheduler.RunBatch(r.Context(), req.Operations, atomic, RequestCreator(r))
+		w.Header().Set("Content-Type", "application/json")
+		if atomic && !ok {
+			// The error envelope, with the results saying which operation failed
+			var failed BatchResult
+			for _, result := range results {
+				if result.Status == BatchFailed {
+					failed = result
+				}
+			}
+			status := http.StatusConflict
+			switch failed.Error.Code {
+			case apierror.CodeNotFound:
+				status = http.StatusNotFound
+			case apierror.CodeInvalidRequest, apierror.CodeInvalidTime:
+				status = http.StatusBadRequest
+			case apierror.CodeInternal:
+				status = http.StatusInternalServerError
+			}
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(struct {
+				apierror.Envelope
+				Results []BatchResult `json:"results"`
+			}{
+				Envelope: apierror.Envelope{Error: apierror.Body{
+					Code:      failed.Error.Code,
+					Message:   fmt.Sprintf("Operation %d failed, so the batch was not applied: %s", failed.Index, failed.Error.Message),
+					RequestID: w.Header().Get(apierror.RequestIDHeader),
+				}},
+				Results: results,
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":     true,
+			"all_applied": ok,
+			"results":     results,
+		})
+	})
 
-		messages, err := scheduler.schedulerDB.GetAllScheduledMessages(status, recipient)
+	// GET /v1/scheduled - List scheduled messages, latest scheduled time first, a page at a time
+	listMessages := func(w http.ResponseWriter, r *http.Request) {
+		page, err := pagination.FromRequest(r)
 		if err != nil {
-			log.Printf("Error getting scheduled messages: %v", err)
-			http.Error(w, "Failed to get scheduled messages", http.StatusInternalServerError)
+			apierror.BadRequest(w, err.Error())
 			return
 		}
+		filter := messageFilterFromQuery(r)
+		var after MessageCursor
+		if ok, err := page.Decode(&after); err != nil {
+			apierror.BadRequest(w, "Invalid cursor")
+			return
+		} else if ok {
+			filter.After = &after
+		}
+		filter.Limit = page.Limit + 1
+
+		messages, err := scheduler.ListMessages(r.Context(), filter)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error getting scheduled messages", "error", err)
+			apierror.Internal(w, "Failed to get scheduled messages")
+			return
+		}
+		messages, next := pagination.Page(messages, page, func(msg *ScheduledMessage) interface{} {
+			return MessageCursor{ScheduledTime: msg.ScheduledTime, ID: msg.ID}
+		})
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success":  true,
-			"messages": messages,
+			"success":     true,
+			"messages":    messages,
+			"next_cursor": next,
 		})
-	})
+	}
+	mux.HandleFunc("GET /v1/scheduled", listMessages)
+	mux.HandleFunc("GET /v1/scheduled/{$}", listMessages)
 
-	// GET /api/scheduled/{id} - Get a specific scheduled message
-	http.HandleFunc("/api/scheduled/", func(w http.ResponseWriter, r *http.Request) {
-		// Extract ID from path
-		id := r.URL.Path[len("/api/scheduled/"):]
-		if id == "" {
-			http.Error(w, "Message ID is required", http.StatusBadRequest)
+	// POST /v1/scheduler/maintenance - Archive or delete old finished messages now
+	mux.HandleFunc("POST /v1/scheduler/maintenance", func(w http.ResponseWriter, r *http.Request) {
+		result, err := scheduler.RunMaintenance(r.Context())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error running maintenance", "error", err)
+			apierror.Internal(w, "Failed to run maintenance")
 			return
 		}
 
-		switch r.Method {
-		case http.MethodGet:
-			// Get specific message
-			msg, err := scheduler.schedulerDB.GetScheduledMessage(id)
-			if err != nil {
-				log.Printf("Error getting scheduled message: %v", err)
-				http.Error(w, "Message not found", http.StatusNotFound)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"result":  result,
+		})
+	})
+
+	// GET /v1/scheduler/stats?days=7&recipient= - Daily sent/failed/paused counters
+	mux.HandleFunc("GET /v1/scheduler/stats", func(w http.ResponseWriter, r *http.Request) {
+		days := 7
+		if v := r.URL.Query().Get("days"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 366 {
+				apierror.BadRequest(w, "days must be between 1 and 366")
 				return
 			}
+			days = n
+		}
 
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"success": true,
-				"message": msg,
-			})
+		recipient := r.URL.Query().Get("recipient")
+		if recipient != "" && !strings.Contains(recipient, "@") {
+			recipient += "@s.whatsapp.net"
+		}
 
-		case http.MethodDelete:
-			// Delete (cancel) scheduled message
-			// First check if message exists and is still pending
-			msg, err := scheduler.schedulerDB.GetScheduledMessage(id)
-			if err != nil {
-				http.Error(w, "Message not found", http.StatusNotFound)
-				return
-			}
+		// Today counts as the first day
+		since := time.Now().UTC().AddDate(0, 0, -(days - 1))
+		stats, err := scheduler.schedulerDB.GetDailyStats(r.Context(), since, recipient)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error getting scheduler stats", "error", err)
+			apierror.Internal(w, "Failed to get scheduler stats")
+			return
+		}
+
+		totals := map[string]int{"sent": 0, "failed": 0, "paused": 0}
+		for _, day := range stats {
+			totals["sent"] += day.Sent
+			totals["failed"] += day.Failed
+			totals["paused"] += day.Paused
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"days":    stats,
+			"totals":  totals,
+		})
+	})
 
-			if msg.Status != "pending" && msg.Status != "paused" {
-				http.Error(w, "Can only cancel pending or paused messages", http.StatusBadRequest)
+	// GET /v1/scheduled/export.csv - Spreadsheet-friendly dump, with the same filters as /v1/scheduled
+	mux.HandleFunc("GET /v1/scheduled/export.csv", func(w http.ResponseWriter, r *http.Request) {
+		messages, err := scheduler.schedulerDB.GetAllScheduledMessages(r.Context(), messageFilterFromQuery(r))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error getting scheduled messages", "error", err)
+			apierror.Internal(w, "Failed to get scheduled messages")
+			return
+		}
+
+		filename := "scheduled-messages-" + time.Now().UTC().Format("20060102T150405Z") + ".csv"
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		if err := WriteMessagesCSV(w, messages); err != nil {
+			slog.ErrorContext(r.Context(), "Error writing CSV export", "error", err)
+		}
+	})
+
+	// GET /v1/scheduled/upcoming?hours=24 - Pending messages due in the next hours
+	mux.HandleFunc("GET /v1/scheduled/upcoming", func(w http.ResponseWriter, r *http.Request) {
+		hours := 24
+		if v := r.URL.Query().Get("hours"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 24*366 {
+				apierror.BadRequest(w, "hours must be between 1 and 8784")
 				return
 			}
+			hours = n
+		}
 
-			// Update status to cancelled
-			if err := scheduler.schedulerDB.UpdateMessageStatus(id, "cancelled", nil, stringPtr("Cancelled by user")); err != nil {
-				log.Printf("Error cancelling message: %v", err)
-				http.Error(w, "Failed to cancel message", http.StatusInternalServerError)
+		from := time.Now()
+		to := from.Add(time.Duration(hours) * time.Hour)
+		messages, err := scheduler.schedulerDB.GetMessagesBetween(r.Context(), from, to)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error getting upcoming messages", "error", err)
+			apierror.Internal(w, "Failed to get upcoming messages")
+			return
+		}
+		if messages == nil {
+			messages = []*ScheduledMessage{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"from":     from,
+			"to":       to,
+			"messages": messages,
+		})
+	})
+
+	// GET /v1/scheduled/export - Download a full backup, including archived messages
+	mux.HandleFunc("GET /v1/scheduled/export", func(w http.ResponseWriter, r *http.Request) {
+		backup, err := scheduler.Export(r.Context())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error exporting scheduled messages", "error", err)
+			apierror.Internal(w, "Failed to export scheduled messages")
+			return
+		}
+
+		filename := "scheduler-backup-" + backup.ExportedAt.UTC().Format("20060102T150405Z") + ".json"
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		json.NewEncoder(w).Encode(backup)
+	})
+
+	// POST /v1/scheduled/export - Build a backup in a job, for large databases
+	mux.HandleFunc("POST /v1/scheduled/export", func(w http.ResponseWriter, r *http.Request) {
+		job, err := jobManager.Start(r.Context(), "export", RequestCreator(r), func(ctx context.Context, _ func(int, int)) (interface{}, error) {
+			return scheduler.Export(ctx)
+		})
+		if err != nil {
+			jobs.StartError(w, err)
+			return
+		}
+		jobs.Accepted(w, r, job)
+	})
+
+	// POST /v1/scheduled/import - Restore a backup produced by /v1/scheduled/export
+	mux.HandleFunc("POST /v1/scheduled/import", func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+		var backup Backup
+		if err := json.NewDecoder(r.Body).Decode(&backup); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				apierror.Write(w, http.StatusRequestEntityTooLarge, apierror.CodeTooLarge, fmt.Sprintf("Backup file must be at most %d MB", maxImportSize>>20))
 				return
 			}
+			apierror.BadRequest(w, "Invalid backup file")
+			return
+		}
 
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"success": true,
-				"message": "Message cancelled successfully",
+		if jobs.Async(r) {
+			job, err := jobManager.Start(r.Context(), "import", RequestCreator(r), func(ctx context.Context, _ func(int, int)) (interface{}, error) {
+				result, err := scheduler.Import(ctx, &backup)
+				var invalid *ValidationError
+				if errors.As(err, &invalid) {
+					return nil, jobs.Fail(invalid.Code, invalid.Message)
+				}
+				return result, err
 			})
-
-		case http.MethodPatch:
-			// Pause or resume scheduled message
-			var req struct {
-				Action string `json:"action"` // "pause" or "resume"
-			}
-			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-				http.Error(w, "Invalid request body", http.StatusBadRequest)
+			if err != nil {
+				jobs.StartError(w, err)
 				return
 			}
+			jobs.Accepted(w, r, job)
+			return
+		}
 
-			msg, err := scheduler.schedulerDB.GetScheduledMessage(id)
-			if err != nil {
-				http.Error(w, "Message not found", http.StatusNotFound)
+		result, err := scheduler.Import(r.Context(), &backup)
+		if err != nil {
+			var invalid *ValidationError
+			if errors.As(err, &invalid) {
+				apierror.Write(w, http.StatusBadRequest, invalid.Code, invalid.Message)
 				return
 			}
+			slog.ErrorContext(r.Context(), "Error importing scheduled messages", "error", err)
+			apierror.Internal(w, "Failed to import scheduled messages")
+			return
+		}
 
-			var newStatus string
-			var reason *string
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"result":  result,
+		})
+	})
 
-			switch req.Action {
-			case "pause":
-				if msg.Status != "pending" {
-					http.Error(w, "Can only pause pending messages", http.StatusBadRequest)
-					return
-				}
-				newStatus = "paused"
-				reason = stringPtr("Paused by user")
+	// GET /v1/scheduled/{id}/attempts - List send attempts for a message
+	mux.HandleFunc("GET /v1/scheduled/{id}/attempts", func(w http.ResponseWriter, r *http.Request) {
+		attempts, err := scheduler.schedulerDB.GetSendAttempts(r.Context(), r.PathValue("id"))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error getting send attempts", "error", err)
+			apierror.Internal(w, "Failed to get send attempts")
+			return
+		}
 
-			case "resume":
-				if msg.Status != "paused" {
-					http.Error(w, "Can only resume paused messages", http.StatusBadRequest)
-					return
-				}
-				newStatus = "pending"
-				reason = nil
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"attempts": attempts,
+		})
+	})
 
-			default:
-				http.Error(w, "Invalid action. Use 'pause' or 'resume'", http.StatusBadRequest)
-				return
-			}
+	// GET /v1/scheduled/{id} - Get a specific scheduled message
+	mux.HandleFunc("GET /v1/scheduled/{id}", func(w http.ResponseWriter, r *http.Request) {
+		msg, err := scheduler.schedulerDB.GetScheduledMessage(r.Context(), r.PathValue("id"))
+		if err != nil {
+			writeLookupError(w, err)
+			return
+		}
 
-			if err := scheduler.schedulerDB.UpdateMessageStatus(id, newStatus, nil, reason); err != nil {
-				log.Printf("Error updating message status: %v", err)
-				http.Error(w, "Failed to update message", http.StatusInternalServerError)
-				return
-			}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": msg,
+		})
+	})
 
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"success": true,
-				"message": "Message " + req.Action + "d successfully",
-			})
+	// DELETE /v1/scheduled/{id} - Cancel a pending or paused message
+	mux.HandleFunc("DELETE /v1/scheduled/{id}", func(w http.ResponseWriter, r *http.Request) {
+		audit.SetResource(r.Context(), r.PathValue("id"))
+		_, err := scheduler.Cancel(r.Context(), r.PathValue("id"))
+		switch {
+		case errors.Is(err, ErrMessageNotFound):
+			apierror.NotFound(w, "Message not found")
+			return
+		case errors.Is(err, ErrNotCancellable):
+			apierror.Conflict(w, "Can only cancel pending or paused messages")
+			return
+		case err != nil:
+			slog.ErrorContext(r.Context(), "Error cancelling message", "error", err)
+			apierror.Internal(w, "Failed to cancel message")
+			return
+		}
 
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Message cancelled successfully",
+		})
+	})
+
+	// PATCH /v1/scheduled/{id} - Pause or resume a message
+	mux.HandleFunc("PATCH /v1/scheduled/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		audit.SetResource(r.Context(), id)
+
+		var req struct {
+			Action string `json:"action"` // "pause" or "resume"
+		}
+		var v validate.Validator
+		if v.Decode(r, &req) && v.Required("action", req.Action) {
+			v.OneOf("action", req.Action, "pause", "resume")
+		}
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+
+		var err error
+		if req.Action == "pause" {
+			_, err = scheduler.Pause(r.Context(), id)
+		} else {
+			_, err = scheduler.Resume(r.Context(), id)
 		}
+		switch {
+		case errors.Is(err, ErrNotPausable):
+			apierror.Conflict(w, "Can only pause pending messages")
+			return
+		case errors.Is(err, ErrNotResumable):
+			apierror.Conflict(w, "Can only resume paused messages")
+			return
+		case errors.Is(err, ErrMessageNotFound):
+			apierror.NotFound(w, "Message not found")
+			return
+		case err != nil:
+			slog.ErrorContext(r.Context(), "Error updating message status", "error", err)
+			apierror.Internal(w, "Failed to update message")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Message " + req.Action + "d successfully",
+		})
 	})
+
+	return apierror.Wrap(mux)
 }
@@ -0,0 +1,48 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// StalledWorkerAfter is how long the worker may go without finishing a tick
+// before Health reports it as stalled. The precise tick runs every 5 seconds,
+// so only a send or maintenance run that hangs gets this far.
+const StalledWorkerAfter = 5 * time.Minute
+
+// Health describes the scheduler worker for readiness checks
+type Health struct {
+	Running bool `json:"running"`
+	// Leader is set when this instance holds the processing lease; standby
+	// instances are healthy too
+	Leader     bool       `json:"leader"`
+	LastTickAt *time.Time `json:"last_tick_at,omitempty"`
+}
+
+// Health reports the worker state, and an error when the worker isn't running
+// or has stalled
+func (ms *MessageScheduler) Health() (Health, error) {
+	ms.lifecycleMu.Lock()
+	running := ms.cancel != nil
+	ms.lifecycleMu.Unlock()
+
+	health := Health{Running: running, Leader: ms.IsLeader()}
+	if nanos := ms.lastTick.Load(); nanos != 0 {
+		lastTick := time.Unix(0, nanos)
+		health.LastTickAt = &lastTick
+	}
+
+	switch {
+	case !running:
+		return health, fmt.Errorf("scheduler worker is not running")
+	case health.LastTickAt != nil && time.Since(*health.LastTickAt) > StalledWorkerAfter:
+		return health, fmt.Errorf("scheduler worker has not finished a tick since %s", health.LastTickAt.Format(time.RFC3339))
+	}
+	return health, nil
+}
+
+// PingDB checks that the scheduler database is reachable
+func (ms *MessageScheduler) PingDB(ctx context.Context) error {
+	return ms.schedulerDB.Ping(ctx)
+}
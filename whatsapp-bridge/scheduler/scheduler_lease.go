@@ -0,0 +1,110 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// processingLease is the lease row that decides which bridge instance sends scheduled messages
+const processingLease = "processing"
+
+// DefaultLeaseTTL is how long a lease stays valid without being renewed. If the
+// instance holding it dies, another instance takes over within this period.
+const DefaultLeaseTTL = 30 * time.Second
+
+// AcquireLease takes or renews the named lease for holder. It succeeds if the
+// lease is free, expired, or already held by holder, and reports whether holder
+// now owns the lease.
+func (sdb *SchedulerDB) AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration, now time.Time) (bool, error) {
+	result, err := sdb.execPrepared(ctx, `
+		INSERT INTO scheduler_leases (name, holder, expires_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(name) DO UPDATE
+		SET holder = excluded.holder, expires_at = excluded.expires_at
+		WHERE scheduler_leases.holder = excluded.holder
+		   OR scheduler_leases.expires_at <= ?
+	`, name, holder, now.Add(ttl).UnixMilli(), now.UnixMilli())
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// ReleaseLease gives up the named lease if it is held by holder
+func (sdb *SchedulerDB) ReleaseLease(ctx context.Context, name string, holder string) error {
+	_, err := sdb.exec(ctx, "DELETE FROM scheduler_leases WHERE name = ? AND holder = ?", name, holder)
+	return err
+}
+
+// newInstanceID builds an identifier that is unique per bridge process
+func newInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), uuid.New().String()[:8])
+}
+
+// SetLeaseTTL changes how long this instance's processing lease lasts between renewals
+func (ms *MessageScheduler) SetLeaseTTL(ttl time.Duration) {
+	ms.leaseMu.Lock()
+	defer ms.leaseMu.Unlock()
+	ms.leaseTTL = ttl
+}
+
+// IsLeader reports whether this instance held the processing lease at its last check
+func (ms *MessageScheduler) IsLeader() bool {
+	ms.leaseMu.Lock()
+	defer ms.leaseMu.Unlock()
+	return ms.isLeader
+}
+
+// holdLease acquires or renews the processing lease and reports whether this
+// instance may send scheduled messages
+func (ms *MessageScheduler) holdLease(ctx context.Context) bool {
+	ms.leaseMu.Lock()
+	defer ms.leaseMu.Unlock()
+
+	acquired, err := ms.schedulerDB.AcquireLease(ctx, processingLease, ms.instanceID, ms.leaseTTL, time.Now())
+	if err != nil {
+		// Without a confirmed lease we can't rule out another sender
+		slog.WarnContext(ctx, "Error renewing scheduler lease", "error", err)
+		acquired = false
+	}
+
+	if acquired != ms.isLeader {
+		if acquired {
+			slog.InfoContext(ctx, "Acquired the scheduler lease", "instance", ms.instanceID)
+			// The previous leader may have stopped mid-send
+			ms.needsRecovery = true
+		} else {
+			slog.InfoContext(ctx, "On standby, another instance holds the scheduler lease", "instance", ms.instanceID)
+		}
+	}
+	ms.isLeader = acquired
+	return acquired
+}
+
+// releaseLease gives up the processing lease so a standby instance can take over immediately
+func (ms *MessageScheduler) releaseLease() {
+	ms.leaseMu.Lock()
+	defer ms.leaseMu.Unlock()
+
+	if !ms.isLeader {
+		return
+	}
+	if err := ms.schedulerDB.ReleaseLease(context.Background(), processingLease, ms.instanceID); err != nil {
+		slog.Warn("Error releasing scheduler lease", "instance", ms.instanceID, "error", err)
+	}
+	ms.isLeader = false
+}
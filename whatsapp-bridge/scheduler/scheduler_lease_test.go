@@ -0,0 +1,140 @@
+package scheduler
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcquireLease(t *testing.T) {
+	const ttl = 30 * time.Second
+
+	// A step has holder take or renew the lease at an offset from the start,
+	// or give it up
+	type step struct {
+		holder  string
+		at      time.Duration
+		release bool
+		want    bool
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name:  "free",
+			steps: []step{{holder: "a", want: true}},
+		},
+		{
+			name: "renewed by its holder",
+			steps: []step{
+				{holder: "a", want: true},
+				{holder: "a", at: 10 * time.Second, want: true},
+			},
+		},
+		{
+			name: "refused to another holder until it expires",
+			steps: []step{
+				{holder: "a", want: true},
+				{holder: "b", at: 10 * time.Second, want: false},
+				{holder: "b", at: ttl - time.Millisecond, want: false},
+				{holder: "b", at: ttl, want: true},
+				{holder: "a", at: ttl + time.Second, want: false},
+			},
+		},
+		{
+			name: "renewing keeps it",
+			steps: []step{
+				{holder: "a", want: true},
+				{holder: "a", at: 20 * time.Second, want: true},
+				{holder: "b", at: ttl + time.Second, want: false},
+				{holder: "b", at: 20*time.Second + ttl, want: true},
+			},
+		},
+		{
+			name: "free again once released",
+			steps: []step{
+				{holder: "a", want: true},
+				{holder: "b", release: true},
+				{holder: "b", at: time.Second, want: false},
+				{holder: "a", release: true},
+				{holder: "b", at: time.Second, want: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			sdb := openTestDB(t, filepath.Join(t.TempDir(), "scheduler.db"))
+			start := time.Now()
+
+			for i, s := range tt.steps {
+				if s.release {
+					if err := sdb.ReleaseLease(ctx, processingLease, s.holder); err != nil {
+						t.Fatalf("step %d: ReleaseLease: %v", i, err)
+					}
+					continue
+				}
+				got, err := sdb.AcquireLease(ctx, processingLease, s.holder, ttl, start.Add(s.at))
+				if err != nil {
+					t.Fatalf("step %d: AcquireLease: %v", i, err)
+				}
+				if got != s.want {
+					t.Errorf("step %d: %s acquiring at %v = %v, want %v", i, s.holder, s.at, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLeaseTakeoverResetsSending(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "scheduler.db")
+	leaderDB, standbyDB := openTestDB(t, path), openTestDB(t, path)
+	leader := NewMessageScheduler(leaderDB, nil, nil, nil)
+	standby := NewMessageScheduler(standbyDB, nil, nil, nil)
+
+	if !leader.holdLease(ctx) {
+		t.Fatal("first instance didn't get the free lease")
+	}
+	now := time.Now()
+	insertTestMessage(t, leaderDB, "due-1", now.Add(-time.Minute), "pending", "normal")
+	insertTestMessage(t, leaderDB, "due-2", now.Add(-time.Second), "pending", "normal")
+	claimed, err := leaderDB.ClaimPendingMessages(ctx, now, "")
+	if err != nil || len(claimed) != 2 {
+		t.Fatalf("leader claimed %d messages (%v), want 2", len(claimed), err)
+	}
+
+	statuses := func(want string) {
+		t.Helper()
+		for _, id := range []string{"due-1", "due-2"} {
+			if status := messageStatus(t, standbyDB, id); status != want {
+				t.Errorf("message %s has status %q, want %q", id, status, want)
+			}
+		}
+	}
+
+	// While the leader holds the lease its sends are left alone
+	if standby.holdLease(ctx) {
+		t.Fatal("standby took a lease that is still held")
+	}
+	standby.recoverAfterTakeover(ctx)
+	statuses("sending")
+
+	// The leader stops renewing mid-send, here by its lease having run out
+	// long ago
+	expired := now.Add(-time.Hour)
+	if ok, err := leaderDB.AcquireLease(ctx, processingLease, leader.instanceID, DefaultLeaseTTL, expired); err != nil || !ok {
+		t.Fatalf("backdating the leader's lease: %v, %v", ok, err)
+	}
+	if !standby.holdLease(ctx) {
+		t.Fatal("standby didn't take over the expired lease")
+	}
+	if !standby.IsLeader() {
+		t.Error("standby doesn't report itself leader after taking over")
+	}
+	standby.recoverAfterTakeover(ctx)
+	statuses("pending")
+}
@@ -0,0 +1,197 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"whatsapp-client/tracing"
+)
+
+// Retention modes for finished (sent, cancelled, failed) messages
+const (
+	// RetentionArchive moves old finished messages into scheduled_messages_archive
+	RetentionArchive = "archive"
+	// RetentionDelete deletes old finished messages
+	RetentionDelete = "delete"
+	// RetentionOff keeps finished messages forever
+	RetentionOff = "off"
+)
+
+// RetentionOptions controls the maintenance job that cleans up finished messages
+type RetentionOptions struct {
+	// Mode is RetentionArchive, RetentionDelete or RetentionOff
+	Mode string
+	// Retention is how long finished messages stay in scheduled_messages,
+	// counted from their scheduled time
+	Retention time.Duration
+}
+
+// DefaultRetentionOptions archives finished messages after 30 days
+func DefaultRetentionOptions() RetentionOptions {
+	return RetentionOptions{
+		Mode:      RetentionArchive,
+		Retention: 30 * 24 * time.Hour,
+	}
+}
+
+// MaintenanceInterval is how often the maintenance job runs
+const MaintenanceInterval = time.Hour
+
+// archiveBatchSize bounds how many rows are moved per transaction
+const archiveBatchSize = 500
+
+// MaintenanceResult reports what a maintenance run did
+type MaintenanceResult struct {
+	Mode     string    `json:"mode"`
+	Cutoff   time.Time `json:"cutoff"`
+	Archived int64     `json:"archived"`
+	Deleted  int64     `json:"deleted"`
+}
+
+// ArchiveMessages copies messages into the archive table and removes them from
+// scheduled_messages in a single transaction
+func (sdb *SchedulerDB) ArchiveMessages(ctx context.Context, messages []*ScheduledMessage, archivedAt time.Time) error {
+	tx, err := sdb.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, msg := range messages {
+		payload, err := sdb.encodeArchivePayload(msg)
+		if err != nil {
+			return err
+		}
+
+		if _, err := sdb.txExec(ctx, tx, `
+			INSERT INTO scheduled_messages_archive (id, recipient, status, scheduled_time, payload, archived_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, msg.ID, msg.Recipient, msg.Status, msg.ScheduledTime, payload, archivedAt); err != nil {
+			return fmt.Errorf("failed to archive message %s: %w", msg.ID, err)
+		}
+
+		if _, err := sdb.txExec(ctx, tx, "DELETE FROM scheduled_messages WHERE id = ?", msg.ID); err != nil {
+			return fmt.Errorf("failed to remove archived message %s: %w", msg.ID, err)
+		}
+	}
+
+	return sdb.commit(tx)
+}
+
+// DeleteFinishedMessagesBefore deletes sent, cancelled and failed messages
+// scheduled before cutoff, along with their send attempts
+func (sdb *SchedulerDB) DeleteFinishedMessagesBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	tx, err := sdb.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := sdb.txExec(ctx, tx, `
+		DELETE FROM send_attempts
+		WHERE message_id IN (
+			SELECT id FROM scheduled_messages
+			WHERE status IN ('sent', 'cancelled', 'failed')
+			  AND scheduled_time < ?
+		)
+	`, cutoff); err != nil {
+		return 0, err
+	}
+
+	result, err := sdb.txExec(ctx, tx, `
+		DELETE FROM scheduled_messages
+		WHERE status IN ('sent', 'cancelled', 'failed')
+		  AND scheduled_time < ?
+	`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, sdb.commit(tx)
+}
+
+// SetRetention changes how the maintenance job treats finished messages
+func (ms *MessageScheduler) SetRetention(opts RetentionOptions) error {
+	switch opts.Mode {
+	case RetentionArchive, RetentionDelete, RetentionOff:
+	default:
+		return fmt.Errorf("invalid retention mode %q", opts.Mode)
+	}
+	if opts.Mode != RetentionOff && opts.Retention <= 0 {
+		return fmt.Errorf("retention period must be positive")
+	}
+
+	ms.maintenanceMu.Lock()
+	defer ms.maintenanceMu.Unlock()
+	ms.retention = opts
+	return nil
+}
+
+// RunMaintenance archives or deletes finished messages older than the retention period
+func (ms *MessageScheduler) RunMaintenance(ctx context.Context) (*MaintenanceResult, error) {
+	ms.maintenanceMu.Lock()
+	opts := ms.retention
+	ms.maintenanceMu.Unlock()
+
+	result := &MaintenanceResult{Mode: opts.Mode}
+	if opts.Mode == RetentionOff {
+		return result, nil
+	}
+
+	now := time.Now()
+	result.Cutoff = now.Add(-opts.Retention)
+
+	switch opts.Mode {
+	case RetentionDelete:
+		deleted, err := ms.schedulerDB.DeleteFinishedMessagesBefore(ctx, result.Cutoff)
+		if err != nil {
+			return result, fmt.Errorf("failed to delete old messages: %w", err)
+		}
+		result.Deleted = deleted
+
+	case RetentionArchive:
+		for {
+			batch, err := ms.schedulerDB.GetFinishedMessagesBefore(ctx, result.Cutoff, archiveBatchSize)
+			if err != nil {
+				return result, fmt.Errorf("failed to find old messages: %w", err)
+			}
+			if len(batch) == 0 {
+				break
+			}
+			if err := ms.schedulerDB.ArchiveMessages(ctx, batch, now); err != nil {
+				return result, fmt.Errorf("failed to archive old messages: %w", err)
+			}
+			result.Archived += int64(len(batch))
+			if len(batch) < archiveBatchSize {
+				break
+			}
+		}
+	}
+
+	if result.Archived > 0 || result.Deleted > 0 {
+		slog.InfoContext(ctx, "Scheduler maintenance finished",
+			"archived", result.Archived, "deleted", result.Deleted, "cutoff", result.Cutoff.Format(time.RFC3339))
+	}
+	return result, nil
+}
+
+// runScheduledMaintenance is the periodic maintenance run; only the lease holder does it
+func (ms *MessageScheduler) runScheduledMaintenance() {
+	ctx, span := tracing.Start(context.Background(), "scheduler.maintenance")
+	defer span.End()
+
+	if !ms.holdLease(ctx) {
+		return
+	}
+	if _, err := ms.RunMaintenance(ctx); err != nil {
+		span.RecordError(err)
+		slog.ErrorContext(ctx, "Error running scheduler maintenance", "error", err)
+	}
+	ms.runScheduledBackup(ctx)
+}
@@ -0,0 +1,96 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+
+	"go.mau.fi/whatsmeow"
+
+	"whatsapp-client/validate"
+)
+
+// Kinds of media a scheduled message can carry
+const (
+	MediaImage    = "image"
+	MediaVideo    = "video"
+	MediaDocument = "document"
+	MediaVoice    = "voice"
+)
+
+// MediaKinds lists every media kind, for validation
+var MediaKinds = []string{MediaImage, MediaVideo, MediaDocument, MediaVoice}
+
+// Media is a file sent with a scheduled message, with the message text as its
+// caption; voice notes have none. The file is read when the message is sent, not when it's scheduled.
+type Media struct {
+	Kind string `json:"kind"`
+	// Path is a file on the bridge host
+	Path string `json:"path,omitempty"`
+	// URL is an http or https URL the file is fetched from
+	URL string `json:"url,omitempty"`
+	// Filename and Mimetype are shown for a document in place of the file's own
+	// name and detected type
+	Filename string `json:"filename,omitempty"`
+	Mimetype string `json:"mimetype,omitempty"`
+}
+
+// MediaSender sends a scheduled message's media with its caption
+type MediaSender func(ctx context.Context, client *whatsmeow.Client, recipient, caption string, media Media, opts SendOptions) SendResult
+
+// MediaChecker reads and checks media when a message is scheduled, so a
+// missing or unsupported file is reported right away rather than at send time
+type MediaChecker func(ctx context.Context, media Media) error
+
+// SetMediaSender lets the scheduler send messages with media. Until it is
+// called, scheduling a message with media fails.
+func (ms *MessageScheduler) SetMediaSender(send MediaSender, check MediaChecker) {
+	ms.mediaSender = send
+	ms.mediaChecker = check
+}
+
+// Validate checks the media fields of a request under field
+func (m *Media) Validate(v *validate.Validator, field string) {
+	if v.Required(field+".kind", m.Kind) {
+		v.OneOf(field+".kind", m.Kind, MediaKinds...)
+	}
+	if (m.Path == "") == (m.URL == "") {
+		v.Add(field+".path", validate.CodeRequired, "exactly one of path or url is required")
+	}
+	ValidateDocumentFields(v, field+".", m.Kind, m.Filename, m.Mimetype)
+}
+
+// ValidateDocumentFields checks the filename and mimetype of a media request,
+// which only documents take. prefix is put before the field names.
+func ValidateDocumentFields(v *validate.Validator, prefix, kind, filename, mimetype string) {
+	if kind != MediaDocument {
+		if filename != "" {
+			v.Add(prefix+"filename", validate.CodeInvalidValue, "is only used for documents")
+		}
+		if mimetype != "" {
+			v.Add(prefix+"mimetype", validate.CodeInvalidValue, "is only used for documents")
+		}
+		return
+	}
+	v.Filename(prefix+"filename", filename)
+	v.Mimetype(prefix+"mimetype", mimetype)
+}
+
+// sendMedia sends msg with its media through the media sender
+func (ms *MessageScheduler) sendMedia(ctx context.Context, msg *ScheduledMessage) SendResult {
+	if ms.mediaSender == nil {
+		return SendResult{Message: "This bridge can't send media"}
+	}
+	return ms.mediaSender(ctx, ms.client, msg.Recipient, msg.Message, *msg.Media, msg.SendOptions)
+}
+
+// mediaValue stores media as JSON, or NULL for a text message
+func mediaValue(media *Media) interface{} {
+	if media == nil {
+		return nil
+	}
+	data, err := json.Marshal(media)
+	if err != nil {
+		return nil
+	}
+	return string(data)
+}
@@ -0,0 +1,53 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+)
+
+// QuietHours is a daily window during which no scheduled messages are sent.
+// Messages that fall due inside it stay pending and go out when it ends.
+type QuietHours struct {
+	// Start and End are offsets from midnight. The window wraps past midnight
+	// when End is before Start, and is off while they are equal.
+	Start time.Duration
+	End   time.Duration
+	// Location is the zone the times are in; nil uses the local zone
+	Location *time.Location
+}
+
+// Contains reports whether t falls inside the window
+func (q QuietHours) Contains(t time.Time) bool {
+	if q.Start == q.End {
+		return false
+	}
+	if q.Location != nil {
+		t = t.In(q.Location)
+	}
+	// Wall-clock time, so DST changes don't shift the window
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+
+	if q.Start < q.End {
+		return offset >= q.Start && offset < q.End
+	}
+	return offset >= q.Start || offset < q.End
+}
+
+// SetQuietHours changes the window during which sending is held back
+func (ms *MessageScheduler) SetQuietHours(q QuietHours) error {
+	if q.Start < 0 || q.Start >= 24*time.Hour || q.End < 0 || q.End >= 24*time.Hour {
+		return fmt.Errorf("quiet hours must be times of day")
+	}
+
+	ms.quietMu.Lock()
+	defer ms.quietMu.Unlock()
+	ms.quietHours = q
+	return nil
+}
+
+// inQuietHours reports whether sending is held back at t
+func (ms *MessageScheduler) inQuietHours(t time.Time) bool {
+	ms.quietMu.Lock()
+	defer ms.quietMu.Unlock()
+	return ms.quietHours.Contains(t)
+}
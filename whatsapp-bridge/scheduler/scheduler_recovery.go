@@ -0,0 +1,153 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// CheckIntegrity runs the database's own consistency check and returns the
+// problems it reports. Only SQLite has one; other backends report nothing.
+func (sdb *SchedulerDB) CheckIntegrity(ctx context.Context) ([]string, error) {
+	if sdb.dialect.name() != "sqlite" {
+		return nil, nil
+	}
+
+	rows, err := sdb.query(ctx, "PRAGMA integrity_check")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return nil, err
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+
+	return problems, rows.Err()
+}
+
+// RecoveryResult summarizes what recoverInterruptedSends did
+type RecoveryResult struct {
+	Reset  int `json:"reset"`
+	Sent   int `json:"sent"`
+	Failed int `json:"failed"`
+}
+
+// checkIntegrity logs any corruption found in the scheduler database at startup
+func (ms *MessageScheduler) checkIntegrity() {
+	checker, ok := ms.schedulerDB.(interface {
+		CheckIntegrity(ctx context.Context) ([]string, error)
+	})
+	if !ok {
+		return
+	}
+
+	problems, err := checker.CheckIntegrity(context.Background())
+	if err != nil {
+		slog.Warn("Could not check scheduler database integrity", "error", err)
+		return
+	}
+	if len(problems) > 0 {
+		slog.Error("Scheduler database integrity check found problems, restore from a backup if this persists",
+			"count", len(problems), "problems", strings.Join(problems, "\n"))
+		return
+	}
+	slog.Info("Scheduler database integrity check passed")
+}
+
+// recoverInterruptedSends resolves messages left in the sending state by an
+// instance that stopped mid-batch. It must run with processMu held right after
+// this instance took the lease, when nothing else can be sending them.
+//
+//   - Claimed but never attempted (or not due yet): back to pending.
+//   - Last attempt finished successfully: marked sent.
+//   - Last attempt failed: marked failed with its error.
+//   - Last attempt never finished: marked failed, because WhatsApp may already
+//     have delivered it and resending could send it twice.
+func (ms *MessageScheduler) recoverInterruptedSends(ctx context.Context) (*RecoveryResult, error) {
+	stuck, err := ms.schedulerDB.GetAllScheduledMessages(ctx, MessageFilter{Status: "sending"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find interrupted sends: %w", err)
+	}
+
+	result := &RecoveryResult{}
+	now := time.Now()
+
+	for _, msg := range stuck {
+		attempts, err := ms.schedulerDB.GetSendAttempts(ctx, msg.ID)
+		if err != nil {
+			return result, fmt.Errorf("failed to read send attempts for %s: %w", msg.ID, err)
+		}
+
+		var last *SendAttempt
+		if len(attempts) > 0 {
+			last = attempts[len(attempts)-1]
+		}
+
+		switch {
+		case last == nil || msg.ScheduledTime.After(now):
+			err = ms.schedulerDB.ReleaseClaim(ctx, msg.ID)
+			result.Reset++
+
+		case last.FinishedAt != nil && last.Success:
+			err = ms.updateStatus(ctx, msg.ID, "sent", last.FinishedAt, nil)
+			result.Sent++
+
+		case last.FinishedAt != nil:
+			errMsg := "Send failed"
+			if last.Error != nil {
+				errMsg = *last.Error
+			}
+			err = ms.updateStatus(ctx, msg.ID, "failed", nil, &errMsg)
+			result.Failed++
+
+		default:
+			errMsg := "Interrupted while sending; it may or may not have been delivered"
+			if ferr := ms.schedulerDB.FinishSendAttempt(ctx, msg.ID, last.Attempt, now, false, &errMsg); ferr != nil {
+				slog.WarnContext(ctx, "Error closing interrupted attempt", "message_id", msg.ID, "error", ferr)
+			}
+			err = ms.updateStatus(ctx, msg.ID, "failed", nil, &errMsg)
+			result.Failed++
+		}
+
+		if err != nil {
+			return result, fmt.Errorf("failed to recover message %s: %w", msg.ID, err)
+		}
+	}
+
+	if len(stuck) > 0 {
+		slog.InfoContext(ctx, "Recovered interrupted sends",
+			"count", len(stuck), "pending", result.Reset, "sent", result.Sent, "failed", result.Failed)
+	}
+	return result, nil
+}
+
+// recoverAfterTakeover runs recoverInterruptedSends once each time this instance
+// becomes the leader. Callers must hold processMu and the lease.
+func (ms *MessageScheduler) recoverAfterTakeover(ctx context.Context) {
+	ms.leaseMu.Lock()
+	pending := ms.needsRecovery
+	ms.leaseMu.Unlock()
+	if !pending {
+		return
+	}
+
+	if _, err := ms.recoverInterruptedSends(ctx); err != nil {
+		// Try again on the next tick
+		slog.ErrorContext(ctx, "Error recovering interrupted sends", "error", err)
+		return
+	}
+
+	ms.leaseMu.Lock()
+	ms.needsRecovery = false
+	ms.leaseMu.Unlock()
+}
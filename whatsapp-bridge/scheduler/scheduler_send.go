@@ -0,0 +1,227 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	waE2E "go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+
+	"whatsapp-client/validate"
+)
+
+// MaxMentions is the most people one message can mention
+const MaxMentions = 256
+
+// MaxAudience is the most people a status update's audience can list
+const MaxAudience = 1024
+
+// MaxStatusLength is the longest text status WhatsApp shows, in characters
+const MaxStatusLength = 700
+
+// StatusBroadcast is the recipient of the account's status updates
+var StatusBroadcast = types.StatusBroadcastJID.String()
+
+// StatusFonts are the fonts a text status can be written in, by the name of
+// WhatsApp's own font type in lower case
+var StatusFonts = statusFonts()
+
+// statusColor is a status background color, like #1E88E5
+var statusColor = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+func statusFonts() []string {
+	values := make([]int32, 0, len(waE2E.ExtendedTextMessage_FontType_name))
+	for value := range waE2E.ExtendedTextMessage_FontType_name {
+		values = append(values, value)
+	}
+	slices.Sort(values)
+	fonts := make([]string, len(values))
+	for i, value := range values {
+		fonts[i] = strings.ToLower(waE2E.ExtendedTextMessage_FontType_name[value])
+	}
+	return fonts
+}
+
+// SendOptions changes how a message is sent
+type SendOptions struct {
+	// QuotedMessageID makes the message a reply to this message in the same chat
+	QuotedMessageID string `json:"quoted_message_id,omitempty"`
+	// Mentions are the JIDs of the group members the message mentions
+	Mentions []string `json:"mentions,omitempty"`
+	// LinkPreview shows the title, description and image of the first link in
+	// a text message, fetched when the message is sent
+	LinkPreview bool `json:"link_preview,omitempty"`
+	// ViewOnce lets the recipient open a photo or video only once
+	ViewOnce bool `json:"view_once,omitempty"`
+	// BackgroundColor and Font style a text status update
+	BackgroundColor string `json:"background_color,omitempty"`
+	Font            string `json:"font,omitempty"`
+	// Audience are the JIDs of the only people a status update may reach.
+	// Linked devices can't choose who sees a status, the phone's status privacy
+	// does, so a status it would show anyone else isn't sent.
+	Audience []string `json:"audience,omitempty"`
+}
+
+// IsBroadcastList reports whether recipient is a broadcast list, rather than
+// the status broadcast that status updates go to
+func IsBroadcastList(recipient string) bool {
+	return strings.HasSuffix(recipient, "@"+types.BroadcastServer) && recipient != types.StatusBroadcastJID.String()
+}
+
+// IsNewsletter reports whether recipient is a WhatsApp channel, where only its
+// owner and admins can post
+func IsNewsletter(recipient string) bool {
+	return strings.HasSuffix(recipient, "@"+types.NewsletterServer)
+}
+
+// IsStatus reports whether recipient is the account's status
+func IsStatus(recipient string) bool {
+	return recipient == StatusBroadcast
+}
+
+// Validate checks the options of a message to recipient, and normalizes each
+// mention and audience member to the JID WhatsApp expects
+func (opts *SendOptions) Validate(v *validate.Validator, recipient string) {
+	opts.validateStatus(v, recipient)
+	if opts.QuotedMessageID != "" && IsBroadcastList(recipient) {
+		v.Add("quoted_message_id", validate.CodeInvalidValue, "can't be set for broadcast lists, whose recipients each get the message in their own chat")
+	}
+	if IsNewsletter(recipient) {
+		if opts.QuotedMessageID != "" {
+			v.Add("quoted_message_id", validate.CodeInvalidValue, "can't be set for channels, whose posts can't be replies")
+		}
+		if opts.ViewOnce {
+			v.Add("view_once", validate.CodeInvalidValue, "must be false, channel posts can't be view once")
+		}
+	}
+	if len(opts.Mentions) == 0 {
+		return
+	}
+	if !strings.HasSuffix(recipient, "@"+types.GroupServer) {
+		v.Add("mentions", validate.CodeInvalidValue, "can only be set for group chats")
+		return
+	}
+	if len(opts.Mentions) > MaxMentions {
+		v.Add("mentions", validate.CodeTooLong, fmt.Sprintf("must have at most %d entries", MaxMentions))
+		return
+	}
+	for i, mention := range opts.Mentions {
+		opts.Mentions[i] = PersonJID(v, fmt.Sprintf("mentions.%d", i), mention)
+	}
+}
+
+// validateStatus checks the options only status updates take, and those they
+// can't take
+func (opts *SendOptions) validateStatus(v *validate.Validator, recipient string) {
+	if !IsStatus(recipient) {
+		for field, set := range map[string]bool{
+			"background_color": opts.BackgroundColor != "",
+			"font":             opts.Font != "",
+			"audience":         len(opts.Audience) > 0,
+		} {
+			if set {
+				v.Add(field, validate.CodeInvalidValue, "can only be set for status updates, sent to "+StatusBroadcast)
+			}
+		}
+		return
+	}
+
+	if opts.QuotedMessageID != "" {
+		v.Add("quoted_message_id", validate.CodeInvalidValue, "can't be set for status updates, which can't be replies")
+	}
+	if opts.ViewOnce {
+		v.Add("view_once", validate.CodeInvalidValue, "must be false, status updates can't be view once")
+	}
+	if opts.BackgroundColor != "" && !statusColor.MatchString(opts.BackgroundColor) {
+		v.Add("background_color", validate.CodeInvalidFormat, "must be a color like #1E88E5")
+	}
+	if opts.Font != "" {
+		v.OneOf("font", opts.Font, StatusFonts...)
+	}
+	if len(opts.Audience) > MaxAudience {
+		v.Add("audience", validate.CodeTooLong, fmt.Sprintf("must have at most %d entries", MaxAudience))
+		return
+	}
+	for i, member := range opts.Audience {
+		opts.Audience[i] = PersonJID(v, fmt.Sprintf("audience.%d", i), member)
+	}
+}
+
+// ValidateStatusKind fails media of kind, at field, for a status update,
+// which can only be a photo or video
+func ValidateStatusKind(v *validate.Validator, field, recipient, kind string) {
+	if IsStatus(recipient) && kind != MediaImage && kind != MediaVideo {
+		v.Add(field, validate.CodeInvalidValue, "must be image or video for status updates")
+	}
+}
+
+// PersonJID checks the phone number or JID of a person at field, and returns
+// it as the JID WhatsApp expects
+func PersonJID(v *validate.Validator, field, value string) string {
+	value = v.Recipient(field, value)
+	if v.Failed(field) {
+		return value
+	}
+	jid := types.NewJID(value, types.DefaultUserServer)
+	if strings.Contains(value, "@") {
+		jid, _ = types.ParseJID(value)
+	}
+	if jid.Server != types.DefaultUserServer && jid.Server != types.HiddenUserServer {
+		v.Add(field, validate.CodeInvalidValue, "must be a person, not a group or channel")
+		return value
+	}
+	return jid.String()
+}
+
+// ValidateNotText fails the options only text messages take, for a message
+// that isn't one. what names the kind of message in the error.
+func (opts SendOptions) ValidateNotText(v *validate.Validator, what string) {
+	if opts.LinkPreview {
+		v.Add("link_preview", validate.CodeInvalidValue, fmt.Sprintf("must be false, %s have no link preview", what))
+	}
+	if opts.BackgroundColor != "" {
+		v.Add("background_color", validate.CodeInvalidValue, fmt.Sprintf("must be empty, %s have no background", what))
+	}
+	if opts.Font != "" {
+		v.Add("font", validate.CodeInvalidValue, fmt.Sprintf("must be empty, %s have no font", what))
+	}
+}
+
+// ValidateViewOnce fails view_once on a message of kind, empty for text,
+// unless it is a photo or video
+func (opts SendOptions) ValidateViewOnce(v *validate.Validator, kind string) {
+	if opts.ViewOnce && kind != MediaImage && kind != MediaVideo {
+		v.Add("view_once", validate.CodeInvalidValue, "must be false, only images and videos can be sent view once")
+	}
+}
+
+// MentionText returns text with an @ tag for every mention it doesn't tag
+// already. WhatsApp shows a tag as the person's name only when the text has it.
+func (opts SendOptions) MentionText(text string) string {
+	for _, mention := range opts.Mentions {
+		tag := "@" + strings.SplitN(mention, "@", 2)[0]
+		if strings.Contains(text, tag) {
+			continue
+		}
+		if text != "" {
+			text += " "
+		}
+		text += tag
+	}
+	return text
+}
+
+// jidsValue is how a list of JIDs, such as mentions, is stored: as a JSON array
+func jidsValue(jids []string) interface{} {
+	if len(jids) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(jids)
+	if err != nil {
+		return nil
+	}
+	return string(data)
+}
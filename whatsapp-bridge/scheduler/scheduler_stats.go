@@ -0,0 +1,84 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// DailyStats holds the status counters for one day (UTC), either for a single
+// recipient or summed over all of them
+type DailyStats struct {
+	Date      string `json:"date"`
+	Recipient string `json:"recipient,omitempty"`
+	Sent      int    `json:"sent"`
+	Failed    int    `json:"failed"`
+	Paused    int    `json:"paused"`
+}
+
+// statsDay is the counter bucket for t
+func statsDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// incrementDailyStats bumps the counter for the recipient of message id if
+// status is one that is counted
+func (sdb *SchedulerDB) incrementDailyStats(ctx context.Context, tx *sql.Tx, id string, status string, at time.Time) error {
+	var sent, failed, paused int
+	switch status {
+	case "sent":
+		sent = 1
+	case "failed":
+		failed = 1
+	case "paused":
+		paused = 1
+	default:
+		return nil
+	}
+
+	_, err := sdb.txExec(ctx, tx, `
+		INSERT INTO scheduler_daily_stats (day, recipient, sent_count, failed_count, paused_count)
+		SELECT ?, recipient, ?, ?, ? FROM scheduled_messages WHERE id = ?
+		ON CONFLICT (day, recipient) DO UPDATE
+		SET sent_count = scheduler_daily_stats.sent_count + excluded.sent_count,
+		    failed_count = scheduler_daily_stats.failed_count + excluded.failed_count,
+		    paused_count = scheduler_daily_stats.paused_count + excluded.paused_count
+	`, statsDay(at), sent, failed, paused, id)
+	return err
+}
+
+// GetDailyStats returns the counters for every day since the given time, newest
+// first. With a recipient only that recipient is counted; otherwise each day is
+// summed over all recipients.
+func (sdb *SchedulerDB) GetDailyStats(ctx context.Context, since time.Time, recipient string) ([]*DailyStats, error) {
+	query := `
+		SELECT day, SUM(sent_count), SUM(failed_count), SUM(paused_count)
+		FROM scheduler_daily_stats
+		WHERE day >= ?
+	`
+	args := []interface{}{statsDay(since)}
+
+	if recipient != "" {
+		query += " AND recipient = ?"
+		args = append(args, recipient)
+	}
+
+	query += " GROUP BY day ORDER BY day DESC"
+
+	rows, err := sdb.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []*DailyStats{}
+	for rows.Next() {
+		day := &DailyStats{Recipient: recipient}
+		if err := rows.Scan(&day.Date, &day.Sent, &day.Failed, &day.Paused); err != nil {
+			return nil, err
+		}
+		stats = append(stats, day)
+	}
+
+	return stats, rows.Err()
+}
@@ -0,0 +1,66 @@
+package scheduler
+
+import (
+	"context"
+	"log/slog"
+	"time"
+	"unicode/utf8"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Bounds and pace of the typing shown before a scheduled message with Typing
+// set. Messages are sent one at a time, so long pauses hold up the rest.
+const (
+	MinTypingDelay = time.Second
+	MaxTypingDelay = 10 * time.Second
+	// typingCharsPerSecond is how fast the account seems to type
+	typingCharsPerSecond = 10
+)
+
+// TypingDelay is how long the account shows as typing before sending message:
+// as long as a quick typist takes to write it, within MinTypingDelay and
+// MaxTypingDelay
+func TypingDelay(message string) time.Duration {
+	delay := MinTypingDelay + time.Duration(utf8.RuneCountInString(message))*time.Second/typingCharsPerSecond
+	return min(delay, MaxTypingDelay)
+}
+
+// ShowTyping shows the account typing in a chat, or recording for a voice
+// note, for as long as TypingDelay says for message, and returns early when ctx
+// is cancelled. The message sent next ends the indicator.
+func ShowTyping(ctx context.Context, client *whatsmeow.Client, chat types.JID, message string, voice bool) error {
+	media := types.ChatPresenceMediaText
+	if voice {
+		media = types.ChatPresenceMediaAudio
+	}
+	if err := client.SendChatPresence(chat, types.ChatPresenceComposing, media); err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(TypingDelay(message))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+	return nil
+}
+
+// showTyping shows the account typing before a scheduled message. Typing is a
+// nicety, so failing to show it doesn't stop the send. Broadcast lists,
+// channels and status updates have no chat to type in.
+func (ms *MessageScheduler) showTyping(ctx context.Context, msg *ScheduledMessage) {
+	if ms.client == nil || !ms.client.IsConnected() || IsBroadcastList(msg.Recipient) || IsNewsletter(msg.Recipient) || IsStatus(msg.Recipient) {
+		return
+	}
+	jid, err := types.ParseJID(msg.Recipient)
+	if err != nil {
+		return
+	}
+	voice := msg.Media != nil && msg.Media.Kind == MediaVoice
+	if err := ShowTyping(ctx, ms.client, jid, msg.Message, voice); err != nil {
+		slog.WarnContext(ctx, "Error showing typing", "message_id", msg.ID, "recipient", msg.Recipient, "error", err)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/pagination"
+	"whatsapp-client/validate"
+)
+
+// maxSearchQueryLength bounds the text searched for, in characters
+const maxSearchQueryLength = 512
+
+// ftsTriggers keep messages_fts in step with messages. A message stored again
+// with INSERT OR REPLACE doesn't fire delete triggers, so its old text is
+// dropped before the insert instead.
+var ftsTriggers = map[string]string{
+	"messages_fts_before_insert": `CREATE TRIGGER messages_fts_before_insert BEFORE INSERT ON messages BEGIN
+		DELETE FROM messages_fts WHERE rowid = (SELECT rowid FROM messages WHERE id = new.id AND chat_jid = new.chat_jid);
+	END`,
+	"messages_fts_after_insert": `CREATE TRIGGER messages_fts_after_insert AFTER INSERT ON messages WHEN new.content != '' BEGIN
+		INSERT INTO messages_fts (rowid, content) VALUES (new.rowid, new.content);
+	END`,
+	"messages_fts_after_update": `CREATE TRIGGER messages_fts_after_update AFTER UPDATE OF content ON messages BEGIN
+		DELETE FROM messages_fts WHERE rowid = old.rowid;
+		INSERT INTO messages_fts (rowid, content) SELECT new.rowid, new.content WHERE new.content != '';
+	END`,
+	"messages_fts_after_delete": `CREATE TRIGGER messages_fts_after_delete AFTER DELETE ON messages BEGIN
+		DELETE FROM messages_fts WHERE rowid = old.rowid;
+	END`,
+}
+
+// setupMessageSearch creates the full-text index of message text and the
+// triggers that maintain it, and indexes the messages stored while it was
+// missing. SQLite builds without FTS5 get no index: the triggers are dropped
+// so storing messages still works, and false is returned.
+func setupMessageSearch(db *sql.DB) (bool, error) {
+	_, err := db.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(content, tokenize = 'unicode61 remove_diacritics 2')")
+	if err != nil && strings.Contains(err.Error(), "no such module: fts5") {
+		for name := range ftsTriggers {
+			if _, err := db.Exec("DROP TRIGGER IF EXISTS " + name); err != nil {
+				return false, err
+			}
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// Without the triggers, messages were stored that the index is missing
+	var triggers int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'messages_fts_%'").Scan(&triggers); err != nil {
+		return false, err
+	}
+	if triggers == len(ftsTriggers) {
+		return true, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	for name, create := range ftsTriggers {
+		if _, err := tx.Exec("DROP TRIGGER IF EXISTS " + name); err != nil {
+			return false, err
+		}
+		if _, err := tx.Exec(create); err != nil {
+			return false, err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM messages_fts"); err != nil {
+		return false, err
+	}
+	result, err := tx.Exec("INSERT INTO messages_fts (rowid, content) SELECT rowid, content FROM messages WHERE content != ''")
+	if err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	indexed, _ := result.RowsAffected()
+	slog.Info("Indexed messages for search", "messages", indexed)
+	return true, nil
+}
+
+// ftsQuery turns what someone typed into an FTS5 query matching messages with
+// all of its words, or phrases in double quotes. A word ending in * matches
+// words starting with it. Nothing else in the text is FTS5 syntax.
+func ftsQuery(text string) string {
+	var terms []string
+	for i, part := range strings.Split(text, `"`) {
+		if i%2 == 1 {
+			// Inside quotes
+			if phrase := strings.TrimSpace(part); phrase != "" {
+				terms = append(terms, `"`+phrase+`"`)
+			}
+			continue
+		}
+		for _, word := range strings.Fields(part) {
+			prefix := strings.HasSuffix(word, "*")
+			word = strings.TrimRight(word, "*")
+			if word == "" {
+				continue
+			}
+			term := `"` + word + `"`
+			if prefix {
+				term += "*"
+			}
+			terms = append(terms, term)
+		}
+	}
+	return strings.Join(terms, " ")
+}
+
+// SearchFilter narrows a message search
+type SearchFilter struct {
+	// ChatJID and Sender keep the messages of one chat, or from one sender
+	// (the user part of their JID)
+	ChatJID string
+	Sender  string
+	// Since and Until bound when the messages were sent, when not zero
+	Since time.Time
+	Until time.Time
+}
+
+// SearchResult is a message matching a search
+type SearchResult struct {
+	MessageID string    `json:"message_id"`
+	ChatJID   string    `json:"chat_jid"`
+	ChatName  string    `json:"chat_name,omitempty"`
+	Sender    string    `json:"sender"`
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"`
+	IsFromMe  bool      `json:"is_from_me"`
+	MediaType string    `json:"media_type,omitempty"`
+	// Snippet is the part of the text around the match, with the words that
+	// matched between [ and ]
+	Snippet string `json:"snippet"`
+	// Rank orders the results, best first; lower is better. It is 0 without
+	// the full-text index.
+	Rank float64 `json:"rank"`
+}
+
+// searchCursor is where the next page of search results starts
+type searchCursor struct {
+	Offset int `json:"o"`
+}
+
+// SearchMessages returns the messages whose text matches text, best first, or
+// latest first when recent is set. Without the full-text index, messages
+// containing text are returned latest first.
+func (store *MessageStore) SearchMessages(text string, filter SearchFilter, recent bool, offset, limit int) ([]SearchResult, error) {
+	var query string
+	var args []interface{}
+	if store.fts {
+		query = `SELECT m.id, m.chat_jid, c.name, m.sender, m.content, m.timestamp, m.is_from_me, m.media_type,
+			snippet(messages_fts, 0, '[', ']', '…', 16), bm25(messages_fts)
+			FROM messages_fts JOIN messages m ON m.rowid = messages_fts.rowid LEFT JOIN chats c ON c.jid = m.chat_jid
+			WHERE messages_fts MATCH ?`
+		args = append(args, ftsQuery(text))
+	} else {
+		query = `SELECT m.id, m.chat_jid, c.name, m.sender, m.content, m.timestamp, m.is_from_me, m.media_type, m.content, 0
+			FROM messages m LEFT JOIN chats c ON c.jid = m.chat_jid
+			WHERE m.content LIKE ? ESCAPE '\'`
+		args = append(args, "%"+likeEscaper.Replace(text)+"%")
+	}
+	if filter.ChatJID != "" {
+		query += " AND m.chat_jid = ?"
+		args = append(args, filter.ChatJID)
+	}
+	if filter.Sender != "" {
+		// History sync stores some senders as full JIDs
+		query += " AND (m.sender = ? OR m.sender LIKE ? || '@%')"
+		args = append(args, filter.Sender, filter.Sender)
+	}
+	// Compared as times, as the stored ones have the offset they came in
+	if !filter.Since.IsZero() {
+		query += " AND julianday(m.timestamp) >= julianday(?)"
+		args = append(args, filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query += " AND julianday(m.timestamp) < julianday(?)"
+		args = append(args, filter.Until)
+	}
+	if store.fts && !recent {
+		query += " ORDER BY bm25(messages_fts), m.timestamp DESC"
+	} else {
+		query += " ORDER BY m.timestamp DESC, m.rowid DESC"
+	}
+	query += " LIMIT ? OFFSET ?"
+	args = append(args, limit, offset)
+
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var result SearchResult
+		var chatName, mediaType sql.NullString
+		if err := rows.Scan(&result.MessageID, &result.ChatJID, &chatName, &result.Sender, &result.Content, &result.Timestamp,
+			&result.IsFromMe, &mediaType, &result.Snippet, &result.Rank); err != nil {
+			return nil, err
+		}
+		result.ChatName, result.MediaType = chatName.String, mediaType.String
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// registerSearchHandlers adds the message search endpoint
+func registerSearchHandlers(mux *http.ServeMux, store *MessageStore) {
+	// GET /v1/messages/search?q= - Stored messages whose text matches q, best
+	// match first, a page at a time. chat, sender, since and until narrow the
+	// search, and order=recent sorts the matches latest first.
+	mux.HandleFunc("GET /v1/messages/search", func(w http.ResponseWriter, r *http.Request) {
+		page, err := pagination.FromRequest(r)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+		query := r.URL.Query()
+		var v validate.Validator
+		text := strings.TrimSpace(query.Get("q"))
+		if v.Required("q", text) {
+			v.MaxLength("q", text, maxSearchQueryLength)
+			if store.fts && ftsQuery(text) == "" {
+				v.Add("q", validate.CodeInvalidValue, "must have a word to search for")
+			}
+		}
+		var filter SearchFilter
+		if chat := query.Get("chat"); chat != "" {
+			chat = v.Recipient("chat", chat)
+			if jid, err := parseRecipient(chat); err == nil && !v.Failed("chat") {
+				filter.ChatJID = jid.String()
+			}
+		}
+		if sender := query.Get("sender"); sender != "" {
+			// Messages store the sender's user part
+			filter.Sender, _, _ = strings.Cut(v.Recipient("sender", sender), "@")
+		}
+		if since := query.Get("since"); since != "" {
+			filter.Since = v.Time("since", since)
+		}
+		if until := query.Get("until"); until != "" {
+			filter.Until = v.Time("until", until)
+		}
+		order := query.Get("order")
+		v.OneOf("order", order, "rank", "recent")
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		var cursor searchCursor
+		if _, err := page.Decode(&cursor); err != nil || cursor.Offset < 0 {
+			apierror.BadRequest(w, "Invalid cursor")
+			return
+		}
+
+		results, err := store.SearchMessages(text, filter, order == "recent", cursor.Offset, page.Limit+1)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to search messages", "error", err)
+			apierror.Internal(w, "Failed to search messages")
+			return
+		}
+		offset := cursor.Offset
+		results, next := pagination.Page(results, page, func(SearchResult) interface{} {
+			return searchCursor{Offset: offset + page.Limit}
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":     true,
+			"messages":    results,
+			"next_cursor": next,
+		})
+	})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/scheduler"
+	"whatsapp-client/tracing"
+)
+
+// sendBuilt sends a message the caller has built, for message types with no
+// upload. kind names it in traces and in the result.
+func sendBuilt(ctx context.Context, client *whatsmeow.Client, recipient, kind string, msg *waProto.Message) (result scheduler.SendResult) {
+	ctx, span := tracing.Start(ctx, "whatsapp.send",
+		tracing.String("recipient", recipient),
+		tracing.String("message.kind", kind),
+	)
+	defer func() {
+		if result.Success {
+			span.SetAttributes(tracing.String("whatsapp.message_id", result.MessageID))
+		} else {
+			span.RecordError(errors.New(result.Message))
+		}
+		span.End()
+	}()
+
+	if !client.IsConnected() {
+		return scheduler.SendResult{Message: "Not connected to WhatsApp"}
+	}
+	recipientJID, err := parseRecipient(recipient)
+	if err != nil {
+		return scheduler.SendResult{Message: err.Error()}
+	}
+
+	sent, err := client.SendMessage(ctx, recipientJID, msg)
+	if err != nil {
+		return scheduler.SendResult{Message: fmt.Sprintf("Error sending message: %v", err)}
+	}
+	return scheduler.SendResult{
+		Success:   true,
+		Message:   fmt.Sprintf("Sent %s to %s", kind, recipient),
+		MessageID: sent.ID,
+		ChatJID:   recipientJID.String(),
+		Timestamp: sent.Timestamp,
+	}
+}
+
+// messageSender returns who sent a stored message, as message keys name it.
+// The store only keeps the sender's user, so in groups it is assumed to be a
+// phone number. An empty JID means the bridge's own account.
+func messageSender(chat types.JID, msg *Message) types.JID {
+	switch {
+	case msg.IsFromMe:
+		return types.EmptyJID
+	case chat.Server == types.GroupServer || chat.Server == types.BroadcastServer:
+		return types.NewJID(msg.Sender, types.DefaultUserServer)
+	default:
+		return chat
+	}
+}
+
+// targetSender works out who sent message id in chat, for acting on it: the
+// sender the request gave, else the one in the message store. what says what
+// the request does to the message, for the error when neither is known. When
+// it returns false, the error response has been written.
+func targetSender(w http.ResponseWriter, r *http.Request, store *MessageStore, chat types.JID, id, sender, what string) (types.JID, bool) {
+	if sender != "" {
+		jid, err := parseRecipient(sender)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return types.EmptyJID, false
+		}
+		return jid, true
+	}
+	msg, err := store.GetMessage(id, chat.String())
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.NotFound(w, fmt.Sprintf("Message not found, pass its sender to %s a message the bridge hasn't stored", what))
+		return types.EmptyJID, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to read message", "error", err)
+		apierror.Internal(w, "Failed to read message")
+		return types.EmptyJID, false
+	}
+	return messageSender(chat, msg), true
+}
+
+// storeSent records a message the bridge sent, as WhatsApp doesn't echo those
+// back, so it can be quoted and edited like the ones received. Messages with
+// nothing to store, such as reactions, are skipped.
+func storeSent(ctx context.Context, client *whatsmeow.Client, store *MessageStore, result scheduler.SendResult, msg *waProto.Message) {
+	if !result.Success || client.Store.ID == nil {
+		return
+	}
+	msg, viewOnce := unwrapViewOnce(msg)
+	content := extractTextContent(msg)
+	mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength := extractMediaInfo(msg)
+	if content == "" && mediaType == "" {
+		return
+	}
+	chat, err := types.ParseJID(result.ChatJID)
+	if err != nil {
+		return
+	}
+
+	name := GetChatName(ctx, client, store, chat, result.ChatJID, nil, "", waLog.Noop)
+	if err := store.StoreChat(result.ChatJID, name, result.Timestamp); err != nil {
+		slog.WarnContext(ctx, "Failed to store chat", "chat_jid", result.ChatJID, "error", err)
+		return
+	}
+	err = store.StoreMessage(result.MessageID, result.ChatJID, client.Store.ID.User, content, result.Timestamp, true,
+		mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to store sent message", "message_id", result.MessageID, "chat_jid", result.ChatJID, "error", err)
+		return
+	}
+	storeMessageContacts(store, result.MessageID, result.ChatJID, msg)
+	if viewOnce {
+		if err := store.StoreViewOnce(result.MessageID, result.ChatJID); err != nil {
+			slog.WarnContext(ctx, "Failed to flag view-once message", "message_id", result.MessageID, "error", err)
+		}
+	}
+}
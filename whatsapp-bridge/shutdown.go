@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+
+	"whatsapp-client/jobs"
+	"whatsapp-client/scheduler"
+	"whatsapp-client/webhook"
+)
+
+// shutdown stops the bridge in dependency order. The HTTP and gRPC servers go
+// first so no new work arrives while in-flight requests get up to timeout to
+// finish, and the jobs they started get what is left of it.
+// The scheduler then finishes the message it is sending, and only after that
+// is the WhatsApp connection both of them send through closed. Webhook events
+// received up to then are written to the queue, which is picked up again on
+// the next start.
+// The databases are left to the caller, which closes them once nothing uses them.
+// server is nil when it failed to start.
+func shutdown(server *http.Server, stopGRPC func(context.Context), jobManager *jobs.Manager, msgScheduler *scheduler.MessageScheduler, client *whatsmeow.Client, webhooks *webhook.Dispatcher, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if server != nil {
+		slog.Info("Stopping REST API server", "timeout", timeout)
+		if err := server.Shutdown(ctx); err != nil {
+			// Requests still running past the timeout are cut off
+			slog.Warn("REST API server did not finish in-flight requests in time", "error", err)
+			server.Close()
+		}
+	}
+	stopGRPC(ctx)
+
+	slog.Info("Waiting for running jobs")
+	jobManager.Stop(ctx)
+
+	msgScheduler.Stop()
+
+	slog.Info("Disconnecting from WhatsApp")
+	client.Disconnect()
+
+	slog.Info("Stopping webhook deliveries")
+	webhooks.Stop(ctx)
+}
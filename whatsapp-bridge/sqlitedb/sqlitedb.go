@@ -0,0 +1,136 @@
+// Package sqlitedb opens the bridge's SQLite databases with consistent
+// connection settings.
+package sqlitedb
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Options controls how SQLite connections are opened and pooled
+type Options struct {
+	// JournalMode is the SQLite journal mode. WAL lets the scheduler read while
+	// the message handler writes.
+	JournalMode string
+	// BusyTimeout is how long a connection waits for a lock before failing with
+	// "database is locked"
+	BusyTimeout time.Duration
+	// ForeignKeys enables foreign key enforcement
+	ForeignKeys bool
+	// Synchronous is the SQLite synchronous setting. NORMAL is safe with WAL.
+	Synchronous string
+	// MaxOpenConns and MaxIdleConns size the connection pool (0 means unlimited)
+	MaxOpenConns int
+	MaxIdleConns int
+}
+
+// DefaultOptions returns the settings used when nothing is configured
+func DefaultOptions() Options {
+	return Options{
+		JournalMode:  "WAL",
+		BusyTimeout:  5 * time.Second,
+		ForeignKeys:  true,
+		Synchronous:  "NORMAL",
+		MaxOpenConns: 4,
+		MaxIdleConns: 4,
+	}
+}
+
+// OptionsFromEnv returns DefaultOptions overridden by SQLITE_JOURNAL_MODE,
+// SQLITE_BUSY_TIMEOUT_MS, SQLITE_FOREIGN_KEYS, SQLITE_SYNCHRONOUS,
+// SQLITE_MAX_OPEN_CONNS and SQLITE_MAX_IDLE_CONNS
+func OptionsFromEnv() (Options, error) {
+	opts := DefaultOptions()
+
+	if v := os.Getenv("SQLITE_JOURNAL_MODE"); v != "" {
+		opts.JournalMode = strings.ToUpper(v)
+	}
+	if v := os.Getenv("SQLITE_SYNCHRONOUS"); v != "" {
+		opts.Synchronous = strings.ToUpper(v)
+	}
+	if v := os.Getenv("SQLITE_BUSY_TIMEOUT_MS"); v != "" {
+		ms, err := strconv.Atoi(v)
+		if err != nil || ms < 0 {
+			return opts, fmt.Errorf("invalid SQLITE_BUSY_TIMEOUT_MS %q", v)
+		}
+		opts.BusyTimeout = time.Duration(ms) * time.Millisecond
+	}
+	if v := os.Getenv("SQLITE_FOREIGN_KEYS"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid SQLITE_FOREIGN_KEYS %q", v)
+		}
+		opts.ForeignKeys = enabled
+	}
+	if v := os.Getenv("SQLITE_MAX_OPEN_CONNS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return opts, fmt.Errorf("invalid SQLITE_MAX_OPEN_CONNS %q", v)
+		}
+		opts.MaxOpenConns = n
+	}
+	if v := os.Getenv("SQLITE_MAX_IDLE_CONNS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return opts, fmt.Errorf("invalid SQLITE_MAX_IDLE_CONNS %q", v)
+		}
+		opts.MaxIdleConns = n
+	}
+
+	return opts, nil
+}
+
+// DSN builds a go-sqlite3 connection string for path with the given options
+func DSN(path string, opts Options) string {
+	params := url.Values{}
+	if opts.JournalMode != "" {
+		params.Set("_journal_mode", opts.JournalMode)
+	}
+	if opts.BusyTimeout > 0 {
+		params.Set("_busy_timeout", strconv.FormatInt(opts.BusyTimeout.Milliseconds(), 10))
+	}
+	if opts.ForeignKeys {
+		params.Set("_foreign_keys", "on")
+	} else {
+		params.Set("_foreign_keys", "off")
+	}
+	if opts.Synchronous != "" {
+		params.Set("_synchronous", opts.Synchronous)
+	}
+
+	dsn := path
+	if !strings.HasPrefix(dsn, "file:") {
+		dsn = "file:" + dsn
+	}
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+	return dsn + separator + params.Encode()
+}
+
+// Open opens the SQLite database at path and applies the pool settings
+func Open(path string, opts Options) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", DSN(path, opts))
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(opts.MaxOpenConns)
+	db.SetMaxIdleConns(opts.MaxIdleConns)
+
+	// sql.Open is lazy; make sure the file can actually be opened with these settings
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/appstate"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/audit"
+	"whatsapp-client/pagination"
+	"whatsapp-client/validate"
+)
+
+// StarRequest is the optional body of POST and DELETE
+// /v1/messages/{chat}/{id}/star
+type StarRequest struct {
+	// Sender is who sent the message, for one the bridge hasn't stored
+	Sender string `json:"sender,omitempty"`
+}
+
+// StarredMessage is a message the account starred
+type StarredMessage struct {
+	MessageID string `json:"message_id"`
+	ChatJID   string `json:"chat_jid"`
+	// Sender, Content, MediaType and Timestamp are empty for a message the
+	// bridge hasn't stored
+	Sender    string     `json:"sender,omitempty"`
+	Content   string     `json:"content"`
+	MediaType string     `json:"media_type,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	IsFromMe  bool       `json:"is_from_me"`
+	StarredAt time.Time  `json:"starred_at"`
+}
+
+// starredCursor is the sort key of the last message of a starred page
+type starredCursor struct {
+	StarredAt time.Time `json:"t"`
+	ChatJID   string    `json:"c"`
+	MessageID string    `json:"id"`
+}
+
+// StoreStar records that a message was starred at starredAt, or unstarred
+// when starred is false. A change older than the one stored is ignored.
+func (store *MessageStore) StoreStar(messageID, chatJID string, starred bool, at time.Time) error {
+	if !starred {
+		_, err := store.db.Exec(
+			"DELETE FROM starred_messages WHERE message_id = ? AND chat_jid = ? AND starred_at <= ?",
+			messageID, chatJID, at,
+		)
+		return err
+	}
+	_, err := store.db.Exec(
+		`INSERT INTO starred_messages (message_id, chat_jid, starred_at) VALUES (?, ?, ?)
+		ON CONFLICT (message_id, chat_jid) DO UPDATE SET starred_at = excluded.starred_at
+		WHERE excluded.starred_at >= starred_messages.starred_at`,
+		messageID, chatJID, at,
+	)
+	return err
+}
+
+// GetStarredMessages returns starred messages, latest starred first, from one
+// chat or from all when chatJID is empty
+func (store *MessageStore) GetStarredMessages(chatJID string, after *starredCursor, limit int) ([]StarredMessage, error) {
+	query := `SELECT s.message_id, s.chat_jid, m.sender, m.content, m.media_type, m.timestamp, m.is_from_me, s.starred_at
+		FROM starred_messages s LEFT JOIN messages m ON m.id = s.message_id AND m.chat_jid = s.chat_jid
+		WHERE 1 = 1`
+	var args []interface{}
+	if chatJID != "" {
+		query += " AND s.chat_jid = ?"
+		args = append(args, chatJID)
+	}
+	if after != nil {
+		query += ` AND (s.starred_at < ? OR (s.starred_at = ? AND (s.chat_jid < ? OR (s.chat_jid = ? AND s.message_id < ?))))`
+		args = append(args, after.StarredAt, after.StarredAt, after.ChatJID, after.ChatJID, after.MessageID)
+	}
+	query += " ORDER BY s.starred_at DESC, s.chat_jid DESC, s.message_id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	starred := []StarredMessage{}
+	for rows.Next() {
+		var msg StarredMessage
+		var sender, content, mediaType sql.NullString
+		var timestamp sql.NullTime
+		var isFromMe sql.NullBool
+		if err := rows.Scan(&msg.MessageID, &msg.ChatJID, &sender, &content, &mediaType, &timestamp, &isFromMe, &msg.StarredAt); err != nil {
+			return nil, err
+		}
+		msg.Sender, msg.Content, msg.MediaType, msg.IsFromMe = sender.String, content.String, mediaType.String, isFromMe.Bool
+		if timestamp.Valid {
+			msg.Timestamp = &timestamp.Time
+		}
+		starred = append(starred, msg)
+	}
+	return starred, rows.Err()
+}
+
+// handleStar stores a star or unstar the account made on another device, or
+// one made through the bridge as WhatsApp syncs it back
+func handleStar(store *MessageStore, star *events.Star) {
+	chatJID := star.ChatJID.String()
+	if err := store.StoreStar(star.MessageID, chatJID, star.Action.GetStarred(), star.Timestamp); err != nil {
+		slog.Warn("Failed to store star", "message_id", star.MessageID, "chat_jid", chatJID, "error", err)
+	}
+}
+
+// registerStarHandlers adds the endpoints for starring messages and listing
+// the starred ones
+func registerStarHandlers(mux *http.ServeMux, client *whatsmeow.Client, store *MessageStore) {
+	// star serves POST, which stars a message, and DELETE, which unstars it
+	star := func(w http.ResponseWriter, r *http.Request) {
+		var req StarRequest
+		var v validate.Validator
+		chat := v.Recipient("chat", r.PathValue("chat"))
+		starred := r.Method == http.MethodPost
+		// The body is optional
+		if r.ContentLength != 0 {
+			v.Decode(r, &req)
+		}
+		if req.Sender != "" {
+			req.Sender = v.Recipient("sender", req.Sender)
+		}
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		chatJID, err := parseRecipient(chat)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+		messageID := r.PathValue("id")
+		what := "star"
+		if !starred {
+			what = "unstar"
+		}
+		sender, ok := targetSender(w, r, store, chatJID, messageID, req.Sender, what)
+		if !ok {
+			return
+		}
+
+		if !client.IsConnected() {
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not connected to WhatsApp")
+			return
+		}
+
+		// Stars are app state, which every device of the account syncs
+		fromMe := sender.IsEmpty() || (client.Store.ID != nil && sender.User == client.Store.ID.User)
+		if fromMe {
+			sender = chatJID
+		}
+		at := time.Now()
+		if err := client.SendAppState(r.Context(), appstate.BuildStar(chatJID, sender, messageID, fromMe, starred)); err != nil {
+			slog.WarnContext(r.Context(), "Failed to star message", "message_id", messageID, "chat_jid", chatJID, "starred", starred, "error", err)
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, "Failed to "+what+" message: "+err.Error())
+			return
+		}
+		slog.InfoContext(r.Context(), "Starred message", "message_id", messageID, "chat_jid", chatJID, "starred", starred)
+		audit.SetResource(r.Context(), messageID)
+
+		if err := store.StoreStar(messageID, chatJID.String(), starred, at); err != nil {
+			slog.WarnContext(r.Context(), "Failed to store star", "message_id", messageID, "error", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    true,
+			"message_id": messageID,
+			"chat_jid":   chatJID.String(),
+			"starred":    starred,
+		})
+	}
+
+	// POST /v1/messages/{chat}/{id}/star - Star a message on all the
+	// account's devices
+	mux.HandleFunc("POST /v1/messages/{chat}/{id}/star", star)
+	// DELETE /v1/messages/{chat}/{id}/star - Unstar a message
+	mux.HandleFunc("DELETE /v1/messages/{chat}/{id}/star", star)
+
+	// GET /v1/messages/starred - Starred messages, latest starred first, a
+	// page at a time. ?chat= keeps those of one chat.
+	mux.HandleFunc("GET /v1/messages/starred", func(w http.ResponseWriter, r *http.Request) {
+		page, err := pagination.FromRequest(r)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+		var v validate.Validator
+		var chatJID string
+		if chat := r.URL.Query().Get("chat"); chat != "" {
+			chat = v.Recipient("chat", chat)
+			if jid, err := parseRecipient(chat); err == nil && !v.Failed("chat") {
+				chatJID = jid.String()
+			}
+		}
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		var cursor *starredCursor
+		var decoded starredCursor
+		if ok, err := page.Decode(&decoded); err != nil {
+			apierror.BadRequest(w, "Invalid cursor")
+			return
+		} else if ok {
+			cursor = &decoded
+		}
+
+		starred, err := store.GetStarredMessages(chatJID, cursor, page.Limit+1)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to get starred messages", "error", err)
+			apierror.Internal(w, "Failed to get starred messages")
+			return
+		}
+		starred, next := pagination.Page(starred, page, func(msg StarredMessage) interface{} {
+			return starredCursor{StarredAt: msg.StarredAt, ChatJID: msg.ChatJID, MessageID: msg.MessageID}
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":     true,
+			"messages":    starred,
+			"next_cursor": next,
+		})
+	})
+}
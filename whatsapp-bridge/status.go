@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	waE2E "go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/audit"
+	"whatsapp-client/jobs"
+	"whatsapp-client/pagination"
+	"whatsapp-client/scheduler"
+	"whatsapp-client/validate"
+)
+
+// defaultStatusBackground is the background of a text status that doesn't
+// choose one
+const defaultStatusBackground = "#075E54"
+
+// statusTextColor is the color of the text of a text status, as ARGB
+const statusTextColor = 0xFFFFFFFF
+
+// statusLifetime is how long WhatsApp shows a status update, and how far back
+// the feed goes by default
+const statusLifetime = 24 * time.Hour
+
+// StatusUpdate is a status update a contact posted
+type StatusUpdate struct {
+	ID     string `json:"id"`
+	Sender string `json:"sender"`
+	// SenderName is the contact's name in the address book, if they're in it
+	SenderName string    `json:"sender_name,omitempty"`
+	Content    string    `json:"content"`
+	MediaType  string    `json:"media_type,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// statusFeedCursor is the sort key of the last status update of a feed page
+type statusFeedCursor struct {
+	Timestamp time.Time `json:"t"`
+	ID        string    `json:"id"`
+}
+
+// statusFeedFilter picks the status updates GetStatusUpdates returns
+type statusFeedFilter struct {
+	Since time.Time
+	// Sender is the user part of the poster's JID, empty for everyone
+	Sender string
+	After  *statusFeedCursor
+	Limit  int
+}
+
+// PostStatusRequest is the body of POST /v1/status
+type PostStatusRequest struct {
+	// Message is the text of a text status, or the caption of a photo or video
+	Message string `json:"message,omitempty"`
+	// Media makes the status a photo or video
+	Media *scheduler.Media `json:"media,omitempty"`
+	scheduler.SendOptions
+}
+
+// validate checks every field of the request and normalizes the audience
+func (req *PostStatusRequest) validate(v *validate.Validator) {
+	req.SendOptions.Validate(v, scheduler.StatusBroadcast)
+	if req.Media != nil {
+		req.Media.Validate(v, "media")
+		scheduler.ValidateStatusKind(v, "media.kind", scheduler.StatusBroadcast, req.Media.Kind)
+		req.ValidateNotText(v, "photo and video statuses")
+		v.MaxLength("message", req.Message, validate.MaxMessageLength)
+	} else {
+		if req.Message == "" {
+			v.Add("message", validate.CodeRequired, "is required unless media is set")
+		}
+		v.MaxLength("message", req.Message, scheduler.MaxStatusLength)
+	}
+	req.Message = req.MentionText(req.Message)
+}
+
+// statusARGB is a #RRGGBB color as the opaque ARGB value WhatsApp expects
+func statusARGB(color string) uint32 {
+	rgb, _ := strconv.ParseUint(strings.TrimPrefix(color, "#"), 16, 32)
+	return 0xFF000000 | uint32(rgb)
+}
+
+// textStatusMessage is a text status in the style opts chooses
+func textStatusMessage(ctx context.Context, text string, opts scheduler.SendOptions) *waProto.Message {
+	background := opts.BackgroundColor
+	if background == "" {
+		background = defaultStatusBackground
+	}
+	msg := &waProto.Message{ExtendedTextMessage: &waProto.ExtendedTextMessage{
+		Text:           proto.String(text),
+		BackgroundArgb: proto.Uint32(statusARGB(background)),
+		TextArgb:       proto.Uint32(statusTextColor),
+	}}
+	if opts.Font != "" {
+		font := waE2E.ExtendedTextMessage_FontType(waE2E.ExtendedTextMessage_FontType_value[strings.ToUpper(opts.Font)])
+		msg.ExtendedTextMessage.Font = font.Enum()
+	}
+	if opts.LinkPreview {
+		addLinkPreview(ctx, msg)
+	}
+	return msg
+}
+
+// GetStatusUpdates returns the stored status updates of contacts, newest
+// first. The account's own are left out.
+func (store *MessageStore) GetStatusUpdates(filter statusFeedFilter) ([]StatusUpdate, error) {
+	query := "SELECT id, sender, content, media_type, timestamp FROM messages WHERE chat_jid = ? AND NOT is_from_me AND timestamp >= ?"
+	args := []interface{}{scheduler.StatusBroadcast, filter.Since}
+	if filter.Sender != "" {
+		query += " AND sender = ?"
+		args = append(args, filter.Sender)
+	}
+	if filter.After != nil {
+		query += " AND (timestamp < ? OR (timestamp = ? AND id < ?))"
+		args = append(args, filter.After.Timestamp, filter.After.Timestamp, filter.After.ID)
+	}
+	query += " ORDER BY timestamp DESC, id DESC LIMIT ?"
+	args = append(args, filter.Limit)
+
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	updates := []StatusUpdate{}
+	for rows.Next() {
+		var update StatusUpdate
+		var mediaType sql.NullString
+		if err := rows.Scan(&update.ID, &update.Sender, &update.Content, &mediaType, &update.Timestamp); err != nil {
+			return nil, err
+		}
+		update.MediaType = mediaType.String
+		updates = append(updates, update)
+	}
+	return updates, rows.Err()
+}
+
+// contactName is the address book name of the person with the user part
+// sender, or "" when they aren't in it
+func contactName(ctx context.Context, client *whatsmeow.Client, sender string) string {
+	for _, server := range []string{types.DefaultUserServer, types.HiddenUserServer} {
+		contact, err := client.Store.Contacts.GetContact(ctx, types.NewJID(sender, server))
+		if err == nil && contact.Found && contact.FullName != "" {
+			return contact.FullName
+		}
+	}
+	return ""
+}
+
+// checkStatusAudience refuses a status update that would reach anyone outside
+// its audience. A linked device can't pick who sees a status: WhatsApp shows
+// it to whoever the phone's status privacy allows. No audience allows anyone.
+func checkStatusAudience(ctx context.Context, client *whatsmeow.Client, audience []string) error {
+	if len(audience) == 0 {
+		return nil
+	}
+	allowed := make(map[string]bool, len(audience))
+	for _, member := range audience {
+		allowed[member] = true
+	}
+
+	recipients, err := client.DangerousInternals().GetStatusBroadcastRecipients(ctx)
+	if err != nil {
+		return fmt.Errorf("Error getting who sees status updates: %v", err)
+	}
+	outside := 0
+	for _, jid := range recipients {
+		jid = jid.ToNonAD()
+		if allowed[jid.String()] {
+			continue
+		}
+		// Status privacy may list a person by LID and the audience by phone
+		// number, or the other way round
+		var other types.JID
+		if jid.Server == types.HiddenUserServer {
+			other, _ = client.Store.LIDs.GetPNForLID(ctx, jid)
+		} else {
+			other, _ = client.Store.LIDs.GetLIDForPN(ctx, jid)
+		}
+		if !other.IsEmpty() && allowed[other.String()] {
+			continue
+		}
+		outside++
+	}
+	if outside > 0 {
+		return fmt.Errorf("Status privacy would show this status to %d people outside the audience; set it to share only with the audience on the phone", outside)
+	}
+	return nil
+}
+
+// registerStatusHandlers adds the endpoints for posting status updates and
+// reading those of contacts. Status updates can also be sent and scheduled
+// like any message, to status@broadcast.
+func registerStatusHandlers(mux *http.ServeMux, client *whatsmeow.Client, store *MessageStore, jobManager *jobs.Manager) {
+	// GET /v1/status/feed - The status updates contacts posted, newest first,
+	// a page at a time. Only those received while the bridge runs are stored.
+	mux.HandleFunc("GET /v1/status/feed", func(w http.ResponseWriter, r *http.Request) {
+		page, err := pagination.FromRequest(r)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+		query := r.URL.Query()
+		var v validate.Validator
+		filter := statusFeedFilter{Since: time.Now().Add(-statusLifetime), Limit: page.Limit + 1}
+		if since := query.Get("since"); since != "" {
+			filter.Since = v.Time("since", since)
+		}
+		if sender := query.Get("sender"); sender != "" {
+			sender = v.Recipient("sender", sender)
+			if jid, err := parseRecipient(sender); err == nil && !v.Failed("sender") {
+				filter.Sender = jid.User
+			}
+		}
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		var cursor statusFeedCursor
+		if ok, err := page.Decode(&cursor); err != nil {
+			apierror.BadRequest(w, "Invalid cursor")
+			return
+		} else if ok {
+			filter.After = &cursor
+		}
+
+		updates, err := store.GetStatusUpdates(filter)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to get status updates", "error", err)
+			apierror.Internal(w, "Failed to get status updates")
+			return
+		}
+		updates, next := pagination.Page(updates, page, func(update StatusUpdate) interface{} {
+			return statusFeedCursor{Timestamp: update.Timestamp, ID: update.ID}
+		})
+		for i := range updates {
+			updates[i].SenderName = contactName(r.Context(), client, updates[i].Sender)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":     true,
+			"updates":     updates,
+			"next_cursor": next,
+		})
+	})
+
+	// POST /v1/status - Post a text, photo or video status update. Like POST
+	// /v1/send it can run as a job, since large uploads take a while.
+	mux.HandleFunc("POST /v1/status", func(w http.ResponseWriter, r *http.Request) {
+		var req PostStatusRequest
+		var v validate.Validator
+		if v.Decode(r, &req) {
+			req.validate(&v)
+		}
+		if !v.Valid() {
+			v.Write(w)
+			return
+		}
+		var media *outgoingMedia
+		if req.Media != nil {
+			var err error
+			if media, err = loadScheduledMedia(r.Context(), *req.Media); err != nil {
+				apierror.BadRequest(w, err.Error())
+				return
+			}
+		}
+		contextInfo, ok := requestContext(w, r, store, client, scheduler.StatusBroadcast, req.SendOptions)
+		if !ok {
+			return
+		}
+
+		if !client.IsConnected() {
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.CodeWhatsAppUnavailable, "Not connected to WhatsApp")
+			return
+		}
+
+		post := func(ctx context.Context) scheduler.SendResult {
+			if media != nil {
+				return sendMedia(ctx, client, store, scheduler.StatusBroadcast, req.Message, req.Media.Kind, media, req.SendOptions, contextInfo)
+			}
+			return sendWhatsAppMessage(ctx, client, store, scheduler.StatusBroadcast, req.Message, "", req.SendOptions, contextInfo)
+		}
+
+		if jobs.Async(r) {
+			job, err := jobManager.Start(r.Context(), "post_status", scheduler.RequestCreator(r), func(ctx context.Context, _ func(int, int)) (interface{}, error) {
+				result := post(ctx)
+				if !result.Success {
+					slog.WarnContext(ctx, "Failed to post status", "status", "failed", "error", result.Message)
+					return nil, jobs.Fail(apierror.CodeWhatsAppError, result.Message)
+				}
+				slog.InfoContext(ctx, "Posted status", "whatsapp_message_id", result.MessageID, "status", "sent")
+				return map[string]string{"message_id": result.MessageID, "chat_jid": result.ChatJID}, nil
+			})
+			if err != nil {
+				jobs.StartError(w, err)
+				return
+			}
+			jobs.Accepted(w, r, job)
+			return
+		}
+
+		// A client hanging up must not abort a post that is under way
+		ctx := context.WithoutCancel(r.Context())
+		start := time.Now()
+		result := post(ctx)
+		latency := time.Since(start)
+		if !result.Success {
+			slog.WarnContext(ctx, "Failed to post status", "status", "failed", "latency", latency, "error", result.Message)
+			apierror.Write(w, http.StatusBadGateway, apierror.CodeWhatsAppError, result.Message)
+			return
+		}
+
+		slog.InfoContext(ctx, "Posted status", "whatsapp_message_id", result.MessageID, "status", "sent", "latency", latency)
+		audit.SetResource(r.Context(), result.MessageID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(SendMessageResponse{
+			Success:   true,
+			Message:   result.Message,
+			MessageID: result.MessageID,
+			ChatJID:   result.ChatJID,
+		})
+	})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sdNotify sends a state change like "READY=1" to systemd. It does nothing
+// unless the bridge runs as a Type=notify service, which sets NOTIFY_SOCKET.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// A leading @ names a socket in the abstract namespace
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval returns how often systemd expects a watchdog ping, or 0 when
+// WatchdogSec isn't set for this process
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// runWatchdog pings the systemd watchdog at half its interval until ctx ends,
+// but only while ready reports the bridge as ready. When the WhatsApp
+// connection or the scheduler wedges, the pings stop and systemd restarts the
+// service.
+func runWatchdog(ctx context.Context, interval time.Duration, ready func(ctx context.Context) ReadinessResponse) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	healthy := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		resp := ready(ctx)
+		if resp.Status != "ok" {
+			if healthy {
+				slog.Warn("Bridge not ready, holding back systemd watchdog pings", "components", resp.Components)
+			}
+			healthy = false
+			continue
+		}
+		if !healthy {
+			slog.Info("Bridge ready again, resuming systemd watchdog pings")
+		}
+		healthy = true
+		if err := sdNotify("WATCHDOG=1"); err != nil {
+			slog.Warn("Failed to ping systemd watchdog", "error", err)
+		}
+	}
+}
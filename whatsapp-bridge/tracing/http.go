@@ -0,0 +1,54 @@
+package tracing
+
+import (
+	"net/http"
+)
+
+// Middleware starts a server span for every request, continuing the caller's
+// trace when the request carries trace headers. The trace ID is returned in
+// the X-Trace-ID response header so clients can quote it when reporting
+// problems.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := tracer().Extract(r.Context(), r.Header)
+		ctx, span := Start(ctx, "HTTP "+r.Method,
+			String("http.request.method", r.Method),
+			String("url.path", r.URL.Path),
+		)
+		defer span.End()
+
+		if id := TraceID(ctx); id != "" {
+			w.Header().Set("X-Trace-ID", id)
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(Int("http.response.status_code", rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.RecordError(errStatus(rec.status))
+		}
+	})
+}
+
+// statusRecorder remembers the response status for the span
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+type errStatus int
+
+func (e errStatus) Error() string {
+	return "HTTP " + http.StatusText(int(e))
+}
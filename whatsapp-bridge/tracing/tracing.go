@@ -0,0 +1,91 @@
+// Package tracing is the bridge's small tracing facade. Code creates spans
+// through it without depending on a tracing library; by default spans are
+// no-ops. Building with the otel tag installs an OpenTelemetry tracer that
+// exports spans over OTLP.
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+)
+
+// Attr is a span attribute
+type Attr struct {
+	Key   string
+	Value interface{}
+}
+
+// String returns a string attribute
+func String(key, value string) Attr { return Attr{Key: key, Value: value} }
+
+// Int returns an integer attribute
+func Int(key string, value int) Attr { return Attr{Key: key, Value: value} }
+
+// Bool returns a boolean attribute
+func Bool(key string, value bool) Attr { return Attr{Key: key, Value: value} }
+
+// Span is an operation being traced
+type Span interface {
+	// SetAttributes adds attributes to the span
+	SetAttributes(attrs ...Attr)
+	// RecordError marks the span as failed. A nil error is ignored.
+	RecordError(err error)
+	// End finishes the span
+	End()
+}
+
+// Tracer creates spans. Implementations must be safe for concurrent use.
+type Tracer interface {
+	// Start begins a span as a child of the span in ctx, if any
+	Start(ctx context.Context, name string, attrs ...Attr) (context.Context, Span)
+	// Extract returns ctx with the remote span context found in HTTP headers
+	// (for example W3C traceparent), so server spans join the caller's trace
+	Extract(ctx context.Context, header http.Header) context.Context
+	// TraceID returns the ID of the trace ctx belongs to, or ""
+	TraceID(ctx context.Context) string
+}
+
+type holder struct{ tracer Tracer }
+
+var current atomic.Value
+
+func init() {
+	current.Store(holder{noopTracer{}})
+}
+
+// SetTracer installs the tracer used by Start. Call it before serving traffic.
+func SetTracer(t Tracer) {
+	if t == nil {
+		t = noopTracer{}
+	}
+	current.Store(holder{t})
+}
+
+func tracer() Tracer {
+	return current.Load().(holder).tracer
+}
+
+// Start begins a span as a child of the span in ctx, if any. Callers must End it.
+func Start(ctx context.Context, name string, attrs ...Attr) (context.Context, Span) {
+	return tracer().Start(ctx, name, attrs...)
+}
+
+// TraceID returns the trace ID of ctx, or "" when tracing is off
+func TraceID(ctx context.Context) string {
+	return tracer().TraceID(ctx)
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, _ string, _ ...Attr) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+func (noopTracer) Extract(ctx context.Context, _ http.Header) context.Context { return ctx }
+func (noopTracer) TraceID(context.Context) string                             { return "" }
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...Attr) {}
+func (noopSpan) RecordError(error)     {}
+func (noopSpan) End()                  {}
@@ -0,0 +1,267 @@
+// Package validate checks API request bodies field by field. A Validator
+// collects every failing field, so a client learns about all of them in one
+// response rather than fixing them one at a time.
+package validate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mau.fi/whatsmeow/types"
+
+	"whatsapp-client/apierror"
+)
+
+// MaxMessageLength is the longest text message WhatsApp delivers, in characters
+const MaxMessageLength = 65536
+
+// MaxFilenameLength is the longest file name accepted, in characters
+const MaxFilenameLength = 255
+
+// Field error codes
+const (
+	// CodeRequired means the field is missing or empty
+	CodeRequired = "required"
+	// CodeInvalidFormat means the value isn't a phone number, JID, or JSON of the right type
+	CodeInvalidFormat = "invalid_format"
+	// CodeInvalidTime means the value isn't an RFC 3339 time
+	CodeInvalidTime = apierror.CodeInvalidTime
+	// CodeTooLong means the value is over its maximum length
+	CodeTooLong = "too_long"
+	// CodeInvalidValue means the value isn't one of the accepted ones or is out of range
+	CodeInvalidValue = "invalid_value"
+)
+
+// e164 matches a phone number in E.164 form, with or without the leading +
+var e164 = regexp.MustCompile(`^\+?[1-9][0-9]{6,14}$`)
+
+// phoneSeparators are dropped from phone numbers before they are checked
+var phoneSeparators = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "", ".", "")
+
+// jidServers are the JID servers messages can be sent to
+var jidServers = []string{
+	types.DefaultUserServer,
+	types.GroupServer,
+	types.HiddenUserServer,
+	types.BroadcastServer,
+	types.NewsletterServer,
+}
+
+// Validator collects the failing fields of one request
+type Validator struct {
+	fields []apierror.FieldError
+}
+
+// Add records a failing field. Only the first problem of each field is kept.
+func (v *Validator) Add(field, code, message string) {
+	if v.Failed(field) {
+		return
+	}
+	v.fields = append(v.fields, apierror.FieldError{Field: field, Code: code, Message: message})
+}
+
+// Nest records the failing fields of sub, an element of a list or object, as
+// fields of prefix, e.g. "operations.2.recipient"
+func (v *Validator) Nest(prefix string, sub *Validator) {
+	for _, f := range sub.fields {
+		v.Add(prefix+"."+f.Field, f.Code, f.Message)
+	}
+}
+
+// Failed reports whether field has already failed
+func (v *Validator) Failed(field string) bool {
+	return slices.ContainsFunc(v.fields, func(f apierror.FieldError) bool { return f.Field == field })
+}
+
+// Valid reports whether no field failed
+func (v *Validator) Valid() bool {
+	return len(v.fields) == 0
+}
+
+// Fields returns the failing fields
+func (v *Validator) Fields() []apierror.FieldError {
+	return v.fields
+}
+
+// Write sends the failing fields as a 400 response
+func (v *Validator) Write(w http.ResponseWriter) {
+	apierror.Invalid(w, v.fields)
+}
+
+// Err returns the failing fields as an error, or nil when all are valid
+func (v *Validator) Err() error {
+	if v.Valid() {
+		return nil
+	}
+	return &Error{Fields: v.fields}
+}
+
+// Required fails field when value is empty, and reports whether it is set
+func (v *Validator) Required(field, value string) bool {
+	if strings.TrimSpace(value) == "" {
+		v.Add(field, CodeRequired, "is required")
+		return false
+	}
+	return true
+}
+
+// Recipient checks a required recipient: a phone number in E.164 form, as
+// digits with an optional +, or a JID. It returns the recipient the bridge
+// sends to, with the + and any spaces, dashes, dots or parentheses of a phone
+// number removed.
+func (v *Validator) Recipient(field, value string) string {
+	if !v.Required(field, value) {
+		return value
+	}
+	if strings.Contains(value, "@") {
+		v.JID(field, value)
+		return value
+	}
+	return v.Phone(field, value)
+}
+
+// Phone checks a phone number in E.164 form and returns it as digits only
+func (v *Validator) Phone(field, value string) string {
+	number := phoneSeparators.Replace(strings.TrimSpace(value))
+	if !e164.MatchString(number) {
+		v.Add(field, CodeInvalidFormat, "must be a phone number with country code, like +5491156543944, or a JID")
+		return value
+	}
+	return strings.TrimPrefix(number, "+")
+}
+
+// JID checks a JID like 5491156543944@s.whatsapp.net or 1203630...@g.us
+func (v *Validator) JID(field, value string) {
+	jid, err := types.ParseJID(value)
+	switch {
+	case err != nil || jid.User == "":
+		v.Add(field, CodeInvalidFormat, "must be a JID like 5491156543944@s.whatsapp.net")
+	case !slices.Contains(jidServers, jid.Server):
+		v.Add(field, CodeInvalidFormat, fmt.Sprintf("has unknown server %q, expected one of %s", jid.Server, strings.Join(jidServers, ", ")))
+	case jid.Server == types.DefaultUserServer && !e164.MatchString(jid.User):
+		v.Add(field, CodeInvalidFormat, "must have a phone number with country code before @s.whatsapp.net")
+	}
+}
+
+// Message checks a required message text of at most MaxMessageLength characters
+func (v *Validator) Message(field, value string) {
+	if v.Required(field, value) {
+		v.MaxLength(field, value, MaxMessageLength)
+	}
+}
+
+// Time checks a required RFC 3339 time and returns it
+func (v *Validator) Time(field, value string) time.Time {
+	if !v.Required(field, value) {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		v.Add(field, CodeInvalidTime, "must be an ISO-8601 time with zone, e.g. 2025-10-06T15:30:00Z")
+	}
+	return t
+}
+
+// MaxLength fails field when value has more than max characters
+func (v *Validator) MaxLength(field, value string, max int) {
+	if n := utf8.RuneCountInString(value); n > max {
+		v.Add(field, CodeTooLong, fmt.Sprintf("must be at most %d characters, got %d", max, n))
+	}
+}
+
+// OneOf fails field when value is set and isn't one of allowed
+func (v *Validator) OneOf(field, value string, allowed ...string) {
+	if value != "" && !slices.Contains(allowed, value) {
+		v.Add(field, CodeInvalidValue, fmt.Sprintf("must be one of %s", strings.Join(allowed, ", ")))
+	}
+}
+
+// Filename checks an optional file name, which must not be a path
+func (v *Validator) Filename(field, value string) {
+	switch {
+	case value == "":
+	case value == "." || value == ".." || strings.ContainsAny(value, `/\`):
+		v.Add(field, CodeInvalidFormat, "must be a file name without directories")
+	case strings.ContainsFunc(value, func(r rune) bool { return r < ' ' || r == 0x7f }):
+		v.Add(field, CodeInvalidFormat, "must not contain control characters")
+	default:
+		v.MaxLength(field, value, MaxFilenameLength)
+	}
+}
+
+// Mimetype checks an optional MIME type like application/pdf
+func (v *Validator) Mimetype(field, value string) {
+	if value == "" {
+		return
+	}
+	if mediaType, _, err := mime.ParseMediaType(value); err != nil || !strings.Contains(mediaType, "/") {
+		v.Add(field, CodeInvalidFormat, "must be a MIME type like application/pdf")
+	}
+}
+
+// NotNegative fails field when n is below zero
+func (v *Validator) NotNegative(field string, n int) {
+	if n < 0 {
+		v.Add(field, CodeInvalidValue, "must not be negative")
+	}
+}
+
+// Error is a request whose fields failed validation
+type Error struct {
+	Fields []apierror.FieldError
+}
+
+func (e *Error) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		messages = append(messages, f.Field+" "+f.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Decode reads a JSON request body into dst. It reports false when the body
+// isn't a usable JSON object at all. A value of the wrong type fails its field
+// and decoding carries on, so the other fields are still checked.
+func (v *Validator) Decode(r *http.Request, dst interface{}) bool {
+	err := json.NewDecoder(r.Body).Decode(dst)
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		v.Add(typeErr.Field, CodeInvalidFormat, fmt.Sprintf("must be a %s, got %s", jsonType(typeErr.Type.Kind().String()), typeErr.Value))
+		return true
+	case errors.As(err, &syntaxErr):
+		v.Add("body", CodeInvalidFormat, fmt.Sprintf("is not valid JSON: %v (at offset %d)", syntaxErr, syntaxErr.Offset))
+	case errors.Is(err, io.EOF):
+		v.Add("body", CodeRequired, "is required")
+	default:
+		v.Add("body", CodeInvalidFormat, "must be a JSON object")
+	}
+	return false
+}
+
+// jsonType names a Go kind the way a JSON client knows it
+func jsonType(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"), strings.HasPrefix(kind, "float"):
+		return "number"
+	case kind == "bool":
+		return "boolean"
+	case kind == "slice", kind == "array":
+		return "list"
+	case kind == "map", kind == "struct":
+		return "object"
+	}
+	return kind
+}
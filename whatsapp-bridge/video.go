@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Codecs WhatsApp plays in MP4 files, by sample entry type
+var (
+	mp4VideoCodecs = []string{"avc1", "avc3"}
+	mp4AudioCodecs = []string{"mp4a"}
+)
+
+// convertVideoHint tells how to fix a video WhatsApp won't play when the
+// bridge has no ffmpeg to convert it
+const convertVideoHint = "WhatsApp plays MP4 with H.264 video and AAC audio; install ffmpeg on the bridge host to have it converted, or convert it with: ffmpeg -i input -c:v libx264 -pix_fmt yuv420p -c:a aac output.mp4"
+
+// maxVideoSide is the longest side of a converted video, in pixels
+const maxVideoSide = 1920
+
+// convertGIFHint tells how to send an animated GIF when the bridge has no
+// ffmpeg to convert it
+const convertGIFHint = "install ffmpeg on the bridge host to have it converted, or convert it with: ffmpeg -i input.gif -c:v libx264 -pix_fmt yuv420p -an -movflags +faststart output.mp4"
+
+// remuxMimetypes are containers that hold the same tracks as MP4, so a video
+// in them with the right codecs only needs moving into MP4
+var remuxMimetypes = []string{"video/quicktime", "video/3gpp"}
+
+// mp4Info is what the bridge reads from an MP4 file's moov box
+type mp4Info struct {
+	seconds    uint32
+	videoCodec string
+	audioCodec string
+	width      uint32
+	height     uint32
+}
+
+// mp4Box is one box (atom) of an MP4 file
+type mp4Box struct {
+	typ     string
+	payload []byte
+}
+
+// mp4Boxes splits data into the boxes it contains
+func mp4Boxes(data []byte) ([]mp4Box, error) {
+	var boxes []mp4Box
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, errors.New("truncated box header")
+		}
+		size := uint64(binary.BigEndian.Uint32(data))
+		typ := string(data[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			// The box runs to the end of the file
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, errors.New("truncated box header")
+			}
+			size, header = binary.BigEndian.Uint64(data[8:]), 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return nil, fmt.Errorf("box %q has an invalid size", typ)
+		}
+		boxes = append(boxes, mp4Box{typ: typ, payload: data[header:size]})
+		data = data[size:]
+	}
+	return boxes, nil
+}
+
+// mp4Child returns the payload of the first box of type typ in data
+func mp4Child(data []byte, typ string) ([]byte, bool) {
+	boxes, err := mp4Boxes(data)
+	if err != nil {
+		return nil, false
+	}
+	for _, box := range boxes {
+		if box.typ == typ {
+			return box.payload, true
+		}
+	}
+	return nil, false
+}
+
+// parseMP4 reads the duration and the codecs and size of the first audio and
+// video tracks of an MP4 file
+func parseMP4(data []byte) (*mp4Info, error) {
+	moov, ok := mp4Child(data, "moov")
+	if !ok {
+		return nil, errors.New("has no moov box, the file is incomplete or not an MP4 video")
+	}
+	info := &mp4Info{}
+
+	if mvhd, ok := mp4Child(moov, "mvhd"); ok && len(mvhd) >= 20 {
+		var timescale, duration uint64
+		if mvhd[0] == 1 && len(mvhd) >= 32 {
+			timescale, duration = uint64(binary.BigEndian.Uint32(mvhd[20:])), binary.BigEndian.Uint64(mvhd[24:])
+		} else {
+			timescale, duration = uint64(binary.BigEndian.Uint32(mvhd[12:])), uint64(binary.BigEndian.Uint32(mvhd[16:]))
+		}
+		if timescale > 0 {
+			info.seconds = uint32((duration + timescale/2) / timescale)
+		}
+	}
+
+	boxes, err := mp4Boxes(moov)
+	if err != nil {
+		return nil, err
+	}
+	for _, trak := range boxes {
+		if trak.typ != "trak" {
+			continue
+		}
+		mdia, ok := mp4Child(trak.payload, "mdia")
+		if !ok {
+			continue
+		}
+		hdlr, ok := mp4Child(mdia, "hdlr")
+		if !ok || len(hdlr) < 12 {
+			continue
+		}
+		handler := string(hdlr[8:12])
+		minf, _ := mp4Child(mdia, "minf")
+		stbl, _ := mp4Child(minf, "stbl")
+		stsd, ok := mp4Child(stbl, "stsd")
+		if !ok || len(stsd) < 8 {
+			continue
+		}
+		entries, err := mp4Boxes(stsd[8:])
+		if err != nil || len(entries) == 0 {
+			continue
+		}
+		entry := entries[0]
+
+		switch handler {
+		case "vide":
+			if info.videoCodec != "" {
+				continue
+			}
+			info.videoCodec = entry.typ
+			if len(entry.payload) >= 28 {
+				info.width = uint32(binary.BigEndian.Uint16(entry.payload[24:]))
+				info.height = uint32(binary.BigEndian.Uint16(entry.payload[26:]))
+			}
+		case "soun":
+			if info.audioCodec == "" {
+				info.audioCodec = entry.typ
+			}
+		}
+	}
+	return info, nil
+}
+
+// videoProblem says why WhatsApp won't play a video, "" when it will. remux
+// is set when the tracks are fine and only the container isn't.
+func videoProblem(mimetype string, info *mp4Info, err error) (problem string, remux bool) {
+	switch {
+	case err != nil && mimetype == "video/mp4":
+		return err.Error(), false
+	case err != nil:
+		return "is " + mimetype, false
+	case info.videoCodec == "":
+		return "has no video track", false
+	case !slices.Contains(mp4VideoCodecs, info.videoCodec):
+		return fmt.Sprintf("has %s video", info.videoCodec), false
+	case info.audioCodec != "" && !slices.Contains(mp4AudioCodecs, info.audioCodec):
+		return fmt.Sprintf("has %s audio", info.audioCodec), false
+	case mimetype != "video/mp4":
+		return "is " + mimetype, slices.Contains(remuxMimetypes, mimetype)
+	}
+	return "", false
+}
+
+// convertVideo turns a video into MP4 with H.264 video, at most maxVideoSide
+// pixels on its longest side, and AAC audio, with the index at the start so it
+// plays while downloading. remux copies the tracks as they are.
+func convertVideo(ctx context.Context, data []byte, remux bool) ([]byte, error) {
+	args := []string{"-map", "0:v:0", "-map", "0:a:0?", "-c", "copy"}
+	if !remux {
+		args = []string{"-map", "0:v:0", "-map", "0:a:0?",
+			"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p",
+			"-vf", fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease:force_divisible_by=2", maxVideoSide, maxVideoSide),
+			"-c:a", "aac", "-b:a", "128k"}
+	}
+	return transcodeFFmpeg(ctx, data, ".mp4", append(args, "-movflags", "+faststart", "-f", "mp4")...)
+}
+
+// convertGIF turns an animated GIF into the silent MP4 WhatsApp plays as a
+// GIF. Unlike other videos, small GIFs are kept at their size.
+func convertGIF(ctx context.Context, data []byte) ([]byte, error) {
+	return transcodeFFmpeg(ctx, data, ".mp4", "-map", "0:v:0",
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p",
+		"-vf", fmt.Sprintf("scale='min(%d,iw)':'min(%d,ih)':force_original_aspect_ratio=decrease:force_divisible_by=2", maxVideoSide, maxVideoSide),
+		"-an", "-movflags", "+faststart", "-f", "mp4")
+}
+
+// prepareGIF converts an animated GIF to MP4 and reads it like any video
+func prepareGIF(ctx context.Context, media *outgoingMedia) error {
+	converted, err := convertGIF(ctx, media.data)
+	switch {
+	case errors.Is(err, errNoFFmpeg):
+		return errors.New("is an animated GIF, which WhatsApp only plays as MP4; " + convertGIFHint)
+	case err != nil:
+		return fmt.Errorf("is an animated GIF that can't be converted to MP4: %v", err)
+	case int64(len(converted)) > mediaSizeLimits[mediaVideo]:
+		return fmt.Errorf("is an animated GIF larger than %s once converted to MP4", formatSize(mediaSizeLimits[mediaVideo]))
+	}
+	slog.InfoContext(ctx, "Converted GIF for WhatsApp", "size", len(media.data), "converted_size", len(converted))
+	media.data, media.mimetype = converted, "video/mp4"
+	media.filename = strings.TrimSuffix(media.filename, filepath.Ext(media.filename)) + ".mp4"
+	return prepareVideo(ctx, media)
+}
+
+// prepareVideo converts a video WhatsApp won't play, when ffmpeg is there to
+// do it, and reads its duration, size and a thumbnail
+func prepareVideo(ctx context.Context, media *outgoingMedia) error {
+	info, err := parseMP4(media.data)
+	if problem, remux := videoProblem(media.mimetype, info, err); problem != "" {
+		if err == nil && info.videoCodec == "" {
+			return errors.New(problem)
+		}
+		converted, err := convertVideo(ctx, media.data, remux)
+		switch {
+		case errors.Is(err, errNoFFmpeg):
+			return fmt.Errorf("%s. %s", problem, convertVideoHint)
+		case err != nil:
+			return fmt.Errorf("%s and can't be converted to H.264: %v", problem, err)
+		case int64(len(converted)) > mediaSizeLimits[mediaVideo]:
+			return fmt.Errorf("%s, and converted to H.264 is larger than %s", problem, formatSize(mediaSizeLimits[mediaVideo]))
+		}
+		slog.InfoContext(ctx, "Converted video for WhatsApp", "reason", problem, "remux", remux, "size", len(media.data), "converted_size", len(converted))
+		media.data, media.mimetype = converted, "video/mp4"
+		media.filename = strings.TrimSuffix(media.filename, filepath.Ext(media.filename)) + ".mp4"
+		if info, err = parseMP4(converted); err != nil {
+			return fmt.Errorf("can't be read once converted: %v", err)
+		}
+	}
+	media.seconds = info.seconds
+	media.width, media.height = info.width, info.height
+
+	// The preview is a nicety, so the video goes without one when ffmpeg can't make it
+	frame, err := runFFmpeg(ctx, media.data, "-frames:v", "1", "-f", "image2pipe", "-c:v", "mjpeg", "pipe:1")
+	if err != nil {
+		if !errors.Is(err, errNoFFmpeg) {
+			slog.Warn("Failed to extract video thumbnail", "error", err)
+		}
+		return nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(frame))
+	if err != nil {
+		slog.Warn("Failed to decode video thumbnail", "error", err)
+		return nil
+	}
+	if media.thumbnail, err = jpegThumbnail(img, thumbnailSize); err != nil {
+		slog.Warn("Failed to make video thumbnail", "error", err)
+	}
+	return nil
+}
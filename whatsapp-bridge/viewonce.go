@@ -0,0 +1,35 @@
+package main
+
+import (
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// StoreViewOnce flags a stored message as media meant to be viewed once
+func (store *MessageStore) StoreViewOnce(messageID, chatJID string) error {
+	_, err := store.db.Exec(
+		"INSERT INTO view_once_messages (message_id, chat_jid) VALUES (?, ?) ON CONFLICT (message_id, chat_jid) DO NOTHING",
+		messageID, chatJID,
+	)
+	return err
+}
+
+// viewOnceMessage wraps a photo or video so the recipient can open it only once
+func viewOnceMessage(msg *waProto.Message) *waProto.Message {
+	switch {
+	case msg.ImageMessage != nil:
+		msg.ImageMessage.ViewOnce = proto.Bool(true)
+	case msg.VideoMessage != nil:
+		msg.VideoMessage.ViewOnce = proto.Bool(true)
+	}
+	return &waProto.Message{ViewOnceMessage: &waProto.FutureProofMessage{Message: msg}}
+}
+
+// unwrapViewOnce returns the media inside a view-once message the bridge
+// built, and whether there was one
+func unwrapViewOnce(msg *waProto.Message) (*waProto.Message, bool) {
+	if inner := msg.GetViewOnceMessage().GetMessage(); inner != nil {
+		return inner, true
+	}
+	return msg, false
+}
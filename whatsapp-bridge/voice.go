@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// voiceMimetype is what WhatsApp voice notes are
+const voiceMimetype = "audio/ogg; codecs=opus"
+
+// voiceWaveformLength is how many bars WhatsApp draws for a voice note
+const voiceWaveformLength = 64
+
+// waveformSampleRate is the rate audio is decoded at to draw its waveform,
+// plenty for 64 bars
+const waveformSampleRate = 8000
+
+// voiceFileMimetypes are the formats WhatsApp plays as a plain audio file, which
+// is how they are sent when there is no ffmpeg to turn them into a voice note
+var voiceFileMimetypes = []string{"audio/mpeg", "audio/mp4", "audio/aac", "audio/amr"}
+
+// isOggOpus reports whether data is an Ogg file with Opus audio. The OpusHead
+// packet is in the first page.
+func isOggOpus(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("OggS")) && bytes.Contains(data[:min(len(data), 512)], []byte("OpusHead"))
+}
+
+// prepareVoice turns audio into an Ogg Opus voice note and reads its length
+// and waveform. Without ffmpeg only Ogg Opus becomes a voice note; MP3 and
+// AAC still go out as an audio file, and other formats are refused.
+func prepareVoice(ctx context.Context, media *outgoingMedia) error {
+	if !isOggOpus(media.data) {
+		opus, err := runFFmpeg(ctx, media.data, "-vn", "-ac", "1", "-c:a", "libopus", "-b:a", "32k", "-ar", "24000",
+			"-application", "voip", "-vbr", "on", "-frame_duration", "60", "-f", "ogg", "pipe:1")
+		switch {
+		case errors.Is(err, errNoFFmpeg) && slices.Contains(voiceFileMimetypes, media.mimetype):
+			slog.Warn("ffmpeg is not installed, sending audio as a file instead of a voice note", "mime_type", media.mimetype)
+			return nil
+		case errors.Is(err, errNoFFmpeg):
+			return fmt.Errorf("is %s, which needs ffmpeg on the bridge host to become a voice note; install it or send Ogg Opus", media.mimetype)
+		case err != nil:
+			return fmt.Errorf("can't be converted to a voice note: %v", err)
+		}
+		media.data = opus
+		media.filename = strings.TrimSuffix(media.filename, filepath.Ext(media.filename)) + ".ogg"
+	}
+
+	seconds, waveform, err := analyzeOggOpus(media.data)
+	if err != nil {
+		return fmt.Errorf("is not a valid Ogg Opus file: %v", err)
+	}
+	media.mimetype = voiceMimetype
+	media.ptt = true
+	media.seconds, media.waveform = seconds, waveform
+
+	// The real waveform needs ffmpeg to decode the audio; the placeholder stays otherwise
+	pcm, err := runFFmpeg(ctx, media.data, "-f", "s16le", "-ac", "1", "-ar", fmt.Sprint(waveformSampleRate), "pipe:1")
+	if err != nil {
+		if !errors.Is(err, errNoFFmpeg) {
+			slog.Warn("Failed to decode voice note for its waveform", "error", err)
+		}
+		return nil
+	}
+	if samples := len(pcm) / 2; samples > 0 {
+		media.seconds = uint32(math.Ceil(float64(samples) / waveformSampleRate))
+		media.waveform = audioWaveform(pcm)
+	}
+	return nil
+}
+
+// audioWaveform draws the waveform of 16-bit little-endian mono PCM: the RMS
+// level of each of voiceWaveformLength slices, scaled so the loudest is 100
+func audioWaveform(pcm []byte) []byte {
+	samples := len(pcm) / 2
+	levels := make([]float64, voiceWaveformLength)
+	var loudest float64
+	for i := range levels {
+		start, end := i*samples/voiceWaveformLength, (i+1)*samples/voiceWaveformLength
+		if end <= start {
+			continue
+		}
+		var sum float64
+		for s := start; s < end; s++ {
+			sample := float64(int16(binary.LittleEndian.Uint16(pcm[2*s:])))
+			sum += sample * sample
+		}
+		levels[i] = math.Sqrt(sum / float64(end-start))
+		loudest = max(loudest, levels[i])
+	}
+
+	waveform := make([]byte, voiceWaveformLength)
+	if loudest == 0 {
+		return waveform
+	}
+	for i, level := range levels {
+		waveform[i] = byte(math.Round(level / loudest * 100))
+	}
+	return waveform
+}
@@ -0,0 +1,111 @@
+package webhook
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"whatsapp-client/apierror"
+	"whatsapp-client/audit"
+	"whatsapp-client/pagination"
+)
+
+// SetupHandlers returns the HTTP handler for inspecting and retrying
+// dead-lettered deliveries under /v1/webhooks/failed
+func SetupHandlers(store *Store) http.Handler {
+	mux := http.NewServeMux()
+
+	// GET /v1/webhooks/failed - Deliveries that ran out of attempts, newest first, a page at a time
+	mux.HandleFunc("GET /v1/webhooks/failed", func(w http.ResponseWriter, r *http.Request) {
+		page, err := pagination.FromRequest(r)
+		if err != nil {
+			apierror.BadRequest(w, err.Error())
+			return
+		}
+		var after *FailedCursor
+		var cursor FailedCursor
+		if ok, err := page.Decode(&cursor); err != nil {
+			apierror.BadRequest(w, "Invalid cursor")
+			return
+		} else if ok {
+			after = &cursor
+		}
+
+		failed, err := store.Failed(r.Context(), after, page.Limit+1)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error listing failed webhooks", "error", err)
+			apierror.Internal(w, "Failed to list failed webhooks")
+			return
+		}
+		failed, next := pagination.Page(failed, page, func(d *Delivery) interface{} {
+			return FailedCursor{LastAttemptAt: *d.LastAttemptAt, ID: d.ID}
+		})
+		for _, d := range failed {
+			d.URL = redactURL(d.URL)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":     true,
+			"deliveries":  failed,
+			"next_cursor": next,
+		})
+	})
+
+	// POST /v1/webhooks/failed/{id}/retry - Queue a failed delivery again
+	mux.HandleFunc("POST /v1/webhooks/failed/{id}/retry", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		audit.SetResource(r.Context(), id)
+		ok, err := store.Retry(r.Context(), id, time.Now())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error retrying failed webhook", "delivery_id", id, "error", err)
+			apierror.Internal(w, "Failed to retry webhook")
+			return
+		}
+		if !ok {
+			apierror.NotFound(w, "Failed delivery not found")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Delivery queued again",
+		})
+	})
+
+	// DELETE /v1/webhooks/failed/{id} - Drop a failed delivery
+	mux.HandleFunc("DELETE /v1/webhooks/failed/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		audit.SetResource(r.Context(), id)
+		ok, err := store.DeleteFailed(r.Context(), id)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error deleting failed webhook", "delivery_id", id, "error", err)
+			apierror.Internal(w, "Failed to delete webhook")
+			return
+		}
+		if !ok {
+			apierror.NotFound(w, "Failed delivery not found")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Delivery deleted",
+		})
+	})
+
+	return apierror.Wrap(mux)
+}
+
+// redactURL hides credentials in a receiver URL
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,190 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Delivery statuses
+const (
+	// StatusPending deliveries are waiting for their next attempt
+	StatusPending = "pending"
+	// StatusFailed deliveries ran out of attempts and wait in the dead-letter list
+	StatusFailed = "failed"
+)
+
+// Delivery is one event queued for one URL
+type Delivery struct {
+	ID            string          `json:"id"`
+	URL           string          `json:"url"`
+	EventType     string          `json:"event_type"`
+	Body          json.RawMessage `json:"event"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	LastError     *string         `json:"last_error,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	LastAttemptAt *time.Time      `json:"last_attempt_at,omitempty"`
+	NextAttemptAt time.Time       `json:"-"`
+}
+
+// Store keeps the webhook queue in a SQLite database, so deliveries survive a
+// restart or a receiver being down for a while
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore creates the queue table in db if needed
+func NewStore(db *sql.DB) (*Store, error) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id TEXT PRIMARY KEY,
+			url TEXT NOT NULL,
+			event_type TEXT NOT NULL,
+			body TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT,
+			created_at TIMESTAMP NOT NULL,
+			last_attempt_at TIMESTAMP,
+			next_attempt_at TIMESTAMP NOT NULL
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, url, next_attempt_at);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook queue table: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+const deliveryColumns = "id, url, event_type, body, status, attempts, last_error, created_at, last_attempt_at, next_attempt_at"
+
+// Insert queues deliveries in a single transaction
+func (s *Store) Insert(ctx context.Context, deliveries []*Delivery) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, d := range deliveries {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO webhook_deliveries (id, url, event_type, body, status, attempts, created_at, next_attempt_at)
+			VALUES (?, ?, ?, ?, ?, 0, ?, ?)
+		`, d.ID, d.URL, d.EventType, string(d.Body), StatusPending, d.CreatedAt.UTC(), d.NextAttemptAt.UTC()); err != nil {
+			return fmt.Errorf("failed to queue webhook delivery: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Due returns up to limit pending deliveries for url whose next attempt is due, oldest first
+func (s *Store) Due(ctx context.Context, url string, now time.Time, limit int) ([]*Delivery, error) {
+	return s.list(ctx, `
+		SELECT `+deliveryColumns+` FROM webhook_deliveries
+		WHERE status = ? AND url = ? AND next_attempt_at <= ?
+		ORDER BY created_at
+		LIMIT ?
+	`, StatusPending, url, now.UTC(), limit)
+}
+
+// FailedCursor is the sort key of a delivery in Failed order
+type FailedCursor struct {
+	LastAttemptAt time.Time `json:"t"`
+	ID            string    `json:"id"`
+}
+
+// Failed returns up to limit deliveries of the dead-letter list, most recently
+// failed first, starting after the given one if after is set
+func (s *Store) Failed(ctx context.Context, after *FailedCursor, limit int) ([]*Delivery, error) {
+	query := `
+		SELECT ` + deliveryColumns + ` FROM webhook_deliveries
+		WHERE status = ?`
+	args := []interface{}{StatusFailed}
+	if after != nil {
+		query += " AND (last_attempt_at < ? OR (last_attempt_at = ? AND id < ?))"
+		args = append(args, after.LastAttemptAt.UTC(), after.LastAttemptAt.UTC(), after.ID)
+	}
+	query += " ORDER BY last_attempt_at DESC, id DESC LIMIT ?"
+	args = append(args, limit)
+
+	return s.list(ctx, query, args...)
+}
+
+// Delete removes a delivered delivery
+func (s *Store) Delete(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM webhook_deliveries WHERE id = ?", id)
+	return err
+}
+
+// RecordFailure records a failed attempt. The delivery is retried at next, or
+// moved to the dead-letter list when next is nil.
+func (s *Store) RecordFailure(ctx context.Context, id string, attempts int, errMsg string, at time.Time, next *time.Time) error {
+	status, nextAt := StatusFailed, at
+	if next != nil {
+		status, nextAt = StatusPending, *next
+	}
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = ?, attempts = ?, last_error = ?, last_attempt_at = ?, next_attempt_at = ?
+		WHERE id = ?
+	`, status, attempts, errMsg, at.UTC(), nextAt.UTC(), id)
+	return err
+}
+
+// Retry puts a dead-lettered delivery back in the queue with a fresh set of
+// attempts. It reports false if there is no such failed delivery.
+func (s *Store) Retry(ctx context.Context, id string, now time.Time) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = ?, attempts = 0, next_attempt_at = ?
+		WHERE id = ? AND status = ?
+	`, StatusPending, now.UTC(), id, StatusFailed)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// DeleteFailed drops a dead-lettered delivery. It reports false if there is no
+// such failed delivery.
+func (s *Store) DeleteFailed(ctx context.Context, id string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM webhook_deliveries WHERE id = ? AND status = ?", id, StatusFailed)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (s *Store) list(ctx context.Context, query string, args ...interface{}) ([]*Delivery, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*Delivery{}
+	for rows.Next() {
+		d := &Delivery{}
+		var body string
+		var lastError sql.NullString
+		var lastAttemptAt sql.NullTime
+		if err := rows.Scan(&d.ID, &d.URL, &d.EventType, &body, &d.Status, &d.Attempts, &lastError, &d.CreatedAt, &lastAttemptAt, &d.NextAttemptAt); err != nil {
+			return nil, err
+		}
+		d.Body = json.RawMessage(body)
+		if lastError.Valid {
+			d.LastError = &lastError.String
+		}
+		if lastAttemptAt.Valid {
+			d.LastAttemptAt = &lastAttemptAt.Time
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
@@ -0,0 +1,361 @@
+// Package webhook POSTs bridge events to configured URLs, signed with HMAC-SHA256
+// so receivers can check they came from this bridge. Deliveries are queued in
+// the database and retried with exponential backoff; those that keep failing
+// end up in a dead-letter list.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"whatsapp-client/events"
+)
+
+// Headers set on every delivery
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+const (
+	// intakeSize is how many events may wait to be written to the queue before
+	// new ones are dropped
+	intakeSize = 1000
+	// pollInterval is how often each URL's worker looks for retries that are due
+	pollInterval = 5 * time.Second
+	// batchSize bounds how many due deliveries a worker loads at once
+	batchSize = 50
+)
+
+// Retry delays double from baseRetryDelay after each failed attempt, up to maxRetryDelay
+const (
+	baseRetryDelay = 30 * time.Second
+	maxRetryDelay  = time.Hour
+)
+
+// Options configures a Dispatcher
+type Options struct {
+	URLs   []string
+	Secret string
+	// Events are the event types delivered; others are ignored
+	Events []string
+	// Timeout bounds each delivery request
+	Timeout time.Duration
+	// MaxAttempts is how many times a delivery is tried before it is dead-lettered
+	MaxAttempts int
+}
+
+// Dispatcher delivers events to every configured URL. Each URL has its own
+// worker, so a slow or broken receiver doesn't hold up the others.
+type Dispatcher struct {
+	store *Store
+
+	intake     chan events.Event
+	intakeDone chan struct{}
+
+	// mu guards the settings and workers, which Update replaces
+	mu      sync.Mutex
+	opts    Options
+	client  *http.Client
+	workers map[string]*worker
+	// stopped is set by Stop; Enqueue ignores events after that
+	stopped bool
+}
+
+// worker delivers the queue of one URL
+type worker struct {
+	url  string
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New starts a Dispatcher with one worker per URL. Deliveries left in store by
+// an earlier run are picked up again.
+func New(store *Store, opts Options) *Dispatcher {
+	d := &Dispatcher{
+		store:      store,
+		intake:     make(chan events.Event, intakeSize),
+		intakeDone: make(chan struct{}),
+		workers:    make(map[string]*worker),
+	}
+	d.apply(opts)
+	go d.ingest()
+	return d
+}
+
+// Update switches to new settings. Workers start for added URLs, and those of
+// removed URLs stop after the delivery they are on; their queued deliveries
+// stay in the store.
+func (d *Dispatcher) Update(opts Options) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.stopped {
+		d.apply(opts)
+	}
+}
+
+// apply installs opts; d.mu must be held
+func (d *Dispatcher) apply(opts Options) {
+	d.opts = opts
+	d.client = &http.Client{Timeout: opts.Timeout}
+
+	for url, w := range d.workers {
+		if !slices.Contains(opts.URLs, url) {
+			close(w.stop)
+			delete(d.workers, url)
+		}
+	}
+	for _, url := range opts.URLs {
+		if _, ok := d.workers[url]; ok {
+			continue
+		}
+		w := &worker{
+			url:  url,
+			wake: make(chan struct{}, 1),
+			stop: make(chan struct{}),
+			done: make(chan struct{}),
+		}
+		d.workers[url] = w
+		go d.run(w)
+	}
+}
+
+// Enqueue queues an event if its type is one the dispatcher delivers. It never
+// blocks, so it can be a bus listener: events are written to the queue in the
+// background, and dropped if too many are waiting for that.
+func (d *Dispatcher) Enqueue(evt events.Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped || len(d.workers) == 0 || !slices.Contains(d.opts.Events, evt.Type) {
+		return
+	}
+
+	select {
+	case d.intake <- evt:
+	default:
+		slog.Warn("Webhook queue is backed up, dropping event", "event_id", evt.ID, "event_type", evt.Type)
+	}
+}
+
+// Stop writes the events still in memory to the queue and waits for the
+// workers to finish the delivery they are on, or until ctx is done. Queued
+// deliveries are sent on the next start.
+func (d *Dispatcher) Stop(ctx context.Context) {
+	d.mu.Lock()
+	if !d.stopped {
+		d.stopped = true
+		close(d.intake)
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		<-d.intakeDone
+
+		d.mu.Lock()
+		var workers []*worker
+		for url, w := range d.workers {
+			close(w.stop)
+			delete(d.workers, url)
+			workers = append(workers, w)
+		}
+		d.mu.Unlock()
+
+		for _, w := range workers {
+			<-w.done
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Warn("Webhook deliveries did not finish in time")
+	}
+}
+
+// ingest writes events from the intake into the queue, one delivery per URL
+func (d *Dispatcher) ingest() {
+	defer close(d.intakeDone)
+
+	for evt := range d.intake {
+		body, err := json.Marshal(evt)
+		if err != nil {
+			slog.Error("Error encoding webhook event", "event_type", evt.Type, "error", err)
+			continue
+		}
+
+		d.mu.Lock()
+		workers := make([]*worker, 0, len(d.workers))
+		for _, w := range d.workers {
+			workers = append(workers, w)
+		}
+		d.mu.Unlock()
+
+		now := time.Now()
+		deliveries := make([]*Delivery, 0, len(workers))
+		for _, w := range workers {
+			deliveries = append(deliveries, &Delivery{
+				ID:            uuid.NewString(),
+				URL:           w.url,
+				EventType:     evt.Type,
+				Body:          body,
+				CreatedAt:     now,
+				NextAttemptAt: now,
+			})
+		}
+		if err := d.store.Insert(context.Background(), deliveries); err != nil {
+			slog.Error("Error queueing webhook event", "event_id", evt.ID, "event_type", evt.Type, "error", err)
+			continue
+		}
+
+		for _, w := range workers {
+			select {
+			case w.wake <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// run delivers one URL's queue until the worker is stopped
+func (d *Dispatcher) run(w *worker) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		d.deliverDue(w)
+
+		select {
+		case <-w.stop:
+			return
+		case <-w.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliverDue sends the deliveries for the worker's URL that are due, oldest first
+func (d *Dispatcher) deliverDue(w *worker) {
+	ctx := context.Background()
+
+	for {
+		due, err := d.store.Due(ctx, w.url, time.Now(), batchSize)
+		if err != nil {
+			slog.Error("Error loading webhook deliveries", "url", w.url, "error", err)
+			return
+		}
+
+		for _, del := range due {
+			select {
+			case <-w.stop:
+				return
+			default:
+			}
+			d.attempt(ctx, del)
+		}
+
+		if len(due) < batchSize {
+			return
+		}
+	}
+}
+
+// attempt sends a delivery and records the outcome
+func (d *Dispatcher) attempt(ctx context.Context, del *Delivery) {
+	d.mu.Lock()
+	secret, client, maxAttempts := []byte(d.opts.Secret), d.client, d.opts.MaxAttempts
+	d.mu.Unlock()
+
+	err := send(client, secret, del)
+	if err == nil {
+		if err := d.store.Delete(ctx, del.ID); err != nil {
+			slog.Error("Error removing delivered webhook", "delivery_id", del.ID, "error", err)
+		}
+		return
+	}
+
+	now := time.Now()
+	attempts := del.Attempts + 1
+	var next *time.Time
+	if attempts < maxAttempts {
+		at := now.Add(retryDelay(attempts))
+		next = &at
+		slog.Warn("Webhook delivery failed, will retry", "url", del.URL, "event_type", del.EventType, "delivery_id", del.ID,
+			"attempt", attempts, "retry_at", at.Format(time.RFC3339), "error", err)
+	} else {
+		slog.Error("Webhook delivery failed for good, moved to the dead-letter list", "url", del.URL, "event_type", del.EventType,
+			"delivery_id", del.ID, "attempts", attempts, "error", err)
+	}
+
+	if err := d.store.RecordFailure(ctx, del.ID, attempts, err.Error(), now, next); err != nil {
+		slog.Error("Error recording webhook failure", "delivery_id", del.ID, "error", err)
+	}
+}
+
+// retryDelay is the wait after the given number of failed attempts
+func retryDelay(attempts int) time.Duration {
+	delay := baseRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// send POSTs one delivery; any 2xx response counts as delivered
+func send(client *http.Client, secret []byte, del *Delivery) error {
+	req, err := http.NewRequest(http.MethodPost, del.URL, bytes.NewReader(del.Body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "whatsapp-bridge-webhook")
+	req.Header.Set(EventHeader, del.EventType)
+	req.Header.Set(DeliveryHeader, del.ID)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(secret, timestamp, del.Body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Drain a little so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the signature header value for a delivery: "sha256=" followed by
+// the hex HMAC-SHA256 of the timestamp, a dot and the body. Covering the
+// timestamp lets receivers reject replayed deliveries.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
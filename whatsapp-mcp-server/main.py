@@ -15,19 +15,26 @@ from whatsapp import (
     send_file as whatsapp_send_file,
     send_audio_message as whatsapp_audio_voice_message,
     download_media as whatsapp_download_media,
-    dataclass_to_dict
+    dataclass_to_dict,
+    bridge_headers,
+    bridge_exception_message,
+    bridge_session
 )
 import sys
 
 # Configuration from environment variables or defaults
 BRIDGE_BASE_URL = os.environ.get('WHATSAPP_BRIDGE_URL', 'http://localhost:8080')
+# Sent as X-Created-By so the bridge can attribute scheduled messages to this server
+MCP_CLIENT_NAME = os.environ.get('MCP_CLIENT_NAME', 'whatsapp-mcp')
 
 # Initialize FastMCP server
 mcp = FastMCP("whatsapp")
 
 @mcp.tool()
 def search_contacts(query: str) -> List[Dict[str, Any]]:
-    """Search WhatsApp contacts by name or phone number.
+    """Search WhatsApp contacts by name or phone number, best matches first. Matches
+    the start of names, saved, push or business names, and tolerates typos, so
+    "jo" finds John and Joanna.
     
     Args:
         query: Search term to match against contact names or phone numbers
@@ -35,6 +42,40 @@ def search_contacts(query: str) -> List[Dict[str, Any]]:
     contacts = whatsapp_search_contacts(query)
     return contacts
 
+@mcp.tool()
+def list_contacts(query: Optional[str] = None, limit: int = 100, cursor: Optional[str] = None) -> Dict[str, Any]:
+    """List the WhatsApp contacts synced from the account's address book and chats, by name.
+    
+    Args:
+        query: Optional text to keep the contacts with it in a name or their phone number
+        limit: Maximum number of contacts to return (default 100, at most 1000)
+        cursor: The next_cursor of a previous call, to get the next page
+    
+    Returns:
+        A dictionary with success status, the contacts, each with jid (usable as a recipient),
+        phone_number, name, full_name, push_name, business_name and is_business, and next_cursor
+    """
+    params: Dict[str, Any] = {"limit": limit}
+    if query:
+        params["q"] = query
+    if cursor:
+        params["cursor"] = cursor
+    try:
+        response = bridge_session.get(
+            f"{BRIDGE_BASE_URL}/v1/contacts",
+            params=params,
+            headers=bridge_headers(),
+            timeout=30.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to list contacts: {bridge_exception_message(e)}"
+        }
+
 @mcp.tool()
 def list_messages(
     after: Optional[str] = None,
@@ -76,6 +117,60 @@ def list_messages(
     )
     return messages
 
+@mcp.tool()
+def search_messages(
+    query: str,
+    chat_jid: Optional[str] = None,
+    sender: Optional[str] = None,
+    after: Optional[str] = None,
+    before: Optional[str] = None,
+    order: str = "rank",
+    limit: int = 20,
+    cursor: Optional[str] = None
+) -> Dict[str, Any]:
+    """Search the text of WhatsApp message history, best matches first. Much faster than list_messages on long histories.
+    
+    Args:
+        query: The words to search for; a message matches when it has all of them. Use double quotes for a phrase and a trailing * for a prefix, e.g. invoic*
+        chat_jid: Optional phone number or JID of the chat to search in
+        sender: Optional phone number or JID of the sender to search the messages of
+        after: Optional ISO-8601 time with zone to only search messages sent at or after it
+        before: Optional ISO-8601 time with zone to only search messages sent before it
+        order: "rank" for the best matches first (default) or "recent" for the latest first
+        limit: Maximum number of messages to return (default 20, at most 1000)
+        cursor: The next_cursor of a previous call, to get the next page
+    
+    Returns:
+        A dictionary with success status, the matching messages, each with message_id, chat_jid,
+        chat_name, sender, content, timestamp and a snippet with the matching words in [ and ], and next_cursor
+    """
+    params: Dict[str, Any] = {"q": query, "order": order, "limit": limit}
+    if chat_jid:
+        params["chat"] = chat_jid
+    if sender:
+        params["sender"] = sender
+    if after:
+        params["since"] = after
+    if before:
+        params["until"] = before
+    if cursor:
+        params["cursor"] = cursor
+    try:
+        response = bridge_session.get(
+            f"{BRIDGE_BASE_URL}/v1/messages/search",
+            params=params,
+            headers=bridge_headers(),
+            timeout=30.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to search messages: {bridge_exception_message(e)}"
+        }
+
 @mcp.tool()
 def list_chats(
     query: Optional[str] = None,
@@ -102,6 +197,184 @@ def list_chats(
     )
     return chats
 
+@mcp.tool()
+def get_chat_history(
+    chat_jid: str,
+    after: Optional[str] = None,
+    before: Optional[str] = None,
+    limit: int = 50,
+    cursor: Optional[str] = None
+) -> Dict[str, Any]:
+    """Get the messages of a WhatsApp chat, the latest first, a page at a time. Unlike list_messages it reads them through the bridge, so it doesn't need the bridge's database file.
+    
+    Args:
+        chat_jid: The phone number or JID of the chat
+        after: Optional ISO-8601 time with zone to only return messages sent at or after it
+        before: Optional ISO-8601 time with zone to only return messages sent before it
+        limit: Maximum number of messages to return (default 50, at most 1000)
+        cursor: The next_cursor of a previous call, to get older messages
+    
+    Returns:
+        A dictionary with success status, the messages, each with id, sender, its latest content,
+        timestamp, is_from_me, edited, revoked for messages deleted for everyone, reactions and,
+        for messages with a file, media with its type, filename, size and whether the bridge has
+        downloaded it, and next_cursor
+    """
+    params: Dict[str, Any] = {"limit": limit}
+    if after:
+        params["since"] = after
+    if before:
+        params["until"] = before
+    if cursor:
+        params["cursor"] = cursor
+    try:
+        response = bridge_session.get(
+            f"{BRIDGE_BASE_URL}/v1/chats/{chat_jid}/messages",
+            params=params,
+            headers=bridge_headers(),
+            timeout=30.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to get chat history: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def get_message_events(chat_jid: str, message_id: str) -> Dict[str, Any]:
+    """Get what happened to a WhatsApp message after it was sent: its edits, whether it was deleted for everyone, and reactions.
+    
+    Args:
+        chat_jid: The phone number or JID of the chat the message is in
+        message_id: The ID of the message
+    
+    Returns:
+        A dictionary with success status, the message's current content when the bridge has it,
+        and its events, oldest first, each with type (edit, revoke or reaction), sender, content
+        (the new text of an edit, or the emoji of a reaction) and timestamp
+    """
+    try:
+        response = bridge_session.get(
+            f"{BRIDGE_BASE_URL}/v1/messages/{chat_jid}/{message_id}/events",
+            headers=bridge_headers(),
+            timeout=30.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to get message events: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def backfill_history(chat_jid: Optional[str] = None, count: int = 50) -> Dict[str, Any]:
+    """Fetch messages older than the oldest the bridge has from the phone, and store them.
+    
+    The phone has to be online, and takes a while to answer, so this starts a background job.
+    Check on it with get_job; once it succeeds, get_chat_history and list_messages return
+    the older messages.
+    
+    Args:
+        chat_jid: Optional phone number or JID of the chat to backfill. Without it, every
+                 stored chat is backfilled, the latest active first
+        count: How many older messages to fetch per chat (default 50, at most 1000)
+    
+    Returns:
+        A dictionary with success status and the job, with its id and status
+    """
+    body: Dict[str, Any] = {"count": count}
+    if chat_jid:
+        body["chat"] = chat_jid
+    try:
+        response = bridge_session.post(
+            f"{BRIDGE_BASE_URL}/v1/history/backfill",
+            json=body,
+            headers=bridge_headers(),
+            timeout=30.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to start history backfill: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def get_job(job_id: str) -> Dict[str, Any]:
+    """Get a background job of the bridge, like a history backfill, and how far it got.
+    
+    Args:
+        job_id: The id of the job
+    
+    Returns:
+        A dictionary with success status and the job, with its status (queued, running,
+        succeeded, failed or cancelled), progress while running, and its result or error
+        once finished
+    """
+    try:
+        response = bridge_session.get(
+            f"{BRIDGE_BASE_URL}/v1/jobs/{job_id}",
+            headers=bridge_headers(),
+            timeout=30.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to get job: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def list_recent_chats(
+    include_archived: bool = False,
+    chat_type: Optional[str] = None,
+    limit: int = 20,
+    cursor: Optional[str] = None
+) -> Dict[str, Any]:
+    """List your WhatsApp conversations, the latest active first, with each one's last message and unread count.
+    
+    Args:
+        include_archived: Whether to include archived chats (default False)
+        chat_type: Optional type of chat to list: individual, group, broadcast, newsletter or status
+        limit: Maximum number of chats to return (default 20, at most 1000)
+        cursor: The next_cursor of a previous call, to get the next page
+    
+    Returns:
+        A dictionary with success status, the chats, each with jid, name, type, last_message_time,
+        last_message, unread_count, archived, muted and muted_until, and next_cursor
+    """
+    params: Dict[str, Any] = {"limit": limit}
+    if not include_archived:
+        params["archived"] = "false"
+    if chat_type:
+        params["type"] = chat_type
+    if cursor:
+        params["cursor"] = cursor
+    try:
+        response = bridge_session.get(
+            f"{BRIDGE_BASE_URL}/v1/chats",
+            params=params,
+            headers=bridge_headers(),
+            timeout=30.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to list chats: {bridge_exception_message(e)}"
+        }
+
 @mcp.tool()
 def get_chat(chat_jid: str, include_last_message: bool = True) -> Dict[str, Any]:
     """Get WhatsApp chat metadata by JID.
@@ -172,14 +445,23 @@ def get_message_context(
 @mcp.tool()
 def send_message(
     recipient: str,
-    message: str
+    message: str,
+    quoted_message_id: Optional[str] = None,
+    mentions: Optional[List[str]] = None,
+    link_preview: bool = False
 ) -> Dict[str, Any]:
-    """Send a WhatsApp message to a person or group. For group chats use the JID.
+    """Send a WhatsApp message to a person, group or broadcast list. For group chats use the JID.
 
     Args:
         recipient: The recipient - either a phone number with country code but no + or other symbols,
-                 or a JID (e.g., "123456789@s.whatsapp.net" or a group JID like "123456789@g.us")
+                 or a JID (e.g., "123456789@s.whatsapp.net", a group JID like "123456789@g.us" or a
+                 broadcast list JID from list_broadcast_lists like "123456789@broadcast")
         message: The message text to send
+        quoted_message_id: Optional ID of a message in the chat to reply to, quoted above this one
+        mentions: Optional JIDs or phone numbers of group members to mention. Each is tagged as
+                 @number in the text if it isn't already, which recipients see as the member's name
+        link_preview: If True, show the title, description and image of the first link in the
+                     message, fetched by the bridge (default: False)
     
     Returns:
         A dictionary containing success status and a status message
@@ -192,7 +474,7 @@ def send_message(
         }
     
     # Call the whatsapp_send_message function with the unified recipient parameter
-    success, status_message = whatsapp_send_message(recipient, message)
+    success, status_message = whatsapp_send_message(recipient, message, quoted_message_id, mentions, link_preview)
     return {
         "success": success,
         "message": status_message
@@ -219,91 +501,104 @@ def send_file(recipient: str, media_path: str) -> Dict[str, Any]:
     }
 
 @mcp.tool()
-def send_audio_message(recipient: str, media_path: str) -> Dict[str, Any]:
-    """Send any audio file as a WhatsApp audio message to the specified recipient. For group messages use the JID. If it errors due to ffmpeg not being installed, use send_file instead.
+def send_image(recipient: str, path: Optional[str] = None, url: Optional[str] = None, caption: str = "", quoted_message_id: Optional[str] = None, mentions: Optional[List[str]] = None, view_once: bool = False) -> Dict[str, Any]:
+    """Send an image via WhatsApp, shown with its preview like a photo sent from the phone.
+    
+    JPEG, PNG and WebP images up to 16 MB are accepted. Images larger than 2560 pixels
+    are scaled down and sent as JPEG.
     
     Args:
         recipient: The recipient - either a phone number with country code but no + or other symbols,
                  or a JID (e.g., "123456789@s.whatsapp.net" or a group JID like "123456789@g.us")
-        media_path: The absolute path to the audio file to send (will be converted to Opus .ogg if it's not a .ogg file)
+        path: The absolute path to the image on the bridge host
+        url: An http or https URL the bridge downloads the image from, instead of path
+        caption: Optional text shown under the image
+        quoted_message_id: Optional ID of a message in the chat to reply to, quoted above this one
+        mentions: Optional JIDs or phone numbers of group members to mention. Each is tagged as
+                 @number in the caption if it isn't already, which recipients see as the member's name
+        view_once: If True, the recipient can open the image only once (default: False)
     
     Returns:
-        A dictionary containing success status and a status message
+        A dictionary with success status and the WhatsApp message_id
     """
-    success, status_message = whatsapp_audio_voice_message(recipient, media_path)
-    return {
-        "success": success,
-        "message": status_message
-    }
+    payload = {"recipient": recipient, "caption": caption}
+    if path:
+        payload["path"] = path
+    if url:
+        payload["url"] = url
+    if quoted_message_id:
+        payload["quoted_message_id"] = quoted_message_id
+    if mentions:
+        payload["mentions"] = mentions
+    if view_once:
+        payload["view_once"] = True
+    try:
+        response = bridge_session.post(
+            f"{BRIDGE_BASE_URL}/v1/send/image",
+            json=payload,
+            headers=bridge_headers(),
+            timeout=120.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to send image: {bridge_exception_message(e)}"
+        }
 
 @mcp.tool()
-def download_media(message_id: str, chat_jid: str) -> Dict[str, Any]:
-    """Download media from a WhatsApp message and get the local file path.
+def send_contact(recipient: str, contacts: List[Dict[str, str]]) -> Dict[str, Any]:
+    """Send one or more contact cards via WhatsApp, which the recipient can save or message.
     
     Args:
-        message_id: The ID of the message containing the media
-        chat_jid: The JID of the chat containing the message
+        recipient: The recipient - either a phone number with country code but no + or other symbols,
+                 or a JID (e.g., "123456789@s.whatsapp.net" or a group JID like "123456789@g.us")
+        contacts: Up to 50 contacts, each with "name" and "phone" (with country code) and an
+                 optional "organization", e.g. [{"name": "Ana Gómez", "phone": "5491133334444"}]
     
     Returns:
-        A dictionary containing success status, a status message, and the file path if successful
+        A dictionary with success status and the WhatsApp message_id
     """
-    file_path = whatsapp_download_media(message_id, chat_jid)
+    try:
+        response = bridge_session.post(
+            f"{BRIDGE_BASE_URL}/v1/send/contact",
+            json={"recipient": recipient, "contacts": contacts},
+            headers=bridge_headers(),
+            timeout=30.0
+        )
+        response.raise_for_status()
+        return response.json()
     
-    if file_path:
-        return {
-            "success": True,
-            "message": "Media downloaded successfully",
-            "file_path": file_path
-        }
-    else:
+    except requests.exceptions.RequestException as e:
         return {
             "success": False,
-            "message": "Failed to download media"
+            "message": f"Failed to send contacts: {bridge_exception_message(e)}"
         }
 
 @mcp.tool()
-def schedule_message(
-    recipient: str,
-    message: str,
-    scheduled_time: str,
-    check_for_response: bool = True
-) -> Dict[str, Any]:
-    """Schedule a WhatsApp message to be sent in the future.
-    
-    The message will only be sent at the specified time if the condition is met.
-    By default (check_for_response=True), the message will be automatically paused
-    if the recipient sends any message after this scheduled message is created.
+def react_to_message(chat_jid: str, message_id: str, emoji: str, sender: Optional[str] = None) -> Dict[str, Any]:
+    """React to a WhatsApp message with an emoji.
     
     Args:
-        recipient: Phone number with country code (no + or symbols) or JID 
-                  (e.g., "1234567890" or "1234567890@s.whatsapp.net")
-        message: The message text to send
-        scheduled_time: ISO-8601 formatted datetime when to send the message 
-                       (e.g., "2025-10-06T15:30:00Z" or "2025-10-06T15:30:00-03:00")
-        check_for_response: If True, the message will be paused if the recipient 
-                           sends a message after scheduling (default: True)
+        chat_jid: The JID of the chat the message is in (e.g., "123456789@s.whatsapp.net" or "123456789@g.us")
+        message_id: The ID of the message to react to
+        emoji: The reaction, e.g. "👍"; an empty string removes your reaction
+        sender: Who sent the message, only needed if the bridge hasn't stored it
     
     Returns:
-        A dictionary with success status and the scheduled message details
-    
-    Example:
-        schedule_message(
-            recipient="5491156543944",
-            message="Hi! Just checking in",
-            scheduled_time="2025-10-06T15:00:00Z",
-            check_for_response=True
-        )
+        A dictionary with success status
     """
+    payload = {"emoji": emoji}
+    if sender:
+        payload["sender"] = sender
     try:
-        response = requests.post(
-            f"{BRIDGE_BASE_URL}/api/schedule",
-            json={
-                "recipient": recipient,
-                "message": message,
-                "scheduled_time": scheduled_time,
-                "check_for_response": check_for_response
-            },
-            timeout=10.0
+        response = bridge_session.post(
+            f"{BRIDGE_BASE_URL}/v1/messages/{chat_jid}/{message_id}/react",
+            json=payload,
+            headers=bridge_headers(),
+            timeout=30.0
         )
         response.raise_for_status()
         return response.json()
@@ -311,41 +606,35 @@ def schedule_message(
     except requests.exceptions.RequestException as e:
         return {
             "success": False,
-            "message": f"Failed to schedule message: {str(e)}"
+            "message": f"Failed to react to message: {bridge_exception_message(e)}"
         }
 
 @mcp.tool()
-def list_scheduled_messages(
-    status: Optional[str] = None,
-    recipient: Optional[str] = None
-) -> Dict[str, Any]:
-    """List all scheduled messages with optional filters.
+def pin_message(chat_jid: str, message_id: str, duration: str = "7d", unpin: bool = False, sender: Optional[str] = None) -> Dict[str, Any]:
+    """Pin a WhatsApp message at the top of its chat for everyone in it, or unpin it.
     
     Args:
-        status: Filter by status. Options: "pending", "sent", "paused", "cancelled", "failed"
-        recipient: Filter by recipient phone number or JID
+        chat_jid: The JID of the chat the message is in (e.g., "123456789@s.whatsapp.net" or "123456789@g.us")
+        message_id: The ID of the message to pin
+        duration: How long it stays pinned: "24h", "7d" or "30d" (default: "7d")
+        unpin: If True, unpin the message instead (default: False)
+        sender: Who sent the message, only needed if the bridge hasn't stored it
     
     Returns:
-        A dictionary with success status and a list of scheduled messages
-    
-    Example:
-        # Get all pending messages
-        list_scheduled_messages(status="pending")
-        
-        # Get all messages for a specific contact
-        list_scheduled_messages(recipient="5491156543944")
+        A dictionary with success status and, for a pin, when it expires_at
     """
+    payload: Dict[str, Any] = {}
+    if not unpin:
+        payload["duration"] = duration
+    if sender:
+        payload["sender"] = sender
     try:
-        params = {}
-        if status:
-            params["status"] = status
-        if recipient:
-            params["recipient"] = recipient
-        
-        response = requests.get(
-            f"{BRIDGE_BASE_URL}/api/scheduled",
-            params=params,
-            timeout=10.0
+        response = bridge_session.request(
+            "DELETE" if unpin else "POST",
+            f"{BRIDGE_BASE_URL}/v1/messages/{chat_jid}/{message_id}/pin",
+            json=payload,
+            headers=bridge_headers(),
+            timeout=30.0
         )
         response.raise_for_status()
         return response.json()
@@ -353,23 +642,24 @@ def list_scheduled_messages(
     except requests.exceptions.RequestException as e:
         return {
             "success": False,
-            "message": f"Failed to list scheduled messages: {str(e)}",
-            "messages": []
+            "message": f"Failed to pin message: {bridge_exception_message(e)}"
         }
 
 @mcp.tool()
-def get_scheduled_message(message_id: str) -> Dict[str, Any]:
-    """Get details of a specific scheduled message.
+def list_pinned_messages(chat_jid: str) -> Dict[str, Any]:
+    """List the messages pinned in a WhatsApp chat, latest pinned first.
     
     Args:
-        message_id: The ID of the scheduled message
+        chat_jid: The JID of the chat (e.g., "123456789@s.whatsapp.net" or "123456789@g.us")
     
     Returns:
-        A dictionary with the scheduled message details
+        A dictionary with pins, each with the message_id, sender, content and media_type of the
+        message, pinned_by, pinned_at and expires_at
     """
     try:
-        response = requests.get(
-            f"{BRIDGE_BASE_URL}/api/scheduled/{message_id}",
+        response = bridge_session.get(
+            f"{BRIDGE_BASE_URL}/v1/chats/{chat_jid}/pins",
+            headers=bridge_headers(),
             timeout=10.0
         )
         response.raise_for_status()
@@ -378,28 +668,32 @@ def get_scheduled_message(message_id: str) -> Dict[str, Any]:
     except requests.exceptions.RequestException as e:
         return {
             "success": False,
-            "message": f"Failed to get scheduled message: {str(e)}"
+            "message": f"Failed to list pinned messages: {bridge_exception_message(e)}"
         }
 
 @mcp.tool()
-def cancel_scheduled_message(message_id: str) -> Dict[str, Any]:
-    """Cancel a scheduled message before it's sent.
-    
-    This permanently cancels the message. It cannot be resumed after cancellation.
+def star_message(chat_jid: str, message_id: str, unstar: bool = False, sender: Optional[str] = None) -> Dict[str, Any]:
+    """Star a WhatsApp message, to find it again later, or unstar it. Stars show on all your devices.
     
     Args:
-        message_id: The ID of the scheduled message to cancel
+        chat_jid: The JID of the chat the message is in (e.g., "123456789@s.whatsapp.net" or "123456789@g.us")
+        message_id: The ID of the message to star
+        unstar: If True, unstar the message instead (default: False)
+        sender: Who sent the message, only needed if the bridge hasn't stored it
     
     Returns:
-        A dictionary with success status
-    
-    Example:
-        cancel_scheduled_message("abc-123-def-456")
+        A dictionary with success status and whether the message is now starred
     """
+    payload: Dict[str, Any] = {}
+    if sender:
+        payload["sender"] = sender
     try:
-        response = requests.delete(
-            f"{BRIDGE_BASE_URL}/api/scheduled/{message_id}",
-            timeout=10.0
+        response = bridge_session.request(
+            "DELETE" if unstar else "POST",
+            f"{BRIDGE_BASE_URL}/v1/messages/{chat_jid}/{message_id}/star",
+            json=payload,
+            headers=bridge_headers(),
+            timeout=30.0
         )
         response.raise_for_status()
         return response.json()
@@ -407,28 +701,32 @@ def cancel_scheduled_message(message_id: str) -> Dict[str, Any]:
     except requests.exceptions.RequestException as e:
         return {
             "success": False,
-            "message": f"Failed to cancel message: {str(e)}"
+            "message": f"Failed to star message: {bridge_exception_message(e)}"
         }
 
 @mcp.tool()
-def pause_scheduled_message(message_id: str) -> Dict[str, Any]:
-    """Pause a pending scheduled message.
-    
-    A paused message will not be sent at its scheduled time. It can be resumed later.
+def list_starred_messages(chat_jid: Optional[str] = None, limit: int = 100, cursor: Optional[str] = None) -> Dict[str, Any]:
+    """List your starred WhatsApp messages, latest starred first.
     
     Args:
-        message_id: The ID of the scheduled message to pause
+        chat_jid: Optional JID of one chat, to list only its starred messages
+        limit: How many messages to return, up to 1000 (default: 100)
+        cursor: Optional next_cursor of the previous call, to get the next page
     
     Returns:
-        A dictionary with success status
-    
-    Example:
-        pause_scheduled_message("abc-123-def-456")
+        A dictionary with messages, each with its message_id, chat_jid, sender, content, media_type,
+        timestamp, is_from_me and starred_at, and the next_cursor, empty on the last page
     """
+    params: Dict[str, Any] = {"limit": limit}
+    if chat_jid:
+        params["chat"] = chat_jid
+    if cursor:
+        params["cursor"] = cursor
     try:
-        response = requests.patch(
-            f"{BRIDGE_BASE_URL}/api/scheduled/{message_id}",
-            json={"action": "pause"},
+        response = bridge_session.get(
+            f"{BRIDGE_BASE_URL}/v1/messages/starred",
+            params=params,
+            headers=bridge_headers(),
             timeout=10.0
         )
         response.raise_for_status()
@@ -437,30 +735,29 @@ def pause_scheduled_message(message_id: str) -> Dict[str, Any]:
     except requests.exceptions.RequestException as e:
         return {
             "success": False,
-            "message": f"Failed to pause message: {str(e)}"
+            "message": f"Failed to list starred messages: {bridge_exception_message(e)}"
         }
 
 @mcp.tool()
-def resume_scheduled_message(message_id: str) -> Dict[str, Any]:
-    """Resume a paused scheduled message.
+def edit_message(chat_jid: str, message_id: str, message: str) -> Dict[str, Any]:
+    """Edit the text of a WhatsApp message you sent.
     
-    The message will be sent at its originally scheduled time if that time hasn't passed yet.
-    If the scheduled time has passed, it will be sent immediately.
+    WhatsApp only allows edits for 20 minutes after a message is sent, and only of text messages.
     
     Args:
-        message_id: The ID of the scheduled message to resume
+        chat_jid: The JID of the chat the message is in (e.g., "123456789@s.whatsapp.net" or "123456789@g.us")
+        message_id: The ID of the message to edit
+        message: The new text
     
     Returns:
         A dictionary with success status
-    
-    Example:
-        resume_scheduled_message("abc-123-def-456")
     """
     try:
-        response = requests.patch(
-            f"{BRIDGE_BASE_URL}/api/scheduled/{message_id}",
-            json={"action": "resume"},
-            timeout=10.0
+        response = bridge_session.put(
+            f"{BRIDGE_BASE_URL}/v1/messages/{chat_jid}/{message_id}",
+            json={"message": message},
+            headers=bridge_headers(),
+            timeout=30.0
         )
         response.raise_for_status()
         return response.json()
@@ -468,7 +765,1589 @@ def resume_scheduled_message(message_id: str) -> Dict[str, Any]:
     except requests.exceptions.RequestException as e:
         return {
             "success": False,
-            "message": f"Failed to resume message: {str(e)}"
+            "message": f"Failed to edit message: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def get_message_edits(chat_jid: str, message_id: str) -> Dict[str, Any]:
+    """Get the current text of a WhatsApp message and the text it had before each edit.
+    
+    Args:
+        chat_jid: The JID of the chat the message is in
+        message_id: The ID of the message
+    
+    Returns:
+        A dictionary with the current content and the edits, oldest first, each with the
+        previous_content it replaced and edited_at
+    """
+    try:
+        response = bridge_session.get(
+            f"{BRIDGE_BASE_URL}/v1/messages/{chat_jid}/{message_id}/edits",
+            headers=bridge_headers(),
+            timeout=10.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to get message edits: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def get_message_receipts(chat_jid: str, message_id: str) -> Dict[str, Any]:
+    """Get when each recipient of a WhatsApp message you sent received and read it.
+    
+    Args:
+        chat_jid: The JID of the chat the message is in
+        message_id: The ID of the message
+    
+    Returns:
+        A dictionary with the receipts, one per recipient, each with the participant,
+        delivered_at and read_at (null until it happens, or when read receipts are off)
+    """
+    try:
+        response = bridge_session.get(
+            f"{BRIDGE_BASE_URL}/v1/messages/{chat_jid}/{message_id}/receipts",
+            headers=bridge_headers(),
+            timeout=10.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to get message receipts: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def delete_message(chat_jid: str, message_id: str) -> Dict[str, Any]:
+    """Delete a WhatsApp message for everyone in the chat.
+    
+    Works for messages you sent, and for anyone's in groups you administer. To take back a message
+    the scheduler sent, pass the whatsapp_message_id and chat_jid of the scheduled message.
+    
+    Args:
+        chat_jid: The JID of the chat the message is in (e.g., "123456789@s.whatsapp.net" or "123456789@g.us")
+        message_id: The WhatsApp ID of the message to delete
+    
+    Returns:
+        A dictionary with success status
+    """
+    try:
+        response = bridge_session.delete(
+            f"{BRIDGE_BASE_URL}/v1/messages/{chat_jid}/{message_id}",
+            headers=bridge_headers(),
+            timeout=30.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to delete message: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def set_disappearing_messages(chat_jid: str, timer: str) -> Dict[str, Any]:
+    """Turn disappearing messages on or off in a WhatsApp chat.
+    
+    Messages sent afterwards, including scheduled ones, disappear after the same time. In groups,
+    only admins can change the timer unless the group lets everyone edit its settings.
+    
+    Args:
+        chat_jid: The JID of the chat (e.g., "123456789@s.whatsapp.net" or "123456789@g.us")
+        timer: How long messages last: "off", "24h", "7d" or "90d"
+    
+    Returns:
+        A dictionary with success status and the timer in seconds
+    """
+    try:
+        response = bridge_session.put(
+            f"{BRIDGE_BASE_URL}/v1/chats/{chat_jid}/disappearing",
+            json={"timer": timer},
+            headers=bridge_headers(),
+            timeout=30.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to set disappearing messages: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def mute_chat(chat_jid: str, duration: str = "8h", unmute: bool = False) -> Dict[str, Any]:
+    """Mute a noisy WhatsApp chat on all your devices, or unmute it.
+    
+    Muted chats still receive messages, they just don't notify. list_chats and get_chat show
+    whether each chat is muted.
+    
+    Args:
+        chat_jid: The JID of the chat (e.g., "123456789@s.whatsapp.net" or "123456789@g.us")
+        duration: How long it stays muted: "8h", "1w" or "always" (default: "8h")
+        unmute: If True, unmute the chat instead (default: False)
+    
+    Returns:
+        A dictionary with success status, whether the chat is muted and, unless muted always,
+        muted_until
+    """
+    try:
+        if unmute:
+            response = bridge_session.delete(
+                f"{BRIDGE_BASE_URL}/v1/chats/{chat_jid}/mute",
+                headers=bridge_headers(),
+                timeout=30.0
+            )
+        else:
+            response = bridge_session.put(
+                f"{BRIDGE_BASE_URL}/v1/chats/{chat_jid}/mute",
+                json={"duration": duration},
+                headers=bridge_headers(),
+                timeout=30.0
+            )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to mute chat: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def list_groups(limit: int = 100, cursor: Optional[str] = None) -> Dict[str, Any]:
+    """List the WhatsApp groups you are in, by name.
+    
+    Each group has its name, topic, participant_count, whether you are an admin (is_admin)
+    or its creator (is_super_admin), and whether only admins can send messages (announce),
+    edit the group's info (locked) or let people in (join_approval).
+    
+    Args:
+        limit: Maximum number of groups to return (default 100, at most 1000)
+        cursor: The next_cursor of a previous call, to get the next page
+    
+    Returns:
+        A dictionary with success status, the groups and next_cursor, empty on the last page
+    """
+    params: Dict[str, Any] = {"limit": limit}
+    if cursor:
+        params["cursor"] = cursor
+    try:
+        response = bridge_session.get(
+            f"{BRIDGE_BASE_URL}/v1/groups",
+            params=params,
+            headers=bridge_headers(),
+            timeout=30.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to list groups: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def get_group_participants(group_jid: str) -> Dict[str, Any]:
+    """Get the members of a WhatsApp group, admins first.
+    
+    Args:
+        group_jid: The JID of the group (e.g., "123456789@g.us")
+    
+    Returns:
+        A dictionary with success status and the participants, each with jid, phone_number and
+        lid when known, name, is_admin, is_super_admin and, for members the bridge saw join,
+        joined_at
+    """
+    try:
+        response = bridge_session.get(
+            f"{BRIDGE_BASE_URL}/v1/groups/{group_jid}/participants",
+            headers=bridge_headers(),
+            timeout=30.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to get group participants: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def list_group_invites(chat_jid: Optional[str] = None, pending_only: bool = False, limit: int = 100, cursor: Optional[str] = None) -> Dict[str, Any]:
+    """List invites to join WhatsApp groups that you received as messages, latest first.
+    
+    Args:
+        chat_jid: Optional JID of the chat to list invites from; all chats if not given
+        pending_only: If True, only invites you haven't accepted that haven't expired (default: False)
+        limit: Maximum number of invites to return (default 100, at most 1000)
+        cursor: The next_cursor of a previous call, to get the next page
+    
+    Returns:
+        A dictionary with success status, the invites, each with message_id, chat_jid, inviter,
+        group_jid, group_name, caption, expires_at and accepted_at, and next_cursor
+    """
+    params: Dict[str, Any] = {"limit": limit}
+    if chat_jid:
+        params["chat"] = chat_jid
+    if pending_only:
+        params["pending"] = "true"
+    if cursor:
+        params["cursor"] = cursor
+    try:
+        response = bridge_session.get(
+            f"{BRIDGE_BASE_URL}/v1/groups/invites",
+            params=params,
+            headers=bridge_headers(),
+            timeout=30.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to list group invites: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def join_group_from_invite(chat_jid: str, message_id: str) -> Dict[str, Any]:
+    """Join a WhatsApp group with an invite message you received.
+    
+    Args:
+        chat_jid: The JID of the chat the invite was sent in
+        message_id: The ID of the invite message, as listed by list_group_invites
+    
+    Returns:
+        A dictionary with success status and the group_jid and group_name of the group joined
+    """
+    try:
+        response = bridge_session.post(
+            f"{BRIDGE_BASE_URL}/v1/messages/{chat_jid}/{message_id}/join",
+            headers=bridge_headers(),
+            timeout=30.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to join group: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def update_group(group_jid: str, name: Optional[str] = None, topic: Optional[str] = None, photo_path: Optional[str] = None, photo_url: Optional[str] = None, remove_photo: bool = False, announce: Optional[bool] = None, locked: Optional[bool] = None) -> Dict[str, Any]:
+    """Change a WhatsApp group's name, description, photo or who may send and edit it.
+    
+    Only what is given changes. Groups may only let admins edit their info, in which case
+    WhatsApp rejects the change. To send an announcement members can't reply to, set
+    announce to True before sending it and back to False after.
+    
+    Args:
+        group_jid: The JID of the group (e.g., "123456789@g.us")
+        name: Optional new name of the group, up to 100 characters
+        topic: Optional new description of the group; an empty string removes it
+        photo_path: Optional absolute path on the bridge host of a JPEG, PNG or WebP image to set
+                   as the group's photo. It is cropped to a square.
+        photo_url: Optional http or https URL the bridge downloads the photo from, instead of photo_path
+        remove_photo: If True, remove the group's photo (default: False)
+        announce: Optional; True lets only admins send messages, False lets every member
+        locked: Optional; True lets only admins edit the group's info, False lets every member
+    
+    Returns:
+        A dictionary with success status and what was changed
+    """
+    payload: Dict[str, Any] = {}
+    if name is not None:
+        payload["name"] = name
+    if topic is not None:
+        payload["topic"] = topic
+    if photo_path:
+        payload["path"] = photo_path
+    if photo_url:
+        payload["url"] = photo_url
+    if remove_photo:
+        payload["remove_photo"] = True
+    if announce is not None:
+        payload["announce"] = announce
+    if locked is not None:
+        payload["locked"] = locked
+    try:
+        response = bridge_session.patch(
+            f"{BRIDGE_BASE_URL}/v1/groups/{group_jid}",
+            json=payload,
+            headers=bridge_headers(),
+            timeout=120.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to update group: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def list_group_join_requests(group_jid: str) -> Dict[str, Any]:
+    """List the people waiting for an admin to let them into a WhatsApp group.
+    
+    Only groups that need admin approval to join have requests, and only admins can see them.
+    
+    Args:
+        group_jid: The JID of the group (e.g., "123456789@g.us")
+    
+    Returns:
+        A dictionary with success status and the requests, oldest first, each with the requester's
+        jid, name if they are in your contacts, and requested_at
+    """
+    try:
+        response = bridge_session.get(
+            f"{BRIDGE_BASE_URL}/v1/groups/{group_jid}/requests",
+            headers=bridge_headers(),
+            timeout=30.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to list join requests: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def answer_group_join_requests(group_jid: str, participants: List[str], approve: bool = True) -> Dict[str, Any]:
+    """Approve or reject requests to join a WhatsApp group you are an admin of.
+    
+    Args:
+        group_jid: The JID of the group (e.g., "123456789@g.us")
+        participants: Phone numbers or JIDs of the people whose requests to answer, as listed by
+                     list_group_join_requests
+        approve: If True, let them in; if False, reject their requests (default: True)
+    
+    Returns:
+        A dictionary with success status and each participant, with an error code for a request
+        that couldn't be answered
+    """
+    action = "approve" if approve else "reject"
+    try:
+        response = bridge_session.post(
+            f"{BRIDGE_BASE_URL}/v1/groups/{group_jid}/requests/{action}",
+            json={"participants": participants},
+            headers=bridge_headers(),
+            timeout=30.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to {action} join requests: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def send_typing(chat_jid: str, state: str = "composing") -> Dict[str, Any]:
+    """Show yourself typing in a WhatsApp chat, or stop.
+    
+    WhatsApp hides the indicator by itself after about 25 seconds, and when you send a message.
+    
+    Args:
+        chat_jid: The JID of the chat (e.g., "123456789@s.whatsapp.net" or "123456789@g.us")
+        state: "composing" for typing, "recording" for recording a voice note, or "paused" to stop
+              (default: "composing")
+    
+    Returns:
+        A dictionary with success status
+    """
+    try:
+        response = bridge_session.post(
+            f"{BRIDGE_BASE_URL}/v1/chats/{chat_jid}/typing",
+            json={"state": state},
+            headers=bridge_headers(),
+            timeout=30.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to send typing state: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def set_presence(presence: str) -> Dict[str, Any]:
+    """Show yourself online or offline to your WhatsApp contacts.
+    
+    While you are unavailable, online-mode scheduled messages fall back to their scheduled time.
+    
+    Args:
+        presence: "available" or "unavailable"
+    
+    Returns:
+        A dictionary with success status
+    """
+    try:
+        response = bridge_session.put(
+            f"{BRIDGE_BASE_URL}/v1/presence",
+            json={"presence": presence},
+            headers=bridge_headers(),
+            timeout=30.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to set presence: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def mark_as_read(chat_jid: str, message_ids: Optional[List[str]] = None) -> Dict[str, Any]:
+    """Mark messages in a WhatsApp chat as read, sending read receipts to their senders.
+    
+    Use it after replying from automation so the chat doesn't stay unread on the phone.
+    
+    Args:
+        chat_jid: The JID of the chat (e.g., "123456789@s.whatsapp.net" or "123456789@g.us")
+        message_ids: Optional IDs of the messages to mark read. Without them, every unread
+                    message in the chat is marked
+    
+    Returns:
+        A dictionary with success status, how many messages were marked and how many are still unread
+    """
+    try:
+        response = bridge_session.post(
+            f"{BRIDGE_BASE_URL}/v1/chats/{chat_jid}/read",
+            json={"message_ids": message_ids} if message_ids else None,
+            headers=bridge_headers(),
+            timeout=30.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to mark messages as read: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def list_broadcast_lists() -> Dict[str, Any]:
+    """List the WhatsApp broadcast lists the bridge knows and who is on each.
+    
+    The bridge learns a list's recipients when you send to it from your phone, or from
+    set_broadcast_list. Send to a list with send_message or schedule_message and its JID.
+    
+    Returns:
+        A dictionary with the broadcasts, each with its jid, name and recipients
+    """
+    try:
+        response = bridge_session.get(
+            f"{BRIDGE_BASE_URL}/v1/broadcasts",
+            headers=bridge_headers(),
+            timeout=10.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to list broadcast lists: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def set_broadcast_list(jid: str, recipients: List[str]) -> Dict[str, Any]:
+    """Set who is on a WhatsApp broadcast list, for sending to it from the bridge.
+    
+    Use it for a list you haven't sent to from your phone since the bridge was paired, or to
+    make a new list only the bridge uses. The phone's list isn't changed.
+    
+    Args:
+        jid: The broadcast list JID (e.g., "123456789@broadcast")
+        recipients: Phone numbers with country code or JIDs of the people on the list, up to 256
+    
+    Returns:
+        A dictionary with success status and the recipients
+    """
+    try:
+        response = bridge_session.put(
+            f"{BRIDGE_BASE_URL}/v1/broadcasts/{jid}",
+            json={"recipients": recipients},
+            headers=bridge_headers(),
+            timeout=10.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to set broadcast list: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def get_broadcast_deliveries(jid: str, message_id: str) -> Dict[str, Any]:
+    """Get each recipient's copy of a message sent to a WhatsApp broadcast list.
+    
+    Args:
+        jid: The broadcast list JID
+        message_id: The message ID the send returned, or the whatsapp_message_id of a
+                   scheduled message sent to the list
+    
+    Returns:
+        A dictionary with the deliveries, each with the recipient, its own message_id, status
+        ("sent" or "failed" with an error), delivered_at and read_at
+    """
+    try:
+        response = bridge_session.get(
+            f"{BRIDGE_BASE_URL}/v1/broadcasts/{jid}/messages/{message_id}",
+            headers=bridge_headers(),
+            timeout=10.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to get broadcast deliveries: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def list_newsletters() -> Dict[str, Any]:
+    """List the WhatsApp channels (newsletters) the account follows or runs.
+    
+    Owners and admins of a channel post to it with send_message or schedule_message and the
+    channel's JID.
+    
+    Returns:
+        A dictionary with the newsletters, each with its jid, name, description, subscribers,
+        invite_link and the account's role (owner, admin, subscriber or guest)
+    """
+    try:
+        response = bridge_session.get(
+            f"{BRIDGE_BASE_URL}/v1/newsletters",
+            headers=bridge_headers(),
+            timeout=30.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to list channels: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def sync_newsletter(jid: str, count: int = 50, before: Optional[int] = None) -> Dict[str, Any]:
+    """Fetch the posts of a WhatsApp channel into the message store, so list_messages can read them.
+    
+    Args:
+        jid: The channel JID (e.g., "120363144038483540@newsletter")
+        count: How many posts to fetch, up to 100 (default: 50)
+        before: Optional server_id of the oldest post fetched so far, to fetch the posts before it
+    
+    Returns:
+        A dictionary with the newsletter and its posts, newest first, each with its id,
+        server_id, timestamp, content, views and reactions
+    """
+    payload: Dict[str, Any] = {"count": count}
+    if before is not None:
+        payload["before"] = before
+    try:
+        response = bridge_session.post(
+            f"{BRIDGE_BASE_URL}/v1/newsletters/{jid}/sync",
+            json=payload,
+            headers=bridge_headers(),
+            timeout=30.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to sync channel: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def post_status(message: str = "", background_color: Optional[str] = None, font: Optional[str] = None, media_kind: Optional[str] = None, media_path: Optional[str] = None, media_url: Optional[str] = None, audience: Optional[List[str]] = None) -> Dict[str, Any]:
+    """Post a WhatsApp status update, seen by your contacts for 24 hours.
+    
+    To schedule one, use schedule_message with the recipient "status@broadcast".
+    
+    Args:
+        message: The text of a text status, up to 700 characters, or the caption of a photo or video
+        background_color: Optional background of a text status, like "#1E88E5"
+        font: Optional font of a text status: system, system_text, fb_script, system_bold,
+              morningbreeze_regular, calistoga_regular, exo2_extrabold or courierprime_bold
+        media_kind: "image" or "video" to post a photo or video status instead of text
+        media_path: The absolute path to the photo or video on the bridge host
+        media_url: An http or https URL the bridge downloads the photo or video from, instead of media_path
+        audience: Optional phone numbers or JIDs of the only people who may see the status. Who sees
+                 it is set by status privacy on the phone; the post fails if that would show it to
+                 anyone else
+    
+    Returns:
+        A dictionary with success status and the WhatsApp message_id
+    """
+    payload: Dict[str, Any] = {"message": message}
+    if background_color:
+        payload["background_color"] = background_color
+    if font:
+        payload["font"] = font
+    if media_kind:
+        media: Dict[str, str] = {"kind": media_kind}
+        if media_path:
+            media["path"] = media_path
+        if media_url:
+            media["url"] = media_url
+        payload["media"] = media
+    if audience:
+        payload["audience"] = audience
+    try:
+        response = bridge_session.post(
+            f"{BRIDGE_BASE_URL}/v1/status",
+            json=payload,
+            headers=bridge_headers(),
+            timeout=120.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to post status: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def get_status_feed(since: Optional[str] = None, sender: Optional[str] = None, limit: int = 100, cursor: Optional[str] = None) -> Dict[str, Any]:
+    """Get the status updates your contacts posted, newest first, e.g. to summarize what they posted today.
+    
+    Only updates received while the bridge was running are available.
+    
+    Args:
+        since: Optional ISO-8601 time to list updates from (default: the last 24 hours)
+        sender: Optional phone number or JID of one contact, to list only their updates
+        limit: How many updates to return, up to 1000 (default: 100)
+        cursor: Optional next_cursor of the previous call, to get the next page
+    
+    Returns:
+        A dictionary with updates, each with its id, sender, sender_name, content, media_type
+        and timestamp, and the next_cursor, empty on the last page
+    """
+    params: Dict[str, Any] = {"limit": limit}
+    if since:
+        params["since"] = since
+    if sender:
+        params["sender"] = sender
+    if cursor:
+        params["cursor"] = cursor
+    try:
+        response = bridge_session.get(
+            f"{BRIDGE_BASE_URL}/v1/status/feed",
+            params=params,
+            headers=bridge_headers(),
+            timeout=10.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to get status feed: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def check_whatsapp_numbers(phones: List[str]) -> Dict[str, Any]:
+    """Check which phone numbers have a WhatsApp account, before sending or scheduling to them.
+    
+    Args:
+        phones: Up to 500 phone numbers with country code (e.g., "+5491156543944")
+    
+    Returns:
+        A dictionary with the registered count and, for each number in order, on_whatsapp,
+        the jid to send to when registered and the verified_name of a business
+    """
+    try:
+        response = bridge_session.post(
+            f"{BRIDGE_BASE_URL}/v1/contacts/check",
+            json={"phones": phones},
+            headers=bridge_headers(),
+            timeout=60.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to check phone numbers: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def get_business_profile(business_jid: str) -> Dict[str, Any]:
+    """Get the profile of a WhatsApp business account: address, email, categories and opening hours.
+    
+    Args:
+        business_jid: The phone number or JID of the business (e.g., "123456789@s.whatsapp.net")
+    
+    Returns:
+        A dictionary with the business, with its address, email, categories, hours_time_zone and
+        hours, each a day with its mode, open_time and close_time in minutes after midnight
+    """
+    try:
+        response = bridge_session.get(
+            f"{BRIDGE_BASE_URL}/v1/business/{business_jid}",
+            headers=bridge_headers(),
+            timeout=30.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to get business profile: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def get_business_catalog(business_jid: str, limit: int = 50, cursor: Optional[str] = None) -> Dict[str, Any]:
+    """List the products in a WhatsApp business's catalog, to find a product_id to send with send_product.
+    
+    Args:
+        business_jid: The phone number or JID of the business (e.g., "123456789@s.whatsapp.net")
+        limit: How many products to return, up to 100 (default: 50)
+        cursor: Optional next_cursor of the previous call, to get the next page
+    
+    Returns:
+        A dictionary with products, each with its id, name, description, price_amount_1000 (the
+        price times 1000) and currency, retailer_id, url and image_urls, and the next_cursor,
+        empty on the last page
+    """
+    params: Dict[str, Any] = {"limit": limit}
+    if cursor:
+        params["cursor"] = cursor
+    try:
+        response = bridge_session.get(
+            f"{BRIDGE_BASE_URL}/v1/business/{business_jid}/catalog",
+            params=params,
+            headers=bridge_headers(),
+            timeout=30.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to get business catalog: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def send_product(
+    recipient: str,
+    product_id: str,
+    business_jid: Optional[str] = None,
+    body: Optional[str] = None,
+    footer: Optional[str] = None
+) -> Dict[str, Any]:
+    """Send a product from a WhatsApp business catalog as a card with its image, name and price.
+    
+    Args:
+        recipient: The recipient - either a phone number with country code but no + or other symbols,
+                 or a JID (e.g., "123456789@s.whatsapp.net" or a group JID like "123456789@g.us")
+        product_id: The id of the product, as get_business_catalog lists it
+        business_jid: Optional phone number or JID of the business whose catalog has the product
+                     (default: your own account)
+        body: Optional text shown with the card
+        footer: Optional smaller text shown under it
+    
+    Returns:
+        A dictionary with success status and the WhatsApp message_id
+    """
+    payload: Dict[str, Any] = {"recipient": recipient, "product_id": product_id}
+    if business_jid:
+        payload["business"] = business_jid
+    if body:
+        payload["body"] = body
+    if footer:
+        payload["footer"] = footer
+    try:
+        response = bridge_session.post(
+            f"{BRIDGE_BASE_URL}/v1/send/product",
+            json=payload,
+            headers=bridge_headers(),
+            timeout=60.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to send product: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def send_catalog(recipient: str, business_jid: Optional[str] = None, message: Optional[str] = None) -> Dict[str, Any]:
+    """Send a link to a WhatsApp business's whole catalog, which WhatsApp opens as the catalog.
+    
+    Args:
+        recipient: The recipient - either a phone number with country code but no + or other symbols,
+                 or a JID (e.g., "123456789@s.whatsapp.net" or a group JID like "123456789@g.us")
+        business_jid: Optional phone number or JID of the business (default: your own account)
+        message: Optional text sent before the link
+    
+    Returns:
+        A dictionary with success status and the WhatsApp message_id
+    """
+    payload: Dict[str, Any] = {"recipient": recipient, "link_preview": True}
+    if business_jid:
+        payload["business"] = business_jid
+    if message:
+        payload["message"] = message
+    try:
+        response = bridge_session.post(
+            f"{BRIDGE_BASE_URL}/v1/send/catalog",
+            json=payload,
+            headers=bridge_headers(),
+            timeout=30.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to send catalog: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def send_batch(
+    message: str,
+    recipients: List[Dict[str, Any]],
+    delay_seconds: float = 2,
+    link_preview: bool = False,
+    typing: bool = False
+) -> Dict[str, Any]:
+    """Send a WhatsApp message to many recipients, one after another.
+    
+    The message may contain {{name}} placeholders, filled in for each recipient from its
+    variables. Nothing is sent if a recipient lacks a variable the message uses.
+    
+    Args:
+        message: The message text, e.g. "Hi {{name}}, your order is ready"
+        recipients: Up to 500 recipients, each {"recipient": phone number or JID,
+                   "variables": {"name": "Ana"}}; variables are only needed for placeholders
+        delay_seconds: Pause between two sends, at most 300 (default: 2)
+        link_preview: If True, show a preview of the first link in each message (default: False)
+        typing: If True, show yourself typing before each message (default: False)
+    
+    Returns:
+        A dictionary with the sent, failed and skipped counts and each recipient's result,
+        with its message_id when sent or the error when it failed
+    """
+    # The bridge sends one message at a time, so wait for all of them
+    timeout = 30.0 + len(recipients) * (delay_seconds + (10 if typing else 0) + 10)
+    try:
+        response = bridge_session.post(
+            f"{BRIDGE_BASE_URL}/v1/send/batch",
+            json={
+                "message": message,
+                "recipients": recipients,
+                "delay_seconds": delay_seconds,
+                "link_preview": link_preview,
+                "typing": typing
+            },
+            headers=bridge_headers(),
+            timeout=timeout
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to send batch: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def send_poll(recipient: str, question: str, options: List[str], multi_select: bool = False) -> Dict[str, Any]:
+    """Send a poll via WhatsApp. Use get_poll_results with the returned message_id to see the votes.
+    
+    Args:
+        recipient: The recipient - either a phone number with country code but no + or other symbols,
+                 or a JID (e.g., "123456789@s.whatsapp.net" or a group JID like "123456789@g.us")
+        question: The question, up to 255 characters
+        options: 2 to 12 distinct options, up to 100 characters each
+        multi_select: Let voters pick any number of options instead of one (default: False)
+    
+    Returns:
+        A dictionary with success status and the WhatsApp message_id of the poll
+    """
+    try:
+        response = bridge_session.post(
+            f"{BRIDGE_BASE_URL}/v1/send/poll",
+            json={"recipient": recipient, "question": question, "options": options, "multi_select": multi_select},
+            headers=bridge_headers(),
+            timeout=30.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to send poll: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def get_poll_results(message_id: str, chat_jid: Optional[str] = None) -> Dict[str, Any]:
+    """Get the current tally of a poll sent or received by the bridge.
+    
+    Args:
+        message_id: The message ID of the poll
+        chat_jid: The chat the poll is in, only needed if the ID is in several chats
+    
+    Returns:
+        A dictionary with the poll's question and, for each option, its votes and voters
+    """
+    params = {"chat_jid": chat_jid} if chat_jid else None
+    try:
+        response = bridge_session.get(
+            f"{BRIDGE_BASE_URL}/v1/polls/{message_id}/results",
+            params=params,
+            headers=bridge_headers(),
+            timeout=10.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to get poll results: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def send_document(
+    recipient: str,
+    path: Optional[str] = None,
+    url: Optional[str] = None,
+    caption: str = "",
+    filename: Optional[str] = None,
+    mimetype: Optional[str] = None,
+    quoted_message_id: Optional[str] = None,
+    mentions: Optional[List[str]] = None
+) -> Dict[str, Any]:
+    """Send any file via WhatsApp as a document, such as an invoice or a PDF.
+    
+    Files up to 100 MB are accepted.
+    
+    Args:
+        recipient: The recipient - either a phone number with country code but no + or other symbols,
+                 or a JID (e.g., "123456789@s.whatsapp.net" or a group JID like "123456789@g.us")
+        path: The absolute path to the file on the bridge host
+        url: An http or https URL the bridge downloads the file from, instead of path
+        caption: Optional text shown under the document
+        filename: The name the recipient sees, e.g. "Invoice 42.pdf" (default: the file's own name)
+        mimetype: The file's MIME type, e.g. "application/pdf" (default: worked out from the name and content)
+        quoted_message_id: Optional ID of a message in the chat to reply to, quoted above this one
+        mentions: Optional JIDs or phone numbers of group members to mention. Each is tagged as
+                 @number in the caption if it isn't already, which recipients see as the member's name
+    
+    Returns:
+        A dictionary with success status and the WhatsApp message_id
+    """
+    payload = {"recipient": recipient, "caption": caption}
+    for key, value in (("path", path), ("url", url), ("filename", filename), ("mimetype", mimetype),
+                       ("quoted_message_id", quoted_message_id), ("mentions", mentions)):
+        if value:
+            payload[key] = value
+    try:
+        response = bridge_session.post(
+            f"{BRIDGE_BASE_URL}/v1/send/document",
+            json=payload,
+            headers=bridge_headers(),
+            timeout=300.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to send document: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def send_video(recipient: str, path: Optional[str] = None, url: Optional[str] = None, caption: str = "", quoted_message_id: Optional[str] = None, mentions: Optional[List[str]] = None, view_once: bool = False, gif: bool = False) -> Dict[str, Any]:
+    """Send a video via WhatsApp, played inline with its length and preview thumbnail, or an animated GIF.
+    
+    MP4, MOV, 3GP, WebM and AVI videos up to 64 MB are accepted. Anything but MP4 with
+    H.264 video and AAC audio is converted to it, which needs ffmpeg on the bridge host;
+    without it such videos are rejected with the ffmpeg command that converts them.
+    With gif, animated .gif files are accepted too and converted to MP4 the same way.
+    
+    Args:
+        recipient: The recipient - either a phone number with country code but no + or other symbols,
+                 or a JID (e.g., "123456789@s.whatsapp.net" or a group JID like "123456789@g.us")
+        path: The absolute path to the video on the bridge host
+        url: An http or https URL the bridge downloads the video from, instead of path
+        caption: Optional text shown under the video
+        quoted_message_id: Optional ID of a message in the chat to reply to, quoted above this one
+        mentions: Optional JIDs or phone numbers of group members to mention. Each is tagged as
+                 @number in the caption if it isn't already, which recipients see as the member's name
+        view_once: If True, the recipient can open the video only once (default: False)
+        gif: If True, the video plays like a GIF, looping and silent (default: False)
+    
+    Returns:
+        A dictionary with success status and the WhatsApp message_id
+    """
+    payload = {"recipient": recipient, "caption": caption}
+    if path:
+        payload["path"] = path
+    if url:
+        payload["url"] = url
+    if quoted_message_id:
+        payload["quoted_message_id"] = quoted_message_id
+    if mentions:
+        payload["mentions"] = mentions
+    if view_once:
+        payload["view_once"] = True
+    if gif:
+        payload["gif"] = True
+    try:
+        response = bridge_session.post(
+            f"{BRIDGE_BASE_URL}/v1/send/video",
+            json=payload,
+            headers=bridge_headers(),
+            timeout=300.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to send video: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def send_voice_note(recipient: str, path: Optional[str] = None, url: Optional[str] = None, quoted_message_id: Optional[str] = None) -> Dict[str, Any]:
+    """Send audio via WhatsApp as a voice note, with its length and waveform.
+    
+    Unlike send_audio_message, the bridge does the conversion, so ffmpeg is only needed on the
+    bridge host. Ogg, MP3, M4A, AAC, WAV, FLAC, AIFF, AMR and WebM audio up to 16 MB are accepted.
+    
+    Args:
+        recipient: The recipient - either a phone number with country code but no + or other symbols,
+                 or a JID (e.g., "123456789@s.whatsapp.net" or a group JID like "123456789@g.us")
+        path: The absolute path to the audio file on the bridge host
+        url: An http or https URL the bridge downloads the audio from, instead of path
+        quoted_message_id: Optional ID of a message in the chat to reply to, quoted above this one
+    
+    Returns:
+        A dictionary with success status and the WhatsApp message_id
+    """
+    payload = {"recipient": recipient}
+    if path:
+        payload["path"] = path
+    if url:
+        payload["url"] = url
+    if quoted_message_id:
+        payload["quoted_message_id"] = quoted_message_id
+    try:
+        response = bridge_session.post(
+            f"{BRIDGE_BASE_URL}/v1/send/voice",
+            json=payload,
+            headers=bridge_headers(),
+            timeout=300.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to send voice note: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def send_audio_message(recipient: str, media_path: str) -> Dict[str, Any]:
+    """Send any audio file as a WhatsApp audio message to the specified recipient. For group messages use the JID. If it errors due to ffmpeg not being installed, use send_file instead.
+    
+    Args:
+        recipient: The recipient - either a phone number with country code but no + or other symbols,
+                 or a JID (e.g., "123456789@s.whatsapp.net" or a group JID like "123456789@g.us")
+        media_path: The absolute path to the audio file to send (will be converted to Opus .ogg if it's not a .ogg file)
+    
+    Returns:
+        A dictionary containing success status and a status message
+    """
+    success, status_message = whatsapp_audio_voice_message(recipient, media_path)
+    return {
+        "success": success,
+        "message": status_message
+    }
+
+@mcp.tool()
+def download_media(message_id: str, chat_jid: str) -> Dict[str, Any]:
+    """Download media from a WhatsApp message and get the local file path.
+    
+    Args:
+        message_id: The ID of the message containing the media
+        chat_jid: The JID of the chat containing the message
+    
+    Returns:
+        A dictionary containing success status, a status message, and the file path if successful
+    """
+    file_path = whatsapp_download_media(message_id, chat_jid)
+    
+    if file_path:
+        return {
+            "success": True,
+            "message": "Media downloaded successfully",
+            "file_path": file_path
+        }
+    else:
+        return {
+            "success": False,
+            "message": "Failed to download media"
+        }
+
+@mcp.tool()
+def get_login_qr() -> Dict[str, Any]:
+    """Get the QR code that pairs the bridge with a WhatsApp account.
+    
+    Only needed while the bridge isn't logged in yet. Scan the code in WhatsApp
+    under Settings > Linked devices. A new code replaces it about every 20 seconds.
+    
+    Returns:
+        A dictionary with the raw "code", the QR image as "png_base64" and
+        "expires_at", or success False when the bridge is already logged in
+    """
+    try:
+        response = bridge_session.get(
+            f"{BRIDGE_BASE_URL}/v1/auth/qr",
+            headers=bridge_headers(),
+            timeout=10.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to get the login QR code: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def pair_phone(phone: str) -> Dict[str, Any]:
+    """Get a pairing code that links the bridge to a WhatsApp account without a QR code.
+    
+    Only works while the bridge isn't logged in yet. WhatsApp shows a notification
+    on the phone; the code goes under Settings > Linked devices > Link with phone number.
+    
+    Args:
+        phone: The phone number of the account, with country code (e.g., "+5491156543944")
+    
+    Returns:
+        A dictionary with the 8-character "code", or success False with the reason
+    """
+    try:
+        response = bridge_session.post(
+            f"{BRIDGE_BASE_URL}/v1/auth/pair",
+            json={"phone": phone},
+            headers=bridge_headers(),
+            timeout=30.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to get a pairing code: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def schedule_message(
+    recipient: str,
+    message: str,
+    scheduled_time: str,
+    check_for_response: bool = True,
+    delivery_mode: str = "scheduled",
+    online_window_minutes: int = 0,
+    precision: str = "normal",
+    metadata: Optional[Dict[str, Any]] = None,
+    media: Optional[Dict[str, str]] = None,
+    quoted_message_id: Optional[str] = None,
+    mentions: Optional[List[str]] = None,
+    link_preview: bool = False,
+    view_once: bool = False,
+    typing: bool = False,
+    background_color: Optional[str] = None,
+    font: Optional[str] = None,
+    audience: Optional[List[str]] = None
+) -> Dict[str, Any]:
+    """Schedule a WhatsApp message to be sent in the future.
+    
+    The message will only be sent at the specified time if the condition is met.
+    By default (check_for_response=True), the message will be automatically paused
+    if the recipient sends any message after this scheduled message is created.
+    
+    Args:
+        recipient: Phone number with country code (no + or symbols) or JID 
+                  (e.g., "1234567890" or "1234567890@s.whatsapp.net"), or "status@broadcast"
+                  to post a status update
+        message: The message text to send
+        scheduled_time: ISO-8601 formatted datetime when to send the message 
+                       (e.g., "2025-10-06T15:30:00Z" or "2025-10-06T15:30:00-03:00")
+        check_for_response: If True, the message will be paused if the recipient 
+                           sends a message after scheduling. Always off for status
+                           updates (default: True)
+        delivery_mode: "scheduled" sends at scheduled_time. "online" sends as soon as
+                      the recipient is seen online within online_window_minutes before
+                      scheduled_time, falling back to scheduled_time (default: "scheduled")
+        online_window_minutes: How long before scheduled_time an "online" message may
+                              go out early (default: 60 when delivery_mode is "online")
+        precision: "precise" sends within seconds of scheduled_time; "normal" is checked
+                  once per scheduler tick, which is fine for campaigns (default: "normal")
+        metadata: Optional JSON object stored with the message and returned untouched,
+                 e.g. correlation or CRM record IDs
+        media: Optional file to send with message as its caption, e.g.
+              {"kind": "video", "path": "/data/promo.mp4"}. kind is "image", "video", "document" or "voice",
+              with either "path" or "url"; documents also take "filename" and "mimetype".
+              The file is checked now and read again at send time
+        quoted_message_id: Optional ID of a message in the chat to reply to. The bridge must have
+                          the message stored, which is checked now
+        mentions: Optional JIDs or phone numbers of group members to mention. Each is tagged as
+                 @number in the message if it isn't already
+        link_preview: If True, show a preview of the first link in the message, fetched when it
+                     is sent. Not for messages with media (default: False)
+        view_once: If True, an image or video media can be opened only once (default: False)
+        typing: If True, show yourself typing for a few seconds, longer for longer messages,
+               before the message is sent (default: False)
+        background_color: Optional background of a text status update, like "#1E88E5"
+        font: Optional font of a text status update, as for post_status
+        audience: Optional phone numbers or JIDs of the only people who may see a status
+                 update, as for post_status
+    
+    Returns:
+        A dictionary with success status and the scheduled message details
+    
+    Example:
+        schedule_message(
+            recipient="5491156543944",
+            message="Hi! Just checking in",
+            scheduled_time="2025-10-06T15:00:00Z",
+            check_for_response=True
+        )
+    """
+    try:
+        response = bridge_session.post(
+            f"{BRIDGE_BASE_URL}/v1/schedule",
+            json={
+                "recipient": recipient,
+                "message": message,
+                "scheduled_time": scheduled_time,
+                "check_for_response": check_for_response and recipient != "status@broadcast",
+                "delivery_mode": delivery_mode,
+                "online_window_minutes": online_window_minutes,
+                "precision": precision,
+                "metadata": metadata,
+                "media": media,
+                "quoted_message_id": quoted_message_id,
+                "mentions": mentions,
+                "link_preview": link_preview,
+                "view_once": view_once,
+                "typing": typing,
+                "background_color": background_color,
+                "font": font,
+                "audience": audience
+            },
+            headers=bridge_headers({"X-Created-By": MCP_CLIENT_NAME}),
+            timeout=120.0 if media else 10.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to schedule message: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def list_scheduled_messages(
+    status: Optional[str] = None,
+    recipient: Optional[str] = None,
+    created_by: Optional[str] = None,
+    limit: Optional[int] = None,
+    cursor: Optional[str] = None
+) -> Dict[str, Any]:
+    """List scheduled messages with optional filters, latest scheduled time first.
+    
+    Args:
+        status: Filter by status. Options: "pending", "sent", "paused", "cancelled", "failed"
+        recipient: Filter by recipient phone number or JID
+        created_by: Filter by who scheduled the message (e.g. an API key name or "whatsapp-mcp")
+        limit: Page size (default 100, max 1000)
+        cursor: The next_cursor of the previous page, to get the next one
+    
+    Returns:
+        A dictionary with success status, a page of scheduled messages and
+        next_cursor, which is empty on the last page
+    
+    Example:
+        # Get all pending messages
+        list_scheduled_messages(status="pending")
+        
+        # Get all messages for a specific contact
+        list_scheduled_messages(recipient="5491156543944")
+    """
+    try:
+        params = {}
+        if status:
+            params["status"] = status
+        if recipient:
+            params["recipient"] = recipient
+        if created_by:
+            params["created_by"] = created_by
+        if limit:
+            params["limit"] = limit
+        if cursor:
+            params["cursor"] = cursor
+        
+        response = bridge_session.get(
+            f"{BRIDGE_BASE_URL}/v1/scheduled",
+            params=params,
+            headers=bridge_headers(),
+            timeout=10.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to list scheduled messages: {bridge_exception_message(e)}",
+            "messages": []
+        }
+
+@mcp.tool()
+def list_upcoming_messages(hours: int = 24) -> Dict[str, Any]:
+    """List pending scheduled messages that will go out in the next hours.
+    
+    Args:
+        hours: How far ahead to look (default: 24)
+    
+    Returns:
+        A dictionary with success status and the upcoming messages, soonest first
+    """
+    try:
+        response = bridge_session.get(
+            f"{BRIDGE_BASE_URL}/v1/scheduled/upcoming",
+            params={"hours": hours},
+            headers=bridge_headers(),
+            timeout=10.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to list upcoming messages: {bridge_exception_message(e)}",
+            "messages": []
+        }
+
+@mcp.tool()
+def get_scheduled_message(message_id: str) -> Dict[str, Any]:
+    """Get details of a specific scheduled message.
+    
+    Args:
+        message_id: The ID of the scheduled message
+    
+    Returns:
+        A dictionary with the scheduled message details
+    """
+    try:
+        response = bridge_session.get(
+            f"{BRIDGE_BASE_URL}/v1/scheduled/{message_id}",
+            headers=bridge_headers(),
+            timeout=10.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to get scheduled message: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def cancel_scheduled_message(message_id: str) -> Dict[str, Any]:
+    """Cancel a scheduled message before it's sent.
+    
+    This permanently cancels the message. It cannot be resumed after cancellation.
+    
+    Args:
+        message_id: The ID of the scheduled message to cancel
+    
+    Returns:
+        A dictionary with success status
+    
+    Example:
+        cancel_scheduled_message("abc-123-def-456")
+    """
+    try:
+        response = bridge_session.delete(
+            f"{BRIDGE_BASE_URL}/v1/scheduled/{message_id}",
+            headers=bridge_headers(),
+            timeout=10.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to cancel message: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def pause_scheduled_message(message_id: str) -> Dict[str, Any]:
+    """Pause a pending scheduled message.
+    
+    A paused message will not be sent at its scheduled time. It can be resumed later.
+    
+    Args:
+        message_id: The ID of the scheduled message to pause
+    
+    Returns:
+        A dictionary with success status
+    
+    Example:
+        pause_scheduled_message("abc-123-def-456")
+    """
+    try:
+        response = bridge_session.patch(
+            f"{BRIDGE_BASE_URL}/v1/scheduled/{message_id}",
+            json={"action": "pause"},
+            headers=bridge_headers(),
+            timeout=10.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to pause message: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def resume_scheduled_message(message_id: str) -> Dict[str, Any]:
+    """Resume a paused scheduled message.
+    
+    The message will be sent at its originally scheduled time if that time hasn't passed yet.
+    If the scheduled time has passed, it will be sent immediately.
+    
+    Args:
+        message_id: The ID of the scheduled message to resume
+    
+    Returns:
+        A dictionary with success status
+    
+    Example:
+        resume_scheduled_message("abc-123-def-456")
+    """
+    try:
+        response = bridge_session.patch(
+            f"{BRIDGE_BASE_URL}/v1/scheduled/{message_id}",
+            json={"action": "resume"},
+            headers=bridge_headers(),
+            timeout=10.0
+        )
+        response.raise_for_status()
+        return response.json()
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to resume message: {bridge_exception_message(e)}"
+        }
+
+@mcp.tool()
+def batch_scheduled_messages(operations: List[Dict[str, Any]], atomic: bool = True) -> Dict[str, Any]:
+    """Schedule, cancel, pause and resume several messages in one call.
+    
+    Each operation is a dictionary with an "op" key:
+    - {"op": "schedule", ...} takes the same fields as schedule_message
+      (recipient, message, scheduled_time, check_for_response, delivery_mode,
+      online_window_minutes, precision, metadata)
+    - {"op": "cancel", "id": ...}, {"op": "pause", "id": ...} and
+      {"op": "resume", "id": ...} change a scheduled message
+    
+    Operations run in order, so a message can be paused and cancelled in the same
+    batch. At most 100 operations are allowed per batch.
+    
+    Args:
+        operations: The operations to run
+        atomic: If True, nothing is changed unless every operation can be applied;
+               if False, each operation is applied on its own (default: True)
+    
+    Returns:
+        A dictionary with one entry in "results" per operation, in order, each with
+        a status of "ok", "failed", "skipped" or "rolled_back", and the error of
+        the failed ones
+    
+    Example:
+        batch_scheduled_messages([
+            {"op": "cancel", "id": "abc-123-def-456"},
+            {"op": "schedule", "recipient": "5491156543944", "message": "Moved to Friday",
+             "scheduled_time": "2025-10-10T15:00:00Z"}
+        ])
+    """
+    try:
+        response = bridge_session.post(
+            f"{BRIDGE_BASE_URL}/v1/batch",
+            json={"operations": operations, "atomic": atomic},
+            headers=bridge_headers({"X-Created-By": MCP_CLIENT_NAME}),
+            timeout=30.0
+        )
+        # A failed atomic batch still says which operation failed
+        body = response.json() if response.headers.get("Content-Type", "").startswith("application/json") else {}
+        if not response.ok and "results" in body:
+            return body
+        response.raise_for_status()
+        return body
+    
+    except requests.exceptions.RequestException as e:
+        return {
+            "success": False,
+            "message": f"Failed to run batch: {bridge_exception_message(e)}"
         }
 
 if __name__ == "__main__":
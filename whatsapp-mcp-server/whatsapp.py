@@ -4,13 +4,104 @@ from dataclasses import dataclass, asdict
 from typing import Optional, List, Tuple
 import os
 import os.path
+import socket
 import requests
+from requests.adapters import HTTPAdapter
+from urllib3.connection import HTTPConnection
+from urllib3.connectionpool import HTTPConnectionPool
 import json
 import audio
 
 # Configuration from environment variables or defaults
 BRIDGE_BASE_URL = os.environ.get('WHATSAPP_BRIDGE_URL', 'http://localhost:8080')
-WHATSAPP_API_BASE_URL = f"{BRIDGE_BASE_URL}/api"
+WHATSAPP_API_BASE_URL = f"{BRIDGE_BASE_URL}/v1"
+# API key for the bridge, one of the keys in its BRIDGE_API_KEYS
+BRIDGE_API_KEY = os.environ.get('BRIDGE_API_KEY', '')
+# Unix socket of the bridge (its BRIDGE_SOCKET). When set, API requests go over
+# it and only the path of WHATSAPP_BRIDGE_URL matters.
+BRIDGE_SOCKET = os.environ.get('WHATSAPP_BRIDGE_SOCKET', '')
+
+
+class _UnixConnection(HTTPConnection):
+    """An HTTP connection to a unix socket."""
+
+    def __init__(self, socket_path: str, **kwargs):
+        super().__init__("localhost", **kwargs)
+        self.socket_path = socket_path
+
+    def connect(self):
+        sock = socket.socket(socket.AF_UNIX, socket.SOCK_STREAM)
+        sock.settimeout(self.timeout if isinstance(self.timeout, (int, float)) else None)
+        sock.connect(self.socket_path)
+        self.sock = sock
+
+
+class _UnixConnectionPool(HTTPConnectionPool):
+    """A connection pool whose connections go to a unix socket."""
+
+    def __init__(self, socket_path: str, **kwargs):
+        super().__init__("localhost", **kwargs)
+        self.socket_path = socket_path
+
+    def _new_conn(self):
+        return _UnixConnection(self.socket_path, timeout=self.timeout.connect_timeout)
+
+
+class UnixSocketAdapter(HTTPAdapter):
+    """Sends every request to the bridge's unix socket, whatever the URL's host."""
+
+    def __init__(self, socket_path: str, **kwargs):
+        super().__init__(**kwargs)
+        self.socket_path = socket_path
+        self.pool = _UnixConnectionPool(socket_path)
+
+    def get_connection_with_tls_context(self, request, verify, proxies=None, cert=None):
+        return self.pool
+
+    def get_connection(self, url, proxies=None):
+        return self.pool
+
+    def close(self):
+        super().close()
+        self.pool.close()
+
+
+# Session for all bridge API requests, over the unix socket when one is configured
+bridge_session = requests.Session()
+if BRIDGE_SOCKET:
+    bridge_session.mount("http://", UnixSocketAdapter(BRIDGE_SOCKET))
+    bridge_session.mount("https://", UnixSocketAdapter(BRIDGE_SOCKET))
+
+
+def bridge_headers(extra: Optional[dict] = None) -> dict:
+    """Return the headers for a bridge API request, including the API key if set."""
+    headers = {}
+    if BRIDGE_API_KEY:
+        headers["Authorization"] = f"Bearer {BRIDGE_API_KEY}"
+    if extra:
+        headers.update(extra)
+    return headers
+
+
+def bridge_error(response: requests.Response) -> str:
+    """Describe a failed bridge response, using its error envelope when present."""
+    try:
+        error = response.json().get("error") or {}
+        if error.get("code"):
+            message = f"Error: HTTP {response.status_code} {error['code']} - {error.get('message', '')}"
+            if error.get("request_id"):
+                message += f" (request {error['request_id']})"
+            return message
+    except (ValueError, AttributeError):
+        pass
+    return f"Error: HTTP {response.status_code} - {response.text}"
+
+
+def bridge_exception_message(e: requests.RequestException) -> str:
+    """Describe a failed bridge request, including the error envelope of HTTP errors."""
+    if e.response is not None:
+        return bridge_error(e.response)
+    return str(e)
 
 # Database path - use environment variable or default relative path
 MESSAGES_DB_PATH = os.environ.get(
@@ -37,6 +128,9 @@ class Chat:
     last_message: Optional[str] = None
     last_sender: Optional[str] = None
     last_is_from_me: Optional[bool] = None
+    muted: bool = False
+    # None while muted means muted until unmuted
+    muted_until: Optional[datetime] = None
 
     @property
     def is_group(self) -> bool:
@@ -66,6 +160,17 @@ def dataclass_to_dict(obj):
             result[key] = value.isoformat()
     return result
 
+def chat_mute(muted: Optional[bool], muted_until: Optional[str]) -> Tuple[bool, Optional[datetime]]:
+    """Whether a chat is muted now and until when, from its row in chat_mutes."""
+    if not muted:
+        return False, None
+    if not muted_until:
+        return True, None
+    until = datetime.fromisoformat(muted_until)
+    if until <= datetime.now(until.tzinfo):
+        return False, None
+    return True, until
+
 def get_sender_name(sender_jid: str) -> str:
     try:
         conn = sqlite3.connect(MESSAGES_DB_PATH)
@@ -366,7 +471,9 @@ def list_chats(
                 chats.last_message_time,
                 messages.content as last_message,
                 messages.sender as last_sender,
-                messages.is_from_me as last_is_from_me
+                messages.is_from_me as last_is_from_me,
+                chat_mutes.muted,
+                chat_mutes.muted_until
             FROM chats
         """]
         
@@ -375,6 +482,7 @@ def list_chats(
                 LEFT JOIN messages ON chats.jid = messages.chat_jid 
                 AND chats.last_message_time = messages.timestamp
             """)
+        query_parts.append("LEFT JOIN chat_mutes ON chats.jid = chat_mutes.chat_jid")
             
         where_clauses = []
         params = []
@@ -400,13 +508,16 @@ def list_chats(
         
         result = []
         for chat_data in chats:
+            muted, muted_until = chat_mute(chat_data[6], chat_data[7])
             chat = Chat(
                 jid=chat_data[0],
                 name=chat_data[1],
                 last_message_time=datetime.fromisoformat(chat_data[2]) if chat_data[2] else None,
                 last_message=chat_data[3],
                 last_sender=chat_data[4],
-                last_is_from_me=chat_data[5]
+                last_is_from_me=chat_data[5],
+                muted=muted,
+                muted_until=muted_until
             )
             result.append(dataclass_to_dict(chat))
             
@@ -421,7 +532,34 @@ def list_chats(
 
 
 def search_contacts(query: str) -> List[Contact]:
-    """Search contacts by name or phone number."""
+    """Search contacts by name or phone number, best matches first.
+    
+    Uses the bridge's fuzzy contact search, which knows saved, push and business
+    names and tolerates typos, and falls back to chat names when the bridge
+    can't be reached.
+    """
+    try:
+        response = bridge_session.get(
+            f"{WHATSAPP_API_BASE_URL}/contacts/search",
+            params={"q": query, "limit": 50},
+            headers=bridge_headers(),
+            timeout=30.0
+        )
+        response.raise_for_status()
+        return [
+            dataclass_to_dict(Contact(
+                phone_number=match.get("phone_number") or match["jid"].split('@')[0],
+                name=match.get("name"),
+                jid=match["jid"]
+            ))
+            for match in response.json().get("contacts", [])
+        ]
+    except requests.RequestException as e:
+        print(f"Contact search failed, searching chat names: {bridge_exception_message(e)}")
+        return search_chat_contacts(query)
+
+def search_chat_contacts(query: str) -> List[Contact]:
+    """Search the names and JIDs of individual chats."""
     try:
         conn = sqlite3.connect(MESSAGES_DB_PATH)
         cursor = conn.cursor()
@@ -481,9 +619,12 @@ def get_contact_chats(jid: str, limit: int = 20, page: int = 0) -> List[Chat]:
                 c.last_message_time,
                 m.content as last_message,
                 m.sender as last_sender,
-                m.is_from_me as last_is_from_me
+                m.is_from_me as last_is_from_me,
+                mu.muted,
+                mu.muted_until
             FROM chats c
             JOIN messages m ON c.jid = m.chat_jid
+            LEFT JOIN chat_mutes mu ON c.jid = mu.chat_jid
             WHERE m.sender = ? OR c.jid = ?
             ORDER BY c.last_message_time DESC
             LIMIT ? OFFSET ?
@@ -493,13 +634,16 @@ def get_contact_chats(jid: str, limit: int = 20, page: int = 0) -> List[Chat]:
         
         result = []
         for chat_data in chats:
+            muted, muted_until = chat_mute(chat_data[6], chat_data[7])
             chat = Chat(
                 jid=chat_data[0],
                 name=chat_data[1],
                 last_message_time=datetime.fromisoformat(chat_data[2]) if chat_data[2] else None,
                 last_message=chat_data[3],
                 last_sender=chat_data[4],
-                last_is_from_me=chat_data[5]
+                last_is_from_me=chat_data[5],
+                muted=muted,
+                muted_until=muted_until
             )
             result.append(dataclass_to_dict(chat))
             
@@ -575,7 +719,9 @@ def get_chat(chat_jid: str, include_last_message: bool = True) -> Optional[Chat]
                 c.last_message_time,
                 m.content as last_message,
                 m.sender as last_sender,
-                m.is_from_me as last_is_from_me
+                m.is_from_me as last_is_from_me,
+                mu.muted,
+                mu.muted_until
             FROM chats c
         """
         
@@ -584,6 +730,7 @@ def get_chat(chat_jid: str, include_last_message: bool = True) -> Optional[Chat]
                 LEFT JOIN messages m ON c.jid = m.chat_jid 
                 AND c.last_message_time = m.timestamp
             """
+        query += " LEFT JOIN chat_mutes mu ON c.jid = mu.chat_jid"
             
         query += " WHERE c.jid = ?"
         
@@ -593,13 +740,16 @@ def get_chat(chat_jid: str, include_last_message: bool = True) -> Optional[Chat]
         if not chat_data:
             return None
             
+        muted, muted_until = chat_mute(chat_data[6], chat_data[7])
         return Chat(
             jid=chat_data[0],
             name=chat_data[1],
             last_message_time=datetime.fromisoformat(chat_data[2]) if chat_data[2] else None,
             last_message=chat_data[3],
             last_sender=chat_data[4],
-            last_is_from_me=chat_data[5]
+            last_is_from_me=chat_data[5],
+            muted=muted,
+            muted_until=muted_until
         )
         
     except sqlite3.Error as e:
@@ -623,10 +773,13 @@ def get_direct_chat_by_contact(sender_phone_number: str) -> Optional[Chat]:
                 c.last_message_time,
                 m.content as last_message,
                 m.sender as last_sender,
-                m.is_from_me as last_is_from_me
+                m.is_from_me as last_is_from_me,
+                mu.muted,
+                mu.muted_until
             FROM chats c
             LEFT JOIN messages m ON c.jid = m.chat_jid 
                 AND c.last_message_time = m.timestamp
+            LEFT JOIN chat_mutes mu ON c.jid = mu.chat_jid
             WHERE c.jid LIKE ? AND c.jid NOT LIKE '%@g.us'
             LIMIT 1
         """, (f"%{sender_phone_number}%",))
@@ -636,13 +789,16 @@ def get_direct_chat_by_contact(sender_phone_number: str) -> Optional[Chat]:
         if not chat_data:
             return None
             
+        muted, muted_until = chat_mute(chat_data[6], chat_data[7])
         return Chat(
             jid=chat_data[0],
             name=chat_data[1],
             last_message_time=datetime.fromisoformat(chat_data[2]) if chat_data[2] else None,
             last_message=chat_data[3],
             last_sender=chat_data[4],
-            last_is_from_me=chat_data[5]
+            last_is_from_me=chat_data[5],
+            muted=muted,
+            muted_until=muted_until
         )
         
     except sqlite3.Error as e:
@@ -652,7 +808,7 @@ def get_direct_chat_by_contact(sender_phone_number: str) -> Optional[Chat]:
         if 'conn' in locals():
             conn.close()
 
-def send_message(recipient: str, message: str) -> Tuple[bool, str]:
+def send_message(recipient: str, message: str, quoted_message_id: Optional[str] = None, mentions: Optional[List[str]] = None, link_preview: bool = False) -> Tuple[bool, str]:
     try:
         # Validate input
         if not recipient:
@@ -663,15 +819,21 @@ def send_message(recipient: str, message: str) -> Tuple[bool, str]:
             "recipient": recipient,
             "message": message,
         }
+        if quoted_message_id:
+            payload["quoted_message_id"] = quoted_message_id
+        if mentions:
+            payload["mentions"] = mentions
+        if link_preview:
+            payload["link_preview"] = True
         
-        response = requests.post(url, json=payload)
+        response = bridge_session.post(url, json=payload, headers=bridge_headers())
         
         # Check if the request was successful
         if response.status_code == 200:
             result = response.json()
             return result.get("success", False), result.get("message", "Unknown response")
         else:
-            return False, f"Error: HTTP {response.status_code} - {response.text}"
+            return False, bridge_error(response)
             
     except requests.RequestException as e:
         return False, f"Request error: {str(e)}"
@@ -698,14 +860,14 @@ def send_file(recipient: str, media_path: str) -> Tuple[bool, str]:
             "media_path": media_path
         }
         
-        response = requests.post(url, json=payload)
+        response = bridge_session.post(url, json=payload, headers=bridge_headers())
         
         # Check if the request was successful
         if response.status_code == 200:
             result = response.json()
             return result.get("success", False), result.get("message", "Unknown response")
         else:
-            return False, f"Error: HTTP {response.status_code} - {response.text}"
+            return False, bridge_error(response)
             
     except requests.RequestException as e:
         return False, f"Request error: {str(e)}"
@@ -738,14 +900,14 @@ def send_audio_message(recipient: str, media_path: str) -> Tuple[bool, str]:
             "media_path": media_path
         }
         
-        response = requests.post(url, json=payload)
+        response = bridge_session.post(url, json=payload, headers=bridge_headers())
         
         # Check if the request was successful
         if response.status_code == 200:
             result = response.json()
             return result.get("success", False), result.get("message", "Unknown response")
         else:
-            return False, f"Error: HTTP {response.status_code} - {response.text}"
+            return False, bridge_error(response)
             
     except requests.RequestException as e:
         return False, f"Request error: {str(e)}"
@@ -771,7 +933,7 @@ def download_media(message_id: str, chat_jid: str) -> Optional[str]:
             "chat_jid": chat_jid
         }
         
-        response = requests.post(url, json=payload)
+        response = bridge_session.post(url, json=payload, headers=bridge_headers())
         
         if response.status_code == 200:
             result = response.json()
@@ -783,7 +945,7 @@ def download_media(message_id: str, chat_jid: str) -> Optional[str]:
                 print(f"Download failed: {result.get('message', 'Unknown error')}")
                 return None
         else:
-            print(f"Error: HTTP {response.status_code} - {response.text}")
+            print(bridge_error(response))
             return None
             
     except requests.RequestException as e: